@@ -0,0 +1,360 @@
+// Package bai writes bam index (.bai) files for coordinate sorted bam files and finds
+// the index of a bam for reading.
+package bai
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/memo"
+	"github.com/dasnellings/duplexTools/pipe"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// metaBin is the pseudo-bin storing the offsets and number of reads for each reference.
+const metaBin = 37450
+
+// linearShift is the log2 size of the windows in the linear index.
+const linearShift = 14
+
+// Bin returns the smallest bin fully containing [beg, end) (0-based, half-open) as defined in the SAM specification.
+// The bin should be set when writing a bam record so that other tools can use the index.
+func Bin(beg, end int) uint16 {
+	end--
+	switch {
+	case beg>>14 == end>>14:
+		return uint16(((1<<15)-1)/7 + (beg >> 14))
+	case beg>>17 == end>>17:
+		return uint16(((1<<12)-1)/7 + (beg >> 17))
+	case beg>>20 == end>>20:
+		return uint16(((1<<9)-1)/7 + (beg >> 20))
+	case beg>>23 == end>>23:
+		return uint16(((1<<6)-1)/7 + (beg >> 23))
+	case beg>>26 == end>>26:
+		return uint16(((1<<3)-1)/7 + (beg >> 26))
+	}
+	return 0
+}
+
+// ReadBin returns the bin of an aligned read for use with sam.WriteToBamFileHandle.
+func ReadBin(r sam.Sam) uint16 {
+	if r.RName == "*" {
+		return 4680 // bin of unplaced reads, reg2bin(-1, 0)
+	}
+	beg, end := r.GetChromStart(), r.GetChromEnd()
+	if end <= beg {
+		end = beg + 1
+	}
+	return Bin(beg, end)
+}
+
+// chunk is a range of virtual file offsets.
+type chunk struct {
+	beg uint64
+	end uint64
+}
+
+// refIndex is the index being built for a single reference.
+type refIndex struct {
+	bins      map[uint32][]chunk
+	linear    []uint64
+	offBeg    uint64
+	offEnd    uint64
+	mapped    uint64
+	unmapped  uint64
+	hasReads  bool
+	lastStart int
+}
+
+// Read reads the index of bamFile, either bamFile.bai or the .bai with the .bam suffix
+// replaced. It exits with exit.MissingIndex if neither exists.
+//
+// Each index is parsed once per process and shared by every caller, so the workers of
+// a command running many threads do not each spend seconds parsing a whole genome
+// index and hold a copy of it. A sam.Bai is only read when seeking, so sharing it is
+// safe. The index is parsed again if the file has changed since it was read.
+func Read(bamFile string) sam.Bai {
+	pipe.RequireIndexable(bamFile, "bam")
+	for _, idx := range []string{bamFile + ".bai", strings.TrimSuffix(bamFile, ".bam") + ".bai"} {
+		if info, err := os.Stat(idx); err == nil {
+			return indexes.Get(idx, info, sam.ReadBai)
+		}
+	}
+	exit.Fatalf(exit.MissingIndex, "could not find %s.bai. Index the bam with samtools index.", bamFile)
+	return sam.Bai{}
+}
+
+// indexes holds the indexes parsed by Read.
+var indexes memo.Files[sam.Bai]
+
+// WriteIndex reads a coordinate sorted bam file and writes its index to bamFile + ".bai".
+func WriteIndex(bamFile string) {
+	r := newBlockReader(bamFile)
+	defer cleanup(r)
+
+	refs := make([]refIndex, readHeader(r))
+	var noCoord uint64
+	var beg, end uint64
+	var refId, prevRefId int32 = 0, -1
+	var pos, refEnd int
+	var flag uint16
+	var bin uint32
+	var ref *refIndex
+	var record []byte
+	var ok bool
+	for {
+		beg, ok = r.offset()
+		if !ok {
+			break
+		}
+		record = r.next(int(le.Uint32(r.next(4))))
+		end = r.endOffset()
+
+		refId = int32(le.Uint32(record[0:4]))
+		if refId == -1 {
+			noCoord++
+			prevRefId = -1
+			continue
+		}
+		if refId < prevRefId || int(refId) >= len(refs) {
+			exit.Fatalf(exit.Unsorted, "%s is not sorted by coordinate and cannot be indexed.", bamFile)
+		}
+		prevRefId = refId
+		ref = &refs[refId]
+		pos = int(int32(le.Uint32(record[4:8])))
+		flag = le.Uint16(record[14:16])
+		if ref.hasReads && pos < ref.lastStart {
+			exit.Fatalf(exit.Unsorted, "%s is not sorted by coordinate and cannot be indexed.", bamFile)
+		}
+		refEnd = pos + refLength(record)
+		if refEnd <= pos {
+			refEnd = pos + 1
+		}
+
+		if !ref.hasReads {
+			ref.bins = make(map[uint32][]chunk)
+			ref.offBeg = beg
+			ref.hasReads = true
+		}
+		ref.lastStart = pos
+		ref.offEnd = end
+		if flag&0x4 != 0 {
+			ref.unmapped++
+		} else {
+			ref.mapped++
+		}
+
+		bin = uint32(Bin(pos, refEnd))
+		if c := ref.bins[bin]; len(c) > 0 && c[len(c)-1].end == beg {
+			c[len(c)-1].end = end
+		} else {
+			ref.bins[bin] = append(c, chunk{beg: beg, end: end})
+		}
+
+		for w := pos >> linearShift; w <= (refEnd-1)>>linearShift; w++ {
+			for len(ref.linear) <= w {
+				ref.linear = append(ref.linear, 0)
+			}
+			if ref.linear[w] == 0 {
+				ref.linear[w] = beg
+			}
+		}
+	}
+
+	out := fileio.EasyCreate(bamFile + ".bai")
+	defer cleanup(out)
+	write(out, refs, noCoord)
+}
+
+// write writes the index in the binary bai format.
+func write(out io.Writer, refs []refIndex, noCoord uint64) {
+	w := bufio.NewWriter(out)
+	_, err := w.WriteString("BAI\x01")
+	exception.PanicOnErr(err)
+	writeLe(w, int32(len(refs)))
+	var ids []uint32
+	for _, ref := range refs {
+		if !ref.hasReads {
+			writeLe(w, int32(0))
+			writeLe(w, int32(0))
+			continue
+		}
+		ids = ids[:0]
+		for id := range ref.bins {
+			ids = append(ids, id)
+		}
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		writeLe(w, int32(len(ids)+1))
+		for _, id := range ids {
+			writeLe(w, id)
+			writeLe(w, int32(len(ref.bins[id])))
+			for _, c := range ref.bins[id] {
+				writeLe(w, c.beg)
+				writeLe(w, c.end)
+			}
+		}
+		// metaBin is written last as gonomics expects it at the end of the bin list
+		writeLe(w, uint32(metaBin))
+		writeLe(w, int32(2))
+		for _, v := range []uint64{ref.offBeg, ref.offEnd, ref.mapped, ref.unmapped} {
+			writeLe(w, v)
+		}
+
+		for i := 1; i < len(ref.linear); i++ { // windows without reads start at the next read
+			if ref.linear[i] == 0 {
+				ref.linear[i] = ref.linear[i-1]
+			}
+		}
+		writeLe(w, int32(len(ref.linear)))
+		for _, v := range ref.linear {
+			writeLe(w, v)
+		}
+	}
+	writeLe(w, noCoord)
+	exception.PanicOnErr(w.Flush())
+}
+
+// refLength returns the number of reference bases covered by the cigar of a bam record.
+func refLength(record []byte) int {
+	nameLen := int(record[8])
+	nCigar := int(le.Uint16(record[12:14]))
+	var ans int
+	var op uint32
+	for i := 0; i < nCigar; i++ {
+		op = le.Uint32(record[32+nameLen+4*i:])
+		switch op & 0xF {
+		case 0, 2, 3, 7, 8: // M, D, N, =, X
+			ans += int(op >> 4)
+		}
+	}
+	return ans
+}
+
+// readHeader reads past the bam header and returns the number of reference sequences.
+func readHeader(r *blockReader) int {
+	if string(r.next(4)) != "BAM\x01" {
+		log.Fatalf("ERROR: %s is not a bam file.", r.file.Name())
+	}
+	r.next(int(le.Uint32(r.next(4))))
+	nRef := int(le.Uint32(r.next(4)))
+	for i := 0; i < nRef; i++ {
+		r.next(int(le.Uint32(r.next(4))) + 4)
+	}
+	return nRef
+}
+
+var le = binary.LittleEndian
+
+func writeLe(w io.Writer, data any) {
+	err := binary.Write(w, le, data)
+	exception.PanicOnErr(err)
+}
+
+// blockReader decompresses a bgzf file one block at a time while tracking virtual file offsets.
+type blockReader struct {
+	file      *os.File
+	r         *bufio.Reader
+	coffset   uint64 // file offset of the current block
+	nextBlock uint64 // file offset of the next block
+	block     []byte // uncompressed data of the current block
+	pos       int    // position in block
+	header    [18]byte
+	buf       bytes.Buffer
+	out       []byte
+}
+
+func newBlockReader(filename string) *blockReader {
+	file, err := os.Open(filename)
+	exception.PanicOnErr(err)
+	return &blockReader{file: file, r: bufio.NewReaderSize(file, 1<<20)}
+}
+
+// readBlock reads the next non-empty block. Returns false at the end of the file.
+func (r *blockReader) readBlock() bool {
+	for {
+		r.coffset = r.nextBlock
+		_, err := io.ReadFull(r.r, r.header[:])
+		if err == io.EOF {
+			return false
+		}
+		exception.PanicOnErr(err)
+		if r.header[0] != 31 || r.header[1] != 139 || r.header[12] != 'B' || r.header[13] != 'C' {
+			log.Fatalf("ERROR: %s is not bgzf compressed.", r.file.Name())
+		}
+		size := int(le.Uint16(r.header[16:18])) + 1
+		r.nextBlock += uint64(size)
+		r.buf.Reset()
+		_, err = io.CopyN(&r.buf, r.r, int64(size-len(r.header)))
+		exception.PanicOnErr(err)
+		compressed := r.buf.Bytes()
+		uncompressedSize := int(le.Uint32(compressed[len(compressed)-4:]))
+		r.block = r.block[:0]
+		if uncompressedSize > 0 {
+			r.block, err = io.ReadAll(flate.NewReader(bytes.NewReader(compressed[:len(compressed)-8])))
+			exception.PanicOnErr(err)
+		}
+		r.pos = 0
+		if len(r.block) > 0 {
+			return true
+		}
+	}
+}
+
+// offset returns the virtual offset of the next byte. Returns false at the end of the file.
+func (r *blockReader) offset() (uint64, bool) {
+	if r.pos == len(r.block) && !r.readBlock() {
+		return 0, false
+	}
+	return r.coffset<<16 | uint64(r.pos), true
+}
+
+// endOffset returns the virtual offset just past the last byte read. At the end of a block this
+// points to the start of the following block, matching samtools.
+func (r *blockReader) endOffset() uint64 {
+	if r.pos == len(r.block) {
+		return r.nextBlock << 16
+	}
+	return r.coffset<<16 | uint64(r.pos)
+}
+
+// next returns the next n bytes. The returned slice is only valid until the next call.
+func (r *blockReader) next(n int) []byte {
+	r.out = r.out[:0]
+	var k int
+	for n > 0 {
+		if r.pos == len(r.block) && !r.readBlock() {
+			log.Fatalf("ERROR: unexpected end of file in %s.", r.file.Name())
+		}
+		k = min(n, len(r.block)-r.pos)
+		r.out = append(r.out, r.block[r.pos:r.pos+k]...)
+		r.pos += k
+		n -= k
+	}
+	return r.out
+}
+
+func (r *blockReader) Close() error {
+	return r.file.Close()
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,52 @@
+package bai_test
+
+import (
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func writeBam(t *testing.T, filename string, chroms ...string) {
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 1000}, {Name: "chr2", Size: 1000, Order: 1}}, nil, sam.Coordinate, sam.None)
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bam.NewWriter(file, header)
+	for _, chrom := range chroms {
+		w.Write(sam.Sam{QName: "r", MapQ: 60, RName: chrom, Pos: 10, Cigar: []cigar.Cigar{{RunLength: 4, Op: 'M'}},
+			RNext: "*", Seq: dna.StringToBases("ACGT"), Qual: "IIII"})
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bai.WriteIndex(filename)
+}
+
+func TestReadShared(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.bam")
+	writeBam(t, filename, "chr1")
+	first := bai.Read(filename)
+	if again := bai.Read(filename); !reflect.DeepEqual(first, again) {
+		t.Error("second read of an unchanged index differs from the first")
+	}
+
+	writeBam(t, filename, "chr1", "chr2")
+	changed := bai.Read(filename)
+	if reflect.DeepEqual(first, changed) {
+		t.Error("index was not read again after the bam was indexed again")
+	}
+	if want := sam.ReadBai(filename + ".bai"); !reflect.DeepEqual(changed, want) {
+		t.Error("shared index differs from sam.ReadBai")
+	}
+}
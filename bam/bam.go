@@ -0,0 +1,57 @@
+// Package bam writes bam files, compressing blocks concurrently with package bgzf.
+// Records are encoded as by sam.WriteToBamFileHandle in gonomics, which compresses each
+// block on the calling goroutine and limits commands writing a bam to about one CPU.
+// AppendRecord and Decode convert single records, as the htslib backend of package hts
+// exchanges them with htslib.
+package bam
+
+import (
+	"encoding/binary"
+	"github.com/dasnellings/duplexTools/bgzf"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"strings"
+)
+
+var le = binary.LittleEndian
+
+// Writer writes sam records to a bam file.
+type Writer struct {
+	bgzf   *bgzf.Writer
+	refMap map[string]int // index of each reference in the header
+	buf    []byte
+}
+
+// NewWriter returns a Writer to w and writes the header. Close does not close w.
+func NewWriter(w io.Writer, h sam.Header) *Writer {
+	bw := &Writer{bgzf: bgzf.NewWriter(w), refMap: make(map[string]int, len(h.Chroms))}
+	text := strings.Join(h.Text, "\n") + "\n"
+	b := append([]byte("BAM\x01"), 0, 0, 0, 0)
+	le.PutUint32(b[4:], uint32(len(text)))
+	b = append(b, text...)
+	b = le.AppendUint32(b, uint32(len(h.Chroms)))
+	for i, c := range h.Chroms {
+		bw.refMap[c.Name] = i
+		b = le.AppendUint32(b, uint32(len(c.Name)+1))
+		b = append(append(b, c.Name...), 0)
+		b = le.AppendUint32(b, uint32(c.Size))
+	}
+	_, err := bw.bgzf.Write(b)
+	exception.PanicOnErr(err)
+	return bw
+}
+
+// Write writes s to the bam. The bin of s is calculated from its alignment.
+func (w *Writer) Write(s sam.Sam) {
+	b := AppendRecord(append(w.buf[:0], 0, 0, 0, 0), s, w.refMap) // block size, filled in below
+	le.PutUint32(b, uint32(len(b)-4))
+	w.buf = b
+	_, err := w.bgzf.Write(b)
+	exception.PanicOnErr(err)
+}
+
+// Close writes the remaining records and the end of file marker.
+func (w *Writer) Close() error {
+	return w.bgzf.Close()
+}
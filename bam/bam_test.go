@@ -0,0 +1,116 @@
+package bam
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 1000}, {Name: "chr2", Size: 1000, Order: 1}}, nil, sam.Coordinate, sam.None)
+	reads := []sam.Sam{
+		{QName: "a", Flag: 99, MapQ: 60, RName: "chr1", Pos: 10, Cigar: []cigar.Cigar{{RunLength: 3, Op: 'M'}, {RunLength: 2, Op: 'S'}},
+			RNext: "=", PNext: 100, TLen: 95, Seq: dna.StringToBases("ACGTN"), Qual: "IIII#",
+			Extra: "RF:Z:chr1:10_x\tRS:i:-4\tXA:A:q\tXB:B:s,1,-2,3\tXF:f:0.5"},
+		{QName: "b", Flag: 4, RName: "*", RNext: "*", Cigar: []cigar.Cigar{{Op: '*'}}, Seq: dna.StringToBases("ACG"), Qual: "*"},
+		{QName: "c", Flag: 145, MapQ: 20, RName: "chr2", Pos: 1, Cigar: []cigar.Cigar{{RunLength: 4, Op: 'M'}},
+			RNext: "chr1", PNext: 5, Seq: dna.StringToBases("TTTT"), Qual: "ABCD"},
+	}
+
+	filename := filepath.Join(t.TempDir(), "test.bam")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := NewWriter(file, header)
+	for _, r := range reads {
+		w.Write(r)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	// the records must be encoded as by gonomics with the bins set
+	var expected bytes.Buffer
+	gw := sam.NewBamWriter(&expected, header)
+	for _, r := range reads {
+		sam.WriteToBamFileHandle(gw, r, bai.ReadBin(r))
+	}
+	gw.Close()
+	original, _ := os.ReadFile(filename)
+	if !bytes.Equal(decompress(t, original), decompress(t, expected.Bytes())) {
+		t.Error("records are not encoded as by sam.WriteToBamFileHandle")
+	}
+
+	// records read from a bam are written with their tags copied unparsed
+	br, h := sam.OpenBam(filename)
+	var copied bytes.Buffer
+	w = NewWriter(&copied, h)
+	var n int
+	for {
+		var r sam.Sam
+		_, err = sam.DecodeBam(br, &r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(r)
+		n++
+	}
+	br.Close()
+	w.Close()
+	if n != len(reads) || !bytes.Equal(copied.Bytes(), original) {
+		t.Error("bam written from records read from a bam differs from the original")
+	}
+}
+
+func TestDecode(t *testing.T) {
+	refs := []chromInfo.ChromInfo{{Name: "chr1", Size: 1000}, {Name: "chr2", Size: 1000, Order: 1}}
+	reads := []sam.Sam{
+		{QName: "a", Flag: 99, MapQ: 60, RName: "chr1", Pos: 10, Cigar: []cigar.Cigar{{RunLength: 3, Op: 'M'}, {RunLength: 2, Op: 'S'}},
+			RNext: "=", PNext: 100, TLen: -95, Seq: dna.StringToBases("ACGTN"), Qual: "IIII#",
+			Extra: "RF:Z:chr1:10_x\tRS:i:-4\tXA:A:q\tXB:B:s,1,-2,3\tXC:B:C\tXF:f:0.5\tXH:H:1AE3"},
+		{QName: "b", Flag: 4, RName: "*", RNext: "*", Cigar: []cigar.Cigar{{Op: '*'}}, Seq: dna.StringToBases("ACG"), Qual: "*"},
+		{QName: "c", Flag: 145, MapQ: 20, RName: "chr2", Pos: 1, Cigar: []cigar.Cigar{{RunLength: 4, Op: 'M'}},
+			RNext: "chr1", PNext: 5, Seq: dna.StringToBases("TTTT"), Qual: "ABCD"},
+	}
+	refMap := map[string]int{"chr1": 0, "chr2": 1}
+	for _, r := range reads {
+		actual := Decode(AppendRecord(nil, r, refMap), refs)
+		if sam.ToString(actual) != sam.ToString(r) {
+			t.Errorf("decoded\n%s\nexpected\n%s", sam.ToString(actual), sam.ToString(r))
+		}
+	}
+
+	// integer tags of every size are decoded as type i, and ambiguous bases as N
+	rec := AppendRecord(nil, sam.Sam{QName: "d", RName: "*", RNext: "*", Cigar: []cigar.Cigar{{Op: '*'}}, Seq: dna.StringToBases("AC"), Qual: "II"}, refMap)
+	rec[recordSize+2] = 0x1f // packed bases A and R
+	rec = append(rec, "XCc\xfeXSS\x01\x01"...)
+	actual := Decode(rec, refs)
+	if dna.BasesToString(actual.Seq) != "AN" || actual.Extra != "XC:i:-2\tXS:i:257" {
+		t.Errorf("unexpected seq %s and tags %s", dna.BasesToString(actual.Seq), actual.Extra)
+	}
+}
+
+func decompress(t *testing.T, data []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ans, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ans
+}
@@ -0,0 +1,333 @@
+package bam
+
+import (
+	"bytes"
+	"encoding/hex"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"log"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// recordSize is the size of the fixed fields at the start of a record, after its block size.
+const recordSize = 32
+
+// AppendRecord appends s to b encoded as a record in a bam file, without the block size
+// before it, with refs holding the index of each reference in the header. The bin of s
+// is calculated from its alignment.
+func AppendRecord(b []byte, s sam.Sam, refs map[string]int) []byte {
+	b = le.AppendUint32(b, uint32(refId(refs, s.RName, s.RName)))
+	b = le.AppendUint32(b, s.Pos-1)
+	b = append(b, uint8(len(s.QName)+1), s.MapQ)
+	b = le.AppendUint16(b, bai.ReadBin(s))
+	cigars := s.Cigar
+	if len(cigars) > 0 && cigars[0].Op == '*' {
+		cigars = nil
+	}
+	b = le.AppendUint16(b, uint16(len(cigars)))
+	b = le.AppendUint16(b, s.Flag)
+	b = le.AppendUint32(b, uint32(len(s.Seq)))
+	if s.RNext == "=" {
+		b = le.AppendUint32(b, uint32(refId(refs, s.RName, s.RName)))
+	} else {
+		b = le.AppendUint32(b, uint32(refId(refs, s.RNext, s.RName)))
+	}
+	b = le.AppendUint32(b, s.PNext-1)
+	b = le.AppendUint32(b, uint32(s.TLen))
+	b = append(append(b, s.QName...), 0)
+	for _, c := range cigars {
+		b = le.AppendUint32(b, uint32(c.RunLength)<<4|cigarOp(c))
+	}
+	for i := 0; i < len(s.Seq); i += 2 {
+		packed := baseEncoder[s.Seq[i]] << 4
+		if i+1 < len(s.Seq) {
+			packed |= baseEncoder[s.Seq[i+1]]
+		}
+		b = append(b, packed)
+	}
+	if s.Qual == "*" {
+		for range s.Seq {
+			b = append(b, 0xff)
+		}
+	} else {
+		for i := 0; i < len(s.Qual); i++ {
+			b = append(b, s.Qual[i]-33)
+		}
+	}
+	return appendTags(b, s)
+}
+
+// refId returns the index in refs of reference name, or -1 for "*".
+func refId(refs map[string]int, name, readRef string) int32 {
+	if name == "*" {
+		return -1
+	}
+	i, found := refs[name]
+	if !found {
+		log.Fatalf("ERROR: reference '%s' of read aligned to '%s' is not in the bam header.", name, readRef)
+	}
+	return int32(i)
+}
+
+// Decode returns the record rec, encoded as in a bam file without the block size before
+// it, with refs the references of the header. Unlike sam.DecodeBam the tags are decoded
+// to s.Extra in the sam text format, and bases other than A, C, G and T are read as N.
+func Decode(rec []byte, refs []chromInfo.ChromInfo) sam.Sam {
+	if len(rec) < recordSize {
+		log.Panicf("bam record of %d bytes is truncated", len(rec))
+	}
+	name := func(id int32) string {
+		if id < 0 {
+			return "*"
+		}
+		if int(id) >= len(refs) {
+			log.Panicf("bam record has reference %d, but the header has %d", id, len(refs))
+		}
+		return refs[id].Name
+	}
+	tid := int32(le.Uint32(rec))
+	lenName := int(rec[8])
+	numCigar := int(le.Uint16(rec[12:]))
+	lenSeq := int(le.Uint32(rec[16:]))
+	s := sam.Sam{
+		RName: name(tid),
+		Pos:   le.Uint32(rec[4:]) + 1,
+		MapQ:  rec[9],
+		Flag:  le.Uint16(rec[14:]),
+		PNext: le.Uint32(rec[24:]) + 1,
+		TLen:  int32(le.Uint32(rec[28:])),
+	}
+	s.RNext = name(int32(le.Uint32(rec[20:])))
+	if s.RNext == s.RName && s.RNext != "*" {
+		s.RNext = "="
+	}
+	end := recordSize + lenName + 4*numCigar + (lenSeq+1)/2 + lenSeq
+	if lenName == 0 || end > len(rec) {
+		log.Panicf("bam record of %d bytes is truncated", len(rec))
+	}
+
+	b := rec[recordSize:]
+	s.QName = strings.TrimRight(string(b[:lenName]), "\x00")
+	b = b[lenName:]
+	if numCigar == 0 {
+		s.Cigar = []cigar.Cigar{{Op: '*'}}
+	}
+	for i := 0; i < numCigar; i++ {
+		c := le.Uint32(b[4*i:])
+		if c&0xf >= uint32(len(cigarOps)) {
+			log.Panicf("unrecognized cigar op %d in bam record of %s", c&0xf, s.QName)
+		}
+		s.Cigar = append(s.Cigar, cigar.Cigar{RunLength: int(c >> 4), Op: rune(cigarOps[c&0xf])})
+	}
+	b = b[4*numCigar:]
+	s.Seq = make([]dna.Base, lenSeq)
+	for i := range s.Seq {
+		packed := b[i/2]
+		if i%2 == 0 {
+			packed >>= 4
+		}
+		s.Seq[i] = baseDecoder[packed&0xf]
+	}
+	b = b[(lenSeq+1)/2:]
+	if lenSeq == 0 || b[0] == 0xff {
+		s.Qual = "*"
+	} else {
+		qual := make([]byte, lenSeq)
+		for i := range qual {
+			qual[i] = b[i] + 33
+		}
+		s.Qual = string(qual)
+	}
+	s.Extra = tagText(rec[end:])
+	return s
+}
+
+// unparsedExtra is the index of the field of sam.Sam holding the encoded tags of a
+// record read from a bam, or -1 if there is none. The field is unexported, so it is
+// read with reflect to copy the tags without parsing and encoding them again.
+var unparsedExtra = func() int {
+	f, found := reflect.TypeOf(sam.Sam{}).FieldByName("unparsedExtra")
+	if !found || f.Type.Kind() != reflect.Slice || f.Type.Elem().Kind() != reflect.Uint8 {
+		return -1
+	}
+	return f.Index[0]
+}()
+
+// baseEncoder converts gonomics dna.Base values to the 4 bit bam encoding. Lowercase
+// bases are written as uppercase and anything else as N.
+var baseEncoder = []uint8{1, 2, 4, 8, 15, 1, 2, 4, 8, 15, 15, 15, 15, 15, 15, 15}
+
+// baseDecoder converts the 4 bit bam encoding of =ACMGRSVTWYHKDBN to gonomics dna.Base
+// values, with every ambiguous base read as N.
+var baseDecoder = [16]dna.Base{dna.N, dna.A, dna.C, dna.N, dna.G, dna.N, dna.N, dna.N, dna.T, dna.N, dna.N, dna.N, dna.N, dna.N, dna.N, dna.N}
+
+// cigarOps are the cigar operations in the order of their bam encoding.
+const cigarOps = "MIDNSHP=X"
+
+func cigarOp(c cigar.Cigar) uint32 {
+	i := strings.IndexRune(cigarOps, c.Op)
+	if i < 0 {
+		log.Fatalf("ERROR: unrecognized cigar op '%c'.", c.Op)
+	}
+	return uint32(i)
+}
+
+// appendTags appends the encoded tags of s to b. Tags of a record read from a bam are
+// copied unless they were parsed into s.Extra, matching sam.WriteToBamFileHandle.
+func appendTags(b []byte, s sam.Sam) []byte {
+	if unparsedExtra >= 0 {
+		if raw := reflect.ValueOf(&s).Elem().Field(unparsedExtra).Bytes(); len(raw) > 0 {
+			return append(b, raw...)
+		}
+	}
+	if s.Extra == "" {
+		return b
+	}
+	for _, tag := range strings.Split(s.Extra, "\t") {
+		b = appendTag(b, tag)
+	}
+	return b
+}
+
+// appendTag appends a tag in the sam text format TG:TYPE:VALUE to b.
+func appendTag(b []byte, tag string) []byte {
+	name, rest, found := strings.Cut(tag, ":")
+	typ, value, found2 := strings.Cut(rest, ":")
+	if !found || !found2 || len(name) != 2 || len(typ) != 1 {
+		log.Panicf("malformed auxiliary data '%s'", tag)
+	}
+	b = append(b, name...)
+	if typ == "B" {
+		if len(value) < 1 {
+			log.Panicf("malformed auxiliary data '%s'", tag)
+		}
+		typ, value = value[:1], strings.TrimPrefix(value[1:], ",")
+		b = append(b, 'B', typ[0])
+		var n int
+		if value != "" {
+			n = strings.Count(value, ",") + 1
+		}
+		b = le.AppendUint32(b, uint32(n))
+	} else {
+		b = append(b, typ[0])
+	}
+
+	switch typ[0] {
+	case 'A':
+		return append(b, value[0])
+	case 'Z':
+		return append(append(b, value...), 0)
+	case 'H': // stored as the hex digits, as in sam
+		_, err := hex.DecodeString(value)
+		exception.PanicOnErr(err)
+		return append(append(b, value...), 0)
+	}
+	if value == "" {
+		return b
+	}
+	for _, v := range strings.Split(value, ",") {
+		switch typ[0] {
+		case 'c', 'C':
+			i, err := strconv.Atoi(v)
+			exception.PanicOnErr(err)
+			b = append(b, uint8(i))
+		case 's', 'S':
+			i, err := strconv.Atoi(v)
+			exception.PanicOnErr(err)
+			b = le.AppendUint16(b, uint16(i))
+		case 'i', 'I':
+			i, err := strconv.Atoi(v)
+			exception.PanicOnErr(err)
+			b = le.AppendUint32(b, uint32(i))
+		case 'f':
+			f, err := strconv.ParseFloat(v, 32)
+			exception.PanicOnErr(err)
+			b = le.AppendUint32(b, math.Float32bits(float32(f)))
+		default:
+			log.Panicf("unrecognized auxiliary data type '%s'", typ)
+		}
+	}
+	return b
+}
+
+// tagSize is the size of a value of each numeric tag type.
+var tagSize = map[byte]int{'c': 1, 'C': 1, 's': 2, 'S': 2, 'i': 4, 'I': 4, 'f': 4}
+
+// tagText returns the encoded tags b in the sam text format, separated by tabs. Integers
+// of every size are written with type i, as by htslib and samtools.
+func tagText(b []byte) string {
+	var text []byte
+	for len(b) > 0 {
+		if len(b) < 4 {
+			log.Panicf("malformed auxiliary data %v", b)
+		}
+		if len(text) > 0 {
+			text = append(text, '\t')
+		}
+		text = append(text, b[0], b[1], ':')
+		typ := b[2]
+		b = b[3:]
+		switch typ {
+		case 'A':
+			text = append(text, 'A', ':', b[0])
+			b = b[1:]
+			continue
+		case 'Z', 'H':
+			end := bytes.IndexByte(b, 0)
+			if end < 0 {
+				log.Panicf("malformed auxiliary data %v", b)
+			}
+			text = append(append(text, typ, ':'), b[:end]...)
+			b = b[end+1:]
+			continue
+		}
+
+		n, array := 1, typ == 'B'
+		switch {
+		case array:
+			if len(b) < 5 {
+				log.Panicf("malformed auxiliary data %v", b)
+			}
+			text = append(text, 'B', ':', b[0])
+			typ, n = b[0], int(le.Uint32(b[1:]))
+			b = b[5:]
+		case typ == 'f':
+			text = append(text, "f:"...)
+		default:
+			text = append(text, "i:"...)
+		}
+		if tagSize[typ] == 0 || tagSize[typ]*n > len(b) {
+			log.Panicf("malformed auxiliary data of type '%c'", typ)
+		}
+		for i := 0; i < n; i++ {
+			if array {
+				text = append(text, ',')
+			}
+			switch typ {
+			case 'c':
+				text = strconv.AppendInt(text, int64(int8(b[0])), 10)
+			case 'C':
+				text = strconv.AppendUint(text, uint64(b[0]), 10)
+			case 's':
+				text = strconv.AppendInt(text, int64(int16(le.Uint16(b))), 10)
+			case 'S':
+				text = strconv.AppendUint(text, uint64(le.Uint16(b)), 10)
+			case 'i':
+				text = strconv.AppendInt(text, int64(int32(le.Uint32(b))), 10)
+			case 'I':
+				text = strconv.AppendUint(text, uint64(le.Uint32(b)), 10)
+			case 'f':
+				text = strconv.AppendFloat(text, float64(math.Float32frombits(le.Uint32(b))), 'g', -1, 32)
+			}
+			b = b[tagSize[typ]:]
+		}
+	}
+	return string(text)
+}
@@ -49,6 +49,10 @@ var Barcodes map[string]bool = map[string]bool{
 	McsB16: true,
 }
 
+// barcodeOrder lists Barcodes in the order they are tried when rescuing a barcode, so
+// a sequence close to two barcodes always gets the same one.
+var barcodeOrder = []string{McsB1, McsB2, McsB3, McsB4, McsB5, McsB6, McsB7, McsB8, McsB9, McsB10, McsB11, McsB12, McsB13, McsB14, McsB15, McsB16}
+
 func Get(s sam.Sam) (forward, reverse string) {
 	//var seq string
 	//var idxEnd int
@@ -122,7 +126,7 @@ func Extract(seq []dna.Base) string {
 
 func attemptBarcodeRescue(s string) string {
 	var idx int
-	for barcode := range Barcodes {
+	for _, barcode := range barcodeOrder {
 		idx = strings.Index(s, barcode)
 		if idx == 0 {
 			return barcode
@@ -139,7 +143,7 @@ func Consensus(s string) string {
 		return s
 	}
 
-	for bc, _ := range Barcodes {
+	for _, bc := range barcodeOrder {
 		if levenshteinString(s, bc) <= 2 {
 			return bc
 		}
@@ -0,0 +1,618 @@
+// Package bcf writes BCF 2.2, the binary form of VCF. A Writer takes VCF text, as
+// written by vcf.NewWriteHeader and vcf.WriteVcf, and encodes each record with the
+// types declared by the ##INFO and ##FORMAT lines of the header, so commands that write
+// VCF to an io.Writer can write BCF without other changes.
+package bcf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bgzf"
+	"io"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// value types of the typed values in a record
+const (
+	typeNull  = 0
+	typeInt8  = 1
+	typeInt16 = 2
+	typeInt32 = 3
+	typeFloat = 5
+	typeChar  = 7
+)
+
+// missing and vectorEnd mark missing values and the padding of short vectors in the
+// int32 values passed to the encoders.
+const (
+	missing   = math.MinInt32
+	vectorEnd = math.MinInt32 + 1
+)
+
+var (
+	floatMissing   = math.Float32frombits(0x7F800001)
+	floatVectorEnd = math.Float32frombits(0x7F800002)
+)
+
+// passLine is added to headers without one, so that PASS is the first filter.
+const passLine = "##FILTER=<ID=PASS,Description=\"All filters passed\">"
+
+// IsBcf reports whether filename names a BCF file.
+func IsBcf(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".bcf")
+}
+
+// field is an INFO or FORMAT field declared in the header.
+type field struct {
+	key int    // index in the dictionary of IDs
+	typ string // Integer, Float, Flag, Character, or String
+}
+
+// Writer encodes VCF text written to it as BCF. Header lines are held until the first
+// record. Comment lines after the header cannot be stored in BCF and are left out with
+// a warning on Close. A #TRUNCATED marker after the header, which ends the output of an
+// interrupted or salvaged run, is kept as the lack of the bgzf end of file block, so
+// htslib and bcftools report the BCF as truncated.
+type Writer struct {
+	name    string
+	file    io.Closer // nil for stdout
+	bgzf    *bgzf.Writer
+	line    []byte
+	header  []string
+	started bool // the header has been written
+	columns bool // the #CHROM line has been written to w
+	samples int
+	contigs map[string]int
+	info    map[string]field
+	format  map[string]field
+	filters map[string]int
+	shared  bytes.Buffer
+	indiv   bytes.Buffer
+	dropped []string
+	marker  string // a #TRUNCATED line after the header, or ""
+}
+
+// Create returns a Writer to filename, or to stdout if filename is "stdout".
+func Create(filename string) *Writer {
+	if filename == "stdout" || filename == "-" {
+		return NewWriter(os.Stdout, filename)
+	}
+	file, err := os.Create(filename)
+	if err != nil {
+		log.Fatalf("ERROR: could not create %s: %s", filename, err)
+	}
+	w := NewWriter(file, filename)
+	w.file = file
+	return w
+}
+
+// NewWriter returns a Writer that writes BCF to out, which Close does not close. name
+// is used in messages.
+func NewWriter(out io.Writer, name string) *Writer {
+	return &Writer{name: name, bgzf: bgzf.NewWriter(out)}
+}
+
+// Write encodes every line of VCF text completed by p.
+func (w *Writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		end := bytes.IndexByte(p, '\n') + 1
+		if end == 0 {
+			w.line = append(w.line, p...)
+			break
+		}
+		w.line = append(w.line, p[:end-1]...)
+		p = p[end:]
+		if err := w.writeLine(string(w.line)); err != nil {
+			return n - len(p), err
+		}
+		w.line = w.line[:0]
+	}
+	return n, nil
+}
+
+// Close writes the header if no record was written and any line without a newline,
+// then the bgzf end of file marker unless a #TRUNCATED marker was written, and closes
+// the file.
+func (w *Writer) Close() error {
+	var err error
+	if len(w.line) > 0 {
+		err = w.writeLine(string(w.line))
+	}
+	if err == nil && !w.started {
+		err = w.writeHeader()
+	}
+	if len(w.dropped) > 0 {
+		log.Printf("WARNING: BCF cannot hold comments after the header, so %d line(s) were left out of %s: %s", len(w.dropped), w.name, strings.Join(w.dropped, " "))
+	}
+	closeBgzf := w.bgzf.Close
+	if w.marker != "" {
+		log.Printf("WARNING: %s is incomplete, so it was written without the bgzf end of file block for htslib to report it as truncated. %s", w.name, w.marker)
+		closeBgzf = w.bgzf.CloseWithoutEOF
+	}
+	if closeErr := closeBgzf(); err == nil {
+		err = closeErr
+	}
+	if w.file != nil {
+		if closeErr := w.file.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func (w *Writer) writeLine(line string) error {
+	switch {
+	case line == "":
+		return nil
+	case line[0] == '#' && !w.columns:
+		w.header = append(w.header, line)
+		w.columns = strings.HasPrefix(line, "#CHROM")
+		return nil
+	case strings.HasPrefix(line, "#TRUNCATED"):
+		w.marker = line
+		return nil
+	case line[0] == '#':
+		w.dropped = append(w.dropped, line)
+		return nil
+	}
+	if !w.started {
+		if err := w.writeHeader(); err != nil {
+			return err
+		}
+	}
+	return w.writeRecord(line)
+}
+
+// writeHeader parses the dictionaries of the header and writes it.
+func (w *Writer) writeHeader() error {
+	w.started = true
+	w.contigs = make(map[string]int)
+	w.info = make(map[string]field)
+	w.format = make(map[string]field)
+	w.filters = map[string]int{"PASS": 0}
+	ids := map[string]int{"PASS": 0}
+
+	lines := w.header
+	if len(lines) == 0 || !strings.HasPrefix(lines[0], "##fileformat") {
+		return fmt.Errorf("%s: a VCF header must start with ##fileformat", w.name)
+	}
+	hasPass := false
+	for _, line := range lines {
+		hasPass = hasPass || strings.HasPrefix(line, "##FILTER=<ID=PASS,")
+	}
+	if !hasPass {
+		lines = append([]string{lines[0], passLine}, lines[1:]...)
+	}
+
+	for _, line := range lines {
+		key, attrs, ok := structured(line)
+		if !ok {
+			if strings.HasPrefix(line, "#CHROM") {
+				if n := strings.Count(line, "\t") - 8; n > 0 {
+					w.samples = n
+				}
+			}
+			continue
+		}
+		id := attrs["ID"]
+		switch key {
+		case "contig":
+			if _, found := w.contigs[id]; !found {
+				w.contigs[id] = len(w.contigs)
+			}
+		case "FILTER", "INFO", "FORMAT":
+			i, found := ids[id]
+			if !found {
+				i = len(ids)
+				ids[id] = i
+			}
+			switch key {
+			case "FILTER":
+				w.filters[id] = i
+			case "INFO":
+				w.info[id] = field{key: i, typ: attrs["Type"]}
+			case "FORMAT":
+				w.format[id] = field{key: i, typ: attrs["Type"]}
+			}
+		}
+	}
+
+	text := strings.Join(lines, "\n") + "\n\x00"
+	var buf bytes.Buffer
+	buf.WriteString("BCF\x02\x02")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(text)))
+	buf.WriteString(text)
+	_, err := w.bgzf.Write(buf.Bytes())
+	return err
+}
+
+// structured parses a header line of the form ##key=<ID=x,Type=y,...>.
+func structured(line string) (key string, attrs map[string]string, ok bool) {
+	key, value, found := strings.Cut(strings.TrimPrefix(line, "##"), "=")
+	if !strings.HasPrefix(line, "##") || !found || !strings.HasPrefix(value, "<") || !strings.HasSuffix(value, ">") {
+		return "", nil, false
+	}
+	attrs = make(map[string]string)
+	value = value[1 : len(value)-1]
+	for value != "" {
+		var name string
+		name, value, _ = strings.Cut(value, "=")
+		var v string
+		if strings.HasPrefix(value, "\"") {
+			end := 1
+			for end < len(value) && (value[end] != '"' || value[end-1] == '\\') {
+				end++
+			}
+			v = value[1:end]
+			if end < len(value) {
+				end++
+			}
+			value = strings.TrimPrefix(value[end:], ",")
+		} else {
+			v, value, _ = strings.Cut(value, ",")
+		}
+		attrs[name] = v
+	}
+	return key, attrs, true
+}
+
+// writeRecord encodes a VCF data line.
+func (w *Writer) writeRecord(line string) error {
+	cols := strings.Split(line, "\t")
+	if len(cols) < 8 {
+		return fmt.Errorf("%s: expected at least 8 columns in VCF record: %s", w.name, line)
+	}
+	contig, found := w.contigs[cols[0]]
+	if !found {
+		return fmt.Errorf("%s: %s has no ##contig line in the header, so it cannot be written to BCF", w.name, cols[0])
+	}
+	pos, err := strconv.Atoi(cols[1])
+	if err != nil {
+		return fmt.Errorf("%s: bad position in VCF record: %s", w.name, line)
+	}
+	alleles := []string{cols[3]}
+	if cols[4] != "." {
+		alleles = append(alleles, strings.Split(cols[4], ",")...)
+	}
+	rlen := len(cols[3])
+	var info []string
+	if cols[7] != "." && cols[7] != "" {
+		info = strings.Split(cols[7], ";")
+	}
+	for _, kv := range info {
+		if end, found := strings.CutPrefix(kv, "END="); found {
+			if e, err := strconv.Atoi(end); err == nil {
+				rlen = e - pos + 1
+			}
+		}
+	}
+	var formats []string
+	if len(cols) > 8 && cols[8] != "." {
+		formats = strings.Split(cols[8], ":")
+	}
+
+	s := &w.shared
+	s.Reset()
+	binary.Write(s, binary.LittleEndian, []int32{int32(contig), int32(pos - 1), int32(rlen)})
+	qual := floatMissing
+	if cols[5] != "." {
+		q, err := strconv.ParseFloat(cols[5], 32)
+		if err != nil {
+			return fmt.Errorf("%s: bad QUAL in VCF record: %s", w.name, line)
+		}
+		qual = float32(q)
+	}
+	binary.Write(s, binary.LittleEndian, qual)
+	binary.Write(s, binary.LittleEndian, uint32(len(alleles))<<16|uint32(len(info)))
+	binary.Write(s, binary.LittleEndian, uint32(len(formats))<<24|uint32(w.samples))
+	if cols[2] == "." {
+		encodeString(s, "")
+	} else {
+		encodeString(s, cols[2])
+	}
+	for _, a := range alleles {
+		encodeString(s, a)
+	}
+	var filters []int32
+	if cols[6] != "." {
+		for _, f := range strings.Split(cols[6], ";") {
+			i, found := w.filters[f]
+			if !found {
+				return fmt.Errorf("%s: FILTER %s is not declared in the header, so it cannot be written to BCF", w.name, f)
+			}
+			filters = append(filters, int32(i))
+		}
+	}
+	encodeInts(s, filters)
+	for _, kv := range info {
+		key, value, _ := strings.Cut(kv, "=")
+		f, found := w.info[key]
+		if !found {
+			return fmt.Errorf("%s: INFO %s is not declared in the header, so it cannot be written to BCF", w.name, key)
+		}
+		encodeInts(s, []int32{int32(f.key)})
+		if err = encodeValue(s, f.typ, value); err != nil {
+			return fmt.Errorf("%s: INFO %s: %s", w.name, key, err)
+		}
+	}
+
+	d := &w.indiv
+	d.Reset()
+	samples := make([][]string, w.samples)
+	for i := range samples {
+		if 9+i < len(cols) {
+			samples[i] = strings.Split(cols[9+i], ":")
+		}
+	}
+	for j, key := range formats {
+		f, found := w.format[key]
+		if !found {
+			return fmt.Errorf("%s: FORMAT %s is not declared in the header, so it cannot be written to BCF", w.name, key)
+		}
+		values := make([]string, w.samples)
+		for i := range samples {
+			values[i] = "."
+			if j < len(samples[i]) {
+				values[i] = samples[i][j]
+			}
+		}
+		encodeInts(d, []int32{int32(f.key)})
+		if key == "GT" {
+			err = encodeGenotypes(d, values)
+		} else {
+			err = encodeSamples(d, f.typ, values)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: FORMAT %s: %s", w.name, key, err)
+		}
+	}
+
+	var lengths [8]byte
+	binary.LittleEndian.PutUint32(lengths[:4], uint32(s.Len()))
+	binary.LittleEndian.PutUint32(lengths[4:], uint32(d.Len()))
+	for _, b := range [][]byte{lengths[:], s.Bytes(), d.Bytes()} {
+		if _, err = w.bgzf.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// typeDescriptor writes the type byte of a vector of n values of type t.
+func typeDescriptor(b *bytes.Buffer, n int, t byte) {
+	if n < 15 {
+		b.WriteByte(byte(n)<<4 | t)
+		return
+	}
+	b.WriteByte(15<<4 | t)
+	encodeInts(b, []int32{int32(n)})
+}
+
+func encodeString(b *bytes.Buffer, s string) {
+	typeDescriptor(b, len(s), typeChar)
+	b.WriteString(s)
+}
+
+// intType returns the smallest type that holds all of values.
+func intType(values []int32) byte {
+	t := byte(typeInt8)
+	for _, v := range values {
+		switch {
+		case v == missing || v == vectorEnd:
+		case v < -32760 || v > math.MaxInt16:
+			return typeInt32
+		case v < -120 || v > math.MaxInt8:
+			t = typeInt16
+		}
+	}
+	return t
+}
+
+// encodeInts writes values as a typed vector, with a null type if it is empty.
+func encodeInts(b *bytes.Buffer, values []int32) {
+	if len(values) == 0 {
+		typeDescriptor(b, 0, typeNull)
+		return
+	}
+	t := intType(values)
+	typeDescriptor(b, len(values), t)
+	writeInts(b, t, values)
+}
+
+func writeInts(b *bytes.Buffer, t byte, values []int32) {
+	for _, v := range values {
+		switch t {
+		case typeInt8:
+			switch v {
+			case missing:
+				v = math.MinInt8
+			case vectorEnd:
+				v = math.MinInt8 + 1
+			}
+			b.WriteByte(byte(int8(v)))
+		case typeInt16:
+			switch v {
+			case missing:
+				v = math.MinInt16
+			case vectorEnd:
+				v = math.MinInt16 + 1
+			}
+			binary.Write(b, binary.LittleEndian, int16(v))
+		default:
+			binary.Write(b, binary.LittleEndian, v)
+		}
+	}
+}
+
+// parseInts parses a comma separated list of integers, where "." is missing.
+func parseInts(s string) ([]int32, error) {
+	var ans []int32
+	for _, v := range strings.Split(s, ",") {
+		if v == "." {
+			ans = append(ans, missing)
+			continue
+		}
+		i, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || i <= vectorEnd {
+			return nil, fmt.Errorf("%q is not an integer", v)
+		}
+		ans = append(ans, int32(i))
+	}
+	return ans, nil
+}
+
+// parseFloats parses a comma separated list of numbers, where "." is missing.
+func parseFloats(s string) ([]float32, error) {
+	var ans []float32
+	for _, v := range strings.Split(s, ",") {
+		if v == "." {
+			ans = append(ans, floatMissing)
+			continue
+		}
+		f, err := strconv.ParseFloat(v, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+		ans = append(ans, float32(f))
+	}
+	return ans, nil
+}
+
+// encodeValue writes the value of an INFO field of type typ.
+func encodeValue(b *bytes.Buffer, typ, value string) error {
+	switch typ {
+	case "Flag":
+		typeDescriptor(b, 0, typeNull)
+	case "Integer":
+		ints, err := parseInts(value)
+		if err != nil {
+			return err
+		}
+		encodeInts(b, ints)
+	case "Float":
+		floats, err := parseFloats(value)
+		if err != nil {
+			return err
+		}
+		typeDescriptor(b, len(floats), typeFloat)
+		binary.Write(b, binary.LittleEndian, floats)
+	default: // String and Character
+		encodeString(b, value)
+	}
+	return nil
+}
+
+// encodeSamples writes the values of a FORMAT field of type typ for every sample,
+// padding the vectors of each sample to the same length.
+func encodeSamples(b *bytes.Buffer, typ string, values []string) error {
+	switch typ {
+	case "Integer":
+		vectors := make([][]int32, len(values))
+		var all []int32
+		var n int
+		for i := range values {
+			ints, err := parseInts(values[i])
+			if err != nil {
+				return err
+			}
+			vectors[i] = ints
+			all = append(all, ints...)
+			if len(ints) > n {
+				n = len(ints)
+			}
+		}
+		t := intType(all)
+		typeDescriptor(b, n, t)
+		for _, v := range vectors {
+			for len(v) < n {
+				v = append(v, vectorEnd)
+			}
+			writeInts(b, t, v)
+		}
+	case "Float":
+		vectors := make([][]float32, len(values))
+		var n int
+		for i := range values {
+			floats, err := parseFloats(values[i])
+			if err != nil {
+				return err
+			}
+			vectors[i] = floats
+			if len(floats) > n {
+				n = len(floats)
+			}
+		}
+		typeDescriptor(b, n, typeFloat)
+		for _, v := range vectors {
+			for len(v) < n {
+				v = append(v, floatVectorEnd)
+			}
+			binary.Write(b, binary.LittleEndian, v)
+		}
+	default: // String and Character
+		var n int
+		for i := range values {
+			if len(values[i]) > n {
+				n = len(values[i])
+			}
+		}
+		typeDescriptor(b, n, typeChar)
+		for _, v := range values {
+			b.WriteString(v)
+			b.Write(make([]byte, n-len(v)))
+		}
+	}
+	return nil
+}
+
+// encodeGenotypes writes GT values as (allele+1)<<1|phased, with missing alleles as 0.
+func encodeGenotypes(b *bytes.Buffer, values []string) error {
+	vectors := make([][]int32, len(values))
+	var n int
+	for i, gt := range values {
+		phased := int32(0)
+		for start := 0; start <= len(gt); {
+			end := strings.IndexAny(gt[start:], "/|")
+			if end == -1 {
+				end = len(gt)
+			} else {
+				end += start
+			}
+			allele := int32(0)
+			if a := gt[start:end]; a != "." {
+				v, err := strconv.Atoi(a)
+				if err != nil || v < 0 {
+					return fmt.Errorf("%q is not a genotype", gt)
+				}
+				allele = int32(v+1) << 1
+			}
+			vectors[i] = append(vectors[i], allele|phased)
+			if end < len(gt) && gt[end] == '|' {
+				phased = 1
+			} else {
+				phased = 0
+			}
+			start = end + 1
+		}
+		if len(vectors[i]) > n {
+			n = len(vectors[i])
+		}
+	}
+	var all []int32
+	for i := range vectors {
+		for len(vectors[i]) < n {
+			vectors[i] = append(vectors[i], vectorEnd)
+		}
+		all = append(all, vectors[i]...)
+	}
+	t := intType(all)
+	typeDescriptor(b, n, t)
+	writeInts(b, t, all)
+	return nil
+}
@@ -0,0 +1,225 @@
+package bcf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bgzf"
+	"io"
+	"math"
+	"strings"
+	"testing"
+)
+
+const testVcf = `##fileformat=VCFv4.2
+##contig=<ID=chr1,length=1000>
+##contig=<ID=chr2,length=1000>
+##INFO=<ID=DS,Number=0,Type=Flag,Description="Double-stranded">
+##INFO=<ID=END,Number=1,Type=Integer,Description="End, of the block">
+##INFO=<ID=AF,Number=A,Type=Float,Description="Allele fraction">
+##INFO=<ID=RG,Number=1,Type=String,Description="Read group">
+##FORMAT=<ID=GT,Number=1,Type=String,Description="Genotype">
+##FORMAT=<ID=DP,Number=1,Type=Integer,Description="Total Read Depth">
+##FORMAT=<ID=RF,Number=1,Type=Integer,Description="Read Family Identifier">
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO	FORMAT	a	b
+chr2	100	.	C	T	0	.	DS;AF=0.5;RG=a_long_read_group_name	GT:DP:RF	1:8:411	0/1:300:.
+chr1	7	rs1	A	<NON_REF>	.	PASS	END=20	GT:DP:RF	0:12:70000	.:.:.
+#TRUNCATED: interrupted
+`
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, "test.bcf")
+	if _, err := io.WriteString(w, testVcf); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data[:5]) != "BCF\x02\x02" {
+		t.Fatalf("wrong magic %q", data[:5])
+	}
+	textLen := binary.LittleEndian.Uint32(data[5:])
+	text := string(data[9 : 9+textLen])
+	if !strings.HasPrefix(text, "##fileformat=VCFv4.2\n"+passLine+"\n") || !strings.HasSuffix(text, "\tb\n\x00") {
+		t.Errorf("wrong header text:\n%s", text)
+	}
+
+	r := &reader{data: data[9+textLen:]}
+	expected := []string{
+		"chr=1 pos=99 rlen=1 qual=0 alleles=[C T] id= filters=[] info=[1:[] 3:[0.5] 4:a_long_read_group_name] format=[5:[4 end 2 4] 6:[8 300] 7:[411 missing]]",
+		"chr=0 pos=6 rlen=14 qual=missing alleles=[A <NON_REF>] id=rs1 filters=[0] info=[2:[20]] format=[5:[2 0] 6:[12 missing] 7:[70000 missing]]",
+	}
+	for i := range expected {
+		if got := r.record(2); got != expected[i] {
+			t.Errorf("record %d:\nexpected %s\ngot      %s", i, expected[i], got)
+		}
+	}
+	if len(r.data) != 0 {
+		t.Errorf("%d bytes left after the records", len(r.data))
+	}
+}
+
+// TestTruncated checks a #TRUNCATED marker leaves out the bgzf end of file block, and
+// is not taken into the header when no record was written.
+func TestTruncated(t *testing.T) {
+	header := testVcf[:strings.Index(testVcf, "chr2\t100")]
+	for name, vcf := range map[string]string{
+		"complete":   strings.TrimSuffix(testVcf, "#TRUNCATED: interrupted\n"),
+		"truncated":  testVcf,
+		"no records": header + "#TRUNCATED: interrupted\n",
+	} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, name)
+		if _, err := io.WriteString(w, vcf); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if complete := bytes.HasSuffix(buf.Bytes(), bgzf.EOF); complete != (name == "complete") {
+			t.Errorf("%s: end of file block written is %t", name, complete)
+		}
+		zr, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		text := string(data[9 : 9+binary.LittleEndian.Uint32(data[5:])])
+		if strings.Contains(text, "TRUNCATED") || !strings.HasSuffix(text, "\tb\n\x00") {
+			t.Errorf("%s: wrong header text:\n%s", name, text)
+		}
+	}
+}
+
+// reader decodes the records written by Writer, for testing.
+type reader struct {
+	data []byte
+}
+
+func (r *reader) uint32() uint32 {
+	v := binary.LittleEndian.Uint32(r.data)
+	r.data = r.data[4:]
+	return v
+}
+
+// typed reads the type byte of a vector, and the vector length that follows it if
+// needed, and returns the type and length.
+func (r *reader) typed() (t byte, n int) {
+	t, n = r.data[0]&0xf, int(r.data[0]>>4)
+	r.data = r.data[1:]
+	if n == 15 {
+		_, size := r.typed()
+		n = int(r.ints(1, size)[0])
+	}
+	return t, n
+}
+
+// ints reads n values of an int type, with missing and vectorEnd for the sentinels.
+func (r *reader) ints(t byte, n int) []int32 {
+	ans := make([]int32, n)
+	for i := range ans {
+		switch t {
+		case typeInt8:
+			ans[i] = int32(int8(r.data[0]))
+			if ans[i] <= math.MinInt8+1 {
+				ans[i] += missing - math.MinInt8
+			}
+			r.data = r.data[1:]
+		case typeInt16:
+			ans[i] = int32(int16(binary.LittleEndian.Uint16(r.data)))
+			if ans[i] <= math.MinInt16+1 {
+				ans[i] += missing - math.MinInt16
+			}
+			r.data = r.data[2:]
+		default:
+			ans[i] = int32(r.uint32())
+		}
+	}
+	return ans
+}
+
+func (r *reader) value() string {
+	t, n := r.typed()
+	return r.values(t, n)
+}
+
+// values reads and formats count values of type t.
+func (r *reader) values(t byte, count int) string {
+	switch t {
+	case typeChar:
+		s := string(r.data[:count])
+		r.data = r.data[count:]
+		return s
+	case typeFloat:
+		var s []string
+		for i := 0; i < count; i++ {
+			s = append(s, fmt.Sprint(math.Float32frombits(r.uint32())))
+		}
+		return fmt.Sprint(s)
+	default:
+		var s []string
+		for _, v := range r.ints(t, count) {
+			switch v {
+			case missing:
+				s = append(s, "missing")
+			case vectorEnd:
+				s = append(s, "end")
+			default:
+				s = append(s, fmt.Sprint(v))
+			}
+		}
+		return fmt.Sprint(s)
+	}
+}
+
+func (r *reader) key() int32 {
+	t, n := r.typed()
+	return r.ints(t, n)[0]
+}
+
+func (r *reader) record(samples int) string {
+	r.uint32() // l_shared
+	r.uint32() // l_indiv
+	chr, pos, rlen := int32(r.uint32()), int32(r.uint32()), int32(r.uint32())
+	qualBits := r.uint32()
+	qual := fmt.Sprint(math.Float32frombits(qualBits))
+	if qualBits == 0x7F800001 {
+		qual = "missing"
+	}
+	alleleInfo, fmtSamples := r.uint32(), r.uint32()
+	id := r.value()
+	var alleles []string
+	for i := 0; i < int(alleleInfo>>16); i++ {
+		alleles = append(alleles, r.value())
+	}
+	filters := r.value()
+	var info []string
+	for i := 0; i < int(alleleInfo&0xffff); i++ {
+		k := r.key()
+		info = append(info, fmt.Sprintf("%d:%s", k, r.value()))
+	}
+	var format []string
+	for i := 0; i < int(fmtSamples>>24); i++ {
+		k := r.key()
+		t, n := r.typed()
+		v := r.values(t, n*samples)
+		if t == typeChar {
+			v = strings.ReplaceAll(v, "\x00", ".")
+		}
+		format = append(format, fmt.Sprintf("%d:%s", k, v))
+	}
+	return fmt.Sprintf("chr=%d pos=%d rlen=%d qual=%s alleles=%v id=%s filters=%s info=%v format=%v", chr, pos, rlen, qual, alleles, id, filters, info, format)
+}
@@ -0,0 +1,198 @@
+// Package bgzf writes the blocked gzip format used by bam, bgzipped vcf and bed, and
+// tabix indexes. Blocks are compressed on a pool of goroutines shared by every Writer
+// and written in order, so writing output is not limited to the speed of one CPU.
+package bgzf
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// BlockSize is the maximum uncompressed size of a block, matching htslib.
+const BlockSize = 0xff00
+
+// maxBlockSize is the maximum compressed size of a block including the header and footer.
+const maxBlockSize = 1 << 16
+
+// EOF is the empty block that marks the end of a bgzf file.
+var EOF = []byte{0x1f, 0x8b, 0x08, 0x04, 0, 0, 0, 0, 0, 0xff, 0x06, 0, 0x42, 0x43, 0x02, 0, 0x1b, 0, 0x03, 0, 0, 0, 0, 0, 0, 0, 0, 0}
+
+// job is one block to be compressed.
+type job struct {
+	data []byte       // uncompressed
+	out  bytes.Buffer // compressed block with header and footer
+	done chan struct{}
+}
+
+var (
+	startWorkers sync.Once
+	jobs         chan *job
+	jobPool      = sync.Pool{New: func() any { return &job{data: make([]byte, 0, BlockSize)} }}
+)
+
+// compressor compresses blocks from jobs until the program exits.
+func compressor() {
+	zw, err := flate.NewWriter(nil, flate.DefaultCompression)
+	if err != nil {
+		panic(err)
+	}
+	var stored *flate.Writer
+	for j := range jobs {
+		compress(j, zw)
+		if j.out.Len() > maxBlockSize { // incompressible data is stored instead
+			if stored == nil {
+				stored, _ = flate.NewWriter(nil, flate.NoCompression)
+			}
+			compress(j, stored)
+		}
+		close(j.done)
+	}
+}
+
+// compress writes the bgzf block of j.data to j.out.
+func compress(j *job, zw *flate.Writer) {
+	j.out.Reset()
+	j.out.Write(EOF[:16]) // the header is the same for every block except the size
+	j.out.Write([]byte{0, 0})
+	zw.Reset(&j.out)
+	zw.Write(j.data) // writes to a bytes.Buffer do not fail
+	zw.Close()
+	var footer [8]byte
+	binary.LittleEndian.PutUint32(footer[:4], crc32.ChecksumIEEE(j.data))
+	binary.LittleEndian.PutUint32(footer[4:], uint32(len(j.data)))
+	j.out.Write(footer[:])
+	binary.LittleEndian.PutUint16(j.out.Bytes()[16:18], uint16(j.out.Len()-1)) // total block size - 1
+}
+
+// Writer compresses data written to it as bgzf blocks. Blocks are compressed
+// concurrently and written to the underlying writer in order.
+type Writer struct {
+	w       io.Writer
+	block   *job
+	blocks  uint64    // number of blocks sent to be compressed
+	queue   chan *job // blocks in the order they are written
+	done    chan struct{}
+	mu      sync.Mutex
+	err     error    // first error from w
+	offsets []uint64 // file offset of the start of each block and of the end of the last
+}
+
+// NewWriter returns a Writer to w. The end of file marker is written by Close, which
+// does not close w.
+func NewWriter(w io.Writer) *Writer {
+	startWorkers.Do(func() {
+		n := runtime.GOMAXPROCS(0)
+		jobs = make(chan *job, 2*n)
+		for i := 0; i < n; i++ {
+			go compressor()
+		}
+	})
+	bw := &Writer{
+		w:     w,
+		block: newJob(),
+		queue: make(chan *job, 2*runtime.GOMAXPROCS(0)),
+		done:  make(chan struct{}),
+	}
+	go bw.writeBlocks()
+	return bw
+}
+
+func newJob() *job {
+	j := jobPool.Get().(*job)
+	j.data = j.data[:0]
+	j.done = make(chan struct{})
+	return j
+}
+
+// writeBlocks writes each block to w once it is compressed.
+func (w *Writer) writeBlocks() {
+	var offset uint64
+	var err error
+	for j := range w.queue {
+		<-j.done
+		w.offsets = append(w.offsets, offset)
+		offset += uint64(j.out.Len())
+		if err == nil {
+			_, err = w.w.Write(j.out.Bytes())
+			if err != nil {
+				w.mu.Lock()
+				w.err = err
+				w.mu.Unlock()
+			}
+		}
+		jobPool.Put(j)
+	}
+	w.offsets = append(w.offsets, offset)
+	close(w.done)
+}
+
+// Write adds p to the current block, sending each block to be compressed as it fills.
+func (w *Writer) Write(p []byte) (int, error) {
+	if err := w.error(); err != nil {
+		return 0, err
+	}
+	n := len(p)
+	for len(p) > 0 {
+		copied := copy(w.block.data[len(w.block.data):BlockSize], p)
+		w.block.data = w.block.data[:len(w.block.data)+copied]
+		p = p[copied:]
+		if len(w.block.data) == BlockSize {
+			w.flush()
+		}
+	}
+	return n, nil
+}
+
+// flush sends the current block to be compressed and starts a new one.
+func (w *Writer) flush() {
+	if len(w.block.data) == 0 {
+		return
+	}
+	w.queue <- w.block
+	jobs <- w.block
+	w.blocks++
+	w.block = newJob()
+}
+
+func (w *Writer) error() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.err
+}
+
+// Offset returns the position of the next byte written as the block number in the
+// upper 48 bits and the offset within the block in the lower 16. The file offset of
+// the block is not known until it is compressed, so positions are converted to
+// virtual file offsets with VirtualOffset after Close.
+func (w *Writer) Offset() uint64 {
+	return w.blocks<<16 | uint64(len(w.block.data))
+}
+
+// VirtualOffset converts a position returned by Offset to a virtual file offset. It
+// may only be called after Close.
+func (w *Writer) VirtualOffset(pos uint64) uint64 {
+	return w.offsets[pos>>16]<<16 | pos&0xffff
+}
+
+// Close compresses and writes any buffered data followed by the end of file marker.
+func (w *Writer) Close() error {
+	if err := w.CloseWithoutEOF(); err != nil {
+		return err
+	}
+	_, err := w.w.Write(EOF)
+	return err
+}
+
+// CloseWithoutEOF compresses and writes any buffered data but not the end of file
+// marker, for output known to be incomplete, which readers then report as truncated.
+func (w *Writer) CloseWithoutEOF() error {
+	w.flush()
+	close(w.queue)
+	<-w.done
+	return w.error()
+}
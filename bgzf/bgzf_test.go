@@ -0,0 +1,101 @@
+package bgzf
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	data := make([]byte, 10*BlockSize+123)
+	rng := rand.New(rand.NewSource(1))
+	for i := range data {
+		data[i] = "ACGT\n"[rng.Intn(5)]
+	}
+	copy(data[3*BlockSize:], make([]byte, BlockSize)) // compresses well
+	rng.Read(data[5*BlockSize : 6*BlockSize])         // does not compress
+
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	var positions []uint64
+	for i := 0; i < len(data); i += 1000 {
+		positions = append(positions, w.Offset())
+		end := i + 1000
+		if end > len(data) {
+			end = len(data)
+		}
+		w.Write(data[i:end])
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasSuffix(out.Bytes(), EOF) {
+		t.Error("missing end of file marker")
+	}
+
+	zr, err := gzip.NewReader(bytes.NewReader(out.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(zr)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("decompressed data does not match input: %v", err)
+	}
+
+	// each virtual offset must point to the start of a block holding the data written there
+	for i, pos := range positions {
+		v := w.VirtualOffset(pos)
+		zr, err = gzip.NewReader(bytes.NewReader(out.Bytes()[v>>16:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr.Multistream(false)
+		block, _ := io.ReadAll(zr)
+		if !bytes.Equal(block[v&0xffff:], data[i*1000:i*1000+len(block)-int(v&0xffff)]) {
+			t.Errorf("virtual offset %d of byte %d points to the wrong data", v, i*1000)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	var out bytes.Buffer
+	w := NewWriter(&out)
+	w.Write(make([]byte, 3*BlockSize))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	data := out.Bytes()
+	first := int64(binary.LittleEndian.Uint16(data[16:])) + 1
+
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		data     []byte
+		complete bool
+		offset   int64
+	}{
+		{"complete", data, true, int64(len(data))},
+		{"no_eof", data[:len(data)-len(EOF)], false, int64(len(data) - len(EOF))},
+		{"truncated_block", data[:first+10], false, first},
+		{"truncated_header", data[:first+5], false, first},
+		{"corrupt", append(append([]byte{}, data[:first]...), make([]byte, 100)...), false, first},
+	}
+	for _, test := range tests {
+		path := filepath.Join(dir, test.name)
+		if err := os.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		complete, offset, problem, err := Check(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if complete != test.complete || offset != test.offset {
+			t.Errorf("%s: Check returned complete %t at offset %d (%s), expected %t at %d", test.name, complete, offset, problem, test.complete, test.offset)
+		}
+	}
+}
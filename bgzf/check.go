@@ -0,0 +1,58 @@
+package bgzf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+// headerSize is the size of a block header up to and including the BSIZE field.
+const headerSize = 18
+
+// Check reports whether the bgzf file at path is complete, i.e. ends with the EOF
+// block. If it does not, the block headers are followed from the start of the file
+// and the offset of the first block that is truncated or does not have a valid header
+// is returned with a description of the problem. Data before the offset can be read.
+func Check(path string) (complete bool, offset int64, problem string, err error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, 0, "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return false, 0, "", err
+	}
+	size := info.Size()
+	if size >= int64(len(EOF)) {
+		tail := make([]byte, len(EOF))
+		if _, err = f.ReadAt(tail, size-int64(len(EOF))); err != nil {
+			return false, 0, "", err
+		}
+		if bytes.Equal(tail, EOF) {
+			return true, size, "", nil
+		}
+	}
+
+	header := make([]byte, headerSize)
+	for offset < size {
+		n, err := f.ReadAt(header, offset)
+		if err != nil && err != io.EOF {
+			return false, offset, "", err
+		}
+		if n < headerSize {
+			return false, offset, fmt.Sprintf("the file ends %d bytes into a block header", n), nil
+		}
+		if header[0] != 0x1f || header[1] != 0x8b || header[3]&4 == 0 || header[12] != 'B' || header[13] != 'C' {
+			return false, offset, "the data is not a bgzf block", nil
+		}
+		blockSize := int64(binary.LittleEndian.Uint16(header[16:])) + 1
+		if offset+blockSize > size {
+			return false, offset, fmt.Sprintf("the file ends %d bytes into a %d byte block", size-offset, blockSize), nil
+		}
+		offset += blockSize
+	}
+	return false, offset, "the file ends without the bgzf end of file block", nil
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/commands/addBamTags"
+	"github.com/dasnellings/duplexTools/commands/annotateReadFamilies"
+	"github.com/dasnellings/duplexTools/commands/calcDuplexRate"
+	"github.com/dasnellings/duplexTools/commands/callCopyNumber"
+	"github.com/dasnellings/duplexTools/commands/callRepeatVariants"
+	"github.com/dasnellings/duplexTools/commands/duplexMultiomeSplit"
+	"github.com/dasnellings/duplexTools/commands/duplexPipeline"
+	"github.com/dasnellings/duplexTools/commands/extractIdtDuplex"
+	"github.com/dasnellings/duplexTools/commands/filterGermline"
+	"github.com/dasnellings/duplexTools/commands/findPerfectRepeats"
+	"github.com/dasnellings/duplexTools/commands/genotype"
+	"github.com/dasnellings/duplexTools/commands/genotypeTable"
+	"github.com/dasnellings/duplexTools/commands/genotypeTargetRepeats"
+	"github.com/dasnellings/duplexTools/commands/makeExcludeBed"
+	"github.com/dasnellings/duplexTools/commands/mcsAnnotate"
+	"github.com/dasnellings/duplexTools/commands/mcsBamSubset"
+	"github.com/dasnellings/duplexTools/commands/mcsBench"
+	"github.com/dasnellings/duplexTools/commands/mcsBurdenCorrection"
+	"github.com/dasnellings/duplexTools/commands/mcsCallVariants"
+	"github.com/dasnellings/duplexTools/commands/mcsCompare"
+	"github.com/dasnellings/duplexTools/commands/mcsConsensus"
+	"github.com/dasnellings/duplexTools/commands/mcsContam"
+	"github.com/dasnellings/duplexTools/commands/mcsCoverage"
+	"github.com/dasnellings/duplexTools/commands/mcsDbFilter"
+	"github.com/dasnellings/duplexTools/commands/mcsDepth"
+	"github.com/dasnellings/duplexTools/commands/mcsDownsample"
+	"github.com/dasnellings/duplexTools/commands/mcsErrorProfile"
+	"github.com/dasnellings/duplexTools/commands/mcsFingerprint"
+	"github.com/dasnellings/duplexTools/commands/mcsFqToBam"
+	"github.com/dasnellings/duplexTools/commands/mcsGermline"
+	"github.com/dasnellings/duplexTools/commands/mcsHotspot"
+	"github.com/dasnellings/duplexTools/commands/mcsMerge"
+	"github.com/dasnellings/duplexTools/commands/mcsMsi"
+	"github.com/dasnellings/duplexTools/commands/mcsPhylo"
+	"github.com/dasnellings/duplexTools/commands/mcsPower"
+	"github.com/dasnellings/duplexTools/commands/mcsQc"
+	"github.com/dasnellings/duplexTools/commands/mcsReport"
+	"github.com/dasnellings/duplexTools/commands/mcsSexCheck"
+	"github.com/dasnellings/duplexTools/commands/mcsShared"
+	"github.com/dasnellings/duplexTools/commands/mcsSignatureExtract"
+	"github.com/dasnellings/duplexTools/commands/mcsSplitFamilies"
+	"github.com/dasnellings/duplexTools/commands/mcsTargets"
+	"github.com/dasnellings/duplexTools/commands/mcsTelomere"
+	"github.com/dasnellings/duplexTools/commands/mcsTrim"
+	"github.com/dasnellings/duplexTools/commands/mcsUmiStats"
+	"github.com/dasnellings/duplexTools/commands/mcsValidate"
+	"github.com/dasnellings/duplexTools/commands/mcsVisualize"
+	"github.com/dasnellings/duplexTools/commands/mutationMotif"
+	"github.com/dasnellings/duplexTools/commands/rmTag"
+	"github.com/dasnellings/duplexTools/commands/selectDivergent"
+	"github.com/dasnellings/duplexTools/commands/singleStrandSummary"
+	"github.com/dasnellings/duplexTools/commands/sortedGrep"
+	"github.com/dasnellings/duplexTools/commands/vcfToMaf"
+	"github.com/dasnellings/duplexTools/deterministic"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/manifest"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/refcache"
+	"github.com/dasnellings/duplexTools/remote"
+	"github.com/dasnellings/duplexTools/schema"
+	"github.com/dasnellings/duplexTools/tmp"
+	"github.com/vertgenlab/gonomics/exception"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"duplexTools - Run any duplexTools command from a single binary.\n" +
+			"Global options are given before the command and are passed to each command that accepts them.\n" +
+			"Options given after the command take precedence over global options.\n" +
+			"Usage:\n" +
+			"duplexTools [global options] <command> [options]\n" +
+			"duplexTools -r ref.fa -threads 0 mcsCallVariants -i annotated.bam -b families.bed > calls.vcf\n" +
+			"duplexTools <command> -h\n" +
+			"duplexTools version\n" +
+			"duplexTools schema [name]\n" +
+			"duplexTools bench [options]\n\n" +
+			"Global options:\n")
+	globalFlags.PrintDefaults()
+	fmt.Print("\nCommands:\n")
+	for _, c := range commands {
+		fmt.Printf("  %s\n", c.name)
+	}
+}
+
+// command is a subcommand of duplexTools. refFlag and threadsFlag are the names of the command's options
+// that receive the global reference and threads, or empty if the command has no such option.
+type command struct {
+	name        string
+	main        func()
+	refFlag     string
+	threadsFlag string
+}
+
+var commands = []command{
+	{name: "addBamTags", main: addBamTags.Main},
+	{name: "annotateReadFamilies", main: annotateReadFamilies.Main},
+	{name: "calcDuplexRate", main: calcDuplexRate.Main},
+	{name: "callCopyNumber", main: callCopyNumber.Main},
+	{name: "callRepeatVariants", main: callRepeatVariants.Main},
+	{name: "duplexMultiomeSplit", main: duplexMultiomeSplit.Main},
+	{name: "duplexPipeline", main: duplexPipeline.Main},
+	{name: "extractIdtDuplex", main: extractIdtDuplex.Main},
+	{name: "filterGermline", main: filterGermline.Main},
+	{name: "findPerfectRepeats", main: findPerfectRepeats.Main, refFlag: "r"},
+	{name: "genotype", main: genotype.Main},
+	{name: "genotypeTable", main: genotypeTable.Main},
+	{name: "genotypeTargetRepeats", main: genotypeTargetRepeats.Main, refFlag: "r", threadsFlag: "alnThreads"},
+	{name: "makeExcludeBed", main: makeExcludeBed.Main, refFlag: "r"},
+	{name: "mcsAnnotate", main: mcsAnnotate.Main},
+	{name: "mcsBamSubset", main: mcsBamSubset.Main},
+	{name: "mcsBench", main: mcsBench.Main, threadsFlag: "threads"},
+	{name: "mcsBurdenCorrection", main: mcsBurdenCorrection.Main, refFlag: "r"},
+	{name: "mcsCallVariants", main: mcsCallVariants.Main, refFlag: "r", threadsFlag: "threads"},
+	{name: "mcsCompare", main: mcsCompare.Main, refFlag: "r"},
+	{name: "mcsConsensus", main: mcsConsensus.Main},
+	{name: "mcsContam", main: mcsContam.Main},
+	{name: "mcsCoverage", main: mcsCoverage.Main, refFlag: "r"},
+	{name: "mcsDbFilter", main: mcsDbFilter.Main},
+	{name: "mcsDepth", main: mcsDepth.Main, refFlag: "r"},
+	{name: "mcsDownsample", main: mcsDownsample.Main},
+	{name: "mcsErrorProfile", main: mcsErrorProfile.Main, refFlag: "r"},
+	{name: "mcsFingerprint", main: mcsFingerprint.Main},
+	{name: "mcsFqToBam", main: mcsFqToBam.Main},
+	{name: "mcsGermline", main: mcsGermline.Main, refFlag: "r"},
+	{name: "mcsHotspot", main: mcsHotspot.Main, refFlag: "r"},
+	{name: "mcsMerge", main: mcsMerge.Main, refFlag: "r"},
+	{name: "mcsMsi", main: mcsMsi.Main},
+	{name: "mcsPhylo", main: mcsPhylo.Main},
+	{name: "mcsPower", main: mcsPower.Main},
+	{name: "mcsQc", main: mcsQc.Main},
+	{name: "mcsReport", main: mcsReport.Main},
+	{name: "mcsSexCheck", main: mcsSexCheck.Main, refFlag: "r"},
+	{name: "mcsShared", main: mcsShared.Main},
+	{name: "mcsSignatureExtract", main: mcsSignatureExtract.Main, refFlag: "r"},
+	{name: "mcsSplitFamilies", main: mcsSplitFamilies.Main},
+	{name: "mcsTargets", main: mcsTargets.Main, refFlag: "r"},
+	{name: "mcsTelomere", main: mcsTelomere.Main},
+	{name: "mcsTrim", main: mcsTrim.Main},
+	{name: "mcsUmiStats", main: mcsUmiStats.Main},
+	{name: "mcsValidate", main: mcsValidate.Main, refFlag: "r"},
+	{name: "mcsVisualize", main: mcsVisualize.Main, refFlag: "r"},
+	{name: "mutationMotif", main: mutationMotif.Main, refFlag: "r"},
+	{name: "rmTag", main: rmTag.Main},
+	{name: "selectDivergent", main: selectDivergent.Main},
+	{name: "singleStrandSummary", main: singleStrandSummary.Main, refFlag: "r"},
+	{name: "sortedGrep", main: sortedGrep.Main},
+	{name: "vcfToMaf", main: vcfToMaf.Main},
+}
+
+var globalFlags = flag.NewFlagSet("duplexTools", flag.ExitOnError)
+
+func main() {
+	ref := globalFlags.String("r", "", "Reference FASTA file passed to commands with a reference option (-r).")
+	threads := globalFlags.Int("threads", -1, "Number of threads passed to commands with a threads option. 0 uses every CPU available to the job, respecting container and scheduler CPU limits. -1 uses the command default.")
+	dryRun := globalFlags.Bool(dryrun.Flag, false, "Passed to the command to check its inputs and outputs and print the plan without processing data.")
+	reproducible := globalFlags.Bool(deterministic.Flag, false, "Passed to the command to write byte-identical output on every run with the same inputs and options.")
+	manifestFile := globalFlags.String(manifest.Flag, "", "JSON manifest of the run, passed to the command.")
+	tmpDir := globalFlags.String(tmp.Flag, "", "Directory for intermediate files, passed to the command.")
+	refCache := globalFlags.String(refcache.Flag, "", "Directory of reference sequences for decoding cram by MD5, passed to the command.")
+	logFile := globalFlags.String("log", "", "Append log messages from the command to this file instead of stderr.")
+	globalFlags.Usage = usage
+	exception.PanicOnErr(globalFlags.Parse(os.Args[1:]))
+
+	if globalFlags.NArg() == 0 {
+		usage()
+		log.Fatal("ERROR: must specify a command.")
+	}
+
+	name := globalFlags.Arg(0)
+	if name == "version" {
+		fmt.Println("duplexTools " + provenance.Version())
+		return
+	}
+	if name == "schema" {
+		printSchema(globalFlags.Arg(1))
+		return
+	}
+
+	if name == "bench" {
+		name = "mcsBench"
+	}
+
+	c, found := findCommand(name)
+	if !found {
+		usage()
+		log.Fatalf("ERROR: unknown command '%s'.", name)
+	}
+
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		exception.PanicOnErr(err)
+		defer f.Close()
+		log.SetOutput(f)
+	}
+
+	hts.Reference = *ref // for cram, when built with htslib
+	if *threads > 0 {
+		hts.Threads = *threads
+	}
+	args := []string{c.name}
+	if *ref != "" && c.refFlag != "" {
+		args = append(args, "-"+c.refFlag, *ref)
+	}
+	if *threads >= 0 && c.threadsFlag != "" {
+		args = append(args, "-"+c.threadsFlag, strconv.Itoa(*threads))
+	}
+	if *dryRun {
+		args = append(args, "-"+dryrun.Flag)
+	}
+	if *reproducible {
+		args = append(args, "-"+deterministic.Flag)
+	}
+	if *manifestFile != "" {
+		args = append(args, "-"+manifest.Flag, *manifestFile)
+	}
+	if *tmpDir != "" {
+		args = append(args, "-"+tmp.Flag, *tmpDir)
+	}
+	if *refCache != "" {
+		args = append(args, "-"+refcache.Flag, *refCache)
+	}
+	os.Args = append(args, globalFlags.Args()[1:]...)
+	remote.Run(c.main)
+}
+
+// printSchema prints the JSON Schema of the named JSON output, or lists the outputs
+// with a schema if name is empty.
+func printSchema(name string) {
+	if name == "" {
+		for _, n := range schema.Names() {
+			fmt.Println(n)
+		}
+		return
+	}
+	b, err := schema.JsonSchema(name)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	_, err = os.Stdout.Write(b)
+	exception.PanicOnErr(err)
+}
+
+// findCommand returns the command with the given name, ignoring case.
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if strings.EqualFold(c.name, name) {
+			return c, true
+		}
+	}
+	return command{}, false
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/dasnellings/duplexTools/commands/makeExcludeBed"
+	"github.com/dasnellings/duplexTools/remote"
+)
+
+func main() {
+	remote.Run(makeExcludeBed.Main)
+}
@@ -0,0 +1,10 @@
+package main
+
+import (
+	"github.com/dasnellings/duplexTools/commands/vcfToMaf"
+	"github.com/dasnellings/duplexTools/remote"
+)
+
+func main() {
+	remote.Run(vcfToMaf.Main)
+}
@@ -0,0 +1,277 @@
+package annotateReadFamilies
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/families"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/parquet"
+	"github.com/dasnellings/duplexTools/preflight"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"annotateReadFamilies - Collapse read families from META-CS data and record families in RF tag for each read.\n" +
+			"Usage:\n" +
+			"annotateReadFamilies [options] -i input.bam > output.bam\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs annotateReadFamilies with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file. Must be coordinate sorted.")
+	output := flag.String("o", "stdout", "Output bam file.")
+	bed := flag.String("bed", "", "Output a bed file with the region covered by each read family. May significantly increase memory usage. Written as a parquet table (chrom, start, end, family, watson, crick) if the name ends in .parquet.")
+	strict := flag.Bool("strict", false, "Require perfect barcode match for family inclusion. Disables position matching. Use for high density data.")
+	tolerance := flag.Int("tolerance", 50, "Deviation from exact start match to be considered for inclusion in read family. 0 means perfect match. Low values are best for dense data, and high values are best for sparse data.")
+	strictPosMatching := flag.Bool("strictPosMatching", false, "For a read to be included in a read family, the start of both reads in a pair must exactly match the read family.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality.")
+	bedTags := flag.String("bedTags", "", "Comma separated sam `tags` (e.g. RG,CB) to add to the -bed file as columns after the watson and crick counts, under a #chrom header line. Each family gets the value of its first read with the tag, or '.' if none has it. Copy the columns to the VCF with mcsCallVariants -familyInfo.")
+	dryrun.Parse()
+
+	if *input == "" {
+		usage()
+		log.Fatal("ERROR: Must input a coordinate sorted bam file.")
+	}
+
+	var tags []string
+	if *bedTags != "" {
+		tags = strings.Split(*bedTags, ",")
+		for _, t := range tags {
+			if len(t) != 2 {
+				log.Fatalf("ERROR: -bedTags must be two character sam tags, found '%s'.", t)
+			}
+		}
+	}
+
+	annotateReadFamilies(*input, *output, *tolerance, *strict, *strictPosMatching, *bed, tags, uint8(*minMapQ))
+}
+
+type minimalBed struct {
+	chr         string
+	start       int
+	end         int
+	family      string
+	count       int
+	countWatson int
+	countCrick  int
+	tags        []string // values of -bedTags, "" until found
+}
+
+// familyColumns are the columns of a -bed file written as parquet.
+var familyColumns = []parquet.Column{
+	{Name: "chrom", Type: parquet.String},
+	{Name: "start", Type: parquet.Int64},
+	{Name: "end", Type: parquet.Int64},
+	{Name: "family", Type: parquet.String},
+	{Name: "watson", Type: parquet.Int64},
+	{Name: "crick", Type: parquet.Int64},
+}
+
+// familyHeader is the header line of a -bed file with -bedTags columns.
+const familyHeader = "#chrom\tstart\tend\tfamily\tscore\tstrand\twatson\tcrick"
+
+// writeFamilies writes families to bedOut, or to pq if the -bed file is parquet.
+func writeFamilies(bedOut io.Writer, pq *parquet.Writer, toWrite []*minimalBed) {
+	for _, b := range toWrite {
+		for i := range b.tags {
+			if b.tags[i] == "" {
+				b.tags[i] = "."
+			}
+		}
+		if pq != nil {
+			row := []any{b.chr, b.start, b.end, b.family, b.countWatson, b.countCrick}
+			for _, t := range b.tags {
+				row = append(row, t)
+			}
+			pq.Write(row...)
+			continue
+		}
+		fmt.Fprintf(bedOut, "%s\t%d\t%d\t%s\t0\t+\t%d\t%d", b.chr, b.start, b.end, b.family, b.countWatson, b.countCrick)
+		for _, t := range b.tags {
+			fmt.Fprintf(bedOut, "\t%s", t)
+		}
+		fmt.Fprintln(bedOut)
+	}
+}
+
+// tagValue returns the value of tag in the optional fields of r, or "" if r does not
+// have it.
+func tagValue(r *sam.Sam, tag string) string {
+	for _, f := range strings.Split(r.Extra, "\t") {
+		if len(f) > 5 && f[:2] == tag && f[2] == ':' && f[4] == ':' {
+			return f[5:]
+		}
+	}
+	return ""
+}
+
+func annotateReadFamilies(input, output string, tolerance int, strict, strictPosMatching bool, bed string, bedTags []string, minMapQ uint8) {
+	var err error
+	required := preflight.Bam{Sorted: true, Tags: []string{"BF", "BR"}}
+	required.Check(input)
+	reads, header := hts.GoReadToChan(input)
+	required.CheckHeader(input, header) // for a bam streamed from a pipe
+	reads = families.GoAnnotate(reads, tolerance, !strict, strictPosMatching)
+
+	bw := hts.Create(output, provenance.Sam(header))
+
+	var bedOut io.WriteCloser
+	var bedParquet *parquet.Writer
+	m := make(map[string]*minimalBed)
+	var rf string
+	var rs byte
+	var mb *minimalBed
+	switch {
+	case parquet.IsParquet(bed):
+		columns := familyColumns
+		for _, t := range bedTags {
+			columns = append(columns[:len(columns):len(columns)], parquet.Column{Name: t, Type: parquet.String})
+		}
+		bedParquet = parquet.Create(bed, columns...)
+	case bed != "":
+		bedOut = tabix.Create(bed)
+		if len(bedTags) > 0 {
+			fmt.Fprintln(bedOut, familyHeader+"\t"+strings.Join(bedTags, "\t"))
+		}
+	}
+	var prevChrom string
+	var readCount int
+	var bedToWrite []*minimalBed
+
+	for r := range reads {
+		if r.RName == "" {
+			continue
+		}
+		bw.Write(r)
+		if bed == "" || r.MapQ < minMapQ {
+			continue
+		}
+		readCount++
+		if r.RName != prevChrom {
+			for k, b := range m {
+				bedToWrite = append(bedToWrite, b)
+				delete(m, k)
+			}
+			sort.Slice(bedToWrite, func(i, j int) bool {
+				switch {
+				case bedToWrite[i].chr < bedToWrite[j].chr:
+					return true
+				case bedToWrite[i].chr > bedToWrite[j].chr:
+					return false
+				case bedToWrite[i].start < bedToWrite[j].start:
+					return true
+				case bedToWrite[i].start > bedToWrite[j].start:
+					return false
+				case bedToWrite[i].end != bedToWrite[j].end:
+					return bedToWrite[i].end < bedToWrite[j].end
+				default: // families with the same interval are collected from a map
+					return bedToWrite[i].family < bedToWrite[j].family
+				}
+			})
+			writeFamilies(bedOut, bedParquet, bedToWrite)
+			bedToWrite = bedToWrite[:0]
+		}
+
+		rf = barcode.GetRF(&r)
+		mb = m[rf]
+		if mb == nil {
+			mb = new(minimalBed)
+			m[rf] = mb
+			mb.chr = r.RName
+			mb.family = rf
+			mb.tags = make([]string, len(bedTags))
+		}
+		for i := range mb.tags {
+			if mb.tags[i] == "" {
+				mb.tags[i] = tagValue(&r, bedTags[i])
+			}
+		}
+		if mb.start == 0 || mb.start > r.GetChromStart() {
+			mb.start = r.GetChromStart()
+		}
+		if mb.end < r.GetChromEnd() {
+			mb.end = r.GetChromEnd()
+		}
+
+		// get read strand, returns true if watson
+		rs = barcode.GetRS(&r)
+		if rs == 'W' { // watson
+			mb.countWatson++
+		} else if rs == 'C' { // crick
+			mb.countCrick++
+		}
+
+		prevChrom = r.RName
+
+		if readCount%10000 == 0 { // write every 10000 reads
+			for k, b := range m {
+				if b.end < r.GetChromStart()-10000 { // only write if family is at least 10kb away
+					bedToWrite = append(bedToWrite, b)
+					delete(m, k)
+				}
+			}
+			sort.Slice(bedToWrite, func(i, j int) bool {
+				switch {
+				case bedToWrite[i].chr < bedToWrite[j].chr:
+					return true
+				case bedToWrite[i].chr > bedToWrite[j].chr:
+					return false
+				case bedToWrite[i].start < bedToWrite[j].start:
+					return true
+				case bedToWrite[i].start > bedToWrite[j].start:
+					return false
+				case bedToWrite[i].end != bedToWrite[j].end:
+					return bedToWrite[i].end < bedToWrite[j].end
+				default: // families with the same interval are collected from a map
+					return bedToWrite[i].family < bedToWrite[j].family
+				}
+			})
+			writeFamilies(bedOut, bedParquet, bedToWrite)
+			bedToWrite = bedToWrite[:0]
+		}
+	}
+
+	if bed != "" {
+		for k, b := range m {
+			bedToWrite = append(bedToWrite, b)
+			delete(m, k)
+		}
+		sort.Slice(bedToWrite, func(i, j int) bool {
+			switch {
+			case bedToWrite[i].chr < bedToWrite[j].chr:
+				return true
+			case bedToWrite[i].chr > bedToWrite[j].chr:
+				return false
+			case bedToWrite[i].start < bedToWrite[j].start:
+				return true
+			case bedToWrite[i].start > bedToWrite[j].start:
+				return false
+			case bedToWrite[i].end != bedToWrite[j].end:
+				return bedToWrite[i].end < bedToWrite[j].end
+			default:
+				return bedToWrite[i].family < bedToWrite[j].family
+			}
+		})
+		writeFamilies(bedOut, bedParquet, bedToWrite)
+		if bedParquet != nil {
+			err = bedParquet.Close()
+		} else {
+			err = bedOut.Close()
+		}
+		exception.PanicOnErr(err)
+	}
+
+	err = bw.Close()
+	exception.PanicOnErr(err)
+}
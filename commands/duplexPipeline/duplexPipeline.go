@@ -0,0 +1,324 @@
+package duplexPipeline
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/vertgenlab/gonomics/exception"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"duplexPipeline - Run annotateReadFamilies, mcsCallVariants, filterGermline, mcsBurdenCorrection, and mutationMotif\n" +
+			"on a single sample from one JSON config file.\n" +
+			"Each stage writes to a temporary directory inside outDir and its outputs are moved into outDir only after the\n" +
+			"stage finishes successfully. A record of each completed stage is kept in outDir/.done so that re-running the\n" +
+			"pipeline skips completed stages and resumes from the first stage that failed or whose command changed.\n" +
+			"The filterGermline stage is skipped if bulkBam is not set in the config.\n" +
+			"Example config:\n" +
+			"{\n" +
+			"  \"input\": \"sample.bam\",\n" +
+			"  \"reference\": \"hg38.fa\",\n" +
+			"  \"outDir\": \"sample_out\",\n" +
+			"  \"exclude\": [\"lowMappability.bed\"],\n" +
+			"  \"bulkBam\": \"bulk.bam\",\n" +
+			"  \"bulkVcf\": \"bulk.vcf.gz\",\n" +
+			"  \"args\": {\"mcsCallVariants\": [\"-threads\", \"8\"]}\n" +
+			"}\n" +
+			"Usage:\n" +
+			"duplexPipeline [options] -c config.json\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs duplexPipeline with the options in os.Args.
+func Main() {
+	configFile := flag.String("c", "", "JSON config file.")
+	restart := flag.Bool("restart", false, "Ignore records of completed stages and run the full pipeline.")
+	from := flag.String("from", "", "Re-run the pipeline starting from this stage. Must be one of: "+strings.Join(stageNames(), ", ")+".")
+	clean := flag.Bool("clean", false, "Remove intermediate files (annotated bam, unfiltered vcf) after the pipeline finishes successfully.")
+	dryRun := flag.Bool("dryRun", false, "Print the commands that would be run without running them.")
+	dryrun.Parse()
+
+	if *configFile == "" {
+		usage()
+		log.Fatal("ERROR: must specify a config file (-c).")
+	}
+
+	if *from != "" && stageIndex(*from) == -1 {
+		usage()
+		log.Fatalf("ERROR: unrecognized stage '%s' for -from.", *from)
+	}
+
+	duplexPipeline(readConfig(*configFile), *from, *restart, *clean, *dryRun)
+}
+
+// config holds the inputs and options for a pipeline run.
+type config struct {
+	Input     string              `json:"input"`     // coordinate sorted bam file with family barcodes
+	Reference string              `json:"reference"` // indexed reference fasta
+	OutDir    string              `json:"outDir"`
+	Prefix    string              `json:"prefix"`   // output file prefix. Defaults to the input file name.
+	Exclude   []string            `json:"exclude"`  // bed files passed to -e in mcsCallVariants
+	BulkBam   string              `json:"bulkBam"`  // indexed bam from bulk tissue for filterGermline
+	BulkVcf   string              `json:"bulkVcf"`  // germline vcf for filterGermline
+	SnpVcf    string              `json:"snpVcf"`   // known SNPs to exclude in filterGermline
+	BinDir    string              `json:"binDir"`   // directory with duplexTools binaries. Defaults to searching PATH.
+	Samtools  string              `json:"samtools"` // samtools binary used to index the annotated bam
+	Args      map[string][]string `json:"args"`     // additional arguments for each stage, keyed by stage name
+}
+
+// stage is a single step of the pipeline.
+type stage struct {
+	name         string
+	outputs      []string // file names relative to outDir
+	intermediate bool     // outputs may be removed with -clean
+	skip         func(c config) bool
+	command      func(c config, tmp string) []string // tmp is the directory outputs are written to
+}
+
+var stages = []stage{
+	{
+		name:         "annotateReadFamilies",
+		outputs:      []string{"annotated.bam", "families.bed"},
+		intermediate: true,
+		command: func(c config, tmp string) []string {
+			return []string{tool(c, "annotateReadFamilies"), "-i", c.Input, "-o", filepath.Join(tmp, file(c, "annotated.bam")), "-bed", filepath.Join(tmp, file(c, "families.bed"))}
+		},
+	},
+	{
+		name:         "index",
+		outputs:      []string{"annotated.bam.bai"},
+		intermediate: true,
+		command: func(c config, tmp string) []string {
+			return []string{c.Samtools, "index", "-o", filepath.Join(tmp, file(c, "annotated.bam.bai")), path(c, "annotated.bam")}
+		},
+	},
+	{
+		name:         "mcsCallVariants",
+		outputs:      []string{"raw.vcf"},
+		intermediate: true,
+		command: func(c config, tmp string) []string {
+			ans := []string{tool(c, "mcsCallVariants"), "-i", path(c, "annotated.bam"), "-b", path(c, "families.bed"), "-r", c.Reference, "-o", filepath.Join(tmp, file(c, "raw.vcf"))}
+			for _, e := range c.Exclude {
+				ans = append(ans, "-e", e)
+			}
+			return ans
+		},
+	},
+	{
+		name:    "filterGermline",
+		outputs: []string{"somatic.vcf"},
+		skip: func(c config) bool {
+			return c.BulkBam == ""
+		},
+		command: func(c config, tmp string) []string {
+			ans := []string{tool(c, "filterGermline"), "-i", path(c, "raw.vcf"), "-b", c.BulkBam, "-o", filepath.Join(tmp, file(c, "somatic.vcf"))}
+			if c.BulkVcf != "" {
+				ans = append(ans, "-g", c.BulkVcf)
+			}
+			if c.SnpVcf != "" {
+				ans = append(ans, "-e", c.SnpVcf)
+			}
+			return ans
+		},
+	},
+	{
+		name:    "mcsBurdenCorrection",
+		outputs: []string{"burden.txt"},
+		command: func(c config, tmp string) []string {
+			return []string{tool(c, "mcsBurdenCorrection"), "-i", finalVcf(c), "-b", path(c, "families.bed"), "-r", c.Reference, "-o", filepath.Join(tmp, file(c, "burden.txt"))}
+		},
+	},
+	{
+		name:    "mutationMotif",
+		outputs: []string{"spectrum.txt"},
+		command: func(c config, tmp string) []string {
+			return []string{tool(c, "mutationMotif"), "-i", finalVcf(c), "-r", c.Reference, "-pad", "1", "-o", filepath.Join(tmp, file(c, "spectrum.txt"))}
+		},
+	},
+}
+
+func duplexPipeline(c config, from string, restart, clean, dryRun bool) {
+	doneDir := filepath.Join(c.OutDir, ".done")
+	tmpDir := filepath.Join(c.OutDir, "tmp")
+	if !dryRun {
+		exception.PanicOnErr(os.MkdirAll(doneDir, 0755))
+		exception.PanicOnErr(os.MkdirAll(tmpDir, 0755))
+	}
+
+	fromIdx := len(stages) // stages before fromIdx may be skipped if previously completed
+	if from != "" {
+		fromIdx = stageIndex(from)
+	}
+
+	var cmd []string
+	var cmdString string
+	var err error
+	var rerun bool // once a stage runs, all downstream stages must also run
+	for i, s := range stages {
+		if s.skip != nil && s.skip(c) {
+			log.Printf("Skipping %s.\n", s.name)
+			continue
+		}
+		cmd = append(s.command(c, tmpDir), c.Args[s.name]...)
+		cmdString = strings.Join(cmd, " ")
+		if !restart && !rerun && i < fromIdx && isDone(doneDir, s, c, cmdString) {
+			log.Printf("Stage %s previously completed.\n", s.name)
+			continue
+		}
+		rerun = true
+		log.Printf("Running %s: %s\n", s.name, cmdString)
+		if dryRun {
+			continue
+		}
+		err = os.Remove(filepath.Join(doneDir, s.name))
+		if err != nil && !os.IsNotExist(err) {
+			exception.PanicOnErr(err)
+		}
+		runStage(c, s, cmd, tmpDir)
+		exception.PanicOnErr(os.WriteFile(filepath.Join(doneDir, s.name), []byte(cmdString+"\n"), 0644))
+	}
+
+	if dryRun {
+		return
+	}
+	exception.PanicOnErr(os.RemoveAll(tmpDir))
+	if clean {
+		for _, s := range stages {
+			if !s.intermediate {
+				continue
+			}
+			for _, o := range s.outputs {
+				if o == "families.bed" || path(c, o) == finalVcf(c) { // needed to interpret the final calls
+					continue
+				}
+				log.Printf("Removing intermediate file %s\n", path(c, o))
+				err = os.Remove(path(c, o))
+				if err != nil && !os.IsNotExist(err) {
+					exception.PanicOnErr(err)
+				}
+			}
+		}
+	}
+	log.Printf("Pipeline complete. Results are in %s\n", c.OutDir)
+}
+
+// runStage runs a stage with its log written to outDir/logs and moves its outputs from tmp into outDir.
+func runStage(c config, s stage, cmd []string, tmp string) {
+	logDir := filepath.Join(c.OutDir, "logs")
+	exception.PanicOnErr(os.MkdirAll(logDir, 0755))
+	logFile, err := os.Create(filepath.Join(logDir, file(c, s.name+".log")))
+	exception.PanicOnErr(err)
+	defer cleanup(logFile)
+
+	run := exec.Command(cmd[0], cmd[1:]...)
+	run.Stdout = logFile
+	run.Stderr = logFile
+	if err = run.Run(); err != nil {
+		log.Fatalf("ERROR: stage %s failed: %s\nSee %s for details. Re-run the pipeline to resume from this stage.", s.name, err, logFile.Name())
+	}
+
+	for _, o := range s.outputs {
+		err = os.Rename(filepath.Join(tmp, file(c, o)), path(c, o))
+		if err != nil {
+			log.Fatalf("ERROR: stage %s did not produce expected output %s: %s", s.name, file(c, o), err)
+		}
+	}
+}
+
+// isDone returns true if the stage has a completion record matching the current command and all of its outputs exist.
+func isDone(doneDir string, s stage, c config, cmdString string) bool {
+	record, err := os.ReadFile(filepath.Join(doneDir, s.name))
+	if err != nil || strings.TrimSpace(string(record)) != cmdString {
+		return false
+	}
+	for _, o := range s.outputs {
+		if _, err = os.Stat(path(c, o)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func readConfig(filename string) config {
+	data, err := os.ReadFile(filename)
+	exception.PanicOnErr(err)
+	var c config
+	err = json.Unmarshal(data, &c)
+	if err != nil {
+		log.Fatalf("ERROR: could not parse config file %s: %s", filename, err)
+	}
+	if c.Input == "" || c.Reference == "" {
+		log.Fatal("ERROR: config must specify input and reference.")
+	}
+	if c.OutDir == "" {
+		c.OutDir = "."
+	}
+	if c.Prefix == "" {
+		c.Prefix = strings.TrimSuffix(filepath.Base(c.Input), ".bam")
+	}
+	if c.Samtools == "" {
+		c.Samtools = "samtools"
+	}
+	for name := range c.Args {
+		if stageIndex(name) == -1 {
+			log.Fatalf("ERROR: unrecognized stage '%s' in config args. Must be one of: %s", name, strings.Join(stageNames(), ", "))
+		}
+	}
+	return c
+}
+
+// tool returns the path to a duplexTools binary.
+func tool(c config, name string) string {
+	if c.BinDir != "" {
+		return filepath.Join(c.BinDir, name)
+	}
+	return name
+}
+
+// file returns the name of a pipeline output.
+func file(c config, suffix string) string {
+	return c.Prefix + "." + suffix
+}
+
+// path returns the final location of a pipeline output.
+func path(c config, suffix string) string {
+	return filepath.Join(c.OutDir, file(c, suffix))
+}
+
+// finalVcf returns the vcf used for burden and spectrum analysis.
+func finalVcf(c config) string {
+	if c.BulkBam == "" {
+		return path(c, "raw.vcf")
+	}
+	return path(c, "somatic.vcf")
+}
+
+func stageNames() []string {
+	ans := make([]string, len(stages))
+	for i := range stages {
+		ans[i] = stages[i].name
+	}
+	return ans
+}
+
+func stageIndex(name string) int {
+	for i := range stages {
+		if stages[i].name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,70 @@
+package duplexPipeline
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadConfig(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(filename, []byte(`{"input": "/data/sample1.bam", "reference": "ref.fa", "args": {"mcsCallVariants": ["-s", "2"]}}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := readConfig(filename)
+	if c.OutDir != "." || c.Prefix != "sample1" || c.Samtools != "samtools" {
+		t.Errorf("expected defaults of ., sample1, and samtools, got %s, %s, and %s", c.OutDir, c.Prefix, c.Samtools)
+	}
+}
+
+func TestStageCommands(t *testing.T) {
+	c := config{Input: "in.bam", Reference: "ref.fa", OutDir: "out", Prefix: "s", BinDir: "bin", Exclude: []string{"a.bed"}}
+	tests := []struct {
+		bulkBam  string
+		stage    string
+		expected string
+	}{
+		{"", "mcsCallVariants", "bin/mcsCallVariants -i out/s.annotated.bam -b out/s.families.bed -r ref.fa -o tmp/s.raw.vcf -e a.bed"},
+		{"", "mutationMotif", "bin/mutationMotif -i out/s.raw.vcf -r ref.fa -pad 1 -o tmp/s.spectrum.txt"},
+		{"bulk.bam", "mutationMotif", "bin/mutationMotif -i out/s.somatic.vcf -r ref.fa -pad 1 -o tmp/s.spectrum.txt"},
+		{"bulk.bam", "filterGermline", "bin/filterGermline -i out/s.raw.vcf -b bulk.bam -o tmp/s.somatic.vcf"},
+	}
+	for _, test := range tests {
+		c.BulkBam = test.bulkBam
+		s := stages[stageIndex(test.stage)]
+		if actual := strings.Join(s.command(c, "tmp"), " "); actual != test.expected {
+			t.Errorf("expected %s, got %s", test.expected, actual)
+		}
+	}
+	c.BulkBam = ""
+	if !stages[stageIndex("filterGermline")].skip(c) {
+		t.Error("expected filterGermline to be skipped without a bulk bam")
+	}
+}
+
+func TestIsDone(t *testing.T) {
+	dir := t.TempDir()
+	c := config{OutDir: dir, Prefix: "s"}
+	s := stages[stageIndex("mcsBurdenCorrection")]
+	doneDir := filepath.Join(dir, ".done")
+	if err := os.MkdirAll(doneDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(doneDir, s.name), []byte("cmd -a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if isDone(doneDir, s, c, "cmd -a") {
+		t.Error("expected a stage without its outputs not to be done")
+	}
+	if err := os.WriteFile(path(c, "burden.txt"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if !isDone(doneDir, s, c, "cmd -a") {
+		t.Error("expected a recorded stage with its outputs to be done")
+	}
+	if isDone(doneDir, s, c, "cmd -b") {
+		t.Error("expected a stage run with other options not to be done")
+	}
+}
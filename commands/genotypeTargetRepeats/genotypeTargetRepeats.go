@@ -0,0 +1,364 @@
+package genotypeTargetRepeats
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/cpus"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/gmm"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/parquet"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/realign"
+	"github.com/dasnellings/duplexTools/repeatcall"
+	"github.com/dasnellings/duplexTools/shard"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"golang.org/x/exp/slices"
+	"io"
+	"log"
+	"math"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"strings"
+	"syscall"
+)
+
+var debug int = 0
+
+// truncatedMarker is the last line of the VCF and lenOut files of an interrupted run. A
+// Parquet lenOut stores it under the "truncated" metadata key instead.
+const truncatedMarker = "#TRUNCATED: genotypeTargetRepeats was interrupted before all targets were genotyped"
+
+func usage() {
+	fmt.Print(
+		"genotypeTargetRepeats - Output a VCF of genotypes of targeted short simple repeats.\n\n" +
+			"options:\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs genotypeTargetRepeats with the options in os.Args.
+func Main() {
+	var inputs inputFiles
+	var sh shard.Shard
+	flag.Var(&inputs, "i", "Input BAM file with alignments. Must be sorted and indexed. Can be declared more than once")
+	var inputDir *string = flag.String("inputDir", "", "Directory with BAM files to be used as inputs. Uses all files in the directory ending with \".bam\". Can be used instead of -i.")
+	var ref *string = flag.String("r", "", "Reference genome. Must be the same reference used for generating the BAM file.")
+	var targets *string = flag.String("t", "", "BED, interval_list, or GFF3 file of targeted repeats. The name (4th column of a BED) must be the sequence of one repeat unit (e.g. CA for a CACACACA repeat), or 'RepeatLen'x'RepeatSeq' (e.g. 10xCA).")
+	var output *string = flag.String("o", "stdout", "Output VCF file.")
+	var lenOut *string = flag.String("lenOut", "", "Output a bed file with additional columns for determined read lengths for each sample. "+
+		"If the file ends in .parquet, write a Parquet table with one row per read (chrom, start, end, repeat, sample, length) instead.")
+	var bamOut *string = flag.String("bamOutPfx", "", "Output a BAM file with realigned reads. Only outputs reads that inform called genotypes. File will be named 'bamOutPfx'_'originalFilename'.")
+	var targetPadding *int = flag.Int("tPad", 50, "Add INT bases of padding to either end of regions in targets file for selecting reads for realignment.")
+	var minFlankOverlap *int = flag.Int("minFlank", 4, "A minimum of INT bases must be mapped on either side of the repeat to be considered an enclosing read.")
+	var minMapQ *int = flag.Int("minMapQ", -1, "Minimum mapping quality (before realignment) to be considered for genotyping. Set to -1 for no filter.")
+	var allowDups *bool = flag.Bool("allowDups", false, "Do not remove duplicate reads when genotyping.")
+	var debugVal *int = flag.Int("debug", 0, "Set to 1 or greater for debug prints.")
+	var minReads *int = flag.Int("minReads", 5, "Minimum total enclosing reads for genotyping.")
+	var alignerThreads *int = flag.Int("alnThreads", 1, "Number of alignment threads. 0 uses every CPU available to the job, respecting container and scheduler CPU limits.")
+	flag.Var(&sh, "shard", "Only genotype `i/n` of the targets (e.g. 3/100) for scatter-gather across cluster jobs. Targets are dealt to shards in turn. Combine the outputs of all shards with mcsMerge.")
+	cpuprofile := flag.String("cpuprofile", "", "write cpu profile to `file`")
+	memprofile := flag.String("memprofile", "", "write memory profile to `file`")
+	dryrun.Parse()
+	flag.Usage = usage
+
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			log.Fatal("could not create CPU profile: ", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatal("could not start CPU profile: ", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	if *inputDir != "" {
+		inputs = getInputsFromDir(*inputDir)
+	}
+
+	if len(inputs) == 0 || *ref == "" {
+		usage()
+		log.Fatalln("ERROR: must input a BAM file with -i")
+	}
+
+	debug = *debugVal
+
+	if *minMapQ > math.MaxUint8 {
+		log.Fatalf("minMapQ out of range. max: %d\n", math.MaxUint8)
+	}
+
+	if *alignerThreads < 0 {
+		log.Fatal("ERROR: alnThreads must be >= 0.")
+	}
+	if *alignerThreads == 0 {
+		*alignerThreads = cpus.Available()
+		log.Printf("Using %d alignment threads.", *alignerThreads)
+	}
+	cpus.LimitMaxProcs()
+
+	opts := repeatcall.Options{
+		TargetPadding:   *targetPadding,
+		MinFlankOverlap: *minFlankOverlap,
+		MinMapQ:         *minMapQ,
+		RemoveDups:      !*allowDups,
+		Debug:           debug,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	genotypeTargetRepeats(ctx, inputs, *ref, *targets, *output, *bamOut, *lenOut, sh, opts, *minReads, *alignerThreads)
+	if ctx.Err() != nil {
+		exit.Fatalf(exit.Interrupted, "interrupted before all targets were genotyped. Output is truncated.")
+	}
+
+	if *memprofile != "" {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			log.Fatal("could not create memory profile: ", err)
+		}
+		defer f.Close() // error handling omitted for example
+		runtime.GC()    // get up-to-date statistics
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Fatal("could not write memory profile: ", err)
+		}
+	}
+}
+
+func getInputsFromDir(dir string) []string {
+	var inputs []string
+	files, err := os.ReadDir(dir)
+	exception.PanicOnErr(err)
+	var absPath string
+	for i := range files {
+		if strings.HasSuffix(files[i].Name(), ".bam") {
+			absPath = filepath.Join(dir, files[i].Name())
+			inputs = append(inputs, absPath)
+		}
+	}
+	return inputs
+}
+
+// genotypeTargetRepeats genotypes each region in targetsFile until all are done or ctx
+// is cancelled, in which case the outputs end with a truncation marker.
+func genotypeTargetRepeats(ctx context.Context, inputFiles []string, refFile, targetsFile, outputFile, bamOutPfx, lenOutFile string, sh shard.Shard, opts repeatcall.Options, minReads int, alignerThreads int) {
+	var ref *fai.Seeker
+	var lenOut *fileio.EasyWriter
+	var lenParquet *parquet.Writer
+	g := new(repeatcall.Genotyper)
+	targets := intervals.Read(targetsFile)
+	refIdx := fai.ReadIndex(refFile + ".fai")
+	intervals.CheckContigs(targets, refIdx, targetsFile)
+	vcfOut := tabix.Create(outputFile)
+	defer cleanup(vcfOut)
+	vcfHeader := repeatcall.VcfHeader(strings.Join(inputFiles, "\t"), refFile)
+	vcf.NewWriteHeader(vcfOut, provenance.Vcf(vcfHeader))
+
+	// get bam reader for each file
+	br := make([]*sam.BamReader, len(inputFiles))
+	headers := make([]sam.Header, len(inputFiles))
+	bamIdxs := make([]sam.Bai, len(inputFiles))
+	for i := range inputFiles {
+		br[i], headers[i] = sam.OpenBam(inputFiles[i])
+		defer cleanup(br[i])
+		bamIdxs[i] = bai.Read(inputFiles[i])
+	}
+
+	bamOutHandle := make([]io.WriteCloser, len(inputFiles))
+	bamOut := make([]*bam.Writer, len(inputFiles))
+	if bamOutPfx != "" {
+		for i := range inputFiles {
+			words := strings.Split(inputFiles[i], "/")
+			words[len(words)-1] = bamOutPfx + "_" + strings.TrimSuffix(words[len(words)-1], ".bam") + sh.Suffix() + ".bam"
+			bamOutHandle[i] = fileio.EasyCreate(words[len(words)-1])
+			bamOut[i] = bam.NewWriter(bamOutHandle[i], provenance.Sam(headers[i]))
+			defer cleanup(bamOutHandle[i])
+			defer cleanup(bamOut[i])
+		}
+	}
+
+	if parquet.IsParquet(lenOutFile) {
+		lenParquet = parquet.Create(lenOutFile, lengthColumns...)
+		defer cleanup(lenParquet)
+	} else if lenOutFile != "" {
+		lenOut = fileio.EasyCreate(lenOutFile)
+		fmt.Fprintf(lenOut, "#CHROM\tSTART\tEND\tREPEAT\t%s\n", strings.Join(inputFiles, "\t"))
+		defer cleanup(lenOut)
+	}
+
+	enclosingReads := make([][]*sam.Sam, len(inputFiles)) // first index is sample
+	observedLengths := make([][]int, len(inputFiles))     // first index is sample
+	var currVcf vcf.Vcf
+	alignerInput := make(chan sam.Sam, 1000)
+	alignerOutput := make(chan sam.Sam, 1000)
+	for j := 0; j < alignerThreads; j++ {
+		ref = fai.NewSeeker(refFile)
+		defer cleanup(ref)
+		go realign.RealignIndels(ctx, alignerInput, alignerOutput, ref)
+	}
+
+	mm := make([]*gmm.MixtureModel, len(inputFiles))
+	tmpMm := make([]*gmm.MixtureModel, len(inputFiles))
+	for i := 0; i < len(inputFiles); i++ {
+		mm[i] = new(gmm.MixtureModel)
+		tmpMm[i] = new(gmm.MixtureModel)
+	}
+
+	var floatSlices [][]float64 = make([][]float64, len(inputFiles))
+	var converged, anyConverged, passingVariant bool
+	var repeatUnit []dna.Base
+	for k, region := range targets {
+		if ctx.Err() != nil {
+			break
+		}
+		if !sh.Keep(k) {
+			continue
+		}
+		repeatUnit, _ = repeatcall.ParseRepeatSeq(region.Name)
+		anyConverged = false
+		for i := range inputFiles {
+			enclosingReads[i], observedLengths[i] = repeatcall.EnclosingReads(ctx, enclosingReads[i], opts, bamIdxs[i], region, br[i], alignerInput, alignerOutput)
+			if bamOutPfx != "" {
+				for j := range enclosingReads[i] {
+					bamOut[i].Write(*enclosingReads[i][j])
+				}
+			}
+			slices.Sort(observedLengths[i])
+
+			converged, tmpMm[i], mm[i] = repeatcall.FitMixtureModel(observedLengths[i], tmpMm[i], mm[i], &floatSlices[i])
+			if converged {
+				anyConverged = true
+			}
+		}
+
+		if !anyConverged || ctx.Err() != nil {
+			continue
+		}
+
+		if lenOut != nil {
+			fmt.Fprintf(lenOut, "%s%s\n", bed.ToString(region, 4), printLengths(observedLengths))
+		}
+		if lenParquet != nil {
+			writeLengths(lenParquet, region, inputFiles, observedLengths)
+		}
+
+		if debug > 0 {
+			//val, counts := sliceToCounts(mm[0].Data)
+			//for i := range val {
+			//	fmt.Printf("%d:%d\t", int(val[i]), counts[i])
+			//}
+			//fmt.Println()
+			g.PrintFits(observedLengths, minReads, mm, len(repeatUnit))
+		}
+
+		currVcf, passingVariant = g.CallGenotypes(ref, region, minReads, enclosingReads, observedLengths, mm)
+		if passingVariant {
+			vcf.WriteVcf(vcfOut, currVcf)
+		}
+	}
+	close(alignerInput) // aligners may still be sending if interrupted, so alignerOutput is left open
+
+	if ctx.Err() != nil {
+		fmt.Fprintln(vcfOut, truncatedMarker)
+		if lenOut != nil {
+			fmt.Fprintln(lenOut, truncatedMarker)
+		}
+		if lenParquet != nil {
+			lenParquet.SetMetadata("truncated", truncatedMarker)
+		}
+	}
+}
+
+func sliceToCounts(s []float64) (val []float64, count []int) {
+	m := make(map[float64]int)
+	for i := range s {
+		m[s[i]]++
+	}
+	for k := range m {
+		val = append(val, k)
+	}
+
+	slices.Sort(val)
+	for i := range val {
+		count = append(count, m[val[i]])
+	}
+	return
+}
+
+// lengthColumns are the columns of a Parquet -lenOut table.
+var lengthColumns = []parquet.Column{
+	{Name: "chrom", Type: parquet.String},
+	{Name: "start", Type: parquet.Int64},
+	{Name: "end", Type: parquet.Int64},
+	{Name: "repeat", Type: parquet.String},
+	{Name: "sample", Type: parquet.String},
+	{Name: "length", Type: parquet.Int64},
+}
+
+// writeLengths writes a row for the observed repeat length of each read in region.
+func writeLengths(w *parquet.Writer, region bed.Bed, samples []string, lengths [][]int) {
+	for i := range lengths {
+		for _, l := range lengths[i] {
+			w.Write(region.Chrom, region.ChromStart, region.ChromEnd, region.Name, samples[i], l)
+		}
+	}
+}
+
+func printLengths(a [][]int) string {
+	if len(a) == 0 {
+		return ""
+	}
+	s := new(strings.Builder)
+	for i := range a {
+		if len(a[i]) == 0 {
+			s.WriteString("\tNA")
+			continue
+		}
+		s.WriteString(fmt.Sprintf("\t%d", a[i][0]))
+		for j := 1; j < len(a[i]); j++ {
+			s.WriteString(fmt.Sprintf(",%d", a[i][j]))
+		}
+	}
+	return s.String()
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
+
+func min(a, b float64) float64 {
+	if a < b {
+		return a
+	} else {
+		return b
+	}
+}
@@ -0,0 +1,252 @@
+package makeExcludeBed
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fasta"
+	"github.com/vertgenlab/gonomics/fileio"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"makeExcludeBed - Build an exclude bed for mcsCallVariants from standard sources of false positive calls.\n" +
+			"Regions from all inputs are padded, sorted, and merged. The name column of the output lists the sources of each region:\n" +
+			"\tblacklist: regions from -blacklist (e.g. the ENCODE blacklist).\n" +
+			"\tlowMappability: intervals from the -mappability bedGraph with a value below -minMappability.\n" +
+			"\tgap: runs of N in the -r reference of at least -minGap bases.\n" +
+			"\tsegdup: regions from -segdup (e.g. UCSC genomicSuperDups). A leading UCSC bin column is ignored.\n" +
+			"Usage:\n" +
+			"makeExcludeBed [options] -blacklist blacklist.bed -mappability k100.bedGraph -r ref.fasta -segdup segdups.bed > exclude.bed\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs makeExcludeBed with the options in os.Args.
+func Main() {
+	var blacklists, segdups inputFiles
+	flag.Var(&blacklists, "blacklist", "Bed file of blacklisted regions. May be declared more than once.")
+	mappability := flag.String("mappability", "", "BedGraph of mappability scores (e.g. Umap/Bismap or GEM tracks converted with bigWigToBedGraph).")
+	minMappability := flag.Float64("minMappability", 1, "Exclude intervals in -mappability with a score below this value.")
+	ref := flag.String("r", "", "Reference FASTA file. Runs of N are excluded as reference gaps and region ends are limited to the chromosome length.")
+	minGap := flag.Int("minGap", 1, "Minimum length of a run of N in the reference to exclude.")
+	flag.Var(&segdups, "segdup", "Bed file of segmental duplications. May be declared more than once.")
+	pad := flag.Int("pad", 0, "Number of bases to add to both sides of each region before merging.")
+	output := flag.String("o", "stdout", "Output bed file.")
+	dryrun.Parse()
+
+	if len(blacklists) == 0 && *mappability == "" && *ref == "" && len(segdups) == 0 {
+		usage()
+		log.Fatal("ERROR: must specify at least one of -blacklist, -mappability, -r, or -segdup.")
+	}
+
+	if *pad < 0 || *minGap < 1 {
+		usage()
+		log.Fatal("ERROR: -pad must be >= 0 and -minGap must be >= 1.")
+	}
+
+	makeExcludeBed(blacklists, segdups, *mappability, *ref, *output, *minMappability, *minGap, *pad)
+}
+
+func makeExcludeBed(blacklists, segdups []string, mappability, ref, output string, minMappability float64, minGap, pad int) {
+	var regions []bed.Bed
+	var chromSizes map[string]int
+	for _, f := range blacklists {
+		regions = readRegions(f, "blacklist", regions)
+	}
+	if mappability != "" {
+		regions = readLowMappability(mappability, minMappability, regions)
+	}
+	if ref != "" {
+		chromSizes = make(map[string]int)
+		regions = findGaps(ref, minGap, chromSizes, regions)
+	}
+	for _, f := range segdups {
+		regions = readRegions(f, "segdup", regions)
+	}
+
+	var size int
+	for i := range regions {
+		regions[i].ChromStart = max(regions[i].ChromStart-pad, 0)
+		regions[i].ChromEnd += pad
+		if size = chromSizes[regions[i].Chrom]; size > 0 {
+			regions[i].ChromEnd = min(regions[i].ChromEnd, size)
+		}
+	}
+
+	merged := mergeRegions(regions)
+	out := tabix.Create(output)
+	defer cleanup(out)
+	var total int
+	for i := range merged {
+		bed.WriteBed(out, merged[i])
+		total += merged[i].ChromEnd - merged[i].ChromStart
+	}
+	log.Printf("Wrote %d regions covering %d bases.\n", len(merged), total)
+}
+
+// readRegions appends the regions in a bed file to ans with the name set to source.
+// A leading bin column as in UCSC tables (e.g. genomicSuperDups.txt) is skipped.
+func readRegions(file, source string, ans []bed.Bed) []bed.Bed {
+	in := fileio.EasyOpen(file)
+	var words []string
+	var offset int
+	for line, done := fileio.EasyNextRealLine(in); !done; line, done = fileio.EasyNextRealLine(in) {
+		if strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+		words = strings.Split(line, "\t")
+		offset = 0
+		if len(words) > 3 && isInt(words[0]) && !isInt(words[1]) && isInt(words[2]) && isInt(words[3]) {
+			offset = 1
+		}
+		if len(words) < offset+3 {
+			exit.Fatalf(exit.MalformedInput, "malformed line in %s:\n%s\n", file, line)
+		}
+		ans = append(ans, bed.Bed{Chrom: words[offset], ChromStart: atoi(words[offset+1], file), ChromEnd: atoi(words[offset+2], file), Name: source, FieldsInitialized: 4})
+	}
+	err := in.Close()
+	exception.PanicOnErr(err)
+	return ans
+}
+
+// readLowMappability appends the intervals in a bedGraph with a value below minMappability to ans.
+func readLowMappability(file string, minMappability float64, ans []bed.Bed) []bed.Bed {
+	in := fileio.EasyOpen(file)
+	var words []string
+	var score float64
+	var err error
+	for line, done := fileio.EasyNextRealLine(in); !done; line, done = fileio.EasyNextRealLine(in) {
+		if strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+		words = strings.Fields(line)
+		if len(words) < 4 {
+			exit.Fatalf(exit.MalformedInput, "malformed line in %s. Mappability must be a bedGraph with 4 columns:\n%s\n", file, line)
+		}
+		score, err = strconv.ParseFloat(words[3], 64)
+		if err != nil {
+			exit.Fatalf(exit.MalformedInput, "could not parse mappability score in %s:\n%s\n", file, line)
+		}
+		if score < minMappability {
+			ans = append(ans, bed.Bed{Chrom: words[0], ChromStart: atoi(words[1], file), ChromEnd: atoi(words[2], file), Name: "lowMappability", FieldsInitialized: 4})
+		}
+	}
+	err = in.Close()
+	exception.PanicOnErr(err)
+	return ans
+}
+
+// findGaps appends runs of at least minGap N bases in the reference to ans and records the length of each chromosome in chromSizes.
+func findGaps(ref string, minGap int, chromSizes map[string]int, ans []bed.Bed) []bed.Bed {
+	in := fileio.EasyOpen(ref)
+	var gapStart int
+	for rec, done := fasta.NextFasta(in); !done; rec, done = fasta.NextFasta(in) {
+		chromSizes[rec.Name] = len(rec.Seq)
+		gapStart = -1
+		for i := 0; i <= len(rec.Seq); i++ {
+			if i < len(rec.Seq) && (rec.Seq[i] == dna.N || rec.Seq[i] == dna.LowerN) {
+				if gapStart == -1 {
+					gapStart = i
+				}
+				continue
+			}
+			if gapStart != -1 && i-gapStart >= minGap {
+				ans = append(ans, bed.Bed{Chrom: rec.Name, ChromStart: gapStart, ChromEnd: i, Name: "gap", FieldsInitialized: 4})
+			}
+			gapStart = -1
+		}
+	}
+	err := in.Close()
+	exception.PanicOnErr(err)
+	return ans
+}
+
+// mergeRegions sorts and merges overlapping or adjacent regions. The name of each merged region is the sorted set of input names.
+func mergeRegions(regions []bed.Bed) []bed.Bed {
+	if len(regions) == 0 {
+		return regions
+	}
+	bed.SortByCoord(regions)
+	ans := make([]bed.Bed, 0, len(regions))
+	curr := regions[0]
+	sources := map[string]bool{curr.Name: true}
+	for i := 1; i <= len(regions); i++ {
+		if i < len(regions) && regions[i].Chrom == curr.Chrom && regions[i].ChromStart <= curr.ChromEnd {
+			curr.ChromEnd = max(curr.ChromEnd, regions[i].ChromEnd)
+			sources[regions[i].Name] = true
+			continue
+		}
+		curr.Name = joinSources(sources)
+		ans = append(ans, curr)
+		if i < len(regions) {
+			curr = regions[i]
+			sources = map[string]bool{curr.Name: true}
+		}
+	}
+	return ans
+}
+
+func joinSources(sources map[string]bool) string {
+	names := make([]string, 0, len(sources))
+	for s := range sources {
+		names = append(names, s)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func isInt(s string) bool {
+	_, err := strconv.Atoi(s)
+	return err == nil
+}
+
+func atoi(s, file string) int {
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		exit.Fatalf(exit.MalformedInput, "could not parse coordinate '%s' in %s.\n", s, file)
+	}
+	return i
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
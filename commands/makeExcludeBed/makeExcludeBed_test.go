@@ -0,0 +1,52 @@
+package makeExcludeBed
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMakeExcludeBed(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"ref.fa":         ">chr1\nACGTACGTACNNNNNACGTACGTACNNACGTACGTACGT\n", // gaps at 10-15 and 25-27
+		"blacklist.bed":  "track name=blacklist\nchr1\t30\t38\n",
+		"segdups.txt":    "585\tchr2\t100\t120\tchr3\n", // UCSC bin column
+		"mappability.bg": "chr1\t0\t5\t0.2\nchr1\t5\t9\t0.9\nchr1\t16\t18\t0.1\n",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output := filepath.Join(dir, "exclude.bed")
+	makeExcludeBed([]string{filepath.Join(dir, "blacklist.bed")}, []string{filepath.Join(dir, "segdups.txt")}, filepath.Join(dir, "mappability.bg"), filepath.Join(dir, "ref.fa"), output, 0.5, 3, 2)
+	actual, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "chr1\t0\t7\tlowMappability\nchr1\t8\t20\tgap,lowMappability\nchr1\t28\t39\tblacklist\nchr2\t98\t122\tsegdup\n"
+	if string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestMergeRegions(t *testing.T) {
+	regions := []bed.Bed{
+		{Chrom: "chr1", ChromStart: 50, ChromEnd: 60, Name: "b"},
+		{Chrom: "chr1", ChromStart: 0, ChromEnd: 10, Name: "b"},
+		{Chrom: "chr1", ChromStart: 10, ChromEnd: 20, Name: "a"}, // adjacent
+		{Chrom: "chr1", ChromStart: 5, ChromEnd: 8, Name: "c"},   // contained
+		{Chrom: "chr2", ChromStart: 0, ChromEnd: 10, Name: "a"},
+	}
+	expected := []bed.Bed{
+		{Chrom: "chr1", ChromStart: 0, ChromEnd: 20, Name: "a,b,c"},
+		{Chrom: "chr1", ChromStart: 50, ChromEnd: 60, Name: "b"},
+		{Chrom: "chr2", ChromStart: 0, ChromEnd: 10, Name: "a"},
+	}
+	if actual := mergeRegions(regions); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
@@ -0,0 +1,322 @@
+package mcsAnnotate
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsAnnotate - Annotate variant calls with the gene compartment they fall in and any overlapping regions of interest.\n" +
+			"Genes are read from a GTF or GFF3 file (-g). Each variant is assigned the highest priority compartment among\n" +
+			"all overlapping transcripts in the order CDS > UTR > exon > intron > intergenic, and the following INFO fields\n" +
+			"are appended: GENE (overlapping gene names), REGION (compartment), GENE_STRAND (+, -, or . if genes on both strands).\n" +
+			"Variants overlapping a region in a -roi bed file are given ROI=name1,name2 using the bed name column, or the\n" +
+			"file name if the bed has no name column.\n" +
+			"Usage:\n" +
+			"mcsAnnotate [options] -i calls.vcf -g genes.gtf -roi regions.bed > annotated.vcf\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsAnnotate with the options in os.Args.
+func Main() {
+	var roiFiles inputFiles
+	input := flag.String("i", "", "Input VCF file.")
+	geneFile := flag.String("g", "", "GTF or GFF3 file with gene annotations. Must contain exon features, and CDS and UTR features for coding annotation.")
+	output := flag.String("o", "stdout", "Output VCF file.")
+	flag.Var(&roiFiles, "roi", "Bed, interval_list, or GFF3 file with regions of interest. May be declared more than once.")
+	dryrun.Parse()
+
+	if *input == "" {
+		usage()
+		log.Fatal("ERROR: must specify input vcf (-i).")
+	}
+
+	if *geneFile == "" && len(roiFiles) == 0 {
+		usage()
+		log.Fatal("ERROR: must specify a gene annotation file (-g) and/or region of interest bed (-roi).")
+	}
+
+	mcsAnnotate(*input, *geneFile, *output, roiFiles)
+}
+
+// compartment of a gene feature. Higher values take priority when a variant overlaps more than one feature.
+type compartment int
+
+const (
+	intergenic compartment = iota
+	intron
+	exon
+	utr
+	cds
+)
+
+var compartmentNames = []string{"intergenic", "intron", "exon", "UTR", "CDS"}
+
+// feature is a single gene, transcript, exon, UTR, or CDS record from the annotation file.
+type feature struct {
+	chr    string
+	start  int // 0-based
+	end    int // exclusive
+	region compartment
+	gene   string
+	strand byte
+}
+
+func (f *feature) GetChrom() string {
+	return f.chr
+}
+
+func (f *feature) GetChromStart() int {
+	return f.start
+}
+
+func (f *feature) GetChromEnd() int {
+	return f.end
+}
+
+// roi is a region of interest with the name reported in the ROI INFO field.
+type roi struct {
+	b    bed.Bed
+	name string
+}
+
+func (r *roi) GetChrom() string {
+	return r.b.Chrom
+}
+
+func (r *roi) GetChromStart() int {
+	return r.b.ChromStart
+}
+
+func (r *roi) GetChromEnd() int {
+	return r.b.ChromEnd
+}
+
+func mcsAnnotate(input, geneFile, output string, roiFiles []string) {
+	var geneTree, roiTree map[string]*interval.IntervalNode
+	if geneFile != "" {
+		geneTree = interval.BuildTree(readFeatures(geneFile))
+	}
+	if len(roiFiles) > 0 {
+		roiTree = interval.BuildTree(readRois(roiFiles))
+	}
+
+	records, header := vcf.GoReadToChan(input)
+	out := tabix.Create(output)
+	defer cleanup(out)
+	vcf.NewWriteHeader(out, provenance.Vcf(addInfoHeader(header, geneTree != nil, roiTree != nil)))
+
+	counts := make([]int, len(compartmentNames))
+	var inRoi int
+	var q bed.Bed
+	var overlaps []interval.Interval
+	var region compartment
+	var genes, rois []string
+	var strand byte
+	var f *feature
+	var r *roi
+	for v := range records {
+		q = bed.Bed{Chrom: v.Chr, ChromStart: v.Pos - 1, ChromEnd: v.Pos - 1 + len(v.Ref), FieldsInitialized: 3}
+		if geneTree != nil {
+			region, genes, strand = intergenic, genes[:0], 0
+			overlaps = interval.Query(geneTree, q, "any")
+			for i := range overlaps {
+				f = overlaps[i].(*feature)
+				if f.region > region {
+					region = f.region
+				}
+				genes = appendUnique(genes, f.gene)
+				switch {
+				case strand == 0:
+					strand = f.strand
+				case strand != f.strand:
+					strand = '.'
+				}
+			}
+			if len(genes) > 0 && region == intergenic { // overlaps a gene or transcript record but no exon
+				region = intron
+			}
+			counts[region]++
+			v.Info = appendInfo(v.Info, "REGION="+compartmentNames[region])
+			if len(genes) > 0 {
+				v.Info = appendInfo(v.Info, "GENE="+strings.Join(genes, ","))
+				v.Info = appendInfo(v.Info, "GENE_STRAND="+string(strand))
+			}
+		}
+		if roiTree != nil {
+			rois = rois[:0]
+			overlaps = interval.Query(roiTree, q, "any")
+			for i := range overlaps {
+				r = overlaps[i].(*roi)
+				rois = appendUnique(rois, r.name)
+			}
+			if len(rois) > 0 {
+				inRoi++
+				v.Info = appendInfo(v.Info, "ROI="+strings.Join(rois, ","))
+			}
+		}
+		vcf.WriteVcf(out, v)
+	}
+
+	if geneTree != nil {
+		for i := range counts {
+			log.Printf("%s variants: %d\n", compartmentNames[i], counts[i])
+		}
+	}
+	if roiTree != nil {
+		log.Printf("Variants in regions of interest: %d\n", inRoi)
+	}
+}
+
+// readFeatures parses a GTF or GFF3 file. Only the columns needed for annotation are used,
+// so any gene model that names its exons, CDS, and UTRs with standard feature types is supported.
+func readFeatures(filename string) []interval.Interval {
+	var ans []interval.Interval
+	var fields []string
+	var f *feature
+	var start, end int
+	var err error
+	file := fileio.EasyOpen(filename)
+	for line, done := fileio.EasyNextRealLine(file); !done; line, done = fileio.EasyNextRealLine(file) {
+		fields = strings.Split(line, "\t")
+		if len(fields) < 9 {
+			continue
+		}
+		f = &feature{chr: fields[0], strand: fields[6][0]}
+		switch fields[2] {
+		case "CDS", "start_codon", "stop_codon":
+			f.region = cds
+		case "UTR", "five_prime_UTR", "three_prime_UTR", "5UTR", "3UTR":
+			f.region = utr
+		case "exon":
+			f.region = exon
+		case "gene", "transcript", "mRNA":
+			f.region = intergenic // promoted to intron if no exon overlaps
+		default:
+			continue
+		}
+		start, err = strconv.Atoi(fields[3])
+		exception.PanicOnErr(err)
+		end, err = strconv.Atoi(fields[4])
+		exception.PanicOnErr(err)
+		f.start, f.end = start-1, end
+		f.gene = geneName(fields[8])
+		ans = append(ans, f)
+	}
+	err = file.Close()
+	exception.PanicOnErr(err)
+	if len(ans) == 0 {
+		log.Fatalf("ERROR: no gene features found in %s.", filename)
+	}
+	return ans
+}
+
+// geneName returns the gene name from a GTF (gene_name "X";) or GFF3 (gene_name=X;) attribute column,
+// falling back to the gene id and then the parent/ID when no name is present.
+func geneName(attributes string) string {
+	vals := make(map[string]string)
+	var key, val string
+	var found bool
+	for _, attr := range strings.Split(attributes, ";") {
+		attr = strings.TrimSpace(attr)
+		if key, val, found = strings.Cut(attr, "="); !found {
+			if key, val, found = strings.Cut(attr, " "); !found {
+				continue
+			}
+		}
+		vals[key] = strings.Trim(val, "\"")
+	}
+	for _, key = range []string{"gene_name", "gene", "Name", "gene_id", "Parent", "ID"} {
+		if val = vals[key]; val != "" {
+			return val
+		}
+	}
+	return "."
+}
+
+func readRois(roiFiles []string) []interval.Interval {
+	var ans []interval.Interval
+	var name string
+	for i := range roiFiles {
+		name = intervals.Name(roiFiles[i])
+		for _, b := range intervals.Read(roiFiles[i]) {
+			if b.FieldsInitialized >= 4 && b.Name != "" {
+				ans = append(ans, &roi{b: b, name: b.Name})
+			} else {
+				ans = append(ans, &roi{b: b, name: name})
+			}
+		}
+	}
+	return ans
+}
+
+// addInfoHeader inserts the ##INFO lines for the added fields before the #CHROM line.
+func addInfoHeader(header vcf.Header, genes, rois bool) vcf.Header {
+	var newLines []string
+	if genes {
+		newLines = append(newLines, "##INFO=<ID=REGION,Number=1,Type=String,Description=\"Gene compartment of the variant (CDS, UTR, exon, intron, or intergenic)\">")
+		newLines = append(newLines, "##INFO=<ID=GENE,Number=.,Type=String,Description=\"Names of overlapping genes\">")
+		newLines = append(newLines, "##INFO=<ID=GENE_STRAND,Number=1,Type=String,Description=\"Strand of overlapping genes (. if genes on both strands)\">")
+	}
+	if rois {
+		newLines = append(newLines, "##INFO=<ID=ROI,Number=.,Type=String,Description=\"Names of overlapping regions of interest\">")
+	}
+	var ans vcf.Header
+	ans.Text = make([]string, 0, len(header.Text)+len(newLines))
+	for i := range header.Text {
+		if strings.HasPrefix(header.Text[i], "#CHROM") {
+			ans.Text = append(ans.Text, newLines...)
+		}
+		ans.Text = append(ans.Text, header.Text[i])
+	}
+	return ans
+}
+
+func appendInfo(info, field string) string {
+	if info == "" || info == "." {
+		return field
+	}
+	return info + ";" + field
+}
+
+func appendUnique(s []string, val string) []string {
+	for i := range s {
+		if s[i] == val {
+			return s
+		}
+	}
+	return append(s, val)
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
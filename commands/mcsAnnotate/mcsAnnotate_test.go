@@ -0,0 +1,73 @@
+package mcsAnnotate
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGeneName(t *testing.T) {
+	tests := []struct {
+		attributes string
+		expected   string
+	}{
+		{`gene_id "ENSG1"; gene_name "TP53"; transcript_id "ENST1";`, "TP53"},
+		{`gene_id "ENSG1"; transcript_id "ENST1";`, "ENSG1"},
+		{"ID=exon1;Parent=tx1;gene=KRAS", "KRAS"},
+		{"ID=exon1;Parent=tx1", "tx1"},
+		{"", "."},
+	}
+	for _, test := range tests {
+		if actual := geneName(test.attributes); actual != test.expected {
+			t.Errorf("expected %s from %s, got %s", test.expected, test.attributes, actual)
+		}
+	}
+}
+
+func TestMcsAnnotate(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "calls.vcf")
+	genes := filepath.Join(dir, "genes.gtf")
+	rois := filepath.Join(dir, "hotspots.bed")
+	output := filepath.Join(dir, "annotated.vcf")
+	err := os.WriteFile(input, []byte("##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n"+
+		"chr1\t50\t.\tA\tC\t.\t.\t.\n"+ // intergenic
+		"chr1\t150\t.\tA\tC\t.\t.\tDS\n"+ // intron
+		"chr1\t210\t.\tA\tC\t.\t.\t.\n"+ // CDS within the exon
+		"chr1\t290\t.\tA\tC\t.\t.\t.\n"), 0644) // UTR, and in both genes
+	if err == nil {
+		err = os.WriteFile(genes, []byte(
+			"chr1\ttest\tgene\t100\t300\t.\t+\t.\tgene_name \"A\";\n"+
+				"chr1\ttest\texon\t200\t300\t.\t+\t.\tgene_name \"A\";\n"+
+				"chr1\ttest\tCDS\t200\t250\t.\t+\t.\tgene_name \"A\";\n"+
+				"chr1\ttest\tthree_prime_UTR\t251\t300\t.\t+\t.\tgene_name \"A\";\n"+
+				"chr1\ttest\tgene\t280\t400\t.\t-\t.\tgene_name \"B\";\n"), 0644)
+	}
+	if err == nil {
+		err = os.WriteFile(rois, []byte("chr1\t200\t220\n"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcsAnnotate(input, genes, output, []string{rois})
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info []string
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, "#") {
+			info = append(info, strings.Split(line, "\t")[7])
+		}
+	}
+	expected := []string{
+		"REGION=intergenic",
+		"DS;REGION=intron;GENE=A;GENE_STRAND=+",
+		"REGION=CDS;GENE=A;GENE_STRAND=+;ROI=hotspots",
+		"REGION=UTR;GENE=A,B;GENE_STRAND=.",
+	}
+	if strings.Join(info, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected INFO:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(info, "\n"))
+	}
+}
@@ -0,0 +1,226 @@
+package mcsBamSubset
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsBamSubset - Extract reads from a bam by any combination of region, read family (RF), strand (RS), and mapping quality.\n" +
+			"The output is a coordinate sorted bam with an index (.bai) for debugging in IGV or sharing minimal reproducers of caller issues.\n" +
+			"Regions are read using the index of the input bam (.bai). Without regions the whole bam is read and must be coordinate sorted.\n" +
+			"Usage:\n" +
+			"mcsBamSubset [options] -i annotated.bam -region chr1:1000000-1000100 -strand W -o subset.bam\n" +
+			"mcsBamSubset [options] -i annotated.bam -families ids.txt -minMapQ 20 -o subset.bam\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsBamSubset with the options in os.Args.
+func Main() {
+	var regions, ids inputFiles
+	input := flag.String("i", "", "Input bam file.")
+	output := flag.String("o", "", "Output bam file. An index is written to the same path with a .bai suffix.")
+	flag.Var(&regions, "region", "Region to extract formatted as chr:start-end (1-based, inclusive) or chr. May be declared more than once.")
+	bedFile := flag.String("b", "", "Bed, interval_list, or GFF3 file of regions to extract.")
+	flag.Var(&ids, "family", "Read family ID (RF tag) to extract. May be declared more than once.")
+	familyFile := flag.String("families", "", "File with one read family ID (RF tag) per line to extract.")
+	strand := flag.String("strand", "", "Only extract reads from this strand of the family (RS tag). Must be W or C.")
+	minMapQ := flag.Int("minMapQ", 0, "Minimum mapping quality of extracted reads.")
+	dryrun.Parse()
+
+	if *input == "" || *output == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i) and output bam (-o).")
+	}
+
+	if !strings.HasSuffix(*output, ".bam") {
+		usage()
+		log.Fatal("ERROR: output file (-o) must end in .bam.")
+	}
+
+	if *strand != "" && *strand != "W" && *strand != "C" {
+		usage()
+		log.Fatal("ERROR: -strand must be W or C.")
+	}
+
+	if *familyFile != "" {
+		ids = append(ids, fileio.Read(*familyFile)...)
+	}
+
+	s := subsetParams{
+		families: make(map[string]bool),
+		minMapQ:  uint8(*minMapQ),
+	}
+	if *strand != "" {
+		s.strand = (*strand)[0]
+	}
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			s.families[id] = true
+		}
+	}
+
+	var regionBeds []bed.Bed
+	for _, r := range regions {
+		b, err := intervals.ParseRegion(r)
+		if err != nil {
+			log.Fatalf("ERROR: %s.", err)
+		}
+		regionBeds = append(regionBeds, b)
+	}
+	if *bedFile != "" {
+		regionBeds = append(regionBeds, intervals.Read(*bedFile)...)
+	}
+
+	mcsBamSubset(*input, *output, regionBeds, s)
+}
+
+// subsetParams stores the read filters.
+type subsetParams struct {
+	families map[string]bool // empty to keep all families
+	strand   byte            // W, C, or 0 for both
+	minMapQ  uint8
+}
+
+func mcsBamSubset(input, output string, regions []bed.Bed, s subsetParams) {
+	br, header := sam.OpenBam(input)
+	defer cleanup(br)
+	out := fileio.EasyCreate(output)
+	bw := bam.NewWriter(out, provenance.Sam(header))
+
+	var written int
+	if len(regions) == 0 {
+		written = subsetAll(br, bw, s)
+	} else {
+		written = subsetRegions(br, bw, bai.Read(input), header, regions, s)
+	}
+
+	// both must be closed before the output can be indexed
+	cleanup(bw)
+	cleanup(out)
+	log.Printf("Wrote %d reads.\n", written)
+	bai.WriteIndex(output)
+}
+
+// subsetAll reads the whole bam and writes passing reads.
+func subsetAll(br *sam.BamReader, bw *bam.Writer, s subsetParams) int {
+	var r sam.Sam
+	var err error
+	var written int
+	for {
+		_, err = sam.DecodeBam(br, &r)
+		if err == io.EOF {
+			break
+		}
+		exception.PanicOnErr(err)
+		if passes(&r, s) {
+			bw.Write(r)
+			written++
+		}
+	}
+	return written
+}
+
+// subsetRegions reads each region from the input bam and writes passing reads in coordinate order.
+// Reads overlapping more than one region are written once.
+func subsetRegions(br *sam.BamReader, bw *bam.Writer, index sam.Bai, header sam.Header, regions []bed.Bed, s subsetParams) int {
+	order := make(map[string]int, len(header.Chroms))
+	for i := range header.Chroms {
+		order[header.Chroms[i].Name] = i
+	}
+	for i := range regions {
+		idx, found := order[regions[i].Chrom]
+		if !found {
+			exit.Fatalf(exit.ContigMismatch, "%s was not found in the bam header.", regions[i].Chrom)
+		}
+		if regions[i].ChromEnd > header.Chroms[idx].Size {
+			regions[i].ChromEnd = header.Chroms[idx].Size
+		}
+		regions[i].FieldsInitialized = 3
+	}
+	regions = bed.MergeBeds(regions)
+	sort.SliceStable(regions, func(i, j int) bool {
+		if regions[i].Chrom != regions[j].Chrom {
+			return order[regions[i].Chrom] < order[regions[j].Chrom]
+		}
+		return regions[i].ChromStart < regions[j].ChromStart
+	})
+
+	var reads []sam.Sam
+	var prevChrom string
+	var prevEnd, written int
+	for _, region := range regions {
+		if region.Chrom != prevChrom {
+			prevEnd = 0
+		}
+		reads = sam.SeekBamRegionRecycle(br, index, region.Chrom, uint32(region.ChromStart), uint32(region.ChromEnd), reads)
+		sort.SliceStable(reads, func(i, j int) bool { return reads[i].Pos < reads[j].Pos })
+		for i := range reads {
+			if reads[i].GetChromStart() < prevEnd { // already written from the previous region
+				continue
+			}
+			if passes(&reads[i], s) {
+				bw.Write(reads[i])
+				written++
+			}
+		}
+		prevChrom, prevEnd = region.Chrom, region.ChromEnd
+	}
+	return written
+}
+
+// passes returns true if the read passes the family, strand, and mapping quality filters.
+func passes(r *sam.Sam, s subsetParams) bool {
+	if r.MapQ < s.minMapQ {
+		return false
+	}
+	if len(s.families) == 0 && s.strand == 0 {
+		return true
+	}
+	sam.ParseExtra(r)
+	if len(s.families) > 0 {
+		rf := barcode.GetRF(r)
+		if idx := strings.IndexByte(rf, '\t'); idx != -1 {
+			rf = rf[:idx]
+		}
+		if !s.families[rf] {
+			return false
+		}
+	}
+	return s.strand == 0 || barcode.GetRS(r) == s.strand
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,92 @@
+package mcsBamSubset
+
+import (
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestBam(t *testing.T, filename string) {
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 1000}, {Name: "chr2", Size: 1000, Order: 1}}, nil, sam.Coordinate, sam.None)
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(name, chrom string, pos uint32, mapQ uint8, tags string) sam.Sam {
+		return sam.Sam{QName: name, MapQ: mapQ, RName: chrom, Pos: pos, Cigar: cigar.FromString("10M"), RNext: "*",
+			Seq: dna.StringToBases("ACGTACGTAC"), Qual: "IIIIIIIIII", Extra: tags}
+	}
+	w := sam.NewBamWriter(file, header)
+	for _, r := range []sam.Sam{
+		read("a", "chr1", 10, 60, "RS:Z:W\tRF:Z:1"),
+		read("b", "chr1", 50, 60, "RS:Z:C\tRF:Z:1"),
+		read("c", "chr1", 100, 10, "RS:Z:W\tRF:Z:2"),
+		read("d", "chr1", 500, 60, "RS:Z:W\tRF:Z:2"),
+		read("e", "chr2", 10, 60, "RS:Z:C\tRF:Z:3"),
+	} {
+		sam.WriteToBamFileHandle(w, r, 0)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bai.WriteIndex(filename)
+}
+
+func readNames(t *testing.T, filename string) string {
+	br, _ := sam.OpenBam(filename)
+	defer br.Close()
+	var r sam.Sam
+	var names string
+	for {
+		_, err := sam.DecodeBam(br, &r)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names += r.QName
+	}
+	return names
+}
+
+func TestMcsBamSubset(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.bam")
+	writeTestBam(t, input)
+	region := func(chrom string, start, end int) bed.Bed {
+		return bed.Bed{Chrom: chrom, ChromStart: start, ChromEnd: end}
+	}
+	tests := []struct {
+		regions  []bed.Bed
+		s        subsetParams
+		expected string
+	}{
+		{nil, subsetParams{families: map[string]bool{"1": true}}, "ab"},
+		{nil, subsetParams{families: map[string]bool{"1": true, "2": true}, strand: 'W'}, "acd"},
+		{nil, subsetParams{minMapQ: 20}, "abde"},
+		{[]bed.Bed{region("chr2", 0, 100), region("chr1", 0, 60), region("chr1", 40, 120)}, subsetParams{}, "abce"}, // in header order
+		{[]bed.Bed{region("chr1", 0, 55), region("chr1", 58, 70)}, subsetParams{}, "ab"},                            // b overlaps both
+		{[]bed.Bed{region("chr1", 400, 2000)}, subsetParams{strand: 'C'}, ""},                                       // past the end of chr1
+	}
+	for i, test := range tests {
+		output := filepath.Join(dir, "out.bam")
+		mcsBamSubset(input, output, test.regions, test.s)
+		if actual := readNames(t, output); actual != test.expected {
+			t.Errorf("test %d: expected reads %q, got %q", i, test.expected, actual)
+		}
+		if _, err := os.Stat(output + ".bai"); err != nil {
+			t.Errorf("test %d: expected the output to be indexed", i)
+		}
+	}
+}
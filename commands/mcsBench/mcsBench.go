@@ -0,0 +1,167 @@
+package mcsBench
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/commands/annotateReadFamilies"
+	"github.com/dasnellings/duplexTools/commands/mcsCallVariants"
+	"github.com/dasnellings/duplexTools/commands/mcsQc"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/tmp"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"time"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsBench - Time the main duplexTools commands on a simulated dataset.\n" +
+			"A random reference and a bam of duplex read families are simulated, then annotateReadFamilies,\n" +
+			"indexing, mcsCallVariants, and mcsQc are run in turn. Wall time and memory allocation are\n" +
+			"reported for each stage as a TSV so results can be compared between releases, option settings, and machines.\n" +
+			"Usage:\n" +
+			"mcsBench [options] > bench.tsv\n" +
+			"duplexTools bench [options] > bench.tsv\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsBench with the options in os.Args.
+func Main() {
+	output := flag.String("o", "stdout", "Output TSV file with one line per stage and run.")
+	families := flag.Int("families", 20000, "Number of read families to simulate.")
+	depth := flag.Int("depth", 4, "Read pairs simulated for each strand of a family.")
+	readLen := flag.Int("readLen", 150, "Read length of simulated reads.")
+	chromSize := flag.Int("chromSize", 2_000_000, "Length of each of the two simulated chromosomes.")
+	runs := flag.Int("runs", 1, "Number of times to run each command on the simulated data.")
+	threads := flag.Int("threads", 1, "Number of threads passed to mcsCallVariants. 0 uses every CPU available to the job.")
+	seed := flag.Int64("seed", 1, "Seed for the random number generator. The same seed and options simulate the same data.")
+	keep := flag.String("keep", "", "Write the simulated data and command outputs to this directory and keep them. By default they are removed when the benchmark finishes.")
+	dryrun.Parse()
+
+	if *families < 1 || *depth < 1 || *runs < 1 {
+		usage()
+		log.Fatal("ERROR: -families, -depth, and -runs must be at least 1.")
+	}
+	if *chromSize < 3**readLen {
+		usage()
+		log.Fatal("ERROR: -chromSize must be at least 3 times -readLen.")
+	}
+
+	dir := tmp.Dir()
+	if *keep != "" {
+		dir = *keep
+		exception.PanicOnErr(os.MkdirAll(dir, 0755))
+	}
+
+	s := simulation{
+		chroms:       2,
+		chromSize:    *chromSize,
+		families:     *families,
+		pairsPerSide: *depth,
+		readLen:      *readLen,
+		variantRate:  0.02,
+		errorRate:    0.001,
+		seed:         *seed,
+	}
+	mcsBench(dir, s, *runs, *threads, *output)
+}
+
+// stage is the measured cost of one stage of the benchmark.
+type stage struct {
+	name    string
+	run     int
+	wall    time.Duration
+	alloc   uint64 // bytes allocated
+	mallocs uint64
+	gcs     uint32
+	reads   int // reads processed, 0 if not meaningful
+}
+
+func mcsBench(dir string, s simulation, runs, threads int, output string) {
+	out := fileio.EasyCreate(output)
+	defer func() {
+		exception.PanicOnErr(out.Close())
+	}()
+	_, err := fmt.Fprintln(out, "stage\trun\tseconds\tallocMB\tmallocs\tgcCycles\treadsPerSec")
+	exception.PanicOnErr(err)
+
+	write := func(st stage) {
+		var rate string
+		if st.reads > 0 {
+			rate = strconv.FormatFloat(float64(st.reads)/st.wall.Seconds(), 'f', 0, 64)
+		}
+		_, err := fmt.Fprintf(out, "%s\t%d\t%.3f\t%.1f\t%d\t%d\t%s\n", st.name, st.run, st.wall.Seconds(),
+			float64(st.alloc)/(1<<20), st.mallocs, st.gcs, rate)
+		exception.PanicOnErr(err)
+		log.Printf("%s (run %d): %.3fs", st.name, st.run, st.wall.Seconds())
+	}
+
+	var data dataset
+	write(measure("simulate", 0, func() { data = simulate(dir, s) }))
+
+	annotated := filepath.Join(dir, "bench.annotated.bam")
+	families := filepath.Join(dir, "bench.families.bed")
+	calls := filepath.Join(dir, "bench.vcf")
+	qc := filepath.Join(dir, "bench.qc.tsv")
+	for r := 1; r <= runs; r++ {
+		st := measure("annotateReadFamilies", r, func() {
+			runCommand(annotateReadFamilies.Main, "annotateReadFamilies", "-i", data.bam, "-o", annotated, "-bed", families)
+		})
+		st.reads = data.reads
+		write(st)
+
+		st = measure("index", r, func() { bai.WriteIndex(annotated) })
+		st.reads = data.reads
+		write(st)
+
+		st = measure("mcsCallVariants", r, func() {
+			runCommand(mcsCallVariants.Main, "mcsCallVariants", "-i", annotated, "-b", families, "-r", data.ref,
+				"-o", calls, "-minContigSize", "0", "-threads", strconv.Itoa(threads))
+		})
+		st.reads = data.reads
+		write(st)
+
+		st = measure("mcsQc", r, func() {
+			runCommand(mcsQc.Main, "mcsQc", "-i", annotated, "-b", families, "-o", qc)
+		})
+		st.reads = data.reads
+		write(st)
+	}
+}
+
+// measure runs f and returns its wall time and allocations.
+func measure(name string, run int, f func()) stage {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	f()
+	wall := time.Since(start)
+	runtime.ReadMemStats(&after)
+	return stage{
+		name:    name,
+		run:     run,
+		wall:    wall,
+		alloc:   after.TotalAlloc - before.TotalAlloc,
+		mallocs: after.Mallocs - before.Mallocs,
+		gcs:     after.NumGC - before.NumGC,
+	}
+}
+
+// runCommand runs the Main function of a command in this process with args as its
+// options. Options are parsed into a new flag set so commands can be run repeatedly.
+func runCommand(main func(), name string, args ...string) {
+	savedArgs, savedFlags := os.Args, flag.CommandLine
+	defer func() {
+		os.Args, flag.CommandLine = savedArgs, savedFlags
+	}()
+	os.Args = append([]string{name}, args...)
+	flag.CommandLine = flag.NewFlagSet(name, flag.ExitOnError)
+	main()
+}
@@ -0,0 +1,41 @@
+package mcsBench
+
+import (
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/vcf"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMcsBench runs every stage of the benchmark on a small simulation with the rates
+// of Main, dense enough that families and the overlapping mates of their read pairs
+// share piles, and checks each stage ran and the simulated variants were called.
+func TestMcsBench(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "bench.tsv")
+	s := simulation{
+		chroms:       2,
+		chromSize:    20000,
+		families:     300,
+		pairsPerSide: 4,
+		readLen:      150,
+		variantRate:  0.02,
+		errorRate:    0.001,
+		seed:         1,
+	}
+	mcsBench(dir, s, 1, 1, output)
+
+	var stages []string
+	for _, line := range fileio.Read(output)[1:] {
+		stages = append(stages, strings.Split(line, "\t")[0])
+	}
+	if actual := strings.Join(stages, ","); actual != "simulate,annotateReadFamilies,index,mcsCallVariants,mcsQc" {
+		t.Errorf("unexpected stages %s", actual)
+	}
+
+	calls, _ := vcf.Read(filepath.Join(dir, "bench.vcf"))
+	if len(calls) == 0 {
+		t.Error("expected the simulated variants to be called")
+	}
+}
@@ -0,0 +1,170 @@
+package mcsBench
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// dataset is the simulated input for the benchmark.
+type dataset struct {
+	ref   string // indexed fasta
+	bam   string // coordinate sorted bam with BF and BR barcode tags, as input to annotateReadFamilies
+	reads int
+}
+
+// simulation describes the dataset to simulate.
+type simulation struct {
+	chroms       int
+	chromSize    int
+	families     int
+	pairsPerSide int // read pairs from each strand of a family
+	readLen      int
+	variantRate  float64 // fraction of families carrying a true variant on both strands
+	errorRate    float64 // per base sequencing error rate
+	seed         int64
+}
+
+const fastaLineLen = 60
+
+var bases = []dna.Base{dna.A, dna.C, dna.G, dna.T}
+
+// simulate writes a random reference and a bam of duplex read families drawn from it to dir.
+func simulate(dir string, s simulation) dataset {
+	rng := rand.New(rand.NewSource(s.seed))
+	d := dataset{ref: filepath.Join(dir, "bench.fa"), bam: filepath.Join(dir, "bench.bam")}
+
+	chroms := make([]chromInfo.ChromInfo, s.chroms)
+	seqs := make([][]dna.Base, s.chroms)
+	for i := range seqs {
+		chroms[i] = chromInfo.ChromInfo{Name: fmt.Sprintf("chr%d", i+1), Size: s.chromSize, Order: i}
+		seqs[i] = make([]dna.Base, s.chromSize)
+		for j := range seqs[i] {
+			seqs[i][j] = bases[rng.Intn(4)]
+		}
+	}
+	writeFasta(d.ref, chroms, seqs)
+
+	barcodes := make([]string, 0, len(barcode.Barcodes))
+	for b := range barcode.Barcodes {
+		barcodes = append(barcodes, b)
+	}
+	sort.Strings(barcodes) // map order is random
+
+	var reads []sam.Sam
+	for f := 0; f < s.families; f++ {
+		chrom := rng.Intn(s.chroms)
+		fragLen := s.readLen + rng.Intn(2*s.readLen)
+		start := rng.Intn(s.chromSize - fragLen)
+		frag := make([]dna.Base, fragLen)
+		copy(frag, seqs[chrom][start:start+fragLen])
+		if rng.Float64() < s.variantRate {
+			pos := rng.Intn(fragLen)
+			frag[pos] = bases[(int(frag[pos])+1+rng.Intn(3))%4]
+		}
+		b1 := barcodes[rng.Intn(len(barcodes))]
+		b2 := barcodes[rng.Intn(len(barcodes))]
+		for strand := 0; strand < 2; strand++ {
+			for p := 0; p < s.pairsPerSide; p++ {
+				name := fmt.Sprintf("f%d.%d.%d", f, strand, p)
+				reads = append(reads, readPair(rng, name, chroms[chrom].Name, start, frag, b1, b2, strand == 1, s)...)
+			}
+		}
+	}
+	sort.SliceStable(reads, func(i, j int) bool {
+		if reads[i].RName != reads[j].RName {
+			return reads[i].RName < reads[j].RName
+		}
+		return reads[i].Pos < reads[j].Pos
+	})
+	d.reads = len(reads)
+
+	out := fileio.EasyCreate(d.bam)
+	bw := bam.NewWriter(out, sam.GenerateHeader(chroms, nil, sam.Coordinate, sam.None))
+	for i := range reads {
+		bw.Write(reads[i])
+	}
+	err := bw.Close()
+	exception.PanicOnErr(err)
+	err = out.Close()
+	exception.PanicOnErr(err)
+	return d
+}
+
+// readPair returns the two reads sequenced from the ends of frag. Reads from the crick
+// strand have their barcodes and orientations swapped.
+func readPair(rng *rand.Rand, name, chrom string, start int, frag []dna.Base, b1, b2 string, crick bool, s simulation) []sam.Sam {
+	left := sam.Sam{QName: name, MapQ: 60, RName: chrom, Pos: uint32(start + 1), RNext: "=", TLen: int32(len(frag))}
+	right := sam.Sam{QName: name, MapQ: 60, RName: chrom, Pos: uint32(start + len(frag) - s.readLen + 1), RNext: "=", TLen: -int32(len(frag))}
+	left.PNext, right.PNext = right.Pos, left.Pos
+	left.Flag = 0x1 | 0x2 | 0x20
+	right.Flag = 0x1 | 0x2 | 0x10
+	if crick {
+		left.Flag |= 0x80
+		right.Flag |= 0x40
+		b1, b2 = b2, b1
+	} else {
+		left.Flag |= 0x40
+		right.Flag |= 0x80
+	}
+	tags := fmt.Sprintf("BF:Z:%s\tBR:Z:%s", b1, b2)
+	qual := strings.Repeat("I", s.readLen)
+	for _, r := range []*sam.Sam{&left, &right} {
+		offset := int(r.Pos-1) - start
+		r.Seq = make([]dna.Base, s.readLen)
+		copy(r.Seq, frag[offset:offset+s.readLen])
+		for i := range r.Seq {
+			if rng.Float64() < s.errorRate {
+				r.Seq[i] = bases[(int(r.Seq[i])+1+rng.Intn(3))%4]
+			}
+		}
+		r.Cigar = []cigar.Cigar{{RunLength: s.readLen, Op: 'M'}}
+		r.Qual = qual
+		r.Extra = tags
+	}
+	return []sam.Sam{left, right}
+}
+
+// writeFasta writes the sequences and a .fai index.
+func writeFasta(filename string, chroms []chromInfo.ChromInfo, seqs [][]dna.Base) {
+	file, err := os.Create(filename)
+	exception.PanicOnErr(err)
+	w := bufio.NewWriter(file)
+	idx := fileio.EasyCreate(filename + ".fai")
+	var offset int
+	for i := range seqs {
+		header := ">" + chroms[i].Name + "\n"
+		offset += len(header)
+		_, err = fmt.Fprintf(idx, "%s\t%d\t%d\t%d\t%d\n", chroms[i].Name, len(seqs[i]), offset, fastaLineLen, fastaLineLen+1)
+		exception.PanicOnErr(err)
+		_, err = w.WriteString(header)
+		exception.PanicOnErr(err)
+		for j := 0; j < len(seqs[i]); j += fastaLineLen {
+			end := j + fastaLineLen
+			if end > len(seqs[i]) {
+				end = len(seqs[i])
+			}
+			_, err = w.WriteString(dna.BasesToString(seqs[i][j:end]) + "\n")
+			exception.PanicOnErr(err)
+			offset += end - j + 1
+		}
+	}
+	err = w.Flush()
+	exception.PanicOnErr(err)
+	err = file.Close()
+	exception.PanicOnErr(err)
+	err = idx.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,116 @@
+package mcsCallVariants
+
+import (
+	"github.com/vertgenlab/gonomics/vcf"
+	"log"
+	"sort"
+	"strings"
+)
+
+// requiredAnnotations are the fields written whatever -annot selects: the genotype,
+// which the FORMAT of every record starts with, and the END of reference blocks.
+var requiredAnnotations = map[string]bool{"GT": true, "END": true}
+
+// annotations are the INFO and FORMAT fields selected with -annot. A nil annotations
+// keeps every field.
+type annotations map[string]bool
+
+// parseAnnotations returns the comma separated field IDs of value, each of which must
+// be an INFO or FORMAT field of h, or nil if value is "".
+func parseAnnotations(value string, h vcf.Header) annotations {
+	if value == "" {
+		return nil
+	}
+	known := make(map[string]bool)
+	for _, line := range h.Text {
+		if id, ok := fieldId(line); ok {
+			known[id] = true
+		}
+	}
+	ans := make(annotations)
+	for _, id := range strings.Split(value, ",") {
+		id = strings.TrimSpace(id)
+		if !known[id] {
+			names := make([]string, 0, len(known))
+			for name := range known {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			log.Fatalf("ERROR: -annot %s is not an INFO or FORMAT field of the output. The fields are %s.", id, strings.Join(names, ","))
+		}
+		ans[id] = true
+	}
+	return ans
+}
+
+// fieldId returns the ID of an ##INFO or ##FORMAT header line.
+func fieldId(line string) (string, bool) {
+	for _, prefix := range []string{"##INFO=<ID=", "##FORMAT=<ID="} {
+		if strings.HasPrefix(line, prefix) {
+			id, _, _ := strings.Cut(strings.TrimPrefix(line, prefix), ",")
+			return id, true
+		}
+	}
+	return "", false
+}
+
+func (a annotations) keep(id string) bool {
+	return a == nil || a[id] || requiredAnnotations[id]
+}
+
+// header returns h without the INFO and FORMAT lines of the fields that are not kept.
+func (a annotations) header(h vcf.Header) vcf.Header {
+	if a == nil {
+		return h
+	}
+	var ans vcf.Header
+	for _, line := range h.Text {
+		if id, ok := fieldId(line); ok && !a.keep(id) {
+			continue
+		}
+		ans.Text = append(ans.Text, line)
+	}
+	return ans
+}
+
+// apply returns v with only the INFO and FORMAT fields that are kept. The samples of v
+// are copied, so v is not changed.
+func (a annotations) apply(v vcf.Vcf) vcf.Vcf {
+	if a == nil {
+		return v
+	}
+	var info []string
+	for _, field := range strings.Split(v.Info, ";") {
+		id, _, _ := strings.Cut(field, "=")
+		if a.keep(id) {
+			info = append(info, field)
+		}
+	}
+	v.Info = "."
+	if len(info) > 0 {
+		v.Info = strings.Join(info, ";")
+	}
+
+	var kept []int
+	for i, id := range v.Format {
+		if a.keep(id) {
+			kept = append(kept, i)
+		}
+	}
+	format := make([]string, len(kept))
+	for j, i := range kept {
+		format[j] = v.Format[i]
+	}
+	samples := make([]vcf.Sample, len(v.Samples))
+	for s := range v.Samples {
+		samples[s] = v.Samples[s]
+		samples[s].FormatData = make([]string, 0, len(kept))
+		for _, i := range kept {
+			if i < len(v.Samples[s].FormatData) {
+				samples[s].FormatData = append(samples[s].FormatData, v.Samples[s].FormatData[i])
+			}
+		}
+	}
+	v.Format, v.Samples = format, samples
+	return v
+}
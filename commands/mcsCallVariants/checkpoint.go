@@ -0,0 +1,198 @@
+package mcsCallVariants
+
+import (
+	"bufio"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bcf"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/mcscall"
+	"github.com/dasnellings/duplexTools/sbs"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// checkpoint records how much of a run is written, so that a run that fails part way
+// can be continued with -resume. It is written as JSON to checkpointFile(output).
+type checkpoint struct {
+	Families    int    `json:"families"`    // families, in the order they are called, whose calls and called sites are written
+	LastFamily  string `json:"lastFamily"`  // the last of them, as chrom:start-end name
+	FamiliesMd5 string `json:"familiesMd5"` // of the filtered family bed, so a resumed run is checked to call the same families
+	OptionsMd5  string `json:"optionsMd5"`  // of the options that change the calls, so a resumed run is checked to call them the same way
+	Vcf         int64  `json:"vcf"`         // bytes written to -o
+	Rejects     int64  `json:"rejects"`     // bytes written to -rejectsOut
+	CalledSites int64  `json:"calledSites"` // bytes written to -calledSitesOut
+}
+
+// checkpointFile returns the name of the checkpoint of a run writing to output.
+func checkpointFile(output string) string {
+	return output + ".checkpoint"
+}
+
+// checkResumable exits if an output of the run cannot be cut back to a checkpoint and
+// appended to, as compressed files cannot, or is only written when the run ends.
+func checkResumable(output, outputType, calledSitesOut, rejectsOut, evidenceBam string) {
+	if output == "stdout" || outputType == "z" || outputType == "b" || strings.HasSuffix(output, ".gz") || bcf.IsBcf(output) {
+		log.Fatal("ERROR: -checkpoint and -resume need -o to be an uncompressed VCF file.")
+	}
+	for _, out := range []string{calledSitesOut, rejectsOut} {
+		if strings.HasSuffix(out, ".gz") {
+			log.Fatalf("ERROR: -checkpoint and -resume cannot append to the compressed %s.", out)
+		}
+	}
+	if evidenceBam != "" {
+		log.Fatal("ERROR: -evidenceBam cannot be used with -checkpoint or -resume, as its reads are held until the run ends.")
+	}
+}
+
+// readCheckpoint reads the checkpoint of a run writing to output and checks that the
+// run calls the families of bedFile with the options hashed to optsMd5 by optionsMd5.
+func readCheckpoint(output, bedFile, optsMd5 string) checkpoint {
+	var c checkpoint
+	data, err := os.ReadFile(checkpointFile(output))
+	if err != nil {
+		log.Fatalf("ERROR: -resume could not read the checkpoint of %s: %s", output, err)
+	}
+	if err = json.Unmarshal(data, &c); err != nil {
+		log.Fatalf("ERROR: -resume could not parse %s: %s", checkpointFile(output), err)
+	}
+	if c.FamiliesMd5 != fileMd5(bedFile) {
+		log.Fatalf("ERROR: -resume found that the families to call differ from those of the checkpointed run. Resume with the same -b, -e, -R, -shard, and family filters.")
+	}
+	if c.OptionsMd5 != optsMd5 {
+		log.Fatalf("ERROR: -resume found that the calling options differ from those of the checkpointed run. Resume with the same -i, -r, -annot, and calling options.")
+	}
+	return c
+}
+
+// write replaces the checkpoint of a run writing to output, by a rename so that a
+// checkpoint is never partly written.
+func (c checkpoint) write(output string) {
+	data, err := json.MarshalIndent(c, "", "  ")
+	exception.PanicOnErr(err)
+	name := checkpointFile(output)
+	err = os.WriteFile(name+".tmp", append(data, '\n'), 0644)
+	exception.PanicOnErr(err)
+	err = os.Rename(name+".tmp", name)
+	exception.PanicOnErr(err)
+}
+
+// optionsMd5 returns the md5 checksum of the options of p that change the calls written
+// to its outputs: the bam, the reference, the fields written, and p.opts.
+func optionsMd5(p callParams) string {
+	data, err := json.Marshal(struct {
+		Input       string
+		Ref         string
+		AnnotFields string
+		Opts        mcscall.Options
+	}{p.input, p.ref, p.annotFields, p.opts})
+	exception.PanicOnErr(err)
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// familyName returns b as recorded in checkpoint.LastFamily.
+func familyName(b bed.Bed) string {
+	return fmt.Sprintf("%s:%d-%d %s", b.Chrom, b.ChromStart+1, b.ChromEnd, b.Name)
+}
+
+// fileMd5 returns the md5 checksum of filename in hex.
+func fileMd5(filename string) string {
+	f, err := os.Open(filename)
+	exception.PanicOnErr(err)
+	defer cleanup(f)
+	h := md5.New()
+	_, err = io.Copy(h, f)
+	exception.PanicOnErr(err)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// resumableFile is an uncompressed output that can be flushed for a checkpoint, and cut
+// back to a checkpoint and appended to on -resume.
+type resumableFile struct {
+	*bufio.Writer
+	file *os.File
+}
+
+// createResumable creates filename, or with resume cuts it back to size bytes to append
+// to.
+func createResumable(filename string, resume bool, size int64) *resumableFile {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if resume {
+		flags = os.O_WRONLY
+	}
+	file, err := os.OpenFile(filename, flags, 0644)
+	if err != nil {
+		log.Fatalf("ERROR: could not open %s: %s", filename, err)
+	}
+	if resume {
+		if info, err := file.Stat(); err != nil || info.Size() < size {
+			log.Fatalf("ERROR: -resume found %s shorter than at the checkpoint.", filename)
+		}
+		exception.PanicOnErr(file.Truncate(size))
+		_, err = file.Seek(size, io.SeekStart)
+		exception.PanicOnErr(err)
+	}
+	return &resumableFile{Writer: bufio.NewWriter(file), file: file}
+}
+
+// size flushes f and returns the bytes written to the file.
+func (f *resumableFile) size() int64 {
+	exception.PanicOnErr(f.Flush())
+	pos, err := f.file.Seek(0, io.SeekCurrent)
+	exception.PanicOnErr(err)
+	return pos
+}
+
+func (f *resumableFile) Close() error {
+	if err := f.Flush(); err != nil {
+		f.file.Close()
+		return err
+	}
+	return f.file.Close()
+}
+
+// restoreCounts adds the calls in the VCF written before a checkpoint to the variant
+// counts of the summary and to the spectrum, if not nil, and the called sites written
+// to callable, if not nil, so outputs made when the run ends cover the whole run.
+func restoreCounts(output, calledSitesOut string, variants *variantCounts, spectrum []int, refSeeker *fai.Seeker, callable *callableBases) {
+	records, _ := vcf.GoReadToChan(output)
+	for v := range records {
+		variants.add(v)
+		if spectrum != nil && (v.Filter == "." || v.Filter == "PASS") {
+			if idx, found := sbs.Index(sbs.Class(v, 0, refSeeker)); found {
+				spectrum[idx]++
+			}
+		}
+	}
+	if callable == nil {
+		return
+	}
+	for b := range bed.GoReadToChan(calledSitesOut) {
+		callable.add(b)
+	}
+}
+
+// skipFamilies passes on the families from in after the first n, which were called
+// before the checkpoint.
+func skipFamilies(in <-chan bed.Bed, n int) <-chan bed.Bed {
+	out := make(chan bed.Bed, 1000)
+	go func() {
+		var i int
+		for b := range in {
+			if i >= n {
+				out <- b
+			}
+			i++
+		}
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,127 @@
+package mcsCallVariants
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/dasnellings/duplexTools/mcscall"
+	"github.com/dasnellings/duplexTools/salvage"
+	"github.com/dasnellings/duplexTools/tmp"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fasta"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// callByContig calls the families of each file of contigBeds, the beds of contigs made by
+// splitByContig, with a pool of a worker per element of workers that is started for the
+// contig and closed once its families are called, so the bam index is only searched near
+// the families of one contig at a time. Contigs are called in the order of contigBeds, and
+// calling stops when ctx is cancelled or salvage.Stop is called.
+func callByContig(ctx context.Context, contigs, contigBeds []string, outputChan chan<- result, calledSitesBedChan chan<- bed.Bed, evidenceChan chan<- []sam.Sam, consensusChan chan<- fasta.Fasta, footprintChan chan<- bed.Bed, inputBam, ref string, opts mcscall.Options, stats *mcscall.Stats, mem *memCeiling, workers []worker, wg *sync.WaitGroup, debugOutChan chan<- string, debugLevel int) {
+	defer wg.Done()
+	for i, file := range contigBeds {
+		if file == "" {
+			continue
+		}
+		if ctx.Err() != nil || salvage.Stopped() {
+			return
+		}
+		families := bed.GoReadToChan(file)
+		pool := new(sync.WaitGroup)
+		for j := range workers {
+			pool.Add(1)
+			go spawnThread(ctx, families, outputChan, calledSitesBedChan, evidenceChan, consensusChan, footprintChan, inputBam, nil, ref, opts, stats, mem, &workers[j], pool, debugOutChan)
+		}
+		pool.Wait()
+		if debugLevel > 0 {
+			log.Printf("Called the read families of %s.", contigs[i])
+		}
+	}
+}
+
+// contigShards holds the calls of each contig in a VCF shard in tmp.Dir until they are
+// sorted and concatenated to the output. Calls should arrive contig by contig, as a
+// shard is reopened each time the contig changes.
+type contigShards struct {
+	base    string
+	files   map[string]string // contig to shard
+	current string
+	file    *os.File
+	out     *bufio.Writer
+}
+
+func newContigShards(output string) *contigShards {
+	return &contigShards{base: strings.TrimSuffix(strings.TrimSuffix(filepath.Base(output), ".gz"), ".vcf"), files: make(map[string]string)}
+}
+
+// writer returns the shard of the calls on chrom.
+func (s *contigShards) writer(chrom string) io.Writer {
+	if chrom == s.current && s.out != nil {
+		return s.out
+	}
+	s.close()
+	file, found := s.files[chrom]
+	if !found {
+		file = tmp.Path(fmt.Sprintf("%s.shard%d.vcf", s.base, len(s.files)))
+		s.files[chrom] = file
+	}
+	var err error
+	s.file, err = os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	exception.PanicOnErr(err)
+	s.out = bufio.NewWriter(s.file)
+	s.current = chrom
+	return s.out
+}
+
+func (s *contigShards) close() {
+	if s.file == nil {
+		return
+	}
+	exception.PanicOnErr(s.out.Flush())
+	cleanup(s.file)
+	s.file, s.out = nil, nil
+}
+
+// concat writes the shard of each of contigs, in order, to out with its calls sorted by
+// position, and then by the text of the record so the order does not depend on the
+// order the families were called. Each shard is removed once written.
+func (s *contigShards) concat(out io.Writer, contigs []string) {
+	s.close()
+	for _, chrom := range contigs {
+		file, found := s.files[chrom]
+		if !found {
+			continue
+		}
+		lines := fileio.Read(file)
+		pos := make([]int, len(lines))
+		for i := range lines {
+			fields := strings.SplitN(lines[i], "\t", 3)
+			pos[i], _ = strconv.Atoi(fields[1])
+		}
+		idx := make([]int, len(lines))
+		for i := range idx {
+			idx[i] = i
+		}
+		sort.Slice(idx, func(i, j int) bool {
+			if pos[idx[i]] != pos[idx[j]] {
+				return pos[idx[i]] < pos[idx[j]]
+			}
+			return lines[idx[i]] < lines[idx[j]]
+		})
+		for _, i := range idx {
+			_, err := fmt.Fprintln(out, lines[i])
+			exception.PanicOnErr(err)
+		}
+		exception.PanicOnErr(os.Remove(file))
+	}
+}
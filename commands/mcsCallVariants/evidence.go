@@ -0,0 +1,186 @@
+package mcsCallVariants
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/mcscall"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/tmp"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// evidenceRun is the number of reads carrying calls held in memory before they are
+// sorted and written to a run in tmp.Dir.
+const evidenceRun = 1 << 18
+
+// evidenceSorter sorts the reads sent to mcscall.Caller.Evidence by position without
+// holding them all in memory. Every evidenceRun reads are sorted and written to a bam
+// run in tmp.Dir, and the runs are merged when the evidence bam is written.
+type evidenceSorter struct {
+	header sam.Header
+	refIdx map[string]int
+	reads  []sam.Sam
+	runs   []string
+}
+
+// newEvidenceSorter returns an evidenceSorter for reads of the bam with header.
+func newEvidenceSorter(header sam.Header) *evidenceSorter {
+	refIdx := make(map[string]int, len(header.Chroms))
+	for i, c := range header.Chroms {
+		refIdx[c.Name] = i
+	}
+	return &evidenceSorter{header: header, refIdx: refIdx}
+}
+
+// add adds reads, writing a run once evidenceRun reads are held.
+func (s *evidenceSorter) add(reads []sam.Sam) {
+	s.reads = append(s.reads, reads...)
+	if len(s.reads) >= evidenceRun {
+		s.spill()
+	}
+}
+
+// less orders reads by position, and then by name and flag.
+func (s *evidenceSorter) less(a, b *sam.Sam) bool {
+	switch {
+	case a.RName != b.RName:
+		return s.refIdx[a.RName] < s.refIdx[b.RName]
+	case a.Pos != b.Pos:
+		return a.Pos < b.Pos
+	case a.QName != b.QName:
+		return a.QName < b.QName
+	}
+	return a.Flag < b.Flag
+}
+
+func (s *evidenceSorter) sort() {
+	sort.SliceStable(s.reads, func(i, j int) bool { return s.less(&s.reads[i], &s.reads[j]) })
+}
+
+// spill sorts the reads held and writes them to a new run.
+func (s *evidenceSorter) spill() {
+	s.sort()
+	file := tmp.Path(fmt.Sprintf("evidence.run%d.bam", len(s.runs)))
+	out := fileio.EasyCreate(file)
+	bw := bam.NewWriter(out, s.header)
+	for i := range s.reads {
+		bw.Write(s.reads[i])
+	}
+	cleanup(bw)
+	cleanup(out)
+	s.runs = append(s.runs, file)
+	s.reads = s.reads[:0]
+}
+
+// write writes the reads that carry a call in written to output, sorted by position,
+// and indexes it. Calls that were not written, such as those outside -R, are removed
+// from the tag of each read. Runs are merged and removed, so reads equal by less keep
+// the order they were added in.
+func (s *evidenceSorter) write(output string, written map[string]bool) {
+	out := fileio.EasyCreate(output)
+	bw := bam.NewWriter(out, provenance.Sam(s.header))
+	var n int
+	emit := func(r sam.Sam) {
+		if keepEvidence(&r, written) {
+			bw.Write(r)
+			n++
+		}
+	}
+	if len(s.runs) == 0 {
+		s.sort()
+		for i := range s.reads {
+			emit(s.reads[i])
+		}
+	} else {
+		if len(s.reads) > 0 {
+			s.spill()
+		}
+		s.merge(emit)
+	}
+	s.reads = nil
+	// both must be closed before the output can be indexed
+	cleanup(bw)
+	cleanup(out)
+	bai.WriteIndex(output)
+	log.Printf("Wrote %d reads carrying calls to %s.\n", n, output)
+}
+
+// merge sends the reads of every run to emit in order, taking the read of the earliest
+// run when reads are equal, and removes the runs.
+func (s *evidenceSorter) merge(emit func(sam.Sam)) {
+	readers := make([]*sam.BamReader, len(s.runs))
+	heads := make([]*sam.Sam, len(s.runs))
+	next := func(i int) {
+		var r sam.Sam
+		_, err := sam.DecodeBam(readers[i], &r)
+		if err == io.EOF {
+			heads[i] = nil
+			return
+		}
+		exception.PanicOnErr(err)
+		exception.PanicOnErr(sam.ParseExtra(&r)) // for the text of the tags, which keepEvidence edits
+		heads[i] = &r
+	}
+	for i, run := range s.runs {
+		readers[i], _ = sam.OpenBam(run)
+		next(i)
+	}
+	for {
+		first := -1
+		for i := range heads {
+			if heads[i] != nil && (first < 0 || s.less(heads[i], heads[first])) {
+				first = i
+			}
+		}
+		if first < 0 {
+			break
+		}
+		emit(*heads[first])
+		next(first)
+	}
+	for i, run := range s.runs {
+		cleanup(readers[i])
+		exception.PanicOnErr(os.Remove(run))
+	}
+	s.runs = nil
+}
+
+// keepEvidence removes the calls not in written from the tag of r, and reports whether
+// r carries a call that was written.
+func keepEvidence(r *sam.Sam, written map[string]bool) bool {
+	prefix := mcscall.EvidenceTag + ":Z:"
+	tag, rest, found := strings.Cut(r.Extra, "\t")
+	var ids []string
+	for _, id := range strings.Split(strings.TrimPrefix(tag, prefix), ",") {
+		if written[id] {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) == 0 {
+		return false
+	}
+	r.Extra = prefix + strings.Join(ids, ",")
+	if found {
+		r.Extra += "\t" + rest
+	}
+	return true
+}
+
+// inputHeader returns the header of input, taken from bamStream if it is not nil as a
+// streamed bam cannot be opened again.
+func inputHeader(input string, bamStream *mcscall.Stream) sam.Header {
+	if bamStream != nil {
+		return bamStream.Header()
+	}
+	br, header := sam.OpenBam(input)
+	cleanup(br)
+	return header
+}
@@ -0,0 +1,1163 @@
+package mcsCallVariants
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bcf"
+	"github.com/dasnellings/duplexTools/bgzf"
+	"github.com/dasnellings/duplexTools/config"
+	"github.com/dasnellings/duplexTools/cpus"
+	"github.com/dasnellings/duplexTools/deterministic"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/mcscall"
+	"github.com/dasnellings/duplexTools/metrics"
+	"github.com/dasnellings/duplexTools/pipe"
+	"github.com/dasnellings/duplexTools/preflight"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/salvage"
+	"github.com/dasnellings/duplexTools/sbs"
+	"github.com/dasnellings/duplexTools/shard"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/dasnellings/duplexTools/tmp"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fasta"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// truncatedMarker is written at the end of outputs when the run is interrupted so that
+// partial files are not mistaken for complete ones.
+const truncatedMarker = "#TRUNCATED: mcsCallVariants was interrupted before all read families were processed"
+
+func usage() {
+	fmt.Print(
+		"mcsCallVariants - Call variants from META-CS data processed with annotateReadFamilies.\n" +
+			"Usage:\n" +
+			"mcsCallVariants [options] -i input.bam -b input.bed -r reference.fasta > output.vcf\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Get to satisfy flag.Getter interface, so each file is echoed on its own
+func (i *inputFiles) Get() any {
+	return []string(*i)
+}
+
+// Main runs mcsCallVariants with the options in os.Args.
+func Main() {
+	var inputs, bedFiles, excludeBeds, regionArgs inputFiles
+	var sh shard.Shard
+	cpuprofile := flag.String("cpuprofile", "", "write cpu profile")
+	memprofile := flag.String("memprofile", "", "write memory profile")
+	flag.Var(&inputs, "i", "Input bam file. Must be indexed unless -stream is given. May be declared more than once, with a -b for each, to call several samples (e.g. single cells from one donor) into one VCF with a column for each sample.")
+	output := flag.String("o", "stdout", "Output VCF file.")
+	outputType := flag.String("O", "", "Output `type`: v for VCF, z for bgzip compressed VCF, or b for BCF. By default the type is chosen from the extension of -o (.vcf.gz or .bcf), and is VCF otherwise.")
+	flag.Var(&bedFiles, "b", "Input bed file with coordinates of read families, read family ID, and read counts for watson and crick strands. Generated with -bed option in annotateReadFamilies. Declared once for each -i, in the same order.")
+	flag.Var(&excludeBeds, "e", "Bed, interval_list, or GFF3 file(s) with regions to exclude from analysis. May be declared more than once with additional -e flags. Strongly recommended to mask regions with poor mappability. Note that any family OVERLAPPING an excluded region will be removed from analysis.")
+	flag.Var(&regionArgs, "R", "Only call in this region, given as chr:start-end (1-based, inclusive), chr, or a BED, interval_list, or GFF3 file. May be declared more than once. Families overlapping a region are called, and only calls with POS in a region and the called sites in a region are output, so the genome can be split into regions for scatter-gather without filtering the -b bed.")
+	ref := flag.String("r", "", "Fasta file with reference genome used to align input bam. Must be indexed.")
+	totalDepth := flag.Int("a", 8, "Minimum total depth of read family for variant consideration.")
+	strandedDepth := flag.Int("s", 4, "Minimum depth of independent watson and crick strands for variant consideration. When set to 0, caller runs in unstranded mode merging read counts from watson and crick strands.")
+	endPad := flag.Int("ignoreEnds", 3, "Ignore bases within # of end of a read.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality.")
+	minReadFamilyLength := flag.Int("minReadFamilyLength", 100, "Minimum length in bp of read family for inclusion in analysis. Empirical evidence suggests errors are more common in small fragments.")
+	maxSoftClipFraction := flag.Float64("maxSoftClipFraction", 0.2, "Maximum fraction of read that may be soft clipped.")
+	countOverlappingPairs := flag.Bool("countOverlappingPairs", false, "Count both reads in overlapping regions of read pairs. By default the overlap is counted once, keeping the base of the mate with the higher base quality.")
+	useMd := flag.Bool("useMD", false, "Take the reference base of SNV candidates from the MD tags of the reads of each family where present, reading the reference only for bases no read covers. Saves a reference lookup per candidate site; the MD tags must be correct for -r, as written by bwa or samtools calmd.")
+	allowSuppAln := flag.Bool("allowSupplementaryAlignments", false, "Allow variants using reads that have supplementary alignments annotated.")
+	minAf := flag.Float64("minAF", 0.9, "Minimum fraction of reads with alternate allele **Within a read family and within strand** to be considered a variant.")
+	minAfSnv := flag.Float64("minAfSnv", 0, "Minimum alternate allele fraction of SNVs. 0 uses -minAF.")
+	minAfIns := flag.Float64("minAfIns", 0, "Minimum alternate allele fraction of insertions, which PCR slippage in repeats makes noisier than SNVs. 0 uses -minAF.")
+	minAfDel := flag.Float64("minAfDel", 0, "Minimum alternate allele fraction of deletions. 0 uses -minAF.")
+	minBaseQuality := flag.Int("minBaseQuality", 30, "Minimum base quality to be considered for calling. Bases below threshold will be ignored.")
+	baseQualPenalty := flag.Float64("baseQualPenalty", 0.5, "Penalty for positions with low quality base. Each read with a base < minBaseQuality counts towards baseQualPenalty fraction of a read for allele frequency calculations. Note that low quality bases are N-masked and so will always count AGAINST the alternate allele. (e.g. by default each read with a low quality base counts as 0.5 reads for allele frequency determination.")
+	consensusQual := flag.Bool("consensusQual", false, "Weigh the base of each read by its base quality, as a duplex consensus caller does, instead of N-masking bases below -minBaseQuality. The SNV allele fraction of each strand counts each read as its probability of being right, and the QUAL of an SNV uses the consensus error of each strand given the base qualities of its reads. Recovers calls where one strand has marginal base qualities. Indels keep the read counts and -baseQualPenalty.")
+	maxOverlappingFamilies := flag.Int("maxOverlappingFamilies", 20, "Maximum number of overlapping read families for site to be considered for calling. Low number avoids regions with many misalignments (e.g. centromeres) reducing memory usage. Set to -1 for no limit.")
+	callSingleStrand := flag.Bool("ss", false, "Include single-stranded variants in output VCF. Single-stranded calling uses the same a and s minimum values as double-stranded calling but requires perfect asymmetry between strands such that 100% of reads carry the variant on strand 1 and 0% of reads carry the variant on strand 2. Single-stranded calls will have 'SS' in the INFO field.")
+	maxFamilyDepth := flag.Int("maxFamilyDepth", 0, "Randomly keep at most this many reads of each strand of a read family before the pileup, keeping both reads of a pair, so families of hundreds of reads do not slow the run. The reads of each strand before downsampling are still given in INFO as WFS and CFS. Must be at least -s. 0 for no limit.")
+	downsampleSeed := flag.Uint64("downsampleSeed", 1, "Seed for the reads kept by -maxFamilyDepth. The same reads of a family are kept with the same seed whatever -threads is.")
+	minBreakendClip := flag.Int("minBreakendClip", 0, "Call breakends where the reads of a family are consistently clipped by at least this many bases, as BND records (INFO SVTYPE=BND) with the supporting reads of each strand in PS and MS. Reads with large clips or a supplementary alignment, which are dropped from the pileup, are counted, and a breakend must be carried by -minAF of the reads of each strand covering it, as an SNV is. Where most supporting reads have a supplementary alignment to the same place, the record is joined to it (e.g. G]chr2:5000]), and is a single breakend (e.g. G.) otherwise. 0 calls none.")
+	minContigSize := flag.Int("minContigSize", 10_000_000, "Remove families mapping to contigs of length < minContigSize. The default value cuts out common decoy sequences and chrM from the human genome while keeping chr1-22,X,Y.")
+	maxVariantsPerReadFamily := flag.Int("maxVariantsPerReadFamily", 3, "Maximum number of variants that are allowed to be called within a single read family. If a read family has more variants than this limit, all variants from the read family will be discarded.")
+	emitFiltered := flag.Bool("emitFiltered", false, "Output candidates that fail the strand agreement, allele fraction (-minAF), depth (-s, -a), or -maxVariantsPerReadFamily filters instead of removing them, with the names of the failed filters (strand_mismatch, min_af, min_depth, max_variants) in the FILTER column. Calls that pass have FILTER '.', so thresholds can be tuned on the output without calling again.")
+	mnvMaxDist := flag.Int("mnvMaxDist", 1, "Merge SNV calls of a read family at most this many bases apart (1 for adjacent bases), with the same strandedness and carried by the same reads, into a single MNV record (e.g. CC>TT). Set to 0 to output each SNV on its own.")
+	secondaryAf := flag.Float64("secondaryAF", 0, "List the non-reference alleles other than the ALT of each call that are carried by at least this fraction of the reads of either strand in the SA INFO field, with their watson and crick read counts (e.g. SA=G:3:2). Shows mixtures of alleles within a family, as in candidates failing -minAF with -emitFiltered. 0 lists none.")
+	strandErrorRate := flag.Float64("strandErrorRate", 0.001, "Probability that an error early in amplification (or DNA damage) is carried by every read of one strand of a family. Used with the base error rate of -minBaseQuality to compute the QUAL of each call.")
+	stream := flag.Bool("stream", false, "Read the input bam once from start to end instead of seeking the reads of each family with its index, holding only the reads that may belong to a family yet to be called. The bam need not be indexed and may be a pipe (e.g. -i <(samtools view -u in.cram)) or - to read bam or sam text from stdin (e.g. samtools view -h in.bam | mcsCallVariants -i - -stream ...), but must be coordinate sorted. A -normal bam must still be indexed.")
+	byContig := flag.Bool("byContig", false, "Call the read families of each contig with its own pool of -threads workers, one contig after another in the order of the bam header, so the bam index is only searched near the families of one contig at a time. The calls of each contig are held in a VCF shard in -tmpdir and sorted by position, and the shards are concatenated to -o at the end, so the output is sorted whatever -threads is. Cannot be used with -stream, -checkpoint, or -resume.")
+	threads := flag.Int("threads", 1, "Number of processor threads to use for calling. 0 uses every CPU available to the job, respecting container and scheduler CPU limits. Output VCF will be out of order with threads > 1 unless -deterministic is given.")
+	var maxMem byteSize
+	flag.Var(&maxMem, "maxMem", "Hold workers back from starting a read family while the heap is above this `size` (e.g. 8G), and buffer fewer calls for the writer, so regions of huge families cannot exhaust memory. One worker always runs, so a ceiling set too low slows the run rather than stopping it. The garbage collector also runs more often near the ceiling.")
+	debugLevel := flag.Int("verbose", 0, "Level of verbosity in log. At 1 or more, the time taken by each 1000 read families is also logged.")
+	progressEvery := flag.Duration("progress", time.Minute, "Log the read families called out of those that pass the filters of the family bed, with the percent complete, families called per second, and estimated time remaining, every `interval`. 0 logs no progress.")
+	calledSitesOut := flag.String("calledSitesOut", "", "Output bed of the sites in each family with enough coverage to call a variant. Defaults to bedfile.analysis.calledSites.bed next to -b.")
+	rejectsOut := flag.String("rejectsOut", "", "Output VCF of the candidates that failed the strand agreement, allele fraction, depth, or -maxVariantsPerReadFamily filters, with the failed filters in FILTER and the watson and crick depth in INFO as WDP and CDP. PS and MS give the alt reads on each strand. Useful to find why a known variant was not called.")
+	spectrumOut := flag.String("spectrumOut", "", "Output the SBS96 mutational spectrum of the SNV calls that pass every filter as a tab delimited matrix with a MutationType column (e.g. A[C>T]G) and a column of counts, as read by mcsSignatureExtract -m. Written when the run completes.")
+	callableOut := flag.String("callableOut", "", "Output the callable duplex bases of each contig, the denominator of the mutation rate, as a tab delimited table with columns chrom, duplexBases (summed over families, so a base covered by 2 families counts twice), and distinctBases (reference positions), and a total line. Bases are those of the called sites: covered by families that pass the filters of the family bed, on both strands at -s depth after end padding, and outside -e. Written when the run completes.")
+	summaryOut := flag.String("summaryOut", "", "Output a JSON summary of the run (schema mcsCallVariants, see duplexTools schema mcsCallVariants): families skipped by each filter of the family bed, families and reads processed, mean family depth, rejections by filter, passing variants by type, and the runtime and families of each thread. Written when the run ends, including interrupted runs.")
+	evidenceBam := flag.String("evidenceBam", "", "Output the end-clipped and N-masked reads that carry each call written to -o to a sorted and indexed bam, with the IDs of the calls they carry in a VI tag. The ID column of each call is set to its family ID and number in the family (e.g. 1234.1), so calls can be reviewed in IGV by grouping alignments by the VI tag.")
+	consensusOut := flag.String("consensusOut", "", "Output the duplex consensus sequence of each called read family to this FASTA, named with the family ID, its coordinates, and the number of positions where watson and crick disagree (e.g. >1234 chr1:1000-1300 disagreements=2). Bases not carried by most reads of both strands are N. For blasting suspicious families and checking end clipping and base masking.")
+	footprintOut := flag.String("footprintOut", "", "Output bed of the interval of each called read family that was interrogated, after -ignoreEnds clipping and the removal of positions outside the consensus start and end of the family, named with the family ID. The interval spans the positions covered by both strands, or by either with -s 0. For intersecting with annotations when computing region-specific mutation rates.")
+	checkpointEvery := flag.Duration("checkpoint", 0, "Every `interval` (e.g. 10m), flush the outputs and record the read families whose calls and called sites are written in -o.checkpoint, so a run that fails can be continued with -resume. Families are then called in order, as with -deterministic. Needs -o, -calledSitesOut, and -rejectsOut to be uncompressed files, and cannot be used with -evidenceBam. The checkpoint is removed when the run completes.")
+	resume := flag.Bool("resume", false, "Continue the run recorded in -o.checkpoint: -o, -calledSitesOut, and -rejectsOut are cut back to the checkpoint and appended to, and the families called before it are skipped. Give the same options as the run being resumed; a run whose families or calling options differ is not resumed. -spectrumOut, -callableOut, and the variant counts of -summaryOut cover the whole run; the other counts of -summaryOut cover the resumed part.")
+	debugOut := flag.String("debugLog", "", "Print debug logs to file. File may be large. Must be run with threads == 1 for coherent output. ")
+	annotFields := flag.String("annot", "", "Comma separated INFO and FORMAT `fields` to write (e.g. DP,PS,MS,RF,WFS,CFS), so VCFs of whole-genome runs stay small. GT and the END of -gvcf blocks are always written, and the header only describes the fields written. By default every field is written.")
+	familyInfo := flag.String("familyInfo", "", "Comma separated `columns` of the -b bed after the watson and crick counts to copy to the INFO field of each variant, named by the #chrom header line (e.g. from annotateReadFamilies -bedTags) or numbered from 1.")
+	gvcf := flag.Bool("gvcf", false, "Also output a reference block (ALT <NON_REF>, INFO END) for each run of sites in a family where a variant could be called but was not, with the lowest total (DP), watson (PS), and crick (MS) depth of the block. Blocks of overlapping families overlap.")
+	normalBam := flag.String("normal", "", "Bam of a matched bulk normal. Must be indexed. Each call is checked against the pile of the normal at its position, and calls with more than -maxNormalAltReads reads supporting the alt allele are dropped. The alt and total depth in the normal are added to the INFO field as NAD and NDP.")
+	maxNormalAltReads := flag.Int("maxNormalAltReads", 0, "Maximum number of reads in the -normal bam that may support the alt allele of a call.")
+	tagNormal := flag.Bool("tagNormal", false, "Set the FILTER of calls supported in the -normal bam to Normal instead of dropping them.")
+	groupTag := flag.String("groupTag", "", "SAM tag (e.g. the UMI pair of dual-UMI libraries) whose value splits the reads of each strand of a family into groups. Every group covering a call must carry it at -minAF, as the watson and crick strands must, or the call is removed, or with -emitFiltered gets the group_mismatch FILTER. The groups carrying each call and covering it are added to INFO as GRP (e.g. GRP=3/4).")
+	forceCall := flag.String("forceCall", "", "VCF of sites (e.g. known driver mutations) to output a record of in every read family that covers them, with the alt reads of each strand as PS and MS and the depth of each strand in INFO as WDP and CDP, whether called or not. Sites the family did not call get the NotCalled FILTER and calls of a site get the FC INFO flag. GT is 1 if both strands carry the alt allele. Families removed before calling, as by -minStrandedDepth, have no records.")
+	popVcf := flag.String("popVcf", "", "Population sites VCF (e.g. gnomAD), bgzip compressed and tabix indexed. The population allele frequency of each call found in it is added to INFO as POP_AF, and calls above -maxPopAf get the popAF FILTER, as with mcsDbFilter.")
+	popAfField := flag.String("popAfField", "AF", "INFO field in the -popVcf with the population allele frequency.")
+	maxPopAf := flag.Float64("maxPopAf", 0.001, "Calls with a population allele frequency above this value are filtered.")
+	removePop := flag.Bool("removePop", false, "Remove calls above -maxPopAf instead of marking them in the FILTER column.")
+	orientationBias := flag.Bool("orientationBias", false, "Add the two-sided Fisher's exact test p-value of the alt reads of each strand being biased to one read orientation (F or R), against the other reads of the strand, to FORMAT as OB (watson,crick). Alt support from a single orientation is a hallmark of mapping artifacts.")
+	minOrientationP := flag.Float64("minOrientationP", 0, "Remove calls with an orientation bias p-value (see -orientationBias) below this on either strand, or with -emitFiltered set their FILTER to orientation_bias. Implies -orientationBias. 0 removes none.")
+	maxHomopolymer := flag.Int("maxHomopolymer", 0, "Drop indels of a homopolymer (e.g. a deleted T) in a reference homopolymer of the same base longer than this many bases, where polymerase slippage makes most indel calls artifacts. The repeat unit and reference repeat length of each indel in a homopolymer or dinucleotide repeat are added to INFO as RU and RL. 0 for no limit.")
+	maxDinucRepeat := flag.Int("maxDinucRepeat", 0, "Drop indels of a dinucleotide repeat unit (e.g. a deleted CA) in a reference repeat of that unit longer than this many bases. 0 for no limit.")
+	minOxoGP := flag.Float64("minOxoGP", 0, "Drop G>T and C>A SNVs (8-oxoG damage) whose alt reads on either strand are biased to first or second reads of their pair with a Fisher's exact test p-value below this. 0 drops none.")
+	minDeaminationEndDist := flag.Int("minDeaminationEndDist", 0, "Drop C>T and G>A SNVs (cytosine deamination, concentrated in the single-stranded ends of fragments) whose alt reads are a median of fewer than this many bases from the nearer read end (INFO ED). 0 drops none.")
+	tagDamage := flag.Bool("tagDamage", false, "Set the FILTER of SNVs failing -minOxoGP or -minDeaminationEndDist to OxoG or Deamination instead of dropping them.")
+	tagRepeat := flag.Bool("tagRepeat", false, "Set the FILTER of indels in repeats longer than -maxHomopolymer or -maxDinucRepeat to Repeat instead of dropping them.")
+	flag.Var(&sh, "shard", "Only call `i/n` of the read families (e.g. 3/100) for scatter-gather across cluster jobs. Families are dealt to shards in turn after filtering, and the default -calledSitesOut is named with a .shard3of100 suffix. Combine the outputs of all shards with mcsMerge.")
+	salvage.AddFlag(flag.CommandLine)
+	config.AddFlag(flag.CommandLine)
+	metricsAddr := flag.String("metricsAddr", "", "Serve live counters (families processed, variants emitted, reads/sec, rejections by filter) in Prometheus format at http://`ADDR`/metrics (e.g. :9100).")
+	dryrun.Parse()
+
+	if *cpuprofile != "" {
+		f, err := os.Create(*cpuprofile)
+		if err != nil {
+			log.Fatal("could not create CPU profile: ", err)
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			log.Fatal("could not start CPU profile: ", err)
+		}
+		defer pprof.StopCPUProfile()
+	}
+
+	if len(excludeBeds) == 0 {
+		log.Println("WARNING: -e was not declared. It is strongly recommended to mask regions with poor mappability. An exclude bed can be built with makeExcludeBed.")
+	}
+
+	if *threads < 0 {
+		log.Fatal("ERROR: threads must be >= 0.")
+	}
+	if *threads == 0 {
+		*threads = cpus.Available()
+		log.Printf("Using %d threads.", *threads)
+	}
+	cpus.LimitMaxProcs()
+
+	if len(inputs) == 0 || len(bedFiles) == 0 || *ref == "" {
+		usage()
+		log.Fatal("ERROR: must specify bam (-i), bed (-b), and fasta (-r).")
+	}
+	if len(inputs) != len(bedFiles) {
+		log.Fatalf("ERROR: -b must be declared once for each -i, found %d -i and %d -b.", len(inputs), len(bedFiles))
+	}
+	if len(inputs) > 1 {
+		flag.Visit(func(f *flag.Flag) {
+			if perSampleFlags[f.Name] {
+				log.Fatalf("ERROR: -%s is not supported when calling more than one sample.", f.Name)
+			}
+		})
+	}
+
+	if *strandedDepth*2 > *totalDepth {
+		log.Fatal("ERROR: -s * 2 should not be larger than -a")
+	}
+	if *maxFamilyDepth < 0 || (*maxFamilyDepth > 0 && *maxFamilyDepth < *strandedDepth) {
+		log.Fatal("ERROR: -maxFamilyDepth must be 0 or at least -s.")
+	}
+
+	switch *outputType {
+	case "", "v", "z", "b":
+	default:
+		log.Fatalf("ERROR: -O must be v, z, or b, not '%s'.", *outputType)
+	}
+
+	if *calledSitesOut == "" {
+		*calledSitesOut = defaultCalledSites(bedFiles[0], sh)
+	}
+	if *checkpointEvery < 0 {
+		log.Fatal("ERROR: -checkpoint must be >= 0.")
+	}
+	if *byContig && (*stream || *checkpointEvery > 0 || *resume) {
+		log.Fatal("ERROR: -byContig cannot be used with -stream, -checkpoint, or -resume.")
+	}
+	for _, in := range inputs {
+		if pipe.Name(in) == "-" && !*stream {
+			log.Fatal("ERROR: -i - reads stdin, which can only be read with -stream.")
+		}
+	}
+	if *checkpointEvery > 0 || *resume {
+		checkResumable(*output, *outputType, *calledSitesOut, *rejectsOut, *evidenceBam)
+	}
+
+	opts := mcscall.Options{
+		MinMapQ:                  uint8(*minMapQ),
+		MinTotalDepth:            *totalDepth,
+		MinStrandedDepth:         *strandedDepth,
+		AllowSuppAln:             *allowSuppAln,
+		MinAf:                    *minAf,
+		MinAfSnv:                 *minAfSnv,
+		MinAfIns:                 *minAfIns,
+		MinAfDel:                 *minAfDel,
+		MinBaseQuality:           *minBaseQuality,
+		BaseQualPenalty:          *baseQualPenalty,
+		ConsensusQuality:         *consensusQual,
+		MaxSoftClipFraction:      *maxSoftClipFraction,
+		EndPad:                   *endPad,
+		CountOverlappingPairs:    *countOverlappingPairs,
+		UseMdTag:                 *useMd,
+		CallSingleStrand:         *callSingleStrand,
+		MaxVariantsPerReadFamily: *maxVariantsPerReadFamily,
+		StrandErrorRate:          *strandErrorRate,
+		OrientationBias:          *orientationBias,
+		MinOrientationP:          *minOrientationP,
+		MaxHomopolymer:           *maxHomopolymer,
+		MaxDinucRepeat:           *maxDinucRepeat,
+		TagRepeat:                *tagRepeat,
+		MinOxoGP:                 *minOxoGP,
+		MinDeaminationEndDist:    *minDeaminationEndDist,
+		TagDamage:                *tagDamage,
+		MnvMaxDist:               *mnvMaxDist,
+		SecondaryAf:              *secondaryAf,
+		GroupTag:                 *groupTag,
+		ForceCallVcf:             *forceCall,
+		MaxFamilyDepth:           *maxFamilyDepth,
+		DownsampleSeed:           *downsampleSeed,
+		MinBreakendClip:          *minBreakendClip,
+		EmitFiltered:             *emitFiltered,
+		GVCF:                     *gvcf,
+		NormalBam:                *normalBam,
+		MaxNormalAltReads:        *maxNormalAltReads,
+		TagNormal:                *tagNormal,
+		PopVcf:                   *popVcf,
+		PopAfField:               *popAfField,
+		MaxPopAf:                 *maxPopAf,
+		RemovePop:                *removePop,
+	}
+	if *familyInfo != "" {
+		var err error
+		opts.FamilyInfo, err = mcscall.FamilyColumns(bedFiles[0], strings.Split(*familyInfo, ","))
+		if err != nil {
+			log.Fatalf("ERROR: -familyInfo: %s", err)
+		}
+	}
+
+	var stats *mcscall.Stats
+	if *metricsAddr != "" || *summaryOut != "" {
+		stats = new(mcscall.Stats)
+	}
+	if *metricsAddr != "" {
+		registry := new(metrics.Registry)
+		stats.Register(registry)
+		err := metrics.Serve(*metricsAddr, registry)
+		if err != nil {
+			log.Fatalf("ERROR: could not serve metrics: %s", err)
+		}
+	}
+
+	regions := readRegions(regionArgs)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	p := callParams{
+		input:                  inputs[0],
+		output:                 *output,
+		outputType:             *outputType,
+		ref:                    *ref,
+		bedFile:                bedFiles[0],
+		calledSitesOut:         *calledSitesOut,
+		rejectsOut:             *rejectsOut,
+		spectrumOut:            *spectrumOut,
+		callableOut:            *callableOut,
+		summaryOut:             *summaryOut,
+		evidenceBam:            *evidenceBam,
+		consensusOut:           *consensusOut,
+		footprintOut:           *footprintOut,
+		debugOut:               *debugOut,
+		annotFields:            *annotFields,
+		excludeBeds:            excludeBeds,
+		regions:                regions,
+		sh:                     sh,
+		opts:                   opts,
+		stats:                  stats,
+		minContigSize:          *minContigSize,
+		minReadFamilyLength:    *minReadFamilyLength,
+		maxOverlappingFamilies: *maxOverlappingFamilies,
+		debugLevel:             *debugLevel,
+		threads:                *threads,
+		stream:                 *stream,
+		byContig:               *byContig,
+		resume:                 *resume,
+		checkpointEvery:        *checkpointEvery,
+		progressEvery:          *progressEvery,
+		mem:                    newMemCeiling(uint64(maxMem)),
+	}
+	if len(inputs) > 1 {
+		callSamples(ctx, inputs, bedFiles, p)
+	} else {
+		mcsCallVariants(ctx, p)
+	}
+	if ctx.Err() != nil {
+		exit.Fatalf(exit.Interrupted, "interrupted before all read families were processed. Output is truncated.")
+	}
+
+	if *memprofile != "" {
+		f, err := os.Create(*memprofile)
+		if err != nil {
+			log.Fatal("could not create memory profile: ", err)
+		}
+		defer f.Close() // error handling omitted for example
+		runtime.GC()    // get up-to-date statistics
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			log.Fatal("could not write memory profile: ", err)
+		}
+	}
+}
+
+// callParams are the inputs, outputs and options of a call of mcsCallVariants. Outputs
+// left "" are not written.
+type callParams struct {
+	input                  string
+	output                 string
+	outputType             string
+	ref                    string
+	bedFile                string
+	calledSitesOut         string
+	rejectsOut             string
+	spectrumOut            string
+	callableOut            string
+	summaryOut             string
+	evidenceBam            string
+	consensusOut           string
+	footprintOut           string
+	debugOut               string
+	annotFields            string
+	excludeBeds            []string
+	regions                []bed.Bed
+	sh                     shard.Shard
+	opts                   mcscall.Options
+	stats                  *mcscall.Stats
+	minContigSize          int
+	minReadFamilyLength    int
+	maxOverlappingFamilies int
+	debugLevel             int
+	threads                int
+	stream                 bool
+	byContig               bool
+	resume                 bool
+	checkpointEvery        time.Duration
+	progressEvery          time.Duration
+	mem                    *memCeiling
+}
+
+// mcsCallVariants calls variants in each read family until all are processed or ctx is
+// cancelled. If cancelled, the workers finish their current family and the VCF and
+// called sites bed are closed with a truncation marker as the last line. The same is
+// done with -salvage when an input turns out to be truncated or corrupt. If rejectsOut
+// is set, candidates that fail a calling filter are written to it, and also to output
+// with opts.EmitFiltered. If spectrumOut is set, the SBS96 spectrum of the passing SNVs
+// is written to it once every family has been called, as are the callable bases of each
+// contig to callableOut. A summary of the run is written to summaryOut, which requires
+// stats, whether or not the run completes, the reads that carry the calls written to
+// output to evidenceBam, the duplex consensus of each family to consensusOut, and the
+// interval of each family interrogated to footprintOut. Only the INFO and FORMAT fields
+// in annotFields are written, or every field if it is "". With
+// byContig, the families of each contig are called by their own pool of workers and the
+// calls are sorted through a shard for each contig, as are the calls and rejects written
+// to a tabix indexed .vcf.gz. Progress is logged every progressEvery.
+func mcsCallVariants(ctx context.Context, p callParams) {
+	// progress tracking
+	startTime := time.Now().UnixMilli()
+
+	//var excludedRegions map[string]*interval.IntervalNode
+	refIdx := fai.ReadIndex(p.ref + ".fai")
+	if !p.stream {
+		pipe.RequireIndexable(p.input, "bam") // preflight skips pipes
+	}
+	preflight.Bam{Sorted: true, Indexed: !p.stream, Tags: []string{"RF"}, Qualities: true}.Check(p.input)
+	if p.opts.NormalBam != "" {
+		pipe.RequireIndexable(p.opts.NormalBam, "bam")
+		preflight.Bam{Sorted: true, Indexed: true}.Check(p.opts.NormalBam)
+	}
+	intervals.CheckContigs(p.regions, refIdx, "-R")
+	inRegions := newRegionFilter(p.regions)
+	var sum summary
+	p.bedFile, _ = filterInputBed(p.bedFile, p.excludeBeds, inRegions, p.sh, p.maxOverlappingFamilies, p.opts.MinTotalDepth, p.opts.MinStrandedDepth, p.minContigSize, p.minReadFamilyLength, refIdx, &sum.FamiliesSkipped)
+
+	// with checkpoints, outputs are flushed and their sizes recorded as the run goes
+	checkpointing := p.checkpointEvery > 0 || p.resume
+	var resumed checkpoint
+	var vcfFile, rejectsFile, sitesFile *resumableFile
+	optsMd5 := ""
+	if checkpointing {
+		optsMd5 = optionsMd5(p) // before opts is changed for -rejectsOut below
+	}
+	if p.resume {
+		resumed = readCheckpoint(p.output, p.bedFile, optsMd5)
+		log.Printf("Resuming after %d read families, the last %s.", resumed.Families, resumed.LastFamily)
+	}
+	var calledSitesBed, vcfOut io.WriteCloser
+	if checkpointing {
+		sitesFile = createResumable(p.calledSitesOut, p.resume, resumed.CalledSites)
+		vcfFile = createResumable(p.output, p.resume, resumed.Vcf)
+		calledSitesBed, vcfOut = sitesFile, vcfFile
+	} else {
+		calledSitesBed = tabix.Create(p.calledSitesOut)
+		vcfOut = createOutput(p.output, p.outputType)
+	}
+	defer cleanup(calledSitesBed)
+	header := callHeader(p.input, p.ref, p.opts)
+	annot := parseAnnotations(p.annotFields, mcscall.AddFilterHeader(header, p.opts))
+	if !p.resume {
+		vcf.NewWriteHeader(vcfOut, provenance.Vcf(annot.header(header)))
+	}
+	var rejectsVcf io.WriteCloser
+	emitFiltered := p.opts.EmitFiltered
+	if p.rejectsOut != "" {
+		if checkpointing {
+			rejectsFile = createResumable(p.rejectsOut, p.resume, resumed.Rejects)
+			rejectsVcf = rejectsFile
+		} else {
+			rejectsVcf = createOutput(p.rejectsOut, "")
+		}
+		if !emitFiltered {
+			header = mcscall.AddFilterHeader(header, p.opts)
+		}
+		if !p.resume {
+			vcf.NewWriteHeader(rejectsVcf, provenance.Vcf(annot.header(header)))
+		}
+		p.opts.EmitFiltered = true // split from the calls below
+	}
+	familiesMd5 := ""
+	if checkpointing {
+		familiesMd5 = fileMd5(p.bedFile)
+	}
+	var bedChan <-chan bed.Bed
+	var bamStream *mcscall.Stream
+	if p.stream {
+		bamStream = mcscall.OpenStream(p.input)
+		bedChan = streamFamilies(ctx, bamStream, p.bedFile, p.input)
+	} else {
+		bedChan = bed.GoReadToChan(p.bedFile)
+	}
+	if p.resume {
+		bedChan = skipFamilies(bedChan, resumed.Families)
+	}
+	var consensusFile, footprintFile, debugFile io.WriteCloser
+	var consensusChan chan fasta.Fasta
+	var footprintChan chan bed.Bed
+	var debugOutChan chan string
+
+	if p.consensusOut != "" {
+		consensusFile = fileio.EasyCreate(p.consensusOut)
+		defer cleanup(consensusFile)
+		consensusChan = make(chan fasta.Fasta, 1000)
+	}
+
+	if p.footprintOut != "" {
+		footprintFile = tabix.Create(p.footprintOut)
+		defer cleanup(footprintFile)
+		footprintChan = make(chan bed.Bed, 1000)
+	}
+
+	if p.debugOut != "" {
+		debugFile = fileio.EasyCreate(p.debugOut)
+		defer cleanup(debugFile)
+		debugOutChan = make(chan string)
+	}
+
+	var err error
+
+	// overhead for multithreading
+	wg := new(sync.WaitGroup)
+	outputChan := make(chan result, p.mem.buffer(p.threads))
+	calledSitesBedChan := make(chan bed.Bed, 1000)
+	var evidenceChan chan []sam.Sam
+	if p.evidenceBam != "" {
+		evidenceChan = make(chan []sam.Sam, p.mem.buffer(p.threads))
+	}
+	workers := make([]worker, p.threads)
+	var shards, rejectShards *contigShards
+	var contigs []string
+	if indexedOutput(p.output, p.outputType) || indexedOutput(p.rejectsOut, "") { // the index needs the calls sorted
+		contigs = refIdx.Names()
+	}
+	if indexedOutput(p.output, p.outputType) {
+		shards = newContigShards(p.output)
+	}
+	if indexedOutput(p.rejectsOut, "") {
+		rejectShards = newContigShards(p.rejectsOut)
+	}
+	if p.byContig {
+		if shards == nil {
+			shards = newContigShards(p.output)
+		}
+		header := inputHeader(p.input, nil)
+		contigs = nil
+		for _, c := range header.Chroms {
+			contigs = append(contigs, c.Name)
+		}
+		wg.Add(1)
+		go callByContig(ctx, contigs, splitByContig(p.bedFile, header, p.input), outputChan, calledSitesBedChan, evidenceChan, consensusChan, footprintChan, p.input, p.ref, p.opts, p.stats, p.mem, workers, wg, debugOutChan, p.debugLevel)
+	} else if (deterministic.Enabled() && p.threads > 1) || checkpointing { // a checkpoint needs the families before it written
+		wg.Add(1)
+		go callInOrder(ctx, bedChan, outputChan, evidenceChan, consensusChan, footprintChan, p.input, bamStream, p.ref, p.opts, p.stats, p.mem, workers, wg, debugOutChan)
+	} else {
+		for i := 0; i < p.threads; i++ {
+			wg.Add(1)
+			go spawnThread(ctx, bedChan, outputChan, calledSitesBedChan, evidenceChan, consensusChan, footprintChan, p.input, bamStream, p.ref, p.opts, p.stats, p.mem, &workers[i], wg, debugOutChan)
+		}
+	}
+
+	// spawn a goroutine to wait until threads are done, then close the output
+	go func(*sync.WaitGroup) {
+		wg.Wait()
+		close(outputChan)
+		close(calledSitesBedChan)
+		if evidenceChan != nil {
+			close(evidenceChan)
+		}
+		if consensusChan != nil {
+			close(consensusChan)
+		}
+		if footprintChan != nil {
+			close(footprintChan)
+		}
+		if debugOutChan != nil {
+			close(debugOutChan)
+		}
+	}(wg)
+
+	// spawn a gorountine to write calledSitesBed, which are sent with the calls of each
+	// family when called in order
+	var callable callableBases
+	writeSites := func(b bed.Bed) {
+		for _, part := range inRegions.clip(b) {
+			bed.WriteBed(calledSitesBed, part)
+			if p.callableOut != "" {
+				callable.add(part)
+			}
+		}
+	}
+	writers := new(sync.WaitGroup)
+	writers.Add(1)
+	go func() {
+		for b := range calledSitesBedChan {
+			writeSites(b)
+		}
+		writers.Done()
+	}()
+
+	var evidence *evidenceSorter
+	if evidenceChan != nil {
+		evidence = newEvidenceSorter(inputHeader(p.input, bamStream))
+		writers.Add(1)
+		go func() {
+			for reads := range evidenceChan {
+				evidence.add(reads)
+			}
+			writers.Done()
+		}()
+	}
+
+	if consensusChan != nil {
+		writers.Add(1)
+		go func() {
+			for f := range consensusChan {
+				fasta.WriteFasta(consensusFile, f, 80)
+			}
+			writers.Done()
+		}()
+	}
+
+	if footprintChan != nil {
+		writers.Add(1)
+		go func() {
+			for b := range footprintChan {
+				bed.WriteBed(footprintFile, b)
+			}
+			writers.Done()
+		}()
+	}
+
+	if debugFile != nil {
+		writers.Add(1)
+		go func() {
+			for s := range debugOutChan {
+				fmt.Fprintln(debugFile, s)
+			}
+			writers.Done()
+		}()
+	}
+
+	var spectrum []int
+	var refSeeker *fai.Seeker
+	if p.spectrumOut != "" {
+		spectrum = make([]int, len(sbs.Types))
+		refSeeker = fai.NewSeeker(p.ref)
+		defer cleanup(refSeeker)
+	}
+	if p.resume && (p.summaryOut != "" || spectrum != nil || p.callableOut != "") {
+		var restored *callableBases
+		if p.callableOut != "" {
+			restored = &callable
+		}
+		restoreCounts(p.output, p.calledSitesOut, &sum.Variants, spectrum, refSeeker, restored)
+	}
+
+	var familiesProcessed int
+	var lastVar vcf.Vcf
+	written := make(map[string]bool) // IDs of the calls written, for evidenceBam
+	lastCheckpointTime := startTime
+	currTime := startTime
+	saved := resumed
+	saved.FamiliesMd5 = familiesMd5
+	saved.OptionsMd5 = optsMd5
+	save := func() {
+		saved.Vcf, saved.CalledSites = vcfFile.size(), sitesFile.size()
+		if rejectsFile != nil {
+			saved.Rejects = rejectsFile.size()
+		}
+		saved.write(p.output)
+	}
+	lastSave := time.Now()
+	prog := newProgress(countFamilies(p.bedFile)-resumed.Families, p.progressEvery)
+	for r := range outputChan {
+		familiesProcessed++
+		for _, b := range r.sites {
+			writeSites(b)
+		}
+		v := r.vcfs
+		if p.debugLevel > 0 && familiesProcessed%1000 == 0 {
+			currTime = time.Now().UnixMilli()
+			log.Printf("Processed 1000 Read Families in:\t%dsec\t%s:%d", (currTime-lastCheckpointTime)/1000, lastVar.Chr, lastVar.Pos)
+			lastCheckpointTime = currTime
+		}
+
+		if len(v) > 0 {
+			for i := range v {
+				//		if len(interval.Query(excludedRegions, v[i], "any")) > 0 {
+				//			continue
+				//		}
+				if !inRegions.keep(v[i]) {
+					continue
+				}
+				if rejectsVcf != nil && mcscall.IsRejected(v[i]) {
+					if rejectShards != nil {
+						vcf.WriteVcf(rejectShards.writer(v[i].Chr), annot.apply(v[i]))
+					} else {
+						vcf.WriteVcf(rejectsVcf, annot.apply(v[i]))
+					}
+					if !emitFiltered {
+						continue
+					}
+				}
+				if shards != nil {
+					vcf.WriteVcf(shards.writer(v[i].Chr), annot.apply(v[i]))
+				} else {
+					vcf.WriteVcf(vcfOut, annot.apply(v[i]))
+				}
+				if evidenceChan != nil {
+					written[v[i].Id] = true
+				}
+				sum.Variants.add(v[i])
+				if spectrum != nil && (v[i].Filter == "." || v[i].Filter == "PASS") {
+					if idx, found := sbs.Index(sbs.Class(v[i], 0, refSeeker)); found {
+						spectrum[idx]++
+					}
+				}
+			}
+			lastVar = v[len(v)-1]
+			//}
+		}
+		prog.update(familiesProcessed, lastVar)
+		if checkpointing {
+			saved.Families++
+			saved.LastFamily = familyName(r.b)
+			if p.checkpointEvery > 0 && time.Since(lastSave) >= p.checkpointEvery {
+				save()
+				lastSave = time.Now()
+			}
+		}
+	}
+
+	writers.Wait()
+	if p.mem != nil && p.mem.throttled > 0 {
+		log.Printf("%d read families waited for the heap to fall below -maxMem.", p.mem.throttled)
+	}
+
+	if shards != nil {
+		shards.concat(vcfOut, contigs)
+	}
+	if rejectShards != nil {
+		rejectShards.concat(rejectsVcf, contigs)
+	}
+
+	endTime := time.Now().UnixMilli()
+	sum.Status = "completed"
+	if ctx.Err() != nil {
+		sum.Status = "interrupted"
+		if checkpointing {
+			save() // before the markers, which a resumed run cuts off
+		}
+		fmt.Fprintln(vcfOut, truncatedMarker)
+		fmt.Fprintln(calledSitesBed, truncatedMarker)
+		if rejectsVcf != nil {
+			fmt.Fprintln(rejectsVcf, truncatedMarker)
+		}
+		log.Printf("Interrupted\nRead Families Processed: %d\nTotal Runtime: %d Minutes\n", familiesProcessed, ((endTime-startTime)/1000)/60)
+	} else if marker := salvage.Marker(); marker != "" {
+		sum.Status = "salvaged"
+		fmt.Fprintln(vcfOut, marker)
+		fmt.Fprintln(calledSitesBed, marker)
+		if rejectsVcf != nil {
+			fmt.Fprintln(rejectsVcf, marker)
+		}
+		log.Printf("Salvaged partial output\nRead Families Processed: %d\nTotal Runtime: %d Minutes\n", familiesProcessed, ((endTime-startTime)/1000)/60)
+	} else {
+		log.Printf("Successfully Completed\nRead Families Processed: %d\nTotal Runtime: %d Minutes\n", familiesProcessed, ((endTime-startTime)/1000)/60)
+		if spectrum != nil {
+			writeSpectrum(p.spectrumOut, sampleName(p.input), spectrum)
+		}
+		if p.callableOut != "" {
+			callable.write(p.callableOut, refIdx.Names())
+		}
+		if checkpointing {
+			err = os.Remove(checkpointFile(p.output))
+			if err != nil && !os.IsNotExist(err) {
+				exception.PanicOnErr(err)
+			}
+		}
+	}
+	if ctx.Err() != nil || salvage.Marker() != "" {
+		for _, out := range []string{p.spectrumOut, p.callableOut} {
+			if out != "" {
+				log.Printf("WARNING: %s was not written as not every family was called.", out)
+			}
+		}
+	}
+
+	err = vcfOut.Close()
+	exception.PanicOnErr(err)
+	if rejectsVcf != nil {
+		cleanup(rejectsVcf)
+	}
+	if p.evidenceBam != "" {
+		evidence.write(p.evidenceBam, written)
+	}
+	if p.summaryOut != "" {
+		sum.finish(sampleName(p.input), p.stats, workers, time.Duration(endTime-startTime)*time.Millisecond)
+		sum.write(p.summaryOut)
+	}
+}
+
+func spawnThread(ctx context.Context, inputChan <-chan bed.Bed, outputChan chan<- result, calledSitesBedChan chan<- bed.Bed, evidenceChan chan<- []sam.Sam, consensusChan chan<- fasta.Fasta, footprintChan chan<- bed.Bed, inputBam string, bamStream *mcscall.Stream, ref string, opts mcscall.Options, stats *mcscall.Stats, mem *memCeiling, w *worker, wg *sync.WaitGroup, debugOutChan chan<- string) {
+	caller := newCaller(inputBam, bamStream, ref, opts)
+	caller.CalledSites = calledSitesBedChan
+	caller.Evidence = evidenceChan
+	caller.Consensus = consensusChan
+	caller.Footprints = footprintChan
+	caller.Debug = debugOutChan
+	caller.Stats = stats
+	for b := range inputChan {
+		if ctx.Err() != nil || salvage.Stopped() {
+			break
+		}
+		mem.acquire()
+		start := time.Now()
+		v, ok := callFamily(caller, b, inputBam)
+		w.add(start)
+		mem.release()
+		if !ok {
+			break
+		}
+		outputChan <- result{b: b, vcfs: v}
+	}
+
+	err := caller.Close()
+	if !salvage.Stopped() {
+		exception.PanicOnErr(err)
+	}
+	wg.Done()
+}
+
+// newCaller returns a Caller of inputBam, which reads families from bamStream if it is
+// not nil.
+func newCaller(inputBam string, bamStream *mcscall.Stream, ref string, opts mcscall.Options) *mcscall.Caller {
+	if bamStream != nil {
+		return mcscall.NewStreamCaller(bamStream, ref, opts)
+	}
+	return mcscall.NewCaller(inputBam, ref, opts)
+}
+
+// callFamily calls the variants of family b. If the reads of the family cannot be read
+// from inputBam, the problem is reported with salvage.Stop and ok is false.
+func callFamily(caller *mcscall.Caller, b bed.Bed, inputBam string) (v []vcf.Vcf, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			salvage.Stop("could not read family %s at %s:%d-%d from %s, which may be truncated or corrupt: %v.", b.Name, b.Chrom, b.ChromStart+1, b.ChromEnd, inputBam, r)
+		}
+	}()
+	return caller.CallFamily(b), true
+}
+
+// family is a read family numbered in the order it was read.
+type family struct {
+	i int
+	b bed.Bed
+}
+
+// result holds the variants of the read family b numbered i, and its called sites when
+// they are collected rather than sent as they are found.
+type result struct {
+	i     int
+	b     bed.Bed
+	vcfs  []vcf.Vcf
+	sites []bed.Bed
+}
+
+// callInOrder calls read families on threads goroutines like spawnThread, but sends the
+// variants and called sites of each family together, in the order the families are read
+// from inputChan, so output does not depend on thread scheduling. At most 16 families
+// per thread, or 2 under a memory ceiling, are held waiting for an earlier family to
+// finish.
+func callInOrder(ctx context.Context, inputChan <-chan bed.Bed, outputChan chan<- result, evidenceChan chan<- []sam.Sam, consensusChan chan<- fasta.Fasta, footprintChan chan<- bed.Bed, inputBam string, bamStream *mcscall.Stream, ref string, opts mcscall.Options, stats *mcscall.Stats, mem *memCeiling, workers []worker, wg *sync.WaitGroup, debugOutChan chan<- string) {
+	defer wg.Done()
+	threads := len(workers)
+	held := 16
+	if mem != nil {
+		held = 2
+	}
+	window := make(chan struct{}, held*threads)
+	families := make(chan family)
+	go func() {
+		var i int
+		for b := range inputChan {
+			window <- struct{}{}
+			families <- family{i: i, b: b}
+			i++
+		}
+		close(families)
+	}()
+
+	results := make(chan result, threads)
+	running := new(sync.WaitGroup)
+	for i := 0; i < threads; i++ {
+		running.Add(1)
+		go spawnOrderedThread(ctx, families, results, evidenceChan, consensusChan, footprintChan, inputBam, bamStream, ref, opts, stats, mem, &workers[i], running, debugOutChan)
+	}
+	go func() {
+		running.Wait()
+		close(results)
+	}()
+
+	pending := make(map[int]result)
+	var next int
+	for r := range results {
+		pending[r.i] = r
+		for r, found := pending[next]; found; r, found = pending[next] {
+			delete(pending, next)
+			outputChan <- r
+			<-window
+			next++
+		}
+	}
+}
+
+// spawnOrderedThread calls the families from inputChan and sends each result, with the
+// called sites of the family collected rather than sent as they are found.
+func spawnOrderedThread(ctx context.Context, inputChan <-chan family, outputChan chan<- result, evidenceChan chan<- []sam.Sam, consensusChan chan<- fasta.Fasta, footprintChan chan<- bed.Bed, inputBam string, bamStream *mcscall.Stream, ref string, opts mcscall.Options, stats *mcscall.Stats, mem *memCeiling, w *worker, wg *sync.WaitGroup, debugOutChan chan<- string) {
+	caller := newCaller(inputBam, bamStream, ref, opts)
+	sites := make(chan bed.Bed)
+	batches := make(chan []bed.Bed)
+	go func() {
+		var batch []bed.Bed
+		for b := range sites {
+			if b.Chrom == "" { // sent after each family
+				batches <- batch
+				batch = nil
+				continue
+			}
+			batch = append(batch, b)
+		}
+	}()
+	caller.CalledSites = sites
+	caller.Evidence = evidenceChan
+	caller.Consensus = consensusChan
+	caller.Footprints = footprintChan
+	caller.Debug = debugOutChan
+	caller.Stats = stats
+	for f := range inputChan {
+		if ctx.Err() != nil || salvage.Stopped() {
+			break
+		}
+		mem.acquire()
+		start := time.Now()
+		vcfs, ok := callFamily(caller, f.b, inputBam)
+		w.add(start)
+		mem.release()
+		sites <- bed.Bed{}
+		if !ok {
+			<-batches
+			break
+		}
+		outputChan <- result{i: f.i, b: f.b, vcfs: vcfs, sites: <-batches}
+	}
+	close(sites)
+
+	err := caller.Close()
+	if !salvage.Stopped() {
+		exception.PanicOnErr(err)
+	}
+	wg.Done()
+}
+
+// filterInputBed writes the families in bedFile that pass the depth, length, overlap, and
+// exclusion filters to a new bed in tmp.Dir and returns its name. Only the families of sh
+// are kept, and only those overlapping regions. Columns after the watson and crick counts
+// are written unchanged. The families removed by each filter are counted in skipped.
+func filterInputBed(bedFile string, excludeBeds []string, regions regionFilter, sh shard.Shard, maxOverlaps, minTotalDepth, minStrandedDepth, minContigSize, minReadFamilyLength int, refIdx fai.Index, skipped *familySkips) (string, map[string]*interval.IntervalNode) {
+	var excludeIntervals []interval.Interval
+	var tree map[string]*interval.IntervalNode
+	for _, e := range excludeBeds {
+		for _, b := range intervals.ReadMerged(e) {
+			excludeIntervals = append(excludeIntervals, b)
+		}
+	}
+	tree = interval.BuildTree(excludeIntervals)
+
+	outfile := tmp.Path(strings.TrimSuffix(filepath.Base(bedFile), ".bed") + sh.Suffix() + ".analysis.bed")
+	beds := intervals.GoReadToChan(bedFile)
+	out := fileio.EasyCreate(outfile)
+	var families int
+	write := func(b bed.Bed) {
+		if !regions.overlaps(b) { // after the overlap filter, so families are filtered as in a run without regions
+			skipped.Region++
+			return
+		}
+		if sh.Keep(families) {
+			bed.WriteBed(out, b)
+		} else {
+			skipped.OtherShard++
+		}
+		families++
+	}
+	overlaps := make([]bed.Bed, 0, 1000)
+	var watsonDepth, crickDepth int
+	for b := range beds {
+		if !refIdx.Contains(b.Chrom) {
+			exit.Fatalf(exit.ContigMismatch, "%s in %s is not in the reference. The family bed must be made from a bam aligned to the same reference.", b.Chrom, bedFile)
+		}
+		if len(b.Annotation) < 2 {
+			exit.Fatalf(exit.MissingTag, "bed record is missing watson and crick read counts. Was it generated with annotateReadFamilies?\n%s", bed.ToString(b, b.FieldsInitialized))
+		}
+		if refIdx.Size(b.Chrom) < minContigSize {
+			skipped.ContigSize++
+			continue
+		}
+		if b.ChromEnd-b.ChromStart < minReadFamilyLength {
+			skipped.Length++
+			continue
+		}
+		switch {
+		case len(overlaps) == 0:
+			overlaps = append(overlaps, b)
+
+		case bed.Overlap(overlaps[0], b):
+			overlaps = append(overlaps, b)
+
+		default: // does not overlap
+			if len(overlaps) <= maxOverlaps { // write
+				for i := range overlaps {
+					watsonDepth, _ = strconv.Atoi(overlaps[i].Annotation[0])
+					crickDepth, _ = strconv.Atoi(overlaps[i].Annotation[1])
+					if watsonDepth+crickDepth < minTotalDepth {
+						skipped.Depth++
+						continue
+					}
+					if minStrandedDepth == 0 && (watsonDepth < minStrandedDepth && crickDepth < minStrandedDepth) {
+						skipped.Depth++
+						continue
+					}
+					if minStrandedDepth > 0 && (watsonDepth < minStrandedDepth || crickDepth < minStrandedDepth) {
+						skipped.Depth++
+						continue
+					}
+					if len(excludeBeds) > 0 && len(interval.Query(tree, overlaps[i], "any")) > 0 { // REMOVE IF ANY OVERLAP WITH EXCLUDED REGIONS switch to "di" for // query entirely contained within excluded region
+						skipped.Excluded++
+						continue
+					}
+					write(overlaps[i])
+				}
+			} else {
+				skipped.Overlapping += len(overlaps)
+			}
+			overlaps = overlaps[:0]
+			overlaps = append(overlaps, b)
+		}
+	}
+
+	if len(overlaps) == 1 {
+		write(overlaps[0])
+	}
+	err := out.Close()
+	exception.PanicOnErr(err)
+	return outfile, tree
+}
+
+// sampleName returns the name of the sample in input, the bam without its .bam suffix,
+// or stdin if it was read from -i -.
+func sampleName(input string) string {
+	if pipe.Name(input) == "-" {
+		return "stdin"
+	}
+	return strings.TrimSuffix(input, ".bam")
+}
+
+// callHeader returns the VCF header of the calls made from input with opts.
+func callHeader(input, ref string, opts mcscall.Options) vcf.Header {
+	header := mcscall.CallHeader(sampleName(input), ref, opts)
+	if config.File() != "" {
+		header = config.AddVcfHeader(header, flag.CommandLine)
+	}
+	return header
+}
+
+// indexedOutput reports whether createOutput writes output with a tabix index, for which
+// the calls are sorted through a shard for each contig before they are written.
+func indexedOutput(output, outputType string) bool {
+	switch {
+	case output == "" || output == "stdout" || bcf.IsBcf(output) && outputType == "":
+		return false
+	case outputType == "z":
+		return true
+	default:
+		return outputType == "" && tabix.Indexed(output)
+	}
+}
+
+// createOutput opens the VCF output as outputType (v, z, or b), or as the type given by
+// the extension of output if outputType is "".
+func createOutput(output, outputType string) io.WriteCloser {
+	if outputType == "" && bcf.IsBcf(output) {
+		outputType = "b"
+	}
+	switch {
+	case outputType == "b":
+		return bcf.Create(output)
+	case outputType == "z" && output == "stdout":
+		return bgzf.NewWriter(os.Stdout)
+	case outputType == "z":
+		return tabix.NewWriter(output) // indexed as .vcf.gz is
+	case outputType == "v" && output != "stdout":
+		return fileio.EasyCreate(output)
+	default:
+		return tabix.Create(output)
+	}
+}
+
+// callableBases collects the called sites of each contig.
+type callableBases struct {
+	sites map[string][]bed.Bed
+}
+
+func (c *callableBases) add(b bed.Bed) {
+	if c.sites == nil {
+		c.sites = make(map[string][]bed.Bed)
+	}
+	c.sites[b.Chrom] = append(c.sites[b.Chrom], bed.Bed{Chrom: b.Chrom, ChromStart: b.ChromStart, ChromEnd: b.ChromEnd})
+}
+
+// write writes the duplex and distinct bases of each contig with called sites, in the
+// order of contigs.
+func (c *callableBases) write(filename string, contigs []string) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "#chrom\tduplexBases\tdistinctBases")
+	exception.PanicOnErr(err)
+	var totalDuplex, totalDistinct int
+	for _, chrom := range contigs {
+		sites := c.sites[chrom]
+		if len(sites) == 0 {
+			continue
+		}
+		sort.Slice(sites, func(i, j int) bool {
+			return sites[i].ChromStart < sites[j].ChromStart
+		})
+		var duplex, distinct, end int
+		for _, b := range sites {
+			duplex += b.ChromEnd - b.ChromStart
+			if b.ChromStart > end {
+				end = b.ChromStart
+			}
+			if b.ChromEnd > end {
+				distinct += b.ChromEnd - end
+				end = b.ChromEnd
+			}
+		}
+		_, err = fmt.Fprintf(out, "%s\t%d\t%d\n", chrom, duplex, distinct)
+		exception.PanicOnErr(err)
+		totalDuplex += duplex
+		totalDistinct += distinct
+	}
+	_, err = fmt.Fprintf(out, "total\t%d\t%d\n", totalDuplex, totalDistinct)
+	exception.PanicOnErr(err)
+}
+
+// writeSpectrum writes the SBS96 counts of sample in the order of sbs.Types.
+func writeSpectrum(filename, sample string, counts []int) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	_, err := fmt.Fprintf(out, "MutationType\t%s\n", sample)
+	exception.PanicOnErr(err)
+	for i := range sbs.Types {
+		_, err = fmt.Fprintf(out, "%s\t%d\n", sbs.Types[i], counts[i])
+		exception.PanicOnErr(err)
+	}
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,106 @@
+package mcsCallVariants
+
+import (
+	"fmt"
+	"runtime/debug"
+	"runtime/metrics"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// byteSize is a flag.Value for an amount of memory in bytes, or in KiB, MiB, GiB, or TiB
+// with a K, M, G, or T suffix (e.g. 8G).
+type byteSize uint64
+
+func (b *byteSize) String() string {
+	if *b == 0 {
+		return ""
+	}
+	return strconv.FormatUint(uint64(*b), 10)
+}
+
+func (b *byteSize) Set(s string) error {
+	number := strings.TrimSuffix(strings.ToUpper(s), "B")
+	var shift uint
+	if n := len(number); n > 0 {
+		if i := strings.IndexByte("KMGT", number[n-1]); i >= 0 {
+			shift = 10 * uint(i+1)
+			number = number[:n-1]
+		}
+	}
+	f, err := strconv.ParseFloat(number, 64)
+	if err != nil || f < 0 {
+		return fmt.Errorf("'%s' is not an amount of memory such as 8G or 512M", s)
+	}
+	*b = byteSize(f * float64(uint64(1)<<shift))
+	return nil
+}
+
+// memCeiling holds workers back from starting a family while the live heap is above
+// -maxMem, so that a region of huge families cannot grow the heap without bound. One
+// worker may always run, so a heap held above the ceiling by data outside the families
+// slows the run down instead of stopping it. A nil memCeiling does nothing.
+type memCeiling struct {
+	limit     uint64
+	mu        sync.Mutex
+	freed     *sync.Cond // signalled when a worker finishes a family
+	running   int
+	throttled int // families started late because of the ceiling
+	sample    []metrics.Sample
+}
+
+// newMemCeiling returns a memCeiling of limit bytes, or nil if limit is 0. The garbage
+// collector is also set to run more often as memory use nears limit.
+func newMemCeiling(limit uint64) *memCeiling {
+	if limit == 0 {
+		return nil
+	}
+	debug.SetMemoryLimit(int64(limit))
+	m := &memCeiling{limit: limit, sample: []metrics.Sample{{Name: "/memory/classes/heap/objects:bytes"}}}
+	m.freed = sync.NewCond(&m.mu)
+	return m
+}
+
+// acquire waits until the heap is below the ceiling or no other worker is calling a
+// family, and counts the caller as running until release.
+func (m *memCeiling) acquire() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.running > 0 && m.heap() > m.limit {
+		m.throttled++
+		for m.running > 0 && m.heap() > m.limit {
+			m.freed.Wait()
+		}
+	}
+	m.running++
+}
+
+// release ends the family of a worker started with acquire.
+func (m *memCeiling) release() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.running--
+	m.mu.Unlock()
+	m.freed.Broadcast()
+}
+
+// heap returns the bytes of heap objects, live or not yet collected. m.mu must be held.
+func (m *memCeiling) heap() uint64 {
+	metrics.Read(m.sample)
+	return m.sample[0].Value.Uint64()
+}
+
+// buffer returns the number of results that may wait for the writer, which is smaller
+// under a ceiling so that fewer variant slices are held.
+func (m *memCeiling) buffer(threads int) int {
+	if m == nil {
+		return 100
+	}
+	return threads
+}
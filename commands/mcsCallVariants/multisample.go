@@ -0,0 +1,255 @@
+package mcsCallVariants
+
+import (
+	"context"
+	"fmt"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/mcscall"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/salvage"
+	"github.com/dasnellings/duplexTools/shard"
+	"github.com/dasnellings/duplexTools/tmp"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/vcf"
+	"golang.org/x/exp/slices"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// perSampleFlags are the options that write a file for a single sample, or name columns
+// of a single family bed, and so cannot be used when calling more than one sample.
+var perSampleFlags = map[string]bool{
+	"calledSitesOut": true,
+	"rejectsOut":     true,
+	"spectrumOut":    true,
+	"callableOut":    true,
+	"evidenceBam":    true,
+	"consensusOut":   true,
+	"footprintOut":   true,
+	"summaryOut":     true,
+	"debugLog":       true,
+	"familyInfo":     true,
+	"gvcf":           true,
+	"checkpoint":     true,
+	"resume":         true,
+	"byContig":       true,
+	"annot":          true,
+}
+
+// defaultCalledSites returns the name of the called sites bed written next to bedFile.
+func defaultCalledSites(bedFile string, sh shard.Shard) string {
+	return strings.TrimSuffix(bedFile, ".bed") + sh.Suffix() + ".analysis.calledSites.bed"
+}
+
+// multiSite is a variant called in at least one sample.
+type multiSite struct {
+	v       vcf.Vcf  // first call of the variant, with the INFO fields of every call
+	calls   []int    // calls of each sample that pass every filter
+	depth   []int    // duplex depth of each sample
+	pass    bool     // a call passes every filter
+	filters []string // filters failed by calls, if none pass
+}
+
+// callSamples calls the families of bedFiles[i] in inputs[i] for each sample in turn with
+// the options of p, as mcsCallVariants does, reading each bam once with stream, and writes
+// one VCF to p.output with a column for each sample at every variant called in any
+// sample. Each sample has a genotype (GT) of 1 if it has a call that passes every filter,
+// 0 if the site was callable in a family of the sample, and missing otherwise, the number
+// of passing calls (AC), and the duplex depth (DD), which is the number of families of the
+// sample with a called site at the variant. The called sites bed of each sample is written
+// next to its family bed. If the run is stopped, the samples not yet called are missing
+// and output ends with a truncation marker.
+func callSamples(ctx context.Context, inputs, bedFiles []string, p callParams) {
+	names := make([]string, len(inputs))
+	calledSites := make([]string, len(inputs))
+	sites := make(map[string]*multiSite)
+	var called int
+	for i := range inputs {
+		names[i] = sampleName(inputs[i])
+		calledSites[i] = defaultCalledSites(bedFiles[i], p.sh)
+		if ctx.Err() != nil || salvage.Marker() != "" {
+			continue
+		}
+		log.Printf("Calling sample %d of %d: %s", i+1, len(inputs), names[i])
+		sampleVcf := tmp.Path(fmt.Sprintf("sample%d.vcf", i))
+		sample := p // per-sample flags are rejected with more than one input, so are unset in p
+		sample.input, sample.output, sample.outputType = inputs[i], sampleVcf, "v"
+		sample.bedFile, sample.calledSitesOut = bedFiles[i], calledSites[i]
+		mcsCallVariants(ctx, sample)
+		addSampleCalls(sites, sampleVcf, i, len(inputs))
+		called++
+	}
+
+	order := make(map[string]int)
+	for i, name := range fai.ReadIndex(p.ref + ".fai").Names() {
+		order[name] = i
+	}
+	sorted := make([]*multiSite, 0, len(sites))
+	for _, s := range sites {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i].v, sorted[j].v
+		switch {
+		case a.Chr != b.Chr:
+			return order[a.Chr] < order[b.Chr]
+		case a.Pos != b.Pos:
+			return a.Pos < b.Pos
+		case a.Ref != b.Ref:
+			return a.Ref < b.Ref
+		default:
+			return a.Alt[0] < b.Alt[0]
+		}
+	})
+	for i := 0; i < called; i++ {
+		addDuplexDepth(sorted, calledSites[i], i)
+	}
+
+	out := createOutput(p.output, p.outputType)
+	vcf.NewWriteHeader(out, provenance.Vcf(multiSampleHeader(callHeader(inputs[0], p.ref, p.opts), names)))
+	for _, s := range sorted {
+		vcf.WriteVcf(out, s.record(called))
+	}
+	if ctx.Err() != nil {
+		fmt.Fprintln(out, truncatedMarker)
+	} else if marker := salvage.Marker(); marker != "" {
+		fmt.Fprintln(out, marker)
+	}
+	cleanup(out)
+}
+
+// addSampleCalls adds the calls in the VCF of sample i of n to sites.
+func addSampleCalls(sites map[string]*multiSite, filename string, i, n int) {
+	records, _ := vcf.GoReadToChan(filename) // skips a truncation marker
+	for v := range records {
+		if mcscall.IsRefBlock(v) {
+			continue
+		}
+		key := fmt.Sprintf("%s:%d:%s:%s", v.Chr, v.Pos, v.Ref, v.Alt[0])
+		s, found := sites[key]
+		if !found {
+			s = &multiSite{v: v, calls: make([]int, n), depth: make([]int, n)}
+			s.v.Info = mergeInfo("", v.Info)
+			sites[key] = s
+		} else {
+			s.v.Info = mergeInfo(s.v.Info, v.Info)
+			if v.Qual > s.v.Qual {
+				s.v.Qual = v.Qual
+			}
+		}
+		if v.Filter == "." || v.Filter == "PASS" {
+			s.calls[i]++
+			s.pass = true
+			continue
+		}
+		for _, f := range strings.Split(v.Filter, ";") {
+			if !slices.Contains(s.filters, f) {
+				s.filters = append(s.filters, f)
+			}
+		}
+	}
+}
+
+// mergeInfo adds the fields of info that are not yet in merged, except Strand, SA, ED, and
+// the family size and alt fraction fields, which belong to a single family.
+func mergeInfo(merged, info string) string {
+	var keys []string
+	if merged != "" {
+		for _, field := range strings.Split(merged, ";") {
+			key, _, _ := strings.Cut(field, "=")
+			keys = append(keys, key)
+		}
+	}
+	for _, field := range strings.Split(info, ";") {
+		key, _, _ := strings.Cut(field, "=")
+		if key == "" || key == "." || key == "Strand" || key == mcscall.SecondaryInfo || key == mcscall.EndDistInfo || familySizeFields[key] || slices.Contains(keys, key) {
+			continue
+		}
+		keys = append(keys, key)
+		if merged == "" {
+			merged = field
+		} else {
+			merged += ";" + field
+		}
+	}
+	if merged == "" {
+		return "."
+	}
+	return merged
+}
+
+// familySizeFields are the INFO fields of the family size and alt fractions of a call.
+var familySizeFields = map[string]bool{
+	mcscall.WatsonSizeInfo: true,
+	mcscall.CrickSizeInfo:  true,
+	mcscall.WatsonAfInfo:   true,
+	mcscall.CrickAfInfo:    true,
+}
+
+// addDuplexDepth sets the duplex depth of sample i at each site from the called sites
+// bed of the sample.
+func addDuplexDepth(sites []*multiSite, calledSites string, i int) {
+	var families []interval.Interval
+	for _, b := range bed.Read(calledSites) { // skips a truncation marker
+		families = append(families, bed.Bed{Chrom: b.Chrom, ChromStart: b.ChromStart, ChromEnd: b.ChromEnd, FieldsInitialized: 3})
+	}
+	tree := interval.BuildTree(families)
+	for _, s := range sites {
+		pos := s.v.Pos
+		if len(s.v.Ref) > len(s.v.Alt[0]) { // deletions start at the base before the called site
+			pos++
+		}
+		s.depth[i] = len(interval.Query(tree, bed.Bed{Chrom: s.v.Chr, ChromStart: pos - 1, ChromEnd: pos, FieldsInitialized: 3}, "any"))
+	}
+}
+
+// record returns the VCF record of s. Samples after the first called samples, which
+// were not called before the run stopped, are missing.
+func (s *multiSite) record(called int) vcf.Vcf {
+	v := s.v
+	v.Filter = "."
+	if !s.pass {
+		v.Filter = strings.Join(s.filters, ";")
+	}
+	v.Format = []string{"GT", "AC", "DD"}
+	v.Samples = make([]vcf.Sample, len(s.calls))
+	for i := range v.Samples {
+		switch {
+		case i >= called:
+			v.Samples[i].FormatData = []string{"", ".", "."}
+			continue
+		case s.calls[i] > 0:
+			v.Samples[i].Alleles = []int16{1}
+		case s.depth[i] > 0:
+			v.Samples[i].Alleles = []int16{0}
+		}
+		v.Samples[i].FormatData = []string{"", strconv.Itoa(s.calls[i]), strconv.Itoa(s.depth[i])}
+	}
+	return v
+}
+
+// multiSampleHeader returns h, the header of a single sample, with the FORMAT fields of
+// callSamples and a column for each of names.
+func multiSampleHeader(h vcf.Header, names []string) vcf.Header {
+	var ans vcf.Header
+	for _, line := range h.Text {
+		switch {
+		case strings.HasPrefix(line, "##FORMAT"), strings.HasPrefix(line, "##INFO=<ID=Strand,"), strings.HasPrefix(line, "##INFO=<ID="+mcscall.SecondaryInfo+","), strings.HasPrefix(line, "##INFO=<ID="+mcscall.EndDistInfo+","):
+			continue
+		case strings.HasPrefix(line, "##INFO=<ID=") && familySizeFields[strings.SplitN(strings.TrimPrefix(line, "##INFO=<ID="), ",", 2)[0]]:
+			continue
+		case strings.HasPrefix(line, "#CHROM"):
+			ans.Text = append(ans.Text,
+				"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"1 if the sample has a call that passes every filter, 0 if the site was callable in a family of the sample\">",
+				"##FORMAT=<ID=AC,Number=1,Type=Integer,Description=\"Read families of the sample with a call that passes every filter\">",
+				"##FORMAT=<ID=DD,Number=1,Type=Integer,Description=\"Duplex depth: read families of the sample in which the site was callable\">",
+				"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t"+strings.Join(names, "\t"))
+			continue
+		}
+		ans.Text = append(ans.Text, line)
+	}
+	return ans
+}
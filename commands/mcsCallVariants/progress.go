@@ -0,0 +1,66 @@
+package mcsCallVariants
+
+import (
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/vcf"
+	"log"
+	"strings"
+	"time"
+)
+
+// progress logs the fraction of the read families called, the families called per
+// second, and the estimated time remaining once every interval.
+type progress struct {
+	total    int
+	interval time.Duration
+	start    time.Time
+	last     time.Time
+}
+
+// newProgress returns a progress of total families that logs every interval, or nil to
+// log nothing if interval is 0.
+func newProgress(total int, interval time.Duration) *progress {
+	if interval <= 0 {
+		return nil
+	}
+	now := time.Now()
+	return &progress{total: total, interval: interval, start: now, last: now}
+}
+
+// update logs the progress of a run that has called done families, the last with a
+// call at lastVar, if interval has passed since it last logged.
+func (p *progress) update(done int, lastVar vcf.Vcf) {
+	if p == nil {
+		return
+	}
+	now := time.Now()
+	if now.Sub(p.last) < p.interval {
+		return
+	}
+	p.last = now
+	elapsed := now.Sub(p.start).Seconds()
+	rate := float64(done) / elapsed
+	var percent float64
+	if p.total > 0 {
+		percent = 100 * float64(done) / float64(p.total)
+	}
+	eta := "unknown"
+	if rate > 0 && done <= p.total {
+		eta = time.Duration(float64(p.total-done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+	log.Printf("Progress: %d of %d read families (%.1f%%), %.1f families/sec, ETA %s, last call at %s:%d", done, p.total, percent, rate, eta, lastVar.Chr, lastVar.Pos)
+}
+
+// countFamilies returns the read families in bedFile.
+func countFamilies(bedFile string) int {
+	var n int
+	in := fileio.EasyOpen(bedFile)
+	for line, done := fileio.EasyNextRealLine(in); !done; line, done = fileio.EasyNextRealLine(in) {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			n++
+		}
+	}
+	exception.PanicOnErr(in.Close())
+	return n
+}
@@ -0,0 +1,78 @@
+package mcsCallVariants
+
+import (
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/vcf"
+	"log"
+	"os"
+	"sort"
+)
+
+// readRegions returns the regions of each -R value, which is either chr:start-end, chr,
+// or a BED, interval_list, or GFF3 file.
+func readRegions(values []string) []bed.Bed {
+	var ans []bed.Bed
+	for _, value := range values {
+		if _, err := os.Stat(value); err == nil {
+			ans = append(ans, intervals.Read(value)...)
+			continue
+		}
+		b, err := intervals.ParseRegion(value)
+		if err != nil {
+			log.Fatalf("ERROR: -R %s.", err)
+		}
+		ans = append(ans, b)
+	}
+	return ans
+}
+
+// regionFilter keeps the families, calls, and called sites in a set of regions. A nil
+// regionFilter keeps everything.
+type regionFilter map[string]*interval.IntervalNode
+
+func newRegionFilter(regions []bed.Bed) regionFilter {
+	if len(regions) == 0 {
+		return nil
+	}
+	merged := make([]interval.Interval, 0, len(regions))
+	for _, b := range bed.MergeBeds(append([]bed.Bed(nil), regions...)) { // sorts in place
+		merged = append(merged, b)
+	}
+	return interval.BuildTree(merged)
+}
+
+// overlaps reports whether b overlaps a region.
+func (r regionFilter) overlaps(b bed.Bed) bool {
+	return r == nil || len(interval.Query(r, b, "any")) > 0
+}
+
+// keep reports whether the POS of v is in a region, so that a call is written by one
+// run when the genome is split into regions.
+func (r regionFilter) keep(v vcf.Vcf) bool {
+	return r.overlaps(bed.Bed{Chrom: v.Chr, ChromStart: v.Pos - 1, ChromEnd: v.Pos, FieldsInitialized: 3})
+}
+
+// clip returns the parts of b in the regions.
+func (r regionFilter) clip(b bed.Bed) []bed.Bed {
+	if r == nil {
+		return []bed.Bed{b}
+	}
+	var ans []bed.Bed
+	for _, o := range interval.Query(r, b, "any") {
+		region := o.(bed.Bed)
+		part := b
+		if region.ChromStart > part.ChromStart {
+			part.ChromStart = region.ChromStart
+		}
+		if region.ChromEnd < part.ChromEnd {
+			part.ChromEnd = region.ChromEnd
+		}
+		ans = append(ans, part)
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].ChromStart < ans[j].ChromStart
+	})
+	return ans
+}
@@ -0,0 +1,88 @@
+package mcsCallVariants
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/mcscall"
+	"github.com/dasnellings/duplexTools/salvage"
+	"github.com/dasnellings/duplexTools/tmp"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// streamFamilies reads the families of bedFile from s, contig by contig in the order of
+// the bam header, and sends each family once s holds its reads. The family bed is
+// sorted by contig name, so its families are first split into a file per contig.
+// Reading stops when ctx is cancelled or salvage.Stop is called.
+func streamFamilies(ctx context.Context, s *mcscall.Stream, bedFile, input string) <-chan bed.Bed {
+	contigs := splitByContig(bedFile, s.Header(), input)
+	families := make(chan bed.Bed, 1000)
+	go func() {
+		defer close(families)
+		for _, file := range contigs {
+			if file == "" {
+				continue
+			}
+			for b := range bed.GoReadToChan(file) {
+				if ctx.Err() != nil || salvage.Stopped() {
+					return
+				}
+				err := s.Read(b)
+				if errors.Is(err, mcscall.ErrUnsorted) {
+					exit.Fatalf(exit.Unsorted, "%s in %s.", err, input)
+				}
+				exception.PanicOnErr(err) // contigs are checked by splitByContig
+				families <- b
+			}
+		}
+	}()
+	return families
+}
+
+// splitByContig writes the families of bedFile on each contig of header to their own
+// file in tmp.Dir and returns the files in the order of the header, with "" for contigs
+// without families.
+func splitByContig(bedFile string, header sam.Header, input string) []string {
+	contigs := make(map[string]int, len(header.Chroms))
+	for i, c := range header.Chroms {
+		contigs[c.Name] = i
+	}
+	files := make([]string, len(header.Chroms))
+	var file *os.File
+	var out *bufio.Writer
+	closeContig := func() {
+		if file != nil {
+			exception.PanicOnErr(out.Flush())
+			cleanup(file)
+		}
+	}
+	current := -1
+	base := strings.TrimSuffix(filepath.Base(bedFile), ".bed")
+	for b := range bed.GoReadToChan(bedFile) {
+		i, found := contigs[b.Chrom]
+		if !found {
+			exit.Fatalf(exit.ContigMismatch, "%s in %s is not in the header of %s.", b.Chrom, bedFile, input)
+		}
+		if i != current {
+			closeContig()
+			if files[i] == "" {
+				files[i] = tmp.Path(fmt.Sprintf("%s.contig%d.bed", base, i))
+			}
+			var err error
+			file, err = os.OpenFile(files[i], os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			exception.PanicOnErr(err)
+			out = bufio.NewWriter(file)
+			current = i
+		}
+		bed.WriteBed(out, b)
+	}
+	closeContig()
+	return files
+}
@@ -0,0 +1,110 @@
+package mcsCallVariants
+
+import (
+	"encoding/json"
+	"github.com/dasnellings/duplexTools/deterministic"
+	"github.com/dasnellings/duplexTools/mcscall"
+	"github.com/dasnellings/duplexTools/schema"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/vcf"
+	"time"
+)
+
+// summary is the JSON document written with -summaryOut, described by the
+// mcsCallVariants schema.
+type summary struct {
+	schema.Header
+	Sample            string           `json:"sample"`
+	Status            string           `json:"status"` // completed, interrupted, or salvaged
+	FamiliesSkipped   familySkips      `json:"familiesSkipped"`
+	FamiliesProcessed int64            `json:"familiesProcessed"`
+	ReadsProcessed    int64            `json:"readsProcessed"`
+	MeanFamilyDepth   float64          `json:"meanFamilyDepth"`
+	Rejections        map[string]int64 `json:"rejections"`
+	Variants          variantCounts    `json:"variants"`
+	RuntimeSeconds    float64          `json:"runtimeSeconds,omitempty"` // left out with -deterministic
+	Threads           []worker         `json:"threads,omitempty"`        // left out with -deterministic
+}
+
+// familySkips counts the families of the family bed removed before calling.
+type familySkips struct {
+	ContigSize  int `json:"contigSize"`  // on a contig shorter than -minContigSize
+	Length      int `json:"length"`      // shorter than -minReadFamilyLength
+	Overlapping int `json:"overlapping"` // overlapped by more than -maxOverlappingFamilies families
+	Depth       int `json:"depth"`       // fewer reads than -a or -s
+	Excluded    int `json:"excluded"`    // overlapping -e
+	Region      int `json:"region"`      // outside the regions of -R
+	OtherShard  int `json:"otherShard"`  // assigned to another -shard
+}
+
+// variantCounts counts the records written to the VCF. Filtered counts calls with a
+// FILTER (e.g. from -emitFiltered or -tagNormal), which are not counted by type.
+type variantCounts struct {
+	Snv       int `json:"snv"`
+	Insertion int `json:"insertion"`
+	Deletion  int `json:"deletion"`
+	Mnv       int `json:"mnv"`
+	Breakend  int `json:"breakend"`
+	Filtered  int `json:"filtered"`
+}
+
+func (c *variantCounts) add(v vcf.Vcf) {
+	switch {
+	case mcscall.IsRefBlock(v):
+	case v.Filter != "." && v.Filter != "PASS":
+		c.Filtered++
+	case mcscall.IsBreakend(v):
+		c.Breakend++
+	case len(v.Alt[0]) > len(v.Ref):
+		c.Insertion++
+	case len(v.Alt[0]) < len(v.Ref):
+		c.Deletion++
+	case len(v.Ref) > 1:
+		c.Mnv++
+	default:
+		c.Snv++
+	}
+}
+
+// worker records the families called by a thread and the time spent calling them.
+type worker struct {
+	Families    int     `json:"families"`
+	BusySeconds float64 `json:"busySeconds"`
+	busy        time.Duration
+}
+
+// add records a family whose calling began at start.
+func (w *worker) add(start time.Time) {
+	w.Families++
+	w.busy += time.Since(start)
+}
+
+// finish fills in the counts of stats and the timing of the run.
+func (s *summary) finish(sample string, stats *mcscall.Stats, workers []worker, runtime time.Duration) {
+	s.Header = schema.NewHeader("mcsCallVariants")
+	s.Sample = sample
+	s.FamiliesProcessed = stats.Families.Value()
+	s.ReadsProcessed = stats.Reads.Value()
+	if s.FamiliesProcessed > 0 {
+		s.MeanFamilyDepth = float64(s.ReadsProcessed) / float64(s.FamiliesProcessed)
+	}
+	s.Rejections = stats.Rejections()
+	if deterministic.Enabled() { // families are dealt to threads as they become free
+		return
+	}
+	s.RuntimeSeconds = runtime.Round(time.Millisecond).Seconds()
+	s.Threads = workers
+	for i := range s.Threads {
+		s.Threads[i].BusySeconds = s.Threads[i].busy.Round(time.Millisecond).Seconds()
+	}
+}
+
+func (s *summary) write(filename string) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(s)
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,321 @@
+package mcsCompare
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsCompare - Benchmark duplex variant calls against a truth set.\n" +
+			"Calls are matched to the truth set by chromosome, position, ref, and alt allele. Sensitivity, precision,\n" +
+			"and F1 are reported overall and stratified by variant type and substitution context.\n" +
+			"Usage:\n" +
+			"mcsCompare [options] -i calls.vcf -t truth.vcf > perCall.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsCompare with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input VCF file with variant calls.")
+	truth := flag.String("t", "", "VCF file with truth set variants.")
+	confident := flag.String("c", "", "Bed, interval_list, or GFF3 file of confident regions. If set, only calls and truth variants within these regions are compared.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai). If set, SNVs are stratified by trinucleotide context and CpG status.")
+	passOnly := flag.Bool("passOnly", false, "Only consider calls with FILTER of PASS or '.'.")
+	output := flag.String("o", "stdout", "Output file with one line per call or missed truth variant, annotated as TP, FP, or FN.")
+	summary := flag.String("summary", "stderr", "Output file for sensitivity, precision, and F1 summary.")
+	dryrun.Parse()
+
+	if *input == "" || *truth == "" {
+		usage()
+		log.Fatalln("ERROR: must have inputs for -i and -t")
+	}
+
+	mcsCompare(*input, *truth, *confident, *ref, *output, *summary, *passOnly)
+}
+
+// compareRecord is a single biallelic variant from either the calls or the truth set.
+type compareRecord struct {
+	chr     string
+	pos     int
+	ref     string
+	alt     string
+	varType string
+	context string
+	status  string
+	matched bool
+}
+
+// GetChrom, GetChromStart, and GetChromEnd satisfy interval.Interval for confident region queries.
+func (r *compareRecord) GetChrom() string {
+	return r.chr
+}
+
+func (r *compareRecord) GetChromStart() int {
+	return r.pos - 1
+}
+
+func (r *compareRecord) GetChromEnd() int {
+	return r.pos - 1 + len(r.ref)
+}
+
+// counts stores the TP/FP/FN tally for a single stratum.
+type counts struct {
+	tp, fp, fn int
+}
+
+func mcsCompare(input, truthFile, confidentFile, refFile, output, summaryFile string, passOnly bool) {
+	var confidentTree map[string]*interval.IntervalNode
+	if confidentFile != "" {
+		confidentTree = interval.BuildTree(interval.BedSliceToIntervals(intervals.ReadMerged(confidentFile)))
+	}
+
+	var ref *fai.Seeker
+	if refFile != "" {
+		ref = fai.NewSeeker(refFile)
+		defer cleanup(ref)
+	}
+
+	truthMap := make(map[string]*compareRecord)
+	var truthOrder []*compareRecord
+	truthChan, _ := vcf.GoReadToChan(truthFile)
+	for v := range truthChan {
+		for _, r := range splitAlleles(v, confidentTree, ref) {
+			if _, dup := truthMap[recordKey(r)]; dup {
+				continue
+			}
+			truthMap[recordKey(r)] = r
+			truthOrder = append(truthOrder, r)
+		}
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Chr\tPos\tRef\tAlt\tType\tContext\tStatus")
+	exception.PanicOnErr(err)
+
+	strata := make(map[string]*counts)
+	var truthRecord *compareRecord
+	var found bool
+	callChan, _ := vcf.GoReadToChan(input)
+	for v := range callChan {
+		if passOnly && v.Filter != "PASS" && v.Filter != "." {
+			continue
+		}
+		for _, r := range splitAlleles(v, confidentTree, ref) {
+			truthRecord, found = truthMap[recordKey(r)]
+			switch {
+			case found && !truthRecord.matched:
+				truthRecord.matched = true
+				r.status = "TP"
+			case found: // repeated call of a truth variant already matched
+				continue
+			default:
+				r.status = "FP"
+			}
+			tally(strata, r)
+			writeRecord(out, r)
+		}
+	}
+
+	for _, r := range truthOrder {
+		if r.matched {
+			continue
+		}
+		r.status = "FN"
+		tally(strata, r)
+		writeRecord(out, r)
+	}
+
+	summaryOut := fileio.EasyCreate(summaryFile)
+	defer cleanup(summaryOut)
+	writeSummary(summaryOut, strata)
+}
+
+// splitAlleles converts a vcf record into one compareRecord per alt allele, excluding
+// symbolic alleles and records outside of the confident regions.
+func splitAlleles(v vcf.Vcf, confidentTree map[string]*interval.IntervalNode, ref *fai.Seeker) []*compareRecord {
+	var ans []*compareRecord
+	var r *compareRecord
+	for _, alt := range v.Alt {
+		if alt == "." || alt == "*" || strings.HasPrefix(alt, "<") {
+			continue
+		}
+		r = &compareRecord{chr: v.Chr, pos: v.Pos, ref: strings.ToUpper(v.Ref), alt: strings.ToUpper(alt)}
+		trimAlleles(r)
+		if confidentTree != nil && len(interval.Query(confidentTree, r, "any")) == 0 {
+			continue
+		}
+		r.varType = variantType(r)
+		r.context = variantContext(r, ref)
+		ans = append(ans, r)
+	}
+	return ans
+}
+
+// trimAlleles removes shared trailing bases, then shared leading bases while retaining a single
+// anchor base, so that the same variant represented differently in the calls and truth set will match.
+func trimAlleles(r *compareRecord) {
+	for len(r.ref) > 1 && len(r.alt) > 1 && r.ref[len(r.ref)-1] == r.alt[len(r.alt)-1] {
+		r.ref = r.ref[:len(r.ref)-1]
+		r.alt = r.alt[:len(r.alt)-1]
+	}
+	for len(r.ref) > 1 && len(r.alt) > 1 && r.ref[0] == r.alt[0] && r.ref[1] == r.alt[1] {
+		r.ref = r.ref[1:]
+		r.alt = r.alt[1:]
+		r.pos++
+	}
+	if len(r.ref) == len(r.alt) {
+		for len(r.ref) > 1 && r.ref[0] == r.alt[0] {
+			r.ref = r.ref[1:]
+			r.alt = r.alt[1:]
+			r.pos++
+		}
+	}
+}
+
+func recordKey(r *compareRecord) string {
+	return fmt.Sprintf("%s:%d:%s:%s", r.chr, r.pos, r.ref, r.alt)
+}
+
+func variantType(r *compareRecord) string {
+	switch {
+	case len(r.ref) == 1 && len(r.alt) == 1:
+		return "SNV"
+	case len(r.ref) == len(r.alt):
+		return "MNV"
+	case len(r.ref) == 1 && r.alt[0] == r.ref[0]:
+		return "INS"
+	case len(r.alt) == 1 && r.alt[0] == r.ref[0]:
+		return "DEL"
+	default:
+		return "COMPLEX"
+	}
+}
+
+// variantContext returns the pyrimidine-centered substitution class for SNVs (e.g. C>T). If a reference
+// is available the trinucleotide context is reported as A[C>T]G. Non-SNVs have no context.
+func variantContext(r *compareRecord, ref *fai.Seeker) string {
+	if r.varType != "SNV" {
+		return "."
+	}
+	refBase := dna.StringToBase(r.ref)
+	altBase := dna.StringToBase(r.alt)
+	needsRevComp := refBase == dna.A || refBase == dna.G
+	if needsRevComp {
+		refBase = dna.ComplementSingleBase(refBase)
+		altBase = dna.ComplementSingleBase(altBase)
+	}
+	class := dna.BaseToString(refBase) + ">" + dna.BaseToString(altBase)
+	if ref == nil || r.pos == 1 {
+		return class
+	}
+
+	seq, err := ref.SeekByName(r.chr, r.pos-2, r.pos+1)
+	if err != nil || len(seq) != 3 {
+		return class
+	}
+	dna.AllToUpper(seq)
+	if needsRevComp {
+		dna.ReverseComplement(seq)
+	}
+	if seq[1] != refBase {
+		log.Printf("WARNING: reference base does not match vcf at %s:%d\n", r.chr, r.pos)
+		return class
+	}
+	return fmt.Sprintf("%s[%s]%s", dna.BaseToString(seq[0]), class, dna.BaseToString(seq[2]))
+}
+
+// strataKeys returns the strata a record is tallied in.
+func strataKeys(r *compareRecord) []string {
+	ans := []string{"ALL", "Type:" + r.varType}
+	if r.varType != "SNV" {
+		return ans
+	}
+	class := r.context
+	if strings.Contains(class, "[") {
+		class = class[2:5]
+	}
+	ans = append(ans, "Substitution:"+class)
+	if class == "C>T" && strings.HasSuffix(r.context, "]G") {
+		ans = append(ans, "Substitution:C>T_CpG")
+	}
+	return ans
+}
+
+func tally(strata map[string]*counts, r *compareRecord) {
+	var c *counts
+	var found bool
+	for _, key := range strataKeys(r) {
+		if c, found = strata[key]; !found {
+			c = new(counts)
+			strata[key] = c
+		}
+		switch r.status {
+		case "TP":
+			c.tp++
+		case "FP":
+			c.fp++
+		case "FN":
+			c.fn++
+		}
+	}
+}
+
+func writeRecord(out io.Writer, r *compareRecord) {
+	_, err := fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", r.chr, r.pos, r.ref, r.alt, r.varType, r.context, r.status)
+	exception.PanicOnErr(err)
+}
+
+func writeSummary(out io.Writer, strata map[string]*counts) {
+	keys := make([]string, 0, len(strata))
+	for key := range strata {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i] == "ALL" || keys[j] == "ALL" {
+			return keys[i] == "ALL"
+		}
+		return keys[i] < keys[j]
+	})
+
+	_, err := fmt.Fprintln(out, "Stratum\tTP\tFP\tFN\tSensitivity\tPrecision\tF1")
+	exception.PanicOnErr(err)
+	var sens, prec, f1 float64
+	for _, key := range keys {
+		c := strata[key]
+		sens = safeDivide(c.tp, c.tp+c.fn)
+		prec = safeDivide(c.tp, c.tp+c.fp)
+		if sens+prec > 0 {
+			f1 = 2 * sens * prec / (sens + prec)
+		} else {
+			f1 = 0
+		}
+		_, err = fmt.Fprintf(out, "%s\t%d\t%d\t%d\t%.4f\t%.4f\t%.4f\n", key, c.tp, c.fp, c.fn, sens, prec, f1)
+		exception.PanicOnErr(err)
+	}
+}
+
+func safeDivide(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return float64(a) / float64(b)
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,75 @@
+package mcsCompare
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestTrimAlleles(t *testing.T) {
+	tests := []struct {
+		pos         int
+		ref, alt    string
+		expectedPos int
+		expectedRef string
+		expectedAlt string
+		expectedTyp string
+	}{
+		{10, "A", "T", 10, "A", "T", "SNV"},
+		{10, "ACG", "ATG", 11, "C", "T", "SNV"},   // padded SNV
+		{10, "ACGT", "AT", 10, "ACG", "A", "DEL"}, // shared trailing base
+		{10, "GAC", "GACAC", 10, "G", "GAC", "INS"},
+		{10, "TAC", "TGT", 11, "AC", "GT", "MNV"},
+		{10, "AC", "GTT", 10, "AC", "GTT", "COMPLEX"},
+	}
+	for _, test := range tests {
+		r := &compareRecord{chr: "chr1", pos: test.pos, ref: test.ref, alt: test.alt}
+		trimAlleles(r)
+		if r.pos != test.expectedPos || r.ref != test.expectedRef || r.alt != test.expectedAlt {
+			t.Errorf("expected %s>%s to trim to %d %s>%s, got %d %s>%s", test.ref, test.alt, test.expectedPos, test.expectedRef, test.expectedAlt, r.pos, r.ref, r.alt)
+		}
+		if actual := variantType(r); actual != test.expectedTyp {
+			t.Errorf("expected %s>%s to be %s, got %s", test.ref, test.alt, test.expectedTyp, actual)
+		}
+	}
+}
+
+func TestStrataKeys(t *testing.T) {
+	tests := []struct {
+		r        compareRecord
+		expected string
+	}{
+		{compareRecord{varType: "SNV", context: "A[C>T]G"}, "ALL Type:SNV Substitution:C>T Substitution:C>T_CpG"},
+		{compareRecord{varType: "SNV", context: "A[C>T]A"}, "ALL Type:SNV Substitution:C>T"},
+		{compareRecord{varType: "SNV", context: "T>A"}, "ALL Type:SNV Substitution:T>A"},
+		{compareRecord{varType: "DEL", context: "."}, "ALL Type:DEL"},
+	}
+	for _, test := range tests {
+		if actual := strings.Join(strataKeys(&test.r), " "); actual != test.expected {
+			t.Errorf("expected strata %s for %s, got %s", test.expected, test.r.context, actual)
+		}
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	strata := make(map[string]*counts)
+	for _, r := range []compareRecord{
+		{varType: "SNV", context: "C>A", status: "TP"},
+		{varType: "SNV", context: "C>A", status: "TP"},
+		{varType: "SNV", context: "C>A", status: "TP"},
+		{varType: "SNV", context: "C>A", status: "FP"},
+		{varType: "DEL", context: ".", status: "FN"},
+	} {
+		tally(strata, &r)
+	}
+	var out bytes.Buffer
+	writeSummary(&out, strata)
+	expected := "Stratum\tTP\tFP\tFN\tSensitivity\tPrecision\tF1\n" +
+		"ALL\t3\t1\t1\t0.7500\t0.7500\t0.7500\n" +
+		"Substitution:C>A\t3\t1\t0\t1.0000\t0.7500\t0.8571\n" +
+		"Type:DEL\t0\t0\t1\t0.0000\t0.0000\t0.0000\n" +
+		"Type:SNV\t3\t1\t0\t1.0000\t0.7500\t0.8571\n"
+	if out.String() != expected {
+		t.Errorf("expected summary:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
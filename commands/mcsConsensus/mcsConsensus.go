@@ -0,0 +1,533 @@
+package mcsConsensus
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/pool"
+	"github.com/dasnellings/duplexTools/preflight"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fastq"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsConsensus - Generate one duplex consensus read per read family from a bam file annotated with annotateReadFamilies.\n" +
+			"Single-strand consensus bases are called separately for watson and crick reads using a likelihood model of the\n" +
+			"input base qualities, then combined into a duplex consensus (similar to fgbio CallDuplexConsensusReads).\n" +
+			"Consensus reads are written in reference coordinates with the family ID as the read name and tags\n" +
+			"RF (family ID), aD (watson reads), bD (crick reads), and cD (total reads).\n" +
+			"Output is BAM unless -o ends in .fq, .fastq, .fq.gz, or .fastq.gz. BAM output is not guaranteed to be sorted.\n" +
+			"Usage:\n" +
+			"mcsConsensus [options] -i annotated.bam > consensus.bam\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsConsensus with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input coordinate sorted bam file annotated with annotateReadFamilies.")
+	output := flag.String("o", "stdout", "Output bam or fastq file.")
+	minReads := flag.Int("minReads", 1, "Minimum number of reads on each of the watson and crick strands to generate a duplex consensus.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality for a read to be included in the consensus.")
+	minInputBaseQuality := flag.Int("minInputBaseQuality", 10, "Input bases below this quality are ignored.")
+	errorRatePreUmi := flag.Int("errorRatePreUmi", 45, "Phred-scaled error rate for errors occurring prior to UMI attachment (e.g. DNA damage).")
+	errorRatePostUmi := flag.Int("errorRatePostUmi", 40, "Phred-scaled error rate for errors occurring after UMI attachment (e.g. PCR errors).")
+	minConsensusQuality := flag.Int("minConsensusQuality", 0, "Duplex consensus bases below this quality are masked to N.")
+	maxQuality := flag.Int("maxQuality", 90, "Maximum consensus base quality.")
+	maxFamilySpan := flag.Int("maxFamilySpan", 10000, "Families spanning more than this many bases of the reference are skipped.")
+	dryrun.Parse()
+
+	if *input == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i).")
+	}
+
+	p := consensusParams{
+		minReads:            *minReads,
+		minMapQ:             uint8(*minMapQ),
+		minInputBaseQuality: uint8(*minInputBaseQuality),
+		errorPreUmi:         phredToProb(float64(*errorRatePreUmi)),
+		errorPostUmi:        phredToProb(float64(*errorRatePostUmi)),
+		minConsensusQuality: float64(*minConsensusQuality),
+		maxQuality:          float64(*maxQuality),
+		maxFamilySpan:       *maxFamilySpan,
+	}
+
+	mcsConsensus(*input, *output, p)
+}
+
+// consensusParams stores the settings shared by all consensus calculations.
+type consensusParams struct {
+	minReads            int
+	minMapQ             uint8
+	minInputBaseQuality uint8
+	errorPreUmi         float64
+	errorPostUmi        float64
+	minConsensusQuality float64
+	maxQuality          float64
+	maxFamilySpan       int
+}
+
+// readFamily stores the reads from a single family split by strand.
+type readFamily struct {
+	id     string
+	chr    string
+	start  int // 0-based, inclusive
+	end    int // 0-based, exclusive
+	watson []sam.Sam
+	crick  []sam.Sam
+}
+
+// strandConsensus stores the single-strand consensus for each reference position spanned by a family.
+// Index i of each slice corresponds to reference position family.start + i.
+type strandConsensus struct {
+	covered []bool
+	deleted []bool
+	base    []dna.Base
+	qual    []float64
+	ins     []string // sequence inserted after the reference position
+	insQual []float64
+}
+
+func mcsConsensus(input, output string, p consensusParams) {
+	required := preflight.Bam{Sorted: true, Tags: []string{"RF", "RS"}, Qualities: true}
+	required.Check(input)
+	reads, header := hts.GoReadToChan(input)
+	required.CheckHeader(input, header)
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+
+	var write func(sam.Sam)
+	if isFastq(output) {
+		write = func(s sam.Sam) {
+			fastq.WriteToFileHandle(out, samToFastq(s))
+		}
+	} else {
+		bw := bam.NewWriter(out, provenance.Sam(header))
+		defer cleanup(bw)
+		write = func(s sam.Sam) {
+			bw.Write(s)
+		}
+	}
+
+	m := make(map[string]*readFamily)
+	var toWrite []*readFamily
+	var f *readFamily
+	var rf, prevChrom string
+	var rs byte
+	var readCount, familyCount int
+	for r := range reads {
+		if sam.IsUnmapped(r) || sam.IsNotPrimaryAlign(r) || sam.IsSupplementaryAlign(r) || r.MapQ < p.minMapQ {
+			continue
+		}
+		sam.ParseExtra(&r)
+		rf = barcode.GetRF(&r)
+		rs = barcode.GetRS(&r)
+		if rf == "" || (rs != 'W' && rs != 'C') {
+			continue
+		}
+		readCount++
+
+		if r.RName != prevChrom {
+			for k := range m {
+				toWrite = append(toWrite, m[k])
+				delete(m, k)
+			}
+			familyCount += writeFamilies(toWrite, write, p)
+			toWrite = toWrite[:0]
+			prevChrom = r.RName
+		}
+
+		f = m[rf]
+		if f == nil {
+			f = &readFamily{id: rf, chr: r.RName, start: r.GetChromStart(), end: r.GetChromEnd()}
+			m[rf] = f
+		}
+		f.start = min(f.start, r.GetChromStart())
+		f.end = max(f.end, r.GetChromEnd())
+		if rs == 'W' {
+			f.watson = append(f.watson, r)
+		} else {
+			f.crick = append(f.crick, r)
+		}
+
+		if readCount%10000 == 0 { // write families at least 10kb behind the current read
+			for k := range m {
+				if m[k].end < r.GetChromStart()-10000 {
+					toWrite = append(toWrite, m[k])
+					delete(m, k)
+				}
+			}
+			familyCount += writeFamilies(toWrite, write, p)
+			toWrite = toWrite[:0]
+		}
+	}
+
+	for k := range m {
+		toWrite = append(toWrite, m[k])
+	}
+	familyCount += writeFamilies(toWrite, write, p)
+	log.Printf("Wrote %d duplex consensus reads.\n", familyCount)
+}
+
+// writeFamilies calls and writes the consensus for each family in coordinate order. Returns the number of consensus reads written.
+func writeFamilies(families []*readFamily, write func(sam.Sam), p consensusParams) int {
+	sort.Slice(families, func(i, j int) bool {
+		if families[i].start != families[j].start {
+			return families[i].start < families[j].start
+		}
+		return families[i].id < families[j].id
+	})
+	var written int
+	var s sam.Sam
+	var ok bool
+	for _, f := range families {
+		s, ok = duplexConsensus(f, p)
+		if ok {
+			write(s)
+			written++
+		}
+	}
+	return written
+}
+
+// duplexConsensus combines the watson and crick single-strand consensus of a family into a single read.
+// Returns false if the family does not have enough reads on both strands or the consensus is empty.
+func duplexConsensus(f *readFamily, p consensusParams) (sam.Sam, bool) {
+	if len(f.watson) < p.minReads || len(f.crick) < p.minReads {
+		return sam.Sam{}, false
+	}
+	if f.end-f.start > p.maxFamilySpan {
+		log.Printf("WARNING: family %s spans %d bases (%s:%d-%d). Skipping.\n", f.id, f.end-f.start, f.chr, f.start, f.end)
+		return sam.Sam{}, false
+	}
+	w := singleStrandConsensus(f.watson, f.start, f.end, p)
+	c := singleStrandConsensus(f.crick, f.start, f.end, p)
+
+	var seq []dna.Base
+	var cig []cigar.Cigar
+	quals := pool.Bytes.Get(len(w.covered))
+	defer func() { pool.Bytes.Put(quals) }() // Qual is copied into a string below
+	var firstPos = -1
+	var pendingDeletion int
+	var base dna.Base
+	var qual float64
+	for i := range w.covered {
+		if !w.covered[i] || !c.covered[i] {
+			if firstPos == -1 { // trim bases not covered by both strands from the start of the read
+				continue
+			}
+			base, qual = dna.N, 0
+		} else if w.deleted[i] && c.deleted[i] {
+			if firstPos != -1 {
+				pendingDeletion++
+			}
+			continue
+		} else if w.deleted[i] || c.deleted[i] {
+			if firstPos == -1 {
+				continue
+			}
+			base, qual = dna.N, 0
+		} else {
+			base, qual = combineStrands(w.base[i], w.qual[i], c.base[i], c.qual[i], p)
+		}
+
+		if firstPos == -1 {
+			firstPos = f.start + i
+		}
+		if pendingDeletion > 0 {
+			cig = appendCigar(cig, 'D', pendingDeletion)
+			pendingDeletion = 0
+		}
+		seq = append(seq, base)
+		quals = append(quals, toQual(qual))
+		cig = appendCigar(cig, 'M', 1)
+
+		if w.ins[i] != "" && w.ins[i] == c.ins[i] {
+			qual = math.Min(w.insQual[i]+c.insQual[i], p.maxQuality)
+			for _, b := range dna.StringToBases(w.ins[i]) {
+				seq = append(seq, b)
+				quals = append(quals, toQual(qual))
+			}
+			cig = appendCigar(cig, 'I', len(w.ins[i]))
+		}
+	}
+
+	trimTrailingN(&seq, &quals, &cig)
+	if len(seq) == 0 {
+		return sam.Sam{}, false
+	}
+
+	var mapQ uint8 = 255
+	for _, r := range append(f.watson, f.crick...) {
+		if r.MapQ < mapQ {
+			mapQ = r.MapQ
+		}
+	}
+
+	s := sam.Sam{
+		QName: f.id,
+		MapQ:  mapQ,
+		RName: f.chr,
+		Pos:   uint32(firstPos + 1),
+		Cigar: cig,
+		RNext: "*",
+		Seq:   seq,
+		Qual:  string(addAsciiOffset(quals)),
+		Extra: fmt.Sprintf("RF:Z:%s\taD:i:%d\tbD:i:%d\tcD:i:%d", f.id, len(f.watson), len(f.crick), len(f.watson)+len(f.crick)),
+	}
+	return s, true
+}
+
+// combineStrands returns the duplex consensus base and quality from two single-strand consensus bases.
+// Agreeing bases have their qualities summed. For disagreeing bases the higher quality base is reported
+// with a quality equal to the difference of the two.
+func combineStrands(wBase dna.Base, wQual float64, cBase dna.Base, cQual float64, p consensusParams) (dna.Base, float64) {
+	var base dna.Base
+	var qual float64
+	switch {
+	case wBase == dna.N || cBase == dna.N:
+		return dna.N, 0
+	case wBase == cBase:
+		base, qual = wBase, wQual+cQual
+	case wQual > cQual:
+		base, qual = wBase, wQual-cQual
+	case cQual > wQual:
+		base, qual = cBase, cQual-wQual
+	default:
+		return dna.N, 0
+	}
+	qual = math.Min(qual, p.maxQuality)
+	if qual < p.minConsensusQuality {
+		return dna.N, 0
+	}
+	return base, qual
+}
+
+// singleStrandConsensus computes the consensus base, deletion, and insertion state at each
+// reference position in [start, end) for a set of reads from one strand of a family.
+func singleStrandConsensus(reads []sam.Sam, start, end int, p consensusParams) strandConsensus {
+	size := end - start
+	logLik := make([][4]float64, size)
+	baseCount := make([]int, size)
+	delCount := make([]int, size)
+	insSeqs := make([]map[string][]float64, size) // inserted sequence -> qualities of supporting reads
+
+	var refPos, queryPos, k int
+	var q uint8
+	var b dna.Base
+	var e float64
+	for _, r := range reads {
+		refPos = r.GetChromStart() - start
+		queryPos = 0
+		for _, c := range r.Cigar {
+			switch c.Op {
+			case 'M', '=', 'X':
+				for k = 0; k < c.RunLength; k++ {
+					q = r.Qual[queryPos+k] - 33
+					b = dna.ToUpper(r.Seq[queryPos+k])
+					if q < p.minInputBaseQuality || b > dna.T {
+						continue
+					}
+					e = probOfErrorTwoTrials(p.errorPostUmi, phredToProb(float64(q)))
+					addLikelihood(&logLik[refPos+k], b, e)
+					baseCount[refPos+k]++
+				}
+				refPos += c.RunLength
+				queryPos += c.RunLength
+			case 'D', 'N':
+				for k = 0; k < c.RunLength; k++ {
+					delCount[refPos+k]++
+				}
+				refPos += c.RunLength
+			case 'I':
+				if refPos > 0 {
+					if insSeqs[refPos-1] == nil {
+						insSeqs[refPos-1] = make(map[string][]float64)
+					}
+					seq := dna.BasesToString(r.Seq[queryPos : queryPos+c.RunLength])
+					insSeqs[refPos-1][seq] = append(insSeqs[refPos-1][seq], meanQual(r.Qual[queryPos:queryPos+c.RunLength]))
+				}
+				queryPos += c.RunLength
+			case 'S':
+				queryPos += c.RunLength
+			}
+		}
+	}
+
+	ans := strandConsensus{
+		covered: make([]bool, size),
+		deleted: make([]bool, size),
+		base:    make([]dna.Base, size),
+		qual:    make([]float64, size),
+		ins:     make([]string, size),
+		insQual: make([]float64, size),
+	}
+
+	var depth int
+	for i := 0; i < size; i++ {
+		depth = baseCount[i] + delCount[i]
+		if depth == 0 {
+			continue
+		}
+		ans.covered[i] = true
+		if delCount[i]*2 > depth {
+			ans.deleted[i] = true
+			continue
+		}
+		ans.base[i], ans.qual[i] = consensusBase(logLik[i], p)
+		for seq, quals := range insSeqs[i] {
+			if len(quals)*2 > depth {
+				ans.ins[i] = seq
+				ans.insQual[i] = math.Min(average(quals), p.maxQuality)
+			}
+		}
+	}
+	return ans
+}
+
+// addLikelihood adds the log likelihood of observing base b with error probability e to each possible true base.
+func addLikelihood(ll *[4]float64, b dna.Base, e float64) {
+	for i := range ll {
+		if dna.Base(i) == b {
+			ll[i] += math.Log(1 - e)
+		} else {
+			ll[i] += math.Log(e / 3)
+		}
+	}
+}
+
+// consensusBase returns the maximum likelihood base and its Phred-scaled posterior error
+// including the pre-UMI error rate.
+func consensusBase(ll [4]float64, p consensusParams) (dna.Base, float64) {
+	var best int
+	for i := range ll {
+		if ll[i] > ll[best] {
+			best = i
+		}
+	}
+	if ll[best] == 0 { // no bases observed
+		return dna.N, 0
+	}
+	var sum float64
+	for i := range ll {
+		sum += math.Exp(ll[i] - ll[best])
+	}
+	errProb := 1 - 1/sum
+	errProb = probOfErrorTwoTrials(p.errorPreUmi, errProb)
+	return dna.Base(best), math.Min(probToPhred(errProb), p.maxQuality)
+}
+
+// probOfErrorTwoTrials returns the probability of observing an error after two independent
+// error processes, accounting for the chance that the second error reverts the first.
+func probOfErrorTwoTrials(p1, p2 float64) float64 {
+	return p1 + p2 - (4.0/3.0)*p1*p2
+}
+
+func phredToProb(q float64) float64 {
+	return math.Pow(10, -q/10)
+}
+
+func probToPhred(p float64) float64 {
+	if p <= 0 {
+		return math.MaxFloat64
+	}
+	return -10 * math.Log10(p)
+}
+
+func toQual(q float64) uint8 {
+	if q < 2 {
+		return 2
+	}
+	return uint8(math.Round(q))
+}
+
+func meanQual(q string) float64 {
+	var sum float64
+	for i := range q {
+		sum += float64(q[i] - 33)
+	}
+	return sum / float64(len(q))
+}
+
+func average(vals []float64) float64 {
+	var sum float64
+	for i := range vals {
+		sum += vals[i]
+	}
+	return sum / float64(len(vals))
+}
+
+// appendCigar extends the last cigar operation if it matches op, otherwise appends a new operation.
+func appendCigar(c []cigar.Cigar, op rune, length int) []cigar.Cigar {
+	if len(c) > 0 && c[len(c)-1].Op == op {
+		c[len(c)-1].RunLength += length
+		return c
+	}
+	return append(c, cigar.Cigar{RunLength: length, Op: op})
+}
+
+// trimTrailingN removes trailing N bases that are not covered by both strands.
+func trimTrailingN(seq *[]dna.Base, quals *[]uint8, c *[]cigar.Cigar) {
+	for len(*seq) > 0 && (*seq)[len(*seq)-1] == dna.N && len(*c) > 0 && (*c)[len(*c)-1].Op == 'M' {
+		*seq = (*seq)[:len(*seq)-1]
+		*quals = (*quals)[:len(*quals)-1]
+		(*c)[len(*c)-1].RunLength--
+		if (*c)[len(*c)-1].RunLength == 0 {
+			*c = (*c)[:len(*c)-1]
+		}
+	}
+}
+
+func addAsciiOffset(q []uint8) []uint8 {
+	for i := range q {
+		q[i] += 33
+	}
+	return q
+}
+
+func isFastq(filename string) bool {
+	filename = strings.TrimSuffix(filename, ".gz")
+	return strings.HasSuffix(filename, ".fq") || strings.HasSuffix(filename, ".fastq")
+}
+
+func samToFastq(s sam.Sam) fastq.Fastq {
+	return fastq.Fastq{
+		Name: fmt.Sprintf("%s %s:%d %s", s.QName, s.RName, s.Pos, strings.ReplaceAll(s.Extra, "\t", " ")),
+		Seq:  s.Seq,
+		Qual: fastq.ToQual([]byte(s.Qual)),
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,89 @@
+package mcsConsensus
+
+import (
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"math"
+	"testing"
+)
+
+func TestCombineStrands(t *testing.T) {
+	p := consensusParams{minConsensusQuality: 10, maxQuality: 90}
+	tests := []struct {
+		wBase        dna.Base
+		wQual        float64
+		cBase        dna.Base
+		cQual        float64
+		expectedBase dna.Base
+		expectedQual float64
+	}{
+		{dna.A, 30, dna.A, 40, dna.A, 70}, // agreeing strands add
+		{dna.A, 60, dna.A, 60, dna.A, 90}, // capped at maxQuality
+		{dna.A, 40, dna.C, 25, dna.A, 15}, // the difference of disagreeing strands
+		{dna.A, 30, dna.C, 25, dna.N, 0},  // below minConsensusQuality
+		{dna.A, 30, dna.C, 30, dna.N, 0},  // tie
+		{dna.N, 0, dna.G, 40, dna.N, 0},   // one strand not covered
+	}
+	for _, test := range tests {
+		base, qual := combineStrands(test.wBase, test.wQual, test.cBase, test.cQual, p)
+		if base != test.expectedBase || qual != test.expectedQual {
+			t.Errorf("expected %s Q%g from %s Q%g and %s Q%g, got %s Q%g", dna.BaseToString(test.expectedBase), test.expectedQual,
+				dna.BaseToString(test.wBase), test.wQual, dna.BaseToString(test.cBase), test.cQual, dna.BaseToString(base), qual)
+		}
+	}
+}
+
+func TestConsensusBase(t *testing.T) {
+	p := consensusParams{maxQuality: 1000}
+	e := phredToProb(20)
+	tests := []struct {
+		agree, disagree int
+	}{
+		{1, 0},
+		{3, 0},
+		{4, 1},
+	}
+	for _, test := range tests {
+		var ll [4]float64
+		for i := 0; i < test.agree; i++ {
+			addLikelihood(&ll, dna.G, e)
+		}
+		for i := 0; i < test.disagree; i++ {
+			addLikelihood(&ll, dna.T, e)
+		}
+		// relative to G, T has the likelihood r^(agree-disagree) and A and C r^agree
+		r := (e / 3) / (1 - e)
+		sum := 1 + math.Pow(r, float64(test.agree-test.disagree)) + 2*math.Pow(r, float64(test.agree))
+		expected := probToPhred(1 - 1/sum)
+		base, qual := consensusBase(ll, p)
+		if base != dna.G || math.Abs(qual-expected) > 1e-6 {
+			t.Errorf("expected G Q%.4f from %d agreeing and %d disagreeing Q20 reads, got %s Q%.4f", expected, test.agree, test.disagree, dna.BaseToString(base), qual)
+		}
+	}
+	if base, _ := consensusBase([4]float64{}, p); base != dna.N {
+		t.Errorf("expected N with no bases, got %s", dna.BaseToString(base))
+	}
+}
+
+func TestSingleStrandConsensus(t *testing.T) {
+	p := consensusParams{maxQuality: 90}
+	read := func(seq, cig string) sam.Sam {
+		return sam.Sam{Pos: 1, Seq: dna.StringToBases(seq), Qual: string(make([]byte, len(seq))), Cigar: cigar.FromString(cig)}
+	}
+	reads := []sam.Sam{read("ACGT", "4M"), read("ACGT", "4M"), read("ATGT", "4M"), read("AT", "1M2D1M")}
+	for i := range reads {
+		q := []byte(reads[i].Qual)
+		for j := range q {
+			q[j] = 30 + 33
+		}
+		reads[i].Qual = string(q)
+	}
+	c := singleStrandConsensus(reads, 0, 4, p)
+	if dna.BasesToString(c.base) != "ACGT" || c.deleted[1] || c.deleted[2] || !c.covered[3] {
+		t.Errorf("expected a majority consensus of ACGT without deletions, got %s deleted %v", dna.BasesToString(c.base), c.deleted)
+	}
+	if c.qual[0] <= c.qual[1] {
+		t.Errorf("expected the unanimous first base to have a higher quality than the second, got Q%g and Q%g", c.qual[0], c.qual[1])
+	}
+}
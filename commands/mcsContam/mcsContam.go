@@ -0,0 +1,277 @@
+package mcsContam
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsContam - Estimate cross-individual contamination from duplex-confirmed alleles at common population SNPs.\n" +
+			"At each SNP the base on each strand of every overlapping read family is determined, and families where the\n" +
+			"watson and crick strands agree are counted as duplex-confirmed alleles. Sites where the sample is homozygous\n" +
+			"are used to estimate the contamination fraction as the number of minor allele families divided by the number\n" +
+			"expected from a fully contaminated sample given the population allele frequency.\n" +
+			"Results are reported for all sites and for each chromosome separately.\n" +
+			"Usage:\n" +
+			"mcsContam [options] -i annotated.bam -p popSnps.vcf > contam.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsContam with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file annotated with annotateReadFamilies. Must be indexed (.bai).")
+	popVcf := flag.String("p", "", "VCF file of common population SNPs with allele frequency in the AF INFO field (e.g. gnomAD).")
+	output := flag.String("o", "stdout", "Output file.")
+	sitesOut := flag.String("sitesOut", "", "Output file with duplex allele counts and genotype at each SNP.")
+	minPopAf := flag.Float64("minPopAf", 0.05, "Minimum population allele frequency for a SNP to be used.")
+	maxPopAf := flag.Float64("maxPopAf", 0.95, "Maximum population allele frequency for a SNP to be used.")
+	minStrandedDepth := flag.Int("s", 1, "Minimum number of reads on each of the watson and crick strands of a family.")
+	minFamilies := flag.Int("minFamilies", 10, "Minimum number of duplex-confirmed families at a SNP to genotype the sample.")
+	homThreshold := flag.Float64("homThreshold", 0.2, "Sites with a minor allele fraction below this value are considered homozygous.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality for a read to be considered.")
+	minBaseQuality := flag.Int("minBaseQuality", 20, "Minimum base quality for a base to be considered.")
+	dryrun.Parse()
+
+	if *input == "" || *popVcf == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i) and population SNP vcf (-p).")
+	}
+
+	mcsContam(*input, *popVcf, *output, *sitesOut, *minPopAf, *maxPopAf, *minStrandedDepth, *minFamilies, *homThreshold, uint8(*minMapQ), uint8(*minBaseQuality))
+}
+
+// contamSummary accumulates evidence for contamination across a set of sites.
+type contamSummary struct {
+	sites            int
+	homSites         int
+	hetSites         int
+	families         int     // duplex-confirmed families at homozygous sites
+	minorFamilies    int     // families supporting the minor allele at homozygous sites
+	expectedFamilies float64 // minor allele families expected if the library were entirely contaminant
+}
+
+func mcsContam(input, popVcf, output, sitesOut string, minPopAf, maxPopAf float64, minStrandedDepth, minFamilies int, homThreshold float64, minMapQ, minBaseQuality uint8) {
+	br, _ := sam.OpenBam(input)
+	defer cleanup(br)
+	idx := bai.Read(input)
+
+	var sitesWriter io.WriteCloser
+	if sitesOut != "" {
+		sitesWriter = fileio.EasyCreate(sitesOut)
+		defer cleanup(sitesWriter)
+		_, err := fmt.Fprintln(sitesWriter, "#chrom\tpos\tref\talt\tpopAf\trefFamilies\taltFamilies\tgenotype")
+		exception.PanicOnErr(err)
+	}
+
+	total := new(contamSummary)
+	perChrom := make(map[string]*contamSummary)
+	var chromOrder []string
+	var reads []sam.Sam
+	var popAf, minorAf, altFrac float64
+	var refFamilies, altFamilies, minor int
+	var refBase, altBase dna.Base
+	var genotype string
+	var found bool
+	snps, _ := vcf.GoReadToChan(popVcf)
+	for v := range snps {
+		if len(v.Ref) != 1 || len(v.Alt) != 1 || len(v.Alt[0]) != 1 {
+			continue
+		}
+		popAf, found = getAf(v.Info)
+		if !found || popAf < minPopAf || popAf > maxPopAf {
+			continue
+		}
+		refBase = dna.StringToBase(strings.ToUpper(v.Ref))
+		altBase = dna.StringToBase(strings.ToUpper(v.Alt[0]))
+		reads = sam.SeekBamRegionRecycle(br, idx, v.Chr, uint32(v.Pos-1), uint32(v.Pos), reads)
+		refFamilies, altFamilies = duplexAlleleCounts(reads, v.Pos, refBase, altBase, minStrandedDepth, minMapQ, minBaseQuality)
+		if refFamilies+altFamilies < minFamilies {
+			continue
+		}
+
+		if _, found = perChrom[v.Chr]; !found {
+			perChrom[v.Chr] = new(contamSummary)
+			chromOrder = append(chromOrder, v.Chr)
+		}
+
+		altFrac = float64(altFamilies) / float64(refFamilies+altFamilies)
+		switch {
+		case altFrac < homThreshold:
+			genotype, minor, minorAf = "0/0", altFamilies, popAf
+		case altFrac > 1-homThreshold:
+			genotype, minor, minorAf = "1/1", refFamilies, 1-popAf
+		default:
+			genotype = "0/1"
+		}
+
+		for _, s := range []*contamSummary{total, perChrom[v.Chr]} {
+			s.sites++
+			if genotype == "0/1" {
+				s.hetSites++
+				continue
+			}
+			s.homSites++
+			s.families += refFamilies + altFamilies
+			s.minorFamilies += minor
+			s.expectedFamilies += float64(refFamilies+altFamilies) * minorAf
+		}
+
+		if sitesWriter != nil {
+			_, err := fmt.Fprintf(sitesWriter, "%s\t%d\t%s\t%s\t%g\t%d\t%d\t%s\n", v.Chr, v.Pos, v.Ref, v.Alt[0], popAf, refFamilies, altFamilies, genotype)
+			exception.PanicOnErr(err)
+		}
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Group\tSites\tHomSites\tHetSites\tHetFraction\tHomFamilies\tMinorAlleleFamilies\tContamination")
+	exception.PanicOnErr(err)
+	writeSummary(out, "ALL", total)
+	for _, chr := range chromOrder {
+		writeSummary(out, chr, perChrom[chr])
+	}
+}
+
+// duplexAlleleCounts returns the number of read families where both strands support the ref or alt base at pos.
+func duplexAlleleCounts(reads []sam.Sam, pos int, refBase, altBase dna.Base, minStrandedDepth int, minMapQ, minBaseQuality uint8) (refFamilies, altFamilies int) {
+	type strandCounts struct {
+		watson [4]int
+		crick  [4]int
+	}
+	families := make(map[string]*strandCounts)
+	var rf string
+	var rs byte
+	var b dna.Base
+	var q uint8
+	var ok bool
+	var c *strandCounts
+	for i := range reads {
+		if reads[i].MapQ < minMapQ || sam.IsUnmapped(reads[i]) || sam.IsNotPrimaryAlign(reads[i]) || sam.IsSupplementaryAlign(reads[i]) {
+			continue
+		}
+		b, q, ok = baseAtPos(reads[i], pos)
+		if !ok || q < minBaseQuality || b > dna.T {
+			continue
+		}
+		sam.ParseExtra(&reads[i])
+		rf = barcode.GetRF(&reads[i])
+		rs = barcode.GetRS(&reads[i])
+		if rf == "" {
+			continue
+		}
+		if c = families[rf]; c == nil {
+			c = new(strandCounts)
+			families[rf] = c
+		}
+		switch rs {
+		case 'W':
+			c.watson[b]++
+		case 'C':
+			c.crick[b]++
+		}
+	}
+
+	var wBase, cBase dna.Base
+	var wOk, cOk bool
+	for _, c = range families {
+		wBase, wOk = majorityBase(c.watson, minStrandedDepth)
+		cBase, cOk = majorityBase(c.crick, minStrandedDepth)
+		if !wOk || !cOk || wBase != cBase {
+			continue
+		}
+		switch wBase {
+		case refBase:
+			refFamilies++
+		case altBase:
+			altFamilies++
+		}
+	}
+	return
+}
+
+// majorityBase returns the base supported by more than half of the reads on a strand.
+func majorityBase(counts [4]int, minDepth int) (dna.Base, bool) {
+	var depth, best int
+	for i := range counts {
+		depth += counts[i]
+		if counts[i] > counts[best] {
+			best = i
+		}
+	}
+	if depth < minDepth || counts[best]*2 <= depth {
+		return dna.N, false
+	}
+	return dna.Base(best), true
+}
+
+// baseAtPos returns the base and quality aligned to the 1-based reference position pos.
+func baseAtPos(r sam.Sam, pos int) (dna.Base, uint8, bool) {
+	refPos := int(r.Pos)
+	var queryPos int
+	for _, c := range r.Cigar {
+		switch c.Op {
+		case 'M', '=', 'X':
+			if pos < refPos+c.RunLength {
+				if pos < refPos {
+					return dna.N, 0, false
+				}
+				return dna.ToUpper(r.Seq[queryPos+pos-refPos]), r.Qual[queryPos+pos-refPos] - 33, true
+			}
+			refPos += c.RunLength
+			queryPos += c.RunLength
+		case 'D', 'N':
+			if pos < refPos+c.RunLength {
+				return dna.N, 0, false
+			}
+			refPos += c.RunLength
+		case 'I', 'S':
+			queryPos += c.RunLength
+		}
+	}
+	return dna.N, 0, false
+}
+
+// getAf parses the AF field from a vcf INFO column.
+func getAf(info string) (float64, bool) {
+	for _, field := range strings.Split(info, ";") {
+		if !strings.HasPrefix(field, "AF=") {
+			continue
+		}
+		af, err := strconv.ParseFloat(strings.Split(field[3:], ",")[0], 64)
+		if err != nil {
+			return 0, false
+		}
+		return af, true
+	}
+	return 0, false
+}
+
+func writeSummary(out io.Writer, name string, s *contamSummary) {
+	var hetFrac, contam float64
+	if s.sites > 0 {
+		hetFrac = float64(s.hetSites) / float64(s.sites)
+	}
+	if s.expectedFamilies > 0 {
+		contam = float64(s.minorFamilies) / s.expectedFamilies
+	}
+	_, err := fmt.Fprintf(out, "%s\t%d\t%d\t%d\t%.4f\t%d\t%d\t%.5f\n", name, s.sites, s.homSites, s.hetSites, hetFrac, s.families, s.minorFamilies, contam)
+	exception.PanicOnErr(err)
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
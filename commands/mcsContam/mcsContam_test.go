@@ -0,0 +1,81 @@
+package mcsContam
+
+import (
+	"bytes"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"testing"
+)
+
+func TestMajorityBase(t *testing.T) {
+	tests := []struct {
+		counts   [4]int
+		minDepth int
+		expected dna.Base
+		ok       bool
+	}{
+		{[4]int{0, 3, 0, 0}, 3, dna.C, true},
+		{[4]int{0, 3, 0, 0}, 4, dna.N, false}, // below the minimum depth
+		{[4]int{2, 3, 0, 0}, 1, dna.C, true},
+		{[4]int{2, 2, 0, 0}, 1, dna.N, false}, // no majority
+		{[4]int{1, 1, 1, 1}, 1, dna.N, false},
+	}
+	for _, test := range tests {
+		b, ok := majorityBase(test.counts, test.minDepth)
+		if b != test.expected || ok != test.ok {
+			t.Errorf("expected %s %v for %v, got %s %v", dna.BaseToString(test.expected), test.ok, test.counts, dna.BaseToString(b), ok)
+		}
+	}
+}
+
+func TestBaseAtPos(t *testing.T) {
+	// AC at 10-11, 12-13 deleted, G at 14, an inserted T, then AC at 15-16
+	r := sam.Sam{Pos: 10, Seq: dna.StringToBases("ttACGTAC"), Qual: "!!+5?ISI", Cigar: cigar.FromString("2S2M2D1M1I2M")}
+	tests := []struct {
+		pos      int
+		expected string
+		qual     uint8
+		ok       bool
+	}{
+		{9, "N", 0, false},
+		{10, "A", 10, true},
+		{11, "C", 20, true},
+		{12, "N", 0, false},
+		{14, "G", 30, true},
+		{15, "A", 50, true},
+		{17, "N", 0, false},
+	}
+	for _, test := range tests {
+		b, q, ok := baseAtPos(r, test.pos)
+		if dna.BaseToString(b) != test.expected || q != test.qual || ok != test.ok {
+			t.Errorf("expected %s Q%d %v at %d, got %s Q%d %v", test.expected, test.qual, test.ok, test.pos, dna.BaseToString(b), q, ok)
+		}
+	}
+}
+
+func TestGetAf(t *testing.T) {
+	tests := []struct {
+		info     string
+		expected float64
+		ok       bool
+	}{
+		{"AC=1;AF=0.25;AN=4", 0.25, true},
+		{"AF=0.1,0.2", 0.1, true},
+		{"CAF=0.5", 0, false},
+		{"AF=.", 0, false},
+	}
+	for _, test := range tests {
+		if af, ok := getAf(test.info); af != test.expected || ok != test.ok {
+			t.Errorf("expected %g %v from %s, got %g %v", test.expected, test.ok, test.info, af, ok)
+		}
+	}
+}
+
+func TestWriteSummary(t *testing.T) {
+	var out bytes.Buffer
+	writeSummary(&out, "s1", &contamSummary{sites: 10, homSites: 8, hetSites: 2, families: 400, minorFamilies: 3, expectedFamilies: 300})
+	if expected := "s1\t10\t8\t2\t0.2000\t400\t3\t0.01000\n"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,520 @@
+package mcsCoverage
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsCoverage - Report the uniformity of duplex read family coverage across the genome to compare library preps and\n" +
+			"predict callable territory before running mcsCallVariants.\n" +
+			"Coverage is the number of passing read families (-a, -s, -minReadFamilyLength) covering each base. The territory is every\n" +
+			"contig in the reference of at least -minContigSize bp, restricted to -t if set. The territory is tiled with windows of -w bp\n" +
+			"and the mean coverage of each window is used for the coefficient of variation, gini coefficient, and lorenz curve.\n" +
+			"Callable bases are covered by at least -minFamilies passing families and at most -maxOverlappingFamilies families,\n" +
+			"approximating the sites considered by mcsCallVariants.\n" +
+			"Multiple family bed files may be given to compare libraries. Output is a TSV with columns Sample, Metric, Value.\n" +
+			"Usage:\n" +
+			"mcsCoverage [options] -b families.bed -r ref.fa > coverage.tsv\n" +
+			"mcsCoverage [options] -b prepA.bed -b prepB.bed -r ref.fa -windowsOut windows.txt > coverage.tsv\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsCoverage with the options in os.Args.
+func Main() {
+	var bedFiles inputFiles
+	flag.Var(&bedFiles, "b", "Input bed file with read families generated with -bed option in annotateReadFamilies. May be declared more than once.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai).")
+	targets := flag.String("t", "", "Bed, interval_list, or GFF3 file of regions to restrict the territory to.")
+	output := flag.String("o", "stdout", "Output file.")
+	windowSize := flag.Int("w", 100_000, "Size of windows used for uniformity metrics.")
+	thresholds := flag.String("thresholds", "1,2,5,10", "Comma separated list of family depths. The fraction of the territory covered by at least each depth is reported.")
+	windowsOut := flag.String("windowsOut", "", "Write the mean family coverage of each window for each sample to this file.")
+	lorenzOut := flag.String("lorenzOut", "", "Write the lorenz curve of window coverage for each sample to this file.")
+	totalDepth := flag.Int("a", 8, "Minimum total depth of read family to pass. Should match -a in mcsCallVariants.")
+	strandedDepth := flag.Int("s", 4, "Minimum depth of independent watson and crick strands to pass. Should match -s in mcsCallVariants.")
+	minReadFamilyLength := flag.Int("minReadFamilyLength", 100, "Minimum length in bp of read family to pass. Should match -minReadFamilyLength in mcsCallVariants.")
+	minContigSize := flag.Int("minContigSize", 10_000_000, "Contigs shorter than this are excluded from the territory. Should match -minContigSize in mcsCallVariants.")
+	maxOverlappingFamilies := flag.Int("maxOverlappingFamilies", 20, "Bases covered by more families than this are not callable. Set to -1 for no limit. Should match -maxOverlappingFamilies in mcsCallVariants.")
+	minFamilies := flag.Int("minFamilies", 1, "Minimum number of passing families covering a base for it to be callable.")
+	dryrun.Parse()
+
+	if len(bedFiles) == 0 || *ref == "" {
+		usage()
+		log.Fatal("ERROR: must specify at least one family bed file (-b) and a reference (-r).")
+	}
+
+	if *windowSize <= 0 {
+		usage()
+		log.Fatal("ERROR: -w must be greater than 0.")
+	}
+
+	c := coverageParams{
+		windowSize:             *windowSize,
+		thresholds:             parseThresholds(*thresholds),
+		minTotalDepth:          *totalDepth,
+		minStrandedDepth:       *strandedDepth,
+		minReadFamilyLength:    *minReadFamilyLength,
+		minContigSize:          *minContigSize,
+		maxOverlappingFamilies: *maxOverlappingFamilies,
+		minFamilies:            *minFamilies,
+	}
+
+	mcsCoverage(bedFiles, *ref, *targets, *output, *windowsOut, *lorenzOut, c)
+}
+
+// coverageParams stores the options for computing coverage.
+type coverageParams struct {
+	windowSize             int
+	thresholds             []int
+	minTotalDepth          int
+	minStrandedDepth       int
+	minReadFamilyLength    int
+	minContigSize          int
+	maxOverlappingFamilies int
+	minFamilies            int
+}
+
+// window is a window of the territory. Only windows with territory bases are kept.
+type window struct {
+	chrom string
+	start int
+	end   int
+	bases int // bases of the territory in the window
+}
+
+// sampleCoverage stores the coverage summary of a single family bed file.
+type sampleCoverage struct {
+	name             string
+	passingFamilies  int
+	coveredBases     int   // sum of family coverage over all bases of the territory
+	basesAtThreshold []int // bases covered by at least each threshold
+	callableBases    int
+	windowDepth      []float64 // mean family coverage of each window
+}
+
+func mcsCoverage(bedFiles []string, ref, targets, output, windowsOut, lorenzOut string, c coverageParams) {
+	idx := fai.ReadIndex(ref + ".fai")
+	territory := makeTerritory(idx, targets, c.minContigSize)
+	windows := makeWindows(idx, territory, c.windowSize)
+	var territoryBases int
+	for i := range windows {
+		territoryBases += windows[i].bases
+	}
+	if territoryBases == 0 {
+		log.Fatal("ERROR: territory is empty. Check -t and -minContigSize.")
+	}
+
+	samples := make([]sampleCoverage, len(bedFiles))
+	for i := range bedFiles {
+		samples[i] = sampleCov(bedFiles[i], idx, territory, windows, c)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Sample\tMetric\tValue")
+	exception.PanicOnErr(err)
+	for i := range samples {
+		writeMetrics(out, samples[i], territoryBases, c)
+	}
+
+	if windowsOut != "" {
+		writeWindows(windowsOut, windows, samples)
+	}
+	if lorenzOut != "" {
+		writeLorenz(lorenzOut, samples)
+	}
+}
+
+// makeTerritory returns the merged regions of each contig included in the territory, keyed by chrom.
+func makeTerritory(idx fai.Index, targets string, minContigSize int) map[string][]bed.Bed {
+	ans := make(map[string][]bed.Bed)
+	if targets == "" {
+		for _, chr := range idx.Names() {
+			if idx.Size(chr) >= minContigSize {
+				ans[chr] = []bed.Bed{{Chrom: chr, ChromStart: 0, ChromEnd: idx.Size(chr), FieldsInitialized: 3}}
+			}
+		}
+		return ans
+	}
+
+	sizes := make(map[string]int)
+	for _, chr := range idx.Names() {
+		sizes[chr] = idx.Size(chr)
+	}
+	var regions []bed.Bed
+	for _, b := range intervals.Read(targets) {
+		size, found := sizes[b.Chrom]
+		if !found {
+			log.Printf("WARNING: %s in %s was not found in the reference and will be ignored.", b.Chrom, targets)
+			continue
+		}
+		if size < minContigSize {
+			continue
+		}
+		b.ChromEnd = min(b.ChromEnd, size)
+		if b.ChromEnd <= b.ChromStart {
+			continue
+		}
+		b.FieldsInitialized = 3
+		regions = append(regions, b)
+	}
+	for _, b := range bed.MergeBeds(regions) {
+		ans[b.Chrom] = append(ans[b.Chrom], b)
+	}
+	for chr := range ans {
+		sort.Slice(ans[chr], func(i, j int) bool { return ans[chr][i].ChromStart < ans[chr][j].ChromStart })
+	}
+	return ans
+}
+
+// makeWindows tiles each contig in reference order and keeps windows overlapping the territory.
+func makeWindows(idx fai.Index, territory map[string][]bed.Bed, windowSize int) []window {
+	var ans []window
+	var w window
+	for _, chr := range idx.Names() {
+		regions := territory[chr]
+		if len(regions) == 0 {
+			continue
+		}
+		var j int
+		for start := 0; start < idx.Size(chr); start += windowSize {
+			w = window{chrom: chr, start: start, end: min(start+windowSize, idx.Size(chr))}
+			for j < len(regions) && regions[j].ChromEnd <= w.start {
+				j++
+			}
+			for k := j; k < len(regions) && regions[k].ChromStart < w.end; k++ {
+				w.bases += min(regions[k].ChromEnd, w.end) - max(regions[k].ChromStart, w.start)
+			}
+			if w.bases > 0 {
+				ans = append(ans, w)
+			}
+		}
+	}
+	return ans
+}
+
+// sampleCov computes the coverage of the territory by the families in bedFile.
+func sampleCov(bedFile string, idx fai.Index, territory map[string][]bed.Bed, windows []window, c coverageParams) sampleCoverage {
+	ans := sampleCoverage{
+		name:             strings.TrimSuffix(filepath.Base(bedFile), ".bed"),
+		basesAtThreshold: make([]int, len(c.thresholds)),
+		windowDepth:      make([]float64, len(windows)),
+	}
+
+	// starts and ends of passing families, and of all families for the overlap limit
+	passStarts, passEnds := make(map[string][]int), make(map[string][]int)
+	allStarts, allEnds := make(map[string][]int), make(map[string][]int)
+	var watson, crick int
+	var err error
+	families := bed.GoReadToChan(bedFile)
+	for b := range families {
+		if b.Name == "0" {
+			continue
+		}
+		if len(b.Annotation) < 2 {
+			exit.Fatalf(exit.MissingTag, "bed record is missing watson and crick read counts. Was it generated with annotateReadFamilies?\n%s", bed.ToString(b, b.FieldsInitialized))
+		}
+		if len(territory[b.Chrom]) == 0 || b.ChromEnd-b.ChromStart < c.minReadFamilyLength {
+			continue
+		}
+		allStarts[b.Chrom] = append(allStarts[b.Chrom], b.ChromStart)
+		allEnds[b.Chrom] = append(allEnds[b.Chrom], b.ChromEnd)
+		watson, err = strconv.Atoi(b.Annotation[0])
+		exception.PanicOnErr(err)
+		crick, err = strconv.Atoi(b.Annotation[1])
+		exception.PanicOnErr(err)
+		if watson+crick < c.minTotalDepth || watson < c.minStrandedDepth || crick < c.minStrandedDepth {
+			continue
+		}
+		ans.passingFamilies++
+		passStarts[b.Chrom] = append(passStarts[b.Chrom], b.ChromStart)
+		passEnds[b.Chrom] = append(passEnds[b.Chrom], b.ChromEnd)
+	}
+
+	var w int // index of the first window of the current chrom
+	for _, chr := range idx.Names() {
+		if len(territory[chr]) == 0 {
+			continue
+		}
+		for w < len(windows) && windows[w].chrom != chr {
+			w++
+		}
+		sweep(territory[chr], passStarts[chr], passEnds[chr], allStarts[chr], allEnds[chr], windows[w:], ans.windowDepth[w:], &ans, c)
+	}
+
+	for i := range windows {
+		ans.windowDepth[i] /= float64(windows[i].bases)
+	}
+	return ans
+}
+
+// sweep walks the segments of constant coverage on a single chrom and adds the coverage of segments
+// in the territory to ans. windows must start with the first window of the chrom, and windowDepth
+// is the matching slice of ans.windowDepth.
+func sweep(territory []bed.Bed, passStarts, passEnds, allStarts, allEnds []int, windows []window, windowDepth []float64, ans *sampleCoverage, c coverageParams) {
+	for _, s := range [][]int{passStarts, passEnds, allStarts, allEnds} {
+		sort.Ints(s)
+	}
+	breaks := make([]int, 0, 2*len(allStarts)+2*len(territory))
+	breaks = append(breaks, allStarts...)
+	breaks = append(breaks, allEnds...)
+	for _, t := range territory {
+		breaks = append(breaks, t.ChromStart, t.ChromEnd)
+	}
+	sort.Ints(breaks)
+
+	var ps, pe, as, ae, t, w int
+	var depth, allDepth, segEnd, l int
+	for i := 0; i < len(breaks)-1; i++ {
+		if breaks[i] == breaks[i+1] {
+			continue
+		}
+		for ps < len(passStarts) && passStarts[ps] <= breaks[i] {
+			ps++
+		}
+		for pe < len(passEnds) && passEnds[pe] <= breaks[i] {
+			pe++
+		}
+		for as < len(allStarts) && allStarts[as] <= breaks[i] {
+			as++
+		}
+		for ae < len(allEnds) && allEnds[ae] <= breaks[i] {
+			ae++
+		}
+		for t < len(territory) && territory[t].ChromEnd <= breaks[i] {
+			t++
+		}
+		if t == len(territory) {
+			break
+		}
+		if territory[t].ChromStart > breaks[i] {
+			continue
+		}
+		depth, allDepth = ps-pe, as-ae
+		l = breaks[i+1] - breaks[i]
+
+		ans.coveredBases += depth * l
+		for j := range c.thresholds {
+			if depth >= c.thresholds[j] {
+				ans.basesAtThreshold[j] += l
+			}
+		}
+		if depth >= c.minFamilies && (c.maxOverlappingFamilies < 0 || allDepth <= c.maxOverlappingFamilies) {
+			ans.callableBases += l
+		}
+
+		// segments may span window boundaries
+		for pos := breaks[i]; pos < breaks[i+1]; pos = segEnd {
+			for windows[w].end <= pos {
+				w++
+			}
+			segEnd = min(breaks[i+1], windows[w].end)
+			windowDepth[w] += float64(depth * (segEnd - pos))
+		}
+	}
+}
+
+// metric is a single line of the output.
+type metric struct {
+	name  string
+	value any
+}
+
+func writeMetrics(out io.Writer, s sampleCoverage, territoryBases int, c coverageParams) {
+	mean, sd := meanSd(s.windowDepth)
+	cv := math.NaN()
+	if mean > 0 {
+		cv = sd / mean
+	}
+	lines := []metric{
+		{"PassingFamilies", s.passingFamilies},
+		{"TerritoryBases", territoryBases},
+		{"Windows", len(s.windowDepth)},
+		{"MeanFamilyCoverage", float64(s.coveredBases) / float64(territoryBases)},
+		{"MeanWindowCoverage", mean},
+		{"WindowCoverageSd", sd},
+		{"WindowCoverageCv", cv},
+		{"Gini", gini(s.windowDepth)},
+		{"FractionWindowsUncovered", fractionZero(s.windowDepth)},
+	}
+	for j := range c.thresholds {
+		lines = append(lines, metric{fmt.Sprintf("FractionBases_%dx", c.thresholds[j]), float64(s.basesAtThreshold[j]) / float64(territoryBases)})
+	}
+	lines = append(lines,
+		metric{"CallableBases", s.callableBases},
+		metric{"CallableFraction", float64(s.callableBases) / float64(territoryBases)})
+
+	var err error
+	for _, l := range lines {
+		_, err = fmt.Fprintf(out, "%s\t%s\t%v\n", s.name, l.name, l.value)
+		exception.PanicOnErr(err)
+	}
+}
+
+// writeWindows writes the mean family coverage of each window with one column per sample.
+func writeWindows(filename string, windows []window, samples []sampleCoverage) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	var err error
+	_, err = fmt.Fprint(out, "#chrom\tstart\tend\tterritoryBases")
+	exception.PanicOnErr(err)
+	for i := range samples {
+		_, err = fmt.Fprintf(out, "\t%s", samples[i].name)
+		exception.PanicOnErr(err)
+	}
+	_, err = fmt.Fprintln(out)
+	exception.PanicOnErr(err)
+	for i, w := range windows {
+		_, err = fmt.Fprintf(out, "%s\t%d\t%d\t%d", w.chrom, w.start, w.end, w.bases)
+		exception.PanicOnErr(err)
+		for j := range samples {
+			_, err = fmt.Fprintf(out, "\t%.3f", samples[j].windowDepth[i])
+			exception.PanicOnErr(err)
+		}
+		_, err = fmt.Fprintln(out)
+		exception.PanicOnErr(err)
+	}
+}
+
+// lorenzPoints is the number of points of the lorenz curve written for each sample.
+const lorenzPoints = 100
+
+// writeLorenz writes the cumulative fraction of coverage in the least covered fraction of windows for each sample.
+func writeLorenz(filename string, samples []sampleCoverage) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Sample\tFractionWindows\tFractionCoverage")
+	exception.PanicOnErr(err)
+	var curve []float64
+	for i := range samples {
+		curve = lorenz(samples[i].windowDepth)
+		for p := 0; p <= lorenzPoints; p++ {
+			_, err = fmt.Fprintf(out, "%s\t%.2f\t%.4f\n", samples[i].name, float64(p)/lorenzPoints,
+				curve[p*(len(curve)-1)/lorenzPoints])
+			exception.PanicOnErr(err)
+		}
+	}
+}
+
+// lorenz returns the cumulative fraction of coverage after sorting windows by increasing coverage.
+// The first value is always 0, so the returned slice has one more value than depths.
+func lorenz(depths []float64) []float64 {
+	sorted := make([]float64, len(depths))
+	copy(sorted, depths)
+	sort.Float64s(sorted)
+	ans := make([]float64, len(sorted)+1)
+	var total float64
+	for i := range sorted {
+		total += sorted[i]
+		ans[i+1] = total
+	}
+	for i := range ans {
+		if total > 0 {
+			ans[i] /= total
+		}
+	}
+	return ans
+}
+
+// gini returns the gini coefficient of window coverage, 0 for perfectly uniform coverage approaching 1 when
+// all coverage is in a single window.
+func gini(depths []float64) float64 {
+	curve := lorenz(depths)
+	if len(depths) == 0 || curve[len(curve)-1] == 0 {
+		return math.NaN()
+	}
+	var area float64
+	for i := 1; i < len(curve); i++ {
+		area += (curve[i-1] + curve[i]) / 2
+	}
+	return 1 - 2*area/float64(len(depths))
+}
+
+func meanSd(x []float64) (mean, sd float64) {
+	if len(x) == 0 {
+		return math.NaN(), math.NaN()
+	}
+	for i := range x {
+		mean += x[i]
+	}
+	mean /= float64(len(x))
+	for i := range x {
+		sd += (x[i] - mean) * (x[i] - mean)
+	}
+	sd = math.Sqrt(sd / float64(len(x)))
+	return
+}
+
+func fractionZero(x []float64) float64 {
+	var zero int
+	for i := range x {
+		if x[i] == 0 {
+			zero++
+		}
+	}
+	return float64(zero) / float64(len(x))
+}
+
+func parseThresholds(s string) []int {
+	var ans []int
+	for _, word := range strings.Split(s, ",") {
+		if word = strings.TrimSpace(word); word == "" {
+			continue
+		}
+		t, err := strconv.Atoi(word)
+		if err != nil || t < 1 {
+			log.Fatalf("ERROR: could not parse threshold '%s'. Thresholds must be positive integers.", word)
+		}
+		ans = append(ans, t)
+	}
+	return ans
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
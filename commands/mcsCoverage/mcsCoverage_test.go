@@ -0,0 +1,139 @@
+package mcsCoverage
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMcsCoverage(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	ref := filepath.Join(dir, "ref.fa")
+	write("ref.fa.fai", "chr1\t1000\t6\t60\t61\nchr2\t50\t1030\t60\t61\n")
+	// the territory is chr1 0-400 and 800-1000. chr2 is shorter than minContigSize and chr3 is not in the reference.
+	targets := write("targets.bed", "chr1\t0\t300\nchr1\t250\t400\nchr1\t800\t1200\nchr2\t0\t50\nchr3\t0\t100\n")
+	families := write("prepA.bed", "chr1\t0\t1000\t0\t0\t+\t10\t10\n"+ // reads without a family
+		"chr1\t10\t30\t1\t0\t+\t5\t5\n"+ // shorter than minReadFamilyLength
+		"chr1\t100\t300\t2\t0\t+\t5\t5\n"+
+		"chr1\t200\t450\t3\t0\t+\t5\t5\n"+
+		"chr1\t250\t350\t4\t0\t+\t1\t1\n"+ // fails, but counts toward maxOverlappingFamilies
+		"chr1\t850\t900\t5\t0\t+\t4\t4\n"+
+		"chr2\t0\t50\t6\t0\t+\t5\t5\n") // outside the territory
+	c := coverageParams{
+		windowSize:             500,
+		thresholds:             []int{1, 2},
+		minTotalDepth:          8,
+		minStrandedDepth:       4,
+		minReadFamilyLength:    50,
+		minContigSize:          100,
+		maxOverlappingFamilies: 2,
+		minFamilies:            1,
+	}
+	output := filepath.Join(dir, "coverage.tsv")
+	windowsOut := filepath.Join(dir, "windows.txt")
+	mcsCoverage([]string{families}, ref, targets, output, windowsOut, "", c)
+
+	// coverage is 1 at 100-200 and 300-400, 2 at 200-300, and 1 at 850-900 with 3 families at 250-300.
+	// the first window has 400 territory bases and a mean coverage of 1, the second has 200 bases and 0.25.
+	expected := map[string]float64{
+		"PassingFamilies":          3,
+		"TerritoryBases":           600,
+		"Windows":                  2,
+		"MeanFamilyCoverage":       450.0 / 600,
+		"MeanWindowCoverage":       0.625,
+		"WindowCoverageSd":         0.375,
+		"WindowCoverageCv":         0.6,
+		"Gini":                     0.3,
+		"FractionWindowsUncovered": 0,
+		"FractionBases_1x":         350.0 / 600,
+		"FractionBases_2x":         100.0 / 600,
+		"CallableBases":            300,
+		"CallableFraction":         300.0 / 600,
+	}
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(data), "\n"), "\n")
+	if lines[0] != "Sample\tMetric\tValue" || len(lines) != len(expected)+1 {
+		t.Fatalf("unexpected output:\n%s", data)
+	}
+	for _, line := range lines[1:] {
+		words := strings.Split(line, "\t")
+		value, err := strconv.ParseFloat(words[2], 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if words[0] != "prepA" || math.Abs(value-expected[words[1]]) > 1e-9 {
+			t.Errorf("expected %s to be %g for prepA, got %s", words[1], expected[words[1]], line)
+		}
+	}
+
+	data, err = os.ReadFile(windowsOut)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expectedWindows := "#chrom\tstart\tend\tterritoryBases\tprepA\nchr1\t0\t500\t400\t1.000\nchr1\t500\t1000\t200\t0.250\n"; string(data) != expectedWindows {
+		t.Errorf("expected windows:\n%s\ngot:\n%s", expectedWindows, data)
+	}
+}
+
+func TestLorenz(t *testing.T) {
+	tests := []struct {
+		depths   []float64
+		expected []float64
+		gini     float64
+	}{
+		{[]float64{2, 0, 1, 1}, []float64{0, 0, 0.25, 0.5, 1}, 0.375},
+		{[]float64{3, 3, 3}, []float64{0, 1.0 / 3, 2.0 / 3, 1}, 0},
+		{[]float64{0, 0, 0, 4}, []float64{0, 0, 0, 0, 1}, 0.75}, // all coverage in one window
+	}
+	for _, test := range tests {
+		actual := lorenz(test.depths)
+		if len(actual) != len(test.expected) {
+			t.Fatalf("expected %v for %v, got %v", test.expected, test.depths, actual)
+		}
+		for i := range actual {
+			if math.Abs(actual[i]-test.expected[i]) > 1e-9 {
+				t.Errorf("expected %v for %v, got %v", test.expected, test.depths, actual)
+				break
+			}
+		}
+		if g := gini(test.depths); math.Abs(g-test.gini) > 1e-9 {
+			t.Errorf("expected a gini coefficient of %g for %v, got %g", test.gini, test.depths, g)
+		}
+	}
+	if g := gini([]float64{0, 0}); !math.IsNaN(g) {
+		t.Errorf("expected NaN for uncovered windows, got %g", g)
+	}
+}
+
+func TestMeanSd(t *testing.T) {
+	mean, sd := meanSd([]float64{2, 4, 4, 4, 5, 5, 7, 9})
+	if mean != 5 || sd != 2 {
+		t.Errorf("expected a mean of 5 and sd of 2, got %g and %g", mean, sd)
+	}
+	if mean, sd = meanSd(nil); !math.IsNaN(mean) || !math.IsNaN(sd) {
+		t.Errorf("expected NaN without windows, got %g and %g", mean, sd)
+	}
+	if f := fractionZero([]float64{0, 1, 0, 2}); f != 0.5 {
+		t.Errorf("expected half of windows uncovered, got %g", f)
+	}
+}
+
+func TestParseThresholds(t *testing.T) {
+	if actual := parseThresholds(" 1, 5,,10"); !reflect.DeepEqual(actual, []int{1, 5, 10}) {
+		t.Errorf("expected [1 5 10], got %v", actual)
+	}
+}
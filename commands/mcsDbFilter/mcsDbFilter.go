@@ -0,0 +1,77 @@
+package mcsDbFilter
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsDbFilter - Annotate and filter variants by population allele frequency from a large tabix indexed VCF (e.g. gnomAD).\n" +
+			"Input variants are streamed and the database is read only around each variant using its tabix index (.tbi),\n" +
+			"so neither file is loaded into memory. Variants are matched on position, REF, and ALT. The population AF\n" +
+			"is added to INFO as POP_AF and variants with POP_AF above -maxAf are marked with the popAF FILTER, or removed with -remove.\n" +
+			"Sequence names are matched with or without a 'chr' prefix.\n" +
+			"Usage:\n" +
+			"mcsDbFilter [options] -i calls.vcf -d gnomad.vcf.gz > filtered.vcf\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsDbFilter with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input VCF file, e.g. from mcsCallVariants. Should be sorted for efficient database access.")
+	db := flag.String("d", "", "Population database VCF. Must be bgzip compressed and tabix indexed (.tbi).")
+	output := flag.String("o", "stdout", "Output VCF file.")
+	afField := flag.String("afField", "AF", "INFO field in the database with the population allele frequency.")
+	maxAf := flag.Float64("maxAf", 0.001, "Variants with a population allele frequency above this value are filtered.")
+	remove := flag.Bool("remove", false, "Remove filtered variants instead of marking them in the FILTER column.")
+	dryrun.Parse()
+
+	if *input == "" || *db == "" {
+		usage()
+		log.Fatal("ERROR: must specify input vcf (-i) and database (-d).")
+	}
+
+	mcsDbFilter(*input, *db, *output, *afField, *maxAf, *remove)
+}
+
+func mcsDbFilter(input, db, output, afField string, maxAf float64, remove bool) {
+	pop := popaf.Open(db, afField)
+	defer cleanup(pop)
+
+	records, header := vcf.GoReadToChan(input)
+	out := tabix.Create(output)
+	defer cleanup(out)
+	vcf.NewWriteHeader(out, provenance.Vcf(popaf.AddHeader(header, afField, maxAf, remove)))
+
+	var total, annotated, filtered int
+	for v := range records {
+		total++
+		found, above := pop.Annotate(&v, maxAf)
+		if found {
+			annotated++
+		}
+		if above {
+			filtered++
+			if remove {
+				continue
+			}
+			v.Filter = popaf.AppendFilter(v.Filter, popaf.Filter)
+		}
+		vcf.WriteVcf(out, v)
+	}
+	log.Printf("Found %d of %d variants in the database. %d variants exceed -maxAf.\n", annotated, total, filtered)
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,74 @@
+package mcsDbFilter
+
+import (
+	"github.com/dasnellings/duplexTools/tabix"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDb = `##fileformat=VCFv4.2
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO
+1	100	.	A	C,G	.	PASS	AF=0.01,0.00001
+1	200	.	G	T	.	PASS	AF=0.5
+`
+
+const testCalls = `##fileformat=VCFv4.2
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO	FORMAT	sample
+chr1	100	.	A	C	50	PASS	DS	GT	1
+chr1	100	.	A	G	50	PASS	DS	GT	1
+chr1	150	.	C	T	50	PASS	DS	GT	1
+chr1	200	.	G	T	50	min_af	DS	GT	1
+`
+
+func TestMcsDbFilter(t *testing.T) {
+	dir := t.TempDir()
+	db := filepath.Join(dir, "db.vcf.gz")
+	out := tabix.Create(db)
+	io.WriteString(out, testDb)
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	input := filepath.Join(dir, "calls.vcf")
+	if err := os.WriteFile(input, []byte(testCalls), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remove   bool
+		expected []string
+	}{
+		{false, []string{
+			"chr1\t100\t.\tA\tC\t50\tpopAF\tDS;POP_AF=0.01\tGT\t1",
+			"chr1\t100\t.\tA\tG\t50\tPASS\tDS;POP_AF=0.00001\tGT\t1",
+			"chr1\t150\t.\tC\tT\t50\tPASS\tDS\tGT\t1",
+			"chr1\t200\t.\tG\tT\t50\tmin_af;popAF\tDS;POP_AF=0.5\tGT\t1",
+		}},
+		{true, []string{
+			"chr1\t100\t.\tA\tG\t50\tPASS\tDS;POP_AF=0.00001\tGT\t1",
+			"chr1\t150\t.\tC\tT\t50\tPASS\tDS\tGT\t1",
+		}},
+	}
+	for _, test := range tests {
+		output := filepath.Join(dir, "out.vcf")
+		mcsDbFilter(input, db, output, "AF", 0.001, test.remove)
+		data, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var records []string
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if !strings.HasPrefix(line, "#") {
+				records = append(records, line)
+			}
+		}
+		if strings.Join(records, "\n") != strings.Join(test.expected, "\n") {
+			t.Errorf("expected with -remove %t:\n%s\ngot:\n%s", test.remove, strings.Join(test.expected, "\n"), strings.Join(records, "\n"))
+		}
+		if !strings.Contains(string(data), "##FILTER=<ID=popAF") && !test.remove {
+			t.Error("expected the popAF FILTER to be declared in the header")
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package mcsDepth
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/interval"
+	"io"
+	"log"
+	"strconv"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsDepth - Report duplex depth as the number of read families (not reads) covering each target or genomic window.\n" +
+			"Read families are taken from the bed file generated with -bed in annotateReadFamilies.\n" +
+			"Output columns: chrom, start, end, passingFamilies, meanDuplexDepth, watsonFamilies, crickFamilies, watsonReads, crickReads.\n" +
+			"passingFamilies and meanDuplexDepth only count families passing -a, -s, and -minReadFamilyLength. watsonFamilies and\n" +
+			"crickFamilies count families with at least -s reads on that strand. watsonReads and crickReads sum over all overlapping families.\n" +
+			"Usage:\n" +
+			"mcsDepth [options] -b families.bed -t targets.bed > depth.txt\n" +
+			"mcsDepth [options] -b families.bed -r ref.fa -w 100000 > depth.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsDepth with the options in os.Args.
+func Main() {
+	bedFile := flag.String("b", "", "Input bed file with read families generated with -bed option in annotateReadFamilies.")
+	targets := flag.String("t", "", "Bed, interval_list, or GFF3 file of regions to report depth for.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai). Used with -w to generate genome-wide windows.")
+	windowSize := flag.Int("w", 0, "Size of genome-wide windows. Requires -r.")
+	output := flag.String("o", "stdout", "Output file.")
+	totalDepth := flag.Int("a", 8, "Minimum total depth of read family to pass. Should match -a in mcsCallVariants.")
+	strandedDepth := flag.Int("s", 4, "Minimum depth of independent watson and crick strands to pass. Should match -s in mcsCallVariants.")
+	minReadFamilyLength := flag.Int("minReadFamilyLength", 100, "Minimum length in bp of read family to pass. Should match -minReadFamilyLength in mcsCallVariants.")
+	dryrun.Parse()
+
+	if *bedFile == "" {
+		usage()
+		log.Fatal("ERROR: must specify family bed file (-b).")
+	}
+
+	if (*targets == "") == (*windowSize <= 0) {
+		usage()
+		log.Fatal("ERROR: must specify exactly one of -t or -w.")
+	}
+
+	if *windowSize > 0 && *ref == "" {
+		usage()
+		log.Fatal("ERROR: -w requires a reference (-r).")
+	}
+
+	mcsDepth(*bedFile, *targets, *ref, *output, *windowSize, *totalDepth, *strandedDepth, *minReadFamilyLength)
+}
+
+// family is a read family parsed from the annotateReadFamilies bed output.
+type family struct {
+	b      bed.Bed
+	watson int
+	crick  int
+	passes bool
+}
+
+func (f *family) GetChrom() string {
+	return f.b.Chrom
+}
+
+func (f *family) GetChromStart() int {
+	return f.b.ChromStart
+}
+
+func (f *family) GetChromEnd() int {
+	return f.b.ChromEnd
+}
+
+func mcsDepth(bedFile, targets, ref, output string, windowSize, minTotalDepth, minStrandedDepth, minReadFamilyLength int) {
+	tree := interval.BuildTree(readFamilies(bedFile, minTotalDepth, minStrandedDepth, minReadFamilyLength))
+
+	var regions <-chan bed.Bed
+	if targets != "" {
+		regions = goIntervals(targets, ref)
+	} else {
+		regions = goMakeWindows(fai.ReadIndex(ref+".fai"), windowSize)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "#chrom\tstart\tend\tpassingFamilies\tmeanDuplexDepth\twatsonFamilies\tcrickFamilies\twatsonReads\tcrickReads")
+	exception.PanicOnErr(err)
+
+	var passing, watsonFamilies, crickFamilies, watsonReads, crickReads, coveredBases int
+	var f *family
+	var overlaps []interval.Interval
+	for region := range regions {
+		passing, watsonFamilies, crickFamilies, watsonReads, crickReads, coveredBases = 0, 0, 0, 0, 0, 0
+		overlaps = interval.Query(tree, region, "any")
+		for i := range overlaps {
+			f = overlaps[i].(*family)
+			watsonReads += f.watson
+			crickReads += f.crick
+			if f.watson >= minStrandedDepth {
+				watsonFamilies++
+			}
+			if f.crick >= minStrandedDepth {
+				crickFamilies++
+			}
+			if f.passes {
+				passing++
+				coveredBases += bed.OverlapLength(f.b, region)
+			}
+		}
+		_, err = fmt.Fprintf(out, "%s\t%d\t%d\t%d\t%.2f\t%d\t%d\t%d\t%d\n", region.Chrom, region.ChromStart, region.ChromEnd,
+			passing, float64(coveredBases)/float64(region.ChromEnd-region.ChromStart), watsonFamilies, crickFamilies, watsonReads, crickReads)
+		exception.PanicOnErr(err)
+	}
+}
+
+// readFamilies parses the family bed and marks which families pass the calling thresholds.
+func readFamilies(bedFile string, minTotalDepth, minStrandedDepth, minReadFamilyLength int) []interval.Interval {
+	var ans []interval.Interval
+	var f *family
+	var err error
+	families := bed.GoReadToChan(bedFile)
+	for b := range families {
+		if len(b.Annotation) < 2 {
+			exit.Fatalf(exit.MissingTag, "bed record is missing watson and crick read counts. Was it generated with annotateReadFamilies?\n%s", bed.ToString(b, b.FieldsInitialized))
+		}
+		f = &family{b: b}
+		f.watson, err = strconv.Atoi(b.Annotation[0])
+		exception.PanicOnErr(err)
+		f.crick, err = strconv.Atoi(b.Annotation[1])
+		exception.PanicOnErr(err)
+		f.passes = b.ChromEnd-b.ChromStart >= minReadFamilyLength &&
+			f.watson+f.crick >= minTotalDepth &&
+			f.watson >= minStrandedDepth &&
+			f.crick >= minStrandedDepth
+		ans = append(ans, f)
+	}
+	return ans
+}
+
+// goMakeWindows tiles each sequence in the index with non-overlapping windows.
+func goMakeWindows(idx fai.Index, windowSize int) <-chan bed.Bed {
+	ans := make(chan bed.Bed, 1000)
+	go func() {
+		var start, size int
+		for _, chr := range idx.Names() {
+			size = idx.Size(chr)
+			for start = 0; start < size; start += windowSize {
+				ans <- bed.Bed{Chrom: chr, ChromStart: start, ChromEnd: min(start+windowSize, size), FieldsInitialized: 3}
+			}
+		}
+		close(ans)
+	}()
+	return ans
+}
+
+// goIntervals sends the intervals in targets to the returned channel. They are checked
+// against the reference if one is given.
+func goIntervals(targets, ref string) <-chan bed.Bed {
+	regions := intervals.Read(targets)
+	if ref != "" {
+		intervals.CheckContigs(regions, fai.ReadIndex(ref+".fai"), targets)
+	}
+	ans := make(chan bed.Bed, 1000)
+	go func() {
+		for i := range regions {
+			ans <- regions[i]
+		}
+		close(ans)
+	}()
+	return ans
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
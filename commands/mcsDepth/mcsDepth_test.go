@@ -0,0 +1,36 @@
+package mcsDepth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMcsDepth(t *testing.T) {
+	dir := t.TempDir()
+	families := filepath.Join(dir, "families.bed")
+	targets := filepath.Join(dir, "targets.bed")
+	output := filepath.Join(dir, "depth.tsv")
+	err := os.WriteFile(families, []byte(
+		"chr1\t100\t300\t0\t0\t+\t5\t5\n"+ // passes
+			"chr1\t150\t350\t1\t0\t+\t10\t1\n"+ // too few crick reads
+			"chr1\t1000\t1050\t2\t0\t+\t10\t10\n"), 0644) // too short
+	if err == nil {
+		err = os.WriteFile(targets, []byte("chr1\t100\t200\nchr1\t200\t400\nchr1\t1000\t1100\n"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	mcsDepth(families, targets, "", output, 0, 8, 4, 100)
+	data, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "#chrom\tstart\tend\tpassingFamilies\tmeanDuplexDepth\twatsonFamilies\tcrickFamilies\twatsonReads\tcrickReads\n" +
+		"chr1\t100\t200\t1\t1.00\t2\t1\t15\t6\n" +
+		"chr1\t200\t400\t1\t0.50\t2\t1\t15\t6\n" +
+		"chr1\t1000\t1100\t0\t0.00\t1\t1\t10\t10\n"
+	if string(data) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, data)
+	}
+}
@@ -0,0 +1,233 @@
+package mcsDownsample
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/sam"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsDownsample - Downsample a bam file annotated with annotateReadFamilies by read family.\n" +
+			"Whole read families are kept or removed so that watson and crick reads from the same molecule stay together.\n" +
+			"Families are ranked by a seeded hash of the family ID, so for a fixed -seed smaller targets are always\n" +
+			"subsets of larger targets, which is appropriate for saturation analyses. Reads without an RF tag are removed.\n" +
+			"Usage:\n" +
+			"mcsDownsample [options] -i annotated.bam -fraction 0.5 > downsampled.bam\n" +
+			"mcsDownsample [options] -i annotated.bam -b families.bed -families 100000 > downsampled.bam\n" +
+			"mcsDownsample [options] -i annotated.bam -b families.bed -t targets.bed -duplexDepth 20 > downsampled.bam\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsDownsample with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file annotated with annotateReadFamilies.")
+	bedFile := flag.String("b", "", "Input bed file with read families generated with -bed option in annotateReadFamilies. Required for -families, -duplexDepth, and -duplexOnly.")
+	targets := flag.String("t", "", "Bed, interval_list, or GFF3 file of targeted regions. Required for -duplexDepth.")
+	output := flag.String("o", "stdout", "Output bam file.")
+	fraction := flag.Float64("fraction", -1, "Fraction of read families to keep.")
+	numFamilies := flag.Int("families", -1, "Number of read families to keep.")
+	duplexDepth := flag.Float64("duplexDepth", -1, "Target mean depth of duplex read families (families with both watson and crick reads) across the regions in -t.")
+	duplexOnly := flag.Bool("duplexOnly", false, "Only keep duplex read families, and only count them towards -families. Requires -b.")
+	seed := flag.Uint64("seed", 1, "Seed for family selection.")
+	dryrun.Parse()
+
+	var modesSet int
+	for _, val := range []float64{*fraction, float64(*numFamilies), *duplexDepth} {
+		if val >= 0 {
+			modesSet++
+		}
+	}
+
+	if *input == "" || modesSet != 1 {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i) and exactly one of -fraction, -families, or -duplexDepth.")
+	}
+
+	if (*numFamilies >= 0 || *duplexDepth >= 0) && *bedFile == "" {
+		usage()
+		log.Fatal("ERROR: -families and -duplexDepth require a family bed file (-b).")
+	}
+
+	if *duplexOnly && *bedFile == "" {
+		usage()
+		log.Fatal("ERROR: -duplexOnly requires a family bed file (-b) to tell duplex from simplex families.")
+	}
+
+	if *duplexDepth >= 0 && *targets == "" {
+		usage()
+		log.Fatal("ERROR: -duplexDepth requires a targets bed file (-t).")
+	}
+
+	if *fraction > 1 {
+		usage()
+		log.Fatal("ERROR: -fraction must be between 0 and 1.")
+	}
+
+	mcsDownsample(*input, *bedFile, *targets, *output, *fraction, *numFamilies, *duplexDepth, *duplexOnly, *seed)
+}
+
+func mcsDownsample(input, bedFile, targets, output string, fraction float64, numFamilies int, duplexDepth float64, duplexOnly bool, seed uint64) {
+	var threshold uint64
+	var families []familyRecord
+	var simplex map[string]bool // families removed by -duplexOnly
+	if bedFile != "" {
+		families = readFamilies(bedFile, seed)
+		if duplexOnly {
+			families, simplex = duplexFamilies(families)
+		}
+	}
+	switch {
+	case fraction >= 0:
+		threshold = fractionThreshold(fraction)
+	case numFamilies >= 0:
+		threshold = familyCountThreshold(families, numFamilies)
+	default:
+		threshold = duplexDepthThreshold(families, targets, duplexDepth)
+	}
+
+	reads, header := hts.GoReadToChan(input)
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	bw := bam.NewWriter(out, provenance.Sam(header))
+	defer cleanup(bw)
+
+	var rf string
+	var keptReads, totalReads int
+	for r := range reads {
+		totalReads++
+		sam.ParseExtra(&r)
+		rf = barcode.GetRF(&r)
+		if rf == "" || simplex[rf] {
+			continue
+		}
+		if familyHash(rf, seed) <= threshold {
+			keptReads++
+			bw.Write(r)
+		}
+	}
+	log.Printf("Kept %d of %d reads.\n", keptReads, totalReads)
+}
+
+// familyHash returns a deterministic pseudo-random value for a family ID. Families with hash values at
+// or below the selection threshold are retained.
+func familyHash(rf string, seed uint64) uint64 {
+	h := fnv.New64a()
+	_, err := h.Write([]byte(strconv.FormatUint(seed, 10) + ":" + rf))
+	exception.PanicOnErr(err)
+	return h.Sum64()
+}
+
+func fractionThreshold(fraction float64) uint64 {
+	if fraction >= 1 {
+		return math.MaxUint64
+	}
+	return uint64(fraction * math.MaxUint64)
+}
+
+// familyRecord is the subset of the family bed needed for selection.
+type familyRecord struct {
+	hash   uint64
+	duplex bool
+	b      bed.Bed
+}
+
+func readFamilies(bedFile string, seed uint64) []familyRecord {
+	var ans []familyRecord
+	var watson, crick int
+	families := bed.GoReadToChan(bedFile)
+	for b := range families {
+		if len(b.Annotation) < 2 {
+			exit.Fatalf(exit.MissingTag, "bed record is missing watson and crick read counts. Was it generated with annotateReadFamilies?\n%s", bed.ToString(b, b.FieldsInitialized))
+		}
+		watson, _ = strconv.Atoi(b.Annotation[0])
+		crick, _ = strconv.Atoi(b.Annotation[1])
+		ans = append(ans, familyRecord{hash: familyHash(b.Name, seed), duplex: watson > 0 && crick > 0, b: b})
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		return ans[i].hash < ans[j].hash
+	})
+	return ans
+}
+
+// duplexFamilies returns the duplex families of families, in the same order, and the
+// names of the others.
+func duplexFamilies(families []familyRecord) ([]familyRecord, map[string]bool) {
+	simplex := make(map[string]bool)
+	var ans []familyRecord
+	for _, f := range families {
+		if f.duplex {
+			ans = append(ans, f)
+		} else {
+			simplex[f.b.Name] = true
+		}
+	}
+	return ans, simplex
+}
+
+// familyCountThreshold returns the hash threshold that retains exactly numFamilies of
+// families, which are sorted by hash.
+func familyCountThreshold(families []familyRecord, numFamilies int) uint64 {
+	if numFamilies == 0 {
+		return 0
+	}
+	if numFamilies <= len(families) {
+		return families[numFamilies-1].hash
+	}
+	log.Printf("WARNING: requested %d families, but only %d are present. Keeping all families.\n", numFamilies, len(families))
+	return math.MaxUint64
+}
+
+// duplexDepthThreshold returns the hash threshold at which the mean duplex family depth over
+// the target regions reaches the requested depth. families are sorted by hash.
+func duplexDepthThreshold(families []familyRecord, targets string, duplexDepth float64) uint64 {
+	targetBeds := intervals.ReadMerged(targets)
+	targetSize := bed.TotalSize(targetBeds)
+	if targetSize == 0 {
+		log.Fatal("ERROR: targets bed file (-t) has no bases.")
+	}
+	tree := interval.BuildTree(interval.BedSliceToIntervals(targetBeds))
+
+	var coveredBases float64
+	var overlaps []interval.Interval
+	goal := duplexDepth * float64(targetSize)
+	if goal == 0 {
+		return 0
+	}
+	for _, f := range families {
+		if !f.duplex {
+			continue
+		}
+		overlaps = interval.Query(tree, f.b, "any")
+		for i := range overlaps {
+			coveredBases += float64(bed.OverlapLength(f.b, overlaps[i].(bed.Bed)))
+		}
+		if coveredBases >= goal {
+			return f.hash
+		}
+	}
+	log.Printf("WARNING: requested duplex depth of %g, but the maximum duplex depth is %g. Keeping all families.\n", duplexDepth, coveredBases/float64(targetSize))
+	return math.MaxUint64
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
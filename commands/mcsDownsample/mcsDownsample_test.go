@@ -0,0 +1,109 @@
+package mcsDownsample
+
+import (
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestFamilyCountThreshold(t *testing.T) {
+	families := []familyRecord{
+		{hash: 10, duplex: true, b: bed.Bed{Name: "a"}},
+		{hash: 20, duplex: false, b: bed.Bed{Name: "b"}},
+		{hash: 30, duplex: true, b: bed.Bed{Name: "c"}},
+		{hash: 40, duplex: false, b: bed.Bed{Name: "d"}},
+		{hash: 50, duplex: true, b: bed.Bed{Name: "e"}},
+	}
+	duplex, simplex := duplexFamilies(families)
+	if len(duplex) != 3 || len(simplex) != 2 || !simplex["b"] || !simplex["d"] {
+		t.Fatalf("expected families a, c, and e to be duplex, got %v and simplex %v", duplex, simplex)
+	}
+	tests := []struct {
+		families    []familyRecord
+		numFamilies int
+		expected    uint64
+	}{
+		{families, 0, 0}, // keep none
+		{families, 1, 10},
+		{families, 2, 20},
+		{families, 5, 50},
+		{families, 6, math.MaxUint64}, // more than present, keep all
+		{duplex, 2, 30},
+		{duplex, 3, 50},
+		{nil, 1, math.MaxUint64},
+	}
+	for _, test := range tests {
+		if actual := familyCountThreshold(test.families, test.numFamilies); actual != test.expected {
+			t.Errorf("expected a threshold of %d for %d of %d families, got %d", test.expected, test.numFamilies, len(test.families), actual)
+		}
+	}
+}
+
+func TestMcsDownsampleDuplexOnly(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.bam")
+	file, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := sam.NewBamWriter(file, sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 1000}}, nil, sam.Coordinate, sam.None))
+	for i, rf := range []string{"d1", "d1", "s1", "d2", "s2", "s2", "d2"} {
+		sam.WriteToBamFileHandle(w, sam.Sam{QName: "r" + string(rune('0'+i)), MapQ: 60, RName: "chr1", Pos: uint32(10 * (i + 1)), Cigar: cigar.FromString("4M"), RNext: "*",
+			Seq: dna.StringToBases("ACGT"), Qual: "IIII", Extra: "RF:Z:" + rf}, 0)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bedFile := filepath.Join(dir, "families.bed")
+	families := "chr1\t9\t100\td1\t0\t+\t1\t1\n" +
+		"chr1\t29\t100\ts1\t0\t+\t1\t0\n" +
+		"chr1\t39\t100\td2\t0\t+\t1\t1\n" +
+		"chr1\t49\t100\ts2\t0\t+\t0\t2\n"
+	if err = os.WriteFile(bedFile, []byte(families), 0644); err != nil {
+		t.Fatal(err)
+	}
+	targets := filepath.Join(dir, "targets.bed")
+	if err = os.WriteFile(targets, []byte("chr1\t0\t100\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "out.bam")
+	tests := []struct {
+		name        string
+		fraction    float64
+		numFamilies int
+		duplexDepth float64
+		duplexOnly  bool
+		expected    string
+	}{
+		{"fraction", 1, -1, -1, false, "d1,d1,d2,d2,s1,s2,s2"},
+		{"fraction duplexOnly", 1, -1, -1, true, "d1,d1,d2,d2"},
+		{"families duplexOnly", -1, 2, -1, true, "d1,d1,d2,d2"},
+		{"duplexDepth duplexOnly", -1, -1, 10, true, "d1,d1,d2,d2"}, // more than present, keep all duplex families
+	}
+	for _, test := range tests {
+		mcsDownsample(input, bedFile, targets, output, test.fraction, test.numFamilies, test.duplexDepth, test.duplexOnly, 1)
+		reads, _ := sam.GoReadToChan(output)
+		var kept []string
+		for r := range reads {
+			sam.ParseExtra(&r)
+			kept = append(kept, barcode.GetRF(&r))
+		}
+		sort.Strings(kept)
+		if actual := strings.Join(kept, ","); actual != test.expected {
+			t.Errorf("%s: expected reads of families %s, got %s", test.name, test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,427 @@
+package mcsErrorProfile
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/preflight"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsErrorProfile - Measure strand-specific error rates by substitution type and trinucleotide context from intra-family discordance.\n" +
+			"Two classes of error are reported for each read family with enough reads on both strands:\n" +
+			"\tsingleStrand: the consensus of one strand differs from the reference while the other strand matches the reference.\n" +
+			"\t\tThese errors arise before UMI attachment (e.g. DNA damage, end repair) and are removed by duplex consensus.\n" +
+			"\tread: a single read differs from the reference where both strand consensuses match the reference.\n" +
+			"\t\tThese errors arise after UMI attachment (e.g. PCR, sequencing) and are removed by single-strand consensus.\n" +
+			"Substitutions and contexts are reported in the orientation of the strand the error occurred on, so a C>T on\n" +
+			"the crick strand is a G>A relative to the reference. Sites where both strands disagree with the reference are ignored.\n" +
+			"Output (-o) has one line per class, strand, and substitution. -contextOut has one line per class, strand,\n" +
+			"trinucleotide context, and substitution (96 per strand when -collapse is set, otherwise 192).\n" +
+			"Usage:\n" +
+			"mcsErrorProfile [options] -i annotated.bam -r reference.fasta -o profile.tsv -contextOut contexts.tsv\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsErrorProfile with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input coordinate sorted bam file annotated with annotateReadFamilies.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai).")
+	output := flag.String("o", "stdout", "Output file with error rates per substitution type.")
+	contextOut := flag.String("contextOut", "", "Output file with error rates per substitution type and trinucleotide context.")
+	sample := flag.String("sample", "", "Sample name to report in output. Defaults to the input bam file name.")
+	minStrandReads := flag.Int("minStrandReads", 3, "Minimum number of reads on each of the watson and crick strands for a family to be used.")
+	minAgreement := flag.Float64("minAgreement", 0.7, "Minimum fraction of reads on a strand supporting the strand consensus base.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality for a read to be used.")
+	minBaseQuality := flag.Int("minBaseQuality", 30, "Input bases below this quality are ignored.")
+	maxFamilySpan := flag.Int("maxFamilySpan", 10000, "Families spanning more than this many bases of the reference are skipped.")
+	collapse := flag.Bool("collapse", false, "Report substitutions from a purine reference base as their pyrimidine complement (e.g. G>A as C>T).")
+	dryrun.Parse()
+
+	if *input == "" || *ref == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i) and reference (-r).")
+	}
+
+	if *minAgreement <= 0.5 || *minAgreement > 1 {
+		usage()
+		log.Fatal("ERROR: -minAgreement must be greater than 0.5 and at most 1.")
+	}
+
+	if *sample == "" {
+		*sample = strings.TrimSuffix(filepath.Base(*input), ".bam")
+	}
+
+	p := profileParams{
+		minStrandReads: *minStrandReads,
+		minAgreement:   *minAgreement,
+		minMapQ:        uint8(*minMapQ),
+		minBaseQuality: uint8(*minBaseQuality),
+		maxFamilySpan:  *maxFamilySpan,
+	}
+
+	mcsErrorProfile(*input, *ref, *output, *contextOut, *sample, *collapse, p)
+}
+
+// profileParams stores the settings used to select families and consensus bases.
+type profileParams struct {
+	minStrandReads int
+	minAgreement   float64
+	minMapQ        uint8
+	minBaseQuality uint8
+	maxFamilySpan  int
+}
+
+// readFamily stores the reads from a single family split by strand.
+type readFamily struct {
+	start int // 0-based, inclusive
+	end   int // 0-based, exclusive
+	reads [2][]sam.Sam
+}
+
+const (
+	singleStrandError = iota
+	readError
+)
+
+var classNames = []string{"singleStrand", "read"}
+var strandNames = []string{"W", "C"}
+
+// profile stores error and opportunity counts by class, strand, and trinucleotide context.
+// Contexts are indexed as 16*left + 4*middle + right in the orientation of the strand.
+type profile struct {
+	errors        [2][2][64][4]int // class, strand, context, alt base
+	opportunities [2][2][64]int    // class, strand, context
+}
+
+func mcsErrorProfile(input, ref, output, contextOut, sample string, collapse bool, p profileParams) {
+	required := preflight.Bam{Sorted: true, Tags: []string{"RF", "RS"}, Qualities: true}
+	required.Check(input)
+	reads, header := hts.GoReadToChan(input)
+	required.CheckHeader(input, header)
+	faSeeker := fai.NewSeeker(ref)
+	defer cleanup(faSeeker)
+	idx := fai.ReadIndex(ref + ".fai")
+
+	prof := new(profile)
+	m := make(map[string]*readFamily)
+	var chrSeq []dna.Base
+	var f *readFamily
+	var rf, chr string
+	var rs byte
+	var strand, readCount, familyCount int
+	var err error
+	for r := range reads {
+		if sam.IsUnmapped(r) || sam.IsNotPrimaryAlign(r) || sam.IsSupplementaryAlign(r) || r.MapQ < p.minMapQ {
+			continue
+		}
+		sam.ParseExtra(&r)
+		rf = barcode.GetRF(&r)
+		rs = barcode.GetRS(&r)
+		if rf == "" || rf == "0" || (rs != 'W' && rs != 'C') { // RF:Z:0 collects reads not assigned to a family
+			continue
+		}
+		readCount++
+
+		if r.RName != chr {
+			for k := range m {
+				familyCount += addFamily(prof, m[k], chrSeq, p)
+				delete(m, k)
+			}
+			chr = r.RName
+			chrSeq, err = faSeeker.SeekByName(chr, 0, idx.Size(chr))
+			exception.PanicOnErr(err)
+			dna.AllToUpper(chrSeq)
+		}
+
+		f = m[rf]
+		if f == nil {
+			f = &readFamily{start: r.GetChromStart(), end: r.GetChromEnd()}
+			m[rf] = f
+		}
+		f.start = min(f.start, r.GetChromStart())
+		f.end = max(f.end, r.GetChromEnd())
+		strand = 0
+		if rs == 'C' {
+			strand = 1
+		}
+		f.reads[strand] = append(f.reads[strand], r)
+
+		if readCount%10000 == 0 { // process families at least 10kb behind the current read
+			for k := range m {
+				if m[k].end < r.GetChromStart()-10000 {
+					familyCount += addFamily(prof, m[k], chrSeq, p)
+					delete(m, k)
+				}
+			}
+		}
+	}
+	for k := range m {
+		familyCount += addFamily(prof, m[k], chrSeq, p)
+	}
+	log.Printf("Profiled %d read families.\n", familyCount)
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	writeSubstitutions(out, prof, sample, collapse)
+
+	if contextOut != "" {
+		ctxOut := fileio.EasyCreate(contextOut)
+		defer cleanup(ctxOut)
+		writeContexts(ctxOut, prof, sample, collapse)
+	}
+}
+
+// addFamily adds the errors and opportunities from a single family to the profile.
+// Returns 1 if the family was used, otherwise 0.
+func addFamily(prof *profile, f *readFamily, chrSeq []dna.Base, p profileParams) int {
+	if len(f.reads[0]) < p.minStrandReads || len(f.reads[1]) < p.minStrandReads {
+		return 0
+	}
+	if f.end-f.start > p.maxFamilySpan || f.end+1 > len(chrSeq) {
+		return 0
+	}
+
+	var counts [2][][4]int
+	var cons [2][]dna.Base
+	for s := range counts {
+		counts[s] = make([][4]int, f.end-f.start)
+		for _, r := range f.reads[s] {
+			eachBase(r, p.minBaseQuality, func(refPos int, b dna.Base) {
+				counts[s][refPos-f.start][b]++
+			})
+		}
+		cons[s] = strandConsensus(counts[s], p)
+	}
+
+	// single-strand errors
+	var ctx int
+	var refBase dna.Base
+	for i := range cons[0] {
+		refBase = chrSeq[f.start+i]
+		if cons[0][i] > dna.T || cons[1][i] > dna.T || refBase > dna.T {
+			continue
+		}
+		for s := range cons {
+			if cons[1-s][i] != refBase { // other strand must match reference
+				continue
+			}
+			ctx = context(chrSeq, f.start+i, s)
+			if ctx == -1 {
+				continue
+			}
+			prof.opportunities[singleStrandError][s][ctx]++
+			if cons[s][i] != refBase {
+				prof.errors[singleStrandError][s][ctx][strandBase(cons[s][i], s)]++
+			}
+		}
+	}
+
+	// read errors
+	for s := range f.reads {
+		for _, r := range f.reads[s] {
+			eachBase(r, p.minBaseQuality, func(refPos int, b dna.Base) {
+				refBase = chrSeq[refPos]
+				if cons[0][refPos-f.start] != refBase || cons[1][refPos-f.start] != refBase {
+					return
+				}
+				ctx = context(chrSeq, refPos, s)
+				if ctx == -1 {
+					return
+				}
+				prof.opportunities[readError][s][ctx]++
+				if b != refBase {
+					prof.errors[readError][s][ctx][strandBase(b, s)]++
+				}
+			})
+		}
+	}
+	return 1
+}
+
+// eachBase calls fn with the 0-based reference position and base of each aligned read base passing the quality threshold.
+func eachBase(r sam.Sam, minBaseQuality uint8, fn func(refPos int, b dna.Base)) {
+	refPos := r.GetChromStart()
+	var queryPos, k int
+	var b dna.Base
+	for _, c := range r.Cigar {
+		switch c.Op {
+		case 'M', '=', 'X':
+			for k = 0; k < c.RunLength; k++ {
+				b = dna.ToUpper(r.Seq[queryPos+k])
+				if r.Qual[queryPos+k]-33 >= minBaseQuality && b <= dna.T {
+					fn(refPos+k, b)
+				}
+			}
+			refPos += c.RunLength
+			queryPos += c.RunLength
+		case 'D', 'N':
+			refPos += c.RunLength
+		case 'I', 'S':
+			queryPos += c.RunLength
+		}
+	}
+}
+
+// strandConsensus returns the majority base at each position, or N if the majority does not meet the thresholds.
+func strandConsensus(counts [][4]int, p profileParams) []dna.Base {
+	ans := make([]dna.Base, len(counts))
+	var total, best int
+	for i := range counts {
+		ans[i] = dna.N
+		total, best = 0, 0
+		for b := range counts[i] {
+			total += counts[i][b]
+			if counts[i][b] > counts[i][best] {
+				best = b
+			}
+		}
+		if total >= p.minStrandReads && float64(counts[i][best]) >= p.minAgreement*float64(total) {
+			ans[i] = dna.Base(best)
+		}
+	}
+	return ans
+}
+
+// context returns the index of the trinucleotide centered on pos in the orientation of strand s, or -1 if undefined.
+func context(chrSeq []dna.Base, pos int, s int) int {
+	if pos == 0 || pos+1 >= len(chrSeq) {
+		return -1
+	}
+	left, mid, right := chrSeq[pos-1], chrSeq[pos], chrSeq[pos+1]
+	if left > dna.T || mid > dna.T || right > dna.T {
+		return -1
+	}
+	if s == 1 {
+		left, right = dna.ComplementSingleBase(right), dna.ComplementSingleBase(left)
+		mid = dna.ComplementSingleBase(mid)
+	}
+	return int(left)*16 + int(mid)*4 + int(right)
+}
+
+// strandBase returns the base in the orientation of strand s.
+func strandBase(b dna.Base, s int) dna.Base {
+	if s == 1 {
+		return dna.ComplementSingleBase(b)
+	}
+	return b
+}
+
+// contextKey returns the trinucleotide context and substitution, collapsed to a pyrimidine reference if requested.
+func contextKey(ctx int, alt dna.Base, collapse bool) (string, string) {
+	trinuc := []dna.Base{dna.Base(ctx / 16), dna.Base(ctx / 4 % 4), dna.Base(ctx % 4)}
+	if collapse && (trinuc[1] == dna.A || trinuc[1] == dna.G) {
+		dna.ReverseComplement(trinuc)
+		alt = dna.ComplementSingleBase(alt)
+	}
+	return dna.BasesToString(trinuc), dna.BaseToString(trinuc[1]) + ">" + dna.BaseToString(alt)
+}
+
+func writeSubstitutions(out io.Writer, prof *profile, sample string, collapse bool) {
+	_, err := fmt.Fprintln(out, "Sample\tClass\tStrand\tSubstitution\tErrors\tOpportunities\tRate")
+	exception.PanicOnErr(err)
+	var errors, opportunities map[string]int
+	var keys []string
+	var sub string
+	for class := range prof.errors {
+		for s := range prof.errors[class] {
+			errors, opportunities = make(map[string]int), make(map[string]int)
+			keys = keys[:0]
+			for ctx := range prof.errors[class][s] {
+				for alt := dna.A; alt <= dna.T; alt++ {
+					if int(alt) == ctx/4%4 {
+						continue
+					}
+					_, sub = contextKey(ctx, alt, collapse)
+					if _, found := opportunities[sub]; !found {
+						keys = append(keys, sub)
+					}
+					errors[sub] += prof.errors[class][s][ctx][alt]
+					opportunities[sub] += prof.opportunities[class][s][ctx]
+				}
+			}
+			sort.Strings(keys)
+			for _, sub = range keys {
+				_, err = fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%d\t%d\t%.4g\n", sample, classNames[class], strandNames[s], sub, errors[sub], opportunities[sub], rate(errors[sub], opportunities[sub]))
+				exception.PanicOnErr(err)
+			}
+		}
+	}
+}
+
+func writeContexts(out io.Writer, prof *profile, sample string, collapse bool) {
+	_, err := fmt.Fprintln(out, "Sample\tClass\tStrand\tContext\tSubstitution\tErrors\tOpportunities\tRate")
+	exception.PanicOnErr(err)
+	var errors, opportunities map[string]int
+	var keys []string
+	var trinuc, sub, key string
+	for class := range prof.errors {
+		for s := range prof.errors[class] {
+			errors, opportunities = make(map[string]int), make(map[string]int)
+			keys = keys[:0]
+			for ctx := range prof.errors[class][s] {
+				for alt := dna.A; alt <= dna.T; alt++ {
+					if int(alt) == ctx/4%4 {
+						continue
+					}
+					trinuc, sub = contextKey(ctx, alt, collapse)
+					key = trinuc + "\t" + sub
+					if _, found := opportunities[key]; !found {
+						keys = append(keys, key)
+					}
+					errors[key] += prof.errors[class][s][ctx][alt]
+					opportunities[key] += prof.opportunities[class][s][ctx]
+				}
+			}
+			sort.Slice(keys, func(i, j int) bool { // by substitution, then context
+				if keys[i][4:] != keys[j][4:] {
+					return keys[i][4:] < keys[j][4:]
+				}
+				return keys[i][:3] < keys[j][:3]
+			})
+			for _, key = range keys {
+				_, err = fmt.Fprintf(out, "%s\t%s\t%s\t%s\t%d\t%d\t%.4g\n", sample, classNames[class], strandNames[s], key, errors[key], opportunities[key], rate(errors[key], opportunities[key]))
+				exception.PanicOnErr(err)
+			}
+		}
+	}
+}
+
+func rate(errors, opportunities int) float64 {
+	if opportunities == 0 {
+		return 0
+	}
+	return float64(errors) / float64(opportunities)
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
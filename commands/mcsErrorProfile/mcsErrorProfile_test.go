@@ -0,0 +1,112 @@
+package mcsErrorProfile
+
+import (
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"reflect"
+	"testing"
+)
+
+func TestStrandConsensus(t *testing.T) {
+	p := profileParams{minStrandReads: 2, minAgreement: 0.6}
+	counts := [][4]int{
+		{3, 0, 0, 0}, // unanimous
+		{0, 2, 1, 0}, // 2 of 3 agree
+		{0, 1, 1, 1}, // no majority
+		{0, 0, 0, 1}, // too few reads
+	}
+	expected := []dna.Base{dna.A, dna.C, dna.N, dna.N}
+	if actual := strandConsensus(counts, p); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestContext(t *testing.T) {
+	chrSeq := dna.StringToBases("ACGNTA")
+	tests := []struct {
+		pos, strand int
+		expected    int
+	}{
+		{1, 0, 16*int(dna.A) + 4*int(dna.C) + int(dna.G)},
+		{1, 1, 16*int(dna.C) + 4*int(dna.G) + int(dna.T)}, // reverse complement of ACG
+		{0, 0, -1}, // no left base
+		{5, 0, -1}, // no right base
+		{2, 0, -1}, // N on the right
+	}
+	for _, test := range tests {
+		if actual := context(chrSeq, test.pos, test.strand); actual != test.expected {
+			t.Errorf("expected context %d at %d on strand %d, got %d", test.expected, test.pos, test.strand, actual)
+		}
+	}
+}
+
+func TestContextKey(t *testing.T) {
+	tests := []struct {
+		ctx         int
+		alt         dna.Base
+		collapse    bool
+		expectedCtx string
+		expectedSub string
+	}{
+		{16*int(dna.A) + 4*int(dna.C) + int(dna.G), dna.T, false, "ACG", "C>T"},
+		{16*int(dna.A) + 4*int(dna.C) + int(dna.G), dna.T, true, "ACG", "C>T"},
+		{16*int(dna.C) + 4*int(dna.G) + int(dna.T), dna.A, false, "CGT", "G>A"},
+		{16*int(dna.C) + 4*int(dna.G) + int(dna.T), dna.A, true, "ACG", "C>T"}, // collapsed to the pyrimidine strand
+	}
+	for _, test := range tests {
+		ctx, sub := contextKey(test.ctx, test.alt, test.collapse)
+		if ctx != test.expectedCtx || sub != test.expectedSub {
+			t.Errorf("expected %s %s for %d>%s collapsed %t, got %s %s", test.expectedCtx, test.expectedSub, test.ctx, dna.BaseToString(test.alt), test.collapse, ctx, sub)
+		}
+	}
+}
+
+func TestAddFamily(t *testing.T) {
+	//                         0123456789
+	chrSeq := dna.StringToBases("ACGTACGTAC")
+	p := profileParams{minStrandReads: 2, minAgreement: 0.6, maxFamilySpan: 100}
+	read := func(seq string) sam.Sam {
+		return sam.Sam{Pos: 2, Cigar: cigar.FromString("7M"), Seq: dna.StringToBases(seq), Qual: "IIIIIII"}
+	}
+	f := &readFamily{start: 1, end: 8}
+	f.reads[0] = []sam.Sam{read("CATACGT"), read("CATACGT"), read("CATATGT")} // G>A at 2 on watson, and C>T at 5 in one read
+	f.reads[1] = []sam.Sam{read("CGTACGT"), read("CGTACGT")}
+
+	prof := new(profile)
+	if addFamily(prof, f, chrSeq, p) != 1 {
+		t.Fatal("expected the family to be profiled")
+	}
+	sum := func(opportunities [64]int) int {
+		var ans int
+		for _, n := range opportunities {
+			ans += n
+		}
+		return ans
+	}
+	tests := []struct {
+		class, strand int
+		expected      int
+	}{
+		{singleStrandError, 0, 7}, // the crick consensus matches the reference at 1-7
+		{singleStrandError, 1, 6}, // except where the watson consensus differs at 2
+		{readError, 0, 18},        // 3 reads at the 6 positions where both strands match
+		{readError, 1, 12},        // 2 reads at the same positions
+	}
+	for _, test := range tests {
+		if actual := sum(prof.opportunities[test.class][test.strand]); actual != test.expected {
+			t.Errorf("expected %d %s opportunities on %s, got %d", test.expected, classNames[test.class], strandNames[test.strand], actual)
+		}
+	}
+	var expected [2][2][64][4]int
+	expected[singleStrandError][0][context(chrSeq, 2, 0)][dna.A] = 1
+	expected[readError][0][context(chrSeq, 5, 0)][dna.T] = 1
+	if prof.errors != expected {
+		t.Errorf("expected a single-strand G>A in CGT and a read C>T in ACG only")
+	}
+
+	f.reads[1] = f.reads[1][:1]
+	if addFamily(prof, f, chrSeq, p) != 0 {
+		t.Error("expected a family with one crick read to be skipped")
+	}
+}
@@ -0,0 +1,407 @@
+package mcsFingerprint
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsFingerprint - Genotype a panel of common SNPs in bam and vcf files and compare genotypes between all pairs of inputs to detect sample swaps.\n" +
+			"For bam files annotated with annotateReadFamilies, each read family where the watson and crick strands agree counts as one\n" +
+			"observation. Reads without family tags (e.g. bulk or mcsConsensus bams) each count as one observation. Bam files must be indexed (.bai).\n" +
+			"For vcf files, the genotype of the first sample is used and panel SNPs missing from the vcf are treated as not genotyped.\n" +
+			"Pairs are compared at SNPs genotyped in both inputs. Because single-cell amplification often drops one allele of a\n" +
+			"heterozygous SNP, samples are matched on the fraction of SNPs with opposite homozygous genotypes (0/0 vs 1/1) rather\n" +
+			"than overall concordance, which is reported for reference.\n" +
+			"Usage:\n" +
+			"mcsFingerprint [options] -s panel.vcf -i bulk.bam -i cell1.bam -i cell2.vcf > fingerprint.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsFingerprint with the options in os.Args.
+func Main() {
+	var inputs inputFiles
+	flag.Var(&inputs, "i", "Input bam or vcf file. Must be declared at least twice.")
+	panel := flag.String("s", "", "VCF file of SNPs to genotype. Only biallelic SNVs are used.")
+	output := flag.String("o", "stdout", "Output file with the comparison of each pair of inputs.")
+	genotypesOut := flag.String("genotypesOut", "", "Output file with the genotype of each input at each SNP.")
+	minDepth := flag.Int("minDepth", 5, "Minimum number of observations (families or reads) to genotype a SNP in a bam file.")
+	homThreshold := flag.Float64("homThreshold", 0.1, "SNPs with a minor allele fraction below this value are called homozygous.")
+	minStrandedDepth := flag.Int("minStrandedDepth", 1, "Minimum number of reads on each of the watson and crick strands of a family.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality for a read to be considered.")
+	minBaseQuality := flag.Int("minBaseQuality", 20, "Minimum base quality for a base to be considered.")
+	minSites := flag.Int("minSites", 20, "Minimum number of SNPs genotyped in both inputs to compare a pair.")
+	maxOppositeHom := flag.Float64("maxOppositeHom", 0.05, "Maximum fraction of shared SNPs with opposite homozygous genotypes for a pair to be called a match.")
+	dryrun.Parse()
+
+	if len(inputs) < 2 || *panel == "" {
+		usage()
+		log.Fatal("ERROR: must specify a SNP panel (-s) and at least two inputs (-i).")
+	}
+
+	if *homThreshold <= 0 || *homThreshold >= 0.5 {
+		usage()
+		log.Fatal("ERROR: -homThreshold must be between 0 and 0.5.")
+	}
+
+	p := genotypeParams{
+		minDepth:         *minDepth,
+		homThreshold:     *homThreshold,
+		minStrandedDepth: *minStrandedDepth,
+		minMapQ:          uint8(*minMapQ),
+		minBaseQuality:   uint8(*minBaseQuality),
+	}
+
+	mcsFingerprint(inputs, *panel, *output, *genotypesOut, *minSites, *maxOppositeHom, p)
+}
+
+// genotypeParams stores the settings used to genotype SNPs from reads.
+type genotypeParams struct {
+	minDepth         int
+	homThreshold     float64
+	minStrandedDepth int
+	minMapQ          uint8
+	minBaseQuality   uint8
+}
+
+// snp is a single site in the panel.
+type snp struct {
+	chr string
+	pos int // 1-based
+	ref dna.Base
+	alt dna.Base
+}
+
+// noCall marks a SNP that could not be genotyped. Other genotypes are stored as the number of alt alleles.
+const noCall int8 = -1
+
+func mcsFingerprint(inputs []string, panel, output, genotypesOut string, minSites int, maxOppositeHom float64, p genotypeParams) {
+	snps := readPanel(panel)
+	log.Printf("Read %d SNPs from panel.\n", len(snps))
+
+	names := make([]string, len(inputs))
+	genotypes := make([][]int8, len(inputs))
+	var called int
+	for i := range inputs {
+		names[i] = sampleName(inputs[i])
+		if isVcf(inputs[i]) {
+			genotypes[i] = vcfGenotypes(inputs[i], snps)
+		} else {
+			genotypes[i] = bamGenotypes(inputs[i], snps, p)
+		}
+		called = 0
+		for _, gt := range genotypes[i] {
+			if gt != noCall {
+				called++
+			}
+		}
+		log.Printf("Genotyped %d SNPs in %s.\n", called, names[i])
+	}
+
+	if genotypesOut != "" {
+		writeGenotypes(genotypesOut, snps, names, genotypes)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "SampleA\tSampleB\tSharedSites\tConcordant\tConcordance\tOppositeHom\tOppositeHomFraction\tStatus")
+	exception.PanicOnErr(err)
+	var shared, concordant, oppositeHom int
+	var concordance, oppositeFrac float64
+	var status string
+	for i := range genotypes {
+		for j := i + 1; j < len(genotypes); j++ {
+			shared, concordant, oppositeHom = compare(genotypes[i], genotypes[j])
+			concordance, oppositeFrac = 0, 0
+			if shared > 0 {
+				concordance = float64(concordant) / float64(shared)
+				oppositeFrac = float64(oppositeHom) / float64(shared)
+			}
+			switch {
+			case shared < minSites:
+				status = "INCONCLUSIVE"
+			case oppositeFrac <= maxOppositeHom:
+				status = "MATCH"
+			default:
+				status = "MISMATCH"
+			}
+			_, err = fmt.Fprintf(out, "%s\t%s\t%d\t%d\t%.4f\t%d\t%.4f\t%s\n", names[i], names[j], shared, concordant, concordance, oppositeHom, oppositeFrac, status)
+			exception.PanicOnErr(err)
+		}
+	}
+}
+
+// readPanel returns the biallelic SNVs in a vcf file.
+func readPanel(file string) []snp {
+	var ans []snp
+	records, _ := vcf.GoReadToChan(file)
+	for v := range records {
+		if len(v.Ref) != 1 || len(v.Alt) != 1 || len(v.Alt[0]) != 1 {
+			continue
+		}
+		ans = append(ans, snp{chr: v.Chr, pos: v.Pos, ref: dna.StringToBase(strings.ToUpper(v.Ref)), alt: dna.StringToBase(strings.ToUpper(v.Alt[0]))})
+	}
+	return ans
+}
+
+// bamGenotypes calls the genotype at each SNP from allele counts in an indexed bam file.
+func bamGenotypes(file string, snps []snp, p genotypeParams) []int8 {
+	br, _ := sam.OpenBam(file)
+	defer cleanup(br)
+	idx := bai.Read(file)
+	ans := make([]int8, len(snps))
+	var reads []sam.Sam
+	var refCount, altCount int
+	var altFrac float64
+	for i, s := range snps {
+		ans[i] = noCall
+		reads = sam.SeekBamRegionRecycle(br, idx, s.chr, uint32(s.pos-1), uint32(s.pos), reads)
+		refCount, altCount = alleleCounts(reads, s, p)
+		if refCount+altCount < p.minDepth {
+			continue
+		}
+		altFrac = float64(altCount) / float64(refCount+altCount)
+		switch {
+		case altFrac < p.homThreshold:
+			ans[i] = 0
+		case altFrac > 1-p.homThreshold:
+			ans[i] = 2
+		default:
+			ans[i] = 1
+		}
+	}
+	return ans
+}
+
+// alleleCounts returns the number of observations of the ref and alt alleles at a SNP. Reads from the same
+// family are combined into a single observation if both strands agree. Reads without family tags are counted individually.
+func alleleCounts(reads []sam.Sam, s snp, p genotypeParams) (refCount, altCount int) {
+	type strandCounts struct {
+		watson [4]int
+		crick  [4]int
+	}
+	families := make(map[string]*strandCounts)
+	var rf string
+	var rs byte
+	var b dna.Base
+	var q uint8
+	var ok bool
+	var c *strandCounts
+	for i := range reads {
+		if reads[i].MapQ < p.minMapQ || sam.IsUnmapped(reads[i]) || sam.IsNotPrimaryAlign(reads[i]) || sam.IsSupplementaryAlign(reads[i]) {
+			continue
+		}
+		b, q, ok = baseAtPos(reads[i], s.pos)
+		if !ok || q < p.minBaseQuality || b > dna.T {
+			continue
+		}
+		sam.ParseExtra(&reads[i])
+		rf = barcode.GetRF(&reads[i])
+		rs = barcode.GetRS(&reads[i])
+		if rf == "" || (rs != 'W' && rs != 'C') {
+			switch b {
+			case s.ref:
+				refCount++
+			case s.alt:
+				altCount++
+			}
+			continue
+		}
+		if c = families[rf]; c == nil {
+			c = new(strandCounts)
+			families[rf] = c
+		}
+		if rs == 'W' {
+			c.watson[b]++
+		} else {
+			c.crick[b]++
+		}
+	}
+
+	var wBase, cBase dna.Base
+	var wOk, cOk bool
+	for _, c = range families {
+		wBase, wOk = majorityBase(c.watson, p.minStrandedDepth)
+		cBase, cOk = majorityBase(c.crick, p.minStrandedDepth)
+		if !wOk || !cOk || wBase != cBase {
+			continue
+		}
+		switch wBase {
+		case s.ref:
+			refCount++
+		case s.alt:
+			altCount++
+		}
+	}
+	return
+}
+
+// vcfGenotypes returns the genotype of the first sample in a vcf file at each SNP.
+func vcfGenotypes(file string, snps []snp) []int8 {
+	idx := make(map[string]int, len(snps))
+	for i, s := range snps {
+		idx[snpKey(s.chr, s.pos)] = i
+	}
+	ans := make([]int8, len(snps))
+	for i := range ans {
+		ans[i] = noCall
+	}
+	records, _ := vcf.GoReadToChan(file)
+	var i, altIdx int
+	var found bool
+	var alt dna.Base
+	var gt int8
+	for v := range records {
+		if i, found = idx[snpKey(v.Chr, v.Pos)]; !found || len(v.Samples) == 0 || len(v.Samples[0].Alleles) == 0 || len(v.Ref) != 1 {
+			continue
+		}
+		if dna.StringToBase(strings.ToUpper(v.Ref)) != snps[i].ref {
+			continue
+		}
+		altIdx = 0
+		for j := range v.Alt {
+			if alt = dna.StringToBase(strings.ToUpper(v.Alt[j])); len(v.Alt[j]) == 1 && alt == snps[i].alt {
+				altIdx = j + 1
+			}
+		}
+		gt = 0
+		for _, a := range v.Samples[0].Alleles {
+			switch {
+			case a < 0: // missing allele
+				gt = noCall
+			case gt != noCall && int(a) == altIdx && altIdx != 0:
+				gt++
+			case gt != noCall && a != 0: // allele other than ref or the panel alt
+				gt = noCall
+			}
+		}
+		if len(v.Samples[0].Alleles) == 1 && gt == 1 { // haploid call
+			gt = 2
+		}
+		ans[i] = gt
+	}
+	return ans
+}
+
+// compare returns the number of SNPs genotyped in both inputs, the number with identical genotypes, and the number with opposite homozygous genotypes.
+func compare(a, b []int8) (shared, concordant, oppositeHom int) {
+	for i := range a {
+		if a[i] == noCall || b[i] == noCall {
+			continue
+		}
+		shared++
+		if a[i] == b[i] {
+			concordant++
+		}
+		if (a[i] == 0 && b[i] == 2) || (a[i] == 2 && b[i] == 0) {
+			oppositeHom++
+		}
+	}
+	return
+}
+
+func writeGenotypes(file string, snps []snp, names []string, genotypes [][]int8) {
+	out := fileio.EasyCreate(file)
+	defer cleanup(out)
+	_, err := fmt.Fprintf(out, "#CHROM\tPOS\tREF\tALT\t%s\n", strings.Join(names, "\t"))
+	exception.PanicOnErr(err)
+	gtStrings := map[int8]string{noCall: "./.", 0: "0/0", 1: "0/1", 2: "1/1"}
+	words := make([]string, len(names))
+	for i, s := range snps {
+		for j := range genotypes {
+			words[j] = gtStrings[genotypes[j][i]]
+		}
+		_, err = fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%s\n", s.chr, s.pos, dna.BaseToString(s.ref), dna.BaseToString(s.alt), strings.Join(words, "\t"))
+		exception.PanicOnErr(err)
+	}
+}
+
+// majorityBase returns the base supported by more than half of the reads on a strand.
+func majorityBase(counts [4]int, minDepth int) (dna.Base, bool) {
+	var depth, best int
+	for i := range counts {
+		depth += counts[i]
+		if counts[i] > counts[best] {
+			best = i
+		}
+	}
+	if depth < minDepth || counts[best]*2 <= depth {
+		return dna.N, false
+	}
+	return dna.Base(best), true
+}
+
+// baseAtPos returns the base and quality aligned to the 1-based reference position pos.
+func baseAtPos(r sam.Sam, pos int) (dna.Base, uint8, bool) {
+	refPos := int(r.Pos)
+	var queryPos int
+	for _, c := range r.Cigar {
+		switch c.Op {
+		case 'M', '=', 'X':
+			if pos < refPos+c.RunLength {
+				if pos < refPos {
+					return dna.N, 0, false
+				}
+				return dna.ToUpper(r.Seq[queryPos+pos-refPos]), r.Qual[queryPos+pos-refPos] - 33, true
+			}
+			refPos += c.RunLength
+			queryPos += c.RunLength
+		case 'D', 'N':
+			if pos < refPos+c.RunLength {
+				return dna.N, 0, false
+			}
+			refPos += c.RunLength
+		case 'I', 'S':
+			queryPos += c.RunLength
+		}
+	}
+	return dna.N, 0, false
+}
+
+func snpKey(chr string, pos int) string {
+	return fmt.Sprintf("%s:%d", chr, pos)
+}
+
+func isVcf(file string) bool {
+	return strings.HasSuffix(file, ".vcf") || strings.HasSuffix(file, ".vcf.gz")
+}
+
+func sampleName(file string) string {
+	name := filepath.Base(file)
+	for _, suffix := range []string{".gz", ".vcf", ".bam"} {
+		name = strings.TrimSuffix(name, suffix)
+	}
+	return name
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,128 @@
+package mcsFingerprint
+
+import (
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const vcfHeader = "##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\tsample\n"
+
+func writeFile(t *testing.T, filename, data string) string {
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func TestMcsFingerprint(t *testing.T) {
+	dir := t.TempDir()
+	record := func(pos, ref, alt, gt string) string {
+		return "chr1\t" + pos + "\t.\t" + ref + "\t" + alt + "\t.\tPASS\t.\tGT\t" + gt + "\n"
+	}
+	panel := writeFile(t, filepath.Join(dir, "panel.vcf"), vcfHeader+
+		record("10", "A", "G", "0/1")+record("20", "C", "T", "0/1")+record("30", "G", "A", "0/1")+
+		record("40", "T", "C", "0/1")+record("50", "AT", "A", "0/1")+record("60", "G", "C", "0/1"))
+	a := writeFile(t, filepath.Join(dir, "a.vcf"), vcfHeader+
+		record("10", "A", "G", "0/1")+record("20", "C", "T", "1/1")+record("30", "G", "A", "0/0")+
+		record("40", "T", "C", "./.")+record("60", "G", "T", "0/1")) // an alt other than the panel's is not called
+	b := writeFile(t, filepath.Join(dir, "b.vcf"), vcfHeader+
+		record("10", "A", "G", "0/1")+record("20", "C", "T", "0/0")+record("30", "G", "A", "0/0")+record("40", "T", "C", "1/1"))
+	c := writeFile(t, filepath.Join(dir, "c.vcf"), vcfHeader+
+		record("10", "A", "G", "0/1")+record("20", "C", "T", "1/1")+record("30", "G", "A", "0/0")+record("40", "T", "C", "1")) // haploid
+	output, genotypes := filepath.Join(dir, "out.tsv"), filepath.Join(dir, "genotypes.tsv")
+
+	mcsFingerprint([]string{a, b, c}, panel, output, genotypes, 3, 0.1, genotypeParams{})
+	expected := "SampleA\tSampleB\tSharedSites\tConcordant\tConcordance\tOppositeHom\tOppositeHomFraction\tStatus\n" +
+		"a\tb\t3\t2\t0.6667\t1\t0.3333\tMISMATCH\n" +
+		"a\tc\t3\t3\t1.0000\t0\t0.0000\tMATCH\n" +
+		"b\tc\t4\t3\t0.7500\t1\t0.2500\tMISMATCH\n"
+	if actual, _ := os.ReadFile(output); string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+	expected = "#CHROM\tPOS\tREF\tALT\ta\tb\tc\n" +
+		"chr1\t10\tA\tG\t0/1\t0/1\t0/1\n" +
+		"chr1\t20\tC\tT\t1/1\t0/0\t1/1\n" +
+		"chr1\t30\tG\tA\t0/0\t0/0\t0/0\n" +
+		"chr1\t40\tT\tC\t./.\t1/1\t1/1\n" +
+		"chr1\t60\tG\tC\t./.\t./.\t./.\n"
+	if actual, _ := os.ReadFile(genotypes); string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestBamGenotypes(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.bam")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 100}}, nil, sam.Coordinate, sam.None)
+	read := func(name string, pos uint32, mapQ uint8, seq, qual, tags string) sam.Sam { // reads are told apart by name when seeking
+		return sam.Sam{QName: name, MapQ: mapQ, RName: "chr1", Pos: pos, Cigar: cigar.FromString("10M"), RNext: "*",
+			Seq: dna.StringToBases(seq), Qual: qual, Extra: tags}
+	}
+	const ref, alt, snv, lowQual = "AAAAAAAAAA", "AAAAGAAAAA", "CCCCTCCCCC", "IIII#IIIII"
+	w := sam.NewBamWriter(file, header)
+	for _, r := range []sam.Sam{
+		read("a", 1, 60, alt, "IIIIIIIIII", "RS:Z:W\tRF:Z:1"), // family 1 supports the alt on both strands
+		read("b", 1, 60, alt, "IIIIIIIIII", "RS:Z:W\tRF:Z:1"),
+		read("c", 1, 60, alt, "IIIIIIIIII", "RS:Z:C\tRF:Z:1"),
+		read("d", 1, 60, alt, "IIIIIIIIII", "RS:Z:C\tRF:Z:1"),
+		read("e", 1, 60, ref, "IIIIIIIIII", "RS:Z:W\tRF:Z:2"), // family 2 supports the ref
+		read("f", 1, 60, ref, "IIIIIIIIII", "RS:Z:C\tRF:Z:2"),
+		read("g", 1, 60, alt, "IIIIIIIIII", "RS:Z:W\tRF:Z:3"), // the strands of family 3 disagree
+		read("h", 1, 60, ref, "IIIIIIIIII", "RS:Z:C\tRF:Z:3"),
+		read("i", 1, 60, alt, "IIIIIIIIII", ""), // counted alone without family tags
+		read("j", 1, 10, alt, "IIIIIIIIII", ""), // below minMapQ
+		read("k", 11, 60, snv, "IIIIIIIIII", ""),
+		read("l", 11, 60, snv, "IIIIIIIIII", ""),
+		read("m", 11, 60, snv, "IIIIIIIIII", ""),
+		read("n", 11, 60, "CCCCCCCCCC", lowQual, ""),
+	} {
+		sam.WriteToBamFileHandle(w, r, 0)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bai.WriteIndex(filename)
+
+	snps := []snp{{"chr1", 5, dna.A, dna.G}, {"chr1", 15, dna.C, dna.T}, {"chr1", 25, dna.G, dna.A}}
+	p := genotypeParams{minDepth: 3, homThreshold: 0.1, minStrandedDepth: 1, minMapQ: 20, minBaseQuality: 20}
+	if expected, actual := []int8{1, 2, noCall}, bamGenotypes(filename, snps, p); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected genotypes %v, got %v", expected, actual)
+	}
+}
+
+func TestBaseAtPos(t *testing.T) {
+	r := sam.Sam{Pos: 10, Cigar: cigar.FromString("2S3M2D2M1I2M"), Seq: dna.StringToBases("TTACGCAGCA"), Qual: "!!ABCDEFGH"}
+	tests := []struct {
+		pos          int
+		expectedBase dna.Base
+		expectedQual uint8
+		expectedOk   bool
+	}{
+		{10, dna.A, 32, true},
+		{12, dna.G, 34, true},
+		{13, dna.N, 0, false}, // deleted
+		{15, dna.C, 35, true},
+		{17, dna.C, 38, true}, // after the insertion
+		{9, dna.N, 0, false},
+		{20, dna.N, 0, false},
+	}
+	for _, test := range tests {
+		b, q, ok := baseAtPos(r, test.pos)
+		if b != test.expectedBase || q != test.expectedQual || ok != test.expectedOk {
+			t.Errorf("expected %s %d %t at %d, got %s %d %t", dna.BaseToString(test.expectedBase), test.expectedQual, test.expectedOk, test.pos, dna.BaseToString(b), q, ok)
+		}
+	}
+}
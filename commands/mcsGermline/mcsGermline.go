@@ -0,0 +1,357 @@
+package mcsGermline
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsGermline - Call germline SNVs and small indels from duplex consensus reads.\n" +
+			"Input is the bam generated by mcsConsensus, so depth is counted in read families rather than reads. Each\n" +
+			"consensus read contributes one observation per reference position, and diploid genotypes are called from the\n" +
+			"reference allele and the most common alternate allele with a binomial model using the duplex error rate.\n" +
+			"Sites with at least -minDepth families and a non-reference genotype with GQ >= -minGQ are written to the output VCF,\n" +
+			"which can be used with -g in filterGermline.\n" +
+			"Usage:\n" +
+			"mcsGermline [options] -i consensus.bam -r reference.fasta > germline.vcf\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsGermline with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file of duplex consensus reads generated with mcsConsensus.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai).")
+	output := flag.String("o", "stdout", "Output VCF file.")
+	sample := flag.String("sample", "", "Sample name for the VCF. Defaults to the input file name.")
+	minDepth := flag.Int("minDepth", 10, "Minimum number of consensus reads (families) covering a site to genotype.")
+	minGQ := flag.Int("minGQ", 20, "Minimum genotype quality for a non-reference genotype to be output.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality of consensus reads.")
+	minBaseQuality := flag.Int("minBaseQuality", 30, "Minimum consensus base quality.")
+	errorRate := flag.Float64("errorRate", 0.001, "Per-base error rate of duplex consensus reads.")
+	heterozygosity := flag.Float64("heterozygosity", 0.001, "Prior probability of a heterozygous site. Prior for homozygous alternate sites is half this value.")
+	window := flag.Int("window", 50000, "Consensus reads from mcsConsensus are sorted within this many bases. Sites are genotyped once all reads within this distance have been read.")
+	dryrun.Parse()
+
+	if *input == "" || *ref == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i) and reference (-r).")
+	}
+
+	if *errorRate <= 0 || *errorRate >= 0.5 {
+		usage()
+		log.Fatal("ERROR: -errorRate must be between 0 and 0.5.")
+	}
+
+	if *sample == "" {
+		*sample = strings.TrimSuffix(filepath.Base(*input), ".bam")
+	}
+
+	p := callParams{
+		minDepth:       *minDepth,
+		minGQ:          float64(*minGQ),
+		minMapQ:        uint8(*minMapQ),
+		minBaseQuality: uint8(*minBaseQuality),
+		errorRate:      *errorRate,
+		hetPrior:       *heterozygosity,
+		homAltPrior:    *heterozygosity / 2,
+	}
+
+	mcsGermline(*input, *ref, *output, *sample, *window, p)
+}
+
+// callParams stores the settings used for genotyping.
+type callParams struct {
+	minDepth       int
+	minGQ          float64
+	minMapQ        uint8
+	minBaseQuality uint8
+	errorRate      float64
+	hetPrior       float64
+	homAltPrior    float64
+}
+
+// siteCounts stores the number of consensus reads supporting each allele at a reference position.
+// Indels are keyed by their anchor position (the base before the indel) as +SEQ for insertions
+// and -LENGTH for deletions.
+type siteCounts struct {
+	bases  [4]int
+	indels map[string]int
+}
+
+func mcsGermline(input, ref, output, sample string, window int, p callParams) {
+	reads, _ := hts.GoReadToChan(input)
+	faSeeker := fai.NewSeeker(ref)
+	defer cleanup(faSeeker)
+	idx := fai.ReadIndex(ref + ".fai")
+
+	out := tabix.Create(output)
+	defer cleanup(out)
+	vcf.NewWriteHeader(out, provenance.Vcf(makeVcfHeader(ref, sample)))
+
+	sites := make(map[int]*siteCounts)
+	var chrSeq []dna.Base
+	var chr string
+	var err error
+	var called, lastFlush int
+	var obs []observation
+	for r := range reads {
+		if sam.IsUnmapped(r) || sam.IsNotPrimaryAlign(r) || sam.IsSupplementaryAlign(r) || r.MapQ < p.minMapQ {
+			continue
+		}
+		if r.RName != chr {
+			called += callSites(out, sites, chr, chrSeq, 0, p)
+			chr = r.RName
+			chrSeq, err = faSeeker.SeekByName(chr, 0, idx.Size(chr))
+			exception.PanicOnErr(err)
+			dna.AllToUpper(chrSeq)
+			lastFlush = 0
+		}
+		obs = observations(r, p.minBaseQuality, obs[:0])
+		for _, o := range obs {
+			addObservation(sites, o)
+		}
+		if r.GetChromStart()-lastFlush > window {
+			called += callSites(out, sites, chr, chrSeq, r.GetChromStart()-window, p)
+			lastFlush = r.GetChromStart()
+		}
+	}
+	called += callSites(out, sites, chr, chrSeq, 0, p)
+	log.Printf("Called %d germline variants.\n", called)
+}
+
+// observation is the allele supported by a consensus read at a single position.
+type observation struct {
+	pos    int // 0-based
+	base   dna.Base
+	indel  string // set if an indel follows this position
+	isBase bool
+}
+
+// observations returns the allele supported by the read at each position it covers.
+func observations(r sam.Sam, minBaseQuality uint8, ans []observation) []observation {
+	refPos := r.GetChromStart()
+	var queryPos int
+	var i int
+	for _, c := range r.Cigar {
+		switch c.Op {
+		case 'M', '=', 'X':
+			for i = 0; i < c.RunLength; i++ {
+				if r.Qual[queryPos+i]-33 >= minBaseQuality && dna.ToUpper(r.Seq[queryPos+i]) <= dna.T {
+					ans = append(ans, observation{pos: refPos + i, base: dna.ToUpper(r.Seq[queryPos+i]), isBase: true})
+				}
+			}
+			refPos += c.RunLength
+			queryPos += c.RunLength
+		case 'I':
+			if len(ans) > 0 && ans[len(ans)-1].pos == refPos-1 {
+				ans[len(ans)-1].indel = "+" + strings.ToUpper(dna.BasesToString(r.Seq[queryPos:queryPos+c.RunLength]))
+			}
+			queryPos += c.RunLength
+		case 'D':
+			if len(ans) > 0 && ans[len(ans)-1].pos == refPos-1 {
+				ans[len(ans)-1].indel = "-" + strconv.Itoa(c.RunLength)
+			}
+			refPos += c.RunLength
+		case 'N':
+			refPos += c.RunLength
+		case 'S':
+			queryPos += c.RunLength
+		}
+	}
+	return ans
+}
+
+func addObservation(sites map[int]*siteCounts, o observation) {
+	s := sites[o.pos]
+	if s == nil {
+		s = &siteCounts{}
+		sites[o.pos] = s
+	}
+	if o.indel != "" {
+		if s.indels == nil {
+			s.indels = make(map[string]int)
+		}
+		s.indels[o.indel]++
+		return
+	}
+	s.bases[o.base]++
+}
+
+// callSites genotypes and removes all sites before end. If end is 0, all sites are called.
+func callSites(out io.Writer, sites map[int]*siteCounts, chr string, chrSeq []dna.Base, end int, p callParams) int {
+	positions := make([]int, 0, len(sites))
+	for pos := range sites {
+		if end == 0 || pos < end {
+			positions = append(positions, pos)
+		}
+	}
+	sort.Ints(positions)
+	var called int
+	var v vcf.Vcf
+	var ok bool
+	for _, pos := range positions {
+		v, ok = genotypeSite(chr, pos, sites[pos], chrSeq, p)
+		delete(sites, pos)
+		if ok {
+			vcf.WriteVcf(out, v)
+			called++
+		}
+	}
+	return called
+}
+
+// genotypeSite calls a diploid genotype from the reference allele and the most common alternate allele.
+func genotypeSite(chr string, pos int, s *siteCounts, chrSeq []dna.Base, p callParams) (vcf.Vcf, bool) {
+	if pos >= len(chrSeq) || chrSeq[pos] > dna.T {
+		return vcf.Vcf{}, false
+	}
+	refBase := chrSeq[pos]
+	var depth int
+	for i := range s.bases {
+		depth += s.bases[i]
+	}
+	for _, c := range s.indels {
+		depth += c
+	}
+	if depth < p.minDepth {
+		return vcf.Vcf{}, false
+	}
+
+	refCount := s.bases[refBase]
+	var altCount int
+	var altBase dna.Base
+	var altIndel string
+	for b := dna.A; b <= dna.T; b++ {
+		if b != refBase && s.bases[b] > altCount {
+			altCount, altBase = s.bases[b], b
+		}
+	}
+	indelKeys := make([]string, 0, len(s.indels))
+	for k := range s.indels {
+		indelKeys = append(indelKeys, k)
+	}
+	sort.Strings(indelKeys) // deterministic tie breaking
+	for _, k := range indelKeys {
+		if s.indels[k] > altCount {
+			altCount, altIndel = s.indels[k], k
+		}
+	}
+	if altCount == 0 {
+		return vcf.Vcf{}, false
+	}
+
+	// genotype log10 likelihoods for 0/0, 0/1, 1/1. Observations of other alleles are equally likely under each genotype.
+	logCorrect, logError := math.Log10(1-p.errorRate), math.Log10(p.errorRate)
+	gl := [3]float64{
+		float64(refCount)*logCorrect + float64(altCount)*logError,
+		float64(refCount+altCount) * math.Log10(0.5),
+		float64(refCount)*logError + float64(altCount)*logCorrect,
+	}
+	priors := [3]float64{math.Log10(1 - p.hetPrior - p.homAltPrior), math.Log10(p.hetPrior), math.Log10(p.homAltPrior)}
+	var post [3]float64
+	var best int
+	for i := range gl {
+		post[i] = gl[i] + priors[i]
+		if post[i] > post[best] {
+			best = i
+		}
+	}
+	norm := logSumExp10(post[:])
+	gq := phred(1 - math.Pow(10, post[best]-norm))
+	if best == 0 || gq < p.minGQ {
+		return vcf.Vcf{}, false
+	}
+
+	v := vcf.Vcf{Chr: chr, Pos: pos + 1, Id: ".", Filter: "PASS", Info: "."}
+	v.Qual = math.Round(phred(math.Pow(10, post[0]-norm))*100) / 100
+	switch {
+	case altIndel == "":
+		v.Ref = dna.BaseToString(refBase)
+		v.Alt = []string{dna.BaseToString(altBase)}
+	case altIndel[0] == '+':
+		v.Ref = dna.BaseToString(refBase)
+		v.Alt = []string{v.Ref + altIndel[1:]}
+	default:
+		delLen, err := strconv.Atoi(altIndel[1:])
+		exception.PanicOnErr(err)
+		if pos+1+delLen > len(chrSeq) {
+			return vcf.Vcf{}, false
+		}
+		v.Ref = dna.BasesToString(chrSeq[pos : pos+1+delLen])
+		v.Alt = []string{dna.BaseToString(refBase)}
+	}
+
+	// PL is normalized so the most likely genotype is 0
+	maxGl := math.Max(gl[0], math.Max(gl[1], gl[2]))
+	pl := make([]string, 3)
+	for i := range gl {
+		pl[i] = strconv.Itoa(int(math.Round(-10 * (gl[i] - maxGl))))
+	}
+	v.Format = []string{"GT", "DP", "AD", "GQ", "PL"}
+	v.Samples = []vcf.Sample{{
+		Alleles:    []int16{0, 1},
+		Phase:      []bool{false, false},
+		FormatData: []string{"", strconv.Itoa(depth), fmt.Sprintf("%d,%d", refCount, altCount), strconv.Itoa(int(math.Min(gq, 99))), strings.Join(pl, ",")},
+	}}
+	if best == 2 {
+		v.Samples[0].Alleles = []int16{1, 1}
+	}
+	return v, true
+}
+
+// logSumExp10 returns log10(sum(10^x)).
+func logSumExp10(x []float64) float64 {
+	m := x[0]
+	for i := range x {
+		m = math.Max(m, x[i])
+	}
+	var sum float64
+	for i := range x {
+		sum += math.Pow(10, x[i]-m)
+	}
+	return m + math.Log10(sum)
+}
+
+// phred converts a probability to a phred scaled quality, capped at 999.
+func phred(prob float64) float64 {
+	if prob <= 1e-100 {
+		return 999
+	}
+	return math.Min(-10*math.Log10(prob), 999)
+}
+
+func makeVcfHeader(referenceFile, sample string) vcf.Header {
+	var header vcf.Header
+	header.Text = append(header.Text, "##fileformat=VCFv4.2")
+	header.Text = append(header.Text, fmt.Sprintf("##reference=%s", referenceFile))
+	header.Text = append(header.Text, strings.TrimSuffix(fai.VcfContigHeader(referenceFile), "\n"))
+	header.Text = append(header.Text, "##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Number of duplex consensus reads (read families) covering the site\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=AD,Number=R,Type=Integer,Description=\"Number of duplex consensus reads supporting each allele\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=GQ,Number=1,Type=Integer,Description=\"Genotype quality\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=PL,Number=G,Type=Integer,Description=\"Phred-scaled genotype likelihoods\">")
+	header.Text = append(header.Text, fmt.Sprintf("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t%s", sample))
+	return header
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,108 @@
+package mcsGermline
+
+import (
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestObservations(t *testing.T) {
+	r := sam.Sam{
+		Pos:   11,
+		Cigar: cigar.FromString("2S3M2I2M1D2M"),
+		Seq:   dna.StringToBases("TTACGTTACGA"),
+		Qual:  "IIIIIIIII#I", // the G at 16 is below the minimum base quality
+	}
+	expected := []observation{
+		{pos: 10, base: dna.A, isBase: true},
+		{pos: 11, base: dna.C, isBase: true},
+		{pos: 12, base: dna.G, isBase: true, indel: "+TT"},
+		{pos: 13, base: dna.A, isBase: true},
+		{pos: 14, base: dna.C, isBase: true, indel: "-1"},
+		{pos: 17, base: dna.A, isBase: true},
+	}
+	if actual := observations(r, 20, nil); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestGenotypeSite(t *testing.T) {
+	chrSeq := dna.StringToBases("ACGTACGTNC")
+	p := callParams{minDepth: 5, minGQ: 20, errorRate: 0.01, hetPrior: 1e-3, homAltPrior: 5e-4}
+	counts := func(a, c, g, t int, indels map[string]int) *siteCounts {
+		return &siteCounts{bases: [4]int{a, c, g, t}, indels: indels}
+	}
+	tests := []struct {
+		pos        int
+		counts     *siteCounts
+		expectedOk bool
+		ref, alt   string
+		alleles    []int16
+		formatData []string // AD, GQ, and PL
+	}{
+		{pos: 2, counts: counts(10, 0, 10, 0, nil), expectedOk: true, ref: "G", alt: "A", alleles: []int16{0, 1}, formatData: []string{"10,10", "99", "140,0,140"}},
+		{pos: 2, counts: counts(10, 0, 0, 0, nil), expectedOk: true, ref: "G", alt: "A", alleles: []int16{1, 1}, formatData: []string{"0,10", "26", "200,30,0"}},
+		{pos: 2, counts: counts(0, 0, 6, 0, map[string]int{"+TT": 6}), expectedOk: true, ref: "G", alt: "GTT", alleles: []int16{0, 1}},
+		{pos: 2, counts: counts(0, 0, 5, 0, map[string]int{"-2": 10}), expectedOk: true, ref: "GTA", alt: "G", alleles: []int16{0, 1}},
+		{pos: 2, counts: counts(0, 0, 10, 0, nil), expectedOk: false},                    // no alternate allele
+		{pos: 2, counts: counts(2, 0, 2, 0, nil), expectedOk: false},                     // below minDepth
+		{pos: 2, counts: counts(1, 0, 19, 0, nil), expectedOk: false},                    // homozygous reference
+		{pos: 2, counts: counts(0, 0, 2, 0, map[string]int{"-2": 8}), expectedOk: false}, // GQ below minGQ
+		{pos: 8, counts: counts(10, 0, 0, 0, nil), expectedOk: false},                    // N in the reference
+		{pos: 9, counts: counts(0, 0, 2, 0, map[string]int{"-3": 8}), expectedOk: false}, // deletion past the end
+	}
+	for _, test := range tests {
+		v, ok := genotypeSite("chr1", test.pos, test.counts, chrSeq, p)
+		if ok != test.expectedOk {
+			t.Errorf("expected a call %t at %d for %+v, got %v", test.expectedOk, test.pos, test.counts, v)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if v.Pos != test.pos+1 || v.Ref != test.ref || v.Alt[0] != test.alt || !reflect.DeepEqual(v.Samples[0].Alleles, test.alleles) {
+			t.Errorf("expected %s>%s %v at %d, got %s>%s %v at %d", test.ref, test.alt, test.alleles, test.pos+1, v.Ref, v.Alt[0], v.Samples[0].Alleles, v.Pos)
+		}
+		if test.formatData != nil && !reflect.DeepEqual(v.Samples[0].FormatData[2:], test.formatData) {
+			t.Errorf("expected AD, GQ, and PL %v, got %v", test.formatData, v.Samples[0].FormatData[2:])
+		}
+	}
+}
+
+func TestLogSumExp10(t *testing.T) {
+	tests := []struct {
+		x        []float64
+		expected float64
+	}{
+		{[]float64{0}, 0},
+		{[]float64{0, 0}, math.Log10(2)},
+		{[]float64{-1, -2, -3}, math.Log10(0.111)},
+		{[]float64{-400, -400}, -400 + math.Log10(2)}, // underflows without the shift by the maximum
+	}
+	for _, test := range tests {
+		if actual := logSumExp10(test.x); math.Abs(actual-test.expected) > 1e-9 {
+			t.Errorf("expected %g for %v, got %g", test.expected, test.x, actual)
+		}
+	}
+}
+
+func TestPhred(t *testing.T) {
+	tests := []struct {
+		prob     float64
+		expected float64
+	}{
+		{1, 0},
+		{0.1, 10},
+		{1e-3, 30},
+		{0, 999},
+		{1e-120, 999},
+	}
+	for _, test := range tests {
+		if actual := phred(test.prob); math.Abs(actual-test.expected) > 1e-9 {
+			t.Errorf("expected %g for %g, got %g", test.expected, test.prob, actual)
+		}
+	}
+}
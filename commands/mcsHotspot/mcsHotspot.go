@@ -0,0 +1,342 @@
+package mcsHotspot
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsHotspot - Find sites mutated recurrently across unrelated samples of a cohort.\n" +
+			"Calls from many VCF files are aggregated by site (chrom, pos, ref, alt), or by position with -byPosition. A site is\n" +
+			"recurrent when it is called in at least -minIndividuals unrelated individuals. Samples are grouped into individuals\n" +
+			"with -individuals, otherwise each sample is treated as unrelated. Independent somatic mutations rarely recur at the\n" +
+			"same base, so recurrent sites are most often artifacts or unfiltered germline variants. Recurrent sites overlapping\n" +
+			"-hotspots (e.g. known cancer hotspots) are written to -whitelist, and all other recurrent sites to -blacklist.\n" +
+			"The blacklist is a bed file that may be passed to -e in mcsCallVariants.\n" +
+			"Usage:\n" +
+			"mcsHotspot [options] -i a.vcf -i b.vcf -i c.vcf -blacklist blacklist.bed > recurrent.txt\n" +
+			"mcsHotspot [options] -list vcfs.txt -individuals individuals.txt -r ref.fa -hotspots known.bed -whitelist whitelist.bed > recurrent.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsHotspot with the options in os.Args.
+func Main() {
+	var inputs inputFiles
+	flag.Var(&inputs, "i", "Input VCF file with variant calls. May be declared more than once.")
+	listFile := flag.String("list", "", "File with one input VCF file per line.")
+	output := flag.String("o", "stdout", "Output file with each recurrent site.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai). If set, the trinucleotide context of recurrent SNVs is reported.")
+	individualsFile := flag.String("individuals", "", "Tab separated file with sample name and individual on each line. Samples from the same individual count once.")
+	hotspots := flag.String("hotspots", "", "Bed, interval_list, or GFF3 file of known hotspots. Recurrent sites overlapping a hotspot are written to -whitelist instead of -blacklist.")
+	blacklist := flag.String("blacklist", "", "Output bed file of recurrent sites not in -hotspots.")
+	whitelist := flag.String("whitelist", "", "Output bed file of recurrent sites in -hotspots.")
+	minIndividuals := flag.Int("minIndividuals", 3, "Minimum number of unrelated individuals with a call for a site to be recurrent.")
+	maxFraction := flag.Float64("maxFraction", 1, "Sites called in more than this fraction of individuals are reported as germline-like rather than recurrent and are always blacklisted.")
+	byPosition := flag.Bool("byPosition", false, "Aggregate calls by position regardless of allele.")
+	passOnly := flag.Bool("passOnly", false, "Only consider calls with FILTER of PASS or '.'.")
+	dryrun.Parse()
+
+	if *listFile != "" {
+		for _, line := range fileio.Read(*listFile) {
+			if line = strings.TrimSpace(line); line != "" {
+				inputs = append(inputs, line)
+			}
+		}
+	}
+
+	if len(inputs) < 2 {
+		usage()
+		log.Fatal("ERROR: must specify at least two input vcf files (-i or -list).")
+	}
+
+	if *minIndividuals < 2 {
+		usage()
+		log.Fatal("ERROR: -minIndividuals must be at least 2.")
+	}
+
+	h := hotspotParams{
+		minIndividuals: *minIndividuals,
+		maxFraction:    *maxFraction,
+		byPosition:     *byPosition,
+		passOnly:       *passOnly,
+	}
+	if *individualsFile != "" {
+		h.individuals = readIndividuals(*individualsFile)
+	}
+
+	mcsHotspot(inputs, *output, *ref, *hotspots, *blacklist, *whitelist, h)
+}
+
+// hotspotParams stores the options for finding recurrent sites.
+type hotspotParams struct {
+	individuals    map[string]string // maps sample name to individual
+	minIndividuals int
+	maxFraction    float64
+	byPosition     bool
+	passOnly       bool
+}
+
+// site stores the calls at a single site across the cohort.
+type site struct {
+	chr         string
+	pos         int
+	ref         string
+	alt         string // "*" when aggregated by position
+	samples     map[string]bool
+	individuals map[string]bool
+	duplex      int // calls with DS in INFO
+	calls       int
+}
+
+func (s *site) GetChrom() string {
+	return s.chr
+}
+
+func (s *site) GetChromStart() int {
+	return s.pos - 1
+}
+
+func (s *site) GetChromEnd() int {
+	return s.pos - 1 + len(s.ref)
+}
+
+func mcsHotspot(inputs []string, output, ref, hotspots, blacklist, whitelist string, h hotspotParams) {
+	sites := make(map[string]*site)
+	allIndividuals := make(map[string]bool)
+	for _, input := range inputs {
+		readCalls(input, sites, allIndividuals, h)
+	}
+	log.Printf("Read calls at %d sites from %d individuals.\n", len(sites), len(allIndividuals))
+
+	var recurrent []*site
+	for _, s := range sites {
+		if len(s.individuals) >= h.minIndividuals {
+			recurrent = append(recurrent, s)
+		}
+	}
+	sort.Slice(recurrent, func(i, j int) bool {
+		if recurrent[i].chr != recurrent[j].chr {
+			return recurrent[i].chr < recurrent[j].chr
+		}
+		if recurrent[i].pos != recurrent[j].pos {
+			return recurrent[i].pos < recurrent[j].pos
+		}
+		return recurrent[i].alt < recurrent[j].alt
+	})
+
+	var hotspotTree map[string]*interval.IntervalNode
+	if hotspots != "" {
+		hotspotTree = interval.BuildTree(interval.BedSliceToIntervals(intervals.Read(hotspots)))
+	}
+	var seeker *fai.Seeker
+	if ref != "" {
+		seeker = fai.NewSeeker(ref)
+		defer cleanup(seeker)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Chrom\tPos\tRef\tAlt\tContext\tIndividuals\tSamples\tCalls\tDuplexCalls\tClass\tSampleNames")
+	exception.PanicOnErr(err)
+
+	var blackOut, whiteOut io.WriteCloser
+	if blacklist != "" {
+		blackOut = tabix.Create(blacklist)
+		defer cleanup(blackOut)
+	}
+	if whitelist != "" {
+		whiteOut = tabix.Create(whitelist)
+		defer cleanup(whiteOut)
+	}
+
+	var class, context string
+	var counts = make(map[string]int)
+	for _, s := range recurrent {
+		class = classify(s, hotspotTree, len(allIndividuals), h)
+		counts[class]++
+		context = "."
+		if seeker != nil {
+			context = snvContext(s, seeker)
+		}
+		_, err = fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%s\t%s\n", s.chr, s.pos, s.ref, s.alt, context,
+			len(s.individuals), len(s.samples), s.calls, s.duplex, class, strings.Join(sortedKeys(s.samples), ","))
+		exception.PanicOnErr(err)
+
+		switch {
+		case class == "hotspot" && whiteOut != nil:
+			writeSite(whiteOut, s, class)
+		case class != "hotspot" && blackOut != nil:
+			writeSite(blackOut, s, class)
+		}
+	}
+	log.Printf("Found %d recurrent sites: %d artifact-like, %d germline-like, %d known hotspots.\n",
+		len(recurrent), counts["recurrent"], counts["germline"], counts["hotspot"])
+}
+
+// readCalls adds each call in a VCF to sites.
+func readCalls(input string, sites map[string]*site, allIndividuals map[string]bool, h hotspotParams) {
+	records, header := vcf.GoReadToChan(input)
+	samples := vcf.SampleNamesInOrder(header)
+	if len(samples) == 0 {
+		samples = []string{strings.TrimSuffix(strings.TrimSuffix(filepath.Base(input), ".gz"), ".vcf")}
+	}
+	individuals := make([]string, len(samples))
+	for i := range samples {
+		individuals[i] = samples[i]
+		if ind, found := h.individuals[samples[i]]; found {
+			individuals[i] = ind
+		}
+		allIndividuals[individuals[i]] = true
+	}
+
+	var key, alt string
+	var s *site
+	var duplex bool
+	for v := range records {
+		if h.passOnly && v.Filter != "PASS" && v.Filter != "." {
+			continue
+		}
+		duplex = hasInfoFlag(v.Info, "DS")
+		for a := range v.Alt {
+			alt = v.Alt[a]
+			if h.byPosition {
+				alt = "*"
+			}
+			key = fmt.Sprintf("%s:%d:%s:%s", v.Chr, v.Pos, v.Ref, alt)
+			for i := range samples {
+				if len(v.Samples) > 0 && !hasAllele(v.Samples[i], int16(a+1)) {
+					continue
+				}
+				if s = sites[key]; s == nil {
+					s = &site{chr: v.Chr, pos: v.Pos, ref: v.Ref, alt: alt, samples: make(map[string]bool), individuals: make(map[string]bool)}
+					sites[key] = s
+				}
+				s.samples[samples[i]] = true
+				s.individuals[individuals[i]] = true
+				s.calls++
+				if duplex {
+					s.duplex++
+				}
+			}
+		}
+	}
+}
+
+// classify returns hotspot for recurrent sites overlapping a known hotspot, germline for sites called in more
+// than h.maxFraction of individuals, and recurrent otherwise.
+func classify(s *site, hotspotTree map[string]*interval.IntervalNode, totalIndividuals int, h hotspotParams) string {
+	if float64(len(s.individuals)) > h.maxFraction*float64(totalIndividuals) {
+		return "germline"
+	}
+	if hotspotTree != nil && len(interval.Query(hotspotTree, s, "any")) > 0 {
+		return "hotspot"
+	}
+	return "recurrent"
+}
+
+// snvContext returns the pyrimidine-centered trinucleotide context of an SNV (e.g. A[C>T]G), or '.' for other variants.
+func snvContext(s *site, ref *fai.Seeker) string {
+	if len(s.ref) != 1 || len(s.alt) != 1 || s.alt == "*" || s.pos < 2 {
+		return "."
+	}
+	refBase, altBase := dna.StringToBase(s.ref), dna.StringToBase(s.alt)
+	seq, err := ref.SeekByName(s.chr, s.pos-2, s.pos+1)
+	if err != nil || len(seq) != 3 {
+		return "."
+	}
+	dna.AllToUpper(seq)
+	if refBase == dna.A || refBase == dna.G {
+		refBase, altBase = dna.ComplementSingleBase(refBase), dna.ComplementSingleBase(altBase)
+		dna.ReverseComplement(seq)
+	}
+	if seq[1] != refBase {
+		log.Printf("WARNING: reference base does not match vcf at %s:%d\n", s.chr, s.pos)
+		return "."
+	}
+	return fmt.Sprintf("%s[%s>%s]%s", dna.BaseToString(seq[0]), dna.BaseToString(refBase), dna.BaseToString(altBase), dna.BaseToString(seq[2]))
+}
+
+// writeSite writes a site to a bed file named by its alleles, class, and number of individuals.
+func writeSite(out io.Writer, s *site, class string) {
+	_, err := fmt.Fprintf(out, "%s\t%d\t%d\t%s>%s;%s;n=%d\n", s.chr, s.GetChromStart(), s.GetChromEnd(), s.ref, s.alt, class, len(s.individuals))
+	exception.PanicOnErr(err)
+}
+
+// readIndividuals reads sample names and individuals from a tab separated file.
+func readIndividuals(filename string) map[string]string {
+	ans := make(map[string]string)
+	var words []string
+	for _, line := range fileio.Read(filename) {
+		if line == "" {
+			continue
+		}
+		words = strings.Split(line, "\t")
+		if len(words) < 2 {
+			exit.Fatalf(exit.MalformedInput, "could not parse line in %s. Must have sample name and individual separated by a tab.\n%s", filename, line)
+		}
+		ans[words[0]] = words[1]
+	}
+	return ans
+}
+
+func hasInfoFlag(info, key string) bool {
+	for _, field := range strings.Split(info, ";") {
+		if field == key {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAllele(s vcf.Sample, allele int16) bool {
+	for _, a := range s.Alleles {
+		if a == allele {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]bool) []string {
+	ans := make([]string, 0, len(m))
+	for k := range m {
+		ans = append(ans, k)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
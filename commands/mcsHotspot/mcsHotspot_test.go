@@ -0,0 +1,115 @@
+package mcsHotspot
+
+import (
+	"github.com/dasnellings/duplexTools/fai"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testRef = "ACGTCGTACGTAGGCTAACGTAGCTAGCATCG"
+
+func writeTestFile(t *testing.T, dir, name, data string) string {
+	filename := filepath.Join(dir, name)
+	if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return filename
+}
+
+func writeTestRef(t *testing.T, dir string) string {
+	writeTestFile(t, dir, "ref.fa.fai", "chr1\t32\t6\t32\t33\n")
+	return writeTestFile(t, dir, "ref.fa", ">chr1\n"+testRef+"\n")
+}
+
+func TestMcsHotspot(t *testing.T) {
+	dir := t.TempDir()
+	vcf := func(sample, records string) string {
+		return writeTestFile(t, dir, sample+".vcf", "##fileformat=VCFv4.2\n"+
+			"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">\n"+
+			"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t"+sample+"\n"+records)
+	}
+	inputs := []string{
+		vcf("s1", "chr1\t5\t.\tC\tT\t50\tPASS\tDS\tGT\t0/1\n"+
+			"chr1\t10\t.\tG\tA\t50\tPASS\tSS\tGT\t0/1\n"+
+			"chr1\t30\t.\tT\tC\t50\tPASS\tSS\tGT\t0/1\n"),
+		vcf("s2", "chr1\t5\t.\tC\tT\t50\tPASS\tDS\tGT\t0/1\n"+
+			"chr1\t20\t.\tG\tT\t50\tPASS\tSS\tGT\t0/1\n"+
+			"chr1\t30\t.\tT\tC\t50\tPASS\tSS\tGT\t0/1\n"),
+		vcf("s3", "chr1\t5\t.\tC\tT\t50\tPASS\tSS\tGT\t0/1\n"+
+			"chr1\t10\t.\tG\tA\t50\tPASS\tSS\tGT\t0/1\n"+
+			"chr1\t20\t.\tG\tT\t50\tPASS\tSS\tGT\t0/1\n"+
+			"chr1\t30\t.\tT\tC\t50\tPASS\tSS\tGT\t0/1\n"),
+		vcf("s4", "chr1\t5\t.\tC\tT\t50\tPASS\tDS\tGT\t0/1\n"+
+			"chr1\t10\t.\tG\tA\t50\tPASS\tSS\tGT\t0/1\n"+
+			"chr1\t20\t.\tG\tT\t50\tmin_af\tSS\tGT\t0/1\n"), // removed by passOnly
+		vcf("s5", "chr1\t10\t.\tG\tA\t50\tPASS\tSS\tGT\t0/1\n"+
+			"chr1\t20\t.\tG\tT,C\t50\tPASS\tSS\tGT\t0/1\n"), // the C allele is not carried
+	}
+	ref := writeTestRef(t, dir)
+	hotspots := writeTestFile(t, dir, "hotspots.bed", "chr1\t19\t20\n")
+	output := filepath.Join(dir, "sites.txt")
+	blacklist := filepath.Join(dir, "blacklist.bed")
+	whitelist := filepath.Join(dir, "whitelist.bed")
+	// s1 and s2 are the same individual, so there are 4 individuals and sites in more than 3.6 are germline-like.
+	// chr1:30 is only called in 2 individuals.
+	h := hotspotParams{individuals: map[string]string{"s1": "indA", "s2": "indA"}, minIndividuals: 3, maxFraction: 0.9, passOnly: true}
+	mcsHotspot(inputs, output, ref, hotspots, blacklist, whitelist, h)
+
+	expected := "Chrom\tPos\tRef\tAlt\tContext\tIndividuals\tSamples\tCalls\tDuplexCalls\tClass\tSampleNames\n" +
+		"chr1\t5\tC\tT\tT[C>T]G\t3\t4\t4\t3\trecurrent\ts1,s2,s3,s4\n" +
+		"chr1\t10\tG\tA\tA[C>T]G\t4\t4\t4\t0\tgermline\ts1,s3,s4,s5\n" +
+		"chr1\t20\tG\tT\tA[C>A]G\t3\t3\t3\t0\thotspot\ts2,s3,s5\n"
+	expectedBlacklist := "chr1\t4\t5\tC>T;recurrent;n=3\nchr1\t9\t10\tG>A;germline;n=4\n"
+	expectedWhitelist := "chr1\t19\t20\tG>T;hotspot;n=3\n"
+	for _, test := range []struct{ filename, expected string }{
+		{output, expected},
+		{blacklist, expectedBlacklist},
+		{whitelist, expectedWhitelist},
+	} {
+		actual, err := os.ReadFile(test.filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != test.expected {
+			t.Errorf("expected %s:\n%s\ngot:\n%s", filepath.Base(test.filename), test.expected, actual)
+		}
+	}
+
+	// without passOnly, the filtered call in s4 makes chr1:20 germline-like
+	h.byPosition, h.passOnly = true, false
+	mcsHotspot(inputs, output, "", "", "", "", h)
+	expected = "Chrom\tPos\tRef\tAlt\tContext\tIndividuals\tSamples\tCalls\tDuplexCalls\tClass\tSampleNames\n" +
+		"chr1\t5\tC\t*\t.\t3\t4\t4\t3\trecurrent\ts1,s2,s3,s4\n" +
+		"chr1\t10\tG\t*\t.\t4\t4\t4\t0\tgermline\ts1,s3,s4,s5\n" +
+		"chr1\t20\tG\t*\t.\t4\t4\t4\t0\tgermline\ts2,s3,s4,s5\n"
+	actual, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("expected by position:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestSnvContext(t *testing.T) {
+	seeker := fai.NewSeeker(writeTestRef(t, t.TempDir()))
+	defer cleanup(seeker)
+	tests := []struct {
+		s        site
+		expected string
+	}{
+		{site{chr: "chr1", pos: 5, ref: "C", alt: "A"}, "T[C>A]G"},
+		{site{chr: "chr1", pos: 8, ref: "A", alt: "G"}, "G[T>C]A"}, // reverse complemented
+		{site{chr: "chr1", pos: 1, ref: "A", alt: "G"}, "."},       // no preceding base
+		{site{chr: "chr1", pos: 32, ref: "G", alt: "A"}, "."},      // no following base
+		{site{chr: "chr1", pos: 5, ref: "T", alt: "A"}, "."},       // does not match the reference
+		{site{chr: "chr1", pos: 5, ref: "C", alt: "CT"}, "."},
+		{site{chr: "chr1", pos: 5, ref: "C", alt: "*"}, "."},
+	}
+	for _, test := range tests {
+		if actual := snvContext(&test.s, seeker); actual != test.expected {
+			t.Errorf("expected %s for %s>%s at %d, got %s", test.expected, test.s.ref, test.s.alt, test.s.pos, actual)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package mcsMerge
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/vcf"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsMerge - Merge the outputs of runs split with -shard (e.g. mcsCallVariants, genotypeTargetRepeats) into one sorted file.\n" +
+			"Inputs may be VCF or BED (e.g. calledSites beds or -lenOut files). The header of the first input is kept and the\n" +
+			"#CHROM line of every VCF must match it. Records are sorted by chromosome, in the order of -r if given, otherwise\n" +
+			"the ##contig order of the VCF header or the order the chromosomes appear in the inputs, then by position.\n" +
+			"Inputs ending in a #TRUNCATED marker from an interrupted or salvaged run are rejected unless -allowTruncated is set.\n" +
+			"Usage:\n" +
+			"mcsMerge [options] -o merged.vcf.gz shard1.vcf shard2.vcf ...\n" +
+			"mcsMerge [options] -o merged.calledSites.bed -i shard1.calledSites.bed -i shard2.calledSites.bed\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsMerge with the options in os.Args.
+func Main() {
+	var inputs inputFiles
+	flag.Var(&inputs, "i", "Input VCF or BED file from one shard. May be declared more than once. Files may also be given as arguments after the options.")
+	output := flag.String("o", "stdout", "Output file. Outputs ending in .vcf.gz or .bed.gz are bgzip compressed and indexed.")
+	ref := flag.String("r", "", "Reference FASTA file with a .fai index. Sets the chromosome order of the output.")
+	allowTruncated := flag.Bool("allowTruncated", false, "Merge inputs from interrupted runs with a warning instead of exiting with an error.")
+	dryrun.Parse()
+	inputs = append(inputs, flag.Args()...)
+
+	if len(inputs) == 0 {
+		usage()
+		log.Fatal("ERROR: must specify at least one input file.")
+	}
+
+	mcsMerge(inputs, *output, *ref, *allowTruncated)
+}
+
+// record is a data line with its sort keys.
+type record struct {
+	chrom      string
+	start, end int
+	line       string
+}
+
+func mcsMerge(inputs []string, output, ref string, allowTruncated bool) {
+	var header []string
+	var isVcf bool
+	var records []record
+	var chromRuns [][]string // chromosomes in order of first appearance in each input
+
+	for n, file := range inputs {
+		fileHeader, fileRecords, chroms := readShard(file, allowTruncated)
+		if n == 0 {
+			header = fileHeader
+			isVcf = len(header) > 0 && strings.HasPrefix(header[0], "##fileformat=VCF")
+		} else if isVcf && columnNames(fileHeader) != columnNames(header) {
+			log.Fatalf("ERROR: the #CHROM line of %s does not match %s. Only shards of the same run can be merged.", file, inputs[0])
+		}
+		records = append(records, fileRecords...)
+		chromRuns = append(chromRuns, chroms)
+	}
+
+	var explicit []string
+	switch {
+	case ref != "":
+		explicit = fai.ReadIndex(ref + ".fai").Names()
+	case isVcf:
+		explicit = contigs(header)
+	}
+	rank := chromOrder(append([][]string{explicit}, chromRuns...))
+
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].chrom != records[j].chrom {
+			return rank[records[i].chrom] < rank[records[j].chrom]
+		}
+		if records[i].start != records[j].start {
+			return records[i].start < records[j].start
+		}
+		return records[i].end < records[j].end
+	})
+
+	if isVcf {
+		header = provenance.Vcf(vcf.Header{Text: header}).Text
+	}
+	out := tabix.Create(output)
+	for i := range header {
+		_, err := fmt.Fprintln(out, header[i])
+		exception.PanicOnErr(err)
+	}
+	for i := range records {
+		_, err := fmt.Fprintln(out, records[i].line)
+		exception.PanicOnErr(err)
+	}
+	err := out.Close()
+	exception.PanicOnErr(err)
+	log.Printf("Merged %d records from %d files.", len(records), len(inputs))
+}
+
+// readShard returns the header lines, records, and chromosomes in order of first
+// appearance of a single input.
+func readShard(file string, allowTruncated bool) (header []string, records []record, chroms []string) {
+	var err error
+	var fields []string
+	var r record
+	seen := make(map[string]bool)
+	in := fileio.EasyOpen(file)
+	for line, done := fileio.EasyNextLine(in); !done; line, done = fileio.EasyNextLine(in) {
+		if len(line) == 0 {
+			continue
+		}
+		if strings.HasPrefix(line, "#TRUNCATED") {
+			if !allowTruncated {
+				log.Fatalf("ERROR: %s is from an interrupted or salvaged run and is incomplete. Rerun the shard or use -allowTruncated.", file)
+			}
+			log.Printf("WARNING: %s is from an interrupted or salvaged run and is incomplete.", file)
+			continue
+		}
+		if line[0] == '#' || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			if len(records) == 0 {
+				header = append(header, line)
+			}
+			continue
+		}
+
+		fields = strings.SplitN(line, "\t", 4)
+		if len(fields) < 3 {
+			exit.Fatalf(exit.MalformedInput, "%s has fewer than 3 columns in line:\n%s", file, line)
+		}
+		r = record{chrom: fields[0], line: line}
+		r.start, err = strconv.Atoi(fields[1])
+		if err != nil {
+			exit.Fatalf(exit.MalformedInput, "could not parse position in %s line:\n%s", file, line)
+		}
+		r.end = r.start
+		if e, err := strconv.Atoi(fields[2]); err == nil { // bed end, vcf has an ID in the third column
+			r.end = e
+		}
+		records = append(records, r)
+		if !seen[r.chrom] {
+			seen[r.chrom] = true
+			chroms = append(chroms, r.chrom)
+		}
+	}
+	err = in.Close()
+	exception.PanicOnErr(err)
+	return
+}
+
+// columnNames returns the #CHROM line of a VCF header.
+func columnNames(header []string) string {
+	for i := range header {
+		if strings.HasPrefix(header[i], "#CHROM") {
+			return header[i]
+		}
+	}
+	return ""
+}
+
+// contigs returns the IDs of the ##contig lines of a VCF header.
+func contigs(header []string) []string {
+	var ans []string
+	for i := range header {
+		if !strings.HasPrefix(header[i], "##contig=<") {
+			continue
+		}
+		for _, field := range strings.Split(strings.Trim(header[i][len("##contig="):], "<>"), ",") {
+			if id, found := strings.CutPrefix(field, "ID="); found {
+				ans = append(ans, id)
+			}
+		}
+	}
+	return ans
+}
+
+// chromOrder returns the rank of each chromosome in an order consistent with every
+// run in runs where possible. Each run lists chromosomes in the order they should be
+// output. Ties and conflicts are resolved by the order chromosomes are first seen.
+func chromOrder(runs [][]string) map[string]int {
+	var names []string
+	firstSeen := make(map[string]int)
+	inDegree := make(map[string]int)
+	next := make(map[string][]string)
+	for _, run := range runs {
+		for i, name := range run {
+			if _, found := firstSeen[name]; !found {
+				firstSeen[name] = len(names)
+				names = append(names, name)
+			}
+			if i > 0 {
+				next[run[i-1]] = append(next[run[i-1]], name)
+				inDegree[name]++
+			}
+		}
+	}
+
+	rank := make(map[string]int, len(names))
+	for len(rank) < len(names) {
+		chosen := ""
+		for _, name := range names { // earliest seen chromosome with nothing left before it
+			if _, done := rank[name]; !done && inDegree[name] == 0 {
+				chosen = name
+				break
+			}
+		}
+		if chosen == "" { // inputs disagree on order
+			for _, name := range names {
+				if _, done := rank[name]; !done {
+					chosen = name
+					break
+				}
+			}
+		}
+		rank[chosen] = len(rank)
+		for _, n := range next[chosen] {
+			inDegree[n]--
+		}
+	}
+	return rank
+}
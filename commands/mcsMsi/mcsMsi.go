@@ -0,0 +1,320 @@
+package mcsMsi
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsMsi - Classify microsatellite instability from repeat lengths measured by genotypeTargetRepeats.\n" +
+			"Input is one or more tables generated with -lenOut in genotypeTargetRepeats. For each tumor/normal pair (or\n" +
+			"single-cell/bulk pair) the distribution of read lengths at each marker is compared between the two samples with a\n" +
+			"two-sample Kolmogorov-Smirnov test. A marker is unstable if the KS statistic is at least -minD and the p-value is\n" +
+			"at most -alpha. The MSI score is the fraction of evaluable markers that are unstable, and pairs with a score of at\n" +
+			"least -msiThreshold are classified as MSI-H, otherwise MSS. Pairs with fewer than -minMarkers evaluable markers\n" +
+			"are classified as Indeterminate.\n" +
+			"Samples are named by the column headers in the -lenOut table, with or without the directory and .bam extension.\n" +
+			"Usage:\n" +
+			"mcsMsi [options] -i lengths.txt -pair tumor:normal > msi.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsMsi with the options in os.Args.
+func Main() {
+	var inputs, pairs inputFiles
+	flag.Var(&inputs, "i", "Input table generated with -lenOut in genotypeTargetRepeats. May be declared more than once.")
+	flag.Var(&pairs, "pair", "Samples to compare formatted as tumor:normal. May be declared more than once.")
+	output := flag.String("o", "stdout", "Output file with the MSI score and classification of each pair.")
+	markersOut := flag.String("markersOut", "", "Output file with stability calls for each marker in each pair.")
+	minReads := flag.Int("minReads", 20, "Minimum number of reads in both samples for a marker to be evaluated.")
+	minD := flag.Float64("minD", 0.2, "Minimum KS statistic (maximum difference between cumulative length distributions) for a marker to be unstable.")
+	alpha := flag.Float64("alpha", 0.01, "Maximum KS test p-value for a marker to be unstable.")
+	msiThreshold := flag.Float64("msiThreshold", 0.3, "Minimum fraction of unstable markers to classify a pair as MSI-H.")
+	minMarkers := flag.Int("minMarkers", 5, "Minimum number of evaluable markers to classify a pair.")
+	dryrun.Parse()
+
+	if len(inputs) == 0 || len(pairs) == 0 {
+		usage()
+		log.Fatal("ERROR: must specify at least one input table (-i) and sample pair (-pair).")
+	}
+
+	mcsMsi(inputs, pairs, *output, *markersOut, *minReads, *minMarkers, *minD, *alpha, *msiThreshold)
+}
+
+// marker is a targeted repeat with observed read lengths for each sample.
+type marker struct {
+	chr     string
+	start   int
+	end     int
+	repeat  string
+	lengths map[string][]int // keyed by sample name
+}
+
+// markerResult is the stability call for one marker in one pair.
+type markerResult struct {
+	tumorReads  int
+	normalReads int
+	tumorMode   int
+	normalMode  int
+	d           float64
+	p           float64
+	status      string
+}
+
+func mcsMsi(inputs, pairs []string, output, markersOut string, minReads, minMarkers int, minD, alpha, msiThreshold float64) {
+	markers, samples := readLengths(inputs)
+
+	var markerWriter io.WriteCloser
+	var err error
+	if markersOut != "" {
+		markerWriter = fileio.EasyCreate(markersOut)
+		defer cleanup(markerWriter)
+		_, err = fmt.Fprintln(markerWriter, "#chrom\tstart\tend\trepeat\ttumor\tnormal\ttumorReads\tnormalReads\ttumorMode\tnormalMode\tksD\tksP\tstatus")
+		exception.PanicOnErr(err)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err = fmt.Fprintln(out, "Tumor\tNormal\tMarkers\tEvaluable\tUnstable\tMsiScore\tClass")
+	exception.PanicOnErr(err)
+
+	var tumor, normal, class string
+	var evaluable, unstable int
+	var score float64
+	var res markerResult
+	for _, p := range pairs {
+		tumor, normal = parsePair(p, samples)
+		evaluable, unstable = 0, 0
+		for _, m := range markers {
+			res = testMarker(m.lengths[tumor], m.lengths[normal], minReads, minD, alpha)
+			switch res.status {
+			case "unstable":
+				unstable++
+				evaluable++
+			case "stable":
+				evaluable++
+			}
+			if markerWriter != nil {
+				_, err = fmt.Fprintf(markerWriter, "%s\t%d\t%d\t%s\t%s\t%s\t%d\t%d\t%d\t%d\t%.4f\t%.4g\t%s\n", m.chr, m.start, m.end, m.repeat,
+					tumor, normal, res.tumorReads, res.normalReads, res.tumorMode, res.normalMode, res.d, res.p, res.status)
+				exception.PanicOnErr(err)
+			}
+		}
+
+		score = 0
+		if evaluable > 0 {
+			score = float64(unstable) / float64(evaluable)
+		}
+		switch {
+		case evaluable < minMarkers:
+			class = "Indeterminate"
+			log.Printf("WARNING: only %d evaluable markers for %s:%s. Pair will be classified as Indeterminate.\n", evaluable, tumor, normal)
+		case score >= msiThreshold:
+			class = "MSI-H"
+		default:
+			class = "MSS"
+		}
+		_, err = fmt.Fprintf(out, "%s\t%s\t%d\t%d\t%d\t%.4f\t%s\n", tumor, normal, len(markers), evaluable, unstable, score, class)
+		exception.PanicOnErr(err)
+	}
+}
+
+// readLengths parses -lenOut tables from genotypeTargetRepeats. Markers present in more than one table are merged.
+func readLengths(inputs []string) ([]*marker, []string) {
+	var ans []*marker
+	var samples []string
+	seen := make(map[string]*marker)
+	var header, fields []string
+	var key string
+	var m *marker
+	var found bool
+	var err error
+	for _, filename := range inputs {
+		header = nil
+		file := fileio.EasyOpen(filename)
+		for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) {
+			fields = strings.Split(line, "\t")
+			if strings.HasPrefix(line, "#") {
+				header = fields
+				for i := 4; i < len(header); i++ {
+					samples = append(samples, header[i])
+				}
+				continue
+			}
+			if header == nil {
+				exit.Fatalf(exit.MalformedInput, "%s is missing a header line. Was it generated with -lenOut in genotypeTargetRepeats?", filename)
+			}
+			if len(fields) != len(header) {
+				exit.Fatalf(exit.MalformedInput, "expected %d columns but found %d in %s\n%s", len(header), len(fields), filename, line)
+			}
+			key = fields[0] + ":" + fields[1] + ":" + fields[2]
+			if m, found = seen[key]; !found {
+				m = &marker{chr: fields[0], repeat: fields[3], lengths: make(map[string][]int)}
+				m.start, err = strconv.Atoi(fields[1])
+				exception.PanicOnErr(err)
+				m.end, err = strconv.Atoi(fields[2])
+				exception.PanicOnErr(err)
+				seen[key] = m
+				ans = append(ans, m)
+			}
+			for i := 4; i < len(fields); i++ {
+				m.lengths[header[i]] = parseLengths(fields[i])
+			}
+		}
+		err = file.Close()
+		exception.PanicOnErr(err)
+	}
+	return ans, samples
+}
+
+func parseLengths(s string) []int {
+	if s == "NA" || s == "" {
+		return nil
+	}
+	words := strings.Split(s, ",")
+	ans := make([]int, len(words))
+	var err error
+	for i := range words {
+		ans[i], err = strconv.Atoi(words[i])
+		exception.PanicOnErr(err)
+	}
+	return ans
+}
+
+// parsePair splits tumor:normal and matches each name to a sample column.
+func parsePair(pair string, samples []string) (tumor, normal string) {
+	words := strings.Split(pair, ":")
+	if len(words) != 2 {
+		log.Fatalf("ERROR: could not parse pair '%s'. Must be formatted as tumor:normal.", pair)
+	}
+	return matchSample(words[0], samples), matchSample(words[1], samples)
+}
+
+func matchSample(name string, samples []string) string {
+	for _, s := range samples {
+		if s == name || strings.TrimSuffix(filepath.Base(s), ".bam") == name {
+			return s
+		}
+	}
+	log.Fatalf("ERROR: sample '%s' was not found in input tables. Available samples: %s", name, strings.Join(samples, ", "))
+	return ""
+}
+
+// testMarker compares tumor and normal read length distributions at a single marker.
+func testMarker(tumor, normal []int, minReads int, minD, alpha float64) markerResult {
+	ans := markerResult{tumorReads: len(tumor), normalReads: len(normal), tumorMode: mode(tumor), normalMode: mode(normal), p: 1, status: "NA"}
+	if len(tumor) < minReads || len(normal) < minReads {
+		return ans
+	}
+	ans.d = ksStatistic(tumor, normal)
+	ans.p = ksPValue(ans.d, len(tumor), len(normal))
+	if ans.d >= minD && ans.p <= alpha {
+		ans.status = "unstable"
+	} else {
+		ans.status = "stable"
+	}
+	return ans
+}
+
+// ksStatistic returns the maximum difference between the empirical cumulative distributions of a and b.
+func ksStatistic(a, b []int) float64 {
+	a = sortedCopy(a)
+	b = sortedCopy(b)
+	var i, j int
+	var d, diff float64
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default: // advance past all ties before comparing
+			val := a[i]
+			for i < len(a) && a[i] == val {
+				i++
+			}
+			for j < len(b) && b[j] == val {
+				j++
+			}
+		}
+		diff = math.Abs(float64(i)/float64(len(a)) - float64(j)/float64(len(b)))
+		if diff > d {
+			d = diff
+		}
+	}
+	return d
+}
+
+// ksPValue returns the asymptotic two-sample KS p-value (Numerical Recipes 14.3).
+func ksPValue(d float64, n1, n2 int) float64 {
+	ne := float64(n1*n2) / float64(n1+n2)
+	lambda := (math.Sqrt(ne) + 0.12 + 0.11/math.Sqrt(ne)) * d
+	if lambda < 0.2 {
+		return 1
+	}
+	var sum, term float64
+	sign := 2.0
+	for k := 1; k <= 100; k++ {
+		term = sign * math.Exp(-2*float64(k*k)*lambda*lambda)
+		sum += term
+		if math.Abs(term) < 1e-10*sum {
+			break
+		}
+		sign = -sign
+	}
+	return math.Min(math.Max(sum, 0), 1)
+}
+
+// mode returns the most common value in a, or -1 if a is empty.
+func mode(a []int) int {
+	if len(a) == 0 {
+		return -1
+	}
+	counts := make(map[int]int)
+	best := a[0]
+	for _, v := range a {
+		counts[v]++
+		if counts[v] > counts[best] || (counts[v] == counts[best] && v < best) {
+			best = v
+		}
+	}
+	return best
+}
+
+func sortedCopy(a []int) []int {
+	ans := make([]int, len(a))
+	copy(ans, a)
+	sort.Ints(ans)
+	return ans
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
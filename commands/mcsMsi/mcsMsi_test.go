@@ -0,0 +1,69 @@
+package mcsMsi
+
+import (
+	"math"
+	"testing"
+)
+
+// repeatLengths returns the repeat lengths of reads, with counts[length] reads of each length.
+func repeatLengths(counts map[int]int) []int {
+	var ans []int
+	for length := 15; length <= 25; length++ {
+		for i := 0; i < counts[length]; i++ {
+			ans = append(ans, length)
+		}
+	}
+	return ans
+}
+
+func TestTestMarker(t *testing.T) {
+	normal := repeatLengths(map[int]int{19: 5, 20: 30, 21: 5})
+	tests := []struct {
+		name     string
+		tumor    []int
+		expected string
+		mode     int
+	}{
+		{"same distribution", repeatLengths(map[int]int{19: 4, 20: 32, 21: 4}), "stable", 20},
+		{"deletion allele", repeatLengths(map[int]int{16: 15, 17: 5, 20: 15, 21: 5}), "unstable", 16},
+		{"too few reads", repeatLengths(map[int]int{16: 5}), "NA", 16},
+	}
+	for _, test := range tests {
+		ans := testMarker(test.tumor, normal, 10, 0.2, 0.05)
+		if ans.status != test.expected || ans.tumorMode != test.mode || ans.normalMode != 20 {
+			t.Errorf("%s: expected %s with tumor mode %d, got %s with D=%.3f p=%.3g and modes %d and %d", test.name, test.expected, test.mode, ans.status, ans.d, ans.p, ans.tumorMode, ans.normalMode)
+		}
+	}
+}
+
+func TestKsStatistic(t *testing.T) {
+	tests := []struct {
+		a, b     []int
+		expected float64
+	}{
+		{[]int{1, 2, 3}, []int{1, 2, 3}, 0},
+		{[]int{1, 1}, []int{2, 2}, 1},
+		{[]int{1, 2, 3, 4}, []int{3, 4, 5, 6}, 0.5},
+		{[]int{1, 2, 2, 3}, []int{2, 2}, 0.25}, // ties are stepped over together
+	}
+	for _, test := range tests {
+		if actual := ksStatistic(test.a, test.b); math.Abs(actual-test.expected) > 1e-12 {
+			t.Errorf("expected D=%g for %v and %v, got %g", test.expected, test.a, test.b, actual)
+		}
+	}
+}
+
+func TestKsPValue(t *testing.T) {
+	// Q_KS(lambda) = 2 sum (-1)^(k-1) exp(-2 k^2 lambda^2), with lambda from the effective size of 50 and 50 reads
+	lambda := (5 + 0.12 + 0.11/5) * 0.3
+	var expected float64
+	for k := 1; k <= 10; k++ {
+		expected += 2 * math.Pow(-1, float64(k-1)) * math.Exp(-2*float64(k*k)*lambda*lambda)
+	}
+	if actual := ksPValue(0.3, 50, 50); math.Abs(actual-expected) > 1e-9 {
+		t.Errorf("expected p=%g for D=0.3 with 50 and 50 reads, got %g", expected, actual)
+	}
+	if actual := ksPValue(0.01, 50, 50); actual != 1 {
+		t.Errorf("expected p=1 for a small D, got %g", actual)
+	}
+}
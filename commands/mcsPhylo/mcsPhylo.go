@@ -0,0 +1,414 @@
+package mcsPhylo
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsPhylo - Build a lineage tree of single cells from the same individual from shared somatic variants.\n" +
+			"Each cell is scored for the presence or absence of each variant. When family beds from annotateReadFamilies are given\n" +
+			"with -b (one per cell, in the same order as -i), a variant not called in a cell with no passing read family at the site\n" +
+			"is missing data rather than absent. Variants not called in a covered cell may still be missed by dropout, so\n" +
+			"mismatches between cells are down-weighted by -dropout. The distance between two cells is the weighted fraction of\n" +
+			"variants present in either cell that are not shared, and the tree is built by neighbor joining.\n" +
+			"Variants found in at least -germlineFrac of cells are removed as germline-like.\n" +
+			"Usage:\n" +
+			"mcsPhylo [options] -i cell1.vcf -i cell2.vcf -i cell3.vcf -matrixOut matrix.txt > tree.nwk\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsPhylo with the options in os.Args.
+func Main() {
+	var inputs, bedFiles inputFiles
+	flag.Var(&inputs, "i", "Input VCF file with variant calls from a single cell. Must be declared at least three times.")
+	flag.Var(&bedFiles, "b", "Bed file with read families generated with -bed option in annotateReadFamilies for each cell, in the same order as -i. Optional.")
+	output := flag.String("o", "stdout", "Output tree in Newick format.")
+	matrixOut := flag.String("matrixOut", "", "Output variant by cell matrix (1 = present, 0 = absent, NA = missing).")
+	distOut := flag.String("distOut", "", "Output cell by cell distance matrix.")
+	dropout := flag.Float64("dropout", 0.2, "Probability that a variant present in a covered cell is not called.")
+	germlineFrac := flag.Float64("germlineFrac", 0.9, "Variants present in at least this fraction of cells are removed as germline-like. Variants in nearly all cells do not inform the tree.")
+	totalDepth := flag.Int("a", 8, "Minimum total depth of read family for the site to be covered. Should match -a in mcsCallVariants.")
+	strandedDepth := flag.Int("s", 4, "Minimum depth of independent watson and crick strands for the site to be covered. Should match -s in mcsCallVariants.")
+	passOnly := flag.Bool("passOnly", false, "Only consider calls with FILTER of PASS or '.'.")
+	dryrun.Parse()
+
+	if len(inputs) < 3 {
+		usage()
+		log.Fatal("ERROR: must specify at least three input vcf files (-i).")
+	}
+
+	if len(bedFiles) > 0 && len(bedFiles) != len(inputs) {
+		usage()
+		log.Fatal("ERROR: the number of family beds (-b) must match the number of input vcf files (-i).")
+	}
+
+	if *dropout < 0 || *dropout >= 1 {
+		usage()
+		log.Fatal("ERROR: -dropout must be >= 0 and < 1.")
+	}
+
+	mcsPhylo(inputs, bedFiles, *output, *matrixOut, *distOut, *dropout, *germlineFrac, *totalDepth, *strandedDepth, *passOnly)
+}
+
+// cell state of a variant
+const (
+	absent  int8 = 0
+	present int8 = 1
+	missing int8 = -1
+)
+
+// phyloVariant stores the state of a variant in each cell.
+type phyloVariant struct {
+	chr   string
+	pos   int
+	ref   string
+	alt   string
+	state []int8
+	count int
+}
+
+func mcsPhylo(inputs, bedFiles []string, output, matrixOut, distOut string, dropout, germlineFrac float64, totalDepth, strandedDepth int, passOnly bool) {
+	cells := make([]string, len(inputs))
+	variants := readVariants(inputs, cells, passOnly)
+
+	var germline int
+	kept := variants[:0]
+	for _, v := range variants {
+		if float64(v.count) >= germlineFrac*float64(len(cells)) {
+			germline++
+			continue
+		}
+		kept = append(kept, v)
+	}
+	variants = kept
+	log.Printf("Found %d somatic variants. Removed %d germline-like variants.\n", len(variants), germline)
+
+	for i := range bedFiles {
+		markMissing(variants, bedFiles[i], i, totalDepth, strandedDepth)
+	}
+
+	dist := distances(variants, len(cells), dropout)
+	if distOut != "" {
+		writeDistances(distOut, dist, cells)
+	}
+	if matrixOut != "" {
+		writeMatrix(matrixOut, variants, cells)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, neighborJoin(dist, cells))
+	exception.PanicOnErr(err)
+}
+
+// readVariants returns all variants called in any cell sorted by position, and fills the cell names.
+func readVariants(inputs []string, cells []string, passOnly bool) []*phyloVariant {
+	variants := make(map[string]*phyloVariant)
+	var key, ref string
+	var pv *phyloVariant
+	var found bool
+	for i := range inputs {
+		records, header := vcf.GoReadToChan(inputs[i])
+		cells[i] = sampleName(inputs[i], header)
+		for v := range records {
+			if passOnly && v.Filter != "PASS" && v.Filter != "." {
+				continue
+			}
+			for _, alt := range v.Alt {
+				ref, alt = trimSuffix(v.Ref, alt)
+				key = variantKey(v.Chr, v.Pos, ref, alt)
+				if pv, found = variants[key]; !found {
+					pv = &phyloVariant{chr: v.Chr, pos: v.Pos, ref: ref, alt: alt, state: make([]int8, len(inputs))}
+					variants[key] = pv
+				}
+				if pv.state[i] != present {
+					pv.state[i] = present
+					pv.count++
+				}
+			}
+		}
+	}
+
+	ans := make([]*phyloVariant, 0, len(variants))
+	for _, pv = range variants {
+		ans = append(ans, pv)
+	}
+	sort.Slice(ans, func(i, j int) bool {
+		if ans[i].chr != ans[j].chr {
+			return ans[i].chr < ans[j].chr
+		}
+		if ans[i].pos != ans[j].pos {
+			return ans[i].pos < ans[j].pos
+		}
+		return ans[i].alt < ans[j].alt
+	})
+	return ans
+}
+
+// markMissing sets the state of variants not called in a cell to missing unless a passing read family
+// from the cell covers the site.
+func markMissing(variants []*phyloVariant, bedFile string, cell, totalDepth, strandedDepth int) {
+	byChrom := make(map[string][]*phyloVariant)
+	for _, v := range variants {
+		byChrom[v.chr] = append(byChrom[v.chr], v)
+	}
+	covered := make([]bool, len(variants))
+	index := make(map[*phyloVariant]int, len(variants))
+	for i := range variants {
+		index[variants[i]] = i
+	}
+
+	var watson, crick int
+	var err error
+	var vars []*phyloVariant
+	families := bed.GoReadToChan(bedFile)
+	for b := range families {
+		if b.Name == "0" { // RF:Z:0 collects reads not assigned to a family
+			continue
+		}
+		if vars = byChrom[b.Chrom]; len(vars) == 0 {
+			continue
+		}
+		if len(b.Annotation) < 2 {
+			exit.Fatalf(exit.MissingTag, "bed record is missing watson and crick read counts. Was it generated with annotateReadFamilies?\n%s", bed.ToString(b, b.FieldsInitialized))
+		}
+		watson, err = strconv.Atoi(b.Annotation[0])
+		exception.PanicOnErr(err)
+		crick, err = strconv.Atoi(b.Annotation[1])
+		exception.PanicOnErr(err)
+		if watson+crick < totalDepth || watson < strandedDepth || crick < strandedDepth {
+			continue
+		}
+		// variants are sorted by position within each chromosome
+		for j := sort.Search(len(vars), func(j int) bool { return vars[j].pos > b.ChromStart }); j < len(vars) && vars[j].pos <= b.ChromEnd; j++ {
+			covered[index[vars[j]]] = true
+		}
+	}
+
+	var nMissing int
+	for i, v := range variants {
+		if v.state[cell] == absent && !covered[i] {
+			v.state[cell] = missing
+			nMissing++
+		}
+	}
+	log.Printf("%s: %d variants are missing data due to no passing read family.\n", bedFile, nMissing)
+}
+
+// distances returns the pairwise distance between cells. Variants absent in one cell and present in the other
+// count as 1 - dropout mismatches to account for variants missed in the absent cell.
+func distances(variants []*phyloVariant, nCells int, dropout float64) [][]float64 {
+	ans := make([][]float64, nCells)
+	for i := range ans {
+		ans[i] = make([]float64, nCells)
+	}
+	var shared, mismatch float64
+	var a, b int8
+	for i := 0; i < nCells; i++ {
+		for j := i + 1; j < nCells; j++ {
+			shared, mismatch = 0, 0
+			for _, v := range variants {
+				a, b = v.state[i], v.state[j]
+				switch {
+				case a == missing || b == missing:
+				case a == present && b == present:
+					shared++
+				case a == present || b == present:
+					mismatch += 1 - dropout
+				}
+			}
+			if shared+mismatch == 0 {
+				ans[i][j] = 1 // no informative variants
+			} else {
+				ans[i][j] = mismatch / (shared + mismatch)
+			}
+			ans[j][i] = ans[i][j]
+		}
+	}
+	return ans
+}
+
+// neighborJoin builds a tree from the distance matrix by neighbor joining and returns it in Newick format.
+func neighborJoin(dist [][]float64, names []string) string {
+	n := len(names)
+	d := make([][]float64, n)
+	for i := range d {
+		d[i] = make([]float64, n)
+		copy(d[i], dist[i])
+	}
+	nodes := make([]string, n)
+	for i := range names {
+		nodes[i] = newickName(names[i])
+	}
+
+	var sums []float64
+	var q, minQ, li, lj float64
+	var bi, bj int
+	for len(nodes) > 2 {
+		n = len(nodes)
+		sums = make([]float64, n)
+		for i := range nodes {
+			for j := range nodes {
+				sums[i] += d[i][j]
+			}
+		}
+		minQ = math.Inf(1)
+		for i := 0; i < n; i++ {
+			for j := i + 1; j < n; j++ {
+				q = float64(n-2)*d[i][j] - sums[i] - sums[j]
+				if q < minQ {
+					minQ, bi, bj = q, i, j
+				}
+			}
+		}
+
+		li = d[bi][bj]/2 + (sums[bi]-sums[bj])/float64(2*(n-2))
+		lj = d[bi][bj] - li
+		joined := fmt.Sprintf("(%s:%s,%s:%s)", nodes[bi], branch(li), nodes[bj], branch(lj))
+
+		// distance from the new node to each remaining node
+		newDist := make([]float64, 0, n-1)
+		for k := 0; k < n; k++ {
+			if k != bi && k != bj {
+				newDist = append(newDist, (d[bi][k]+d[bj][k]-d[bi][bj])/2)
+			}
+		}
+		d, nodes = removeNodes(d, nodes, bi, bj)
+		for k := range d {
+			d[k] = append(d[k], newDist[k])
+		}
+		d = append(d, append(newDist, 0))
+		nodes = append(nodes, joined)
+	}
+	return fmt.Sprintf("(%s:%s,%s:%s);", nodes[0], branch(d[0][1]/2), nodes[1], branch(d[0][1]/2))
+}
+
+// removeNodes returns the distance matrix and node names without nodes i and j.
+func removeNodes(d [][]float64, nodes []string, i, j int) ([][]float64, []string) {
+	ansD := make([][]float64, 0, len(nodes))
+	ansNodes := make([]string, 0, len(nodes))
+	for a := range nodes {
+		if a == i || a == j {
+			continue
+		}
+		row := make([]float64, 0, len(nodes))
+		for b := range nodes {
+			if b != i && b != j {
+				row = append(row, d[a][b])
+			}
+		}
+		ansD = append(ansD, row)
+		ansNodes = append(ansNodes, nodes[a])
+	}
+	return ansD, ansNodes
+}
+
+// branch formats a branch length. Negative lengths from neighbor joining are set to 0.
+func branch(l float64) string {
+	return strconv.FormatFloat(math.Max(l, 0), 'f', 5, 64)
+}
+
+// newickName quotes names with characters reserved in the Newick format.
+func newickName(s string) string {
+	if strings.ContainsAny(s, " ():;,[]'") {
+		return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+	}
+	return s
+}
+
+func writeMatrix(file string, variants []*phyloVariant, cells []string) {
+	out := fileio.EasyCreate(file)
+	defer cleanup(out)
+	_, err := fmt.Fprintf(out, "#chrom\tpos\tref\talt\tnumCells\t%s\n", strings.Join(cells, "\t"))
+	exception.PanicOnErr(err)
+	var sb strings.Builder
+	for _, v := range variants {
+		sb.Reset()
+		for _, s := range v.state {
+			switch s {
+			case present:
+				sb.WriteString("\t1")
+			case absent:
+				sb.WriteString("\t0")
+			default:
+				sb.WriteString("\tNA")
+			}
+		}
+		_, err = fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%d%s\n", v.chr, v.pos, v.ref, v.alt, v.count, sb.String())
+		exception.PanicOnErr(err)
+	}
+}
+
+func writeDistances(file string, dist [][]float64, cells []string) {
+	out := fileio.EasyCreate(file)
+	defer cleanup(out)
+	_, err := fmt.Fprintf(out, "Cell\t%s\n", strings.Join(cells, "\t"))
+	exception.PanicOnErr(err)
+	for i := range dist {
+		_, err = fmt.Fprint(out, cells[i])
+		exception.PanicOnErr(err)
+		for j := range dist[i] {
+			_, err = fmt.Fprintf(out, "\t%.4f", dist[i][j])
+			exception.PanicOnErr(err)
+		}
+		_, err = fmt.Fprintln(out)
+		exception.PanicOnErr(err)
+	}
+}
+
+// trimSuffix removes trailing bases shared by ref and alt so that the same indel
+// written with different amounts of trailing context matches across samples.
+func trimSuffix(ref, alt string) (string, string) {
+	for len(ref) > 1 && len(alt) > 1 && ref[len(ref)-1] == alt[len(alt)-1] {
+		ref = ref[:len(ref)-1]
+		alt = alt[:len(alt)-1]
+	}
+	return ref, alt
+}
+
+func variantKey(chr string, pos int, ref, alt string) string {
+	return fmt.Sprintf("%s:%d:%s:%s", chr, pos, ref, alt)
+}
+
+// sampleName returns the first sample in the vcf header, or the file name if the vcf has no samples.
+func sampleName(filename string, header vcf.Header) string {
+	names := vcf.SampleNamesInOrder(header)
+	if len(names) > 0 && names[0] != "" {
+		return names[0]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filename), ".gz"), ".vcf")
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
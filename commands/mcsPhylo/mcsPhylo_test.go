@@ -0,0 +1,124 @@
+package mcsPhylo
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNeighborJoin(t *testing.T) {
+	// additive distances from the five taxon example of Saitou and Nei's algorithm, with branch lengths
+	// a:2 b:3 c:4 d:2 e:1, 3 between (a,b) and c, and 2 between the (a,b,c) and (d,e) clades
+	dist := [][]float64{
+		{0, 5, 9, 9, 8},
+		{5, 0, 10, 10, 9},
+		{9, 10, 0, 8, 7},
+		{9, 10, 8, 0, 3},
+		{8, 9, 7, 3, 0},
+	}
+	expected := "((c:4.00000,(a:2.00000,b:3.00000):3.00000):1.00000,(d:2.00000,e:1.00000):1.00000);"
+	if actual := neighborJoin(dist, []string{"a", "b", "c", "d", "e"}); actual != expected {
+		t.Errorf("expected %s, got %s", expected, actual)
+	}
+	if dist[0][1] != 5 || len(dist) != 5 {
+		t.Error("expected the input distance matrix to be unchanged")
+	}
+
+	expected = "('cell 1':0.25000,(x:0.50000,y:0.50000):0.25000);"
+	if actual := neighborJoin([][]float64{{0, 1, 1}, {1, 0, 1}, {1, 1, 0}}, []string{"x", "y", "cell 1"}); actual != expected {
+		t.Errorf("expected %s with a quoted name, got %s", expected, actual)
+	}
+}
+
+func TestDistances(t *testing.T) {
+	variants := []*phyloVariant{
+		{state: []int8{present, present, absent}},
+		{state: []int8{present, absent, missing}},
+		{state: []int8{absent, present, present}},
+		{state: []int8{absent, absent, present}},
+	}
+	// mismatches count as 1 - dropout = 0.8 and missing states are skipped
+	expected := [][]float64{
+		{0, 1.6 / 2.6, 1},
+		{1.6 / 2.6, 0, 1.6 / 2.6},
+		{1, 1.6 / 2.6, 0},
+	}
+	actual := distances(variants, 3, 0.2)
+	for i := range expected {
+		for j := range expected[i] {
+			if math.Abs(actual[i][j]-expected[i][j]) > 1e-9 {
+				t.Errorf("expected distance %g between cells %d and %d, got %g", expected[i][j], i, j, actual[i][j])
+			}
+		}
+	}
+	if actual = distances(nil, 2, 0.2); actual[0][1] != 1 || actual[1][0] != 1 {
+		t.Errorf("expected cells with no informative variants to be 1 apart, got %v", actual)
+	}
+}
+
+func TestMarkMissing(t *testing.T) {
+	bedFile := filepath.Join(t.TempDir(), "families.bed")
+	families := "chr1\t0\t1000\t0\t0\t+\t10\t10\n" + // reads without a family
+		"chr1\t90\t150\t1\t0\t+\t5\t4\n" +
+		"chr1\t190\t210\t2\t0\t+\t3\t3\n" + // too few reads
+		"chr2\t40\t60\t3\t0\t+\t8\t2\n" // too few crick reads
+	if err := os.WriteFile(bedFile, []byte(families), 0644); err != nil {
+		t.Fatal(err)
+	}
+	variants := []*phyloVariant{
+		{chr: "chr1", pos: 90, state: []int8{absent, absent}}, // before the first base of family 1
+		{chr: "chr1", pos: 100, state: []int8{absent, absent}},
+		{chr: "chr1", pos: 150, state: []int8{absent, absent}},
+		{chr: "chr1", pos: 200, state: []int8{present, absent}},
+		{chr: "chr2", pos: 50, state: []int8{absent, absent}},
+	}
+	markMissing(variants, bedFile, 1, 8, 4)
+	expected := []int8{missing, absent, absent, missing, missing}
+	for i, v := range variants {
+		if v.state[1] != expected[i] {
+			t.Errorf("expected state %d for %s:%d, got %d", expected[i], v.chr, v.pos, v.state[1])
+		}
+		if v.state[0] == missing {
+			t.Errorf("expected %s:%d to be unchanged in the other cell", v.chr, v.pos)
+		}
+	}
+}
+
+func TestTrimSuffix(t *testing.T) {
+	tests := []struct {
+		ref, alt                 string
+		expectedRef, expectedAlt string
+	}{
+		{"A", "G", "A", "G"},
+		{"ACGT", "AT", "ACG", "A"},
+		{"AT", "ACGT", "A", "ACG"},
+		{"AA", "A", "AA", "A"},
+		{"ACG", "TCG", "A", "T"},
+	}
+	for _, test := range tests {
+		if ref, alt := trimSuffix(test.ref, test.alt); ref != test.expectedRef || alt != test.expectedAlt {
+			t.Errorf("expected %s>%s for %s>%s, got %s>%s", test.expectedRef, test.expectedAlt, test.ref, test.alt, ref, alt)
+		}
+	}
+}
+
+func TestNewickName(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected string
+	}{
+		{"cell_1", "cell_1"},
+		{"cell 1", "'cell 1'"},
+		{"cell:1", "'cell:1'"},
+		{"O'Hara (A)", "'O''Hara (A)'"},
+	}
+	for _, test := range tests {
+		if actual := newickName(test.name); actual != test.expected {
+			t.Errorf("expected %s for %s, got %s", test.expected, test.name, actual)
+		}
+	}
+	if actual := branch(-0.1); actual != "0.00000" {
+		t.Errorf("expected negative branch lengths to be 0, got %s", actual)
+	}
+}
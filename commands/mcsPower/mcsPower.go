@@ -0,0 +1,291 @@
+package mcsPower
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"io"
+	"log"
+	"math"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsPower - Estimate mutation detection sensitivity and the samples or duplex coverage needed to detect a difference in mutation burden.\n" +
+			"Duplex coverage is the number of bases interrogated by passing read families. It is read from the family bed of\n" +
+			"annotateReadFamilies (-b), the 'Experimental Coverage' of mcsBurdenCorrection (-burdenSummary), or given directly (-coverage).\n" +
+			"With -b, the sensitivity of each passing family is calculated from its watson and crick read depth as the probability\n" +
+			"that a majority of reads on both strands carry a true mutation given -readErrorRate.\n" +
+			"The number of mutations detected in a sample is modeled as Poisson with mean burden * coverage * sensitivity, and\n" +
+			"differences between sample groups are tested on log burden with -cv biological variation between samples.\n" +
+			"Usage:\n" +
+			"mcsPower [options] -b families.bed -burden 1e-7 -effect 1.5 -curveOut power.tsv > power.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsPower with the options in os.Args.
+func Main() {
+	bedFile := flag.String("b", "", "Bed file with read families generated with -bed option in annotateReadFamilies.")
+	burdenSummary := flag.String("burdenSummary", "", "Summary written by mcsBurdenCorrection. The experimental coverage is used as the duplex coverage.")
+	coverage := flag.Float64("coverage", 0, "Duplex coverage in bases.")
+	burden := flag.Float64("burden", 1e-7, "Expected mutation burden in mutations per base.")
+	genomeSize := flag.Float64("genomeSize", 6.2e9, "Callable diploid genome size in bases. Used to report mutations per genome.")
+	sensitivity := flag.Float64("sensitivity", 0.9, "Probability a true mutation in a passing family is called after filters. Multiplied by the depth-based sensitivity with -b.")
+	readErrorRate := flag.Float64("readErrorRate", 0.01, "Probability a read does not show a mutation present on its strand. Used with -b.")
+	totalDepth := flag.Int("a", 8, "Minimum total depth of read family to pass. Should match -a in mcsCallVariants.")
+	strandedDepth := flag.Int("s", 4, "Minimum depth of independent watson and crick strands to pass. Should match -s in mcsCallVariants.")
+	minReadFamilyLength := flag.Int("minReadFamilyLength", 100, "Minimum length in bp of read family to pass. Should match -minReadFamilyLength in mcsCallVariants.")
+	effect := flag.Float64("effect", 1.5, "Ratio of mutation burden between the two groups to detect.")
+	cv := flag.Float64("cv", 0.2, "Coefficient of variation of mutation burden between samples in the same group.")
+	alpha := flag.Float64("alpha", 0.05, "Two-sided significance level.")
+	power := flag.Float64("power", 0.8, "Target power.")
+	samples := flag.Int("n", 10, "Number of samples per group used to report the duplex coverage required per sample.")
+	output := flag.String("o", "stdout", "Output file.")
+	curveOut := flag.String("curveOut", "", "Output file with expected mutations, precision, and samples needed per group at multiples of the current coverage.")
+	dryrun.Parse()
+
+	var sources int
+	for _, s := range []bool{*bedFile != "", *burdenSummary != "", *coverage > 0} {
+		if s {
+			sources++
+		}
+	}
+	if sources != 1 {
+		usage()
+		log.Fatal("ERROR: must specify exactly one of -b, -burdenSummary, or -coverage.")
+	}
+
+	if *effect <= 0 || *effect == 1 {
+		usage()
+		log.Fatal("ERROR: -effect must be greater than 0 and not equal to 1.")
+	}
+
+	p := powerParams{
+		burden:      *burden,
+		genomeSize:  *genomeSize,
+		sensitivity: *sensitivity,
+		effect:      *effect,
+		cv:          *cv,
+		alpha:       *alpha,
+		power:       *power,
+		samples:     *samples,
+	}
+
+	switch {
+	case *bedFile != "":
+		p.coverage, p.depthSensitivity = readFamilies(*bedFile, *totalDepth, *strandedDepth, *minReadFamilyLength, *readErrorRate)
+	case *burdenSummary != "":
+		p.coverage = readCoverage(*burdenSummary)
+		p.depthSensitivity = 1
+	default:
+		p.coverage = *coverage
+		p.depthSensitivity = 1
+	}
+
+	mcsPower(*output, *curveOut, p)
+}
+
+// powerParams stores the inputs to the power calculation.
+type powerParams struct {
+	coverage         float64
+	depthSensitivity float64
+	burden           float64
+	genomeSize       float64
+	sensitivity      float64
+	effect           float64
+	cv               float64
+	alpha            float64
+	power            float64
+	samples          int
+}
+
+func mcsPower(output, curveOut string, p powerParams) {
+	if p.coverage <= 0 {
+		log.Fatal("ERROR: duplex coverage is 0.")
+	}
+	sens := p.sensitivity * p.depthSensitivity
+	expected := p.burden * p.coverage * sens
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Metric\tValue")
+	exception.PanicOnErr(err)
+	lines := []struct {
+		name  string
+		value string
+	}{
+		{"DuplexCoverage", fmt.Sprintf("%.0f", p.coverage)},
+		{"DepthSensitivity", fmt.Sprintf("%.4f", p.depthSensitivity)},
+		{"Sensitivity", fmt.Sprintf("%.4f", sens)},
+		{"EffectiveCoverage", fmt.Sprintf("%.0f", p.coverage*sens)},
+		{"ExpectedMutationsPerGenome", fmt.Sprintf("%.1f", p.burden*p.genomeSize)},
+		{"ExpectedMutationsDetected", fmt.Sprintf("%.2f", expected)},
+		{"ProbabilityAtLeastOneDetected", fmt.Sprintf("%.4f", 1-math.Exp(-expected))},
+		{"BurdenRelativeError", formatFloat(relativeError(expected))},
+		{"SamplesPerGroup", formatSamples(samplesPerGroup(expected, p))},
+		{"CoveragePerSampleForN", formatBases(coverageForSamples(p))},
+		{"CoverageForOneMutation", fmt.Sprintf("%.0f", 1/(p.burden*sens))},
+	}
+	for _, l := range lines {
+		_, err = fmt.Fprintf(out, "%s\t%s\n", l.name, l.value)
+		exception.PanicOnErr(err)
+	}
+
+	if curveOut != "" {
+		writeCurve(curveOut, p, sens)
+	}
+}
+
+// writeCurve reports the expected results at multiples of the current duplex coverage.
+func writeCurve(file string, p powerParams, sens float64) {
+	out := fileio.EasyCreate(file)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Fold\tDuplexCoverage\tExpectedMutationsDetected\tProbabilityAtLeastOneDetected\tBurdenRelativeError\tSamplesPerGroup")
+	exception.PanicOnErr(err)
+	var expected float64
+	for _, fold := range []float64{0.25, 0.5, 1, 2, 4, 8, 16} {
+		expected = p.burden * p.coverage * fold * sens
+		_, err = fmt.Fprintf(out, "%g\t%.0f\t%.2f\t%.4f\t%s\t%s\n", fold, p.coverage*fold, expected, 1-math.Exp(-expected),
+			formatFloat(relativeError(expected)), formatSamples(samplesPerGroup(expected, p)))
+		exception.PanicOnErr(err)
+	}
+}
+
+// relativeError returns the coefficient of variation of the burden estimate from Poisson counting error.
+func relativeError(expected float64) float64 {
+	if expected == 0 {
+		return math.Inf(1)
+	}
+	return 1 / math.Sqrt(expected)
+}
+
+// logVariance returns the variance of log burden in a single sample from counting error and biological variation.
+func logVariance(expected, cv float64) float64 {
+	return 1/expected + math.Log(1+cv*cv)
+}
+
+// samplesPerGroup returns the number of samples per group for a two-sample test of log burden.
+func samplesPerGroup(expected float64, p powerParams) int {
+	if expected == 0 {
+		return -1
+	}
+	z := zAlpha(p.alpha) + zPower(p.power)
+	d := math.Log(p.effect)
+	return int(math.Ceil(2 * z * z * logVariance(expected, p.cv) / (d * d)))
+}
+
+// coverageForSamples returns the duplex coverage per sample needed to reach the target power with p.samples per group.
+func coverageForSamples(p powerParams) float64 {
+	z := zAlpha(p.alpha) + zPower(p.power)
+	d := math.Log(p.effect)
+	maxVariance := float64(p.samples) * d * d / (2 * z * z)
+	countVariance := maxVariance - math.Log(1+p.cv*p.cv)
+	if countVariance <= 0 { // biological variation alone exceeds the allowed variance
+		return math.Inf(1)
+	}
+	return 1 / (countVariance * p.burden * p.sensitivity * p.depthSensitivity)
+}
+
+func zAlpha(alpha float64) float64 {
+	return math.Sqrt2 * math.Erfinv(1-alpha)
+}
+
+func zPower(power float64) float64 {
+	return math.Sqrt2 * math.Erfinv(2*power-1)
+}
+
+// readFamilies returns the number of bases covered by passing families and the mean depth-based sensitivity
+// across those bases.
+func readFamilies(bedFile string, minTotalDepth, minStrandedDepth, minReadFamilyLength int, readErrorRate float64) (coverage, sensitivity float64) {
+	var watson, crick, length int
+	var err error
+	var weighted float64
+	families := bed.GoReadToChan(bedFile)
+	for b := range families {
+		if b.Name == "0" { // RF:Z:0 collects reads not assigned to a family
+			continue
+		}
+		if len(b.Annotation) < 2 {
+			exit.Fatalf(exit.MissingTag, "bed record is missing watson and crick read counts. Was it generated with annotateReadFamilies?\n%s", bed.ToString(b, b.FieldsInitialized))
+		}
+		watson, err = strconv.Atoi(b.Annotation[0])
+		exception.PanicOnErr(err)
+		crick, err = strconv.Atoi(b.Annotation[1])
+		exception.PanicOnErr(err)
+		length = b.ChromEnd - b.ChromStart
+		if length < minReadFamilyLength || watson+crick < minTotalDepth || watson < minStrandedDepth || crick < minStrandedDepth {
+			continue
+		}
+		coverage += float64(length)
+		weighted += float64(length) * strandSensitivity(watson, readErrorRate) * strandSensitivity(crick, readErrorRate)
+	}
+	if coverage > 0 {
+		sensitivity = weighted / coverage
+	}
+	return
+}
+
+// strandSensitivity returns the probability that more than half of n reads show a mutation when each read
+// independently fails to show it with probability e.
+func strandSensitivity(n int, e float64) float64 {
+	var ans float64
+	for k := n/2 + 1; k <= n; k++ {
+		ans += math.Exp(logChoose(n, k) + float64(k)*math.Log(1-e) + float64(n-k)*math.Log(e))
+	}
+	return ans
+}
+
+func logChoose(n, k int) float64 {
+	a, _ := math.Lgamma(float64(n + 1))
+	b, _ := math.Lgamma(float64(k + 1))
+	c, _ := math.Lgamma(float64(n - k + 1))
+	return a - b - c
+}
+
+// readCoverage returns the experimental coverage from the output of mcsBurdenCorrection.
+func readCoverage(file string) float64 {
+	for _, line := range fileio.Read(file) {
+		if !strings.HasPrefix(line, "Experimental Coverage:") {
+			continue
+		}
+		cov, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimPrefix(line, "Experimental Coverage:")), 64)
+		if err != nil {
+			exit.Fatalf(exit.MalformedInput, "could not parse coverage in %s:\n%s\n", file, line)
+		}
+		return cov
+	}
+	log.Fatalf("ERROR: 'Experimental Coverage' not found in %s. Was it generated with mcsBurdenCorrection?", file)
+	return 0
+}
+
+func formatFloat(f float64) string {
+	if math.IsInf(f, 0) {
+		return "NA"
+	}
+	return fmt.Sprintf("%.4g", f)
+}
+
+func formatBases(f float64) string {
+	if math.IsInf(f, 0) {
+		return "NA"
+	}
+	return fmt.Sprintf("%.0f", f)
+}
+
+func formatSamples(n int) string {
+	if n < 0 {
+		return "NA"
+	}
+	return strconv.Itoa(n)
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,114 @@
+package mcsPower
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStrandSensitivity(t *testing.T) {
+	e := 0.1
+	tests := []struct {
+		n        int
+		expected float64 // probability that a majority of n reads show the mutation
+	}{
+		{1, 1 - e},
+		{2, math.Pow(1-e, 2)},
+		{3, math.Pow(1-e, 3) + 3*math.Pow(1-e, 2)*e},
+		{4, math.Pow(1-e, 4) + 4*math.Pow(1-e, 3)*e},
+		{5, math.Pow(1-e, 5) + 5*math.Pow(1-e, 4)*e + 10*math.Pow(1-e, 3)*e*e},
+	}
+	for _, test := range tests {
+		if actual := strandSensitivity(test.n, e); math.Abs(actual-test.expected) > 1e-12 {
+			t.Errorf("expected %g for %d reads, got %g", test.expected, test.n, actual)
+		}
+	}
+}
+
+func TestZ(t *testing.T) {
+	if actual := zAlpha(0.05); math.Abs(actual-1.959964) > 1e-6 {
+		t.Errorf("expected a two-sided z of 1.959964 at alpha 0.05, got %g", actual)
+	}
+	if actual := zPower(0.8); math.Abs(actual-0.841621) > 1e-6 {
+		t.Errorf("expected a z of 0.841621 at 80%% power, got %g", actual)
+	}
+}
+
+func TestSamplesPerGroup(t *testing.T) {
+	p := powerParams{effect: 2, cv: 0.2, alpha: 0.05, power: 0.8}
+	tests := []struct {
+		expected float64
+		samples  int
+	}{
+		{100, 2}, // 2 * 2.8016^2 * (1/100 + ln(1.04)) / ln(2)^2 = 1.61
+		{10, 5},  // 2 * 2.8016^2 * (1/10 + ln(1.04)) / ln(2)^2 = 4.55
+		{1, 34},  // 2 * 2.8016^2 * (1 + ln(1.04)) / ln(2)^2 = 33.95
+		{0, -1},  // no mutations are expected
+	}
+	for _, test := range tests {
+		if actual := samplesPerGroup(test.expected, p); actual != test.samples {
+			t.Errorf("expected %d samples per group with %g mutations detected, got %d", test.samples, test.expected, actual)
+		}
+	}
+}
+
+func TestCoverageForSamples(t *testing.T) {
+	p := powerParams{burden: 1e-7, sensitivity: 0.9, depthSensitivity: 0.8, effect: 2, cv: 0.2, alpha: 0.05, power: 0.8, samples: 10}
+	coverage := coverageForSamples(p)
+	expected := p.burden * coverage * p.sensitivity * p.depthSensitivity
+	z := zAlpha(p.alpha) + zPower(p.power)
+	if n := 2 * z * z * logVariance(expected, p.cv) / (math.Ln2 * math.Ln2); math.Abs(n-10) > 1e-9 {
+		t.Errorf("expected %g bases of coverage to need 10 samples per group, got %g", coverage, n)
+	}
+	p.cv, p.samples = 2, 2 // biological variation alone needs more samples
+	if coverage = coverageForSamples(p); !math.IsInf(coverage, 1) {
+		t.Errorf("expected no coverage to reach the power with 2 samples, got %g", coverage)
+	}
+}
+
+func TestReadFamilies(t *testing.T) {
+	bedFile := filepath.Join(t.TempDir(), "families.bed")
+	families := "chr1\t0\t100\t1\t0\t+\t2\t2\n" +
+		"chr1\t0\t50\t2\t0\t+\t1\t3\n" + // too few watson reads
+		"chr1\t0\t20\t3\t0\t+\t3\t3\n" + // too short
+		"chr1\t0\t200\t0\t0\t+\t5\t5\n" + // reads without a family
+		"chr1\t0\t300\t4\t0\t+\t3\t2\n"
+	if err := os.WriteFile(bedFile, []byte(families), 0644); err != nil {
+		t.Fatal(err)
+	}
+	e := 0.1
+	s2, s3 := strandSensitivity(2, e), strandSensitivity(3, e)
+	coverage, sensitivity := readFamilies(bedFile, 4, 2, 30, e)
+	if expected := (100*s2*s2 + 300*s3*s2) / 400; coverage != 400 || math.Abs(sensitivity-expected) > 1e-12 {
+		t.Errorf("expected 400 bases with a sensitivity of %g, got %g bases with %g", expected, coverage, sensitivity)
+	}
+}
+
+func TestMcsPower(t *testing.T) {
+	output := filepath.Join(t.TempDir(), "power.tsv")
+	p := powerParams{coverage: 1e8, depthSensitivity: 0.8, burden: 1e-7, genomeSize: 6e9, sensitivity: 0.9, effect: 2, cv: 0.2, alpha: 0.05, power: 0.8, samples: 10}
+	mcsPower(output, "", p)
+	actual, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 1e8 bases * 0.72 sensitivity * 1e-7 mutations per base = 7.2 mutations, found at least once with 1 - exp(-7.2).
+	// 2 * 2.8016^2 * (1/7.2 + ln(1.04)) / ln(2)^2 = 5.82 samples per group, and 10 samples allow a count variance of
+	// 10 * ln(2)^2 / (2 * 2.8016^2) - ln(1.04) = 0.2668, reached with 1 / (0.2668 * 7.2e-8) bases.
+	expected := "Metric\tValue\n" +
+		"DuplexCoverage\t100000000\n" +
+		"DepthSensitivity\t0.8000\n" +
+		"Sensitivity\t0.7200\n" +
+		"EffectiveCoverage\t72000000\n" +
+		"ExpectedMutationsPerGenome\t600.0\n" +
+		"ExpectedMutationsDetected\t7.20\n" +
+		"ProbabilityAtLeastOneDetected\t0.9993\n" +
+		"BurdenRelativeError\t0.3727\n" +
+		"SamplesPerGroup\t6\n" +
+		"CoveragePerSampleForN\t52048722\n" +
+		"CoverageForOneMutation\t13888889\n"
+	if string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
@@ -0,0 +1,268 @@
+package mcsQc
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/schema"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsQc - Compute duplex library QC metrics from a bam and bed file generated with annotateReadFamilies.\n" +
+			"Output is a single TSV (or JSON if -o ends with .json) summarizing the library.\n" +
+			"Usage:\n" +
+			"mcsQc [options] -i annotated.bam -b families.bed > qc.tsv\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsQc with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file annotated with annotateReadFamilies.")
+	bedFile := flag.String("b", "", "Input bed file with read families generated with -bed option in annotateReadFamilies.")
+	targets := flag.String("t", "", "Bed, interval_list, or GFF3 file of targeted regions. If set, on-target rates are reported.")
+	output := flag.String("o", "stdout", "Output file. Written as JSON if the file name ends with .json, otherwise TSV.")
+	sample := flag.String("sample", "", "Sample name to report in output. Defaults to the input bam file name.")
+	totalDepth := flag.Int("a", 8, "Minimum total depth of read family to pass calling thresholds. Should match -a in mcsCallVariants.")
+	strandedDepth := flag.Int("s", 4, "Minimum depth of independent watson and crick strands to pass calling thresholds. Should match -s in mcsCallVariants.")
+	minReadFamilyLength := flag.Int("minReadFamilyLength", 100, "Minimum length in bp of read family to pass calling thresholds. Should match -minReadFamilyLength in mcsCallVariants.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality for a read to be counted as usable.")
+	maxFamilySize := flag.Int("maxFamilySize", 100, "Family sizes larger than this value are grouped into the last bin of the family size distribution.")
+	dryrun.Parse()
+
+	if *input == "" || *bedFile == "" {
+		usage()
+		log.Fatal("ERROR: must specify bam (-i) and bed (-b).")
+	}
+
+	if *sample == "" {
+		*sample = strings.TrimSuffix(filepath.Base(*input), ".bam")
+	}
+
+	mcsQc(*input, *bedFile, *targets, *output, *sample, *totalDepth, *strandedDepth, *minReadFamilyLength, uint8(*minMapQ), *maxFamilySize)
+}
+
+// qcMetrics stores all metrics reported for a single library.
+type qcMetrics struct {
+	schema.Header
+	Sample                  string  `json:"sample"`
+	TotalReads              int     `json:"totalReads"`
+	MappedReads             int     `json:"mappedReads"`
+	UsableReads             int     `json:"usableReads"`
+	AnnotatedReads          int     `json:"annotatedReads"`
+	OnTargetReads           int     `json:"onTargetReads,omitempty"`
+	OnTargetRate            float64 `json:"onTargetRate,omitempty"`
+	Families                int     `json:"families"`
+	DuplexFamilies          int     `json:"duplexFamilies"`
+	DuplexRecoveryRate      float64 `json:"duplexRecoveryRate"`
+	PassingFamilies         int     `json:"passingFamilies"`
+	PassingFamilyFraction   float64 `json:"passingFamilyFraction"`
+	OnTargetFamilies        int     `json:"onTargetFamilies,omitempty"`
+	OnTargetFamilyRate      float64 `json:"onTargetFamilyRate,omitempty"`
+	MeanReadsPerFamily      float64 `json:"meanReadsPerFamily"`
+	MedianReadsPerFamily    int     `json:"medianReadsPerFamily"`
+	MeanWatsonFraction      float64 `json:"meanWatsonFraction"`
+	MeanMinorStrandFraction float64 `json:"meanMinorStrandFraction"`
+	FamilySizeDistribution  []int   `json:"familySizeDistribution"` // index is reads per family, last index includes all larger families
+}
+
+func mcsQc(input, bedFile, targets, output, sample string, minTotalDepth, minStrandedDepth, minReadFamilyLength int, minMapQ uint8, maxFamilySize int) {
+	var targetTree map[string]*interval.IntervalNode
+	if targets != "" {
+		targetTree = interval.BuildTree(interval.BedSliceToIntervals(intervals.ReadMerged(targets)))
+	}
+
+	var m qcMetrics
+	m.Sample = sample
+	m.FamilySizeDistribution = make([]int, maxFamilySize+1)
+	readStats(input, targetTree, minMapQ, &m)
+	familyStats(bedFile, targetTree, minTotalDepth, minStrandedDepth, minReadFamilyLength, &m)
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	if strings.HasSuffix(output, ".json") {
+		m.Header = schema.NewHeader("mcsQc")
+		writeJson(out, m)
+	} else {
+		writeTsv(out, m)
+	}
+}
+
+// readStats streams the input bam and records read-level metrics.
+func readStats(input string, targetTree map[string]*interval.IntervalNode, minMapQ uint8, m *qcMetrics) {
+	reads, _ := hts.GoReadToChan(input)
+	for r := range reads {
+		if sam.IsNotPrimaryAlign(r) || sam.IsSupplementaryAlign(r) {
+			continue
+		}
+		m.TotalReads++
+		if sam.IsUnmapped(r) {
+			continue
+		}
+		m.MappedReads++
+		if r.MapQ < minMapQ {
+			continue
+		}
+		m.UsableReads++
+		sam.ParseExtra(&r)
+		if barcode.GetRF(&r) != "" {
+			m.AnnotatedReads++
+		}
+		if targetTree != nil && len(interval.Query(targetTree, r, "any")) > 0 {
+			m.OnTargetReads++
+		}
+	}
+	if targetTree != nil && m.UsableReads > 0 {
+		m.OnTargetRate = float64(m.OnTargetReads) / float64(m.UsableReads)
+	}
+}
+
+// familyStats reads the family bed and records family-level metrics.
+func familyStats(bedFile string, targetTree map[string]*interval.IntervalNode, minTotalDepth, minStrandedDepth, minReadFamilyLength int, m *qcMetrics) {
+	var watsonDepth, crickDepth, totalReads int
+	var watsonFractionSum, minorFractionSum float64
+	maxBin := len(m.FamilySizeDistribution) - 1
+	families := bed.GoReadToChan(bedFile)
+	for b := range families {
+		if len(b.Annotation) < 2 {
+			exit.Fatalf(exit.MissingTag, "bed record is missing watson and crick read counts. Was it generated with annotateReadFamilies?\n%s", bed.ToString(b, b.FieldsInitialized))
+		}
+		watsonDepth, _ = strconv.Atoi(b.Annotation[0])
+		crickDepth, _ = strconv.Atoi(b.Annotation[1])
+		if watsonDepth+crickDepth == 0 {
+			continue
+		}
+		m.Families++
+		totalReads += watsonDepth + crickDepth
+		m.FamilySizeDistribution[min(watsonDepth+crickDepth, maxBin)]++
+		watsonFractionSum += float64(watsonDepth) / float64(watsonDepth+crickDepth)
+
+		if watsonDepth > 0 && crickDepth > 0 {
+			m.DuplexFamilies++
+			minorFractionSum += float64(min(watsonDepth, crickDepth)) / float64(watsonDepth+crickDepth)
+		}
+
+		if passesThresholds(b, watsonDepth, crickDepth, minTotalDepth, minStrandedDepth, minReadFamilyLength) {
+			m.PassingFamilies++
+		}
+
+		if targetTree != nil && len(interval.Query(targetTree, b, "any")) > 0 {
+			m.OnTargetFamilies++
+		}
+	}
+
+	if m.Families == 0 {
+		log.Println("WARNING: no read families found in", bedFile)
+		return
+	}
+
+	m.DuplexRecoveryRate = float64(m.DuplexFamilies) / float64(m.Families)
+	m.PassingFamilyFraction = float64(m.PassingFamilies) / float64(m.Families)
+	m.MeanReadsPerFamily = float64(totalReads) / float64(m.Families)
+	m.MedianReadsPerFamily = medianFromHist(m.FamilySizeDistribution, m.Families)
+	m.MeanWatsonFraction = watsonFractionSum / float64(m.Families)
+	if m.DuplexFamilies > 0 {
+		m.MeanMinorStrandFraction = minorFractionSum / float64(m.DuplexFamilies)
+	}
+	if targetTree != nil {
+		m.OnTargetFamilyRate = float64(m.OnTargetFamilies) / float64(m.Families)
+	}
+}
+
+// passesThresholds mirrors the depth and length filters applied by mcsCallVariants.
+func passesThresholds(b bed.Bed, watsonDepth, crickDepth, minTotalDepth, minStrandedDepth, minReadFamilyLength int) bool {
+	if b.ChromEnd-b.ChromStart < minReadFamilyLength {
+		return false
+	}
+	if watsonDepth+crickDepth < minTotalDepth {
+		return false
+	}
+	return watsonDepth >= minStrandedDepth && crickDepth >= minStrandedDepth
+}
+
+// medianFromHist returns the median value of a histogram where the index is the value.
+func medianFromHist(hist []int, total int) int {
+	var cumulative int
+	for i := range hist {
+		cumulative += hist[i]
+		if cumulative*2 >= total {
+			return i
+		}
+	}
+	return len(hist) - 1
+}
+
+func writeJson(out io.Writer, m qcMetrics) {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	err := enc.Encode(m)
+	exception.PanicOnErr(err)
+}
+
+func writeTsv(out io.Writer, m qcMetrics) {
+	var err error
+	_, err = fmt.Fprintf(out, "Sample\tMetric\tValue\n")
+	exception.PanicOnErr(err)
+	lines := []struct {
+		name  string
+		value any
+	}{
+		{"TotalReads", m.TotalReads},
+		{"MappedReads", m.MappedReads},
+		{"UsableReads", m.UsableReads},
+		{"AnnotatedReads", m.AnnotatedReads},
+		{"OnTargetReads", m.OnTargetReads},
+		{"OnTargetRate", m.OnTargetRate},
+		{"Families", m.Families},
+		{"DuplexFamilies", m.DuplexFamilies},
+		{"DuplexRecoveryRate", m.DuplexRecoveryRate},
+		{"PassingFamilies", m.PassingFamilies},
+		{"PassingFamilyFraction", m.PassingFamilyFraction},
+		{"OnTargetFamilies", m.OnTargetFamilies},
+		{"OnTargetFamilyRate", m.OnTargetFamilyRate},
+		{"MeanReadsPerFamily", m.MeanReadsPerFamily},
+		{"MedianReadsPerFamily", m.MedianReadsPerFamily},
+		{"MeanWatsonFraction", m.MeanWatsonFraction},
+		{"MeanMinorStrandFraction", m.MeanMinorStrandFraction},
+	}
+	for _, l := range lines {
+		_, err = fmt.Fprintf(out, "%s\t%s\t%v\n", m.Sample, l.name, l.value)
+		exception.PanicOnErr(err)
+	}
+
+	for i := 1; i < len(m.FamilySizeDistribution); i++ {
+		if i == len(m.FamilySizeDistribution)-1 {
+			_, err = fmt.Fprintf(out, "%s\tFamilySize_%d+\t%d\n", m.Sample, i, m.FamilySizeDistribution[i])
+		} else {
+			_, err = fmt.Fprintf(out, "%s\tFamilySize_%d\t%d\n", m.Sample, i, m.FamilySizeDistribution[i])
+		}
+		exception.PanicOnErr(err)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
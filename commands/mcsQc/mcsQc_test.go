@@ -0,0 +1,48 @@
+package mcsQc
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"testing"
+)
+
+func TestPassesThresholds(t *testing.T) {
+	b := bed.Bed{Chrom: "chr1", ChromStart: 100, ChromEnd: 250}
+	tests := []struct {
+		watson, crick       int
+		minTotal, minStrand int
+		minLength           int
+		expected            bool
+	}{
+		{2, 2, 4, 2, 150, true},
+		{3, 1, 4, 2, 0, false},   // crick below the stranded depth
+		{2, 1, 4, 1, 0, false},   // below the total depth
+		{5, 0, 1, 0, 0, true},    // unstranded
+		{2, 2, 4, 2, 151, false}, // shorter than the minimum length
+	}
+	for _, test := range tests {
+		if actual := passesThresholds(b, test.watson, test.crick, test.minTotal, test.minStrand, test.minLength); actual != test.expected {
+			t.Errorf("expected %v for %d watson and %d crick reads with -a %d -s %d -minReadFamilyLength %d, got %v", test.expected, test.watson, test.crick, test.minTotal, test.minStrand, test.minLength, actual)
+		}
+	}
+}
+
+func TestMedianFromHist(t *testing.T) {
+	tests := []struct {
+		hist     []int
+		expected int
+	}{
+		{[]int{0, 1, 1, 1}, 2},
+		{[]int{0, 5, 1, 1}, 1},
+		{[]int{0, 1, 0, 0, 4}, 4},
+		{[]int{0, 2, 2}, 1}, // an even count takes the lower middle value
+	}
+	for _, test := range tests {
+		var total int
+		for _, n := range test.hist {
+			total += n
+		}
+		if actual := medianFromHist(test.hist, total); actual != test.expected {
+			t.Errorf("expected a median of %d for %v, got %d", test.expected, test.hist, actual)
+		}
+	}
+}
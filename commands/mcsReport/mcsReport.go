@@ -0,0 +1,543 @@
+package mcsReport
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/deterministic"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/schema"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"html/template"
+	"io"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsReport - Combine QC metrics, burden estimates, mutation spectra, and stats from a run into a single self-contained HTML report.\n" +
+			"For a single sample, pass files with -qc (mcsQc, TSV or JSON), -burden (mcsBurdenCorrection), and -stats (any\n" +
+			"JSON object of metrics, may be declared more than once). For a cohort, pass a tab-separated manifest with -manifest\n" +
+			"where each line is: sample  qcFile  burdenFile  statsFiles. Use '.' for missing files and ',' to separate stats files.\n" +
+			"Spectra are plotted from the mutation contexts in the mcsBurdenCorrection output (requires -pad 1).\n" +
+			"Usage:\n" +
+			"mcsReport [options] -name sample -qc qc.json -burden burden.txt -o report.html\n" +
+			"mcsReport [options] -manifest samples.tsv -o cohort.html\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsReport with the options in os.Args.
+func Main() {
+	var stats inputFiles
+	name := flag.String("name", "", "Sample name for a single sample report. Defaults to the name of the first input file.")
+	qc := flag.String("qc", "", "QC metrics file generated with mcsQc.")
+	burden := flag.String("burden", "", "Summary file generated with mcsBurdenCorrection.")
+	flag.Var(&stats, "stats", "JSON file with additional metrics. May be declared more than once.")
+	manifest := flag.String("manifest", "", "Tab-separated file with one sample per line for a cohort report. Columns: sample, qc, burden, stats.")
+	title := flag.String("title", "Duplex sequencing report", "Title of the report.")
+	output := flag.String("o", "report.html", "Output HTML file.")
+	dryrun.Parse()
+
+	if *manifest == "" && *qc == "" && *burden == "" && len(stats) == 0 {
+		usage()
+		log.Fatal("ERROR: must specify -manifest or at least one of -qc, -burden, or -stats.")
+	}
+
+	var samples []sampleFiles
+	if *manifest != "" {
+		samples = readManifest(*manifest)
+	} else {
+		if *name == "" {
+			*name = defaultName(*qc, *burden, stats)
+		}
+		samples = []sampleFiles{{name: *name, qc: *qc, burden: *burden, stats: stats}}
+	}
+
+	mcsReport(samples, *title, *output)
+}
+
+// sampleFiles are the inputs for one sample.
+type sampleFiles struct {
+	name   string
+	qc     string
+	burden string
+	stats  []string
+}
+
+// metric is a single named value displayed in a table.
+type metric struct {
+	Name  string
+	Value string
+}
+
+// sampleReport holds the parsed results for one sample.
+type sampleReport struct {
+	Name             string
+	Qc               []metric
+	FamilySizes      []int
+	Stats            []metric
+	HasBurden        bool
+	MutationCount    int
+	AdjMutationCount float64
+	Coverage         int
+	Burden           float64
+	Spectrum         map[string]float64 // keyed by N[X>Y]N context
+	FamilyPlot       template.HTML
+	SpectrumPlot     template.HTML
+}
+
+func mcsReport(samples []sampleFiles, title, output string) {
+	reports := make([]*sampleReport, len(samples))
+	for i, s := range samples {
+		reports[i] = &sampleReport{Name: s.name}
+		if s.qc != "" {
+			reports[i].Qc, reports[i].FamilySizes = readQc(s.qc)
+		}
+		if s.burden != "" {
+			readBurden(s.burden, reports[i])
+		}
+		for _, f := range s.stats {
+			reports[i].Stats = append(reports[i].Stats, readStats(f)...)
+		}
+		reports[i].FamilyPlot = familySizePlot(reports[i].FamilySizes)
+		reports[i].SpectrumPlot = spectrumPlot(reports[i].Spectrum)
+	}
+
+	data := struct {
+		Title      string
+		Generated  string
+		Samples    []*sampleReport
+		Cohort     bool
+		BurdenPlot template.HTML
+		Summary    [][]string
+	}{
+		Title:     title,
+		Generated: time.Now().Format("2006-01-02 15:04"),
+		Samples:   reports,
+		Cohort:    len(reports) > 1,
+	}
+	if deterministic.Enabled() {
+		data.Generated = ""
+	}
+	if data.Cohort {
+		data.BurdenPlot = burdenPlot(reports)
+		data.Summary = summaryTable(reports)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	err := reportTemplate.Execute(out, data)
+	exception.PanicOnErr(err)
+}
+
+func readManifest(filename string) []sampleFiles {
+	var ans []sampleFiles
+	var fields []string
+	for _, line := range fileio.Read(filename) {
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields = strings.Split(line, "\t")
+		if len(fields) < 2 {
+			exit.Fatalf(exit.MalformedInput, "manifest lines must have at least a sample name and one file:\n%s", line)
+		}
+		s := sampleFiles{name: fields[0]}
+		if len(fields) > 1 && fields[1] != "." {
+			s.qc = fields[1]
+		}
+		if len(fields) > 2 && fields[2] != "." {
+			s.burden = fields[2]
+		}
+		if len(fields) > 3 && fields[3] != "." {
+			s.stats = strings.Split(fields[3], ",")
+		}
+		ans = append(ans, s)
+	}
+	return ans
+}
+
+func defaultName(qc, burden string, stats []string) string {
+	for _, f := range append([]string{qc, burden}, stats...) {
+		if f != "" {
+			return strings.Split(filepath.Base(f), ".")[0]
+		}
+	}
+	return "sample"
+}
+
+// readQc parses mcsQc output in either TSV or JSON format.
+func readQc(filename string) ([]metric, []int) {
+	var ans []metric
+	var sizes []int
+	if strings.HasSuffix(filename, ".json") {
+		var m map[string]any
+		data, err := os.ReadFile(filename)
+		exception.PanicOnErr(err)
+		if err = schema.Check(data, "mcsQc"); err != nil {
+			exit.Fatalf(exit.MalformedInput, "could not read QC file %s: %s", filename, err)
+		}
+		err = json.Unmarshal(data, &m)
+		exception.PanicOnErr(err)
+		if dist, ok := m["familySizeDistribution"].([]any); ok {
+			for i := range dist {
+				sizes = append(sizes, int(dist[i].(float64)))
+			}
+			delete(m, "familySizeDistribution")
+		}
+		delete(m, "sample")
+		for _, f := range schema.Fields {
+			delete(m, f)
+		}
+		return flatten(m), sizes
+	}
+
+	var fields []string
+	var idx, count int
+	var err error
+	for _, line := range fileio.Read(filename) {
+		fields = strings.Split(line, "\t")
+		if len(fields) != 3 || fields[1] == "Metric" {
+			continue
+		}
+		if strings.HasPrefix(fields[1], "FamilySize_") {
+			idx, err = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(fields[1], "FamilySize_"), "+"))
+			exception.PanicOnErr(err)
+			count, err = strconv.Atoi(fields[2])
+			exception.PanicOnErr(err)
+			for len(sizes) <= idx {
+				sizes = append(sizes, 0)
+			}
+			sizes[idx] = count
+			continue
+		}
+		ans = append(ans, metric{Name: fields[1], Value: formatValue(fields[2])})
+	}
+	return ans, sizes
+}
+
+// readBurden parses the summary and mutation contexts from mcsBurdenCorrection output.
+func readBurden(filename string, r *sampleReport) {
+	r.HasBurden = true
+	r.Spectrum = make(map[string]float64)
+	var fields []string
+	var err error
+	var count float64
+	file := fileio.EasyOpen(filename)
+	defer cleanup(file)
+	for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) { // mutation contexts are on lines starting with #
+		fields = strings.Split(line, "\t")
+		switch {
+		case strings.HasPrefix(line, "Mutation Count:"):
+			r.MutationCount, err = strconv.Atoi(fields[1])
+		case strings.HasPrefix(line, "Adjusted Mutation Count:"):
+			r.AdjMutationCount, err = strconv.ParseFloat(fields[1], 64)
+		case strings.HasPrefix(line, "Experimental Coverage:"):
+			r.Coverage, err = strconv.Atoi(fields[1])
+		case strings.HasPrefix(line, "Adjusted Mutation Burden:"):
+			r.Burden, err = strconv.ParseFloat(fields[1], 64)
+		case strings.HasPrefix(line, "#MutationContexts#") && len(fields) == 5 && fields[1] != "Variant":
+			if len(fields[2]) != 3 || (fields[1][0] != 'C' && fields[1][0] != 'T') {
+				continue // only trinucleotide contexts on the pyrimidine strand are plotted
+			}
+			count, err = strconv.ParseFloat(fields[3], 64)
+			r.Spectrum[fmt.Sprintf("%c[%s]%c", fields[2][0], fields[1], fields[2][2])] += count
+		}
+		exception.PanicOnErr(err)
+	}
+}
+
+// readStats parses a JSON object of metrics. Nested objects are flattened with dot-separated names.
+func readStats(filename string) []metric {
+	data, err := os.ReadFile(filename)
+	exception.PanicOnErr(err)
+	var m map[string]any
+	err = json.Unmarshal(data, &m)
+	if err != nil {
+		exit.Fatalf(exit.MalformedInput, "could not parse stats file %s: %s", filename, err)
+	}
+	for _, f := range schema.Fields {
+		delete(m, f)
+	}
+	return flatten(m)
+}
+
+func flatten(m map[string]any) []metric {
+	var ans []metric
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		switch v := m[k].(type) {
+		case map[string]any:
+			for _, sub := range flatten(v) {
+				ans = append(ans, metric{Name: k + "." + sub.Name, Value: sub.Value})
+			}
+		case []any:
+			ans = append(ans, metric{Name: k, Value: fmt.Sprintf("%d values", len(v))})
+		case float64:
+			ans = append(ans, metric{Name: k, Value: formatValue(strconv.FormatFloat(v, 'g', -1, 64))})
+		default:
+			ans = append(ans, metric{Name: k, Value: fmt.Sprint(v)})
+		}
+	}
+	return ans
+}
+
+// formatValue rounds floating point values for display.
+func formatValue(s string) string {
+	if _, err := strconv.Atoi(s); err == nil {
+		return s
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.FormatFloat(f, 'g', 4, 64)
+	}
+	return s
+}
+
+func summaryTable(reports []*sampleReport) [][]string {
+	ans := [][]string{{"Sample", "Families", "Duplex families", "Mutations", "Coverage (bp)", "Adjusted burden (per bp)"}}
+	var families, duplex string
+	for _, r := range reports {
+		families, duplex = "-", "-"
+		for _, m := range r.Qc {
+			switch m.Name {
+			case "Families", "families":
+				families = m.Value
+			case "DuplexFamilies", "duplexFamilies":
+				duplex = m.Value
+			}
+		}
+		if r.HasBurden {
+			ans = append(ans, []string{r.Name, families, duplex, strconv.Itoa(r.MutationCount), strconv.Itoa(r.Coverage), strconv.FormatFloat(r.Burden, 'g', 4, 64)})
+		} else {
+			ans = append(ans, []string{r.Name, families, duplex, "-", "-", "-"})
+		}
+	}
+	return ans
+}
+
+var substitutionColors = map[string]string{
+	"C>A": "#1ebff0",
+	"C>G": "#050708",
+	"C>T": "#e62725",
+	"T>A": "#cbcacb",
+	"T>C": "#a1cf64",
+	"T>G": "#edc8c5",
+}
+
+var substitutions = []string{"C>A", "C>G", "C>T", "T>A", "T>C", "T>G"}
+
+// spectrumPlot draws a 96 channel trinucleotide substitution spectrum as an inline SVG.
+func spectrumPlot(spectrum map[string]float64) template.HTML {
+	if len(spectrum) == 0 {
+		return ""
+	}
+	const width, height, left, bottom, top = 960.0, 240.0, 50.0, 40.0, 25.0
+	barWidth := (width - left) / 96
+	var maxVal float64
+	for _, v := range spectrum {
+		maxVal = math.Max(maxVal, v)
+	}
+	if maxVal == 0 {
+		maxVal = 1
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" font-family="sans-serif" font-size="10">`, width, height)
+	fmt.Fprintf(&sb, `<line x1="%g" y1="%g" x2="%g" y2="%g" stroke="black"/>`, left, top, left, height-bottom)
+	fmt.Fprintf(&sb, `<text x="%g" y="%g" text-anchor="end">%g</text>`, left-4, top+4, maxVal)
+	fmt.Fprintf(&sb, `<text x="%g" y="%g" text-anchor="end">0</text>`, left-4, height-bottom)
+	bases := "ACGT"
+	var x, h float64
+	var key string
+	var i int
+	for s, sub := range substitutions {
+		fmt.Fprintf(&sb, `<rect x="%g" y="5" width="%g" height="12" fill="%s"/>`, left+float64(s*16)*barWidth, 16*barWidth-2, substitutionColors[sub])
+		fmt.Fprintf(&sb, `<text x="%g" y="%g" text-anchor="middle">%s</text>`, left+float64(s*16+8)*barWidth, height-8, sub)
+		for _, five := range bases {
+			for _, three := range bases {
+				key = fmt.Sprintf("%c[%s]%c", five, sub, three)
+				x = left + float64(i)*barWidth
+				h = spectrum[key] / maxVal * (height - bottom - top)
+				fmt.Fprintf(&sb, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="%s"><title>%s: %g</title></rect>`,
+					x+0.5, height-bottom-h, barWidth-1, h, substitutionColors[sub], key, spectrum[key])
+				fmt.Fprintf(&sb, `<text x="%.2f" y="%g" font-size="6" text-anchor="middle">%c%c%c</text>`, x+barWidth/2, height-bottom+10, five, sub[0], three)
+				i++
+			}
+		}
+	}
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+// familySizePlot draws the distribution of reads per family as an inline SVG.
+func familySizePlot(sizes []int) template.HTML {
+	if len(sizes) < 2 {
+		return ""
+	}
+	const width, height, left, bottom, top = 600.0, 200.0, 60.0, 30.0, 10.0
+	sizes = sizes[1:] // index 0 is empty
+	barWidth := (width - left) / float64(len(sizes))
+	var maxVal int
+	for _, v := range sizes {
+		maxVal = max(maxVal, v)
+	}
+	if maxVal == 0 {
+		maxVal = 1
+	}
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" font-family="sans-serif" font-size="10">`, width, height)
+	fmt.Fprintf(&sb, `<text x="%g" y="%g" text-anchor="end">%d</text>`, left-4, top+4, maxVal)
+	fmt.Fprintf(&sb, `<text x="%g" y="%g" text-anchor="end">0</text>`, left-4, height-bottom)
+	var h float64
+	for i, v := range sizes {
+		h = float64(v) / float64(maxVal) * (height - bottom - top)
+		fmt.Fprintf(&sb, `<rect x="%.2f" y="%.2f" width="%.2f" height="%.2f" fill="#4a7ab5"><title>%d reads: %d families</title></rect>`,
+			left+float64(i)*barWidth, height-bottom-h, math.Max(barWidth-1, 0.5), h, i+1, v)
+	}
+	fmt.Fprintf(&sb, `<text x="%g" y="%g">1</text>`, left, height-bottom+12)
+	fmt.Fprintf(&sb, `<text x="%g" y="%g" text-anchor="end">%d+</text>`, width, height-bottom+12, len(sizes))
+	fmt.Fprintf(&sb, `<text x="%g" y="%g" text-anchor="middle">Reads per family</text>`, left+(width-left)/2, height-4)
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+// burdenPlot draws the adjusted mutation burden of each sample as an inline SVG.
+func burdenPlot(reports []*sampleReport) template.HTML {
+	var maxVal float64
+	var hasBurden bool
+	for _, r := range reports {
+		if r.HasBurden {
+			hasBurden = true
+			maxVal = math.Max(maxVal, r.Burden)
+		}
+	}
+	if !hasBurden {
+		return ""
+	}
+	if maxVal == 0 {
+		maxVal = 1
+	}
+	const rowHeight, left, width = 20.0, 150.0, 700.0
+	height := rowHeight*float64(len(reports)) + 10
+	var sb strings.Builder
+	fmt.Fprintf(&sb, `<svg xmlns="http://www.w3.org/2000/svg" width="%g" height="%g" font-family="sans-serif" font-size="11">`, width, height)
+	var w float64
+	for i, r := range reports {
+		y := float64(i) * rowHeight
+		fmt.Fprintf(&sb, `<text x="%g" y="%g" text-anchor="end">%s</text>`, left-6, y+14, template.HTMLEscapeString(r.Name))
+		if !r.HasBurden {
+			continue
+		}
+		w = r.Burden / maxVal * (width - left - 90)
+		fmt.Fprintf(&sb, `<rect x="%g" y="%g" width="%.2f" height="%g" fill="#e62725"/>`, left, y+3, w, rowHeight-6)
+		fmt.Fprintf(&sb, `<text x="%.2f" y="%g">%.3g</text>`, left+w+4, y+14, r.Burden)
+	}
+	sb.WriteString(`</svg>`)
+	return template.HTML(sb.String())
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+h1 { margin-bottom: 0; }
+h2 { border-bottom: 1px solid #ccc; margin-top: 2em; }
+table { border-collapse: collapse; margin: 1em 0; }
+td, th { border: 1px solid #ddd; padding: 4px 10px; text-align: left; }
+th { background: #f4f4f4; }
+.meta { color: #777; }
+.panel { display: inline-block; vertical-align: top; margin-right: 2em; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Generated}}<p class="meta">Generated {{.Generated}}</p>{{end}}
+{{if .Cohort}}
+<h2>Cohort summary</h2>
+<table>
+{{range $i, $row := .Summary}}<tr>{{range $row}}{{if eq $i 0}}<th>{{.}}</th>{{else}}<td>{{.}}</td>{{end}}{{end}}</tr>
+{{end}}</table>
+{{if .BurdenPlot}}<h3>Adjusted mutation burden</h3>
+{{.BurdenPlot}}{{end}}
+{{end}}
+{{range .Samples}}
+<h2>{{.Name}}</h2>
+{{if .HasBurden}}
+<div class="panel">
+<h3>Mutation burden</h3>
+<table>
+<tr><th>Mutations</th><td>{{.MutationCount}}</td></tr>
+<tr><th>Adjusted mutations</th><td>{{printf "%.2f" .AdjMutationCount}}</td></tr>
+<tr><th>Coverage (bp)</th><td>{{.Coverage}}</td></tr>
+<tr><th>Adjusted burden (per bp)</th><td>{{printf "%.4g" .Burden}}</td></tr>
+</table>
+</div>
+{{end}}
+{{if .Qc}}
+<div class="panel">
+<h3>Library QC</h3>
+<table>
+{{range .Qc}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+</div>
+{{end}}
+{{if .FamilyPlot}}
+<div class="panel">
+<h3>Family size distribution</h3>
+{{.FamilyPlot}}
+</div>
+{{end}}
+{{if .SpectrumPlot}}
+<h3>Trinucleotide mutation spectrum</h3>
+{{.SpectrumPlot}}
+{{end}}
+{{if .Stats}}
+<h3>Additional metrics</h3>
+<table>
+{{range .Stats}}<tr><th>{{.Name}}</th><td>{{.Value}}</td></tr>
+{{end}}</table>
+{{end}}
+{{end}}
+</body>
+</html>
+`))
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
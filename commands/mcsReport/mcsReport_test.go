@@ -0,0 +1,135 @@
+package mcsReport
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadManifest(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "manifest.tsv")
+	data := "#sample\tqc\tburden\tstats\n" +
+		"a\ta.qc.tsv\ta.burden.txt\ta.stats.json,a.calls.json\n" +
+		"b\t.\tb.burden.txt\n" +
+		"\n" +
+		"c\tc.qc.json\t.\t.\n"
+	if err := os.WriteFile(manifest, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	expected := []sampleFiles{
+		{name: "a", qc: "a.qc.tsv", burden: "a.burden.txt", stats: []string{"a.stats.json", "a.calls.json"}},
+		{name: "b", burden: "b.burden.txt"},
+		{name: "c", qc: "c.qc.json"},
+	}
+	if actual := readManifest(manifest); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestDefaultName(t *testing.T) {
+	tests := []struct {
+		qc, burden string
+		stats      []string
+		expected   string
+	}{
+		{"dir/s1.qc.tsv", "s2.burden.txt", nil, "s1"},
+		{"", "dir/s2.burden.txt", nil, "s2"},
+		{"", "", []string{"s3.stats.json"}, "s3"},
+		{"", "", nil, "sample"},
+	}
+	for _, test := range tests {
+		if actual := defaultName(test.qc, test.burden, test.stats); actual != test.expected {
+			t.Errorf("expected %s for %q %q %v, got %s", test.expected, test.qc, test.burden, test.stats, actual)
+		}
+	}
+}
+
+func TestReadQc(t *testing.T) {
+	qc := filepath.Join(t.TempDir(), "s.qc.tsv")
+	data := "Sample\tMetric\tValue\n" +
+		"s\tFamilies\t120\n" +
+		"s\tDuplexRate\t0.123456\n" +
+		"s\tFamilySize_1\t40\n" +
+		"s\tFamilySize_3+\t7\n"
+	if err := os.WriteFile(qc, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	metrics, sizes := readQc(qc)
+	if expected := []metric{{"Families", "120"}, {"DuplexRate", "0.1235"}}; !reflect.DeepEqual(metrics, expected) {
+		t.Errorf("expected metrics %v, got %v", expected, metrics)
+	}
+	if expected := []int{0, 40, 0, 7}; !reflect.DeepEqual(sizes, expected) {
+		t.Errorf("expected family sizes %v, got %v", expected, sizes)
+	}
+}
+
+func TestReadBurden(t *testing.T) {
+	burden := filepath.Join(t.TempDir(), "s.burden.txt")
+	data := "Mutation Count:\t12\n" +
+		"Adjusted Mutation Count:\t13.5\n" +
+		"Experimental Coverage:\t1000000\n" +
+		"Adjusted Mutation Burden:\t1.35e-05\n" +
+		"#MutationContexts#\tVariant\tContext\tCount\tFraction\n" +
+		"#MutationContexts#\tC>T\tACG\t5\t0.4\n" +
+		"#MutationContexts#\tC>T\tACG\t1\t0.1\n" +
+		"#MutationContexts#\tT>G\tATA\t2\t0.2\n" +
+		"#MutationContexts#\tG>A\tCGT\t4\t0.3\n" // purine strand, not plotted
+	if err := os.WriteFile(burden, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var r sampleReport
+	readBurden(burden, &r)
+	if !r.HasBurden || r.MutationCount != 12 || r.AdjMutationCount != 13.5 || r.Coverage != 1000000 || r.Burden != 1.35e-05 {
+		t.Errorf("unexpected burden summary: %+v", r)
+	}
+	if expected := map[string]float64{"A[C>T]G": 6, "A[T>G]A": 2}; !reflect.DeepEqual(r.Spectrum, expected) {
+		t.Errorf("expected spectrum %v, got %v", expected, r.Spectrum)
+	}
+}
+
+func TestFlatten(t *testing.T) {
+	m := map[string]any{
+		"reads":  float64(1000),
+		"rate":   0.333333,
+		"name":   "s",
+		"sizes":  []any{1.0, 2.0, 3.0},
+		"strand": map[string]any{"watson": float64(3), "crick": float64(4)},
+	}
+	expected := []metric{{"name", "s"}, {"rate", "0.3333"}, {"reads", "1000"}, {"sizes", "3 values"}, {"strand.crick", "4"}, {"strand.watson", "3"}}
+	if actual := flatten(m); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected string
+	}{
+		{"42", "42"},
+		{"3.14159", "3.142"},
+		{"1.5e-07", "1.5e-07"},
+		{"NA", "NA"},
+	}
+	for _, test := range tests {
+		if actual := formatValue(test.value); actual != test.expected {
+			t.Errorf("expected %s for %s, got %s", test.expected, test.value, actual)
+		}
+	}
+}
+
+func TestSummaryTable(t *testing.T) {
+	reports := []*sampleReport{
+		{Name: "a", Qc: []metric{{"Families", "100"}, {"DuplexFamilies", "60"}}, HasBurden: true, MutationCount: 3, Coverage: 5000, Burden: 6e-4},
+		{Name: "b", Qc: []metric{{"families", "80"}}},
+	}
+	expected := [][]string{
+		{"Sample", "Families", "Duplex families", "Mutations", "Coverage (bp)", "Adjusted burden (per bp)"},
+		{"a", "100", "60", "3", "5000", "0.0006"},
+		{"b", "80", "-", "-", "-", "-"},
+	}
+	if actual := summaryTable(reports); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, got %v", expected, actual)
+	}
+}
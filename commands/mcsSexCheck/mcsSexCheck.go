@@ -0,0 +1,266 @@
+package mcsSexCheck
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"io"
+	"log"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsSexCheck - Infer sex chromosome karyotype from duplex read family counts and flag mismatches with declared sample sex.\n" +
+			"Passing read families (-a, -s, -minReadFamilyLength) are counted on chrX, chrY, and the autosomes and normalized by\n" +
+			"chromosome length. The X ratio (X density / autosome density) is ~1 for XX and ~0.5 for XY. The Y ratio is ~0 without\n" +
+			"a Y chromosome. The karyotype is XX, XY, X0, or XXY based on -xThreshold and -yThreshold.\n" +
+			"Declared sex is given with -sex for a single sample or with -metadata, a tab separated file of sample name and sex\n" +
+			"(M, F, male, female, XX, XY, or a karyotype). Sample names are the bed file names without the .bed suffix.\n" +
+			"Usage:\n" +
+			"mcsSexCheck [options] -b families.bed -r ref.fa -sex F > sexCheck.txt\n" +
+			"mcsSexCheck [options] -b a.bed -b b.bed -r ref.fa -metadata samples.txt -failOnMismatch > sexCheck.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsSexCheck with the options in os.Args.
+func Main() {
+	var bedFiles inputFiles
+	flag.Var(&bedFiles, "b", "Input bed file with read families generated with -bed option in annotateReadFamilies. May be declared more than once.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai). Used for chromosome lengths.")
+	output := flag.String("o", "stdout", "Output file.")
+	sex := flag.String("sex", "", "Declared sex of the sample. Only valid with a single bed file.")
+	metadata := flag.String("metadata", "", "Tab separated file with sample name and declared sex on each line.")
+	xThreshold := flag.Float64("xThreshold", 0.75, "Samples with an X ratio at or above this value have two X chromosomes.")
+	yThreshold := flag.Float64("yThreshold", 0.1, "Samples with a Y ratio at or above this value have a Y chromosome.")
+	failOnMismatch := flag.Bool("failOnMismatch", false, "Exit with an error if any inferred karyotype does not match the declared sex.")
+	totalDepth := flag.Int("a", 8, "Minimum total depth of read family to pass. Should match -a in mcsCallVariants.")
+	strandedDepth := flag.Int("s", 4, "Minimum depth of independent watson and crick strands to pass. Should match -s in mcsCallVariants.")
+	minReadFamilyLength := flag.Int("minReadFamilyLength", 100, "Minimum length in bp of read family to pass. Should match -minReadFamilyLength in mcsCallVariants.")
+	dryrun.Parse()
+
+	if len(bedFiles) == 0 || *ref == "" {
+		usage()
+		log.Fatal("ERROR: must specify at least one family bed file (-b) and a reference (-r).")
+	}
+
+	if *sex != "" && (len(bedFiles) > 1 || *metadata != "") {
+		usage()
+		log.Fatal("ERROR: -sex may only be used with a single bed file and without -metadata.")
+	}
+
+	declared := make(map[string]string)
+	if *sex != "" {
+		declared[sampleName(bedFiles[0])] = normalizeSex(*sex)
+	}
+	if *metadata != "" {
+		declared = readMetadata(*metadata)
+	}
+
+	s := sexParams{
+		xThreshold:          *xThreshold,
+		yThreshold:          *yThreshold,
+		minTotalDepth:       *totalDepth,
+		minStrandedDepth:    *strandedDepth,
+		minReadFamilyLength: *minReadFamilyLength,
+	}
+
+	mismatches := mcsSexCheck(bedFiles, *ref, *output, declared, s)
+	if mismatches > 0 && *failOnMismatch {
+		log.Fatalf("ERROR: %d samples have an inferred karyotype that does not match the declared sex.", mismatches)
+	}
+}
+
+// sexParams stores the options for inferring karyotype.
+type sexParams struct {
+	xThreshold          float64
+	yThreshold          float64
+	minTotalDepth       int
+	minStrandedDepth    int
+	minReadFamilyLength int
+}
+
+// chromClass is the class of each chromosome used for counting.
+type chromClass byte
+
+const (
+	other chromClass = iota
+	autosome
+	chrX
+	chrY
+)
+
+// mcsSexCheck writes the inferred karyotype of each sample and returns the number of samples that do not match the declared sex.
+func mcsSexCheck(bedFiles []string, ref, output string, declared map[string]string, s sexParams) int {
+	idx := fai.ReadIndex(ref + ".fai")
+	classes := make(map[string]chromClass)
+	var lengths [4]int
+	for _, chr := range idx.Names() {
+		classes[chr] = classify(chr)
+		lengths[classes[chr]] += idx.Size(chr)
+	}
+	if lengths[autosome] == 0 || lengths[chrX] == 0 || lengths[chrY] == 0 {
+		log.Fatalf("ERROR: could not find autosomes, chrX, and chrY in %s.fai.", ref)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Sample\tAutosomeFamilies\tXFamilies\tYFamilies\tXRatio\tYRatio\tInferredKaryotype\tDeclaredSex\tStatus")
+	exception.PanicOnErr(err)
+
+	var counts [4]int
+	var xRatio, yRatio float64
+	var mismatches int
+	var name, karyotype, expected, status string
+	var found bool
+	for _, bedFile := range bedFiles {
+		name = sampleName(bedFile)
+		counts = countFamilies(bedFile, classes, s)
+		if counts[autosome] == 0 {
+			log.Printf("WARNING: no passing read families on autosomes in %s. Karyotype cannot be inferred.", bedFile)
+		}
+		autosomeDensity := float64(counts[autosome]) / float64(lengths[autosome])
+		xRatio = float64(counts[chrX]) / float64(lengths[chrX]) / autosomeDensity
+		yRatio = float64(counts[chrY]) / float64(lengths[chrY]) / autosomeDensity
+		karyotype = inferKaryotype(xRatio, yRatio, counts[autosome] > 0, s)
+
+		expected, found = declared[name]
+		switch {
+		case !found || expected == "":
+			expected, status = ".", "UNKNOWN"
+		case karyotype == "UNKNOWN":
+			status = "UNKNOWN"
+		case karyotype == expected:
+			status = "PASS"
+		default:
+			status = "MISMATCH"
+			mismatches++
+			log.Printf("WARNING: %s was declared %s but the inferred karyotype is %s (X ratio %.3f, Y ratio %.3f).", name, expected, karyotype, xRatio, yRatio)
+		}
+		_, err = fmt.Fprintf(out, "%s\t%d\t%d\t%d\t%.4f\t%.4f\t%s\t%s\t%s\n", name, counts[autosome], counts[chrX], counts[chrY],
+			xRatio, yRatio, karyotype, expected, status)
+		exception.PanicOnErr(err)
+	}
+	return mismatches
+}
+
+// countFamilies returns the number of passing read families in each chromosome class.
+func countFamilies(bedFile string, classes map[string]chromClass, s sexParams) [4]int {
+	var ans [4]int
+	var watson, crick int
+	var err error
+	for b := range bed.GoReadToChan(bedFile) {
+		if b.Name == "0" { // family 0 collects reads not assigned to a family
+			continue
+		}
+		if len(b.Annotation) < 2 {
+			exit.Fatalf(exit.MissingTag, "bed record is missing watson and crick read counts. Was it generated with annotateReadFamilies?\n%s", bed.ToString(b, b.FieldsInitialized))
+		}
+		if b.ChromEnd-b.ChromStart < s.minReadFamilyLength {
+			continue
+		}
+		watson, err = strconv.Atoi(b.Annotation[0])
+		exception.PanicOnErr(err)
+		crick, err = strconv.Atoi(b.Annotation[1])
+		exception.PanicOnErr(err)
+		if watson+crick < s.minTotalDepth || watson < s.minStrandedDepth || crick < s.minStrandedDepth {
+			continue
+		}
+		ans[classes[b.Chrom]]++
+	}
+	return ans
+}
+
+// inferKaryotype returns the sex chromosome karyotype from the X and Y ratios.
+func inferKaryotype(xRatio, yRatio float64, hasAutosomes bool, s sexParams) string {
+	if !hasAutosomes {
+		return "UNKNOWN"
+	}
+	twoX, hasY := xRatio >= s.xThreshold, yRatio >= s.yThreshold
+	switch {
+	case twoX && hasY:
+		return "XXY"
+	case twoX:
+		return "XX"
+	case hasY:
+		return "XY"
+	default:
+		return "X0"
+	}
+}
+
+// classify returns the class of a chromosome from its name, with or without a chr prefix.
+func classify(chr string) chromClass {
+	name := strings.TrimPrefix(chr, "chr")
+	switch name {
+	case "X":
+		return chrX
+	case "Y":
+		return chrY
+	}
+	if _, err := strconv.Atoi(name); err == nil {
+		return autosome
+	}
+	return other
+}
+
+// normalizeSex converts a declared sex to a karyotype. Empty or unrecognized values are returned unchanged.
+func normalizeSex(sex string) string {
+	switch strings.ToUpper(strings.TrimSpace(sex)) {
+	case "M", "MALE", "XY":
+		return "XY"
+	case "F", "FEMALE", "XX":
+		return "XX"
+	case "", ".", "NA", "UNKNOWN":
+		return ""
+	}
+	return strings.ToUpper(strings.TrimSpace(sex))
+}
+
+// readMetadata reads sample names and declared sex from a tab separated file.
+func readMetadata(filename string) map[string]string {
+	ans := make(map[string]string)
+	var words []string
+	for _, line := range fileio.Read(filename) {
+		if line == "" {
+			continue
+		}
+		words = strings.Split(line, "\t")
+		if len(words) < 2 {
+			exit.Fatalf(exit.MalformedInput, "could not parse line in %s. Must have sample name and sex separated by a tab.\n%s", filename, line)
+		}
+		ans[words[0]] = normalizeSex(words[1])
+	}
+	return ans
+}
+
+// sampleName returns the bed file name without the directory or .bed suffix.
+func sampleName(bedFile string) string {
+	return strings.TrimSuffix(filepath.Base(bedFile), ".bed")
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,126 @@
+package mcsSexCheck
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		chr      string
+		expected chromClass
+	}{
+		{"chr1", autosome},
+		{"22", autosome},
+		{"chrX", chrX},
+		{"X", chrX},
+		{"chrY", chrY},
+		{"chrM", other},
+		{"chr1_KI270706v1_random", other},
+	}
+	for _, test := range tests {
+		if actual := classify(test.chr); actual != test.expected {
+			t.Errorf("expected class %d for %s, got %d", test.expected, test.chr, actual)
+		}
+	}
+}
+
+func TestNormalizeSex(t *testing.T) {
+	tests := []struct {
+		sex      string
+		expected string
+	}{
+		{"M", "XY"},
+		{" female ", "XX"},
+		{"xy", "XY"},
+		{"NA", ""},
+		{".", ""},
+		{"xxy", "XXY"},
+	}
+	for _, test := range tests {
+		if actual := normalizeSex(test.sex); actual != test.expected {
+			t.Errorf("expected %q for %q, got %q", test.expected, test.sex, actual)
+		}
+	}
+}
+
+func TestInferKaryotype(t *testing.T) {
+	s := sexParams{xThreshold: 0.75, yThreshold: 0.1}
+	tests := []struct {
+		xRatio, yRatio float64
+		hasAutosomes   bool
+		expected       string
+	}{
+		{1, 0, true, "XX"},
+		{0.5, 0.5, true, "XY"},
+		{0.75, 0.1, true, "XXY"}, // at both thresholds
+		{0.5, 0.01, true, "X0"},
+		{1, 0, false, "UNKNOWN"},
+	}
+	for _, test := range tests {
+		if actual := inferKaryotype(test.xRatio, test.yRatio, test.hasAutosomes, s); actual != test.expected {
+			t.Errorf("expected %s for X ratio %g and Y ratio %g, got %s", test.expected, test.xRatio, test.yRatio, actual)
+		}
+	}
+}
+
+func TestMcsSexCheck(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		filename := filepath.Join(dir, name)
+		if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return filename
+	}
+	// families returns n passing families on chr
+	families := func(chr string, n int) string {
+		var sb strings.Builder
+		for i := 0; i < n; i++ {
+			fmt.Fprintf(&sb, "%s\t%d\t%d\t%s%d\t0\t+\t4\t4\n", chr, 10*i, 10*i+200, chr, i+1)
+		}
+		return sb.String()
+	}
+	ref := filepath.Join(dir, "ref.fa")
+	write("ref.fa.fai", "chr1\t1000\t6\t60\t61\nchr2\t1000\t1030\t60\t61\nchrX\t1000\t2054\t60\t61\nchrY\t500\t3078\t60\t61\nchrM\t100\t3592\t60\t61\n")
+
+	// 10 families on 2000 bp of autosomes, so one family per 1000 bp of X or Y is a ratio of 0.2
+	female := write("female.bed", families("chr1", 6)+families("chr2", 4)+families("chrX", 4)+
+		"chrY\t0\t200\ty1\t0\t+\t2\t2\n"+ // too few reads
+		"chrY\t0\t50\ty2\t0\t+\t4\t4\n") // too short
+	male := write("male.bed", families("chr1", 10)+families("chrX", 2)+families("chrY", 1)+families("chrM", 5)+
+		"chr1\t0\t1000\t0\t0\t+\t100\t100\n") // reads without a family
+	unknown := write("unknown.bed", families("chrX", 2))
+	declared := map[string]string{"female": "XX", "male": "XX"}
+
+	output := filepath.Join(dir, "sex.tsv")
+	s := sexParams{xThreshold: 0.75, yThreshold: 0.1, minTotalDepth: 8, minStrandedDepth: 4, minReadFamilyLength: 100}
+	if mismatches := mcsSexCheck([]string{female, male, unknown}, ref, output, declared, s); mismatches != 1 {
+		t.Errorf("expected 1 mismatch, got %d", mismatches)
+	}
+	expected := "Sample\tAutosomeFamilies\tXFamilies\tYFamilies\tXRatio\tYRatio\tInferredKaryotype\tDeclaredSex\tStatus\n" +
+		"female\t10\t4\t0\t0.8000\t0.0000\tXX\tXX\tPASS\n" +
+		"male\t10\t2\t1\t0.4000\t0.4000\tXY\tXX\tMISMATCH\n" +
+		"unknown\t0\t2\t0\t+Inf\tNaN\tUNKNOWN\t.\tUNKNOWN\n"
+	actual, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestReadMetadata(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "metadata.tsv")
+	if err := os.WriteFile(filename, []byte("s1\tMale\n\ns2\tF\ts2 notes\ns3\tNA\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	actual := readMetadata(filename)
+	if len(actual) != 3 || actual["s1"] != "XY" || actual["s2"] != "XX" || actual["s3"] != "" {
+		t.Errorf("expected s1 XY, s2 XX, and s3 without a declared sex, got %v", actual)
+	}
+}
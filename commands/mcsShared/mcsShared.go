@@ -0,0 +1,278 @@
+package mcsShared
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsShared - Classify variants called in many single cells from one individual as private, shared, or germline-like.\n" +
+			"A variant is germline-like if it is present in at least -germlineFrac of samples, is present in the bulk vcf (-g),\n" +
+			"or has an alt allele fraction of at least -minBulkAf in the bulk bam (-b). Remaining variants are private if\n" +
+			"found in a single sample or shared if found in more than one.\n" +
+			"Output is a matrix with one row per variant and one column per sample (1 = called, 0 = not called).\n" +
+			"Usage:\n" +
+			"mcsShared [options] -i cell1.vcf -i cell2.vcf -i cell3.vcf > matrix.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsShared with the options in os.Args.
+func Main() {
+	var inputs inputFiles
+	flag.Var(&inputs, "i", "Input VCF file with variant calls from a single cell. Must be declared more than once.")
+	bulkBam := flag.String("b", "", "BAM file from bulk tissue of the same individual. Must be indexed (.bai).")
+	bulkVcf := flag.String("g", "", "VCF file with germline variants from bulk sequencing.")
+	output := flag.String("o", "stdout", "Output matrix file.")
+	germlineFrac := flag.Float64("germlineFrac", 0.5, "Variants present in at least this fraction of samples are classified as germline-like.")
+	minBulkAf := flag.Float64("minBulkAf", 0.2, "Variants with at least this alt allele fraction in the bulk bam are classified as germline-like.")
+	minBulkDepth := flag.Int("minBulkDepth", 10, "Minimum read depth in the bulk bam for -minBulkAf to be applied.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality for bulk reads.")
+	minBaseQuality := flag.Int("minBaseQuality", 20, "Minimum base quality for bulk reads.")
+	passOnly := flag.Bool("passOnly", false, "Only consider calls with FILTER of PASS or '.'.")
+	dryrun.Parse()
+
+	if len(inputs) < 2 {
+		usage()
+		log.Fatal("ERROR: must specify at least two input vcf files (-i).")
+	}
+
+	mcsShared(inputs, *bulkBam, *bulkVcf, *output, *germlineFrac, *minBulkAf, *minBulkDepth, uint8(*minMapQ), uint8(*minBaseQuality), *passOnly)
+}
+
+// sharedVariant stores the presence of a variant across all input samples.
+type sharedVariant struct {
+	chr       string
+	pos       int
+	ref       string
+	alt       string
+	present   []bool
+	count     int
+	inBulkVcf bool
+	bulkAlt   int
+	bulkDepth int
+	class     string
+}
+
+func mcsShared(inputs []string, bulkBam, bulkVcf, output string, germlineFrac, minBulkAf float64, minBulkDepth int, minMapQ, minBaseQuality uint8, passOnly bool) {
+	variants := make(map[string]*sharedVariant)
+	sampleNames := make([]string, len(inputs))
+	var key, ref string
+	var sv *sharedVariant
+	var found bool
+	for i := range inputs {
+		records, header := vcf.GoReadToChan(inputs[i])
+		sampleNames[i] = sampleName(inputs[i], header)
+		for v := range records {
+			if passOnly && v.Filter != "PASS" && v.Filter != "." {
+				continue
+			}
+			for _, alt := range v.Alt {
+				ref, alt = trimSuffix(v.Ref, alt)
+				key = variantKey(v.Chr, v.Pos, ref, alt)
+				if sv, found = variants[key]; !found {
+					sv = &sharedVariant{chr: v.Chr, pos: v.Pos, ref: ref, alt: alt, present: make([]bool, len(inputs))}
+					variants[key] = sv
+				}
+				if !sv.present[i] {
+					sv.present[i] = true
+					sv.count++
+				}
+			}
+		}
+	}
+
+	if bulkVcf != "" {
+		germline, _ := vcf.GoReadToChan(bulkVcf)
+		for v := range germline {
+			for _, alt := range v.Alt {
+				ref, alt = trimSuffix(v.Ref, alt)
+				if sv, found = variants[variantKey(v.Chr, v.Pos, ref, alt)]; found {
+					sv.inBulkVcf = true
+				}
+			}
+		}
+	}
+
+	sorted := make([]*sharedVariant, 0, len(variants))
+	for _, sv = range variants {
+		sorted = append(sorted, sv)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].chr != sorted[j].chr {
+			return sorted[i].chr < sorted[j].chr
+		}
+		if sorted[i].pos != sorted[j].pos {
+			return sorted[i].pos < sorted[j].pos
+		}
+		return sorted[i].alt < sorted[j].alt
+	})
+
+	if bulkBam != "" {
+		annotateBulk(sorted, bulkBam, minMapQ, minBaseQuality)
+	}
+
+	for _, sv = range sorted {
+		sv.class = classify(sv, len(inputs), germlineFrac, minBulkAf, minBulkDepth)
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	writeMatrix(out, sorted, sampleNames)
+}
+
+// classify returns the class of a variant found in numSamples cells: germline-like if it
+// is in at least germlineFrac of the cells, in the bulk vcf, or above minBulkAf in the
+// bulk bam, otherwise private to one cell or shared.
+func classify(sv *sharedVariant, numSamples int, germlineFrac, minBulkAf float64, minBulkDepth int) string {
+	switch {
+	case float64(sv.count) >= germlineFrac*float64(numSamples),
+		sv.inBulkVcf,
+		sv.bulkDepth >= minBulkDepth && float64(sv.bulkAlt) >= minBulkAf*float64(sv.bulkDepth):
+		return "germline-like"
+	case sv.count == 1:
+		return "private"
+	default:
+		return "shared"
+	}
+}
+
+// annotateBulk counts bulk reads supporting the alt allele and total bulk depth for each variant.
+func annotateBulk(variants []*sharedVariant, bulkBam string, minMapQ, minBaseQuality uint8) {
+	br, _ := sam.OpenBam(bulkBam)
+	defer cleanup(br)
+	idx := bai.Read(bulkBam)
+	var reads []sam.Sam
+	for _, sv := range variants {
+		reads = sam.SeekBamRegionRecycle(br, idx, sv.chr, uint32(sv.pos-1), uint32(sv.pos+len(sv.ref)), reads)
+		for i := range reads {
+			if reads[i].MapQ < minMapQ || sam.IsUnmapped(reads[i]) || sam.IsNotPrimaryAlign(reads[i]) || sam.IsSupplementaryAlign(reads[i]) || sam.IsDuplicate(reads[i]) {
+				continue
+			}
+			switch supportsAlt(reads[i], sv, minBaseQuality) {
+			case 1:
+				sv.bulkAlt++
+				sv.bulkDepth++
+			case 0:
+				sv.bulkDepth++
+			}
+		}
+	}
+}
+
+// supportsAlt returns 1 if the read supports the alt allele, 0 if the read does not support the alt allele,
+// and -1 if the read is not informative (e.g. does not cover the site or has a low quality base).
+func supportsAlt(r sam.Sam, sv *sharedVariant, minBaseQuality uint8) int {
+	refPos := int(r.Pos)
+	var queryPos int
+	isSnv := len(sv.ref) == 1 && len(sv.alt) == 1
+	insLen := len(sv.alt) - len(sv.ref)
+	if r.GetChromStart() >= sv.pos || r.GetChromEnd() <= sv.pos {
+		return -1
+	}
+	for _, c := range r.Cigar {
+		switch c.Op {
+		case 'M', '=', 'X':
+			if isSnv && sv.pos >= refPos && sv.pos < refPos+c.RunLength {
+				idx := queryPos + sv.pos - refPos
+				if r.Qual[idx]-33 < minBaseQuality {
+					return -1
+				}
+				if dna.ToUpper(r.Seq[idx]) == dna.StringToBase(strings.ToUpper(sv.alt)) {
+					return 1
+				}
+				return 0
+			}
+			refPos += c.RunLength
+			queryPos += c.RunLength
+		case 'D', 'N':
+			if !isSnv && refPos == sv.pos+1 && c.Op == 'D' && -c.RunLength == insLen {
+				return 1
+			}
+			refPos += c.RunLength
+		case 'I':
+			if !isSnv && refPos == sv.pos+1 && c.RunLength == insLen {
+				return 1
+			}
+			queryPos += c.RunLength
+		case 'S':
+			queryPos += c.RunLength
+		}
+	}
+	if isSnv {
+		return -1
+	}
+	return 0
+}
+
+// trimSuffix removes trailing bases shared by ref and alt so that the same indel
+// written with different amounts of trailing context matches across samples.
+func trimSuffix(ref, alt string) (string, string) {
+	for len(ref) > 1 && len(alt) > 1 && ref[len(ref)-1] == alt[len(alt)-1] {
+		ref = ref[:len(ref)-1]
+		alt = alt[:len(alt)-1]
+	}
+	return ref, alt
+}
+
+func variantKey(chr string, pos int, ref, alt string) string {
+	return fmt.Sprintf("%s:%d:%s:%s", chr, pos, ref, alt)
+}
+
+// sampleName returns the first sample in the vcf header, or the file name if the vcf has no samples.
+func sampleName(filename string, header vcf.Header) string {
+	names := vcf.SampleNamesInOrder(header)
+	if len(names) > 0 && names[0] != "" {
+		return names[0]
+	}
+	return strings.TrimSuffix(strings.TrimSuffix(filepath.Base(filename), ".gz"), ".vcf")
+}
+
+func writeMatrix(out io.Writer, variants []*sharedVariant, sampleNames []string) {
+	_, err := fmt.Fprintf(out, "#chrom\tpos\tref\talt\tclass\tnumSamples\tbulkAltReads\tbulkDepth\t%s\n", strings.Join(sampleNames, "\t"))
+	exception.PanicOnErr(err)
+	var sb strings.Builder
+	for _, sv := range variants {
+		sb.Reset()
+		for i := range sv.present {
+			if sv.present[i] {
+				sb.WriteString("\t1")
+			} else {
+				sb.WriteString("\t0")
+			}
+		}
+		_, err = fmt.Fprintf(out, "%s\t%d\t%s\t%s\t%s\t%d\t%d\t%d%s\n", sv.chr, sv.pos, sv.ref, sv.alt, sv.class, sv.count, sv.bulkAlt, sv.bulkDepth, sb.String())
+		exception.PanicOnErr(err)
+	}
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,70 @@
+package mcsShared
+
+import (
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"strings"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		sv       sharedVariant
+		expected string
+	}{
+		{sharedVariant{count: 1}, "private"},
+		{sharedVariant{count: 3}, "shared"},
+		{sharedVariant{count: 5}, "germline-like"}, // half of the cells
+		{sharedVariant{count: 1, inBulkVcf: true}, "germline-like"},
+		{sharedVariant{count: 1, bulkAlt: 4, bulkDepth: 20}, "germline-like"},
+		{sharedVariant{count: 1, bulkAlt: 1, bulkDepth: 20}, "private"},
+		{sharedVariant{count: 2, bulkAlt: 4, bulkDepth: 5}, "shared"}, // bulk too shallow
+	}
+	for _, test := range tests {
+		if actual := classify(&test.sv, 10, 0.5, 0.1, 10); actual != test.expected {
+			t.Errorf("expected %s for %+v, got %s", test.expected, test.sv, actual)
+		}
+	}
+}
+
+func TestSupportsAlt(t *testing.T) {
+	read := func(seq, cig string) sam.Sam {
+		return sam.Sam{Pos: 10, Seq: dna.StringToBases(seq), Qual: strings.Repeat("I", len(seq)), Cigar: cigar.FromString(cig)}
+	}
+	tests := []struct {
+		r        sam.Sam
+		pos      int
+		ref, alt string
+		expected int
+	}{
+		{read("ACGTA", "5M"), 12, "C", "G", 1},
+		{read("ACCTA", "5M"), 12, "C", "G", 0},
+		{read("ACGTA", "5M"), 20, "C", "G", -1},      // not covered
+		{read("ACGTTA", "3M1I2M"), 12, "G", "GT", 1}, // insertion after 12
+		{read("ACGA", "3M1D1M"), 12, "GT", "G", 1},   // deletion of 13
+		{read("ACGTA", "5M"), 12, "GT", "G", 0},      // spans the deletion without it
+		{read("ACGTTA", "3M1I2M"), 11, "C", "CT", 0}, // insertion elsewhere
+	}
+	for _, test := range tests {
+		sv := sharedVariant{pos: test.pos, ref: test.ref, alt: test.alt}
+		if actual := supportsAlt(test.r, &sv, 20); actual != test.expected {
+			t.Errorf("expected %d for %d %s>%s in %s %s, got %d", test.expected, test.pos, test.ref, test.alt, dna.BasesToString(test.r.Seq), cigar.ToString(test.r.Cigar), actual)
+		}
+	}
+}
+
+func TestTrimSuffix(t *testing.T) {
+	tests := []struct {
+		ref, alt, expectedRef, expectedAlt string
+	}{
+		{"GTT", "GT", "GT", "G"},
+		{"GCA", "GCACA", "G", "GCA"},
+		{"A", "C", "A", "C"},
+	}
+	for _, test := range tests {
+		if ref, alt := trimSuffix(test.ref, test.alt); ref != test.expectedRef || alt != test.expectedAlt {
+			t.Errorf("expected %s>%s from %s>%s, got %s>%s", test.expectedRef, test.expectedAlt, test.ref, test.alt, ref, alt)
+		}
+	}
+}
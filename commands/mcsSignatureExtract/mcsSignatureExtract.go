@@ -0,0 +1,661 @@
+package mcsSignatureExtract
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/sbs"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"math"
+	"math/rand"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsSignatureExtract - De novo extraction of SBS96 mutational signatures from a cohort of samples by non-negative matrix factorization.\n" +
+			"The SBS96 matrix is built from VCF files (-i) and the reference (-r), or read from a matrix file (-m) with a MutationType column\n" +
+			"(e.g. A[C>T]G) followed by one column of counts per sample. For each number of signatures from -minK to -maxK, NMF is run on\n" +
+			"-replicates bootstrap resamplings of the matrix. Signatures from each replicate are matched to consensus signatures and the\n" +
+			"stability of each signature is the mean cosine similarity of its replicates to the consensus. The selected number of signatures\n" +
+			"is the largest where every signature has a stability of at least -minStability, unless set with -k. Exposures are fit to the original matrix.\n" +
+			"Usage:\n" +
+			"mcsSignatureExtract [options] -i a.vcf -i b.vcf -i c.vcf -r ref.fa -o signatures.txt -exposuresOut exposures.txt\n" +
+			"mcsSignatureExtract [options] -m sbs96.txt -statsOut stats.txt -o signatures.txt\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsSignatureExtract with the options in os.Args.
+func Main() {
+	var inputs inputFiles
+	flag.Var(&inputs, "i", "Input VCF file. May be declared more than once. Each sample in a VCF is a separate column of the matrix. VCFs without samples are named by file.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai). Required with -i.")
+	matrixFile := flag.String("m", "", "Input SBS96 matrix file. May be used instead of -i.")
+	output := flag.String("o", "stdout", "Output file with the selected signatures.")
+	matrixOut := flag.String("matrixOut", "", "Write the SBS96 matrix built from -i to this file.")
+	exposuresOut := flag.String("exposuresOut", "", "Write the number of mutations attributed to each signature in each sample to this file.")
+	statsOut := flag.String("statsOut", "", "Write the stability and reconstruction error for each number of signatures to this file.")
+	minK := flag.Int("minK", 1, "Minimum number of signatures to extract.")
+	maxK := flag.Int("maxK", 6, "Maximum number of signatures to extract.")
+	k := flag.Int("k", 0, "Number of signatures to report. If 0, selected by -minStability.")
+	replicates := flag.Int("replicates", 20, "Number of bootstrap replicates for each number of signatures.")
+	maxIter := flag.Int("maxIter", 10_000, "Maximum number of NMF iterations.")
+	tolerance := flag.Float64("tolerance", 1e-7, "NMF stops when the relative change in divergence over 10 iterations is below this value.")
+	minStability := flag.Float64("minStability", 0.85, "Minimum stability of every signature used to select the number of signatures.")
+	seed := flag.Int64("seed", 1, "Seed for bootstrap resampling and NMF initialization.")
+	passOnly := flag.Bool("passOnly", false, "Only count variants with a FILTER of PASS or '.'.")
+	dryrun.Parse()
+
+	if (len(inputs) == 0) == (*matrixFile == "") {
+		usage()
+		log.Fatal("ERROR: must specify exactly one of VCF files (-i) or a matrix file (-m).")
+	}
+
+	if len(inputs) > 0 && *ref == "" {
+		usage()
+		log.Fatal("ERROR: must specify a reference (-r) with -i.")
+	}
+
+	if *minK < 1 || *maxK < *minK {
+		usage()
+		log.Fatal("ERROR: -minK must be at least 1 and no greater than -maxK.")
+	}
+
+	if *k != 0 && (*k < *minK || *k > *maxK) {
+		usage()
+		log.Fatal("ERROR: -k must be between -minK and -maxK.")
+	}
+
+	if *replicates < 1 {
+		usage()
+		log.Fatal("ERROR: -replicates must be at least 1.")
+	}
+
+	e := extractParams{
+		minK:         *minK,
+		maxK:         *maxK,
+		k:            *k,
+		replicates:   *replicates,
+		maxIter:      *maxIter,
+		tolerance:    *tolerance,
+		minStability: *minStability,
+		rng:          rand.New(rand.NewSource(*seed)),
+	}
+
+	var m sbsMatrix
+	if *matrixFile != "" {
+		m = readMatrix(*matrixFile)
+	} else {
+		m = buildMatrix(inputs, *ref, *passOnly)
+		if *matrixOut != "" {
+			writeMatrix(*matrixOut, m)
+		}
+	}
+
+	mcsSignatureExtract(m, *output, *exposuresOut, *statsOut, e)
+}
+
+// extractParams stores the options for signature extraction.
+type extractParams struct {
+	minK         int
+	maxK         int
+	k            int
+	replicates   int
+	maxIter      int
+	tolerance    float64
+	minStability float64
+	rng          *rand.Rand
+}
+
+// sbsMatrix stores mutation counts with one row per SBS96 class in the order of sbs.Types.
+type sbsMatrix struct {
+	samples []string
+	counts  [][]float64 // counts[class][sample]
+}
+
+// extraction is the consensus result for a single number of signatures.
+type extraction struct {
+	k            int
+	signatures   [][]float64 // signatures[class][signature], each column sums to 1
+	exposures    [][]float64 // exposures[signature][sample]
+	stability    []float64   // stability of each signature
+	relError     float64     // relative Frobenius error of the reconstruction
+	meanCosine   float64     // mean cosine similarity of each sample to its reconstruction
+	meanStable   float64
+	minStability float64
+}
+
+func mcsSignatureExtract(m sbsMatrix, output, exposuresOut, statsOut string, e extractParams) {
+	var total float64
+	for i := range m.counts {
+		for j := range m.counts[i] {
+			total += m.counts[i][j]
+		}
+	}
+	if total == 0 {
+		log.Fatal("ERROR: no SNVs found in input.")
+	}
+	log.Printf("Extracting signatures from %.0f SNVs in %d samples.\n", total, len(m.samples))
+
+	results := make([]extraction, 0, e.maxK-e.minK+1)
+	for k := e.minK; k <= e.maxK; k++ {
+		results = append(results, extract(m.counts, k, e))
+		log.Printf("k=%d mean stability %.3f, min stability %.3f, relative error %.4f\n", k, results[len(results)-1].meanStable,
+			results[len(results)-1].minStability, results[len(results)-1].relError)
+	}
+
+	selected := results[0]
+	for _, r := range results {
+		if (e.k != 0 && r.k == e.k) || (e.k == 0 && r.minStability >= e.minStability) {
+			selected = r
+		}
+	}
+	log.Printf("Selected %d signatures.\n", selected.k)
+
+	writeSignatures(output, selected)
+	if exposuresOut != "" {
+		writeExposures(exposuresOut, m.samples, selected)
+	}
+	if statsOut != "" {
+		writeStats(statsOut, results, selected.k)
+	}
+}
+
+// extract runs NMF with k signatures on bootstrap replicates of v and returns the consensus signatures.
+func extract(v [][]float64, k int, e extractParams) extraction {
+	reps := make([][][]float64, e.replicates)
+	for r := range reps {
+		reps[r], _ = nmf(bootstrap(v, e.rng), k, nil, e)
+	}
+
+	// the centroids start from the first replicate and are refined by matching every replicate to them
+	centroids := copyMatrix(reps[0])
+	var assign [][]int
+	for round := 0; round < 3; round++ {
+		assign = make([][]int, len(reps))
+		for r := range reps {
+			assign[r] = match(centroids, reps[r])
+		}
+		centroids = zeroMatrix(len(v), k)
+		for r := range reps {
+			for c := 0; c < k; c++ {
+				for i := range v {
+					centroids[i][c] += reps[r][i][assign[r][c]]
+				}
+			}
+		}
+		normalizeColumns(centroids)
+	}
+
+	ans := extraction{k: k, signatures: centroids, stability: make([]float64, k), minStability: 1}
+	for c := 0; c < k; c++ {
+		for r := range reps {
+			ans.stability[c] += cosine(column(centroids, c), column(reps[r], assign[r][c]))
+		}
+		ans.stability[c] /= float64(len(reps))
+		ans.meanStable += ans.stability[c] / float64(k)
+		ans.minStability = math.Min(ans.minStability, ans.stability[c])
+	}
+
+	_, ans.exposures = nmf(v, k, centroids, e)
+	ans.relError, ans.meanCosine = reconstruction(v, centroids, ans.exposures)
+	return ans
+}
+
+// nmf factors v into w (classes x k) and h (k x samples) by multiplicative updates minimizing the
+// Kullback-Leibler divergence. If fixedW is set only h is updated. Columns of w are returned summing to 1.
+func nmf(v [][]float64, k int, fixedW [][]float64, e extractParams) (w, h [][]float64) {
+	const eps = 1e-12
+	nClass, nSample := len(v), len(v[0])
+	if fixedW != nil {
+		w = copyMatrix(fixedW)
+	} else {
+		w = randomMatrix(nClass, k, e.rng)
+	}
+	h = randomMatrix(k, nSample, e.rng)
+
+	wh := zeroMatrix(nClass, nSample)
+	ratio := zeroMatrix(nClass, nSample)
+	prevDiv := -1.0
+	var num, denom, div float64
+	for iter := 0; iter < e.maxIter; iter++ {
+		multiply(w, h, wh)
+		for i := range v {
+			for j := range v[i] {
+				ratio[i][j] = v[i][j] / (wh[i][j] + eps)
+			}
+		}
+		for a := 0; a < k; a++ {
+			for j := 0; j < nSample; j++ {
+				num, denom = 0, 0
+				for i := 0; i < nClass; i++ {
+					num += w[i][a] * ratio[i][j]
+					denom += w[i][a]
+				}
+				h[a][j] *= num / (denom + eps)
+			}
+		}
+
+		if fixedW == nil {
+			multiply(w, h, wh)
+			for i := range v {
+				for j := range v[i] {
+					ratio[i][j] = v[i][j] / (wh[i][j] + eps)
+				}
+			}
+			for i := 0; i < nClass; i++ {
+				for a := 0; a < k; a++ {
+					num, denom = 0, 0
+					for j := 0; j < nSample; j++ {
+						num += h[a][j] * ratio[i][j]
+						denom += h[a][j]
+					}
+					w[i][a] *= num / (denom + eps)
+				}
+			}
+		}
+
+		if iter%10 == 9 {
+			multiply(w, h, wh)
+			div = divergence(v, wh)
+			if prevDiv >= 0 && prevDiv-div <= e.tolerance*prevDiv {
+				break
+			}
+			prevDiv = div
+		}
+	}
+
+	// move the scale of each signature to the exposures
+	var sum float64
+	for a := 0; a < k; a++ {
+		sum = 0
+		for i := 0; i < nClass; i++ {
+			sum += w[i][a]
+		}
+		if sum == 0 {
+			continue
+		}
+		for i := 0; i < nClass; i++ {
+			w[i][a] /= sum
+		}
+		for j := 0; j < nSample; j++ {
+			h[a][j] *= sum
+		}
+	}
+	return w, h
+}
+
+// match assigns each centroid a distinct signature of w, greedily taking the most similar pairs first.
+func match(centroids, w [][]float64) []int {
+	type pair struct {
+		c, s int
+		sim  float64
+	}
+	k := len(centroids[0])
+	pairs := make([]pair, 0, k*k)
+	for c := 0; c < k; c++ {
+		for s := 0; s < k; s++ {
+			pairs = append(pairs, pair{c: c, s: s, sim: cosine(column(centroids, c), column(w, s))})
+		}
+	}
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].sim > pairs[j].sim })
+	ans := make([]int, k)
+	usedC, usedS := make([]bool, k), make([]bool, k)
+	for _, p := range pairs {
+		if usedC[p.c] || usedS[p.s] {
+			continue
+		}
+		ans[p.c] = p.s
+		usedC[p.c], usedS[p.s] = true, true
+	}
+	return ans
+}
+
+// bootstrap resamples the mutations of each sample from its own profile, keeping the number of mutations.
+func bootstrap(v [][]float64, rng *rand.Rand) [][]float64 {
+	ans := zeroMatrix(len(v), len(v[0]))
+	cdf := make([]float64, len(v))
+	var total float64
+	for j := range v[0] {
+		total = 0
+		for i := range v {
+			total += v[i][j]
+			cdf[i] = total
+		}
+		for n := 0; n < int(math.Round(total)); n++ {
+			ans[sort.SearchFloat64s(cdf, rng.Float64()*total)][j]++
+		}
+	}
+	return ans
+}
+
+// reconstruction returns the relative Frobenius error of w*h and the mean cosine similarity of each sample to its reconstruction.
+func reconstruction(v, w, h [][]float64) (relError, meanCosine float64) {
+	wh := zeroMatrix(len(v), len(v[0]))
+	multiply(w, h, wh)
+	var diff, norm float64
+	for i := range v {
+		for j := range v[i] {
+			diff += (v[i][j] - wh[i][j]) * (v[i][j] - wh[i][j])
+			norm += v[i][j] * v[i][j]
+		}
+	}
+	for j := range v[0] {
+		meanCosine += cosine(column(v, j), column(wh, j)) / float64(len(v[0]))
+	}
+	return math.Sqrt(diff / norm), meanCosine
+}
+
+// divergence returns the generalized Kullback-Leibler divergence of wh from v.
+func divergence(v, wh [][]float64) float64 {
+	var ans float64
+	for i := range v {
+		for j := range v[i] {
+			if v[i][j] > 0 {
+				ans += v[i][j] * math.Log(v[i][j]/(wh[i][j]+1e-12))
+			}
+			ans += wh[i][j] - v[i][j]
+		}
+	}
+	return ans
+}
+
+func cosine(a, b []float64) float64 {
+	var dot, na, nb float64
+	for i := range a {
+		dot += a[i] * b[i]
+		na += a[i] * a[i]
+		nb += b[i] * b[i]
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(na*nb)
+}
+
+// multiply sets ans to a*b.
+func multiply(a, b, ans [][]float64) {
+	for i := range ans {
+		for j := range ans[i] {
+			ans[i][j] = 0
+			for l := range b {
+				ans[i][j] += a[i][l] * b[l][j]
+			}
+		}
+	}
+}
+
+func column(m [][]float64, c int) []float64 {
+	ans := make([]float64, len(m))
+	for i := range m {
+		ans[i] = m[i][c]
+	}
+	return ans
+}
+
+func normalizeColumns(m [][]float64) {
+	var sum float64
+	for c := range m[0] {
+		sum = 0
+		for i := range m {
+			sum += m[i][c]
+		}
+		for i := range m {
+			if sum > 0 {
+				m[i][c] /= sum
+			}
+		}
+	}
+}
+
+func zeroMatrix(rows, cols int) [][]float64 {
+	ans := make([][]float64, rows)
+	for i := range ans {
+		ans[i] = make([]float64, cols)
+	}
+	return ans
+}
+
+func randomMatrix(rows, cols int, rng *rand.Rand) [][]float64 {
+	ans := zeroMatrix(rows, cols)
+	for i := range ans {
+		for j := range ans[i] {
+			ans[i][j] = rng.Float64() + 1e-3
+		}
+	}
+	return ans
+}
+
+func copyMatrix(m [][]float64) [][]float64 {
+	ans := make([][]float64, len(m))
+	for i := range m {
+		ans[i] = make([]float64, len(m[i]))
+		copy(ans[i], m[i])
+	}
+	return ans
+}
+
+// buildMatrix counts the SBS96 class of each SNV in each sample of the input VCF files.
+func buildMatrix(inputs []string, refFile string, passOnly bool) sbsMatrix {
+	ref := fai.NewSeeker(refFile)
+	defer cleanup(ref)
+
+	var m sbsMatrix
+	var sampleCounts [][]float64 // sampleCounts[sample][class], transposed when done
+	var skipped int
+	for _, input := range inputs {
+		records, header := vcf.GoReadToChan(input)
+		names := vcf.SampleNamesInOrder(header)
+		offset := len(m.samples)
+		if len(names) == 0 {
+			names = []string{strings.TrimSuffix(strings.TrimSuffix(filepath.Base(input), ".gz"), ".vcf")}
+		}
+		for i := range names {
+			m.samples = append(m.samples, names[i])
+			sampleCounts = append(sampleCounts, make([]float64, len(sbs.Types)))
+		}
+
+		var class string
+		var idx int
+		for v := range records {
+			if passOnly && v.Filter != "PASS" && v.Filter != "." {
+				continue
+			}
+			for a := range v.Alt {
+				class = sbs.Class(v, a, ref)
+				if class == "" {
+					if len(v.Ref) == 1 && len(v.Alt[a]) == 1 {
+						skipped++
+					}
+					continue
+				}
+				idx, _ = sbs.Index(class)
+				if len(v.Samples) == 0 {
+					sampleCounts[offset][idx]++
+					continue
+				}
+				for s := range v.Samples {
+					if hasAllele(v.Samples[s], int16(a+1)) {
+						sampleCounts[offset+s][idx]++
+					}
+				}
+			}
+		}
+	}
+	if skipped > 0 {
+		log.Printf("WARNING: %d SNVs had an undefined trinucleotide context and were skipped.", skipped)
+	}
+
+	m.counts = zeroMatrix(len(sbs.Types), len(m.samples))
+	for s := range sampleCounts {
+		for c := range sampleCounts[s] {
+			m.counts[c][s] = sampleCounts[s][c]
+		}
+	}
+	return m
+}
+
+func hasAllele(s vcf.Sample, allele int16) bool {
+	for _, a := range s.Alleles {
+		if a == allele {
+			return true
+		}
+	}
+	return false
+}
+
+// readMatrix reads a matrix with a MutationType column and one column of counts per sample.
+func readMatrix(filename string) sbsMatrix {
+	file := fileio.EasyOpen(filename)
+	defer cleanup(file)
+
+	var m sbsMatrix
+	var seen int
+	var words []string
+	var err error
+	for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) {
+		words = strings.Split(strings.TrimPrefix(line, "#"), "\t")
+		if m.samples == nil {
+			if len(words) < 2 {
+				exit.Fatalf(exit.MalformedInput, "%s must have a MutationType column and at least one sample column.", filename)
+			}
+			m.samples = words[1:]
+			m.counts = zeroMatrix(len(sbs.Types), len(m.samples))
+			continue
+		}
+		idx, found := sbs.Index(words[0])
+		if !found {
+			exit.Fatalf(exit.MalformedInput, "unrecognized mutation type '%s' in %s. Must be formatted as A[C>T]G.", words[0], filename)
+		}
+		if len(words) != len(m.samples)+1 {
+			exit.Fatalf(exit.MalformedInput, "line for %s in %s has %d columns, expected %d.", words[0], filename, len(words), len(m.samples)+1)
+		}
+		for j := range m.samples {
+			m.counts[idx][j], err = strconv.ParseFloat(words[j+1], 64)
+			if err != nil || m.counts[idx][j] < 0 {
+				exit.Fatalf(exit.MalformedInput, "could not parse count '%s' in %s. Counts must be non-negative numbers.", words[j+1], filename)
+			}
+		}
+		seen++
+	}
+	if seen != len(sbs.Types) {
+		exit.Fatalf(exit.MalformedInput, "%s has %d mutation types, expected %d.", filename, seen, len(sbs.Types))
+	}
+	return m
+}
+
+func writeMatrix(filename string, m sbsMatrix) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	_, err := fmt.Fprintf(out, "MutationType\t%s\n", strings.Join(m.samples, "\t"))
+	exception.PanicOnErr(err)
+	for i := range sbs.Types {
+		_, err = fmt.Fprint(out, sbs.Types[i])
+		exception.PanicOnErr(err)
+		for j := range m.samples {
+			_, err = fmt.Fprintf(out, "\t%.0f", m.counts[i][j])
+			exception.PanicOnErr(err)
+		}
+		_, err = fmt.Fprintln(out)
+		exception.PanicOnErr(err)
+	}
+}
+
+// signatureName returns the name of the i-th extracted signature (SBS96A, SBS96B, ...).
+func signatureName(i int) string {
+	if i < 26 {
+		return "SBS96" + string(rune('A'+i))
+	}
+	return fmt.Sprintf("SBS96_%d", i+1)
+}
+
+func writeSignatures(filename string, e extraction) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	_, err := fmt.Fprint(out, "MutationType")
+	exception.PanicOnErr(err)
+	for c := 0; c < e.k; c++ {
+		_, err = fmt.Fprintf(out, "\t%s", signatureName(c))
+		exception.PanicOnErr(err)
+	}
+	_, err = fmt.Fprintln(out)
+	exception.PanicOnErr(err)
+	for i := range sbs.Types {
+		_, err = fmt.Fprint(out, sbs.Types[i])
+		exception.PanicOnErr(err)
+		for c := 0; c < e.k; c++ {
+			_, err = fmt.Fprintf(out, "\t%.6f", e.signatures[i][c])
+			exception.PanicOnErr(err)
+		}
+		_, err = fmt.Fprintln(out)
+		exception.PanicOnErr(err)
+	}
+}
+
+func writeExposures(filename string, samples []string, e extraction) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	_, err := fmt.Fprint(out, "Sample")
+	exception.PanicOnErr(err)
+	for c := 0; c < e.k; c++ {
+		_, err = fmt.Fprintf(out, "\t%s", signatureName(c))
+		exception.PanicOnErr(err)
+	}
+	_, err = fmt.Fprintln(out)
+	exception.PanicOnErr(err)
+	for j := range samples {
+		_, err = fmt.Fprint(out, samples[j])
+		exception.PanicOnErr(err)
+		for c := 0; c < e.k; c++ {
+			_, err = fmt.Fprintf(out, "\t%.2f", e.exposures[c][j])
+			exception.PanicOnErr(err)
+		}
+		_, err = fmt.Fprintln(out)
+		exception.PanicOnErr(err)
+	}
+}
+
+func writeStats(filename string, results []extraction, selected int) {
+	out := fileio.EasyCreate(filename)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "k\tmeanStability\tminStability\trelativeError\tmeanCosineSimilarity\tselected\tsignatureStability")
+	exception.PanicOnErr(err)
+	var stabilities []string
+	for _, r := range results {
+		stabilities = stabilities[:0]
+		for c := range r.stability {
+			stabilities = append(stabilities, fmt.Sprintf("%s:%.3f", signatureName(c), r.stability[c]))
+		}
+		_, err = fmt.Fprintf(out, "%d\t%.4f\t%.4f\t%.4f\t%.4f\t%t\t%s\n", r.k, r.meanStable, r.minStability, r.relError, r.meanCosine,
+			r.k == selected, strings.Join(stabilities, ","))
+		exception.PanicOnErr(err)
+	}
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,45 @@
+package mcsSignatureExtract
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestExtract(t *testing.T) {
+	// two signatures over the 96 classes, overlapping in classes 40-55
+	known := zeroMatrix(96, 2)
+	for i := 0; i < 56; i++ {
+		known[i][0] = float64(1 + i%4)
+	}
+	for i := 40; i < 96; i++ {
+		known[i][1] = float64(1 + (i/4)%3)
+	}
+	normalizeColumns(known)
+	exposures := [][]float64{
+		{4000, 0, 1000, 3000, 2000, 500},
+		{0, 4000, 3000, 1000, 2000, 3500},
+	}
+	v := zeroMatrix(96, len(exposures[0]))
+	multiply(known, exposures, v)
+
+	e := extractParams{minK: 2, maxK: 2, replicates: 10, maxIter: 5000, tolerance: 1e-9, minStability: 0.8, rng: rand.New(rand.NewSource(1))}
+	ans := extract(v, 2, e)
+	assign := match(known, ans.signatures)
+	for s := 0; s < 2; s++ {
+		if sim := cosine(column(known, s), column(ans.signatures, assign[s])); sim < 0.99 {
+			t.Errorf("expected signature %d to be recovered with a cosine similarity above 0.99, got %.4f", s, sim)
+		}
+		for j := range exposures[s] {
+			if actual := ans.exposures[assign[s]][j]; math.Abs(actual-exposures[s][j]) > 0.05*4000 {
+				t.Errorf("expected an exposure of %g to signature %d in sample %d, got %.1f", exposures[s][j], s, j, actual)
+			}
+		}
+		if ans.stability[assign[s]] < 0.95 {
+			t.Errorf("expected signature %d to be stable across bootstrap replicates, got %.3f", s, ans.stability[assign[s]])
+		}
+	}
+	if ans.relError > 0.05 || ans.meanCosine < 0.99 {
+		t.Errorf("expected a close reconstruction, got a relative error of %.4f and mean cosine of %.4f", ans.relError, ans.meanCosine)
+	}
+}
@@ -0,0 +1,224 @@
+package mcsSplitFamilies
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/interval"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"math"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsSplitFamilies - Extract all reads from selected read families into a small bam for review in IGV or sharing in bug reports.\n" +
+			"Families may be selected by ID (-id, -f) or by overlap with a region (-region). If a family bed from annotateReadFamilies\n" +
+			"is given with -b, only the regions spanned by the selected families are read from the bam (requires .bai).\n" +
+			"Otherwise IDs are found by reading the full bam, and regions are searched with -pad bases of padding.\n" +
+			"Usage:\n" +
+			"mcsSplitFamilies [options] -i annotated.bam -b families.bed -id 1234 -id 5678 -o families.bam\n" +
+			"mcsSplitFamilies [options] -i annotated.bam -b families.bed -region chr1:1000000-1000100 -o families.bam\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsSplitFamilies with the options in os.Args.
+func Main() {
+	var ids inputFiles
+	input := flag.String("i", "", "Input bam file annotated with annotateReadFamilies.")
+	output := flag.String("o", "stdout", "Output bam file.")
+	bedFile := flag.String("b", "", "Bed file with read families generated with -bed option in annotateReadFamilies.")
+	idFile := flag.String("f", "", "File with one family ID per line.")
+	flag.Var(&ids, "id", "Family ID to extract. May be declared more than once.")
+	region := flag.String("region", "", "Extract all families with reads overlapping this region, formatted as chr:start-end (1-based, inclusive). Requires an indexed bam.")
+	pad := flag.Int("pad", 2000, "When -region is used without -b, search this many bases on either side of the region for other reads from the selected families.")
+	maxSpan := flag.Int("maxSpan", 1_000_000, "Skip families in the family bed spanning more than this many bases. Avoids extracting the large unassigned family RF:Z:0.")
+	dryrun.Parse()
+
+	if *input == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i).")
+	}
+
+	if (*region == "") == (len(ids) == 0 && *idFile == "") {
+		usage()
+		log.Fatal("ERROR: must specify families with exactly one of -region or -id/-f.")
+	}
+
+	if *idFile != "" {
+		ids = append(ids, fileio.Read(*idFile)...)
+	}
+
+	mcsSplitFamilies(*input, *output, *bedFile, *region, ids, *pad, *maxSpan)
+}
+
+func mcsSplitFamilies(input, output, bedFile, region string, ids []string, pad, maxSpan int) {
+	selected := make(map[string]bool)
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			selected[id] = true
+		}
+	}
+
+	var regionBed bed.Bed
+	if region != "" {
+		var err error
+		regionBed, err = intervals.ParseRegion(region)
+		if err != nil {
+			log.Fatalf("ERROR: %s.", err)
+		}
+	}
+	var spans []bed.Bed
+	switch {
+	case bedFile != "" && region != "":
+		spans = familiesInRegion(bedFile, regionBed, selected, maxSpan)
+	case bedFile != "":
+		spans = familySpans(bedFile, selected, maxSpan)
+	case region != "":
+		spans = familiesInBamRegion(input, regionBed, selected, pad)
+	}
+	log.Printf("Selected %d families.\n", len(selected))
+
+	br, header := sam.OpenBam(input)
+	defer cleanup(br)
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	bw := bam.NewWriter(out, provenance.Sam(header))
+	defer cleanup(bw)
+
+	var written int
+	if spans == nil { // ids without a family bed, read the whole bam
+		var r sam.Sam
+		var err error
+		for {
+			_, err = sam.DecodeBam(br, &r)
+			if err == io.EOF {
+				break
+			}
+			exception.PanicOnErr(err)
+			if selected[familyId(&r)] {
+				bw.Write(r)
+				written++
+			}
+		}
+		log.Printf("Wrote %d reads.\n", written)
+		return
+	}
+
+	idx := bai.Read(input)
+	var reads []sam.Sam
+	var prevChrom string
+	var prevEnd int
+	for _, s := range bed.MergeBeds(spans) {
+		if s.Chrom != prevChrom {
+			prevEnd = 0
+		}
+		reads = sam.SeekBamRegionRecycle(br, idx, s.Chrom, uint32(s.ChromStart), uint32(s.ChromEnd), reads)
+		for i := range reads {
+			if reads[i].GetChromStart() < prevEnd { // already written from the previous span
+				continue
+			}
+			if selected[familyId(&reads[i])] {
+				bw.Write(reads[i])
+				written++
+			}
+		}
+		prevChrom, prevEnd = s.Chrom, s.ChromEnd
+	}
+	log.Printf("Wrote %d reads.\n", written)
+}
+
+// familySpans returns the regions spanned by each selected family.
+func familySpans(bedFile string, selected map[string]bool, maxSpan int) []bed.Bed {
+	ans := make([]bed.Bed, 0, len(selected))
+	found := make(map[string]bool)
+	for _, b := range bed.Read(bedFile) {
+		if !selected[b.Name] {
+			continue
+		}
+		found[b.Name] = true
+		if b.ChromEnd-b.ChromStart > maxSpan {
+			log.Printf("WARNING: family %s spans %d bases and will be skipped. Increase -maxSpan to include it.\n", b.Name, b.ChromEnd-b.ChromStart)
+			continue
+		}
+		ans = append(ans, b)
+	}
+	for id := range selected {
+		if !found[id] {
+			log.Printf("WARNING: family %s was not found in %s.\n", id, bedFile)
+		}
+	}
+	return ans
+}
+
+// familiesInRegion adds all families in the bed that overlap the region to selected and returns their spans.
+func familiesInRegion(bedFile string, region bed.Bed, selected map[string]bool, maxSpan int) []bed.Bed {
+	ans := make([]bed.Bed, 0)
+	tree := interval.BuildTree(interval.BedSliceToIntervals(bed.Read(bedFile)))
+	var b bed.Bed
+	for _, i := range interval.Query(tree, region, "any") {
+		b = i.(bed.Bed)
+		if b.ChromEnd-b.ChromStart > maxSpan {
+			continue
+		}
+		selected[b.Name] = true
+		ans = append(ans, b)
+	}
+	return ans
+}
+
+// familiesInBamRegion adds the families of all reads in the region to selected and returns the padded region to search.
+func familiesInBamRegion(input string, region bed.Bed, selected map[string]bool, pad int) []bed.Bed {
+	br, _ := sam.OpenBam(input)
+	defer cleanup(br)
+	idx := bai.Read(input)
+	reads := sam.SeekBamRegion(br, idx, region.Chrom, uint32(region.ChromStart), uint32(region.ChromEnd))
+	var rf string
+	for i := range reads {
+		if rf = familyId(&reads[i]); rf != "" && rf != "0" { // RF:Z:0 collects reads not assigned to a family
+			selected[rf] = true
+		}
+	}
+	region.ChromStart = int(math.Max(0, float64(region.ChromStart-pad)))
+	region.ChromEnd += pad
+	return []bed.Bed{region}
+}
+
+// familyId returns the RF tag of a read, or an empty string if it has none.
+func familyId(r *sam.Sam) string {
+	sam.ParseExtra(r)
+	rf := barcode.GetRF(r)
+	if idx := strings.IndexByte(rf, '\t'); idx != -1 {
+		rf = rf[:idx]
+	}
+	return rf
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
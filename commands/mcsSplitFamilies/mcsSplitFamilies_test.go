@@ -0,0 +1,76 @@
+package mcsSplitFamilies
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func writeFamilies(t *testing.T) string {
+	bedFile := filepath.Join(t.TempDir(), "families.bed")
+	families := "chr1\t100\t200\t1\t0\t+\n" +
+		"chr1\t150\t400\t2\t0\t+\n" +
+		"chr1\t500\t5500\t3\t0\t+\n" + // longer than the maxSpan of the tests
+		"chr2\t100\t200\t4\t0\t+\n"
+	if err := os.WriteFile(bedFile, []byte(families), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return bedFile
+}
+
+func names(beds []bed.Bed) []string {
+	ans := make([]string, 0, len(beds))
+	for _, b := range beds {
+		ans = append(ans, b.Name)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+func TestFamilySpans(t *testing.T) {
+	bedFile := writeFamilies(t)
+	tests := []struct {
+		ids      []string
+		expected []string
+	}{
+		{[]string{"1"}, []string{"1"}},
+		{[]string{"1", "4"}, []string{"1", "4"}},
+		{[]string{"2", "3"}, []string{"2"}}, // 3 spans more than maxSpan
+		{[]string{"9"}, []string{}},         // not in the bed
+	}
+	for _, test := range tests {
+		selected := make(map[string]bool)
+		for _, id := range test.ids {
+			selected[id] = true
+		}
+		if actual := names(familySpans(bedFile, selected, 1000)); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected spans of families %v for ids %v, got %v", test.expected, test.ids, actual)
+		}
+	}
+}
+
+func TestFamiliesInRegion(t *testing.T) {
+	bedFile := writeFamilies(t)
+	tests := []struct {
+		region   bed.Bed
+		expected []string
+	}{
+		{bed.Bed{Chrom: "chr1", ChromStart: 160, ChromEnd: 170}, []string{"1", "2"}},
+		{bed.Bed{Chrom: "chr1", ChromStart: 300, ChromEnd: 600}, []string{"2"}}, // 3 spans more than maxSpan
+		{bed.Bed{Chrom: "chr2", ChromStart: 0, ChromEnd: 1000}, []string{"4"}},
+		{bed.Bed{Chrom: "chr3", ChromStart: 0, ChromEnd: 1000}, []string{}},
+	}
+	for _, test := range tests {
+		selected := make(map[string]bool)
+		actual := names(familiesInRegion(bedFile, test.region, selected, 1000))
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected families %v in %s:%d-%d, got %v", test.expected, test.region.Chrom, test.region.ChromStart, test.region.ChromEnd, actual)
+		}
+		if len(selected) != len(test.expected) {
+			t.Errorf("expected families %v to be selected, got %v", test.expected, selected)
+		}
+	}
+}
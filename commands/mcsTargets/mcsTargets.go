@@ -0,0 +1,299 @@
+package mcsTargets
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/intervals"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fasta"
+	"github.com/vertgenlab/gonomics/interval"
+	"io"
+	"log"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsTargets - Scan a reference genome for short tandem repeat loci to use as targets for genotypeTargetRepeats.\n" +
+			"Repeats are seeded by perfect runs of -minSeedCopies copies and extended in both directions while the\n" +
+			"fraction of bases matching the repeat unit stays high. Overlapping loci with different unit lengths are\n" +
+			"resolved by keeping the longest. Output is a bed file with the name column formatted as NxSEQ (e.g. 12xCA)\n" +
+			"and the score column set to the repeat purity * 1000.\n" +
+			"Usage:\n" +
+			"mcsTargets [options] -r reference.fasta > targets.bed\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsTargets with the options in os.Args.
+func Main() {
+	var excludeBeds inputFiles
+	ref := flag.String("r", "", "Reference FASTA file.")
+	output := flag.String("o", "stdout", "Output BED file.")
+	minUnitLen := flag.Int("minUnitLen", 1, "Minimum length of repeat unit.")
+	maxUnitLen := flag.Int("maxUnitLen", 6, "Maximum length of repeat unit.")
+	minCopies := flag.Int("minCopies", 5, "Minimum number of copies of the repeat unit.")
+	maxTotalLen := flag.Int("maxTotalLen", 100, "Maximum total length of repeat. Repeats longer than this value are not spanned by short reads.")
+	minPurity := flag.Float64("minPurity", 0.9, "Minimum fraction of bases in the repeat that match the repeat unit. Set to 1 for perfect repeats only.")
+	minSeedCopies := flag.Int("minSeedCopies", 3, "Minimum number of perfect copies required to seed a repeat.")
+	flank := flag.Int("flank", 20, "Number of bases on either side of the repeat that must be free of N bases and within -mappable regions.")
+	mappable := flag.String("mappable", "", "Bed, interval_list, or GFF3 file of uniquely mappable regions (e.g. from umap). If set, repeats and their flanks must be entirely within a mappable region.")
+	flag.Var(&excludeBeds, "e", "Bed, interval_list, or GFF3 file(s) with regions to exclude. May be declared more than once.")
+	dryrun.Parse()
+
+	if *ref == "" {
+		usage()
+		log.Fatal("ERROR: must specify reference (-r).")
+	}
+
+	if *minUnitLen < 1 || *maxUnitLen < *minUnitLen {
+		usage()
+		log.Fatal("ERROR: -minUnitLen must be >= 1 and <= -maxUnitLen.")
+	}
+
+	if *minSeedCopies < 2 {
+		usage()
+		log.Fatal("ERROR: -minSeedCopies must be >= 2.")
+	}
+
+	mcsTargets(*ref, *output, *mappable, excludeBeds, *minUnitLen, *maxUnitLen, *minCopies, *maxTotalLen, *minSeedCopies, *flank, *minPurity)
+}
+
+// locus is a candidate repeat on the reference.
+type locus struct {
+	chr    string
+	start  int
+	end    int
+	unit   []dna.Base
+	purity float64
+}
+
+func mcsTargets(ref, output, mappable string, excludeBeds []string, minUnitLen, maxUnitLen, minCopies, maxTotalLen, minSeedCopies, flank int, minPurity float64) {
+	var mappableTree, excludeTree map[string]*interval.IntervalNode
+	if mappable != "" {
+		mappableTree = interval.BuildTree(interval.BedSliceToIntervals(intervals.ReadMerged(mappable)))
+	}
+	if len(excludeBeds) > 0 {
+		var excluded []bed.Bed
+		for i := range excludeBeds {
+			excluded = append(excluded, intervals.ReadMerged(excludeBeds[i])...)
+		}
+		excludeTree = interval.BuildTree(interval.BedSliceToIntervals(excluded))
+	}
+
+	out := tabix.Create(output)
+	defer cleanup(out)
+
+	var loci []locus
+	var written int
+	var b bed.Bed
+	records := fasta.GoReadToChan(ref)
+	for chr := range records {
+		dna.AllToUpper(chr.Seq)
+		loci = loci[:0]
+		for k := minUnitLen; k <= maxUnitLen; k++ {
+			loci = scanSeq(chr.Name, chr.Seq, k, minSeedCopies, minPurity, loci)
+		}
+		loci = resolveOverlaps(loci)
+		for _, l := range loci {
+			if (l.end-l.start)/len(l.unit) < minCopies || l.end-l.start > maxTotalLen {
+				continue
+			}
+			b = bed.Bed{Chrom: l.chr, ChromStart: l.start, ChromEnd: l.end, Name: fmt.Sprintf("%dx%s", (l.end-l.start)/len(l.unit), dna.BasesToString(l.unit)), Score: int(l.purity * 1000), FieldsInitialized: 5}
+			if !passesFlanks(chr.Seq, l, flank) {
+				continue
+			}
+			if mappableTree != nil && !withinMappable(mappableTree, b, flank) {
+				continue
+			}
+			if excludeTree != nil && len(interval.Query(excludeTree, b, "any")) > 0 {
+				continue
+			}
+			bed.WriteBed(out, b)
+			written++
+		}
+	}
+	log.Printf("Wrote %d repeat loci.\n", written)
+}
+
+// scanSeq finds repeats with unit length k and appends them to ans.
+func scanSeq(chr string, seq []dna.Base, k, minSeedCopies int, minPurity float64, ans []locus) []locus {
+	seedLen := k * (minSeedCopies - 1) // number of consecutive positions where seq[i] == seq[i-k] for a perfect seed
+	var run, start, end int
+	for i := k; i < len(seq); i++ {
+		if seq[i] != dna.N && seq[i] == seq[i-k] {
+			run++
+		} else {
+			run = 0
+			continue
+		}
+		if run < seedLen {
+			continue
+		}
+		// seed spans [i-run-k+1, i+1)
+		start, end = extend(seq, i-run-k+1, i+1, k, minPurity)
+		if isPrimitive(seq[start : start+k]) {
+			ans = append(ans, locus{chr: chr, start: start, end: end, unit: copyBases(seq[start : start+k]), purity: purity(seq[start:end], k)})
+		}
+		if end-1 > i { // continue scanning after the repeat
+			i = end - 1
+		}
+		run = 0
+	}
+	return ans
+}
+
+// extend grows a seeded repeat region in both directions. Each base matching the base one unit away scores +1
+// and each mismatch scores -k, so extension stops quickly in non-repetitive sequence. The region is trimmed back
+// to the highest scoring position and then to a whole number of repeat units.
+func extend(seq []dna.Base, start, end, k int, minPurity float64) (int, int) {
+	var score, bestScore, j int
+	mismatchPenalty := k
+	if minPurity >= 1 {
+		mismatchPenalty = len(seq) // any mismatch terminates extension
+	}
+
+	bestEnd := end
+	for j = end; j < len(seq) && seq[j] != dna.N; j++ {
+		if seq[j] == seq[j-k] {
+			score++
+		} else {
+			score -= mismatchPenalty
+		}
+		if score > bestScore {
+			bestScore = score
+			bestEnd = j + 1
+		}
+		if bestScore-score > 2*k {
+			break
+		}
+	}
+
+	score, bestScore = 0, 0
+	bestStart := start
+	for j = start - 1; j >= 0 && seq[j] != dna.N; j-- {
+		if seq[j] == seq[j+k] {
+			score++
+		} else {
+			score -= mismatchPenalty
+		}
+		if score > bestScore {
+			bestScore = score
+			bestStart = j
+		}
+		if bestScore-score > 2*k {
+			break
+		}
+	}
+
+	// trim trailing partial unit
+	bestEnd -= (bestEnd - bestStart) % k
+	for bestEnd-bestStart > k && purity(seq[bestStart:bestEnd], k) < minPurity {
+		bestEnd -= k
+	}
+	return bestStart, bestEnd
+}
+
+// purity returns the fraction of bases after the first unit that match the base one unit upstream.
+func purity(seq []dna.Base, k int) float64 {
+	if len(seq) <= k {
+		return 1
+	}
+	var matches int
+	for i := k; i < len(seq); i++ {
+		if seq[i] == seq[i-k] {
+			matches++
+		}
+	}
+	return float64(matches) / float64(len(seq)-k)
+}
+
+// isPrimitive returns false if the unit is itself a repeat of a shorter unit (e.g. CACA).
+func isPrimitive(unit []dna.Base) bool {
+	var i int
+	for d := 1; d < len(unit); d++ {
+		if len(unit)%d != 0 {
+			continue
+		}
+		for i = d; i < len(unit); i++ {
+			if unit[i] != unit[i-d] {
+				break
+			}
+		}
+		if i == len(unit) {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveOverlaps removes overlapping loci, keeping the longest.
+func resolveOverlaps(loci []locus) []locus {
+	sort.Slice(loci, func(i, j int) bool {
+		return loci[i].start < loci[j].start
+	})
+	ans := loci[:0]
+	for _, l := range loci {
+		if len(ans) > 0 && l.start < ans[len(ans)-1].end {
+			if l.end-l.start > ans[len(ans)-1].end-ans[len(ans)-1].start {
+				ans[len(ans)-1] = l
+			}
+			continue
+		}
+		ans = append(ans, l)
+	}
+	return ans
+}
+
+func passesFlanks(seq []dna.Base, l locus, flank int) bool {
+	if l.start-flank < 0 || l.end+flank > len(seq) {
+		return false
+	}
+	for i := l.start - flank; i < l.start; i++ {
+		if seq[i] == dna.N {
+			return false
+		}
+	}
+	for i := l.end; i < l.end+flank; i++ {
+		if seq[i] == dna.N {
+			return false
+		}
+	}
+	return true
+}
+
+// withinMappable returns true if the padded repeat is entirely contained in a single mappable region.
+func withinMappable(tree map[string]*interval.IntervalNode, b bed.Bed, flank int) bool {
+	b.ChromStart -= flank
+	b.ChromEnd += flank
+	return len(interval.Query(tree, b, "within")) > 0
+}
+
+func copyBases(b []dna.Base) []dna.Base {
+	ans := make([]dna.Base, len(b))
+	copy(ans, b)
+	return ans
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
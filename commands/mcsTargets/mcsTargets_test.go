@@ -0,0 +1,74 @@
+package mcsTargets
+
+import (
+	"github.com/vertgenlab/gonomics/dna"
+	"testing"
+)
+
+func TestScanSeq(t *testing.T) {
+	//                             0         1         2         3         4
+	//                             0123456789012345678901234567890123456789012345
+	seq := dna.StringToBases("GATTGTCACACACACACAGGTTGNAGCTAGCTAGCTAGCTTCGATC")
+	tests := []struct {
+		k             int
+		minPurity     float64
+		expectedStart int
+		expectedEnd   int
+		expectedUnit  string
+	}{
+		{2, 1, 6, 18, "CA"},
+		{4, 1, 24, 40, "AGCT"},
+	}
+	for _, test := range tests {
+		loci := scanSeq("chr1", seq, test.k, 4, test.minPurity, nil)
+		if len(loci) != 1 || loci[0].start != test.expectedStart || loci[0].end != test.expectedEnd || dna.BasesToString(loci[0].unit) != test.expectedUnit {
+			t.Errorf("expected a %s repeat at [%d, %d) with k = %d, got %v", test.expectedUnit, test.expectedStart, test.expectedEnd, test.k, loci)
+		}
+	}
+	if loci := scanSeq("chr1", seq, 2, 7, 1, nil); len(loci) != 0 {
+		t.Errorf("expected no CA repeat with 7 seed copies, got %v", loci)
+	}
+}
+
+func TestIsPrimitive(t *testing.T) {
+	tests := []struct {
+		unit     string
+		expected bool
+	}{
+		{"CA", true},
+		{"CACA", false},
+		{"AAA", false},
+		{"AAG", true},
+		{"ATAT", false},
+		{"A", true},
+	}
+	for _, test := range tests {
+		if actual := isPrimitive(dna.StringToBases(test.unit)); actual != test.expected {
+			t.Errorf("expected isPrimitive(%s) to be %v", test.unit, test.expected)
+		}
+	}
+}
+
+func TestPurity(t *testing.T) {
+	tests := []struct {
+		seq      string
+		k        int
+		expected float64
+	}{
+		{"CACACACA", 2, 1},
+		{"CACATACA", 2, 4.0 / 6},
+		{"CA", 2, 1},
+	}
+	for _, test := range tests {
+		if actual := purity(dna.StringToBases(test.seq), test.k); actual != test.expected {
+			t.Errorf("expected a purity of %g for %s, got %g", test.expected, test.seq, actual)
+		}
+	}
+}
+
+func TestResolveOverlaps(t *testing.T) {
+	loci := resolveOverlaps([]locus{{start: 30, end: 40}, {start: 0, end: 10}, {start: 5, end: 25}, {start: 40, end: 44}})
+	if len(loci) != 3 || loci[0].start != 5 || loci[1].start != 30 || loci[2].start != 40 {
+		t.Errorf("expected the longer of the overlapping loci to be kept, got %v", loci)
+	}
+}
@@ -0,0 +1,188 @@
+package mcsTelomere
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/repeatcall"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"path/filepath"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsTelomere - Estimate telomere content from the number of read families containing telomeric repeats.\n" +
+			"A read is telomeric if it contains a run of at least -minRepeats copies of TTAGGG or CCCTAA, measured as\n" +
+			"genotypeTargetRepeats measures repeat lengths, so runs split by a short interruption may be joined.\n" +
+			"A read family is telomeric if at least -minReads of its reads (RF tag from annotateReadFamilies) are telomeric, and duplex\n" +
+			"telomeric if telomeric reads are found on both the watson and crick strands. Counts are normalized by the number of\n" +
+			"read families in the bed file from annotateReadFamilies and reported per million families as a proxy for telomere length.\n" +
+			"Output is a TSV with columns Sample, Metric, Value.\n" +
+			"Usage:\n" +
+			"mcsTelomere [options] -i annotated.bam -b families.bed > telomere.tsv\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsTelomere with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file annotated with annotateReadFamilies. Should include unmapped reads.")
+	bedFile := flag.String("b", "", "Input bed file with read families generated with -bed option in annotateReadFamilies.")
+	output := flag.String("o", "stdout", "Output file.")
+	sample := flag.String("sample", "", "Sample name to report in output. Defaults to the input bam file name.")
+	minRepeats := flag.Int("minRepeats", 7, "Minimum number of consecutive telomeric repeat units for a read to be telomeric.")
+	minReads := flag.Int("minReads", 2, "Minimum number of telomeric reads for a read family to be telomeric.")
+	dryrun.Parse()
+
+	if *input == "" || *bedFile == "" {
+		usage()
+		log.Fatal("ERROR: must specify bam (-i) and bed (-b).")
+	}
+
+	if *minRepeats < 1 || *minReads < 1 {
+		usage()
+		log.Fatal("ERROR: -minRepeats and -minReads must be at least 1.")
+	}
+
+	if *sample == "" {
+		*sample = strings.TrimSuffix(filepath.Base(*input), ".bam")
+	}
+
+	mcsTelomere(*input, *bedFile, *output, *sample, *minRepeats, *minReads)
+}
+
+// telomereUnits are the repeat units on the G-rich and C-rich strands of the telomere.
+var telomereUnits = [][]dna.Base{dna.StringToBases("TTAGGG"), dna.StringToBases("CCCTAA")}
+
+// telFamily counts the telomeric reads in a read family.
+type telFamily struct {
+	watson int
+	crick  int
+}
+
+func mcsTelomere(input, bedFile, output, sample string, minRepeats, minReads int) {
+	totalFamilies := countFamilies(bedFile)
+	if totalFamilies == 0 {
+		log.Fatalf("ERROR: no read families found in %s.", bedFile)
+	}
+
+	reads, _ := hts.GoReadToChan(input)
+	families := make(map[string]*telFamily)
+	minLength := minRepeats * len(telomereUnits[0])
+	var totalReads, telomericReads, unassignedReads int
+	var rf string
+	var rs byte
+	var f *telFamily
+	for r := range reads {
+		if r.Flag&0x900 != 0 { // secondary or supplementary
+			continue
+		}
+		totalReads++
+		if !isTelomeric(r.Seq, minLength) {
+			continue
+		}
+		telomericReads++
+		sam.ParseExtra(&r)
+		rf = barcode.GetRF(&r)
+		if idx := strings.IndexByte(rf, '\t'); idx != -1 {
+			rf = rf[:idx]
+		}
+		rs = barcode.GetRS(&r)
+		if rf == "" || rf == "0" || (rs != 'W' && rs != 'C') { // RF:Z:0 collects reads not assigned to a family
+			unassignedReads++
+			continue
+		}
+		if f = families[rf]; f == nil {
+			f = new(telFamily)
+			families[rf] = f
+		}
+		if rs == 'W' {
+			f.watson++
+		} else {
+			f.crick++
+		}
+	}
+
+	var telomericFamilies, duplexFamilies int
+	for _, f = range families {
+		if f.watson+f.crick < minReads {
+			continue
+		}
+		telomericFamilies++
+		if f.watson > 0 && f.crick > 0 {
+			duplexFamilies++
+		}
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	lines := []struct {
+		name  string
+		value any
+	}{
+		{"TotalReads", totalReads},
+		{"TelomericReads", telomericReads},
+		{"UnassignedTelomericReads", unassignedReads},
+		{"TotalFamilies", totalFamilies},
+		{"TelomericFamilies", telomericFamilies},
+		{"DuplexTelomericFamilies", duplexFamilies},
+		{"TelomericReadsPerMillionReads", perMillion(telomericReads, totalReads)},
+		{"TelomericFamiliesPerMillionFamilies", perMillion(telomericFamilies, totalFamilies)},
+		{"DuplexTelomericFamiliesPerMillionFamilies", perMillion(duplexFamilies, totalFamilies)},
+	}
+	_, err := fmt.Fprintln(out, "Sample\tMetric\tValue")
+	exception.PanicOnErr(err)
+	for _, l := range lines {
+		_, err = fmt.Fprintf(out, "%s\t%s\t%v\n", sample, l.name, l.value)
+		exception.PanicOnErr(err)
+	}
+}
+
+// isTelomeric returns true if seq contains a run of either telomeric repeat unit at least minLength bases long,
+// as measured by the repeat walk of genotypeTargetRepeats.
+func isTelomeric(seq []dna.Base, minLength int) bool {
+	if len(seq) < minLength {
+		return false
+	}
+	// the walk takes the phase of a run from its first base, so it is started at each copy of the unit, as a run
+	// preceded by a base in the unit would otherwise be cut short
+	for _, unit := range telomereUnits {
+		for i := 0; i+len(unit) <= len(seq); i++ {
+			if dna.CompareSeqsCaseSensitive(seq[i:i+len(unit)], unit) == 0 && repeatcall.RepeatRun(seq, i, len(seq), unit, 0) >= minLength {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// countFamilies returns the number of read families in the bed file from annotateReadFamilies.
+func countFamilies(bedFile string) int {
+	var ans int
+	for b := range bed.GoReadToChan(bedFile) {
+		if b.Name != "0" { // family 0 collects reads not assigned to a family
+			ans++
+		}
+	}
+	return ans
+}
+
+func perMillion(a, b int) float64 {
+	if b == 0 {
+		return 0
+	}
+	return 1e6 * float64(a) / float64(b)
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
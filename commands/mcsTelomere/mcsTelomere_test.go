@@ -0,0 +1,104 @@
+package mcsTelomere
+
+import (
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestIsTelomeric(t *testing.T) {
+	tests := []struct {
+		seq      string
+		expected bool
+	}{
+		{"AC" + strings.Repeat("TTAGGG", 4) + "AC", true},
+		{"AC" + strings.Repeat("CCCTAA", 4) + "AC", true},
+		{"AC" + strings.Repeat("TTAGGG", 3) + "AC", false},
+		{"TTAGGGTTAGCGTTAGGGTTAGGG", false}, // interrupted run
+		{strings.Repeat("TTAGGG", 2) + strings.Repeat("CCCTAA", 2), false},
+		{"TTAGGG", false}, // shorter than the minimum length
+		{"GGG" + strings.Repeat("TTAGGG", 4), true}, // preceded by bases of the unit
+	}
+	for _, test := range tests {
+		if actual := isTelomeric(dna.StringToBases(test.seq), 24); actual != test.expected {
+			t.Errorf("expected %t for %s, got %t", test.expected, test.seq, actual)
+		}
+	}
+}
+
+func TestMcsTelomere(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "in.bam")
+	file, err := os.Create(input)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gRich := "AC" + strings.Repeat("TTAGGG", 4) + "AC"
+	cRich := "AC" + strings.Repeat("CCCTAA", 4) + "AC"
+	read := func(name string, flag uint16, pos uint32, seq, tags string) sam.Sam {
+		return sam.Sam{QName: name, Flag: flag, MapQ: 60, RName: "chr1", Pos: pos, Cigar: cigar.FromString("28M"), RNext: "*",
+			Seq: dna.StringToBases(seq), Qual: strings.Repeat("I", 28), Extra: tags}
+	}
+	w := sam.NewBamWriter(file, sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 10000}}, nil, sam.Coordinate, sam.None))
+	for _, r := range []sam.Sam{
+		read("a", 0, 10, gRich, "RS:Z:W\tRF:Z:1"),
+		read("b", 16, 20, cRich, "RS:Z:C\tRF:Z:1"), // family 1 is duplex
+		read("c", 0, 30, gRich, "RS:Z:W\tRF:Z:2"),
+		read("d", 0, 40, gRich, "RS:Z:W\tRF:Z:2"),
+		read("e", 0, 50, gRich, "RS:Z:W\tRF:Z:3"), // family 3 has only one telomeric read
+		read("f", 0, 60, strings.Repeat("ACGT", 7), "RS:Z:W\tRF:Z:3"),
+		read("g", 0, 70, gRich, "RS:Z:W\tRF:Z:0"),   // not assigned to a family
+		read("h", 256, 80, gRich, "RS:Z:W\tRF:Z:4"), // secondary
+	} {
+		sam.WriteToBamFileHandle(w, r, 0)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bedFile := filepath.Join(dir, "families.bed")
+	families := "chr1\t0\t1000\t0\t0\t+\t1\t0\n" +
+		"chr1\t10\t48\t1\t0\t+\t1\t1\n" +
+		"chr1\t30\t68\t2\t0\t+\t2\t0\n" +
+		"chr1\t50\t88\t3\t0\t+\t2\t0\n" +
+		"chr1\t80\t108\t4\t0\t+\t1\t0\n"
+	if err = os.WriteFile(bedFile, []byte(families), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "telomere.tsv")
+	mcsTelomere(input, bedFile, output, "s1", 4, 2)
+	expected := "Sample\tMetric\tValue\n" +
+		"s1\tTotalReads\t7\n" +
+		"s1\tTelomericReads\t6\n" +
+		"s1\tUnassignedTelomericReads\t1\n" +
+		"s1\tTotalFamilies\t4\n" +
+		"s1\tTelomericFamilies\t2\n" +
+		"s1\tDuplexTelomericFamilies\t1\n" +
+		"s1\tTelomericReadsPerMillionReads\t857142.8571428572\n" + // 6 of 7 reads
+		"s1\tTelomericFamiliesPerMillionFamilies\t500000\n" +
+		"s1\tDuplexTelomericFamiliesPerMillionFamilies\t250000\n"
+	actual, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(actual) != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestPerMillion(t *testing.T) {
+	if actual := perMillion(3, 12); actual != 250000 {
+		t.Errorf("expected 250000, got %g", actual)
+	}
+	if actual := perMillion(3, 0); actual != 0 {
+		t.Errorf("expected 0 when the denominator is 0, got %g", actual)
+	}
+}
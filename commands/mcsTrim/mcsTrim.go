@@ -0,0 +1,317 @@
+package mcsTrim
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/pool"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fastq"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"strings"
+)
+
+// SAM format uses ascii offset of 33 to make everything start with individual characters
+// without adding 33 you get values like spaces and newlines
+const asciiOffset uint8 = 33
+
+func usage() {
+	fmt.Print(
+		"mcsTrim - Trim adapter read-through and the error-prone terminal bases of each fragment from META-CS reads before alignment.\n" +
+			"Input is either raw FASTQ files (-1, -2), in which case barcodes are extracted as in mcsFqToBam before trimming, or an\n" +
+			"unmapped bam from mcsFqToBam (-i). Trimming is only applied to the template after the barcode and shared sequence,\n" +
+			"so barcodes are never clipped. Read-through is found by searching for the adapter (allowing mismatches and partial\n" +
+			"matches at the end of the read) and confirmed by the overlap of the two reads of the pair. The first -endTrim bases of\n" +
+			"each template are removed, as are the last -endTrim bases before the adapter when the end of the fragment is sequenced.\n" +
+			"Output is an unmapped bam with the same barcode tags as mcsFqToBam.\n" +
+			"Usage:\n" +
+			"mcsTrim [options] -1 r1.fq.gz -2 r2.fq.gz -o trimmed.bam\n" +
+			"mcsTrim [options] -i unmapped.bam -o trimmed.bam\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsTrim with the options in os.Args.
+func Main() {
+	r1 := flag.String("1", "", "FASTQ file containing R1 reads. May be gzipped.")
+	r2 := flag.String("2", "", "FASTQ file containing R2 reads. May be gzipped.")
+	input := flag.String("i", "", "Unmapped bam file generated by mcsFqToBam.")
+	output := flag.String("o", "stdout", "Output bam file.")
+	endTrim := flag.Int("endTrim", 3, "Number of bases to trim from each end of the fragment.")
+	minAdapterOverlap := flag.Int("minAdapterOverlap", 5, "Minimum number of adapter bases at the end of a read to be trimmed.")
+	maxErrorRate := flag.Float64("maxErrorRate", 0.1, "Maximum fraction of mismatches when matching the adapter and the overlap of paired reads.")
+	minLength := flag.Int("minLength", 30, "Read pairs with either read shorter than this after trimming are removed.")
+	dryrun.Parse()
+
+	if (*input == "") == (*r1 == "" && *r2 == "") {
+		usage()
+		log.Fatal("ERROR: must specify either FASTQ files (-1, -2) or an unmapped bam (-i).")
+	}
+
+	if *input == "" && (*r1 == "" || *r2 == "") {
+		usage()
+		log.Fatal("ERROR: must specify both R1 (-1) and R2 (-2) FASTQ files.")
+	}
+
+	t := trimParams{
+		adapter:           dna.StringToBases(barcode.McsSharedSequenceRevComp),
+		endTrim:           *endTrim,
+		minAdapterOverlap: *minAdapterOverlap,
+		maxErrorRate:      *maxErrorRate,
+		minLength:         *minLength,
+	}
+
+	mcsTrim(*r1, *r2, *input, *output, t)
+}
+
+// trimParams stores the options for trimming.
+type trimParams struct {
+	adapter           []dna.Base
+	endTrim           int
+	minAdapterOverlap int
+	maxErrorRate      float64
+	minLength         int
+}
+
+// trimStats counts the outcome of trimming each read pair.
+type trimStats struct {
+	pairs        int
+	noBarcode    int
+	adapter      int // pairs with adapter found in at least one read
+	confirmed    int // pairs with read-through confirmed by the read overlap
+	tooShort     int
+	basesIn      int
+	basesTrimmed int
+}
+
+func mcsTrim(r1, r2, input, output string, t trimParams) {
+	out := fileio.EasyCreate(output)
+	bw := bam.NewWriter(out, provenance.Sam(sam.GenerateHeader(nil, nil, sam.Unsorted, sam.None)))
+
+	var stats trimStats
+	if input == "" {
+		trimFastq(r1, r2, bw, t, &stats)
+	} else {
+		trimBam(input, bw, t, &stats)
+	}
+
+	cleanup(bw)
+	cleanup(out)
+	log.Printf("Processed %d read pairs. %d had no barcode. Adapter read-through found in %d pairs (%d confirmed by read overlap). "+
+		"%d pairs were shorter than -minLength after trimming. Trimmed %d of %d template bases.\n",
+		stats.pairs, stats.noBarcode, stats.adapter, stats.confirmed, stats.tooShort, stats.basesTrimmed, stats.basesIn)
+}
+
+// trimFastq extracts barcodes from raw reads, trims the templates, and writes passing pairs.
+func trimFastq(r1, r2 string, bw *bam.Writer, t trimParams, stats *trimStats) {
+	readPairs := make(chan fastq.PairedEnd, 1000)
+	go fastq.PairedEndToChan(r1, r2, readPairs)
+
+	var s1, s2 sam.Sam
+	s1.RName, s2.RName = "*", "*"
+	s1.RNext, s2.RNext = "*", "*"
+	var bcFor, bcRev, bcId, extra string
+	var start1, end1, start2, end2 int
+	var ok bool
+	for pair := range readPairs {
+		stats.pairs++
+		bcFor = barcode.Extract(pair.Fwd.Seq)
+		bcRev = barcode.Extract(pair.Rev.Seq)
+		if bcFor == "*" || bcRev == "*" {
+			stats.noBarcode++
+			continue
+		}
+
+		if bcFor > bcRev {
+			bcId = bcFor + "-" + bcRev
+		} else {
+			bcId = bcRev + "-" + bcFor
+		}
+
+		start1 = templateStart(pair.Fwd.Seq, bcFor)
+		start2 = templateStart(pair.Rev.Seq, bcRev)
+		pair.Fwd.Seq, pair.Fwd.Qual = pair.Fwd.Seq[start1:], pair.Fwd.Qual[start1:]
+		pair.Rev.Seq, pair.Rev.Qual = pair.Rev.Seq[start2:], pair.Rev.Qual[start2:]
+
+		start1, end1, start2, end2, ok = trimPair(pair.Fwd.Seq, pair.Rev.Seq, t, stats)
+		if !ok {
+			continue
+		}
+
+		fqToSam(&pair.Fwd, &s1, start1, end1, true)
+		fqToSam(&pair.Rev, &s2, start2, end2, false)
+		extra = fmt.Sprintf("AL:Z:%s\tBC:Z:%s\tBF:Z:%s\tBR:Z:%s", bcId, bcFor+"-"+bcRev, bcFor, bcRev)
+		s1.Extra = extra
+		s2.Extra = extra
+		bw.Write(s1)
+		bw.Write(s2)
+	}
+}
+
+// trimBam trims the templates of read pairs in an unmapped bam from mcsFqToBam and writes passing pairs.
+func trimBam(input string, bw *bam.Writer, t trimParams, stats *trimStats) {
+	reads, _ := hts.GoReadToChan(input)
+	var first sam.Sam
+	var havePair bool
+	var start1, end1, start2, end2 int
+	var ok bool
+	for r := range reads {
+		if !havePair {
+			first = r
+			havePair = true
+			continue
+		}
+		havePair = false
+		if r.QName != first.QName {
+			exit.Fatalf(exit.MalformedInput, "reads %s and %s are not a pair. Input must be an unmapped bam from mcsFqToBam with pairs on consecutive lines.", first.QName, r.QName)
+		}
+		stats.pairs++
+		if !sam.IsForwardRead(first) {
+			first, r = r, first
+		}
+		start1, end1, start2, end2, ok = trimPair(first.Seq, r.Seq, t, stats)
+		if !ok {
+			continue
+		}
+		trimSam(&first, start1, end1)
+		trimSam(&r, start2, end2)
+		bw.Write(first)
+		bw.Write(r)
+	}
+	if havePair {
+		log.Printf("WARNING: read %s has no mate and was not written.", first.QName)
+	}
+}
+
+// trimPair returns the range of each template to keep. Returns false if either read is shorter than t.minLength.
+func trimPair(t1, t2 []dna.Base, t trimParams, stats *trimStats) (start1, end1, start2, end2 int, ok bool) {
+	stats.basesIn += len(t1) + len(t2)
+	end1, end2 = adapterStart(t1, t), adapterStart(t2, t)
+	// the end of the fragment was sequenced in any read with adapter
+	fragEnd1, fragEnd2 := end1 < len(t1), end2 < len(t2)
+	if fragEnd1 || fragEnd2 {
+		stats.adapter++
+	}
+	switch {
+	case fragEnd1 && fragEnd2 && end1 == end2:
+		stats.confirmed++
+	case fragEnd1 && overlapAgrees(t1, t2, end1, t):
+		end2, fragEnd2 = end1, true
+		stats.confirmed++
+	case fragEnd2 && overlapAgrees(t1, t2, end2, t):
+		end1, fragEnd1 = end2, true
+		stats.confirmed++
+	}
+	if fragEnd1 {
+		end1 -= t.endTrim
+	}
+	if fragEnd2 {
+		end2 -= t.endTrim
+	}
+	start1, start2 = t.endTrim, t.endTrim
+
+	if end1-start1 < t.minLength || end2-start2 < t.minLength {
+		stats.tooShort++
+		stats.basesTrimmed += len(t1) + len(t2)
+		return 0, 0, 0, 0, false
+	}
+	stats.basesTrimmed += len(t1) - (end1 - start1) + len(t2) - (end2 - start2)
+	return start1, end1, start2, end2, true
+}
+
+// adapterStart returns the position of the first adapter match in the read, or len(read) if none is found.
+// Adapters running off the end of the read must match at least t.minAdapterOverlap bases.
+func adapterStart(read []dna.Base, t trimParams) int {
+	var n, mismatches int
+	for pos := 0; pos <= len(read)-t.minAdapterOverlap; pos++ {
+		n = min(len(t.adapter), len(read)-pos)
+		mismatches = 0
+		for i := 0; i < n && mismatches <= int(t.maxErrorRate*float64(n)); i++ {
+			if !matches(read[pos+i], t.adapter[i]) {
+				mismatches++
+			}
+		}
+		if mismatches <= int(t.maxErrorRate*float64(n)) {
+			return pos
+		}
+	}
+	return len(read)
+}
+
+// overlapAgrees returns true if the first l bases of t1 are the reverse complement of the first l bases of t2,
+// as expected when the fragment is l bases long.
+func overlapAgrees(t1, t2 []dna.Base, l int, t trimParams) bool {
+	if l < t.minLength || l > len(t1) || l > len(t2) {
+		return false
+	}
+	var mismatches int
+	maxMismatches := int(t.maxErrorRate * float64(l))
+	for i := 0; i < l; i++ {
+		if !matches(t1[i], dna.ComplementSingleBase(t2[l-1-i])) {
+			mismatches++
+			if mismatches > maxMismatches {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// matches returns true if the bases are equal or either is N.
+func matches(a, b dna.Base) bool {
+	a, b = dna.ToUpper(a), dna.ToUpper(b)
+	return a == b || a == dna.N || b == dna.N
+}
+
+// templateStart returns the position in a raw read after the barcode and shared sequence.
+func templateStart(seq []dna.Base, bc string) int {
+	s := dna.BasesToString(seq)
+	ans := strings.Index(s, barcode.McsSharedSequence)
+	if ans == -1 { // barcode was rescued without the shared sequence
+		ans = len(bc)
+	}
+	return min(ans+len(barcode.McsSharedSequence), len(seq))
+}
+
+func fqToSam(fq *fastq.Fastq, s *sam.Sam, start, end int, firstInPair bool) {
+	s.QName = fq.Name
+	s.Seq = fq.Seq[start:end]
+	qual := pool.Bytes.Get(end - start)
+	for i := start; i < end; i++ {
+		qual = append(qual, fq.Qual[i]+asciiOffset)
+	}
+	s.Qual = string(qual)
+	pool.Bytes.Put(qual)
+	if firstInPair {
+		s.Flag = 77
+	} else {
+		s.Flag = 141
+	}
+}
+
+func trimSam(s *sam.Sam, start, end int) {
+	s.Seq = s.Seq[start:end]
+	if s.Qual != "*" {
+		s.Qual = s.Qual[start:end]
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
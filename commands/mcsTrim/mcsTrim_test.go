@@ -0,0 +1,117 @@
+package mcsTrim
+
+import (
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"testing"
+)
+
+const (
+	testAdapter  = "AGATCGGAAG"
+	testFragment = "ACGTTGCAAGCTTAGC" // 16 bases
+)
+
+func revComp(s string) string {
+	b := dna.StringToBases(s)
+	dna.ReverseComplement(b)
+	return dna.BasesToString(b)
+}
+
+func TestAdapterStart(t *testing.T) {
+	p := trimParams{adapter: dna.StringToBases(testAdapter), minAdapterOverlap: 3, maxErrorRate: 0.1}
+	tests := []struct {
+		read     string
+		expected int
+	}{
+		{testFragment + testAdapter + "CCCC", 16},
+		{testFragment + "AGATCGGTAG", 16}, // one mismatch in 10 bases
+		{testFragment + "AGTTCGGTAG", 26}, // two mismatches
+		{testFragment + "AGATC", 16},      // adapter running off the end
+		{testFragment + "AG", 18},         // shorter than minAdapterOverlap
+		{testFragment + "NGATCGGAAG", 16}, // N matches any base
+		{testFragment, 16},
+	}
+	for _, test := range tests {
+		if actual := adapterStart(dna.StringToBases(test.read), p); actual != test.expected {
+			t.Errorf("expected the adapter at %d in %s, got %d", test.expected, test.read, actual)
+		}
+	}
+}
+
+func TestTrimPair(t *testing.T) {
+	p := trimParams{adapter: dna.StringToBases(testAdapter), endTrim: 2, minAdapterOverlap: 3, maxErrorRate: 0.1, minLength: 5}
+	tests := []struct {
+		read1, read2  string
+		minLength     int
+		expectedEnd1  int
+		expectedEnd2  int
+		expectedOk    bool
+		expectedStats trimStats
+	}{
+		{ // adapter in both reads at the same position
+			testFragment + testAdapter + "CCCC", revComp(testFragment) + testAdapter + "CCCC", 5,
+			14, 14, true, trimStats{adapter: 1, confirmed: 1, basesIn: 60, basesTrimmed: 36},
+		},
+		{ // adapter only in read 1, confirmed by the reverse complement at the start of read 2
+			testFragment + testAdapter + "CCCC", revComp(testFragment), 5,
+			14, 14, true, trimStats{adapter: 1, confirmed: 1, basesIn: 46, basesTrimmed: 22},
+		},
+		{ // adapter only in read 1, not confirmed by read 2
+			testFragment + testAdapter + "CCCC", "TTTTTTTTTTTTTTTTTTTT", 5,
+			14, 20, true, trimStats{adapter: 1, basesIn: 50, basesTrimmed: 20},
+		},
+		{ // no adapter, only the first endTrim bases are removed
+			testFragment, revComp(testFragment), 5,
+			16, 16, true, trimStats{basesIn: 32, basesTrimmed: 4},
+		},
+		{ // too short after trimming
+			testFragment + testAdapter + "CCCC", revComp(testFragment) + testAdapter + "CCCC", 13,
+			0, 0, false, trimStats{adapter: 1, confirmed: 1, tooShort: 1, basesIn: 60, basesTrimmed: 60},
+		},
+	}
+	for i, test := range tests {
+		p.minLength = test.minLength
+		var stats trimStats
+		start1, end1, start2, end2, ok := trimPair(dna.StringToBases(test.read1), dna.StringToBases(test.read2), p, &stats)
+		if ok != test.expectedOk || end1 != test.expectedEnd1 || end2 != test.expectedEnd2 {
+			t.Errorf("test %d: expected ends %d and %d (%t), got %d and %d (%t)", i, test.expectedEnd1, test.expectedEnd2, test.expectedOk, end1, end2, ok)
+		}
+		if ok && (start1 != 2 || start2 != 2) {
+			t.Errorf("test %d: expected both reads to start after endTrim, got %d and %d", i, start1, start2)
+		}
+		if stats != test.expectedStats {
+			t.Errorf("test %d: expected stats %+v, got %+v", i, test.expectedStats, stats)
+		}
+	}
+}
+
+func TestTemplateStart(t *testing.T) {
+	tests := []struct {
+		seq      string
+		bc       string
+		expected int
+	}{
+		{"ACGTAC" + barcode.McsSharedSequence + testFragment, "ACGTAC", 6 + len(barcode.McsSharedSequence)},
+		{"ACGTACG" + testFragment + "ACGTACGTACGT", "ACGTACG", 7 + len(barcode.McsSharedSequence)}, // shared sequence not found
+		{"ACGTACG" + "TTTT", "ACGTACG", 11}, // shorter than the shared sequence
+	}
+	for _, test := range tests {
+		if actual := templateStart(dna.StringToBases(test.seq), test.bc); actual != test.expected {
+			t.Errorf("expected the template to start at %d in %s, got %d", test.expected, test.seq, actual)
+		}
+	}
+}
+
+func TestTrimSam(t *testing.T) {
+	s := sam.Sam{Seq: dna.StringToBases("ACGTACGT"), Qual: "ABCDEFGH"}
+	trimSam(&s, 2, 6)
+	if dna.BasesToString(s.Seq) != "GTAC" || s.Qual != "CDEF" {
+		t.Errorf("expected GTAC with CDEF, got %s with %s", dna.BasesToString(s.Seq), s.Qual)
+	}
+	s = sam.Sam{Seq: dna.StringToBases("ACGTACGT"), Qual: "*"}
+	trimSam(&s, 2, 6)
+	if dna.BasesToString(s.Seq) != "GTAC" || s.Qual != "*" {
+		t.Errorf("expected GTAC without qualities, got %s with %s", dna.BasesToString(s.Seq), s.Qual)
+	}
+}
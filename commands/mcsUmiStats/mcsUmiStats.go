@@ -0,0 +1,305 @@
+package mcsUmiStats
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/preflight"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsUmiStats - Report UMI diversity, family size saturation, and estimated library complexity from a bam annotated with annotateReadFamilies.\n" +
+			"Read pairs are counted once using the first read of each pair. Saturation is reported by subsampling the observed reads\n" +
+			"(expected families at each fraction of the current depth) and by extrapolating to deeper sequencing from the estimated\n" +
+			"number of unique molecules in the library (Lander-Waterman model, as in Picard EstimateLibraryComplexity).\n" +
+			"Usage:\n" +
+			"mcsUmiStats [options] -i annotated.bam -curveOut saturation.tsv > umiStats.tsv\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsUmiStats with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file annotated with annotateReadFamilies.")
+	output := flag.String("o", "stdout", "Output file with UMI and complexity metrics.")
+	curveOut := flag.String("curveOut", "", "Output file with expected families and duplex families versus sequencing depth.")
+	sample := flag.String("sample", "", "Sample name to report in output. Defaults to the input bam file name.")
+	minMapQ := flag.Int("minMapQ", 20, "Minimum mapping quality for a read to be counted.")
+	steps := flag.Int("steps", 20, "Number of subsampling fractions between 0 and the current depth in -curveOut.")
+	maxFold := flag.Float64("maxFold", 10, "Extrapolate -curveOut up to this multiple of the current depth.")
+	dryrun.Parse()
+
+	if *input == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i).")
+	}
+
+	if *steps < 1 || *maxFold < 1 {
+		usage()
+		log.Fatal("ERROR: -steps and -maxFold must be >= 1.")
+	}
+
+	if *sample == "" {
+		*sample = strings.TrimSuffix(filepath.Base(*input), ".bam")
+	}
+
+	mcsUmiStats(*input, *output, *curveOut, *sample, uint8(*minMapQ), *steps, *maxFold)
+}
+
+// familyCounts stores the number of read pairs on each strand of a family.
+type familyCounts struct {
+	watson int
+	crick  int
+}
+
+func mcsUmiStats(input, output, curveOut, sample string, minMapQ uint8, steps int, maxFold float64) {
+	preflight.Bam{Tags: []string{"RF", "RS"}}.Check(input)
+	reads, _ := hts.GoReadToChan(input)
+	families := make(map[string]*familyCounts)
+	var ordered []*familyCounts // families in the order first seen, so sums over them are the same every run
+	forward := make(map[string]int)
+	reverse := make(map[string]int)
+	pairs := make(map[string]int)
+	var f *familyCounts
+	var rf, bcFor, bcRev string
+	var rs byte
+	var totalReads, barcodedReads, whitelistReads, familyReads int
+	for r := range reads {
+		if sam.IsUnmapped(r) || sam.IsNotPrimaryAlign(r) || sam.IsSupplementaryAlign(r) || r.MapQ < minMapQ {
+			continue
+		}
+		if sam.IsPaired(r) && !sam.IsForwardRead(r) {
+			continue
+		}
+		totalReads++
+		sam.ParseExtra(&r)
+		bcFor, bcRev = barcode.Get(r)
+		if bcFor != "" && bcRev != "" {
+			barcodedReads++
+			forward[bcFor]++
+			reverse[bcRev]++
+			pairs[bcFor+"-"+bcRev]++
+			if barcode.Barcodes[bcFor] && barcode.Barcodes[bcRev] {
+				whitelistReads++
+			}
+		}
+
+		rf = barcode.GetRF(&r)
+		if idx := strings.IndexByte(rf, '\t'); idx != -1 {
+			rf = rf[:idx]
+		}
+		rs = barcode.GetRS(&r)
+		if rf == "" || rf == "0" || (rs != 'W' && rs != 'C') { // RF:Z:0 collects reads not assigned to a family
+			continue
+		}
+		familyReads++
+		if f = families[rf]; f == nil {
+			f = new(familyCounts)
+			families[rf] = f
+			ordered = append(ordered, f)
+		}
+		if rs == 'W' {
+			f.watson++
+		} else {
+			f.crick++
+		}
+	}
+	if familyReads == 0 {
+		exit.Fatalf(exit.MissingTag, "no reads with family tags (RF, RS) were found. Input must be annotated with annotateReadFamilies.")
+	}
+
+	var duplexFamilies, strands int
+	sizes := make([]int, 0, len(families))
+	for _, f = range families {
+		sizes = append(sizes, f.watson+f.crick)
+		if f.watson > 0 {
+			strands++
+		}
+		if f.crick > 0 {
+			strands++
+		}
+		if f.watson > 0 && f.crick > 0 {
+			duplexFamilies++
+		}
+	}
+	sort.Ints(sizes)
+
+	// each strand of a molecule is sampled independently, so molecules with both strands in the library are
+	// the difference between the number of unique strands and the number of unique molecules
+	c := estimateLibrary(familyReads, len(families), strands)
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintf(out, "Sample\tMetric\tValue\n")
+	exception.PanicOnErr(err)
+	lines := []struct {
+		name  string
+		value interface{}
+	}{
+		{"ReadPairs", totalReads},
+		{"BarcodedReadPairs", barcodedReads},
+		{"WhitelistBarcodeFraction", fraction(whitelistReads, barcodedReads)},
+		{"UniqueForwardBarcodes", len(forward)},
+		{"UniqueReverseBarcodes", len(reverse)},
+		{"UniqueBarcodePairs", len(pairs)},
+		{"BarcodePairEntropy", fmt.Sprintf("%.4f", entropy(pairs))},
+		{"EffectiveBarcodePairs", fmt.Sprintf("%.2f", math.Pow(2, entropy(pairs)))},
+		{"FamilyReadPairs", familyReads},
+		{"Families", len(families)},
+		{"SingleStrandFamilies", strands},
+		{"DuplexFamilies", duplexFamilies},
+		{"MeanFamilySize", fmt.Sprintf("%.2f", float64(familyReads)/float64(len(families)))},
+		{"MedianFamilySize", sizes[len(sizes)/2]},
+		{"SingletonFamilyFraction", fraction(sort.SearchInts(sizes, 2), len(sizes))},
+		{"EstimatedMolecules", estimate(c.molecules)},
+		{"EstimatedDuplexMolecules", estimate(c.duplexMolecules)},
+		{"FractionMoleculesObserved", estimate(float64(len(families)) / c.molecules)},
+		{"FractionDuplexMoleculesObserved", estimate(float64(duplexFamilies) / c.duplexMolecules)},
+	}
+	for _, l := range lines {
+		_, err = fmt.Fprintf(out, "%s\t%s\t%v\n", sample, l.name, l.value)
+		exception.PanicOnErr(err)
+	}
+
+	if curveOut != "" {
+		writeCurve(curveOut, sample, ordered, familyReads, c, steps, maxFold)
+	}
+}
+
+// writeCurve writes the expected number of families and duplex families when sequencing to a
+// multiple of the current depth. Subsampled values are computed exactly from the observed family
+// sizes while values above the current depth are extrapolated from the estimated library complexity.
+func writeCurve(file, sample string, families []*familyCounts, reads int, c complexity, steps int, maxFold float64) {
+	out := fileio.EasyCreate(file)
+	defer cleanup(out)
+	_, err := fmt.Fprintln(out, "Sample\tFold\tReadPairs\tFamilies\tDuplexFamilies\tMeanFamilySize\tMethod")
+	exception.PanicOnErr(err)
+
+	var fam, duplex, pw, pc float64
+	var fold float64
+	for i := 1; i <= steps; i++ {
+		fold = float64(i) / float64(steps)
+		fam, duplex = 0, 0
+		for _, f := range families {
+			pw = 1 - math.Pow(1-fold, float64(f.watson))
+			pc = 1 - math.Pow(1-fold, float64(f.crick))
+			fam += 1 - (1-pw)*(1-pc)
+			duplex += pw * pc
+		}
+		writeCurveLine(out, sample, fold, float64(reads)*fold, fam, duplex, "subsampled")
+	}
+
+	if math.IsInf(c.molecules, 1) {
+		log.Println("WARNING: all families are singletons. Library complexity cannot be estimated and -curveOut will not be extrapolated.")
+		return
+	}
+	var strandSeen float64
+	for fold = 2; fold <= maxFold; fold++ {
+		fam = c.molecules * (1 - math.Exp(-fold*float64(reads)/c.molecules))
+		strandSeen = 1 - math.Exp(-fold*float64(reads)/c.strands)
+		duplex = c.duplexMolecules * strandSeen * strandSeen
+		writeCurveLine(out, sample, fold, float64(reads)*fold, fam, duplex, "extrapolated")
+	}
+}
+
+func writeCurveLine(out io.Writer, sample string, fold, reads, families, duplex float64, method string) {
+	var meanSize float64
+	if families > 0 {
+		meanSize = reads / families
+	}
+	_, err := fmt.Fprintf(out, "%s\t%.3g\t%.0f\t%.0f\t%.0f\t%.2f\t%s\n", sample, fold, reads, families, duplex, meanSize, method)
+	exception.PanicOnErr(err)
+}
+
+// complexity stores the estimated number of unique units in a library.
+type complexity struct {
+	molecules       float64
+	strands         float64
+	duplexMolecules float64 // molecules with both strands present in the library
+}
+
+// estimateLibrary estimates the library complexity from the number of reads, families, and single-strand families.
+func estimateLibrary(reads, families, strands int) complexity {
+	ans := complexity{molecules: estimateComplexity(reads, families), strands: estimateComplexity(reads, strands)}
+	ans.duplexMolecules = math.Max(ans.strands-ans.molecules, 0)
+	return ans
+}
+
+// estimateComplexity returns the number of unique units (X) in a library where n reads gave c unique units by
+// solving c/X = 1 - exp(-n/X) with bisection, as in Picard EstimateLibraryComplexity.
+func estimateComplexity(n, c int) float64 {
+	if c >= n { // every read unique, complexity cannot be estimated
+		return math.Inf(1)
+	}
+	f := func(x float64) float64 {
+		return float64(c)/x - 1 + math.Exp(-float64(n)/x)
+	}
+	lower, upper := float64(c), float64(c)*10
+	for f(upper) > 0 {
+		lower = upper
+		upper *= 10
+	}
+	for i := 0; i < 100 && upper-lower > 0.5; i++ {
+		mid := (lower + upper) / 2
+		if f(mid) > 0 {
+			lower = mid
+		} else {
+			upper = mid
+		}
+	}
+	return (lower + upper) / 2
+}
+
+// entropy returns the Shannon entropy in bits of the counts.
+func entropy(counts map[string]int) float64 {
+	var total int
+	for _, c := range counts {
+		total += c
+	}
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys) // the float sum depends on the order
+	var ans, p float64
+	for _, k := range keys {
+		p = float64(counts[k]) / float64(total)
+		ans -= p * math.Log2(p)
+	}
+	return ans
+}
+
+// estimate formats a value derived from the library complexity, which is undefined if every family is a singleton.
+func estimate(x float64) string {
+	if math.IsInf(x, 0) || math.IsNaN(x) {
+		return "NA"
+	}
+	if x < 1 {
+		return fmt.Sprintf("%.4f", x)
+	}
+	return fmt.Sprintf("%.0f", x)
+}
+
+func fraction(a, b int) string {
+	if b == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.4f", float64(a)/float64(b))
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
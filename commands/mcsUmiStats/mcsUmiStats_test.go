@@ -0,0 +1,103 @@
+package mcsUmiStats
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEstimateComplexity(t *testing.T) {
+	tests := []struct {
+		n, c     int
+		expected float64
+	}{
+		{1000, 632, 1000}, // 1000 * (1 - exp(-1))
+		{2000, 865, 1000}, // 1000 * (1 - exp(-2))
+		{500, 393, 1000},  // 1000 * (1 - exp(-0.5))
+		{100, 100, math.Inf(1)},
+	}
+	for _, test := range tests {
+		actual := estimateComplexity(test.n, test.c)
+		if math.IsInf(test.expected, 1) != math.IsInf(actual, 1) || math.Abs(actual-test.expected) > 0.01*test.expected {
+			t.Errorf("expected about %g molecules from %d unique of %d reads, got %g", test.expected, test.c, test.n, actual)
+		}
+	}
+}
+
+func TestEstimateLibrary(t *testing.T) {
+	c := estimateLibrary(1000, 632, 632)
+	if math.Abs(c.molecules-1000) > 10 || c.strands != c.molecules || c.duplexMolecules != 0 {
+		t.Errorf("expected no duplex molecules when each family has one strand, got %+v", c)
+	}
+	c = estimateLibrary(1000, 632, 865)
+	if c.strands <= c.molecules || math.Abs(c.duplexMolecules-(c.strands-c.molecules)) > 1e-9 {
+		t.Errorf("expected duplex molecules to be the strands less the molecules, got %+v", c)
+	}
+}
+
+func TestEntropy(t *testing.T) {
+	tests := []struct {
+		counts   map[string]int
+		expected float64
+	}{
+		{map[string]int{"a": 5}, 0},
+		{map[string]int{"a": 1, "b": 1}, 1},
+		{map[string]int{"a": 2, "b": 1, "c": 1}, 1.5},
+		{map[string]int{"a": 1, "b": 1, "c": 1, "d": 1}, 2},
+	}
+	for _, test := range tests {
+		if actual := entropy(test.counts); math.Abs(actual-test.expected) > 1e-12 {
+			t.Errorf("expected %g bits for %v, got %g", test.expected, test.counts, actual)
+		}
+	}
+}
+
+func TestEstimate(t *testing.T) {
+	tests := []struct {
+		x        float64
+		expected string
+	}{
+		{math.Inf(1), "NA"},
+		{math.NaN(), "NA"},
+		{0.25, "0.2500"},
+		{1234.4, "1234"},
+	}
+	for _, test := range tests {
+		if actual := estimate(test.x); actual != test.expected {
+			t.Errorf("expected %s for %g, got %s", test.expected, test.x, actual)
+		}
+	}
+}
+
+func TestWriteCurve(t *testing.T) {
+	families := make([]*familyCounts, 20)
+	for i := range families {
+		families[i] = &familyCounts{watson: 1, crick: 1}
+	}
+	tests := []struct {
+		c        complexity
+		expected string
+	}{
+		{ // each strand is kept with half of the reads, so 3/4 of families and 1/4 of duplexes are
+			complexity{molecules: math.Inf(1)},
+			"Sample\tFold\tReadPairs\tFamilies\tDuplexFamilies\tMeanFamilySize\tMethod\n" +
+				"s\t0.5\t20\t15\t5\t1.33\tsubsampled\n" +
+				"s\t1\t40\t20\t20\t2.00\tsubsampled\n",
+		},
+		{ // 100 * (1 - exp(-80/100)) families and 50 * (1 - exp(-80/150))^2 duplexes
+			complexity{molecules: 100, strands: 150, duplexMolecules: 50},
+			"Sample\tFold\tReadPairs\tFamilies\tDuplexFamilies\tMeanFamilySize\tMethod\n" +
+				"s\t0.5\t20\t15\t5\t1.33\tsubsampled\n" +
+				"s\t1\t40\t20\t20\t2.00\tsubsampled\n" +
+				"s\t2\t80\t55\t9\t1.45\textrapolated\n",
+		},
+	}
+	for _, test := range tests {
+		file := filepath.Join(t.TempDir(), "curve.tsv")
+		writeCurve(file, "s", families, 40, test.c, 2, 2)
+		if actual, _ := os.ReadFile(file); string(actual) != test.expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", test.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package mcsValidate
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/preflight"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsValidate - Check that a bam file meets the assumptions of annotateReadFamilies and mcsCallVariants before running them.\n" +
+			"Checks: bam can be decoded, header and reads are coordinate sorted, index is present and newer than the bam,\n" +
+			"family tags are present, bam contigs match the reference, and base qualities look like Phred+33.\n" +
+			"Each check is reported as PASS, WARN, or FAIL with a suggested fix. Exits with status 1 if any check fails.\n" +
+			"Usage:\n" +
+			"mcsValidate [options] -i input.bam -r reference.fasta\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs mcsValidate with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file.")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai). If not set, the contig check is skipped.")
+	output := flag.String("o", "stdout", "Output report file.")
+	stage := flag.String("stage", "annotated", "Which tool the bam is intended for. 'raw' checks for barcode tags (BF, BR) required by annotateReadFamilies, "+
+		"'annotated' checks for family tags (RF, RS) required by mcsCallVariants.")
+	numReads := flag.Int("n", 1_000_000, "Number of reads to check from the start of the bam. Set to -1 to check all reads.")
+	minTagFrac := flag.Float64("minTagFrac", 0.5, "Minimum fraction of mapped primary reads carrying the required tags.")
+	dryrun.Parse()
+
+	if *input == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i).")
+	}
+
+	if *stage != "raw" && *stage != "annotated" {
+		usage()
+		log.Fatal("ERROR: -stage must be 'raw' or 'annotated'.")
+	}
+
+	if !mcsValidate(*input, *ref, *output, *stage, *numReads, *minTagFrac) {
+		os.Exit(1)
+	}
+}
+
+type status string
+
+const (
+	pass status = "PASS"
+	warn status = "WARN"
+	fail status = "FAIL"
+)
+
+// result is the outcome of a single check.
+type result struct {
+	check  string
+	status status
+	detail string
+	fix    string
+}
+
+func mcsValidate(input, ref, output, stage string, numReads int, minTagFrac float64) bool {
+	var results []result
+	header, err := preflight.ReadHeader(input)
+	if err != nil {
+		results = append(results, result{check: "bam", status: fail, detail: err.Error(), fix: "Confirm the file is a complete bam (not sam or cram) and was not truncated during transfer."})
+		return report(output, results)
+	}
+	results = append(results, checkSortOrder(header))
+	results = append(results, checkIndex(input))
+	if ref != "" {
+		results = append(results, checkContigs(header, ref))
+	}
+	results = append(results, checkReads(input, header, stage, numReads, minTagFrac)...)
+	return report(output, results)
+}
+
+func checkSortOrder(header sam.Header) result {
+	ans := result{check: "headerSortOrder"}
+	if len(header.Metadata.SortOrder) > 0 && header.Metadata.SortOrder[0] == sam.Coordinate {
+		ans.status = pass
+		ans.detail = "@HD SO:coordinate"
+		return ans
+	}
+	ans.status = warn
+	ans.detail = "header does not declare SO:coordinate"
+	if len(header.Metadata.SortOrder) > 0 {
+		ans.detail = fmt.Sprintf("header declares SO:%s", header.Metadata.SortOrder[0])
+	}
+	ans.fix = "Sort with 'samtools sort'. If the reads are already sorted (see readSortOrder) this warning can be ignored."
+	return ans
+}
+
+func checkIndex(input string) result {
+	ans := result{check: "index"}
+	idx, stale, found := preflight.FindIndex(input)
+	switch {
+	case !found:
+		ans.status = fail
+		ans.detail = "no .bai index found"
+		ans.fix = fmt.Sprintf("Run 'samtools index %s'.", input)
+	case stale:
+		ans.status = fail
+		ans.detail = idx + " is older than the bam"
+		ans.fix = fmt.Sprintf("The bam was modified after indexing. Re-run 'samtools index %s'.", input)
+	default:
+		ans.status = pass
+		ans.detail = idx
+	}
+	return ans
+}
+
+func checkContigs(header sam.Header, ref string) result {
+	ans := result{check: "referenceContigs"}
+	if _, err := os.Stat(ref + ".fai"); err != nil {
+		ans.status = fail
+		ans.detail = fmt.Sprintf("%s.fai not found", ref)
+		ans.fix = fmt.Sprintf("Run 'samtools faidx %s'.", ref)
+		return ans
+	}
+	idx := fai.ReadIndex(ref + ".fai")
+	refSizes := make(map[string]int)
+	for _, name := range idx.Names() {
+		refSizes[name] = idx.Size(name)
+	}
+	var missing, mismatched []string
+	for _, c := range header.Chroms {
+		size, found := refSizes[c.Name]
+		switch {
+		case !found:
+			missing = append(missing, c.Name)
+		case size != c.Size:
+			mismatched = append(mismatched, fmt.Sprintf("%s (bam: %d, ref: %d)", c.Name, c.Size, size))
+		}
+	}
+	switch {
+	case len(header.Chroms) == 0:
+		ans.status = fail
+		ans.detail = "bam header has no @SQ lines"
+		ans.fix = "Re-align the reads or restore the header with 'samtools reheader'."
+	case len(mismatched) > 0:
+		ans.status = fail
+		ans.detail = fmt.Sprintf("%d contigs differ in length: %s", len(mismatched), summarize(mismatched))
+		ans.fix = "The bam was aligned to a different reference build. Use the reference the reads were aligned to."
+	case len(missing) == len(header.Chroms):
+		ans.status = fail
+		ans.detail = fmt.Sprintf("no bam contigs found in reference (e.g. bam: %s, ref: %s)", header.Chroms[0].Name, summarize(idx.Names()))
+		ans.fix = "Check for a naming mismatch such as 'chr1' vs '1' and use a reference with matching names."
+	case len(missing) > 0:
+		ans.status = warn
+		ans.detail = fmt.Sprintf("%d bam contigs missing from reference: %s", len(missing), summarize(missing))
+		ans.fix = "Reads on missing contigs will fail to call. Exclude them with -e or use the full reference."
+	default:
+		ans.status = pass
+		ans.detail = fmt.Sprintf("%d contigs match", len(header.Chroms))
+	}
+	return ans
+}
+
+// checkReads decodes up to numReads reads and checks read sort order, tags, and base quality encoding.
+func checkReads(input string, header sam.Header, stage string, numReads int, minTagFrac float64) []result {
+	var ans []result
+	tags := []string{"RF", "RS"}
+	if stage == "raw" {
+		tags = []string{"BF", "BR"}
+	}
+	s := preflight.Read(input, header, numReads, tags...)
+	decoded, checked, tagged, noQual := s.Reads, s.Mapped, s.AllTagged, s.NoQual
+	minQual, maxQual := s.MinQual, s.MaxQual
+
+	decode := result{check: "decode", status: pass, detail: fmt.Sprintf("%d reads decoded", decoded)}
+	if s.Err != nil {
+		decode.status = fail
+		decode.detail = fmt.Sprintf("error after %d reads: %s", decoded, s.Err)
+		decode.fix = "The bam is truncated or corrupt. Re-generate or re-download it."
+	}
+	ans = append(ans, decode)
+
+	sortResult := result{check: "readSortOrder", status: pass, detail: fmt.Sprintf("first %d reads are coordinate sorted", decoded)}
+	if s.Unsorted != "" {
+		sortResult.status = fail
+		sortResult.detail = "reads are not coordinate sorted: " + s.Unsorted
+		sortResult.fix = "Sort with 'samtools sort' and re-index."
+	}
+	ans = append(ans, sortResult)
+
+	tagFix := "Run annotateReadFamilies on this bam before calling."
+	if stage == "raw" {
+		tagFix = "Barcodes must be moved to BF/BR tags before alignment (see mcsFqToBam or extractIdtDuplex)."
+	}
+	tagResult := result{check: "tags", status: pass}
+	tagResult.detail = fmt.Sprintf("%d of %d mapped primary reads have %s", tagged, checked, strings.Join(tags, ", "))
+	switch {
+	case checked == 0:
+		tagResult.status = fail
+		tagResult.detail = "no mapped primary reads found"
+		tagResult.fix = "Check that the bam contains aligned reads."
+	case float64(tagged) < minTagFrac*float64(checked):
+		tagResult.status = fail
+		tagResult.fix = tagFix
+	}
+	ans = append(ans, tagResult)
+
+	qual := result{check: "baseQuality", status: pass, detail: fmt.Sprintf("Phred range %d-%d", minQual, maxQual)}
+	switch {
+	case decoded > 0 && noQual == decoded:
+		qual.status = fail
+		qual.detail = "no reads have base qualities"
+		qual.fix = "mcsCallVariants requires base qualities. Re-generate the bam keeping the fastq qualities."
+	case s.Phred64():
+		qual.status = warn
+		qual.detail += ". Lowest quality is unusually high, qualities may be Phred+64 encoded"
+		qual.fix = "Convert the fastq to Phred+33 (e.g. 'seqtk seq -Q64 -V') and re-align."
+	case noQual > 0:
+		qual.status = warn
+		qual.detail += fmt.Sprintf(". %d reads have no base qualities", noQual)
+		qual.fix = "Reads without base qualities will be ignored."
+	}
+	ans = append(ans, qual)
+	return ans
+}
+
+func report(output string, results []result) bool {
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	ok := true
+	var err error
+	for _, r := range results {
+		if r.status == fail {
+			ok = false
+		}
+		_, err = fmt.Fprintf(out, "%s\t%s\t%s\n", r.status, r.check, r.detail)
+		exception.PanicOnErr(err)
+		if r.fix != "" {
+			_, err = fmt.Fprintf(out, "\t\tFix: %s\n", r.fix)
+			exception.PanicOnErr(err)
+		}
+	}
+	if ok {
+		_, err = fmt.Fprintln(out, "All required checks passed.")
+	} else {
+		_, err = fmt.Fprintln(out, "One or more checks failed. Resolve FAIL items before running the callers.")
+	}
+	exception.PanicOnErr(err)
+	return ok
+}
+
+// summarize lists up to 5 items.
+func summarize(s []string) string {
+	if len(s) > 5 {
+		return strings.Join(s[:5], ", ") + fmt.Sprintf(", ... (%d more)", len(s)-5)
+	}
+	return strings.Join(s, ", ")
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
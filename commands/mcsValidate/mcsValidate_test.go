@@ -0,0 +1,106 @@
+package mcsValidate
+
+import (
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/sam"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckSortOrder(t *testing.T) {
+	tests := []struct {
+		order          []sam.SortOrder
+		expectedStatus status
+		expectedDetail string
+	}{
+		{[]sam.SortOrder{sam.Coordinate}, pass, "@HD SO:coordinate"},
+		{[]sam.SortOrder{sam.QueryName}, warn, "header declares SO:queryname"},
+		{nil, warn, "header does not declare SO:coordinate"},
+	}
+	for _, test := range tests {
+		var header sam.Header
+		header.Metadata.SortOrder = test.order
+		actual := checkSortOrder(header)
+		if actual.status != test.expectedStatus || actual.detail != test.expectedDetail {
+			t.Errorf("expected %s %q for %v, got %s %q", test.expectedStatus, test.expectedDetail, test.order, actual.status, actual.detail)
+		}
+	}
+}
+
+func TestCheckContigs(t *testing.T) {
+	ref := filepath.Join(t.TempDir(), "ref.fa")
+	if err := os.WriteFile(ref+".fai", []byte("chr1\t100\t6\t60\t61\nchr2\t50\t114\t60\t61\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		chroms         []chromInfo.ChromInfo
+		expectedStatus status
+		expectedDetail string
+	}{
+		{[]chromInfo.ChromInfo{{Name: "chr1", Size: 100}, {Name: "chr2", Size: 50}}, pass, "2 contigs match"},
+		{[]chromInfo.ChromInfo{{Name: "chr1", Size: 100}, {Name: "chrM", Size: 16569}}, warn, "1 bam contigs missing from reference: chrM"},
+		{[]chromInfo.ChromInfo{{Name: "chr1", Size: 120}, {Name: "chrM", Size: 16569}}, fail, "1 contigs differ in length: chr1 (bam: 120, ref: 100)"},
+		{[]chromInfo.ChromInfo{{Name: "1", Size: 100}}, fail, "no bam contigs found in reference (e.g. bam: 1, ref: chr1, chr2)"},
+		{nil, fail, "bam header has no @SQ lines"},
+	}
+	for _, test := range tests {
+		actual := checkContigs(sam.Header{Chroms: test.chroms}, ref)
+		if actual.status != test.expectedStatus || actual.detail != test.expectedDetail {
+			t.Errorf("expected %s %q for %v, got %s %q", test.expectedStatus, test.expectedDetail, test.chroms, actual.status, actual.detail)
+		}
+	}
+	if actual := checkContigs(sam.Header{}, filepath.Join(t.TempDir(), "missing.fa")); actual.status != fail || !strings.HasSuffix(actual.detail, ".fai not found") {
+		t.Errorf("expected a missing fai to fail, got %s %q", actual.status, actual.detail)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	tests := []struct {
+		items    []string
+		expected string
+	}{
+		{nil, ""},
+		{[]string{"a"}, "a"},
+		{[]string{"a", "b", "c", "d", "e"}, "a, b, c, d, e"},
+		{[]string{"a", "b", "c", "d", "e", "f", "g"}, "a, b, c, d, e, ... (2 more)"},
+	}
+	for _, test := range tests {
+		if actual := summarize(test.items); actual != test.expected {
+			t.Errorf("expected %q for %v, got %q", test.expected, test.items, actual)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	tests := []struct {
+		results    []result
+		expectedOk bool
+		expected   string
+	}{
+		{
+			results:    []result{{check: "index", status: pass, detail: "found"}, {check: "headerSortOrder", status: warn, detail: "unsorted", fix: "Sort it."}},
+			expectedOk: true,
+			expected:   "PASS\tindex\tfound\nWARN\theaderSortOrder\tunsorted\n\t\tFix: Sort it.\nAll required checks passed.\n",
+		},
+		{
+			results:    []result{{check: "index", status: fail, detail: "no .bai index found", fix: "Index it."}},
+			expectedOk: false,
+			expected:   "FAIL\tindex\tno .bai index found\n\t\tFix: Index it.\nOne or more checks failed. Resolve FAIL items before running the callers.\n",
+		},
+	}
+	for _, test := range tests {
+		output := filepath.Join(t.TempDir(), "report.txt")
+		if ok := report(output, test.results); ok != test.expectedOk {
+			t.Errorf("expected report to return %t for %v", test.expectedOk, test.results)
+		}
+		actual, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(actual) != test.expected {
+			t.Errorf("expected:\n%s\ngot:\n%s", test.expected, actual)
+		}
+	}
+}
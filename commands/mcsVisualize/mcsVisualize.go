@@ -0,0 +1,499 @@
+package mcsVisualize
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"html/template"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"mcsVisualize - Render a mini-pileup of the watson and crick reads of each read family supporting a variant for manual curation.\n" +
+			"Families are selected if at least one read carries the variant allele, or by ID with -family.\n" +
+			"Output is plain text unless -o ends with .html. In text output:\n" +
+			"\t. and , are bases matching the reference on the forward and reverse strand.\n" +
+			"\tACGTN are mismatches, acgtn are bases masked for quality below -minBaseQuality.\n" +
+			"\t* is a deletion and ~ is a soft clipped base. Insertions are listed after each read.\n" +
+			"\tThe variant position is marked with 'v' above the reference.\n" +
+			"Input bam must be indexed (.bai).\n" +
+			"Usage:\n" +
+			"mcsVisualize [options] -i annotated.bam -r ref.fasta -v variants.vcf -o curation.html\n" +
+			"mcsVisualize [options] -i annotated.bam -r ref.fasta -region chr1:1000000 -family 1234\n\n")
+	flag.PrintDefaults()
+}
+
+// inputFiles is a custom type that gets filled by flag.Parse()
+type inputFiles []string
+
+// String to satisfy flag.Value interface
+func (i *inputFiles) String() string {
+	return strings.Join(*i, " ")
+}
+
+// Set to satisfy flag.Value interface
+func (i *inputFiles) Set(value string) error {
+	*i = append(*i, value)
+	return nil
+}
+
+// Main runs mcsVisualize with the options in os.Args.
+func Main() {
+	var families inputFiles
+	input := flag.String("i", "", "Input bam file annotated with annotateReadFamilies. Must be indexed (.bai).")
+	ref := flag.String("r", "", "Reference FASTA file. Must be indexed (.fai).")
+	vcfFile := flag.String("v", "", "VCF file of variants to visualize.")
+	region := flag.String("region", "", "Position to visualize formatted as chr:pos (1-based). Alternative to -v.")
+	flag.Var(&families, "family", "Family ID to display. May be declared more than once. If not set, families supporting the variant are shown.")
+	output := flag.String("o", "stdout", "Output file. Written as HTML if the file name ends with .html.")
+	pad := flag.Int("pad", 30, "Number of bases to display on either side of the variant.")
+	minBaseQuality := flag.Int("minBaseQuality", 20, "Bases below this quality are displayed as masked.")
+	maxFamilies := flag.Int("maxFamilies", 10, "Maximum number of families to display per variant.")
+	maxVariants := flag.Int("maxVariants", 100, "Maximum number of variants to display from -v.")
+	dryrun.Parse()
+
+	if *input == "" || *ref == "" {
+		usage()
+		log.Fatal("ERROR: must specify input bam (-i) and reference (-r).")
+	}
+
+	if (*vcfFile == "") == (*region == "") {
+		usage()
+		log.Fatal("ERROR: must specify exactly one of -v or -region.")
+	}
+
+	var sites []site
+	if *vcfFile != "" {
+		sites = readSites(*vcfFile, *maxVariants)
+	} else {
+		sites = []site{parseRegion(*region)}
+	}
+
+	p := displayParams{
+		pad:            *pad,
+		minBaseQuality: uint8(*minBaseQuality),
+		maxFamilies:    *maxFamilies,
+		families:       make(map[string]bool),
+	}
+	for _, f := range families {
+		p.families[f] = true
+	}
+
+	mcsVisualize(*input, *ref, *output, sites, p)
+}
+
+// displayParams stores the settings for selecting and rendering families.
+type displayParams struct {
+	pad            int
+	minBaseQuality uint8
+	maxFamilies    int
+	families       map[string]bool
+}
+
+// site is a variant or position to display.
+type site struct {
+	chr string
+	pos int // 1-based
+	ref string
+	alt string // empty if only a position was given
+}
+
+// cell is a single displayed character.
+type cell struct {
+	Char  string
+	Class string
+}
+
+// row is a single line of a pileup.
+type row struct {
+	Label string
+	Cells []cell
+	Note  string
+}
+
+// familyView is the pileup of all reads from one family.
+type familyView struct {
+	Id      string
+	Summary string
+	Rows    []row
+}
+
+// siteView is the display of all families at one site.
+type siteView struct {
+	Title    string
+	Marker   row
+	Ref      row
+	Families []familyView
+}
+
+func mcsVisualize(input, ref, output string, sites []site, p displayParams) {
+	br, _ := sam.OpenBam(input)
+	defer cleanup(br)
+	idx := bai.Read(input)
+	faSeeker := fai.NewSeeker(ref)
+	defer cleanup(faSeeker)
+
+	var views []siteView
+	var reads []sam.Sam
+	var refSeq []dna.Base
+	var err error
+	var start, end int
+	for _, s := range sites {
+		start = max(s.pos-1-p.pad, 0)
+		end = s.pos + p.pad
+		refSeq, err = faSeeker.SeekByName(s.chr, start, end)
+		exception.PanicOnErr(err)
+		end = start + len(refSeq) // may be truncated at the end of the chromosome
+		reads = sam.SeekBamRegionRecycle(br, idx, s.chr, uint32(start), uint32(end), reads)
+		views = append(views, renderSite(s, reads, refSeq, start, p))
+	}
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	if strings.HasSuffix(output, ".html") {
+		err = htmlTemplate.Execute(out, views)
+		exception.PanicOnErr(err)
+		return
+	}
+	for i := range views {
+		writeText(out, views[i])
+	}
+}
+
+// renderSite builds the pileup of each selected family overlapping a site.
+func renderSite(s site, reads []sam.Sam, refSeq []dna.Base, start int, p displayParams) siteView {
+	byFamily := make(map[string][]sam.Sam)
+	supporting := make(map[string]int)
+	var rf string
+	for i := range reads {
+		if sam.IsUnmapped(reads[i]) || sam.IsNotPrimaryAlign(reads[i]) || sam.IsSupplementaryAlign(reads[i]) {
+			continue
+		}
+		sam.ParseExtra(&reads[i])
+		rf = familyId(&reads[i])
+		if rf == "" || rf == "0" { // RF:Z:0 collects reads not assigned to a family
+			continue
+		}
+		byFamily[rf] = append(byFamily[rf], reads[i])
+		if s.alt != "" && supportsAlt(reads[i], s) {
+			supporting[rf]++
+		}
+	}
+
+	var ids []string
+	for id := range byFamily {
+		if (len(p.families) > 0 && p.families[id]) || (len(p.families) == 0 && (s.alt == "" || supporting[id] > 0)) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { // most support first
+		if supporting[ids[i]] != supporting[ids[j]] {
+			return supporting[ids[i]] > supporting[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+	if len(ids) > p.maxFamilies {
+		log.Printf("WARNING: %d families found at %s:%d. Only the first %d are displayed.\n", len(ids), s.chr, s.pos, p.maxFamilies)
+		ids = ids[:p.maxFamilies]
+	}
+
+	view := siteView{Title: fmt.Sprintf("%s:%d", s.chr, s.pos)}
+	if s.alt != "" {
+		view.Title += fmt.Sprintf(" %s>%s", s.ref, s.alt)
+	}
+	view.Marker = row{Label: "", Cells: make([]cell, len(refSeq))}
+	view.Ref = row{Label: "ref", Cells: make([]cell, len(refSeq))}
+	for i := range refSeq {
+		view.Marker.Cells[i] = cell{Char: " ", Class: "blank"}
+		view.Ref.Cells[i] = cell{Char: dna.BaseToString(dna.ToUpper(refSeq[i])), Class: "ref"}
+	}
+	if idx := s.pos - 1 - start; idx >= 0 && idx < len(refSeq) {
+		view.Marker.Cells[idx] = cell{Char: "v", Class: "marker"}
+	}
+
+	var fam familyView
+	var watson, crick, watsonAlt, crickAlt int
+	for _, id := range ids {
+		fam = familyView{Id: id}
+		watson, crick, watsonAlt, crickAlt = 0, 0, 0, 0
+		sort.SliceStable(byFamily[id], func(i, j int) bool { // watson before crick, then by position
+			a, b := barcode.GetRS(&byFamily[id][i]), barcode.GetRS(&byFamily[id][j])
+			if a != b {
+				return a == 'W'
+			}
+			return byFamily[id][i].Pos < byFamily[id][j].Pos
+		})
+		for _, r := range byFamily[id] {
+			alt := s.alt != "" && supportsAlt(r, s)
+			switch barcode.GetRS(&r) {
+			case 'W':
+				watson++
+				if alt {
+					watsonAlt++
+				}
+			case 'C':
+				crick++
+				if alt {
+					crickAlt++
+				}
+			}
+			fam.Rows = append(fam.Rows, renderRead(r, refSeq, start, p.minBaseQuality))
+		}
+		fam.Summary = fmt.Sprintf("watson reads: %d (%d alt), crick reads: %d (%d alt)", watson, watsonAlt, crick, crickAlt)
+		view.Families = append(view.Families, fam)
+	}
+	return view
+}
+
+// renderRead converts the aligned portion of a read overlapping the window starting at start into display cells.
+func renderRead(r sam.Sam, refSeq []dna.Base, start int, minBaseQuality uint8) row {
+	cells := make([]cell, len(refSeq))
+	for i := range cells {
+		cells[i] = cell{Char: " ", Class: "blank"}
+	}
+	set := func(refPos int, c cell) {
+		if idx := refPos - start; idx >= 0 && idx < len(cells) {
+			cells[idx] = c
+		}
+	}
+
+	forward := sam.IsPosStrand(r)
+	var notes []string
+	refPos := r.GetChromStart()
+	var queryPos, k int
+	var b dna.Base
+	var aligned bool
+	for _, c := range r.Cigar {
+		switch c.Op {
+		case 'S':
+			for k = 0; k < c.RunLength; k++ {
+				if aligned { // trailing clip
+					set(refPos+k, cell{Char: "~", Class: "clip"})
+				} else { // leading clip
+					set(refPos-c.RunLength+k, cell{Char: "~", Class: "clip"})
+				}
+			}
+			queryPos += c.RunLength
+		case 'M', '=', 'X':
+			aligned = true
+			for k = 0; k < c.RunLength; k++ {
+				b = dna.ToUpper(r.Seq[queryPos+k])
+				switch {
+				case r.Qual[queryPos+k]-33 < minBaseQuality:
+					set(refPos+k, cell{Char: strings.ToLower(dna.BaseToString(b)), Class: "masked"})
+				case refPos+k-start >= 0 && refPos+k-start < len(refSeq) && b == dna.ToUpper(refSeq[refPos+k-start]):
+					if forward {
+						set(refPos+k, cell{Char: ".", Class: "match"})
+					} else {
+						set(refPos+k, cell{Char: ",", Class: "match"})
+					}
+				default:
+					set(refPos+k, cell{Char: dna.BaseToString(b), Class: "mismatch"})
+				}
+			}
+			refPos += c.RunLength
+			queryPos += c.RunLength
+		case 'D', 'N':
+			aligned = true
+			for k = 0; k < c.RunLength; k++ {
+				set(refPos+k, cell{Char: "*", Class: "del"})
+			}
+			refPos += c.RunLength
+		case 'I':
+			notes = append(notes, fmt.Sprintf("ins %d:%s", refPos, dna.BasesToString(r.Seq[queryPos:queryPos+c.RunLength])))
+			queryPos += c.RunLength
+		}
+	}
+
+	label := string(barcode.GetRS(&r))
+	if sam.IsForwardRead(r) {
+		label += " R1"
+	} else if sam.IsReverseRead(r) {
+		label += " R2"
+	}
+	if forward {
+		label += "+"
+	} else {
+		label += "-"
+	}
+	notes = append(notes, "MQ="+strconv.Itoa(int(r.MapQ)))
+	return row{Label: label, Cells: cells, Note: strings.Join(notes, " ")}
+}
+
+// supportsAlt returns true if the read carries the alternate allele of the site.
+func supportsAlt(r sam.Sam, s site) bool {
+	if len(s.ref) == 1 && len(s.alt) == 1 {
+		b, ok := baseAtPos(r, s.pos)
+		return ok && b == dna.StringToBase(strings.ToUpper(s.alt))
+	}
+	// indels are anchored on the base before the event
+	refPos := r.GetChromStart()
+	var indelLen int
+	for _, c := range r.Cigar {
+		switch c.Op {
+		case 'M', '=', 'X', 'N':
+			refPos += c.RunLength
+		case 'D':
+			indelLen = len(s.ref) - len(s.alt)
+			if refPos == s.pos && c.RunLength == indelLen {
+				return true
+			}
+			refPos += c.RunLength
+		case 'I':
+			indelLen = len(s.alt) - len(s.ref)
+			if refPos == s.pos && c.RunLength == indelLen {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// baseAtPos returns the base aligned to the 1-based reference position pos.
+func baseAtPos(r sam.Sam, pos int) (dna.Base, bool) {
+	refPos := int(r.Pos)
+	var queryPos int
+	for _, c := range r.Cigar {
+		switch c.Op {
+		case 'M', '=', 'X':
+			if pos < refPos+c.RunLength {
+				if pos < refPos {
+					return dna.N, false
+				}
+				return dna.ToUpper(r.Seq[queryPos+pos-refPos]), true
+			}
+			refPos += c.RunLength
+			queryPos += c.RunLength
+		case 'D', 'N':
+			if pos < refPos+c.RunLength {
+				return dna.N, false
+			}
+			refPos += c.RunLength
+		case 'I', 'S':
+			queryPos += c.RunLength
+		}
+	}
+	return dna.N, false
+}
+
+func writeText(out io.Writer, v siteView) {
+	_, err := fmt.Fprintf(out, "### %s\n", v.Title)
+	exception.PanicOnErr(err)
+	writeTextRow(out, v.Marker)
+	writeTextRow(out, v.Ref)
+	if len(v.Families) == 0 {
+		_, err = fmt.Fprintln(out, "No families found.")
+		exception.PanicOnErr(err)
+	}
+	for _, f := range v.Families {
+		_, err = fmt.Fprintf(out, "# family %s: %s\n", f.Id, f.Summary)
+		exception.PanicOnErr(err)
+		for _, r := range f.Rows {
+			writeTextRow(out, r)
+		}
+	}
+	_, err = fmt.Fprintln(out)
+	exception.PanicOnErr(err)
+}
+
+func writeTextRow(out io.Writer, r row) {
+	var sb strings.Builder
+	for _, c := range r.Cells {
+		sb.WriteString(c.Char)
+	}
+	_, err := fmt.Fprintf(out, "%-6s %s  %s\n", r.Label, sb.String(), r.Note)
+	exception.PanicOnErr(err)
+}
+
+// readSites returns the first maxVariants records of a vcf file.
+func readSites(file string, maxVariants int) []site {
+	var ans []site
+	records, _ := vcf.GoReadToChan(file)
+	for v := range records {
+		if len(ans) >= maxVariants {
+			log.Printf("WARNING: only the first %d variants are displayed. Increase -maxVariants to display more.\n", maxVariants)
+			break
+		}
+		ans = append(ans, site{chr: v.Chr, pos: v.Pos, ref: v.Ref, alt: v.Alt[0]})
+	}
+	for range records { // drain so the reader can close
+	}
+	return ans
+}
+
+// parseRegion converts chr:pos to a site.
+func parseRegion(region string) site {
+	colon := strings.LastIndex(region, ":")
+	if colon == -1 {
+		log.Fatalf("ERROR: could not parse region '%s'. Must be formatted as chr:pos.", region)
+	}
+	pos, err := strconv.Atoi(strings.ReplaceAll(region[colon+1:], ",", ""))
+	if err != nil || pos < 1 {
+		log.Fatalf("ERROR: could not parse region '%s'. Must be formatted as chr:pos.", region)
+	}
+	return site{chr: region[:colon], pos: pos}
+}
+
+// familyId returns the RF tag of a read, or an empty string if it has none.
+func familyId(r *sam.Sam) string {
+	rf := barcode.GetRF(r)
+	if idx := strings.IndexByte(rf, '\t'); idx != -1 {
+		rf = rf[:idx]
+	}
+	return rf
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
+
+var htmlTemplate = template.Must(template.New("pileup").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>mcsVisualize</title>
+<style>
+body { font-family: sans-serif; margin: 20px; }
+pre { font-family: monospace; font-size: 13px; line-height: 1.2; background: #fafafa; padding: 8px; overflow-x: auto; }
+.label { color: #555; display: inline-block; width: 6ch; }
+.note { color: #777; }
+.ref { font-weight: bold; }
+.marker { color: #d62728; font-weight: bold; }
+.match { color: #bbb; }
+.mismatch { color: #fff; background: #d62728; font-weight: bold; }
+.masked { color: #999; background: #eee; }
+.clip { color: #1f77b4; }
+.del { color: #000; }
+</style>
+</head>
+<body>
+{{range .}}<h2>{{.Title}}</h2>
+<pre>{{template "row" .Marker}}{{template "row" .Ref}}{{if not .Families}}No families found.
+{{end}}{{range .Families}}<b>family {{.Id}}</b>: {{.Summary}}
+{{range .Rows}}{{template "row" .}}{{end}}{{end}}</pre>
+{{end}}
+</body>
+</html>
+{{define "row"}}<span class="label">{{.Label}}</span>{{range .Cells}}<span class="{{.Class}}">{{.Char}}</span>{{end}}  <span class="note">{{.Note}}</span>
+{{end}}`))
@@ -0,0 +1,77 @@
+package mcsVisualize
+
+import (
+	"bytes"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"testing"
+)
+
+// testRead is aligned to 2-8 of ACGTACGTAC with a C at 4, a deletion at 5, and an A inserted after 7.
+var testRead = sam.Sam{QName: "r", Flag: 0x40, MapQ: 60, Pos: 3, Cigar: cigar.FromString("2S3M1D2M1I1M2S"),
+	Seq: dna.StringToBases("TTGTCGTAACC"), Qual: "III#IIIIIII", Extra: "RS:Z:W"}
+
+func TestRenderRead(t *testing.T) {
+	r := renderRead(testRead, dna.StringToBases("ACGTACGTAC"), 0, 20)
+	var out bytes.Buffer
+	writeTextRow(&out, r)
+	if expected := "W R1+  ~~.tC*...~  ins 8:A MQ=60\n"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+	r = renderRead(testRead, dna.StringToBases("ACGTA"), 4, 20) // a window within the read
+	out.Reset()
+	writeTextRow(&out, r)
+	if expected := "W R1+  C*...  ins 8:A MQ=60\n"; out.String() != expected {
+		t.Errorf("expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestSupportsAlt(t *testing.T) {
+	tests := []struct {
+		s        site
+		expected bool
+	}{
+		{site{chr: "chr1", pos: 5, ref: "A", alt: "C"}, true},
+		{site{chr: "chr1", pos: 5, ref: "A", alt: "G"}, false},
+		{site{chr: "chr1", pos: 3, ref: "G", alt: "T"}, false},
+		{site{chr: "chr1", pos: 5, ref: "AC", alt: "A"}, true},   // deletion anchored at 5
+		{site{chr: "chr1", pos: 5, ref: "ACG", alt: "A"}, false}, // longer deletion
+		{site{chr: "chr1", pos: 8, ref: "T", alt: "TA"}, true},   // insertion anchored at 8
+		{site{chr: "chr1", pos: 8, ref: "T", alt: "TAA"}, false},
+		{site{chr: "chr1", pos: 7, ref: "G", alt: "GA"}, false},
+	}
+	for _, test := range tests {
+		if actual := supportsAlt(testRead, test.s); actual != test.expected {
+			t.Errorf("expected %t for %s>%s at %d, got %t", test.expected, test.s.ref, test.s.alt, test.s.pos, actual)
+		}
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	tests := []struct {
+		region   string
+		expected site
+	}{
+		{"chr1:1,234", site{chr: "chr1", pos: 1234}},
+		{"HLA-A*01:01:10", site{chr: "HLA-A*01:01", pos: 10}},
+	}
+	for _, test := range tests {
+		if actual := parseRegion(test.region); actual != test.expected {
+			t.Errorf("expected %v for %s, got %v", test.expected, test.region, actual)
+		}
+	}
+}
+
+func TestWriteText(t *testing.T) {
+	v := renderSite(site{chr: "chr1", pos: 5, ref: "A", alt: "C"}, nil, dna.StringToBases("acgtacgtac"), 0, displayParams{maxFamilies: 10})
+	var out bytes.Buffer
+	writeText(&out, v)
+	expected := "### chr1:5 A>C\n" +
+		"           v       \n" +
+		"ref    ACGTACGTAC  \n" +
+		"No families found.\n\n"
+	if out.String() != expected {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, out.String())
+	}
+}
@@ -0,0 +1,62 @@
+package rmTag
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"log"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"rmTag - Remove a tag from read in a bam file.\n" +
+			"Usage:\n" +
+			"rmTag [options] -i input.bam -tag RF > output.bam\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs rmTag with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input bam file.")
+	output := flag.String("o", "stdout", "Output bam file.")
+	tag := flag.String("tag", "", "Tag to remove")
+	dryrun.Parse()
+
+	if *input == "" || *tag == "" {
+		usage()
+		log.Fatal("ERROR: Must input a coordinate sorted bam file.")
+	}
+
+	t := *tag
+
+	reads, header := hts.GoReadToChan(*input)
+	bw := hts.Create(*output, provenance.Sam(header))
+	var start, end, i int
+	for r := range reads {
+		sam.ParseExtra(&r)
+		start = strings.Index(r.Extra, t+":")
+		end = -1
+		for i = start; i < len(r.Extra); i++ {
+			if r.Extra[i] == '\t' {
+				end = i
+				break
+			}
+		}
+
+		if end == -1 {
+			r.Extra = r.Extra[:start-1]
+		} else {
+			r.Extra = r.Extra[:start-1] + r.Extra[end:]
+		}
+
+		bw.Write(r)
+	}
+
+	err := bw.Close()
+	exception.PanicOnErr(err)
+}
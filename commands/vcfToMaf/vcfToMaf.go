@@ -0,0 +1,315 @@
+package vcfToMaf
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/dryrun"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+)
+
+func usage() {
+	fmt.Print(
+		"vcfToMaf - Convert a VCF from mcsCallVariants to MAF format.\n" +
+			"One MAF row is written for each sample and ALT allele present in the sample genotype. t_depth is the read family depth (DP)\n" +
+			"and t_alt_count is the sum of the watson (PS) and crick (MS) alt read counts. All FORMAT fields declared in the header are kept\n" +
+			"as extra columns named by their ID, along with FILTER, the strandedness (DS, SS, US), and the strand of single-stranded variants.\n" +
+			"Hugo_Symbol and Variant_Classification are filled from the GENE and REGION fields added by mcsAnnotate when present.\n" +
+			"Usage:\n" +
+			"vcfToMaf [options] -i calls.vcf > calls.maf\n\n")
+	flag.PrintDefaults()
+}
+
+// Main runs vcfToMaf with the options in os.Args.
+func Main() {
+	input := flag.String("i", "", "Input VCF file.")
+	output := flag.String("o", "stdout", "Output MAF file.")
+	build := flag.String("build", "GRCh38", "Reference genome build reported in the NCBI_Build column.")
+	center := flag.String("center", ".", "Sequencing center reported in the Center column.")
+	passOnly := flag.Bool("passOnly", false, "Only convert variants with a FILTER of PASS or '.'.")
+	dryrun.Parse()
+
+	if *input == "" {
+		usage()
+		log.Fatal("ERROR: must specify input vcf (-i).")
+	}
+
+	vcfToMaf(*input, *output, *build, *center, *passOnly)
+}
+
+// mafColumns are the standard MAF columns written before the extra columns.
+var mafColumns = []string{"Hugo_Symbol", "Entrez_Gene_Id", "Center", "NCBI_Build", "Chromosome", "Start_Position", "End_Position",
+	"Strand", "Variant_Classification", "Variant_Type", "Reference_Allele", "Tumor_Seq_Allele1", "Tumor_Seq_Allele2", "dbSNP_RS",
+	"Tumor_Sample_Barcode", "Matched_Norm_Sample_Barcode", "t_depth", "t_ref_count", "t_alt_count"}
+
+func vcfToMaf(input, output, build, center string, passOnly bool) {
+	records, header := vcf.GoReadToChan(input)
+	samples := sampleNames(header)
+	formatIds := formatFields(header)
+
+	out := fileio.EasyCreate(output)
+	defer cleanup(out)
+	_, err := fmt.Fprintf(out, "#version 2.4\n%s\tFILTER\tStrandedness\tMutation_Strand",
+		strings.Join(mafColumns, "\t"))
+	exception.PanicOnErr(err)
+	for _, id := range formatIds {
+		_, err = fmt.Fprintf(out, "\t%s", id)
+		exception.PanicOnErr(err)
+	}
+	_, err = fmt.Fprintln(out)
+	exception.PanicOnErr(err)
+
+	var rows int
+	var alt int16
+	for v := range records {
+		if passOnly && v.Filter != "PASS" && v.Filter != "." {
+			continue
+		}
+		if len(v.Samples) == 0 { // sites only vcf
+			for alt = 1; int(alt) <= len(v.Alt); alt++ {
+				writeRow(out, v, -1, int(alt)-1, ".", formatIds, build, center)
+				rows++
+			}
+		}
+		for i := range v.Samples {
+			for _, alt = range altAlleles(v.Samples[i]) {
+				if int(alt) > len(v.Alt) {
+					exit.Fatalf(exit.MalformedInput, "genotype refers to ALT allele %d but only %d ALT alleles are present at %s:%d.", alt, len(v.Alt), v.Chr, v.Pos)
+				}
+				writeRow(out, v, i, int(alt)-1, sampleName(samples, i), formatIds, build, center)
+				rows++
+			}
+		}
+	}
+	log.Printf("Wrote %d MAF rows.\n", rows)
+}
+
+// writeRow writes the MAF row for an ALT allele in a sample.
+func writeRow(out io.Writer, v vcf.Vcf, sampleIdx, altIdx int, sample string, formatIds []string, build, center string) {
+	ref, alt, start, end, varType := mafAllele(v.Pos, v.Ref, v.Alt[altIdx])
+	gene := infoValue(v.Info, "GENE")
+	if gene == "" {
+		gene = "Unknown"
+	}
+	gene = strings.Split(gene, ",")[0]
+
+	depth := formatValue(v, sampleIdx, "DP")
+	altCount := -1
+	ps, psErr := strconv.Atoi(formatValue(v, sampleIdx, "PS"))
+	ms, msErr := strconv.Atoi(formatValue(v, sampleIdx, "MS"))
+	if psErr == nil && msErr == nil {
+		altCount = ps + ms
+	}
+	var refCount, altCountStr string = ".", "."
+	if altCount >= 0 {
+		altCountStr = strconv.Itoa(altCount)
+		if d, err := strconv.Atoi(depth); err == nil {
+			refCount = strconv.Itoa(d - altCount)
+		}
+	}
+	if depth == "" {
+		depth = "."
+	}
+
+	id := v.Id
+	if id == "" {
+		id = "."
+	}
+	filter := v.Filter
+	if filter == "" {
+		filter = "."
+	}
+	mutationStrand := infoValue(v.Info, "Strand")
+	if mutationStrand == "" {
+		mutationStrand = "."
+	}
+
+	fields := []string{gene, "0", center, build, v.Chr, strconv.Itoa(start), strconv.Itoa(end),
+		"+", classification(infoValue(v.Info, "REGION"), varType, alleleLen(ref), alleleLen(alt)), varType, ref, ref, alt, id,
+		sample, ".", depth, refCount, altCountStr, filter, strandedness(v.Info), mutationStrand}
+	for _, f := range formatIds {
+		val := formatValue(v, sampleIdx, f)
+		if val == "" {
+			val = "."
+		}
+		fields = append(fields, val)
+	}
+	_, err := fmt.Fprintln(out, strings.Join(fields, "\t"))
+	exception.PanicOnErr(err)
+}
+
+// mafAllele converts a VCF allele to MAF coordinates, removing the shared leading bases and using '-' for
+// the empty allele of insertions and deletions. Start and end are 1-based and inclusive.
+func mafAllele(pos int, ref, alt string) (mafRef, mafAlt string, start, end int, varType string) {
+	var prefix int
+	for prefix < len(ref) && prefix < len(alt) && ref[prefix] == alt[prefix] && (len(ref) != len(alt) || prefix < len(ref)-1) {
+		prefix++
+	}
+	mafRef, mafAlt = strings.ToUpper(ref[prefix:]), strings.ToUpper(alt[prefix:])
+	start = pos + prefix
+	switch {
+	case mafRef == "":
+		mafRef = "-"
+		varType = "INS"
+		start-- // insertions are between the two positions
+		end = start + 1
+		return
+	case mafAlt == "":
+		mafAlt = "-"
+		varType = "DEL"
+	case len(mafRef) == 1 && len(mafAlt) == 1:
+		varType = "SNP"
+	case len(mafRef) == 2 && len(mafAlt) == 2:
+		varType = "DNP"
+	case len(mafRef) == 3 && len(mafAlt) == 3:
+		varType = "TNP"
+	case len(mafRef) == len(mafAlt):
+		varType = "ONP"
+	case len(mafRef) > len(mafAlt):
+		varType = "DEL"
+	default:
+		varType = "INS"
+	}
+	end = start + len(mafRef) - 1
+	return
+}
+
+// classification returns the MAF Variant_Classification from the REGION annotation of mcsAnnotate.
+// Coding SNVs require codon level annotation and are reported as Unknown.
+func classification(region, varType string, refLen, altLen int) string {
+	switch region {
+	case "intergenic":
+		return "IGR"
+	case "intron":
+		return "Intron"
+	case "exon":
+		return "RNA"
+	case "CDS":
+		if varType != "INS" && varType != "DEL" {
+			return "Unknown"
+		}
+		frameShift := (refLen-altLen)%3 != 0
+		switch {
+		case varType == "INS" && frameShift:
+			return "Frame_Shift_Ins"
+		case varType == "INS":
+			return "In_Frame_Ins"
+		case frameShift:
+			return "Frame_Shift_Del"
+		default:
+			return "In_Frame_Del"
+		}
+	}
+	return "Unknown"
+}
+
+// alleleLen returns the length of a MAF allele, which is 0 for '-'.
+func alleleLen(allele string) int {
+	if allele == "-" {
+		return 0
+	}
+	return len(allele)
+}
+
+// strandedness returns the strandedness flag set by mcsCallVariants.
+func strandedness(info string) string {
+	for _, field := range strings.Split(info, ";") {
+		switch field {
+		case "DS", "SS", "US":
+			return field
+		}
+	}
+	return "."
+}
+
+// altAlleles returns each distinct ALT allele in the genotype of a sample.
+func altAlleles(s vcf.Sample) []int16 {
+	var ans []int16
+	for _, a := range s.Alleles {
+		if a <= 0 {
+			continue
+		}
+		found := false
+		for i := range ans {
+			if ans[i] == a {
+				found = true
+			}
+		}
+		if !found {
+			ans = append(ans, a)
+		}
+	}
+	return ans
+}
+
+// formatValue returns the value of a FORMAT field for a sample, or an empty string if not present.
+// A negative sampleIdx is used for vcf files without samples.
+func formatValue(v vcf.Vcf, sampleIdx int, id string) string {
+	if sampleIdx < 0 {
+		return ""
+	}
+	for i := range v.Format {
+		if v.Format[i] == id && i < len(v.Samples[sampleIdx].FormatData) {
+			return v.Samples[sampleIdx].FormatData[i]
+		}
+	}
+	return ""
+}
+
+// infoValue returns the value of an INFO field, or an empty string if not present.
+func infoValue(info, key string) string {
+	for _, field := range strings.Split(info, ";") {
+		if strings.HasPrefix(field, key+"=") {
+			return field[len(key)+1:]
+		}
+	}
+	return ""
+}
+
+// formatFields returns the ID of each FORMAT field declared in the header, except GT.
+func formatFields(header vcf.Header) []string {
+	var ans []string
+	var id string
+	for _, line := range header.Text {
+		if !strings.HasPrefix(line, "##FORMAT=<ID=") {
+			continue
+		}
+		id = strings.TrimPrefix(line, "##FORMAT=<ID=")
+		id = id[:strings.IndexAny(id, ",>")]
+		if id != "GT" {
+			ans = append(ans, id)
+		}
+	}
+	return ans
+}
+
+// sampleNames returns the sample names in the #CHROM line of the header.
+func sampleNames(header vcf.Header) []string {
+	for _, line := range header.Text {
+		if strings.HasPrefix(line, "#CHROM") {
+			words := strings.Split(line, "\t")
+			if len(words) > 9 {
+				return words[9:]
+			}
+		}
+	}
+	return nil
+}
+
+func sampleName(samples []string, i int) string {
+	if i < len(samples) {
+		return samples[i]
+	}
+	return fmt.Sprintf("sample%d", i+1)
+}
+
+func cleanup(f io.Closer) {
+	err := f.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,122 @@
+package vcfToMaf
+
+import (
+	"github.com/vertgenlab/gonomics/vcf"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMafAllele(t *testing.T) {
+	tests := []struct {
+		ref, alt                   string
+		mafRef, mafAlt             string
+		expectedStart, expectedEnd int
+		varType                    string
+	}{
+		{"A", "G", "A", "G", 100, 100, "SNP"},
+		{"AC", "GT", "AC", "GT", 100, 101, "DNP"},
+		{"ACG", "ACT", "G", "T", 102, 102, "SNP"}, // shared leading bases are removed
+		{"ACG", "TGA", "ACG", "TGA", 100, 102, "TNP"},
+		{"acgt", "tgca", "ACGT", "TGCA", 100, 103, "ONP"},
+		{"A", "AT", "-", "T", 100, 101, "INS"}, // between 100 and 101
+		{"ATG", "A", "TG", "-", 101, 102, "DEL"},
+		{"ATG", "AC", "TG", "C", 101, 102, "DEL"},
+		{"AT", "ACGA", "T", "CGA", 101, 101, "INS"},
+	}
+	for _, test := range tests {
+		mafRef, mafAlt, start, end, varType := mafAllele(100, test.ref, test.alt)
+		if mafRef != test.mafRef || mafAlt != test.mafAlt || start != test.expectedStart || end != test.expectedEnd || varType != test.varType {
+			t.Errorf("expected %s>%s at %d-%d %s for %s>%s, got %s>%s at %d-%d %s", test.mafRef, test.mafAlt, test.expectedStart, test.expectedEnd, test.varType,
+				test.ref, test.alt, mafRef, mafAlt, start, end, varType)
+		}
+	}
+}
+
+func TestClassification(t *testing.T) {
+	tests := []struct {
+		region, varType string
+		refLen, altLen  int
+		expected        string
+	}{
+		{"CDS", "DEL", 2, 0, "Frame_Shift_Del"},
+		{"CDS", "DEL", 3, 0, "In_Frame_Del"},
+		{"CDS", "INS", 0, 1, "Frame_Shift_Ins"},
+		{"CDS", "INS", 0, 3, "In_Frame_Ins"},
+		{"CDS", "SNP", 1, 1, "Unknown"},
+		{"intron", "SNP", 1, 1, "Intron"},
+		{"intergenic", "DEL", 1, 0, "IGR"},
+		{"exon", "SNP", 1, 1, "RNA"},
+		{"", "SNP", 1, 1, "Unknown"},
+	}
+	for _, test := range tests {
+		if actual := classification(test.region, test.varType, test.refLen, test.altLen); actual != test.expected {
+			t.Errorf("expected %s for a %s in %q, got %s", test.expected, test.varType, test.region, actual)
+		}
+	}
+}
+
+func TestAltAlleles(t *testing.T) {
+	tests := []struct {
+		alleles  []int16
+		expected []int16
+	}{
+		{[]int16{0, 1}, []int16{1}},
+		{[]int16{1, 1}, []int16{1}},
+		{[]int16{2, 1}, []int16{2, 1}},
+		{[]int16{0, 0}, nil},
+		{[]int16{-1, -1}, nil},
+	}
+	for _, test := range tests {
+		if actual := altAlleles(vcf.Sample{Alleles: test.alleles}); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("expected %v for %v, got %v", test.expected, test.alleles, actual)
+		}
+	}
+}
+
+func TestVcfToMaf(t *testing.T) {
+	dir := t.TempDir()
+	input := filepath.Join(dir, "calls.vcf")
+	data := "##fileformat=VCFv4.2\n" +
+		"##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">\n" +
+		"##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Depth\">\n" +
+		"##FORMAT=<ID=PS,Number=1,Type=Integer,Description=\"Plus strand alt reads\">\n" +
+		"##FORMAT=<ID=MS,Number=1,Type=Integer,Description=\"Minus strand alt reads\">\n" +
+		"#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\ts1\ts2\n" +
+		"chr1\t100\t.\tA\tG\t50\tPASS\tDS;GENE=TP53,WRAP53;REGION=CDS;Strand=+\tGT:DP:PS:MS\t0/1:20:3:2\t1/1:10:.:.\n" +
+		"chr1\t200\t.\tATG\tA\t50\tmin_af\tSS\tGT:DP:PS:MS\t0/1:30:1:1\t0/0:30:0:0\n"
+	if err := os.WriteFile(input, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		passOnly bool
+		expected []string
+	}{
+		{true, []string{
+			"TP53\t0\tcenter\tGRCh38\tchr1\t100\t100\t+\tUnknown\tSNP\tA\tA\tG\t.\ts1\t.\t20\t15\t5\tPASS\tDS\t+\t20\t3\t2",
+			"TP53\t0\tcenter\tGRCh38\tchr1\t100\t100\t+\tUnknown\tSNP\tA\tA\tG\t.\ts2\t.\t10\t.\t.\tPASS\tDS\t+\t10\t.\t.",
+		}},
+		{false, []string{
+			"TP53\t0\tcenter\tGRCh38\tchr1\t100\t100\t+\tUnknown\tSNP\tA\tA\tG\t.\ts1\t.\t20\t15\t5\tPASS\tDS\t+\t20\t3\t2",
+			"TP53\t0\tcenter\tGRCh38\tchr1\t100\t100\t+\tUnknown\tSNP\tA\tA\tG\t.\ts2\t.\t10\t.\t.\tPASS\tDS\t+\t10\t.\t.",
+			"Unknown\t0\tcenter\tGRCh38\tchr1\t201\t202\t+\tUnknown\tDEL\tTG\tTG\t-\t.\ts1\t.\t30\t28\t2\tmin_af\tSS\t.\t30\t1\t1",
+		}},
+	}
+	for _, test := range tests {
+		output := filepath.Join(dir, "out.maf")
+		vcfToMaf(input, output, "GRCh38", "center", test.passOnly)
+		actual, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSuffix(string(actual), "\n"), "\n")
+		if len(lines) < 2 || lines[0] != "#version 2.4" || !strings.HasSuffix(lines[1], "\tFILTER\tStrandedness\tMutation_Strand\tDP\tPS\tMS") {
+			t.Fatalf("unexpected MAF header:\n%s", actual)
+		}
+		if !reflect.DeepEqual(lines[2:], test.expected) {
+			t.Errorf("expected with passOnly %t:\n%s\ngot:\n%s", test.passOnly, strings.Join(test.expected, "\n"), strings.Join(lines[2:], "\n"))
+		}
+	}
+}
@@ -0,0 +1,241 @@
+// Package config reads the options of a command from a file given with -config, so
+// that a lab can keep its parameter sets in files instead of in scripts. The file is
+// YAML, or TOML if its name ends in .toml, holding one option per key without the
+// leading dash:
+//
+//	# lab defaults for mcsCallVariants
+//	minAF: 0.8
+//	s: 3
+//	ss: true
+//	e:
+//	  - blacklist.bed
+//	  - segdups.bed
+//	R: [chr1, chr2]
+//
+// Only the flat key, value, and list forms shown are read. Options given on the
+// command line override those of the file, and a list replaces the whole list of a
+// repeatable option such as -e.
+package config
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Flag is the name of the option.
+const Flag = "config"
+
+var file string
+
+// AddFlag adds the -config option to fs. The options of the file are set by Expand
+// before fs is parsed.
+func AddFlag(fs *flag.FlagSet) {
+	fs.StringVar(&file, Flag, file, "YAML (or .toml) `file` of options, keyed by option name without the dash (e.g. minAF: 0.8). Options on the command line override those of the file.")
+}
+
+// File returns the -config file, or "" if none was given.
+func File() string {
+	return file
+}
+
+// AddVcfHeader returns a copy of h with a ##resolvedConfig line before the #CHROM line,
+// holding the value of every option of fs, whether set on the command line, by the
+// -config file, or by default. Options without a value are left out. Lists, given by
+// flag values that Get a []string, are written as one option per value.
+func AddVcfHeader(h vcf.Header, fs *flag.FlagSet) vcf.Header {
+	var args []string
+	fs.VisitAll(func(f *flag.Flag) {
+		values := []string{f.Value.String()}
+		if g, ok := f.Value.(flag.Getter); ok {
+			if list, ok := g.Get().([]string); ok {
+				values = list
+			}
+		}
+		for _, v := range values {
+			if v != "" {
+				args = append(args, "-"+f.Name+"="+v)
+			}
+		}
+	})
+	line := fmt.Sprintf("##resolvedConfig=%q", provenance.Quote(args))
+	text := make([]string, 0, len(h.Text)+1)
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "#CHROM") {
+			text = append(text, line)
+			line = ""
+		}
+		text = append(text, h.Text[i])
+	}
+	if line != "" {
+		text = append(text, line)
+	}
+	h.Text = text
+	return h
+}
+
+// Option is an option read from a config file.
+type Option struct {
+	Name, Value string
+}
+
+// Expand returns args, a command line with the program name first, with the options of
+// the file named by a -config option in args inserted after the program name, so that
+// options later on the command line override them. Options named in args are left out.
+// The file is opened with open, so it may be remote. args is returned unchanged if it
+// has no -config option.
+func Expand(args []string, open func(string) (io.ReadCloser, error)) ([]string, error) {
+	var filename string
+	given := make(map[string]bool)
+	for i := 1; i < len(args); i++ {
+		if args[i] == "--" {
+			break
+		}
+		if !strings.HasPrefix(args[i], "-") {
+			continue
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(args[i], "-"), "=")
+		given[name] = true
+		if name != Flag {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			i++
+			value = args[i]
+		}
+		filename = value
+	}
+	if filename == "" {
+		return args, nil
+	}
+
+	r, err := open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("could not open -%s %s: %w", Flag, filename, err)
+	}
+	defer r.Close()
+	options, err := Parse(r, strings.HasSuffix(strings.ToLower(filename), ".toml"))
+	if err != nil {
+		return nil, fmt.Errorf("-%s %s: %w", Flag, filename, err)
+	}
+	expanded := []string{args[0]}
+	for _, o := range options {
+		if o.Name == Flag {
+			return nil, fmt.Errorf("-%s %s may not set %s", Flag, filename, Flag)
+		}
+		if !given[o.Name] {
+			expanded = append(expanded, "-"+o.Name+"="+o.Value)
+		}
+	}
+	return append(expanded, args[1:]...), nil
+}
+
+// Parse reads the options of a YAML file, or of a TOML file if toml is true. A list
+// gives an Option for each of its values.
+func Parse(r io.Reader, toml bool) ([]Option, error) {
+	sep := ":"
+	if toml {
+		sep = "="
+	}
+	var options []Option
+	var list string // key of the YAML block list being read
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" || line == "---" {
+			continue
+		}
+		if item, found := strings.CutPrefix(line, "- "); found && !toml {
+			if list == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", n)
+			}
+			value, err := unquote(strings.TrimSpace(item))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", n, err)
+			}
+			options = append(options, Option{list, value})
+			continue
+		}
+		if toml && strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported", n)
+		}
+		key, value, found := strings.Cut(line, sep)
+		key, value = strings.Trim(strings.TrimSpace(key), `"'`), strings.TrimSpace(value)
+		key = strings.TrimLeft(key, "-")
+		if !found || key == "" {
+			return nil, fmt.Errorf("line %d: expected key%s value, got '%s'", n, sep, line)
+		}
+		list = ""
+		if value == "" && !toml {
+			list = key // the values follow as - items
+			continue
+		}
+		values, err := parseValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", n, err)
+		}
+		for _, v := range values {
+			options = append(options, Option{key, v})
+		}
+	}
+	return options, scanner.Err()
+}
+
+// parseValue returns the values of an inline list ([a, b]), or the single value.
+func parseValue(value string) ([]string, error) {
+	inner, found := strings.CutPrefix(value, "[")
+	if !found {
+		v, err := unquote(value)
+		return []string{v}, err
+	}
+	inner, found = strings.CutSuffix(inner, "]")
+	if !found {
+		return nil, fmt.Errorf("list '%s' is not closed on its line", value)
+	}
+	var values []string
+	for _, item := range strings.Split(inner, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		v, err := unquote(item)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+// unquote removes the double or single quotes around s, if any.
+func unquote(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		return strconv.Unquote(s)
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
+
+// stripComment removes a # comment, outside of quotes, from line.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' ' || line[i-1] == '\t'):
+			return line[:i]
+		}
+	}
+	return line
+}
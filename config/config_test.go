@@ -0,0 +1,68 @@
+package config
+
+import (
+	"flag"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	yaml := `# defaults
+minAF: 0.8   # of duplex reads
+ss: true
+o: "calls #1.vcf"
+e:
+  - blacklist.bed
+  - 'segdups.bed'
+R: [chr1, "chr2"]
+`
+	toml := `minAF = 0.8
+o = 'calls.vcf'
+R = ["chr1", "chr2"]
+`
+	want := []Option{{"minAF", "0.8"}, {"ss", "true"}, {"o", "calls #1.vcf"}, {"e", "blacklist.bed"}, {"e", "segdups.bed"}, {"R", "chr1"}, {"R", "chr2"}}
+	got, err := Parse(strings.NewReader(yaml), false)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong YAML options: %v %v", got, err)
+	}
+	want = []Option{{"minAF", "0.8"}, {"o", "calls.vcf"}, {"R", "chr1"}, {"R", "chr2"}}
+	got, err = Parse(strings.NewReader(toml), true)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong TOML options: %v %v", got, err)
+	}
+	if _, err = Parse(strings.NewReader("[mcsCallVariants]\nminAF = 0.8\n"), true); err == nil {
+		t.Error("expected an error for a TOML table")
+	}
+	if _, err = Parse(strings.NewReader("- a.bed\n"), false); err == nil {
+		t.Error("expected an error for a list item without a key")
+	}
+}
+
+func TestExpand(t *testing.T) {
+	open := func(string) (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader("minAF: 0.8\ns: 3\ne: [a.bed, b.bed]\n")), nil
+	}
+	got, err := Expand([]string{"mcsCallVariants", "-config", "lab.yaml", "-s=2", "-i", "x.bam"}, open)
+	want := []string{"mcsCallVariants", "-minAF=0.8", "-e=a.bed", "-e=b.bed", "-config", "lab.yaml", "-s=2", "-i", "x.bam"}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("wrong expanded arguments: %v %v", got, err)
+	}
+	args := []string{"mcsCallVariants", "-i", "x.bam"}
+	if got, _ = Expand(args, nil); !reflect.DeepEqual(got, args) {
+		t.Errorf("expected arguments without -config unchanged, got %v", got)
+	}
+}
+
+func TestAddVcfHeader(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("o", "stdout", "")
+	fs.String("r", "", "")
+	fs.Bool("ss", false, "")
+	h := AddVcfHeader(vcf.Header{Text: []string{"##fileformat=VCFv4.2", "#CHROM\tPOS"}}, fs)
+	if len(h.Text) != 3 || h.Text[1] != `##resolvedConfig="-o=stdout -ss=false"` {
+		t.Errorf("wrong header: %v", h.Text)
+	}
+}
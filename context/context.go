@@ -1,14 +1,14 @@
 package context
 
 import (
+	"github.com/dasnellings/duplexTools/fai"
 	"github.com/dasnellings/duplexTools/strand"
 	"github.com/vertgenlab/gonomics/dna"
-	"github.com/vertgenlab/gonomics/fasta"
 	"github.com/vertgenlab/gonomics/vcf"
 	"log"
 )
 
-func GetContextMap(vcfChan <-chan vcf.Vcf, ref *fasta.Seeker, pad int, mergeComplements bool, considerStrand bool) map[string]map[string]int {
+func GetContextMap(vcfChan <-chan vcf.Vcf, ref *fai.Seeker, pad int, mergeComplements bool, considerStrand bool) map[string]map[string]int {
 	ans := initMap(pad)
 	for v := range vcfChan {
 		vcfContext(v, ans, ref, pad, considerStrand)
@@ -21,7 +21,7 @@ func GetContextMap(vcfChan <-chan vcf.Vcf, ref *fasta.Seeker, pad int, mergeComp
 	return ans
 }
 
-func vcfContext(v vcf.Vcf, m map[string]map[string]int, ref *fasta.Seeker, pad int, considerStrand bool) {
+func vcfContext(v vcf.Vcf, m map[string]map[string]int, ref *fai.Seeker, pad int, considerStrand bool) {
 	var topKey, botKey string
 	var keyFound bool
 	var seq []dna.Base
@@ -53,7 +53,7 @@ func vcfContext(v vcf.Vcf, m map[string]map[string]int, ref *fasta.Seeker, pad i
 	}
 
 	if pad > 0 {
-		seq, err = fasta.SeekByName(ref, v.Chr, (v.Pos-1)-pad, (v.Pos-1)+pad+1)
+		seq, err = ref.SeekByName(v.Chr, (v.Pos-1)-pad, (v.Pos-1)+pad+1)
 		dna.AllToUpper(seq)
 		if needsComplement {
 			dna.ReverseComplement(seq)
@@ -0,0 +1,144 @@
+// Package cpus counts the CPUs a process may use, taking the cgroup CPU quota set by
+// container runtimes and batch schedulers (Kubernetes, Docker, SLURM) into account.
+// runtime.NumCPU only reflects CPU affinity, so a job limited to 4 CPUs on a 64 core
+// node would otherwise start 64 threads and be throttled.
+package cpus
+
+import (
+	"bufio"
+	"math"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Available returns the number of CPUs this process can use: the smaller of the CPUs
+// it may be scheduled on and its cgroup CPU quota, rounded up. It is at least 1.
+func Available() int {
+	return available("/", runtime.NumCPU())
+}
+
+// Threads returns n, or Available if n is 0, for commands where -threads 0 means auto.
+func Threads(n int) int {
+	if n == 0 {
+		return Available()
+	}
+	return n
+}
+
+// LimitMaxProcs lowers GOMAXPROCS to Available unless GOMAXPROCS is set in the
+// environment, so the Go runtime does not run more threads than the quota allows.
+func LimitMaxProcs() {
+	if os.Getenv("GOMAXPROCS") != "" {
+		return
+	}
+	if n := Available(); n < runtime.GOMAXPROCS(0) {
+		runtime.GOMAXPROCS(n)
+	}
+}
+
+func available(root string, numCPU int) int {
+	n := numCPU
+	if q := quota(root); q > 0 && q < n {
+		n = q
+	}
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// quota returns the smallest CPU limit of the cgroup of this process and its parents
+// in whole CPUs, or 0 if there is no limit. Both cgroup v2 (cpu.max) and v1
+// (cpu.cfs_quota_us) are read. Inside a container /proc/self/cgroup may show a path
+// on the host that is not under the container's mount, so every parent is tried.
+func quota(root string) int {
+	var ans int
+	for controllers, group := range cgroups(filepath.Join(root, "proc/self/cgroup")) {
+		var mounts []string
+		switch {
+		case controllers == "": // v2
+			mounts = []string{"sys/fs/cgroup", "sys/fs/cgroup/unified"}
+		case hasController(controllers, "cpu"):
+			mounts = []string{"sys/fs/cgroup/cpu", "sys/fs/cgroup/" + controllers}
+		default:
+			continue
+		}
+		for _, mount := range mounts {
+			for dir := group; ; dir = path.Dir(dir) {
+				q := readQuota(filepath.Join(root, mount, dir))
+				if q > 0 && (ans == 0 || q < ans) {
+					ans = q
+				}
+				if dir == "/" || dir == "." {
+					break
+				}
+			}
+		}
+	}
+	return ans
+}
+
+// cgroups maps the controllers of each line of /proc/self/cgroup (e.g. "cpu,cpuacct",
+// or "" for cgroup v2) to the cgroup path.
+func cgroups(filename string) map[string]string {
+	ans := make(map[string]string)
+	file, err := os.Open(filename)
+	if err != nil {
+		return ans
+	}
+	defer file.Close()
+	s := bufio.NewScanner(file)
+	for s.Scan() {
+		fields := strings.SplitN(s.Text(), ":", 3)
+		if len(fields) == 3 {
+			ans[fields[1]] = fields[2]
+		}
+	}
+	return ans
+}
+
+func hasController(controllers, name string) bool {
+	for _, c := range strings.Split(controllers, ",") {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readQuota returns the CPU limit set in dir, or 0 if there is none.
+func readQuota(dir string) int {
+	if b, err := os.ReadFile(filepath.Join(dir, "cpu.max")); err == nil { // v2: "$MAX $PERIOD" or "max $PERIOD"
+		fields := strings.Fields(string(b))
+		if len(fields) == 2 {
+			return quotaCpus(fields[0], fields[1])
+		}
+		return 0
+	}
+	q, err := os.ReadFile(filepath.Join(dir, "cpu.cfs_quota_us")) // v1: -1 for no limit
+	if err != nil {
+		return 0
+	}
+	p, err := os.ReadFile(filepath.Join(dir, "cpu.cfs_period_us"))
+	if err != nil {
+		return 0
+	}
+	return quotaCpus(strings.TrimSpace(string(q)), strings.TrimSpace(string(p)))
+}
+
+// quotaCpus returns quota/period rounded up, or 0 if either is not a positive number.
+func quotaCpus(quota, period string) int {
+	q, err := strconv.ParseFloat(quota, 64)
+	if err != nil || q <= 0 {
+		return 0
+	}
+	p, err := strconv.ParseFloat(period, 64)
+	if err != nil || p <= 0 {
+		return 0
+	}
+	return int(math.Ceil(q / p))
+}
@@ -0,0 +1,63 @@
+package cpus
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeFiles(t *testing.T, root string, files map[string]string) {
+	for name, data := range files {
+		name = filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAvailable(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected int
+	}{
+		{"no cgroups", nil, 64},
+		{"v2 unlimited", map[string]string{
+			"proc/self/cgroup":      "0::/\n",
+			"sys/fs/cgroup/cpu.max": "max 100000\n",
+		}, 64},
+		{"v2 quota", map[string]string{
+			"proc/self/cgroup":                    "0::/kubepods/pod1/ctr\n",
+			"sys/fs/cgroup/kubepods/cpu.max":      "max 100000\n",
+			"sys/fs/cgroup/kubepods/pod1/cpu.max": "250000 100000\n",
+		}, 3},
+		{"v2 host path in container", map[string]string{
+			"proc/self/cgroup":      "0::/system.slice/docker-abc.scope\n",
+			"sys/fs/cgroup/cpu.max": "400000 100000\n",
+		}, 4},
+		{"v1 quota", map[string]string{
+			"proc/self/cgroup": "4:memory:/slurm/job1\n2:cpu,cpuacct:/slurm/job1\n",
+			"sys/fs/cgroup/cpu,cpuacct/slurm/job1/cpu.cfs_quota_us":  "800000\n",
+			"sys/fs/cgroup/cpu,cpuacct/slurm/job1/cpu.cfs_period_us": "100000\n",
+		}, 8},
+		{"v1 unlimited", map[string]string{
+			"proc/self/cgroup":                    "1:cpu:/\n",
+			"sys/fs/cgroup/cpu/cpu.cfs_quota_us":  "-1\n",
+			"sys/fs/cgroup/cpu/cpu.cfs_period_us": "100000\n",
+		}, 64},
+		{"quota above affinity", map[string]string{
+			"proc/self/cgroup":      "0::/\n",
+			"sys/fs/cgroup/cpu.max": "12800000 100000\n",
+		}, 64},
+	}
+	for _, test := range tests {
+		root := t.TempDir()
+		writeFiles(t, root, test.files)
+		if got := available(root, 64); got != test.expected {
+			t.Errorf("%s: expected %d, got %d", test.name, test.expected, got)
+		}
+	}
+}
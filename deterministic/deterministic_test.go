@@ -0,0 +1,25 @@
+package deterministic
+
+import (
+	"flag"
+	"math/rand"
+	"testing"
+)
+
+func TestApply(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	AddFlag(fs)
+	if err := fs.Parse([]string{"-" + Flag}); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { enabled = false }()
+	if !Enabled() {
+		t.Fatal("-deterministic was given but is not enabled")
+	}
+	Apply()
+	first := rand.Int63()
+	Apply()
+	if again := rand.Int63(); again != first {
+		t.Errorf("random numbers after Apply differ between runs: %d and %d", first, again)
+	}
+}
@@ -0,0 +1,461 @@
+// Package dryrun adds a -dry-run option to every command. A dry run checks the files
+// named on the command line (existence, indexes, headers, matching contigs, and that
+// outputs can be written), prints what the command would read and write with a rough
+// memory estimate, and exits without processing any data. Misconfigured jobs then fail
+// in seconds instead of after waiting in a cluster queue.
+package dryrun
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/cpus"
+	"github.com/dasnellings/duplexTools/deterministic"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/manifest"
+	"github.com/dasnellings/duplexTools/pipe"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/refcache"
+	"github.com/dasnellings/duplexTools/remote"
+	"github.com/dasnellings/duplexTools/tmp"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Flag is the name of the dry run option.
+const Flag = "dry-run"
+
+// rough memory costs used for the estimate
+const (
+	baseMemory      = 64 << 20  // runtime, buffers, and the bam/vcf readers
+	threadMemory    = 32 << 20  // per worker thread
+	bedRecordMemory = 200       // bytes per bed record held in memory
+	vcfRecordMemory = 1 << 10   // bytes per vcf record held in memory
+	maxScanRecords  = 100000000 // stop counting records of very large tables
+)
+
+// osExit is replaced in tests.
+var osExit = os.Exit
+
+// Parse parses the command line like flag.Parse, with the -dry-run, -tmpdir,
+// -deterministic, -manifest, and -refCache options shared by every command. Remote and piped paths are
+// replaced with local files first (see remote.Prepare). If -dry-run is given, Parse checks
+// the inputs and outputs, prints the plan to stdout, and exits: with 0 if no problems
+// were found, or with the exit code of the first problem otherwise.
+func Parse() {
+	dry := flag.Bool(Flag, false, "Check inputs, indexes, headers, and outputs, print what would be read and written, and exit without processing data.")
+	tmp.AddFlag(flag.CommandLine)
+	deterministic.AddFlag(flag.CommandLine)
+	manifest.AddFlag(flag.CommandLine)
+	refcache.AddFlag(flag.CommandLine)
+	remote.Prepare(flag.CommandLine)
+	flag.Parse()
+	deterministic.Apply()
+	if !*dry {
+		return
+	}
+	p := check(flag.CommandLine, os.Args[1:])
+	p.print(os.Stdout)
+	if len(p.errors) > 0 {
+		e := p.errors[0]
+		if e.class == 0 {
+			log.Fatalf("ERROR: %s", e.msg)
+		}
+		exit.Fatalf(e.class, "%s", e.msg)
+	}
+	osExit(0)
+}
+
+// problem is an error found during the dry run. A zero class exits with 1.
+type problem struct {
+	class exit.Class
+	msg   string
+}
+
+// input is a file read by the command.
+type input struct {
+	path    string
+	size    int64
+	details []string
+}
+
+type plan struct {
+	fs       *flag.FlagSet
+	options  []string
+	inputs   []input
+	outputs  []string
+	threads  int
+	memory   int64
+	ref      *fai.Index
+	refFile  string
+	errors   []problem
+	warnings []string
+}
+
+// arg is a flag value or positional argument that may name a file.
+type arg struct {
+	flag, value string
+}
+
+func check(fs *flag.FlagSet, args []string) *plan {
+	p := &plan{fs: fs, threads: 1}
+	fs.VisitAll(func(f *flag.Flag) {
+		if !strings.Contains(strings.ToLower(f.Name), "threads") {
+			return
+		}
+		if n, err := strconv.Atoi(f.Value.String()); err == nil {
+			if n < 0 {
+				p.fail(0, "-%s must be 0 or more, got %d", f.Name, n)
+				return
+			}
+			p.threads = cpus.Threads(n)
+		}
+	})
+	inputArgs, outputArgs := p.classify(args)
+
+	// the reference is checked first so other inputs can be compared to it
+	sort.SliceStable(inputArgs, func(i, j int) bool {
+		return isFasta(inputArgs[i].value) && !isFasta(inputArgs[j].value)
+	})
+	for _, a := range inputArgs {
+		p.checkInput(a)
+	}
+	for _, a := range outputArgs {
+		p.checkOutput(a, inputArgs)
+	}
+	p.memory += baseMemory + int64(p.threads)*threadMemory
+	return p
+}
+
+// classify sorts the flags and positional arguments given on the command line into
+// inputs and outputs, and records the options for the plan.
+func (p *plan) classify(args []string) (inputs, outputs []arg) {
+	var all []arg
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" || !strings.HasPrefix(a, "-") || a == "-" {
+			break
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		f := p.fs.Lookup(name)
+		if f == nil || name == Flag {
+			continue
+		}
+		if !hasValue {
+			if b, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && b.IsBoolFlag() {
+				p.options = append(p.options, "-"+name)
+				continue
+			}
+			if i+1 < len(args) {
+				i++
+				value = args[i]
+			}
+		}
+		p.options = append(p.options, fmt.Sprintf("-%s %s", name, value))
+		all = append(all, arg{flag: name, value: value})
+	}
+	for _, a := range p.fs.Args() {
+		all = append(all, arg{value: a})
+	}
+
+	for _, a := range all {
+		switch {
+		case a.value == "" || a.value == "stdout" || a.value == "stderr" || a.value == "stdin" || a.value == "-":
+		case a.flag == refcache.Flag: // created as needed, and only used for cram
+		case manifest.IsOutput(p.fs.Lookup(a.flag)) || a.flag == tmp.Flag:
+			outputs = append(outputs, a)
+		case looksLikeFile(a.value):
+			inputs = append(inputs, a)
+		}
+	}
+	return inputs, outputs
+}
+
+var fileSuffixes = []string{".bam", ".sam", ".fa", ".fasta", ".fa.gz", ".fasta.gz", ".bed", ".bed.gz", ".vcf", ".vcf.gz",
+	".txt", ".tsv", ".csv", ".gz", ".fq", ".fastq", ".json", ".parquet"}
+
+// looksLikeFile reports whether value is a path rather than a number, tag, or other option.
+func looksLikeFile(value string) bool {
+	if remote.IsRemote(value) {
+		return true
+	}
+	if _, err := os.Stat(value); err == nil {
+		return true
+	}
+	for _, s := range fileSuffixes {
+		if strings.HasSuffix(value, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func isFasta(path string) bool {
+	path = strings.TrimSuffix(path, ".gz")
+	return strings.HasSuffix(path, ".fa") || strings.HasSuffix(path, ".fasta") || strings.HasSuffix(path, ".fna")
+}
+
+func (p *plan) fail(c exit.Class, format string, args ...any) {
+	p.errors = append(p.errors, problem{class: c, msg: fmt.Sprintf(format, args...)})
+}
+
+func (p *plan) warn(format string, args ...any) {
+	p.warnings = append(p.warnings, fmt.Sprintf(format, args...))
+}
+
+func (p *plan) checkInput(a arg) {
+	in := input{path: a.value}
+	defer func() {
+		if r := recover(); r != nil { // gonomics readers panic on malformed files
+			p.fail(exit.MalformedInput, "could not read %s: %v", a.value, r)
+		}
+		p.inputs = append(p.inputs, in)
+	}()
+
+	if remote.IsRemote(a.value) {
+		exists, _, err := remote.Stat(a.value)
+		switch {
+		case err != nil:
+			p.fail(0, "%s", err)
+		case !exists:
+			p.fail(0, "input %s does not exist", a.value)
+		default:
+			in.details = append(in.details, "remote, not downloaded in a dry run")
+		}
+		return
+	}
+
+	info, err := os.Stat(a.value)
+	if err != nil {
+		p.fail(0, "input %s does not exist", a.value)
+		return
+	}
+	in.size = info.Size()
+	if info.IsDir() {
+		in.details = append(in.details, "directory")
+		return
+	}
+	if pipe.Is(a.value) { // reading would consume the data
+		in.details = append(in.details, "pipe, not read in a dry run")
+		return
+	}
+	f, err := os.Open(a.value)
+	if err != nil {
+		p.fail(0, "input %s is not readable: %s", a.value, err)
+		return
+	}
+	f.Close()
+
+	path := strings.TrimSuffix(a.value, ".gz")
+	switch {
+	case strings.HasSuffix(a.value, ".bam"):
+		in.details = p.checkBam(a.value)
+	case isFasta(a.value):
+		in.details = p.checkFasta(a.value, in.size)
+	case strings.HasSuffix(path, ".bed"):
+		in.details = p.checkTable(a.value, false)
+	case strings.HasSuffix(path, ".vcf"):
+		in.details = p.checkTable(a.value, true)
+	}
+}
+
+func (p *plan) checkBam(path string) []string {
+	r, h := sam.OpenBam(path)
+	r.Close()
+	details := []string{fmt.Sprintf("bam, %d references", len(h.Chroms))}
+	if len(h.Metadata.SortOrder) > 0 && h.Metadata.SortOrder[0] == sam.Coordinate {
+		details = append(details, "coordinate sorted")
+	} else {
+		details = append(details, "not coordinate sorted")
+	}
+
+	var index string
+	for _, idx := range []string{path + ".bai", strings.TrimSuffix(path, ".bam") + ".bai"} {
+		if _, err := os.Stat(idx); err == nil {
+			index = idx
+			break
+		}
+	}
+	if index == "" {
+		p.warn("%s has no .bai index, which commands that seek to regions require (samtools index %s)", path, path)
+	} else {
+		details = append(details, "index "+index)
+	}
+
+	if p.ref != nil {
+		var missing []string
+		for _, c := range h.Chroms {
+			if !p.ref.Contains(c.Name) {
+				missing = append(missing, c.Name)
+			}
+		}
+		switch {
+		case len(h.Chroms) > 0 && len(missing) == len(h.Chroms):
+			p.fail(exit.ContigMismatch, "none of the references in %s are in %s, which suggests a different reference build", path, p.refFile)
+		case len(missing) > 0:
+			p.warn("%d references in %s are not in %s (e.g. %s), so reads on them cannot be used", len(missing), path, p.refFile, missing[0])
+		}
+	}
+	return details
+}
+
+func (p *plan) checkFasta(path string, size int64) []string {
+	if _, err := os.Stat(path + ".fai"); err != nil {
+		p.warn("%s has no .fai index, so commands that seek in the reference will fail and others read it into memory (samtools faidx %s)", path, path)
+		p.memory += size
+		return []string{"fasta, not indexed"}
+	}
+	if p.ref != nil {
+		return []string{"fasta"}
+	}
+	idx := fai.ReadIndex(path + ".fai")
+	p.ref, p.refFile = &idx, path
+	return []string{fmt.Sprintf("fasta, %d sequences, index %s.fai", len(idx.Names()), path)}
+}
+
+// checkTable reads every line of a bed or vcf file, checking the coordinates of each
+// record and that its chromosome is in the reference.
+func (p *plan) checkTable(path string, isVcf bool) []string {
+	file := fileio.EasyOpen(path)
+	defer file.Close()
+	var records, lineNum int
+	var sawHeader bool
+	missing := make(map[string]bool)
+	for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) {
+		lineNum++
+		if strings.HasPrefix(line, "#CHROM") {
+			sawHeader = true
+		}
+		if line == "" || line[0] == '#' || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser") {
+			continue
+		}
+		if records++; records > maxScanRecords {
+			break
+		}
+		fields := strings.SplitN(line, "\t", 4)
+		if len(fields) < 3 {
+			p.fail(exit.MalformedInput, "%s line %d has fewer than 3 tab separated columns", path, lineNum)
+			return nil
+		}
+		start, err := strconv.Atoi(fields[1])
+		if err != nil {
+			p.fail(exit.MalformedInput, "%s line %d: position '%s' is not a number", path, lineNum, fields[1])
+			return nil
+		}
+		if !isVcf {
+			end, err := strconv.Atoi(fields[2])
+			if err != nil || end < start {
+				p.fail(exit.MalformedInput, "%s line %d: end '%s' is not a number at least as large as the start", path, lineNum, fields[2])
+				return nil
+			}
+		}
+		if p.ref != nil && !p.ref.Contains(fields[0]) {
+			missing[fields[0]] = true
+		}
+	}
+	if isVcf && !sawHeader {
+		p.fail(exit.MalformedInput, "%s has no #CHROM header line", path)
+	}
+	if len(missing) > 0 {
+		names := make([]string, 0, len(missing))
+		for c := range missing {
+			names = append(names, c)
+		}
+		sort.Strings(names)
+		p.fail(exit.ContigMismatch, "%d chromosomes in %s are not in %s (e.g. %s)", len(names), path, p.refFile, names[0])
+	}
+
+	if isVcf {
+		p.memory += int64(records) * vcfRecordMemory
+		return []string{fmt.Sprintf("vcf, %d records", records)}
+	}
+	p.memory += int64(records) * bedRecordMemory
+	return []string{fmt.Sprintf("bed, %d records", records)}
+}
+
+func (p *plan) checkOutput(a arg, inputs []arg) {
+	for _, in := range inputs {
+		if in.value == a.value {
+			p.fail(0, "output %s is also an input", a.value)
+			return
+		}
+	}
+	p.outputs = append(p.outputs, a.value)
+	if remote.IsRemote(a.value) || pipe.Is(a.value) {
+		return
+	}
+	dir := filepath.Dir(a.value)
+	if a.flag == tmp.Flag || strings.HasSuffix(strings.ToLower(a.flag), "pfx") && strings.HasSuffix(a.value, string(filepath.Separator)) {
+		dir = a.value
+	}
+	f, err := os.CreateTemp(dir, ".duplexTools-dryrun-")
+	if err != nil {
+		if pe, ok := err.(*os.PathError); ok { // hide the name of the test file
+			err = pe.Err
+		}
+		p.fail(0, "cannot write output %s: %s", a.value, err)
+		return
+	}
+	f.Close()
+	os.Remove(f.Name())
+	if _, err = os.Stat(a.value); err == nil {
+		p.warn("output %s exists and will be overwritten", a.value)
+	}
+}
+
+func (p *plan) print(w io.Writer) {
+	fmt.Fprintf(w, "Dry run of %s (duplexTools %s)\n", provenance.Command(), provenance.Version())
+	if len(p.options) > 0 {
+		fmt.Fprintln(w, "Options:")
+		for _, o := range p.options {
+			fmt.Fprintf(w, "  %s\n", o)
+		}
+	}
+	fmt.Fprintln(w, "Inputs:")
+	for _, in := range p.inputs {
+		fmt.Fprintf(w, "  %s\t%s", in.path, humanBytes(in.size))
+		if len(in.details) > 0 {
+			fmt.Fprintf(w, "\t%s", strings.Join(in.details, ", "))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "Outputs:")
+	for _, out := range p.outputs {
+		fmt.Fprintf(w, "  %s\n", out)
+	}
+	fmt.Fprintf(w, "Threads: %d\n", p.threads)
+	fmt.Fprintf(w, "Estimated memory: %s\n", humanBytes(p.memory))
+	for _, msg := range p.warnings {
+		fmt.Fprintf(w, "WARNING: %s\n", msg)
+	}
+	for _, e := range p.errors {
+		if e.class == 0 {
+			fmt.Fprintf(w, "ERROR: %s\n", e.msg)
+		} else {
+			fmt.Fprintf(w, "ERROR [%s]: %s\n", e.class, e.msg)
+		}
+	}
+	if len(p.errors) == 0 {
+		fmt.Fprintln(w, "No problems found.")
+	}
+}
+
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,61 @@
+package dryrun
+
+import (
+	"flag"
+	"github.com/dasnellings/duplexTools/exit"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, data string) string {
+		name = filepath.Join(dir, name)
+		if err := os.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return name
+	}
+	ref := write("ref.fa", ">chr1\nACGT\n")
+	write("ref.fa.fai", "chr1\t4\t6\t4\t5\n")
+	good := write("good.bed", "chr1\t0\t4\tfamily1\n")
+	wrongChrom := write("chrom.bed", "chr1\t0\t4\nchr2\t0\t4\n")
+	malformed := write("bad.bed", "chr1\t4\t0\n")
+	out := filepath.Join(dir, "out.vcf")
+
+	tests := []struct {
+		name     string
+		args     []string
+		expected []exit.Class // 0 for an error without a class
+	}{
+		{"valid", []string{"-r", ref, "-b", good, "-o", out, "-n", "3"}, nil},
+		{"contig mismatch", []string{"-r", ref, "-b", wrongChrom, "-o", out}, []exit.Class{exit.ContigMismatch}},
+		{"malformed", []string{"-r", ref, "-b", malformed}, []exit.Class{exit.MalformedInput}},
+		{"missing input", []string{"-b", filepath.Join(dir, "missing.bed")}, []exit.Class{0}},
+		{"output is input", []string{"-b", good, "-o", good}, []exit.Class{0}},
+		{"output not writable", []string{"-o", filepath.Join(dir, "missing", "out.vcf")}, []exit.Class{0}},
+		{"negative threads", []string{"-threads", "-1"}, []exit.Class{0}},
+	}
+	for _, test := range tests {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		fs.String("r", "", "Reference.")
+		fs.String("b", "", "Families.")
+		fs.String("o", "stdout", "Output file.")
+		fs.Int("n", 1, "Count.")
+		fs.Int("threads", 1, "Threads.")
+		if err := fs.Parse(test.args); err != nil {
+			t.Fatal(err)
+		}
+		p := check(fs, test.args)
+		if len(p.errors) != len(test.expected) {
+			t.Errorf("%s: expected %d errors, got %v", test.name, len(test.expected), p.errors)
+			continue
+		}
+		for i := range p.errors {
+			if p.errors[i].class != test.expected[i] {
+				t.Errorf("%s: expected class %s, got %s", test.name, test.expected[i], p.errors[i].class)
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Package exit gives fatal errors a class with its own exit code and a stable name, so
+// workflow engines can tell a missing index from a malformed bed without parsing the
+// message. Failures without a class still exit with 1 (log.Fatal) or 2 (panics and
+// invalid flags).
+package exit
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/dasnellings/duplexTools/schema"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Class is a kind of failure. Its value is the exit code.
+type Class int
+
+const (
+	MissingIndex   Class = iota + 3 // a .bai, .fai, or .tbi index was not found
+	MissingTag                      // reads or records lack tags written by an earlier step (e.g. RF from annotateReadFamilies)
+	ContigMismatch                  // a chromosome in one input is absent from the reference or bam header
+	MalformedInput                  // a line of a bed, vcf, or table could not be parsed
+	Unsorted                        // an input that must be coordinate sorted is not
+	Interrupted                     // the run was stopped by a signal and may be retried
+)
+
+var names = [...]string{
+	MissingIndex:   "missing_index",
+	MissingTag:     "missing_tag",
+	ContigMismatch: "contig_mismatch",
+	MalformedInput: "malformed_input",
+	Unsorted:       "unsorted",
+	Interrupted:    "interrupted",
+}
+
+// String returns the name of c used in error messages, e.g. missing_index.
+func (c Class) String() string {
+	if c < MissingIndex || int(c) >= len(names) {
+		return fmt.Sprintf("class%d", int(c))
+	}
+	return names[c]
+}
+
+// osExit is replaced in tests.
+var osExit = os.Exit
+
+// Fatalf logs an error of class c and exits with c as the exit code. The message is
+// logged as "ERROR [class]: message", or as a line holding a single JSON object with
+// class, code, command, and message fields (the error document of package schema) when
+// DUPLEXTOOLS_ERROR_FORMAT is json.
+func Fatalf(c Class, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if os.Getenv("DUPLEXTOOLS_ERROR_FORMAT") == "json" {
+		fmt.Fprintln(log.Writer(), message(c, msg, true)) // without the log prefix so the line parses as json
+	} else {
+		log.Print(message(c, msg, false))
+	}
+	osExit(int(c))
+}
+
+func message(c Class, msg string, asJson bool) string {
+	if !asJson {
+		return fmt.Sprintf("ERROR [%s]: %s", c, msg)
+	}
+	b, err := json.Marshal(struct {
+		schema.Header
+		Class   string `json:"class"`
+		Code    int    `json:"code"`
+		Command string `json:"command"`
+		Message string `json:"message"`
+	}{schema.NewHeader("error"), c.String(), int(c), filepath.Base(os.Args[0]), msg})
+	if err != nil {
+		return fmt.Sprintf("ERROR [%s]: %s", c, msg)
+	}
+	return string(b)
+}
@@ -0,0 +1,37 @@
+package exit
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFatalf(t *testing.T) {
+	var code int
+	osExit = func(c int) { code = c }
+	defer func() { osExit = os.Exit }()
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	log.SetFlags(0)
+	defer log.SetOutput(os.Stderr)
+	defer log.SetFlags(log.LstdFlags)
+
+	Fatalf(MissingIndex, "could not find %s", "in.bam.bai")
+	if code != 3 || strings.TrimSpace(buf.String()) != "ERROR [missing_index]: could not find in.bam.bai" {
+		t.Errorf("wrong exit code %d or message %q", code, buf.String())
+	}
+
+	buf.Reset()
+	t.Setenv("DUPLEXTOOLS_ERROR_FORMAT", "json")
+	Fatalf(Interrupted, "stopped")
+	var m map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &m); err != nil {
+		t.Fatalf("message is not json: %q", buf.String())
+	}
+	if m["schema"] != "error" || m["class"] != "interrupted" || m["code"] != float64(Interrupted) || m["message"] != "stopped" {
+		t.Errorf("wrong json message: %v", m)
+	}
+}
@@ -2,9 +2,13 @@ package fai
 
 import (
 	"fmt"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/memo"
+	"github.com/dasnellings/duplexTools/pipe"
 	"github.com/vertgenlab/gonomics/exception"
 	"github.com/vertgenlab/gonomics/fileio"
-	"log"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 )
@@ -25,6 +29,12 @@ func (idx Index) String() string {
 	return answer.String()
 }
 
+// Contains reports whether chr is a sequence in the index.
+func (idx Index) Contains(chr string) bool {
+	_, found := idx.nameMap[chr]
+	return found
+}
+
 func (idx Index) Size(chr string) int {
 	return idx.chroms[idx.nameMap[chr]].len
 }
@@ -44,7 +54,22 @@ func (c chrOffset) String() string {
 }
 
 // ReadIndex reads a fai index file to an Index struct that can be used for random access.
+// It exits with exit.MissingIndex if filename does not exist. The file is parsed once
+// per process unless it changes, and the returned Index is shared by every caller.
 func ReadIndex(filename string) Index {
+	pipe.RequireIndexable(strings.TrimSuffix(filename, ".fai"), "reference")
+	info, err := os.Stat(filename)
+	if err != nil {
+		exit.Fatalf(exit.MissingIndex, "could not find reference index %s. Index the reference with samtools faidx.", filename)
+	}
+
+	return indexes.Get(filename, info, readIndex)
+}
+
+// indexes holds the indexes parsed by ReadIndex.
+var indexes memo.Files[Index]
+
+func readIndex(filename string) Index {
 	file := fileio.EasyOpen(filename)
 	var answer Index
 	var curr chrOffset
@@ -55,7 +80,7 @@ func ReadIndex(filename string) Index {
 	for line, done = fileio.EasyNextRealLine(file); !done; line, done = fileio.EasyNextRealLine(file) {
 		col = strings.Split(line, "\t")
 		if len(col) != 5 {
-			log.Fatalf("ERROR: malformed index file: %s\nerror on line:\n%s\n", filename, line)
+			exit.Fatalf(exit.MalformedInput, "malformed index file: %s\nerror on line:\n%s\n", filename, line)
 		}
 
 		curr.name = col[0]
@@ -81,10 +106,78 @@ func ReadIndex(filename string) Index {
 	return answer
 }
 
+// IndexToVcfHeader returns a ##contig line with the name and length of each sequence in idx.
 func IndexToVcfHeader(idx Index) string {
+	return contigLines(idx, nil)
+}
+
+// VcfContigHeader returns a ##contig line for each sequence of referenceFile, with its
+// length from the .fai index. If the reference has a sequence dictionary (ref.dict or
+// ref.fa.dict, as written by samtools dict or Picard CreateSequenceDictionary), the md5,
+// assembly, and species of each sequence are added, as GATK and hap.py expect.
+func VcfContigHeader(referenceFile string) string {
+	idx := ReadIndex(referenceFile + ".fai")
+	for _, dict := range dictFiles(referenceFile) {
+		if _, err := os.Stat(dict); err == nil {
+			return contigLines(idx, readDict(dict))
+		}
+	}
+	return contigLines(idx, nil)
+}
+
+// dictKeys are the @SQ fields of a sequence dictionary copied to ##contig lines, with
+// their VCF names.
+var dictKeys = []struct{ sam, vcf string }{{"M5", "md5"}, {"AS", "assembly"}, {"SP", "species"}}
+
+func contigLines(idx Index, dict map[string]map[string]string) string {
 	ans := new(strings.Builder)
 	for i := range idx.chroms {
-		ans.WriteString(fmt.Sprintf("##contig=<ID=%s,length=%d>\n", idx.chroms[i].name, idx.chroms[i].len))
+		ans.WriteString(fmt.Sprintf("##contig=<ID=%s,length=%d", idx.chroms[i].name, idx.chroms[i].len))
+		for _, key := range dictKeys {
+			if val := dict[idx.chroms[i].name][key.sam]; val != "" {
+				if strings.ContainsAny(val, " ,<>=\"") {
+					val = strconv.Quote(val)
+				}
+				ans.WriteString(fmt.Sprintf(",%s=%s", key.vcf, val))
+			}
+		}
+		ans.WriteString(">\n")
 	}
 	return ans.String()
 }
+
+// dictFiles returns the paths a sequence dictionary of referenceFile may have.
+func dictFiles(referenceFile string) []string {
+	base := strings.TrimSuffix(referenceFile, ".gz")
+	return []string{strings.TrimSuffix(base, filepath.Ext(base)) + ".dict", referenceFile + ".dict"}
+}
+
+// readDict returns the fields of each @SQ line of a sequence dictionary by sequence name.
+func readDict(filename string) map[string]map[string]string {
+	file := fileio.EasyOpen(filename)
+	ans := make(map[string]map[string]string)
+	for line, done := fileio.EasyNextRealLine(file); !done; line, done = fileio.EasyNextRealLine(file) {
+		if !strings.HasPrefix(line, "@SQ\t") {
+			continue
+		}
+		fields := make(map[string]string)
+		for _, field := range strings.Split(line, "\t")[1:] {
+			if key, val, found := strings.Cut(field, ":"); found {
+				fields[key] = val
+			}
+		}
+		ans[fields["SN"]] = fields
+	}
+	err := file.Close()
+	exception.PanicOnErr(err)
+	return ans
+}
+
+// Names returns the name of each sequence in the order they appear in the index.
+func (idx Index) Names() []string {
+	ans := make([]string, len(idx.chroms))
+	for i := range idx.chroms {
+		ans[i] = idx.chroms[i].name
+	}
+	return ans
+}
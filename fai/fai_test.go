@@ -0,0 +1,62 @@
+package fai
+
+import (
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/fasta"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestVcfContigHeader(t *testing.T) {
+	dir := t.TempDir()
+	ref := filepath.Join(dir, "ref.fa")
+	write := func(name, text string) {
+		if err := os.WriteFile(name, []byte(text), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(ref, ">chr1\nACGT\n>chr2\nAC\n")
+	write(ref+".fai", "chr1\t4\t6\t4\t5\nchr2\t2\t17\t2\t3\n")
+	if got, expected := VcfContigHeader(ref), "##contig=<ID=chr1,length=4>\n##contig=<ID=chr2,length=2>\n"; got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+
+	write(filepath.Join(dir, "ref.dict"), "@HD\tVN:1.0\n@SQ\tSN:chr1\tLN:4\tM5:f1f8f4bf413b16ad135722aa4591043e\tAS:test\tSP:Homo sapiens\n")
+	expected := "##contig=<ID=chr1,length=4,md5=f1f8f4bf413b16ad135722aa4591043e,assembly=test,species=\"Homo sapiens\">\n##contig=<ID=chr2,length=2>\n"
+	if got := VcfContigHeader(ref); got != expected {
+		t.Errorf("expected %q, got %q", expected, got)
+	}
+}
+
+func TestSeeker(t *testing.T) {
+	ref := filepath.Join(t.TempDir(), "ref.fa")
+	err := os.WriteFile(ref, []byte(">chr1\nACGTA\nCGTAC\nGT\n>chr2\nTTGCA\n"), 0644)
+	if err == nil {
+		err = os.WriteFile(ref+".fai", []byte("chr1\t12\t6\t5\t6\nchr2\t5\t27\t5\t6\n"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, expected := NewSeeker(ref), fasta.NewSeeker(ref, "")
+	defer s.Close()
+	defer expected.Close()
+	for _, r := range []struct {
+		chr        string
+		start, end int
+	}{{"chr1", 0, 12}, {"chr1", 3, 8}, {"chr1", 10, 14}, {"chr1", 20, 22}, {"chr2", 2, 5}, {"chr2", 3, 9}} {
+		seq, err := s.SeekByName(r.chr, r.start, r.end)
+		expectedSeq, expectedErr := fasta.SeekByName(expected, r.chr, r.start, r.end)
+		if dna.BasesToString(seq) != dna.BasesToString(expectedSeq) || err != expectedErr {
+			t.Errorf("%s:%d-%d: expected %s %v, got %s %v", r.chr, r.start, r.end, dna.BasesToString(expectedSeq), expectedErr, dna.BasesToString(seq), err)
+		}
+	}
+
+	// seekers of a file share one parsed index
+	other := NewSeeker(ref)
+	defer other.Close()
+	if reflect.ValueOf(other.idx.nameMap).Pointer() != reflect.ValueOf(s.idx.nameMap).Pointer() {
+		t.Error("expected seekers of the same fasta to share the index")
+	}
+}
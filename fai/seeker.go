@@ -0,0 +1,84 @@
+package fai
+
+import (
+	"errors"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fasta"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// Seeker reads regions of an indexed fasta file. Seekers of the same file share the
+// Index returned by ReadIndex, so a Seeker per worker does not parse the .fai again.
+// A Seeker must not be used by more than one goroutine at a time.
+type Seeker struct {
+	file *os.File
+	idx  Index
+}
+
+// NewSeeker opens fastaFile for random access with its .fai index. It exits with
+// exit.MissingIndex if the index does not exist.
+func NewSeeker(fastaFile string) *Seeker {
+	if strings.HasSuffix(fastaFile, ".gz") {
+		log.Fatalf("ERROR: %s is gzip compressed, which cannot be read by region. Decompress the reference and index it with samtools faidx.", fastaFile)
+	}
+	idx := ReadIndex(fastaFile + ".fai")
+	file, err := os.Open(fastaFile)
+	exception.FatalOnErr(err)
+	return &Seeker{file: file, idx: idx}
+}
+
+// Close closes the fasta file.
+func (s *Seeker) Close() error {
+	return s.file.Close()
+}
+
+// SeekByName returns the bases of chr from start to end (0-based, half open), with the
+// same results and errors as fasta.SeekByName: nil and fasta.ErrSeekStartOutsideChr if
+// start is past the end of chr, and the bases up to the end of chr if end is, with
+// fasta.ErrSeekEndOutsideChr only if the next sequence was reached.
+func (s *Seeker) SeekByName(chr string, start, end int) ([]dna.Base, error) {
+	i, found := s.idx.nameMap[chr]
+	if !found {
+		log.Fatalf("ERROR: could not find sequence for fasta record '%s'\n", chr)
+	}
+	if start > end || start < 0 {
+		log.Panicf("illegal start/end position\n\nstart: %d\nend: %d\n", start, end)
+	}
+	off := s.idx.chroms[i]
+	startOffset := off.offset + (start/off.basesPerLine)*off.bytesPerLine + start%off.basesPerLine
+	endOffset := off.offset + (end/off.basesPerLine)*off.bytesPerLine + end%off.basesPerLine
+	if i+1 < len(s.idx.chroms) && startOffset >= s.idx.chroms[i+1].offset {
+		return nil, fasta.ErrSeekStartOutsideChr
+	}
+
+	data := make([]byte, endOffset-startOffset)
+	n, err := s.file.ReadAt(data, int64(startOffset))
+	if err != nil && !errors.Is(err, io.EOF) {
+		log.Panic(err)
+	}
+	err = nil
+	if n < len(data) { // read past the end of the file
+		data = data[:n]
+		err = fasta.ErrSeekEndOutsideChr
+	}
+
+	answer := make([]dna.Base, 0, end-start)
+	for _, c := range data {
+		if c == '>' { // read into the next sequence
+			err = fasta.ErrSeekEndOutsideChr
+			break
+		}
+		if c == '\r' || c == '\n' {
+			continue
+		}
+		var b dna.Base
+		b, err = dna.ByteToBase(c) // clears a truncation at the end of the file, as in fasta.SeekByName
+		exception.PanicOnErr(err)
+		answer = append(answer, b)
+	}
+	return answer, err
+}
@@ -0,0 +1,46 @@
+// Package hts reads and writes alignment files for the streaming commands. By default
+// it uses the pure-Go readers of gonomics and the bam writer of this module. Building
+// with -tags htslib (which needs cgo and htslib 1.10 or later found by pkg-config)
+// switches to htslib, which decompresses with several threads and reads and writes
+// CRAM up to version 3.1:
+//
+//	go install -tags htslib github.com/dasnellings/duplexTools/cmd/...
+//
+// Commands that seek to regions through a .bai index, such as mcsCallVariants without
+// -stream, always use the pure-Go reader.
+package hts
+
+import (
+	"github.com/vertgenlab/gonomics/sam"
+	"strings"
+)
+
+// Threads is the number of threads htslib uses to decompress and compress each file.
+// It has no effect on the pure-Go backend, which compresses on the bgzf worker pool.
+var Threads = 4
+
+// Reference is the fasta used to decode and encode CRAM, if any.
+var Reference string
+
+// Writer writes sam records to a file.
+type Writer interface {
+	Write(s sam.Sam)
+	Close() error
+}
+
+// GoReadToChan streams the records of the bam, sam, or (with htslib) cram file in
+// filename, like sam.GoReadToChan.
+func GoReadToChan(filename string) (<-chan sam.Sam, sam.Header) {
+	return goReadToChan(filename)
+}
+
+// Create returns a Writer to filename (or stdout) with header h already written. The
+// output is bam unless filename ends in .sam, or .cram with htslib.
+func Create(filename string, h sam.Header) Writer {
+	return create(filename, h)
+}
+
+// IsCram reports whether filename names a cram file.
+func IsCram(filename string) bool {
+	return strings.HasSuffix(strings.ToLower(filename), ".cram")
+}
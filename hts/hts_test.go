@@ -0,0 +1,45 @@
+package hts
+
+import (
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoundTrip(t *testing.T) {
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 1000}}, nil, sam.Coordinate, sam.None)
+	reads := []sam.Sam{
+		{QName: "a", MapQ: 60, RName: "chr1", Pos: 10, Cigar: []cigar.Cigar{{RunLength: 4, Op: 'M'}}, RNext: "*",
+			Seq: dna.StringToBases("ACGT"), Qual: "IIII", Extra: "RF:Z:chr1:10_a"},
+		{QName: "b", Flag: 16, MapQ: 30, RName: "chr1", Pos: 20, Cigar: []cigar.Cigar{{RunLength: 2, Op: 'S'}, {RunLength: 2, Op: 'M'}}, RNext: "*",
+			Seq: dna.StringToBases("TTGA"), Qual: "5555"},
+	}
+	for _, name := range []string{"test.bam", "test.sam"} {
+		filename := filepath.Join(t.TempDir(), name)
+		w := Create(filename, header)
+		for _, r := range reads {
+			w.Write(r)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		in, h := GoReadToChan(filename)
+		if len(h.Chroms) != 1 || h.Chroms[0].Name != "chr1" {
+			t.Errorf("%s: header has chromosomes %v", name, h.Chroms)
+		}
+		var i int
+		for r := range in {
+			if i < len(reads) && sam.ToString(r) != sam.ToString(reads[i]) {
+				t.Errorf("%s: read %d is\n%s\nexpected\n%s", name, i, sam.ToString(r), sam.ToString(reads[i]))
+			}
+			i++
+		}
+		if i != len(reads) {
+			t.Errorf("%s: read %d records, expected %d", name, i, len(reads))
+		}
+	}
+}
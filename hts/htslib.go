@@ -0,0 +1,214 @@
+//go:build htslib
+
+package hts
+
+/*
+#cgo pkg-config: htslib
+#include <limits.h>
+#include <stdlib.h>
+#include <string.h>
+#include <htslib/hts.h>
+#include <htslib/sam.h>
+
+// record_size returns the size of b as stored in a bam file after its block size.
+static size_t record_size(const bam1_t *b) {
+	return 32 + b->l_data - b->core.l_extranul;
+}
+
+// get_record writes b to rec as stored in a bam file after its block size, as bam_write1
+// does, without the NULs padding the read name in memory.
+static void get_record(const bam1_t *b, uint8_t *rec) {
+	const bam1_core_t *c = &b->core;
+	uint32_t l_qname = c->l_qname - c->l_extranul;
+	uint32_t x[8] = {
+		(uint32_t)c->tid, (uint32_t)c->pos, (uint32_t)c->bin << 16 | (uint32_t)c->qual << 8 | l_qname,
+		(uint32_t)c->flag << 16 | c->n_cigar, (uint32_t)c->l_qseq, (uint32_t)c->mtid, (uint32_t)c->mpos,
+		(uint32_t)c->isize,
+	};
+	memcpy(rec, x, 32);
+	memcpy(rec + 32, b->data, l_qname);
+	memcpy(rec + 32 + l_qname, b->data + c->l_qname, b->l_data - c->l_qname);
+}
+
+// set_record sets b to the record rec of n bytes, as stored in a bam file after its block
+// size, padding the read name with NULs to align the cigar as bam_read1 does.
+static int set_record(bam1_t *b, const uint8_t *rec, size_t n) {
+	bam1_core_t *c = &b->core;
+	uint32_t x[8];
+	if (n < 32) return -1;
+	memcpy(x, rec, 32);
+	c->tid = (int32_t)x[0];
+	c->pos = (int32_t)x[1];
+	c->bin = x[2] >> 16;
+	c->qual = x[2] >> 8 & 0xff;
+	c->l_qname = x[2] & 0xff;
+	c->l_extranul = c->l_qname % 4 ? 4 - c->l_qname % 4 : 0;
+	c->flag = x[3] >> 16;
+	c->n_cigar = x[3] & 0xffff;
+	c->l_qseq = (int32_t)x[4];
+	c->mtid = (int32_t)x[5];
+	c->mpos = (int32_t)x[6];
+	c->isize = (int32_t)x[7];
+	size_t rest = n - 32, l_data = rest + c->l_extranul;
+	if (c->l_qname > rest || l_data > INT_MAX) return -1;
+	if (b->m_data < l_data) {
+		uint8_t *data = realloc(b->data, l_data);
+		if (data == NULL) return -1;
+		b->data = data;
+		b->m_data = l_data;
+	}
+	memcpy(b->data, rec + 32, c->l_qname);
+	memset(b->data + c->l_qname, 0, c->l_extranul);
+	memcpy(b->data + c->l_qname + c->l_extranul, rec + 32 + c->l_qname, rest - c->l_qname);
+	c->l_qname += c->l_extranul;
+	b->l_data = l_data;
+	return 0;
+}
+*/
+import "C"
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/dasnellings/duplexTools/salvage"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"log"
+	"strings"
+	"unsafe"
+)
+
+// Backend names the backend duplexTools was built with.
+const Backend = "htslib"
+
+// Cram reports whether cram files can be read and written.
+const Cram = true
+
+// file is an open htslib file and its header. Records are exchanged with htslib in
+// rec, copied to and from buf in their bam encoding, which package bam converts.
+type file struct {
+	name string
+	fp   *C.htsFile
+	hdr  *C.sam_hdr_t
+	rec  *C.bam1_t
+	buf  []byte
+}
+
+// open opens filename with htslib in mode (e.g. "r", "wb", "wc") using Threads and
+// Reference.
+func open(filename, mode string) (*file, error) {
+	if filename == "stdout" || filename == "stdin" {
+		filename = "-"
+	}
+	cName, cMode := C.CString(filename), C.CString(mode)
+	defer C.free(unsafe.Pointer(cName))
+	defer C.free(unsafe.Pointer(cMode))
+	f := &file{name: filename, fp: C.hts_open(cName, cMode)}
+	if f.fp == nil {
+		return nil, fmt.Errorf("htslib could not open %s", filename)
+	}
+	if Threads > 1 {
+		C.hts_set_threads(f.fp, C.int(Threads))
+	}
+	if Reference != "" {
+		cRef := C.CString(Reference)
+		defer C.free(unsafe.Pointer(cRef))
+		if C.hts_set_fai_filename(f.fp, cRef) != 0 {
+			C.hts_close(f.fp)
+			return nil, fmt.Errorf("htslib could not use reference %s for %s", Reference, filename)
+		}
+	}
+	f.rec = C.bam_init1()
+	return f, nil
+}
+
+func (f *file) close() error {
+	C.bam_destroy1(f.rec)
+	if f.hdr != nil {
+		C.sam_hdr_destroy(f.hdr)
+	}
+	if ret := C.hts_close(f.fp); ret != 0 {
+		return fmt.Errorf("htslib could not close %s (error %d)", f.name, int(ret))
+	}
+	return nil
+}
+
+func goReadToChan(filename string) (<-chan sam.Sam, sam.Header) {
+	f, err := open(filename, "r")
+	exception.PanicOnErr(err)
+	f.hdr = C.sam_hdr_read(f.fp)
+	if f.hdr == nil {
+		log.Fatalf("ERROR: htslib could not read the header of %s", filename)
+	}
+	text := C.GoStringN(C.sam_hdr_str(f.hdr), C.int(C.sam_hdr_length(f.hdr)))
+	header := sam.ParseHeaderText(sam.Header{Text: strings.Split(strings.TrimSuffix(text, "\n"), "\n")})
+
+	data := make(chan sam.Sam, 1000)
+	go func() {
+		defer close(data)
+		defer func() {
+			exception.PanicOnErr(f.close())
+		}()
+		for n := 0; ; n++ {
+			ret := C.sam_read1(f.fp, f.hdr, f.rec)
+			if ret == -1 {
+				return
+			}
+			if ret < -1 {
+				salvage.Stop("htslib could not read record %d of %s (error %d), which may be truncated or corrupt.", n+1, filename, int(ret))
+				return
+			}
+			size := int(C.record_size(f.rec))
+			if cap(f.buf) < size {
+				f.buf = make([]byte, size)
+			}
+			f.buf = f.buf[:size]
+			C.get_record(f.rec, (*C.uint8_t)(unsafe.Pointer(&f.buf[0])))
+			data <- bam.Decode(f.buf, header.Chroms)
+		}
+	}()
+	return data, header
+}
+
+// htsWriter encodes records as bam records and has htslib write them.
+type htsWriter struct {
+	*file
+	refs map[string]int // index of each reference in the header
+}
+
+func create(filename string, h sam.Header) Writer {
+	mode := "wb"
+	switch {
+	case IsCram(filename):
+		mode = "wc"
+	case strings.HasSuffix(filename, ".sam"):
+		mode = "w"
+	}
+	f, err := open(filename, mode)
+	exception.PanicOnErr(err)
+	text := C.CString(strings.Join(h.Text, "\n") + "\n")
+	defer C.free(unsafe.Pointer(text))
+	f.hdr = C.sam_hdr_parse(C.strlen(text), text)
+	if f.hdr == nil || C.sam_hdr_write(f.fp, f.hdr) != 0 {
+		log.Fatalf("ERROR: htslib could not write the header of %s", filename)
+	}
+	refs := make(map[string]int, len(h.Chroms))
+	for i, c := range h.Chroms {
+		refs[c.Name] = i
+	}
+	return htsWriter{file: f, refs: refs}
+}
+
+func (w htsWriter) Write(s sam.Sam) {
+	w.buf = bam.AppendRecord(w.buf[:0], s, w.refs)
+	if C.set_record(w.rec, (*C.uint8_t)(unsafe.Pointer(&w.buf[0])), C.size_t(len(w.buf))) < 0 {
+		log.Fatalf("ERROR: htslib could not encode read %s for %s", s.QName, w.name)
+	}
+	if C.sam_write1(w.fp, w.hdr, w.rec) < 0 {
+		log.Fatalf("ERROR: htslib could not write read %s to %s", s.QName, w.name)
+	}
+}
+
+func (w htsWriter) Close() error {
+	return w.close()
+}
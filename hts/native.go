@@ -0,0 +1,54 @@
+//go:build !htslib
+
+package hts
+
+import (
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"strings"
+)
+
+// Backend names the backend duplexTools was built with.
+const Backend = "go"
+
+// Cram reports whether cram files can be read and written.
+const Cram = false
+
+func goReadToChan(filename string) (<-chan sam.Sam, sam.Header) {
+	return sam.GoReadToChan(filename)
+}
+
+// fileWriter closes the file under the encoder.
+type fileWriter struct {
+	file *fileio.EasyWriter
+	bam  *bam.Writer
+}
+
+func (w *fileWriter) Write(s sam.Sam) {
+	if w.bam == nil {
+		sam.WriteToFileHandle(w.file, s)
+		return
+	}
+	w.bam.Write(s)
+}
+
+func (w *fileWriter) Close() error {
+	if w.bam != nil {
+		if err := w.bam.Close(); err != nil {
+			w.file.Close()
+			return err
+		}
+	}
+	return w.file.Close()
+}
+
+func create(filename string, h sam.Header) Writer {
+	w := &fileWriter{file: fileio.EasyCreate(filename)}
+	if strings.HasSuffix(strings.TrimSuffix(filename, ".gz"), ".sam") {
+		sam.WriteHeaderToFileHandle(w.file, h)
+	} else {
+		w.bam = bam.NewWriter(w.file, h)
+	}
+	return w
+}
@@ -0,0 +1,406 @@
+// Package intervals reads the regions given to options such as targets, excludes, and
+// regions of interest. BED, Picard interval_list, and GFF3 files are accepted and are
+// returned as zero-based half-open bed records sorted by position.
+package intervals
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/salvage"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"math"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format is the format of an interval file.
+type Format int
+
+const (
+	Bed          Format = iota // 0-based half-open, tab separated
+	IntervalList               // Picard interval_list: sam style header, then 1-based closed chrom, start, end, strand, name
+	Gff3                       // GFF3: 1-based closed, with the name taken from the Name or ID attribute
+)
+
+var extensions = []struct {
+	suffix string
+	format Format
+}{
+	{".bed", Bed},
+	{".interval_list", IntervalList},
+	{".intervals", IntervalList},
+	{".list", IntervalList},
+	{".gff3", Gff3},
+	{".gff", Gff3},
+}
+
+// DetectFormat returns the format of filename from its extension (ignoring .gz), or
+// from its first line if the extension is not recognized: a line starting with @HD or
+// @SQ is an interval_list and ##gff-version is GFF3. Anything else is read as BED.
+func DetectFormat(filename string) Format {
+	name := strings.TrimSuffix(filename, ".gz")
+	for _, e := range extensions {
+		if strings.HasSuffix(name, e.suffix) {
+			return e.format
+		}
+	}
+	file := fileio.EasyOpen(filename)
+	defer cleanup(file)
+	for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) {
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "@HD") || strings.HasPrefix(line, "@SQ"):
+			return IntervalList
+		case strings.HasPrefix(line, "##gff-version"):
+			return Gff3
+		}
+		break
+	}
+	return Bed
+}
+
+// Name returns the base name of filename without .gz or an interval file extension,
+// e.g. to label the intervals of each file.
+func Name(filename string) string {
+	name := strings.TrimSuffix(filepath.Base(filename), ".gz")
+	for _, e := range extensions {
+		if s, found := strings.CutSuffix(name, e.suffix); found {
+			return s
+		}
+	}
+	return name
+}
+
+// Read returns the intervals in filename sorted by chromosome, start, and end.
+// Chromosomes are kept in the order of the interval_list header, or else in the order
+// they first appear in the file. Malformed lines exit with exit.MalformedInput.
+func Read(filename string) []bed.Bed {
+	var ans []bed.Bed
+	var order map[string]int
+	switch DetectFormat(filename) {
+	case IntervalList:
+		ans, order = readIntervalList(filename)
+	case Gff3:
+		ans = readGff3(filename)
+	default:
+		ans = readBed(filename)
+	}
+	sortBeds(ans, order)
+	return ans
+}
+
+// ReadMerged is Read with overlapping and book-ended intervals merged, for options
+// that use the intervals as a mask. A merged interval keeps the name of its first interval.
+func ReadMerged(filename string) []bed.Bed {
+	return merge(Read(filename))
+}
+
+// ParseRegion converts chr:start-end (1-based, inclusive) or chr, for a whole
+// chromosome, to a bed. Commas in the coordinates are ignored.
+func ParseRegion(region string) (bed.Bed, error) {
+	ans := bed.Bed{Chrom: region, ChromStart: 0, ChromEnd: math.MaxInt32, FieldsInitialized: 3}
+	colon := strings.LastIndex(region, ":")
+	if colon == -1 {
+		return ans, nil
+	}
+	ans.Chrom = region[:colon]
+	words := strings.Split(strings.ReplaceAll(region[colon+1:], ",", ""), "-")
+	var err error
+	ans.ChromStart, err = strconv.Atoi(words[0])
+	if err != nil || len(words) != 2 {
+		return ans, fmt.Errorf("could not parse region '%s'. Must be formatted as chr:start-end", region)
+	}
+	ans.ChromStart--
+	ans.ChromEnd, err = strconv.Atoi(words[1])
+	if err != nil || ans.ChromEnd <= ans.ChromStart {
+		return ans, fmt.Errorf("could not parse region '%s'. Must be formatted as chr:start-end", region)
+	}
+	return ans, nil
+}
+
+// CheckContigs exits with exit.ContigMismatch if any interval in regions, read from
+// filename, is on a chromosome that is not in the reference index idx.
+func CheckContigs(regions []bed.Bed, idx fai.Index, filename string) {
+	for i := range regions {
+		if !idx.Contains(regions[i].Chrom) {
+			exit.Fatalf(exit.ContigMismatch, "%s in %s is not in the reference. Intervals must use the chromosome names of the reference.", regions[i].Chrom, filename)
+		}
+	}
+}
+
+func readBed(filename string) []bed.Bed {
+	var ans []bed.Bed
+	file := fileio.EasyOpen(filename)
+	defer cleanup(file)
+	var lineNum int
+	for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) {
+		lineNum++
+		if skipBed(line) {
+			continue
+		}
+		b, err := parseBed(line)
+		if err != nil {
+			malformed(filename, lineNum, err.Error())
+		}
+		ans = append(ans, b)
+	}
+	return ans
+}
+
+// GoReadToChan streams the records of a bed file in file order, like bed.GoReadToChan
+// but reporting the line of a malformed record. Reading stops at a malformed record
+// with salvage.Stop, so with -salvage the records before it are still sent.
+func GoReadToChan(filename string) <-chan bed.Bed {
+	file := fileio.EasyOpen(filename)
+	ans := make(chan bed.Bed, 1000)
+	go func() {
+		defer close(ans)
+		defer cleanup(file)
+		var lineNum int
+		for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) {
+			lineNum++
+			if skipBed(line) {
+				continue
+			}
+			b, err := parseBed(line)
+			if err != nil {
+				salvage.Stop("%s line %d: %s.", filename, lineNum, err)
+				return
+			}
+			ans <- b
+		}
+	}()
+	return ans
+}
+
+func skipBed(line string) bool {
+	return line == "" || line[0] == '#' || strings.HasPrefix(line, "track") || strings.HasPrefix(line, "browser")
+}
+
+func parseBed(line string) (bed.Bed, error) {
+	words := strings.Split(line, "\t")
+	if len(words) < 3 {
+		return bed.Bed{}, errors.New("expected at least 3 tab separated columns")
+	}
+	b := bed.Bed{Chrom: words[0], Strand: bed.None, FieldsInitialized: len(words)}
+	var err error
+	if b.ChromStart, b.ChromEnd, err = coordinates(words[1], words[2], 0); err != nil {
+		return b, err
+	}
+	if len(words) >= 4 {
+		b.Name = words[3]
+	}
+	if len(words) >= 5 {
+		b.Score, _ = strconv.Atoi(words[4]) // "." and scores with decimals are read as 0
+	}
+	if len(words) >= 6 {
+		if b.Strand, err = strand(words[5]); err != nil {
+			return b, err
+		}
+	}
+	if len(words) >= 7 {
+		b.Annotation = words[6:]
+	}
+	return b, nil
+}
+
+// readIntervalList reads a Picard interval_list and returns the intervals and the
+// order of the chromosomes in its @SQ header lines.
+func readIntervalList(filename string) ([]bed.Bed, map[string]int) {
+	var ans []bed.Bed
+	order := make(map[string]int)
+	file := fileio.EasyOpen(filename)
+	defer cleanup(file)
+	var lineNum int
+	for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) {
+		lineNum++
+		if line == "" {
+			continue
+		}
+		if line[0] == '@' {
+			if strings.HasPrefix(line, "@SQ") {
+				for _, field := range strings.Split(line, "\t") {
+					if name, found := strings.CutPrefix(field, "SN:"); found {
+						order[name] = len(order)
+					}
+				}
+			}
+			continue
+		}
+		words := strings.Split(line, "\t")
+		if len(words) < 3 {
+			malformed(filename, lineNum, "expected at least 3 tab separated columns")
+		}
+		b := bed.Bed{Chrom: words[0], Strand: bed.None, FieldsInitialized: 6}
+		var err error
+		if b.ChromStart, b.ChromEnd, err = coordinates(words[1], words[2], 1); err != nil {
+			malformed(filename, lineNum, err.Error())
+		}
+		if len(words) >= 4 {
+			if b.Strand, err = strand(words[3]); err != nil {
+				malformed(filename, lineNum, err.Error())
+			}
+		}
+		if len(words) >= 5 {
+			b.Name = words[4]
+		}
+		ans = append(ans, b)
+	}
+	if len(order) == 0 {
+		order = nil
+	}
+	return ans, order
+}
+
+func readGff3(filename string) []bed.Bed {
+	var ans []bed.Bed
+	file := fileio.EasyOpen(filename)
+	defer cleanup(file)
+	var lineNum int
+	for line, done := fileio.EasyNextLine(file); !done; line, done = fileio.EasyNextLine(file) {
+		lineNum++
+		if line == "##FASTA" { // sequences may follow the features
+			break
+		}
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		words := strings.Split(line, "\t")
+		if len(words) != 9 {
+			malformed(filename, lineNum, "expected 9 tab separated columns")
+		}
+		b := bed.Bed{Chrom: unescape(words[0]), Name: words[2], Strand: bed.None, FieldsInitialized: 6}
+		var err error
+		if b.ChromStart, b.ChromEnd, err = coordinates(words[3], words[4], 1); err != nil {
+			malformed(filename, lineNum, err.Error())
+		}
+		if b.Strand, err = strand(words[6]); err != nil {
+			malformed(filename, lineNum, err.Error())
+		}
+		if name := gffName(words[8]); name != "" {
+			b.Name = name
+		}
+		ans = append(ans, b)
+	}
+	return ans
+}
+
+// gffName returns the Name attribute of a GFF3 feature, or its ID if it has no Name.
+func gffName(attributes string) string {
+	var id string
+	for _, attr := range strings.Split(attributes, ";") {
+		key, value, _ := strings.Cut(attr, "=")
+		switch key {
+		case "Name":
+			return unescape(value)
+		case "ID":
+			id = unescape(value)
+		}
+	}
+	return id
+}
+
+func unescape(s string) string {
+	if u, err := url.PathUnescape(s); err == nil {
+		return u
+	}
+	return s
+}
+
+// coordinates parses the start and end of an interval. One is subtracted from the
+// start of formats with 1-based starts (offset 1) to make it zero-based.
+func coordinates(start, end string, offset int) (int, int, error) {
+	s, err := strconv.Atoi(start)
+	if err != nil {
+		return 0, 0, fmt.Errorf("start '%s' is not a number", start)
+	}
+	e, err := strconv.Atoi(end)
+	if err != nil {
+		return 0, 0, fmt.Errorf("end '%s' is not a number", end)
+	}
+	s -= offset
+	if s < 0 || e < s {
+		return 0, 0, fmt.Errorf("interval %s-%s is not valid", start, end)
+	}
+	return s, e, nil
+}
+
+func strand(s string) (bed.Strand, error) {
+	switch s {
+	case "+":
+		return bed.Positive, nil
+	case "-":
+		return bed.Negative, nil
+	case ".", "?", "":
+		return bed.None, nil
+	}
+	return bed.None, fmt.Errorf("strand '%s' is not +, -, or .", s)
+}
+
+func malformed(filename string, lineNum int, msg string) {
+	exit.Fatalf(exit.MalformedInput, "%s line %d: %s.", filename, lineNum, msg)
+}
+
+// sortBeds sorts b by chromosome, start, and end. Chromosomes are ordered by order if
+// it is not nil (unlisted chromosomes sort last), or else by their first appearance.
+func sortBeds(b []bed.Bed, order map[string]int) {
+	if order == nil {
+		order = make(map[string]int)
+		for i := range b {
+			if _, found := order[b[i].Chrom]; !found {
+				order[b[i].Chrom] = len(order)
+			}
+		}
+	}
+	rank := func(chrom string) int {
+		if r, found := order[chrom]; found {
+			return r
+		}
+		return len(order)
+	}
+	sort.SliceStable(b, func(i, j int) bool {
+		ri, rj := rank(b[i].Chrom), rank(b[j].Chrom)
+		switch {
+		case ri != rj:
+			return ri < rj
+		case b[i].Chrom != b[j].Chrom:
+			return b[i].Chrom < b[j].Chrom
+		case b[i].ChromStart != b[j].ChromStart:
+			return b[i].ChromStart < b[j].ChromStart
+		default:
+			return b[i].ChromEnd < b[j].ChromEnd
+		}
+	})
+}
+
+// merge merges overlapping and book-ended intervals of sorted in place.
+func merge(sorted []bed.Bed) []bed.Bed {
+	if len(sorted) == 0 {
+		return sorted
+	}
+	ans := sorted[:1]
+	for _, b := range sorted[1:] {
+		last := &ans[len(ans)-1]
+		if b.Chrom != last.Chrom || b.ChromStart > last.ChromEnd {
+			ans = append(ans, b)
+			continue
+		}
+		if b.ChromEnd > last.ChromEnd {
+			last.ChromEnd = b.ChromEnd
+		}
+	}
+	return ans
+}
+
+func cleanup(file *fileio.EasyReader) {
+	err := file.Close()
+	exception.PanicOnErr(err)
+}
@@ -0,0 +1,100 @@
+package intervals
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRead(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name     string
+		data     string
+		format   Format
+		expected []bed.Bed
+	}{
+		{"targets.bed", "track name=targets\nchr2\t10\t20\tCA\nchr1\t5\t8\n#comment\nchr2\t0\t4\tGT\t0\t-\n", Bed, []bed.Bed{
+			{Chrom: "chr2", ChromStart: 0, ChromEnd: 4, Name: "GT", Strand: bed.Negative},
+			{Chrom: "chr2", ChromStart: 10, ChromEnd: 20, Name: "CA", Strand: bed.None},
+			{Chrom: "chr1", ChromStart: 5, ChromEnd: 8, Strand: bed.None},
+		}},
+		{"targets.interval_list", "@HD\tVN:1.6\n@SQ\tSN:chr1\tLN:100\n@SQ\tSN:chr2\tLN:100\nchr2\t11\t20\t+\tCA\nchr1\t6\t8\t-\tt1\n", IntervalList, []bed.Bed{
+			{Chrom: "chr1", ChromStart: 5, ChromEnd: 8, Name: "t1", Strand: bed.Negative},
+			{Chrom: "chr2", ChromStart: 10, ChromEnd: 20, Name: "CA", Strand: bed.Positive},
+		}},
+		{"no_extension", "##gff-version 3\nchr1\tsrc\tgene\t101\t200\t.\t+\t.\tID=gene1;Name=ABC%3B1\nchr1\tsrc\texon\t1\t50\t.\t.\t.\tParent=gene1\n##FASTA\n>chr1\n", Gff3, []bed.Bed{
+			{Chrom: "chr1", ChromStart: 0, ChromEnd: 50, Name: "exon", Strand: bed.None},
+			{Chrom: "chr1", ChromStart: 100, ChromEnd: 200, Name: "ABC;1", Strand: bed.Positive},
+		}},
+	}
+	for _, test := range tests {
+		filename := filepath.Join(dir, test.name)
+		if err := os.WriteFile(filename, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if f := DetectFormat(filename); f != test.format {
+			t.Errorf("%s: expected format %d, got %d", test.name, test.format, f)
+		}
+		actual := Read(filename)
+		if len(actual) != len(test.expected) {
+			t.Errorf("%s: expected %d intervals, got %d", test.name, len(test.expected), len(actual))
+			continue
+		}
+		for i := range actual {
+			a, e := actual[i], test.expected[i]
+			if a.Chrom != e.Chrom || a.ChromStart != e.ChromStart || a.ChromEnd != e.ChromEnd || a.Name != e.Name || a.Strand != e.Strand {
+				t.Errorf("%s: expected %v, got %v", test.name, e, a)
+			}
+		}
+	}
+}
+
+func TestMerge(t *testing.T) {
+	merged := merge([]bed.Bed{
+		{Chrom: "chr1", ChromStart: 0, ChromEnd: 10},
+		{Chrom: "chr1", ChromStart: 5, ChromEnd: 8},
+		{Chrom: "chr1", ChromStart: 10, ChromEnd: 15},
+		{Chrom: "chr1", ChromStart: 20, ChromEnd: 25},
+		{Chrom: "chr2", ChromStart: 0, ChromEnd: 5},
+	})
+	expected := [][2]int{{0, 15}, {20, 25}, {0, 5}}
+	if len(merged) != len(expected) {
+		t.Fatalf("expected %d intervals, got %v", len(expected), merged)
+	}
+	for i := range merged {
+		if merged[i].ChromStart != expected[i][0] || merged[i].ChromEnd != expected[i][1] {
+			t.Errorf("expected %v, got %v", expected[i], merged[i])
+		}
+	}
+}
+
+func TestName(t *testing.T) {
+	for filename, expected := range map[string]string{
+		"/data/exons.bed.gz":  "exons",
+		"panel.interval_list": "panel",
+		"genes.gff3":          "genes",
+		"regions.txt":         "regions.txt",
+	} {
+		if actual := Name(filename); actual != expected {
+			t.Errorf("%s: expected %s, got %s", filename, expected, actual)
+		}
+	}
+}
+
+func TestParseRegion(t *testing.T) {
+	b, err := ParseRegion("chr1:1,001-2000")
+	if err != nil || b.Chrom != "chr1" || b.ChromStart != 1000 || b.ChromEnd != 2000 {
+		t.Errorf("unexpected region %v %v", b, err)
+	}
+	if b, err = ParseRegion("chrUn:KI270302v1"); err == nil {
+		t.Errorf("expected an error, got %v", b)
+	}
+	if b, err = ParseRegion("chrX"); err != nil || b.ChromStart != 0 || b.ChromEnd <= 156_040_895 {
+		t.Errorf("expected the whole chromosome, got %v %v", b, err)
+	}
+	if _, err = ParseRegion("chr1:200-100"); err == nil {
+		t.Error("expected an error for an end before the start")
+	}
+}
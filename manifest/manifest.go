@@ -0,0 +1,240 @@
+// Package manifest adds a -manifest option to every command, which writes a JSON record
+// of a finished run: the value of every option after defaults are filled in, and the
+// size and SHA-256 checksum of each file read and written. Provenance tracking can then
+// ingest what a run actually did instead of reconstructing it from logs.
+//
+// Files are found among the option values and positional arguments once the command
+// returns, so outputs whose names a command derives from other options (e.g. the
+// default -calledSitesOut of mcsCallVariants) are included. A value is an output if its
+// option names one (see IsOutput) and an input if it is an existing file. Index files
+// written next to an output are listed as outputs.
+package manifest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"github.com/dasnellings/duplexTools/deterministic"
+	"github.com/dasnellings/duplexTools/pipe"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/dasnellings/duplexTools/schema"
+	"github.com/dasnellings/duplexTools/tmp"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Flag is the name of the option.
+const Flag = "manifest"
+
+var (
+	path    string
+	fs      *flag.FlagSet
+	started = time.Now()
+	mu      sync.Mutex
+	aliases = make(map[string]string) // local path -> path given by the user
+)
+
+// indexSuffixes are the indexes that may be written next to an output.
+var indexSuffixes = []string{".tbi", ".csi", ".bai"}
+
+// AddFlag adds the -manifest option to f. The manifest lists the options in f.
+func AddFlag(f *flag.FlagSet) {
+	fs = f
+	f.StringVar(&path, Flag, path, "Write a JSON manifest of the resolved options and the checksums of the input and output files to this file when the command finishes.")
+}
+
+// Alias records that local is read or written in place of the path given by the user,
+// e.g. a download of a remote input, so the manifest lists the path given by the user.
+func Alias(local, original string) {
+	mu.Lock()
+	aliases[local] = original
+	mu.Unlock()
+}
+
+// IsOutput reports whether f names an output: -o, options ending in out, output, or
+// pfx, and options whose usage starts with Output or Write.
+func IsOutput(f *flag.Flag) bool {
+	if f == nil { // positional argument
+		return false
+	}
+	lower := strings.ToLower(f.Name)
+	return lower == "o" || strings.HasSuffix(lower, "out") || strings.HasSuffix(lower, "output") || strings.HasSuffix(lower, "pfx") ||
+		strings.HasPrefix(f.Usage, "Output") || strings.HasPrefix(f.Usage, "Write")
+}
+
+// File is an input or output of the run.
+type File struct {
+	Path   string `json:"path"`
+	Flag   string `json:"flag,omitempty"` // empty for positional arguments
+	Size   int64  `json:"size"`
+	Sha256 string `json:"sha256,omitempty"` // empty for pipes, which cannot be read again
+}
+
+// Manifest is the document written by -manifest.
+type Manifest struct {
+	schema.Header
+	Command     string            `json:"command"`
+	CommandLine string            `json:"commandLine"`
+	Options     map[string]string `json:"options"`
+	Inputs      []File            `json:"inputs"`
+	Outputs     []File            `json:"outputs"`
+	Started     string            `json:"started,omitempty"` // RFC 3339, left out with -deterministic
+	Finished    string            `json:"finished,omitempty"`
+}
+
+// Write writes the manifest if -manifest was given. It is called when the command
+// returns, and exits if the manifest cannot be written.
+func Write() {
+	if path == "" || fs == nil {
+		return
+	}
+	m := Build(fs, started)
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err == nil {
+		err = os.WriteFile(path, append(b, '\n'), 0644)
+	}
+	if err != nil {
+		log.Fatalf("ERROR: could not write manifest %s: %s", path, err)
+	}
+}
+
+// Build returns the manifest of a run with options f that started at start. Outputs
+// not modified since start were not written by the run and are left out.
+func Build(f *flag.FlagSet, start time.Time) Manifest {
+	m := Manifest{
+		Header:      schema.NewHeader("manifest"),
+		Command:     provenance.Command(),
+		CommandLine: provenance.CommandLine(),
+		Options:     make(map[string]string),
+		Inputs:      []File{},
+		Outputs:     []File{},
+	}
+	if !deterministic.Enabled() {
+		m.Started = start.Format(time.RFC3339)
+		m.Finished = time.Now().Format(time.RFC3339)
+	}
+
+	seen := make(map[string]bool)
+	add := func(name, value string, output bool) {
+		for _, p := range paths(name, value, output) {
+			if seen[p] || p == path {
+				continue
+			}
+			file := File{Path: p, Flag: name}
+			info, err := os.Stat(p)
+			switch {
+			case err != nil && !output && pipe.Name(p) != p: // a relayed bam pipe, removed once opened
+			case err != nil || info.IsDir() || output && info.ModTime().Before(start):
+				continue
+			default:
+				file.Size = info.Size()
+			}
+			seen[p] = true
+			if output {
+				m.Outputs = append(m.Outputs, file)
+			} else {
+				m.Inputs = append(m.Inputs, file)
+			}
+		}
+	}
+	f.VisitAll(func(fl *flag.Flag) {
+		m.Options[fl.Name] = fl.Value.String()
+		if fl.Name == Flag || fl.Name == tmp.Flag {
+			return
+		}
+		output := IsOutput(fl)
+		add(fl.Name, fl.Value.String(), output)
+		if output {
+			for _, s := range indexSuffixes {
+				add(fl.Name, fl.Value.String()+s, true)
+			}
+		}
+	})
+	for _, a := range f.Args() {
+		add("", a, false)
+	}
+	checksum(m.Inputs)
+	checksum(m.Outputs)
+	sort.Slice(m.Inputs, func(i, j int) bool { return m.Inputs[i].Path < m.Inputs[j].Path })
+	sort.Slice(m.Outputs, func(i, j int) bool { return m.Outputs[i].Path < m.Outputs[j].Path })
+	return m
+}
+
+// paths returns the files named by the value of option name: a single path, paths
+// separated by spaces for options that may be given more than once, or for outputs
+// ending in pfx, the files starting with the prefix.
+func paths(name, value string, output bool) []string {
+	if value == "" || value == "stdout" || value == "stdin" || value == "-" {
+		return nil
+	}
+	if output && strings.HasSuffix(strings.ToLower(name), "pfx") {
+		matches, _ := filepath.Glob(value + "*")
+		return matches
+	}
+	if _, err := os.Stat(value); err == nil || pipe.Name(value) != value {
+		return []string{value}
+	}
+	return strings.Fields(value)
+}
+
+// checksum fills in the checksum of each file that can be read again, reading the files
+// in parallel, and replaces local paths with the paths given by the user.
+func checksum(files []File) {
+	wg := new(sync.WaitGroup)
+	for i := range files {
+		local := files[i].Path
+		files[i].Path = given(local)
+		if _, err := os.Stat(local); err != nil || pipe.Is(local) {
+			continue
+		}
+		wg.Add(1)
+		go func(file *File, local string) {
+			defer wg.Done()
+			sum, err := hashFile(local)
+			if err != nil {
+				log.Printf("WARNING: could not checksum %s for the manifest: %s", local, err)
+				return
+			}
+			file.Sha256 = sum
+		}(&files[i], local)
+	}
+	wg.Wait()
+}
+
+// given returns the path the user gave for local: the pipe or remote path it was made
+// from, or else the absolute path.
+func given(local string) string {
+	if p := pipe.Name(local); p != local {
+		return p
+	}
+	mu.Lock()
+	p, found := aliases[local]
+	mu.Unlock()
+	if found {
+		return p
+	}
+	if abs, err := filepath.Abs(local); err == nil {
+		return abs
+	}
+	return local
+}
+
+func hashFile(name string) (string, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,53 @@
+package manifest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBuild(t *testing.T) {
+	dir := t.TempDir()
+	in := filepath.Join(dir, "in.bed")
+	stale := filepath.Join(dir, "stale.txt")
+	for _, name := range []string{in, stale} {
+		if err := os.WriteFile(name, []byte("abc"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(stale, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now().Add(-time.Minute)
+	out := filepath.Join(dir, "out.vcf")
+	for _, name := range []string{out, out + ".tbi"} {
+		if err := os.WriteFile(name, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("b", "", "Input bed.")
+	fs.String("o", "", "Output vcf.")
+	fs.String("statsOut", "", "Output stats.")
+	fs.Int("a", 8, "Minimum depth.")
+	if err := fs.Parse([]string{"-b", in, "-o", out, "-statsOut", stale}); err != nil {
+		t.Fatal(err)
+	}
+
+	m := Build(fs, start)
+	if m.Options["a"] != "8" || m.Options["b"] != in {
+		t.Errorf("options %v do not include defaults and given values", m.Options)
+	}
+	if len(m.Inputs) != 1 || m.Inputs[0].Path != in || m.Inputs[0].Flag != "b" || m.Inputs[0].Size != 3 ||
+		m.Inputs[0].Sha256 != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("inputs are %+v", m.Inputs)
+	}
+	if len(m.Outputs) != 2 || m.Outputs[0].Path != out || m.Outputs[1].Path != out+".tbi" {
+		t.Errorf("outputs are %+v, expected %s and its index but not the output older than the run", m.Outputs, out)
+	}
+}
@@ -0,0 +1,287 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SvTypeInfo is the INFO field of the structural variant type of a breakend record,
+// always BND.
+const SvTypeInfo = "SVTYPE"
+
+// breakendRead is a primary alignment of a family kept for breakend calling before the
+// read filters and end clipping of FetchFamily.
+type breakendRead struct {
+	name                string
+	strand              byte // RS tag, W or C
+	start, end          int  // 1-based first and last aligned reference base
+	leftClip, rightClip int  // soft and hard clipped bases at either end
+	f1r2                bool // orientation, for WatsonIsPlus
+	mate                supplementary
+}
+
+// supplementary is the first alignment of the SA tag of a read.
+type supplementary struct {
+	chr        string
+	start, end int  // 1-based first and last aligned reference base
+	sameStrand bool // aligned to the same strand as the read
+	found      bool
+}
+
+// breakends holds the reads of a family that may support a breakend, collected in
+// FetchFamily with MinBreakendClip.
+type breakends struct {
+	reads []breakendRead
+}
+
+// reset empties b for the next family.
+func (b *breakends) reset() {
+	b.reads = b.reads[:0]
+}
+
+// add records r, a read of the family with the RS tag strand. Secondary and
+// supplementary alignments are skipped, so each read is counted once.
+func (b *breakends) add(r *sam.Sam, strand byte) {
+	if r.Flag&(0x100|0x800) != 0 || len(r.Cigar) == 0 || r.Cigar[0].Op == '*' {
+		return
+	}
+	br := breakendRead{name: r.QName, strand: strand, start: int(r.Pos), end: int(r.Pos) + cigar.ReferenceLength(r.Cigar) - 1, f1r2: getOrientation(r) == F1R2}
+	for i := 0; i < len(r.Cigar) && isClip(r.Cigar[i].Op); i++ {
+		br.leftClip += r.Cigar[i].RunLength
+	}
+	for i := len(r.Cigar) - 1; i >= 0 && isClip(r.Cigar[i].Op); i-- {
+		br.rightClip += r.Cigar[i].RunLength
+	}
+	for _, tag := range strings.Split(r.Extra, "\t") {
+		if sa, found := strings.CutPrefix(tag, "SA:Z:"); found {
+			br.mate = parseSupplementary(sa, r.Flag&0x10 != 0)
+			break
+		}
+	}
+	b.reads = append(b.reads, br)
+}
+
+func isClip(op rune) bool {
+	return op == 'S' || op == 'H'
+}
+
+// parseSupplementary returns the first alignment of the SA tag value sa
+// (rname,pos,strand,CIGAR,mapQ,NM;...) of a read on the minus strand if reverse.
+func parseSupplementary(sa string, reverse bool) supplementary {
+	first, _, _ := strings.Cut(sa, ";")
+	fields := strings.Split(first, ",")
+	if len(fields) < 4 || len(fields[2]) != 1 {
+		return supplementary{}
+	}
+	pos, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return supplementary{}
+	}
+	refLen := cigar.ReferenceLength(cigar.FromString(fields[3]))
+	return supplementary{chr: fields[0], start: pos, end: pos + refLen - 1, sameStrand: (fields[2] == "-") == reverse, found: true}
+}
+
+// breakendSite is a candidate breakend: the last aligned base of reads clipped on the
+// right, or the first of reads clipped on the left.
+type breakendSite struct {
+	pos   int
+	right bool
+}
+
+// callBreakends returns a BND record for each position of family b where the reads of
+// the family are clipped by at least MinBreakendClip bases, supported by reads as SNVs
+// are: at least MinAf of the reads of each strand covering the position, with
+// MinStrandedDepth and MinTotalDepth reads covering it, or only the total with
+// MinStrandedDepth 0. Where most supporting reads have a supplementary alignment at the
+// same mate breakend, the record joins the two (e.g. G]chr2:5000]), and is a single
+// breakend (e.g. G.) otherwise. Breakends are called whether or not the family has
+// enough reads that pass the read filters to call other variants.
+func (c *Caller) callBreakends(b bed.Bed) []vcf.Vcf {
+	if c.MinBreakendClip <= 0 || len(c.breaks.reads) == 0 {
+		return nil
+	}
+	var watsonF1R2, watsonF2R1 int // as in WatsonIsPlus
+	for _, r := range c.breaks.reads {
+		switch {
+		case r.strand != 'W':
+		case r.f1r2:
+			watsonF1R2++
+		default:
+			watsonF2R1++
+		}
+	}
+	watsonIsPlus := watsonF1R2 < watsonF2R1
+	sites := make(map[breakendSite]bool)
+	for _, r := range c.breaks.reads {
+		if r.leftClip >= c.MinBreakendClip {
+			sites[breakendSite{pos: r.start}] = true
+		}
+		if r.rightClip >= c.MinBreakendClip {
+			sites[breakendSite{pos: r.end, right: true}] = true
+		}
+	}
+	sorted := make([]breakendSite, 0, len(sites))
+	for s := range sites {
+		sorted = append(sorted, s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].pos != sorted[j].pos {
+			return sorted[i].pos < sorted[j].pos
+		}
+		return !sorted[i].right && sorted[j].right
+	})
+
+	var ans []vcf.Vcf
+	for _, s := range sorted {
+		if v, ok := c.breakendCall(b, s, watsonIsPlus); ok {
+			ans = append(ans, v)
+		}
+	}
+	return ans
+}
+
+// breakendCall returns the record of the breakend at s, and false if it is not
+// supported. PS and MS count the supporting reads of the plus and minus strands, so
+// watson and crick are swapped unless watsonIsPlus.
+func (c *Caller) breakendCall(b bed.Bed, s breakendSite, watsonIsPlus bool) (vcf.Vcf, bool) {
+	t := dna.BaseToString(c.refBase(b.Chrom, s.pos))
+	type vote struct {
+		strand   int
+		supports bool
+		mate     string // ALT joined to the mate breakend of the supplementary alignment
+	}
+	votes := make(map[string]*vote) // a read pair votes once unless CountOverlappingPairs
+	for i, r := range c.breaks.reads {
+		if r.start > s.pos || r.end < s.pos {
+			continue
+		}
+		strand := 0
+		switch {
+		case r.strand == 'W' && !watsonIsPlus, r.strand == 'C' && watsonIsPlus:
+			strand = 1
+		case r.strand != 'W' && r.strand != 'C':
+			continue
+		}
+		key := string(r.strand) + r.name
+		if c.CountOverlappingPairs {
+			key += "\t" + strconv.Itoa(i)
+		}
+		v, found := votes[key]
+		if !found {
+			v = &vote{strand: strand}
+			votes[key] = v
+		}
+		if (s.right && r.end == s.pos && r.rightClip >= c.MinBreakendClip) || (!s.right && r.start == s.pos && r.leftClip >= c.MinBreakendClip) {
+			v.supports = true
+			if r.mate.found && v.mate == "" {
+				v.mate = mateAlt(s, r.mate, t)
+			}
+		}
+	}
+	var support, depth [2]int
+	mates := make(map[string]int)
+	for _, v := range votes {
+		depth[v.strand]++
+		if v.supports {
+			support[v.strand]++
+			if v.mate != "" {
+				mates[v.mate]++
+			}
+		}
+	}
+
+	total, alt := depth[0]+depth[1], support[0]+support[1]
+	strandedness := doubleStranded
+	switch {
+	case total < c.MinTotalDepth || alt == 0:
+		return vcf.Vcf{}, false
+	case c.MinStrandedDepth == 0:
+		if float64(alt) < c.MinAf*float64(total) {
+			return vcf.Vcf{}, false
+		}
+		strandedness = unStranded
+	default:
+		for i := range depth {
+			if depth[i] < c.MinStrandedDepth || support[i] == 0 || float64(support[i]) < c.MinAf*float64(depth[i]) {
+				return vcf.Vcf{}, false
+			}
+		}
+	}
+
+	alt1 := "." + t
+	if s.right {
+		alt1 = t + "."
+	}
+	var best string
+	for mate, n := range mates {
+		if n*2 > alt && (best == "" || mate < best) {
+			best = mate
+		}
+	}
+	if best != "" {
+		alt1 = best
+	}
+
+	var v vcf.Vcf
+	v.Chr = b.Chrom
+	v.Pos = s.pos
+	v.Id = "."
+	v.Ref = t
+	v.Alt = []string{alt1}
+	v.Qual = phred(c.breakendArtifactProb(depth[0], support[0]) * c.breakendArtifactProb(depth[1], support[1]))
+	v.Filter = "."
+	v.Info = strandedness.String() + ";" + SvTypeInfo + "=BND"
+	v.Format = []string{"GT", "DP", "PS", "MS", "RF"}
+	v.Samples = []vcf.Sample{{Alleles: []int16{1}, FormatData: []string{"", fmt.Sprint(total), fmt.Sprint(support[0]), fmt.Sprint(support[1]), b.Name}}}
+	return v, true
+}
+
+// mateAlt returns the ALT of a breakend at s, with reference base t, joined to the mate
+// breakend given by the supplementary alignment m.
+func mateAlt(s breakendSite, m supplementary, t string) string {
+	switch {
+	case s.right && m.sameStrand: // the clipped bases continue to the right of the mate
+		return fmt.Sprintf("%s[%s:%d[", t, m.chr, m.start)
+	case s.right:
+		return fmt.Sprintf("%s]%s:%d]", t, m.chr, m.end)
+	case m.sameStrand: // the clipped bases end at the mate
+		return fmt.Sprintf("]%s:%d]%s", m.chr, m.end, t)
+	default:
+		return fmt.Sprintf("[%s:%d[%s", m.chr, m.start, t)
+	}
+}
+
+// breakendArtifactProb returns the probability that k clipped reads of the n reads of a
+// strand covering a breakend are an artifact, as strandArtifactProb does for indels.
+func (c *Caller) breakendArtifactProb(n, k int) float64 {
+	if k == 0 {
+		return 1
+	}
+	return c.StrandErrorRate + (1-c.StrandErrorRate)*binomialTail(n, k, math.Pow(10, -float64(c.MinBaseQuality)/10))
+}
+
+// AddBreakendHeader adds the INFO line of the type of BND records to h.
+func AddBreakendHeader(h vcf.Header) vcf.Header {
+	line := fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=String,Description=\"Type of structural variant, BND for a breakend where the reads of a read family are clipped\">", SvTypeInfo)
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), line), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, line)
+	return h
+}
+
+// IsBreakend reports whether v is a BND record returned with MinBreakendClip.
+func IsBreakend(v vcf.Vcf) bool {
+	return strings.Contains(v.Info, SvTypeInfo+"=BND")
+}
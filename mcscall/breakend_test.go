@@ -0,0 +1,56 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/sam"
+	"strings"
+	"testing"
+)
+
+func TestCallBreakends(t *testing.T) {
+	c := &Caller{Options: DefaultOptions()}
+	c.MinBreakendClip = 20
+	c.ref = testRef(t, strings.Repeat("ACGTTGCA", 10))
+	add := func(name string, strand byte, pos uint32, cig, extra string) {
+		c.breaks.add(&sam.Sam{QName: name, Pos: pos, Cigar: cigar.FromString(cig), Extra: extra}, strand)
+	}
+	c.breaks.reset()
+	for i := 0; i < 4; i++ {
+		for _, strand := range []byte{'W', 'C'} {
+			name := fmt.Sprintf("%c%d", strand, i)
+			// split reads joined after 30 to the start of a forward alignment at chr2:500
+			add(name, strand, 11, "20M30S", "SA:Z:chr2,500,+,20S30M,60,0;")
+			// clipped before 41 with no supplementary alignment
+			add(name+"b", strand, 41, "30S20M", "")
+			// reads covering 49, of which only one is clipped there
+			cig := "20M"
+			if name == "W0" {
+				cig = "5M45S"
+			}
+			add(name+"c", strand, 45, cig, "")
+		}
+	}
+
+	variants := c.callBreakends(bed.Bed{Chrom: "chr1", Name: "fam"})
+	if len(variants) != 2 {
+		t.Fatalf("expected 2 breakends, got %d: %v", len(variants), variants)
+	}
+	for i, expected := range []struct {
+		pos      int
+		ref, alt string
+	}{{30, "G", "G[chr2:500["}, {41, "A", ".A"}} {
+		v := variants[i]
+		if v.Pos != expected.pos || v.Ref != expected.ref || v.Alt[0] != expected.alt || !IsBreakend(v) {
+			t.Errorf("breakend %d: expected %d %s %s, got %d %s %s", i, expected.pos, expected.ref, expected.alt, v.Pos, v.Ref, v.Alt[0])
+		}
+		if ps, ms := v.Samples[0].FormatData[2], v.Samples[0].FormatData[3]; ps != "4" || ms != "4" {
+			t.Errorf("breakend %d: expected 4 supporting reads on each strand, got %s and %s", i, ps, ms)
+		}
+	}
+
+	if mate := parseSupplementary("chr3,100,-,10M5D20M30S,60,2;chr4,1,+,50M,60,0", false); mate.chr != "chr3" || mate.start != 100 || mate.end != 134 || mate.sameStrand {
+		t.Errorf("wrong supplementary alignment: %+v", mate)
+	}
+}
@@ -0,0 +1,549 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"golang.org/x/exp/slices"
+	"strings"
+)
+
+// CallPiles matches watson and crick piles by position and calls each pair with
+// CallPilePair. Both slices must be sorted by position. In unstranded mode, piles
+// covered by only one strand are also called. No variants are returned if the
+// family has more than MaxVariantsPerReadFamily calls, or with EmitFiltered they are
+// returned with the max_variants FILTER.
+func (c *Caller) CallPiles(watsonPiles, crickPiles []sam.Pile, b bed.Bed) []vcf.Vcf {
+	var variants []vcf.Vcf
+	var v vcf.Vcf
+	var keepVariant, keepSite bool
+	var watsonPileIdx, crickPileIdx int
+	c.calledSites = c.calledSites[:0] // empty slice
+	c.sites = c.sites[:0]
+	if cap(c.calledSites) < b.ChromEnd-b.ChromStart {
+		c.calledSites = make([]uint32, 0, b.ChromEnd-b.ChromStart)
+	}
+
+	for { // pos matching between slices of watson and crick piles
+		if watsonPileIdx == len(watsonPiles) || crickPileIdx == len(crickPiles) {
+			break
+		}
+		if crickPiles[crickPileIdx].Pos > watsonPiles[watsonPileIdx].Pos {
+			watsonPileIdx++
+			continue
+		}
+		if crickPiles[crickPileIdx].Pos < watsonPiles[watsonPileIdx].Pos {
+			crickPileIdx++
+			continue
+		}
+		v, keepVariant, keepSite = c.CallPilePair(watsonPiles[watsonPileIdx], crickPiles[crickPileIdx], b)
+		if keepSite {
+			c.addSite(watsonPiles[watsonPileIdx], crickPiles[crickPileIdx], keepVariant && v.Filter == ".")
+		}
+		if keepVariant {
+			variants = append(variants, v)
+		}
+
+		watsonPileIdx++
+		crickPileIdx++
+	}
+
+	// do not include single-stranded data if not running in unstranded mode
+	if !(c.MinStrandedDepth == 0 && (watsonPileIdx < len(watsonPiles) || crickPileIdx < len(crickPiles))) {
+		return c.finishFamily(variants, b)
+	}
+
+	// unstranded mode only below
+	var emptyPile sam.Pile
+	for watsonPileIdx < len(watsonPiles) {
+		emptyPile.Pos = watsonPiles[watsonPileIdx].Pos
+		emptyPile.RefIdx = watsonPiles[watsonPileIdx].RefIdx
+		v, keepVariant, keepSite = c.CallPilePair(watsonPiles[watsonPileIdx], emptyPile, b)
+		if keepSite {
+			c.addSite(watsonPiles[watsonPileIdx], emptyPile, keepVariant && v.Filter == ".")
+		}
+		if keepVariant {
+			variants = append(variants, v)
+		}
+		watsonPileIdx++
+	}
+	for crickPileIdx < len(crickPiles) {
+		emptyPile.Pos = crickPiles[crickPileIdx].Pos
+		emptyPile.RefIdx = crickPiles[crickPileIdx].RefIdx
+		v, keepVariant, keepSite = c.CallPilePair(emptyPile, crickPiles[crickPileIdx], b)
+		if keepSite {
+			c.addSite(emptyPile, crickPiles[crickPileIdx], keepVariant && v.Filter == ".")
+		}
+		if keepVariant {
+			variants = append(variants, v)
+		}
+		crickPileIdx++
+	}
+
+	return c.finishFamily(variants, b)
+}
+
+// finishFamily applies MaxVariantsPerReadFamily to the calls of family b, sends its
+// called sites, and adds its reference blocks. Calls that failed another filter do not
+// count towards the limit.
+func (c *Caller) finishFamily(variants []vcf.Vcf, b bed.Bed) []vcf.Vcf {
+	var passing int
+	for i := range variants {
+		if variants[i].Filter == "." {
+			passing++
+		}
+	}
+	if passing > c.MaxVariantsPerReadFamily {
+		c.reject(MaxVariantsFilter)
+		if !c.EmitFiltered {
+			return nil
+		}
+		for i := range variants {
+			variants[i].Filter = popaf.AppendFilter(variants[i].Filter, MaxVariantsFilter.String())
+		}
+	}
+	sendCalledSites(b, c.calledSites, c.CalledSites)
+	return c.addRefBlocks(variants, b)
+}
+
+// CallPilePair calls a variant from the watson and crick piles at a single position
+// of read family b. keepVariant reports whether v is a call and keepSite reports
+// whether the position had enough depth to be evaluated. With EmitFiltered, candidates
+// that fail the strand, allele fraction, or depth filters are returned as calls with
+// the names of the failed filters in FILTER. Other alleles above SecondaryAf are listed
+// in the SA INFO field of a call.
+func (c *Caller) CallPilePair(wPile, cPile sam.Pile, b bed.Bed) (v vcf.Vcf, keepVariant bool, keepSite bool) {
+	defer func() {
+		if keepVariant {
+			c.addSecondaryAlleles(&v, wPile, cPile)
+		}
+	}()
+	var watsonDelLen, crickDelLen int
+	var watsonInsSeq, crickInsSeq, chr string
+	var maxWatsonBase, maxCrickBase dna.Base
+	var refBase dna.Base
+	var watsonVarType, crickVarType variantType
+	var watsonAltAlleleCount, crickAltAlleleCount, watsonInsAlleleCount, crickInsAlleleCount int
+	var ans vcf.Vcf
+	var failed string // FILTER of a candidate kept with EmitFiltered
+
+	watsonDepth := pileDepth(wPile, c.BaseQualPenalty)
+	crickDepth := pileDepth(cPile, c.BaseQualPenalty)
+
+	if watsonDepth < float64(c.MinStrandedDepth) || crickDepth < float64(c.MinStrandedDepth) {
+		return ans, false, false
+	}
+
+	if c.Debug != nil {
+		c.Debug <- fmt.Sprintf("watson: %v, crick: %v", wPile, cPile)
+	}
+
+	// switch to unstranded calling mode if minStrandDepth == 0
+	if c.MinStrandedDepth == 0 {
+		return c.unstrandedCall(wPile, cPile, b, watsonDepth+crickDepth)
+	}
+
+	//fmt.Printf("evaluating pile %s:%d\nwatson:\t%v\ncrick:\t%v\n\n", c.header.Chroms[wPile.RefIdx].Name, wPile.Pos, wPile, cPile)
+
+	// matching ref position
+	watsonVarType, maxWatsonBase, watsonInsSeq, watsonDelLen, watsonAltAlleleCount, watsonInsAlleleCount = maxBase(wPile)
+	crickVarType, maxCrickBase, crickInsSeq, crickDelLen, crickAltAlleleCount, crickInsAlleleCount = maxBase(cPile)
+
+	// special case to bias towards insertions since they are assigned to the position before the insertion
+	if float64(watsonInsAlleleCount)/float64(watsonDepth) > c.minAf(insertion) || float64(crickInsAlleleCount)/float64(crickDepth) > c.minAf(insertion) {
+		watsonVarType = insertion
+		crickVarType = insertion
+		watsonAltAlleleCount = watsonInsAlleleCount
+		crickAltAlleleCount = crickInsAlleleCount
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("triggered insertion bias")
+			c.Debug <- fmt.Sprintf("WatsonAC:%d, WatsonDP:%f, CrickAC:%d, CrickDP:%f", watsonAltAlleleCount, watsonDepth, crickAltAlleleCount, crickDepth)
+		}
+	}
+
+	var shouldCallSingleStrand bool
+	if c.CallSingleStrand {
+		switch {
+		case watsonVarType != crickVarType:
+			shouldCallSingleStrand = true
+
+		case watsonVarType == snv && maxWatsonBase != maxCrickBase:
+			shouldCallSingleStrand = true
+
+		case watsonVarType == insertion && watsonInsSeq != crickInsSeq:
+			shouldCallSingleStrand = true
+
+		case watsonVarType == deletion && watsonDelLen != crickDelLen:
+			shouldCallSingleStrand = true
+
+		default:
+			shouldCallSingleStrand = false
+		}
+	}
+
+	if shouldCallSingleStrand {
+		return c.singleStrandCall(wPile, cPile, b, watsonVarType, crickVarType, maxWatsonBase, maxCrickBase, watsonInsSeq, crickInsSeq, watsonDelLen, crickDelLen, watsonAltAlleleCount, crickAltAlleleCount, watsonDepth, crickDepth)
+	}
+
+	// exclude if watson and crick do not agree.
+	if watsonVarType != crickVarType {
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("variant types do not match, moving on")
+		}
+		return c.strandMismatch(wPile, cPile, b, watsonVarType, crickVarType, maxWatsonBase, maxCrickBase, watsonInsSeq, crickInsSeq, watsonDelLen, crickDelLen)
+	}
+
+	// exclude if watson or crick AF is less than threshold.
+	watsonAf, crickAf := float64(watsonAltAlleleCount)/watsonDepth, float64(crickAltAlleleCount)/crickDepth
+	if c.ConsensusQuality && watsonVarType == snv {
+		watsonAf, crickAf = c.watsonCons.at(wPile.Pos).af(maxWatsonBase), c.crickCons.at(cPile.Pos).af(maxCrickBase)
+	}
+	if watsonAf < c.minAf(watsonVarType) || crickAf < c.minAf(watsonVarType) {
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("does not meet af requirements\nwatson: (%d/%f) = %f\ncrick: (%d/%f) = %f", watsonAltAlleleCount, watsonDepth, watsonAf, crickAltAlleleCount, crickDepth, crickAf)
+		}
+		if !c.fail(MinAfFilter, &failed) {
+			return ans, false, true
+		}
+	}
+
+	// exclude if below minimum read depth
+	if watsonAltAlleleCount < c.MinStrandedDepth || crickAltAlleleCount < c.MinStrandedDepth || watsonAltAlleleCount+crickAltAlleleCount < c.MinTotalDepth {
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("does not meet minimum read depth, moving on")
+		}
+		if !c.fail(MinDepthFilter, &failed) {
+			return ans, false, true
+		}
+	}
+
+	// variant-type specific filters and processing
+	chr = c.header.Chroms[wPile.RefIdx].Name
+	switch watsonVarType {
+	case snv:
+		if maxWatsonBase != maxCrickBase {
+			if c.Debug != nil {
+				c.Debug <- fmt.Sprintf("variant bases do not match, moving on\nwatson: %s\ncrick: %s", dna.BaseToString(maxWatsonBase), dna.BaseToString(maxCrickBase))
+			}
+			return c.strandMismatch(wPile, cPile, b, watsonVarType, crickVarType, maxWatsonBase, maxCrickBase, watsonInsSeq, crickInsSeq, watsonDelLen, crickDelLen)
+		}
+
+		refBase = c.refBase(chr, int(wPile.Pos))
+
+		if maxWatsonBase == refBase {
+			if c.Debug != nil {
+				c.Debug <- fmt.Sprintf("alt base matches ref")
+			}
+			return ans, false, true
+		}
+		ans = snvToVcf(wPile, cPile, chr, refBase, maxWatsonBase, b.Name, doubleStranded, false)
+
+	case insertion:
+		if watsonInsSeq != crickInsSeq {
+			if c.Debug != nil {
+				c.Debug <- fmt.Sprintf("different insertion lengths")
+			}
+			return c.strandMismatch(wPile, cPile, b, watsonVarType, crickVarType, maxWatsonBase, maxCrickBase, watsonInsSeq, crickInsSeq, watsonDelLen, crickDelLen)
+		}
+		if strings.Contains(watsonInsSeq, "N") {
+			if c.Debug != nil {
+				c.Debug <- fmt.Sprintf("insertion seq contains Ns")
+			}
+			return ans, false, true
+		}
+		ans = insToVcf(wPile, cPile, chr, watsonInsSeq, c.ref, b.Name, doubleStranded, false)
+
+	case deletion:
+		if watsonDelLen != crickDelLen {
+			if c.Debug != nil {
+				c.Debug <- fmt.Sprintf("different deletion lengths")
+			}
+			return c.strandMismatch(wPile, cPile, b, watsonVarType, crickVarType, maxWatsonBase, maxCrickBase, watsonInsSeq, crickInsSeq, watsonDelLen, crickDelLen)
+		}
+		ans = delToVcf(wPile, cPile, chr, watsonDelLen, c.ref, b.Name, doubleStranded, false)
+
+	default:
+		return ans, false, true
+	}
+
+	// both strands must be wrong for a duplex call to be an artifact
+	ans.Qual = phred(c.strandArtifactProb(wPile, c.watsonCons.at(wPile.Pos), ans) * c.strandArtifactProb(cPile, c.crickCons.at(cPile.Pos), ans))
+	if !c.checkOrientation(&ans, wPile, cPile, &failed) {
+		return ans, false, true
+	}
+	if failed != "" {
+		markFailed(&ans, failed, wPile, cPile)
+	}
+	return ans, true, true
+}
+
+func (c *Caller) unstrandedCall(wPile, cPile sam.Pile, b bed.Bed, mergeDepth float64) (v vcf.Vcf, keepVariant bool, keepSite bool) {
+	var mergeDelLen int
+	var mergeInsSeq, chr string
+	var maxMergeBase dna.Base
+	var refBase dna.Base
+	var mergeVarType variantType
+	var mergeAltAlleleCount, mergeInsAlleleCount int
+	var ans vcf.Vcf
+	var failed string
+
+	mergePile := sumPiles(wPile, cPile)
+
+	mergeVarType, maxMergeBase, mergeInsSeq, mergeDelLen, mergeAltAlleleCount, mergeInsAlleleCount = maxBase(mergePile)
+
+	if float64(mergeInsAlleleCount)/float64(mergeDepth) > c.minAf(insertion) {
+		mergeVarType = insertion
+		mergeAltAlleleCount = mergeInsAlleleCount
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("triggered insertion bias")
+		}
+	}
+
+	// exclude if watson or crick AF is less than threshold.
+	mergeAf := float64(mergeAltAlleleCount) / float64(mergeDepth)
+	mergeCons := c.watsonCons.at(wPile.Pos).plus(c.crickCons.at(cPile.Pos))
+	if c.ConsensusQuality && mergeVarType == snv {
+		mergeAf = mergeCons.af(maxMergeBase)
+	}
+	if mergeAf < c.minAf(mergeVarType) {
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("does not meet af requirements\nmerge: (%d/%f) = %f\n", mergeAltAlleleCount, mergeDepth, mergeAf)
+		}
+		if !c.fail(MinAfFilter, &failed) {
+			return ans, false, true
+		}
+	}
+
+	// exclude if below minimum read depth
+	if mergeAltAlleleCount < c.MinStrandedDepth || mergeDepth < float64(c.MinTotalDepth) {
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("does not meet minimum read depth, moving on")
+		}
+		if !c.fail(MinDepthFilter, &failed) {
+			return ans, false, true
+		}
+	}
+
+	// variant-type specific filters and processing
+	chr = c.header.Chroms[wPile.RefIdx].Name
+	switch mergeVarType {
+	case snv:
+		refBase = c.refBase(chr, int(wPile.Pos))
+
+		if maxMergeBase == refBase {
+			if c.Debug != nil {
+				c.Debug <- fmt.Sprintf("alt base matches ref")
+			}
+			return ans, false, true
+		}
+		ans = snvToVcf(wPile, cPile, chr, refBase, maxMergeBase, b.Name, unStranded, false)
+
+	case insertion:
+		ans = insToVcf(wPile, cPile, chr, mergeInsSeq, c.ref, b.Name, unStranded, false)
+
+	case deletion:
+		ans = delToVcf(wPile, cPile, chr, mergeDelLen, c.ref, b.Name, unStranded, false)
+
+	default:
+		return ans, false, true
+	}
+
+	ans.Qual = phred(c.strandArtifactProb(mergePile, mergeCons, ans))
+	if !c.checkOrientation(&ans, wPile, cPile, &failed) {
+		return ans, false, true
+	}
+	if failed != "" {
+		markFailed(&ans, failed, wPile, cPile)
+	}
+	return ans, true, true
+}
+
+func (c *Caller) singleStrandCall(wPile, cPile sam.Pile, b bed.Bed, watsonVarType, crickVarType variantType, maxWatsonBase, maxCrickBase dna.Base, watsonInsSeq, crickInsSeq string, watsonDelLen, crickDelLen, watsonAltAlleleCount, crickAltAlleleCount int, watsonDepth, crickDepth float64) (v vcf.Vcf, keepVariant bool, keepSite bool) {
+	var ans vcf.Vcf
+	var failed string
+
+	// exclude if watson or crick AF is less than threshold.
+	if float64(watsonAltAlleleCount)/float64(watsonDepth) < 1 && float64(crickAltAlleleCount)/float64(crickDepth) < 1 {
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("does not meet single-stranded af requirements\nwatson: (%d/%f) = %f\ncrick: (%d/%f) = %f", watsonAltAlleleCount, watsonDepth, float64(watsonAltAlleleCount)/float64(watsonDepth), crickAltAlleleCount, crickDepth, float64(crickAltAlleleCount)/float64(crickDepth))
+		}
+		if !c.fail(MinAfFilter, &failed) {
+			return ans, false, true
+		}
+	}
+
+	// exclude if below minimum read depth
+	if watsonAltAlleleCount < c.MinStrandedDepth || crickAltAlleleCount < c.MinStrandedDepth || watsonDepth+crickDepth < float64(c.MinTotalDepth) {
+		if c.Debug != nil {
+			c.Debug <- fmt.Sprintf("does not meet minimum read depth, moving on")
+		}
+		if !c.fail(MinDepthFilter, &failed) {
+			return ans, false, true
+		}
+	}
+
+	ans, keepVariant = c.strandCall(wPile, cPile, b, watsonVarType, crickVarType, maxWatsonBase, maxCrickBase, watsonInsSeq, crickInsSeq, watsonDelLen, crickDelLen)
+	if keepVariant && !c.checkOrientation(&ans, wPile, cPile, &failed) {
+		return ans, false, true
+	}
+	if failed != "" {
+		markFailed(&ans, failed, wPile, cPile)
+	}
+	return ans, keepVariant, true
+}
+
+// strandMismatch counts a site where watson and crick support different alleles. With
+// EmitFiltered, the allele of one strand is returned as a single-stranded call with
+// the strand_mismatch FILTER.
+func (c *Caller) strandMismatch(wPile, cPile sam.Pile, b bed.Bed, watsonVarType, crickVarType variantType, maxWatsonBase, maxCrickBase dna.Base, watsonInsSeq, crickInsSeq string, watsonDelLen, crickDelLen int) (v vcf.Vcf, keepVariant bool, keepSite bool) {
+	c.reject(StrandMismatchFilter)
+	if !c.EmitFiltered {
+		return v, false, true
+	}
+	v, keepVariant = c.strandCall(wPile, cPile, b, watsonVarType, crickVarType, maxWatsonBase, maxCrickBase, watsonInsSeq, crickInsSeq, watsonDelLen, crickDelLen)
+	markFailed(&v, StrandMismatchFilter.String(), wPile, cPile)
+	return v, keepVariant, true
+}
+
+// strandCall returns the single-stranded call of the allele supported by one strand,
+// preferring indels to SNVs and the longer indel. No call is made if neither allele
+// differs from the reference, or if both strands have different SNVs.
+func (c *Caller) strandCall(wPile, cPile sam.Pile, b bed.Bed, watsonVarType, crickVarType variantType, maxWatsonBase, maxCrickBase dna.Base, watsonInsSeq, crickInsSeq string, watsonDelLen, crickDelLen int) (v vcf.Vcf, ok bool) {
+	var refBase dna.Base
+	var ans vcf.Vcf
+	var chr string
+
+	var prefVarType variantType
+	switch {
+	case watsonVarType == crickVarType:
+		prefVarType = watsonVarType
+	case watsonVarType == snv:
+		prefVarType = crickVarType
+	case crickVarType == snv:
+		prefVarType = watsonVarType
+	default:
+		prefVarType = watsonVarType
+	}
+
+	// variant-type specific filters and processing
+	chr = c.header.Chroms[wPile.RefIdx].Name
+	var chosenStrand bool
+	switch prefVarType {
+	case snv:
+		refBase = c.refBase(chr, int(wPile.Pos))
+		var altBase dna.Base
+		if maxWatsonBase == refBase {
+			altBase = maxCrickBase
+			chosenStrand = false
+		} else if maxCrickBase == refBase {
+			altBase = maxWatsonBase
+			chosenStrand = true
+		} else {
+			return ans, false
+		}
+		ans = snvToVcf(wPile, cPile, chr, refBase, altBase, b.Name, singleStranded, chosenStrand)
+
+	case insertion:
+		var prefInsSeq string
+		if len(watsonInsSeq) > len(crickInsSeq) {
+			prefInsSeq = watsonInsSeq
+			chosenStrand = true
+		} else {
+			prefInsSeq = crickInsSeq
+			chosenStrand = false
+		}
+		if strings.Contains(prefInsSeq, "N") {
+			if c.Debug != nil {
+				c.Debug <- fmt.Sprintf("insertion seq contains Ns")
+			}
+			return ans, false
+		}
+		ans = insToVcf(wPile, cPile, chr, prefInsSeq, c.ref, b.Name, singleStranded, chosenStrand)
+
+	case deletion:
+		var prefDelLen int
+		if watsonDelLen > crickDelLen {
+			prefDelLen = watsonDelLen
+			chosenStrand = true
+		} else {
+			prefDelLen = crickDelLen
+			chosenStrand = false
+		}
+		ans = delToVcf(wPile, cPile, chr, prefDelLen, c.ref, b.Name, singleStranded, chosenStrand)
+
+	default:
+		return ans, false
+	}
+
+	// only the strand with the alt allele supports the call
+	if chosenStrand {
+		ans.Qual = phred(c.strandArtifactProb(wPile, c.watsonCons.at(wPile.Pos), ans))
+	} else {
+		ans.Qual = phred(c.strandArtifactProb(cPile, c.crickCons.at(cPile.Pos), ans))
+	}
+	return ans, true
+}
+
+// markFailed sets the FILTER of a candidate that failed the filters named in failed and
+// adds the depth of each strand to its INFO field, as PS and MS only count alt reads.
+func markFailed(v *vcf.Vcf, failed string, wPile, cPile sam.Pile) {
+	v.Filter = failed
+	v.Info += fmt.Sprintf(";WDP=%d;CDP=%d", calcDepth(wPile), calcDepth(cPile))
+}
+
+// minAf returns the minimum alt allele fraction of a call of type tp.
+func (c *Caller) minAf(tp variantType) float64 {
+	var af float64
+	switch tp {
+	case snv:
+		af = c.MinAfSnv
+	case insertion:
+		af = c.MinAfIns
+	case deletion:
+		af = c.MinAfDel
+	}
+	if af > 0 {
+		return af
+	}
+	return c.MinAf
+}
+
+// fail counts a rejection by filter f and reports whether the candidate is kept, which
+// it is with EmitFiltered. The name of f is then added to failed, the candidate's FILTER.
+func (c *Caller) fail(f Filter, failed *string) bool {
+	c.reject(f)
+	if !c.EmitFiltered {
+		return false
+	}
+	*failed = popaf.AppendFilter(*failed, f.String())
+	return true
+}
+
+func sendCalledSites(orig bed.Bed, sites []uint32, out chan<- bed.Bed) {
+	if len(sites) == 0 || out == nil {
+		return
+	}
+	slices.Sort(sites)
+	var curr bed.Bed = orig
+	var prevPos uint32
+	for i := range sites {
+		if prevPos == 0 { // first entry, start bed
+			curr.ChromStart = int(sites[i]) - 1 // bed is 0-base sam is 1-base
+			prevPos = sites[i]
+			continue
+		}
+		if sites[i] > prevPos+1 { // discontiguous, output curr
+			curr.ChromEnd = int(prevPos)
+			out <- curr
+			curr.ChromStart = int(sites[i]) - 1
+			prevPos = sites[i]
+			continue
+		}
+		prevPos = sites[i]
+	}
+	curr.ChromEnd = int(prevPos)
+	out <- curr
+}
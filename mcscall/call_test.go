@@ -0,0 +1,53 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestFinishFamily(t *testing.T) {
+	b := bed.Bed{Chrom: "chr1", ChromStart: 0, ChromEnd: 100, Name: "1"}
+	calls := func() []vcf.Vcf {
+		return []vcf.Vcf{{Pos: 10, Filter: "."}, {Pos: 20, Filter: "min_af"}, {Pos: 30, Filter: "."}}
+	}
+	c := &Caller{Options: DefaultOptions(), Stats: new(Stats)}
+	c.MaxVariantsPerReadFamily = 1
+	if v := c.finishFamily(calls(), b); v != nil {
+		t.Errorf("expected family with 2 passing calls to be removed, got %v", v)
+	}
+
+	c.EmitFiltered = true
+	v := c.finishFamily(calls(), b)
+	expected := []string{"max_variants", "min_af;max_variants", "max_variants"}
+	if len(v) != len(expected) {
+		t.Fatalf("expected %d calls, got %d", len(expected), len(v))
+	}
+	for i := range v {
+		if v[i].Filter != expected[i] {
+			t.Errorf("call %d: expected FILTER %s, got %s", i, expected[i], v[i].Filter)
+		}
+	}
+
+	// filtered calls do not count towards the limit
+	c.MaxVariantsPerReadFamily = 2
+	if v = c.finishFamily(calls(), b); v[0].Filter != "." || v[1].Filter != "min_af" {
+		t.Errorf("unexpected filters %s %s", v[0].Filter, v[1].Filter)
+	}
+	if n := c.Stats.Rejected[MaxVariantsFilter].Value(); n != 2 {
+		t.Errorf("expected 2 max_variants rejections, got %d", n)
+	}
+}
+
+func TestMinAf(t *testing.T) {
+	c := &Caller{Options: DefaultOptions()}
+	c.MinAfIns, c.MinAfDel = 0.6, 0.7
+	for tp, expected := range map[variantType]float64{snv: 0.9, insertion: 0.6, deletion: 0.7, none: 0.9} {
+		if af := c.minAf(tp); af != expected {
+			t.Errorf("type %d: expected %g, got %g", tp, expected, af)
+		}
+	}
+	if s := minAfDescription(c.Options); s != "0.9 for SNVs, 0.6 for insertions, and 0.7 for deletions" {
+		t.Errorf("unexpected description %s", s)
+	}
+}
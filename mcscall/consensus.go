@@ -0,0 +1,131 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"math"
+)
+
+// minConsensusInputQual is the lowest base quality read with ConsensusQuality. Illumina
+// marks the unusable ends of reads with Q2, so those bases are still N-masked.
+const minConsensusInputQual = 3
+
+// consensus holds the base calls of the reads of one strand of a family at each
+// position, weighed by their base qualities, as in the single strand consensus of a
+// duplex consensus caller.
+type consensus struct {
+	start uint32 // position of sites[0], 1-based as in sam.Pile
+	sites []consensusSite
+}
+
+// consensusSite is the evidence of the reads of a strand, or of both strands, at a
+// position. The zero value is a position without reads.
+type consensusSite struct {
+	logLik [4]float64 // log likelihood of the reads if the true base is A, C, G, or T
+	weight [4]float64 // reads with each base, each counted as its probability of being right
+}
+
+// build fills c with the bases of reads in family b. Unless countOverlappingPairs is
+// set, the overlap of a read pair is counted once, as in Pileup.
+func (c *consensus) build(reads []sam.Sam, b bed.Bed, countOverlappingPairs bool) {
+	c.start = uint32(b.ChromStart) + 1
+	c.sites = c.sites[:0]
+	for i := 0; i < b.ChromEnd-b.ChromStart; i++ {
+		c.sites = append(c.sites, consensusSite{})
+	}
+	if !countOverlappingPairs {
+		reads = mergeOverlappingMates(reads)
+	}
+	for i := range reads {
+		c.add(&reads[i])
+	}
+}
+
+// add adds the aligned bases of r.
+func (c *consensus) add(r *sam.Sam) {
+	if len(r.Cigar) == 0 || r.Cigar[0].Op == '*' || len(r.Qual) != len(r.Seq) {
+		return
+	}
+	pos, q := r.Pos, 0
+	for _, op := range r.Cigar {
+		switch op.Op {
+		case 'M', '=', 'X':
+			for k := 0; k < op.RunLength; k++ {
+				if pos >= c.start && int(pos-c.start) < len(c.sites) {
+					c.sites[pos-c.start].add(r.Seq[q+k], r.Qual[q+k]-33)
+				}
+				pos++
+			}
+			q += op.RunLength
+		case 'I', 'S':
+			q += op.RunLength
+		case 'D', 'N':
+			pos += uint32(op.RunLength)
+		}
+	}
+}
+
+// at returns the evidence at pos, which is empty outside of the family.
+func (c *consensus) at(pos uint32) consensusSite {
+	if pos < c.start || int(pos-c.start) >= len(c.sites) {
+		return consensusSite{}
+	}
+	return c.sites[pos-c.start]
+}
+
+// add adds a read with base b of quality qual. N-masked bases are not counted.
+func (s *consensusSite) add(b dna.Base, qual uint8) {
+	if b > dna.T {
+		return
+	}
+	e := math.Min(math.Pow(10, -float64(qual)/10), 0.75) // 0.75 is a random base
+	for i := range s.logLik {
+		if dna.Base(i) == b {
+			s.logLik[i] += math.Log1p(-e)
+		} else {
+			s.logLik[i] += math.Log(e / 3)
+		}
+	}
+	s.weight[b] += 1 - e
+}
+
+// plus returns the evidence of the reads of s and t together.
+func (s consensusSite) plus(t consensusSite) consensusSite {
+	for i := range s.logLik {
+		s.logLik[i] += t.logLik[i]
+		s.weight[i] += t.weight[i]
+	}
+	return s
+}
+
+// errorProb returns the probability that the true base is not b, given the reads and
+// an equal prior on the four bases. It is 1 without reads.
+func (s consensusSite) errorProb(b dna.Base) float64 {
+	if b > dna.T || s.weight == [4]float64{} {
+		return 1
+	}
+	var other float64 // likelihood of the other bases relative to b
+	for i := range s.logLik {
+		if dna.Base(i) != b {
+			other += math.Exp(s.logLik[i] - s.logLik[b])
+		}
+	}
+	return other / (1 + other)
+}
+
+// af returns the fraction of reads with base b, each counted as its probability of
+// being right.
+func (s consensusSite) af(b dna.Base) float64 {
+	if b > dna.T {
+		return 0
+	}
+	var total float64
+	for i := range s.weight {
+		total += s.weight[i]
+	}
+	if total == 0 {
+		return 0
+	}
+	return s.weight[b] / total
+}
@@ -0,0 +1,39 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"testing"
+)
+
+func TestConsensus(t *testing.T) {
+	var c consensus
+	reads := []sam.Sam{testRead("a", 11, "2M1D2M", "ACGT"), testRead("b", 11, "2M1D2M", "ACGT"), testRead("c", 11, "2M1D2M", "ATGT")}
+	reads[0].Qual, reads[1].Qual, reads[2].Qual = "IIII", "I+II", "I+II"
+	c.build(reads, bed.Bed{Chrom: "chr1", ChromStart: 10, ChromEnd: 20}, false)
+
+	// position 12 has two C reads, one at Q40 and one at Q10, and a T read at Q10
+	site := c.at(12)
+	if af := site.af(dna.C); af < 0.67 || af > 0.68 {
+		t.Errorf("expected the Q10 T read to count for 0.9 of a read, got AF %v", af)
+	}
+	if e := site.errorProb(dna.C); e > 1e-4 {
+		t.Errorf("expected the Q40 read to outweigh the Q10 reads, got error %v", e)
+	}
+	if e := site.errorProb(dna.T); e < 0.99 {
+		t.Errorf("expected T to be unlikely, got error %v", e)
+	}
+	if e := c.at(13).errorProb(dna.G); e != 1 {
+		t.Errorf("expected no evidence at a deleted position, got error %v", e)
+	}
+	if af := c.at(14).af(dna.G); af != 1 {
+		t.Errorf("expected the bases after the deletion at 14, got AF %v", af)
+	}
+
+	// the reads of both strands together, as in unstranded calling
+	merged := c.at(12).plus(c.at(12))
+	if merged.errorProb(dna.C) >= site.errorProb(dna.C) {
+		t.Error("expected more reads to give a lower error")
+	}
+}
@@ -0,0 +1,118 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strings"
+)
+
+// FILTERs of SNVs with a DNA damage signature when Options.TagDamage is set.
+const (
+	OxoGFilterName        = "OxoG"
+	DeaminationFilterName = "Deamination"
+)
+
+// isOxoG reports whether v is a G>T SNV on either strand of the reference, the
+// substitution left by 8-oxoguanine.
+func isOxoG(v vcf.Vcf) bool {
+	ref, alt := strings.ToUpper(v.Ref), strings.ToUpper(v.Alt[0])
+	return (ref == "G" && alt == "T") || (ref == "C" && alt == "A")
+}
+
+// isDeamination reports whether v is a C>T SNV on either strand of the reference, the
+// substitution left by cytosine deamination.
+func isDeamination(v vcf.Vcf) bool {
+	ref, alt := strings.ToUpper(v.Ref), strings.ToUpper(v.Alt[0])
+	return (ref == "C" && alt == "T") || (ref == "G" && alt == "A")
+}
+
+// readOrientationCounts returns the reads of a strand that carry SNV v and the other
+// reads covering it, each split into first (F) and second (R) reads of their pair as
+// in Pileup. N-masked bases are not counted.
+func readOrientationCounts(reads []sam.Sam, v vcf.Vcf) (altF, altR, otherF, otherR int) {
+	alt := dna.StringToBase(strings.ToUpper(v.Alt[0]))
+	for i := range reads {
+		base, ok := baseAtPos(reads[i], v.Pos)
+		if !ok || base > dna.T {
+			continue
+		}
+		forward := !sam.IsPaired(reads[i]) || sam.IsForwardRead(reads[i])
+		switch {
+		case base == alt && forward:
+			altF++
+		case base == alt:
+			altR++
+		case forward:
+			otherF++
+		default:
+			otherR++
+		}
+	}
+	return
+}
+
+// checkDamage drops, or with TagDamage filters, SNVs with a DNA damage signature: G>T
+// with an orientation bias p-value below MinOxoGP on either strand, and C>T whose alt
+// reads have a median distance below MinDeaminationEndDist from the nearer read end.
+// Calls must be at the position of their pile, before normalizeIndels.
+func (c *Caller) checkDamage(variants []vcf.Vcf, watsonReads, crickReads []sam.Sam) []vcf.Vcf {
+	if c.MinOxoGP == 0 && c.MinDeaminationEndDist == 0 {
+		return variants
+	}
+	var dists []int
+	kept := variants[:0]
+	for _, v := range variants {
+		if IsRefBlock(v) || len(v.Ref) != 1 || len(v.Alt[0]) != 1 {
+			kept = append(kept, v)
+			continue
+		}
+		var f Filter
+		var name string
+		switch {
+		case c.MinOxoGP > 0 && isOxoG(v):
+			watson := fisherExact(readOrientationCounts(watsonReads, v))
+			crick := fisherExact(readOrientationCounts(crickReads, v))
+			if watson < c.MinOxoGP || crick < c.MinOxoGP {
+				f, name = OxoGFilter, OxoGFilterName
+			}
+		case c.MinDeaminationEndDist > 0 && isDeamination(v):
+			var median int
+			median, dists = endDistance(v, dists[:0], watsonReads, crickReads)
+			if len(dists) > 0 && median < c.MinDeaminationEndDist {
+				f, name = DeaminationFilter, DeaminationFilterName
+			}
+		}
+		if name != "" {
+			c.reject(f)
+			if !c.TagDamage {
+				continue
+			}
+			v.Filter = popaf.AppendFilter(v.Filter, name)
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
+
+// AddDamageHeader adds the FILTER lines of the damage signatures set in opts to h, for
+// calls kept with Options.TagDamage.
+func AddDamageHeader(h vcf.Header, opts Options) vcf.Header {
+	var lines []string
+	if opts.MinOxoGP > 0 {
+		lines = append(lines, fmt.Sprintf("##FILTER=<ID=%s,Description=\"G>T or C>A SNV with a read orientation bias p-value below %g on a strand (8-oxoG)\">", OxoGFilterName, opts.MinOxoGP))
+	}
+	if opts.MinDeaminationEndDist > 0 {
+		lines = append(lines, fmt.Sprintf("##FILTER=<ID=%s,Description=\"C>T or G>A SNV with a median distance below %d bases from the nearer read end (deamination)\">", DeaminationFilterName, opts.MinDeaminationEndDist))
+	}
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##INFO") || strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), lines...), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, lines...)
+	return h
+}
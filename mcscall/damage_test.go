@@ -0,0 +1,41 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestCheckDamage(t *testing.T) {
+	var watson, crick []sam.Sam
+	for i := 0; i < 6; i++ {
+		watson = append(watson, testRead("", 11, "10M", "AAAAATAAAA"), testRead("", 11, "10M", "AAAAAAAAAA")) // T at 16 only in first reads
+		crick = append(crick, testRead("", 11, "10M", "AAAAATAAAT"), testRead("", 11, "10M", "AAAAATAAAT"))   // T at 16 in both, T at 20 at the end
+	}
+	for _, reads := range [][]sam.Sam{watson, crick} {
+		for i := range reads {
+			reads[i].Flag = 1 | 64 // first and second reads alternate
+			if i%2 == 1 {
+				reads[i].Flag = 1 | 128
+			}
+		}
+	}
+	variants := []vcf.Vcf{
+		{Pos: 16, Ref: "G", Alt: []string{"T"}},
+		{Pos: 16, Ref: "C", Alt: []string{"T"}},
+		{Pos: 20, Ref: "C", Alt: []string{"T"}},
+		{Pos: 20, Ref: "A", Alt: []string{"T"}}, // not a damage signature
+	}
+	c := Caller{Options: Options{MinOxoGP: 0.01, MinDeaminationEndDist: 2, TagDamage: true}}
+	kept := c.checkDamage(append([]vcf.Vcf{}, variants...), watson, crick)
+	for i, expected := range []string{OxoGFilterName, "", DeaminationFilterName, ""} {
+		if kept[i].Filter != expected {
+			t.Errorf("%d %s>%s: expected FILTER %q, got %q", kept[i].Pos, kept[i].Ref, kept[i].Alt[0], expected, kept[i].Filter)
+		}
+	}
+
+	c.TagDamage = false
+	if kept = c.checkDamage(append([]vcf.Vcf{}, variants...), watson, crick); len(kept) != 2 {
+		t.Errorf("expected the damaged SNVs to be dropped, got %d calls", len(kept))
+	}
+}
@@ -0,0 +1,38 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"hash/fnv"
+	"sort"
+	"strconv"
+)
+
+// downsample returns at most max of the reads of a strand of family famId, in the order
+// of their positions. Reads are ranked by a hash of seed, the family, and the read name,
+// so mates are kept or dropped together and the same reads are kept whichever worker
+// calls the family. The reads are reordered in place.
+func downsample(reads []sam.Sam, max int, famId string, seed uint64) []sam.Sam {
+	if max <= 0 || len(reads) <= max {
+		return reads
+	}
+	prefix := strconv.FormatUint(seed, 10) + ":" + famId + ":"
+	ranks := make(map[string]uint64, len(reads))
+	for i := range reads {
+		if _, found := ranks[reads[i].QName]; found {
+			continue
+		}
+		h := fnv.New64a()
+		_, err := h.Write([]byte(prefix + reads[i].QName))
+		exception.PanicOnErr(err)
+		ranks[reads[i].QName] = h.Sum64()
+	}
+	sort.SliceStable(reads, func(i, j int) bool {
+		return ranks[reads[i].QName] < ranks[reads[j].QName]
+	})
+	reads = reads[:max]
+	sort.SliceStable(reads, func(i, j int) bool {
+		return reads[i].Pos < reads[j].Pos
+	})
+	return reads
+}
@@ -0,0 +1,41 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/sam"
+	"testing"
+)
+
+func TestDownsample(t *testing.T) {
+	reads := func() []sam.Sam {
+		var ans []sam.Sam
+		for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+			ans = append(ans, sam.Sam{QName: name, Pos: uint32(len(ans) + 1)}, sam.Sam{QName: name, Pos: uint32(len(ans) + 100)})
+		}
+		return ans
+	}
+	if kept := downsample(reads(), 20, "1", 1); len(kept) != 12 {
+		t.Errorf("expected a family under the limit to be kept whole, got %d reads", len(kept))
+	}
+	kept := downsample(reads(), 6, "1", 1)
+	if len(kept) != 6 {
+		t.Fatalf("expected 6 reads, got %d", len(kept))
+	}
+	mates := make(map[string]int)
+	for i := range kept {
+		mates[kept[i].QName]++
+		if i > 0 && kept[i].Pos < kept[i-1].Pos {
+			t.Errorf("reads are not sorted by position")
+		}
+	}
+	for name, n := range mates {
+		if n != 2 {
+			t.Errorf("expected both mates of %s to be kept, got %d", name, n)
+		}
+	}
+	again := downsample(reads(), 6, "1", 1)
+	for i := range kept {
+		if kept[i].QName != again[i].QName {
+			t.Errorf("expected the same reads to be kept with the same seed")
+		}
+	}
+}
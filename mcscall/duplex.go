@@ -0,0 +1,70 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/fasta"
+	"github.com/vertgenlab/gonomics/sam"
+)
+
+// duplexConsensus returns the duplex consensus of family b from the watson and crick
+// piles it was called from, named with the family ID, its coordinates, and the number
+// of positions where the strands disagree. Each position has the base carried by more
+// than half of the reads of both strands, and N where either strand has no such base
+// or they disagree. Positions deleted on both strands are left out, and insertions are
+// not shown, so the sequence follows the reference.
+func duplexConsensus(watsonPiles, crickPiles []sam.Pile, b bed.Bed) fasta.Fasta {
+	watson, crick := strandConsensus(watsonPiles, b), strandConsensus(crickPiles, b)
+	seq := make([]dna.Base, 0, len(watson))
+	var disagreements int
+	for i := range watson {
+		switch {
+		case watson[i] == dna.Gap && crick[i] == dna.Gap:
+			continue
+		case watson[i] == crick[i]:
+			seq = append(seq, watson[i])
+		case watson[i] == dna.N || crick[i] == dna.N:
+			seq = append(seq, dna.N)
+		default:
+			disagreements++
+			seq = append(seq, dna.N)
+		}
+	}
+	return fasta.Fasta{
+		Name: fmt.Sprintf("%s %s:%d-%d disagreements=%d", b.Name, b.Chrom, b.ChromStart+1, b.ChromEnd, disagreements),
+		Seq:  seq,
+	}
+}
+
+// strandConsensus returns the base, or dna.Gap for a deletion, carried by more than half
+// of the reads of piles at each position of b, and N where no base is. N-masked bases
+// are not counted.
+func strandConsensus(piles []sam.Pile, b bed.Bed) []dna.Base {
+	ans := make([]dna.Base, b.ChromEnd-b.ChromStart)
+	for i := range ans {
+		ans[i] = dna.N
+	}
+	for _, p := range piles {
+		i := int(p.Pos) - 1 - b.ChromStart
+		if i < 0 || i >= len(ans) {
+			continue
+		}
+		var total, most int
+		best := dna.N
+		for j := range p.CountF {
+			if dna.Base(j) == dna.N {
+				continue
+			}
+			n := p.CountF[j] + p.CountR[j]
+			total += n
+			if n > most {
+				best, most = dna.Base(j), n
+			}
+		}
+		if 2*most > total {
+			ans[i] = best
+		}
+	}
+	return ans
+}
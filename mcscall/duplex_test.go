@@ -0,0 +1,39 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"testing"
+)
+
+func TestDuplexConsensus(t *testing.T) {
+	pile := func(pos uint32, counts map[dna.Base]int) sam.Pile {
+		p := sam.Pile{Pos: pos}
+		for b, n := range counts {
+			p.CountF[b] = n
+		}
+		return p
+	}
+	watson := []sam.Pile{
+		pile(11, map[dna.Base]int{dna.A: 3}),
+		pile(12, map[dna.Base]int{dna.C: 3, dna.N: 5}), // masked bases are not counted
+		pile(13, map[dna.Base]int{dna.G: 2, dna.T: 2}), // no majority
+		pile(14, map[dna.Base]int{dna.Gap: 3}),
+		pile(15, map[dna.Base]int{dna.T: 3}),
+	}
+	crick := []sam.Pile{
+		pile(11, map[dna.Base]int{dna.A: 2, dna.C: 1}),
+		pile(12, map[dna.Base]int{dna.C: 3}),
+		pile(13, map[dna.Base]int{dna.G: 3}),
+		pile(14, map[dna.Base]int{dna.Gap: 3}),
+		pile(15, map[dna.Base]int{dna.A: 3}), // disagrees
+	}
+	f := duplexConsensus(watson, crick, bed.Bed{Chrom: "chr1", ChromStart: 10, ChromEnd: 16, Name: "7"})
+	if seq := dna.BasesToString(f.Seq); seq != "ACNNN" {
+		t.Errorf("expected ACNNN, got %s", seq)
+	}
+	if f.Name != "7 chr1:11-16 disagreements=1" {
+		t.Errorf("unexpected name %q", f.Name)
+	}
+}
@@ -0,0 +1,92 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strings"
+)
+
+// EvidenceTag is the tag listing the IDs of the calls carried by a read sent to
+// Caller.Evidence.
+const EvidenceTag = "VI"
+
+// carrier is a read of a family with the IDs of the calls it carries.
+type carrier struct {
+	read *sam.Sam
+	ids  []string
+}
+
+// findEvidence sets the ID of each call to the family name and the number of the call
+// in the family (e.g. 1234.1) and returns the reads that carry each call. Calls must be
+// at the position of their pile, before normalizeIndels.
+func findEvidence(variants []vcf.Vcf, family string, reads ...[]sam.Sam) []carrier {
+	var ans []carrier
+	idx := make(map[*sam.Sam]int)
+	for i := range variants {
+		if IsRefBlock(variants[i]) {
+			continue
+		}
+		variants[i].Id = fmt.Sprintf("%s.%d", family, i+1)
+		for _, strand := range reads {
+			for j := range strand {
+				if _, ok := altIndex(strand[j], variants[i]); !ok {
+					continue
+				}
+				k, found := idx[&strand[j]]
+				if !found {
+					k = len(ans)
+					idx[&strand[j]] = k
+					ans = append(ans, carrier{read: &strand[j]})
+				}
+				ans[k].ids = append(ans[k].ids, variants[i].Id)
+			}
+		}
+	}
+	return ans
+}
+
+// sendEvidence sends a copy of each carrier of a call still in variants to c.Evidence,
+// with the IDs of the calls it carries in an EvidenceTag tag.
+func (c *Caller) sendEvidence(variants []vcf.Vcf, carriers []carrier) {
+	called := make(map[string]bool, len(variants))
+	for i := range variants {
+		called[variants[i].Id] = true
+	}
+	var reads []sam.Sam
+	for _, cr := range carriers {
+		var ids []string
+		for _, id := range cr.ids {
+			if called[id] {
+				ids = append(ids, id)
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+		r := cr.read
+		tag := EvidenceTag + ":Z:" + strings.Join(ids, ",")
+		if r.Extra != "" {
+			tag += "\t" + r.Extra // RF stays last for barcode.GetRF
+		}
+		reads = append(reads, sam.Sam{
+			QName: r.QName,
+			Flag:  r.Flag,
+			MapQ:  r.MapQ,
+			RName: r.RName,
+			Pos:   r.Pos,
+			Cigar: append([]cigar.Cigar(nil), r.Cigar...),
+			RNext: r.RNext,
+			PNext: r.PNext,
+			TLen:  r.TLen,
+			Seq:   append([]dna.Base(nil), r.Seq...),
+			Qual:  r.Qual,
+			Extra: tag,
+		})
+	}
+	if len(reads) > 0 {
+		c.Evidence <- reads
+	}
+}
@@ -0,0 +1,34 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestEvidence(t *testing.T) {
+	watson := []sam.Sam{testRead("a", 10, "4M", "TACA", "RF:Z:7"), testRead("b", 10, "4M", "TAGA", "RF:Z:7")}
+	crick := []sam.Sam{testRead("c", 10, "4M", "AAGA", "RF:Z:7")}
+	variants := []vcf.Vcf{
+		{Id: ".", Pos: 10, Ref: "A", Alt: []string{"T"}},
+		{Id: ".", Pos: 12, Ref: "A", Alt: []string{"G"}},
+	}
+	carriers := findEvidence(variants, "7", watson, crick)
+	if variants[0].Id != "7.1" || variants[1].Id != "7.2" || len(carriers) != 3 {
+		t.Fatalf("unexpected IDs %s %s or carriers %v", variants[0].Id, variants[1].Id, carriers)
+	}
+
+	// reads are only sent for the calls that are kept
+	evidence := make(chan []sam.Sam, 1)
+	c := &Caller{Evidence: evidence}
+	c.sendEvidence(variants[1:], carriers)
+	reads := <-evidence
+	if len(reads) != 2 || reads[0].QName != "b" || reads[0].Extra != "VI:Z:7.2\tRF:Z:7" || reads[1].QName != "c" {
+		t.Errorf("unexpected evidence %v", reads)
+	}
+	reads[0].Seq[0] = dna.N
+	if watson[1].Seq[0] != dna.T {
+		t.Error("expected evidence to be a copy of the reads")
+	}
+}
@@ -0,0 +1,109 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strconv"
+	"strings"
+)
+
+// FamilyCountColumns is the number of columns of a family bed up to and including the
+// watson and crick read counts. Any further columns are extra annotations, which
+// commands pass through unchanged.
+const FamilyCountColumns = 8
+
+// FamilyColumn is an extra column of the family bed that is copied to the INFO field of
+// each variant called in the family.
+type FamilyColumn struct {
+	ID         string // INFO key
+	Annotation int    // index of the column in bed.Bed.Annotation
+}
+
+// FamilyColumns finds the columns of the family bed in bedFile given by names. A column
+// is named by its heading in the #chrom header line written by annotateReadFamilies, or
+// by its 1-based number, in which case the heading (or colN without one) is the ID.
+// Only columns after the watson and crick counts may be chosen.
+func FamilyColumns(bedFile string, names []string) ([]FamilyColumn, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	headings := familyHeadings(bedFile)
+	ans := make([]FamilyColumn, 0, len(names))
+	for _, name := range names {
+		col := -1
+		if n, err := strconv.Atoi(name); err == nil {
+			col = n - 1
+		} else {
+			for i := range headings {
+				if headings[i] == name {
+					col = i
+					break
+				}
+			}
+			if col == -1 {
+				return nil, fmt.Errorf("%s has no column named %s in its #chrom header line", bedFile, name)
+			}
+		}
+		if col < FamilyCountColumns {
+			return nil, fmt.Errorf("column %s of %s is not after the watson and crick counts (column %d)", name, bedFile, FamilyCountColumns)
+		}
+		c := FamilyColumn{ID: fmt.Sprintf("col%d", col+1), Annotation: col - 6}
+		if col < len(headings) {
+			c.ID = headings[col]
+		}
+		ans = append(ans, c)
+	}
+	return ans, nil
+}
+
+// familyHeadings returns the headings of the #chrom header line of bedFile, if any.
+func familyHeadings(bedFile string) []string {
+	file := fileio.EasyOpen(bedFile)
+	defer file.Close()
+	for line, done := fileio.EasyNextLine(file); !done && strings.HasPrefix(line, "#"); line, done = fileio.EasyNextLine(file) {
+		if strings.HasPrefix(line, "#chrom\t") {
+			return strings.Split(line[1:], "\t")
+		}
+	}
+	return nil
+}
+
+// AddFamilyInfoHeader adds an ##INFO line for each of cols to h, after its other ##INFO
+// lines.
+func AddFamilyInfoHeader(h vcf.Header, cols []FamilyColumn) vcf.Header {
+	var lines []string
+	for _, c := range cols {
+		lines = append(lines, fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=String,Description=\"Column %s of the read family bed\">", c.ID, c.ID))
+	}
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), lines...), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, lines...)
+	return h
+}
+
+// addFamilyInfo appends the cols of family b to the INFO field of each of variants.
+// Missing and "." values are left out.
+func addFamilyInfo(variants []vcf.Vcf, b bed.Bed, cols []FamilyColumn) {
+	var info strings.Builder
+	for _, c := range cols {
+		if c.Annotation >= len(b.Annotation) || b.Annotation[c.Annotation] == "" || b.Annotation[c.Annotation] == "." {
+			continue
+		}
+		fmt.Fprintf(&info, ";%s=%s", c.ID, infoEscaper.Replace(b.Annotation[c.Annotation]))
+	}
+	if info.Len() == 0 {
+		return
+	}
+	for i := range variants {
+		variants[i].Info += info.String()
+	}
+}
+
+// infoEscaper percent encodes the characters that may not appear in an INFO value.
+var infoEscaper = strings.NewReplacer("%", "%25", ";", "%3B", "=", "%3D", ",", "%2C", " ", "%20", "\t", "%09")
@@ -0,0 +1,41 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/vcf"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFamilyInfo(t *testing.T) {
+	bedFile := filepath.Join(t.TempDir(), "families.bed")
+	err := os.WriteFile(bedFile, []byte("#chrom\tstart\tend\tfamily\tscore\tstrand\twatson\tcrick\tRG\tCB\nchr1\t10\t200\tf1\t0\t+\t5\t6\tlib;1\t.\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cols, err := FamilyColumns(bedFile, []string{"RG", "10", "11"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cols) != 3 || cols[0] != (FamilyColumn{"RG", 2}) || cols[1] != (FamilyColumn{"CB", 3}) || cols[2] != (FamilyColumn{"col11", 4}) {
+		t.Errorf("wrong columns: %v", cols)
+	}
+	for _, bad := range []string{"UMI", "7"} {
+		if _, err = FamilyColumns(bedFile, []string{bad}); err == nil {
+			t.Errorf("expected an error for column %s", bad)
+		}
+	}
+
+	b := bed.Bed{Chrom: "chr1", Name: "f1", Annotation: []string{"5", "6", "lib;1", "."}}
+	v := []vcf.Vcf{{Info: "DS;Strand=+"}}
+	addFamilyInfo(v, b, cols)
+	if v[0].Info != "DS;Strand=+;RG=lib%3B1" {
+		t.Errorf("wrong INFO: %s", v[0].Info)
+	}
+
+	h := AddFamilyInfoHeader(vcf.Header{Text: []string{"##INFO=<ID=DS>", "##FORMAT=<ID=GT>", "#CHROM"}}, cols[:1])
+	if len(h.Text) != 4 || h.Text[1] != "##INFO=<ID=RG,Number=1,Type=String,Description=\"Column RG of the read family bed\">" {
+		t.Errorf("wrong header: %v", h.Text)
+	}
+}
@@ -0,0 +1,65 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strconv"
+)
+
+// WatsonSizeInfo and CrickSizeInfo are the INFO fields of the reads of each strand of the
+// family of a call. WatsonAfInfo and CrickAfInfo are those of the fraction of the reads
+// of each strand covering a call that carry its alt allele.
+const (
+	WatsonSizeInfo = "WFS"
+	CrickSizeInfo  = "CFS"
+	WatsonAfInfo   = "WAF"
+	CrickAfInfo    = "CAF"
+)
+
+// addFamilySize adds the reads of each strand of the family, watsonSize and crickSize,
+// and the fraction of the reads of each strand covering each call that carry it, to the
+// INFO field of each call. Unlike the AF filters, the fractions count N-masked bases as
+// not covering the call and do not weigh bases by quality. Calls must be at the position
+// of their pile, before normalizeIndels.
+func (c *Caller) addFamilySize(variants []vcf.Vcf, watsonSize, crickSize int, watsonReads, crickReads []sam.Sam) {
+	if len(variants) == 0 {
+		return
+	}
+	if !c.CountOverlappingPairs {
+		watsonReads, crickReads = mergeOverlappingMates(watsonReads), mergeOverlappingMates(crickReads)
+	}
+	for i := range variants {
+		if IsRefBlock(variants[i]) {
+			continue
+		}
+		watsonAlt, watsonDepth := siteCounts(watsonReads, variants[i])
+		crickAlt, crickDepth := siteCounts(crickReads, variants[i])
+		variants[i].Info = popaf.AppendInfo(variants[i].Info, familySizeInfo(watsonSize, crickSize, watsonAlt, watsonDepth, crickAlt, crickDepth))
+	}
+}
+
+// familySizeInfo returns the WFS, CFS, WAF, and CAF INFO fields of a call. The fraction
+// of a strand with no reads covering the call is missing.
+func familySizeInfo(watsonSize, crickSize, watsonAlt, watsonDepth, crickAlt, crickDepth int) string {
+	return fmt.Sprintf("%s=%d;%s=%d;%s=%s;%s=%s", WatsonSizeInfo, watsonSize, CrickSizeInfo, crickSize,
+		WatsonAfInfo, altFraction(watsonAlt, watsonDepth), CrickAfInfo, altFraction(crickAlt, crickDepth))
+}
+
+func altFraction(alt, depth int) string {
+	if depth == 0 {
+		return "."
+	}
+	return strconv.FormatFloat(float64(alt)/float64(depth), 'g', 4, 64)
+}
+
+// familySizeHeader returns the INFO lines of the family size and alt fraction fields.
+func familySizeHeader() []string {
+	return []string{
+		fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=Integer,Description=\"Watson reads of the read family\">", WatsonSizeInfo),
+		fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=Integer,Description=\"Crick reads of the read family\">", CrickSizeInfo),
+		fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=Float,Description=\"Fraction of the watson reads covering the variant that carry it\">", WatsonAfInfo),
+		fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=Float,Description=\"Fraction of the crick reads covering the variant that carry it\">", CrickAfInfo),
+	}
+}
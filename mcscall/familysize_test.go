@@ -0,0 +1,23 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestAddFamilySize(t *testing.T) {
+	watson := []sam.Sam{testRead("a", 11, "8M", "AAATAAAA"), testRead("b", 11, "8M", "AAATAAAA"), testRead("c", 11, "8M", "AAAAAAAA"), testRead("d", 11, "8M", "AAANAAAA")}
+	crick := []sam.Sam{testRead("e", 11, "8M", "AAATAAAA")}
+	variants := []vcf.Vcf{
+		{Pos: 14, Ref: "A", Alt: []string{"T"}, Info: "DS"},
+		{Pos: 30, Ref: "A", Alt: []string{"T"}, Info: "DS"}, // covered by no read
+	}
+	c := &Caller{Options: DefaultOptions()}
+	c.addFamilySize(variants, len(watson), len(crick), watson, crick)
+	for i, expected := range []string{"DS;WFS=4;CFS=1;WAF=0.6667;CAF=1", "DS;WFS=4;CFS=1;WAF=.;CAF=."} {
+		if variants[i].Info != expected {
+			t.Errorf("call %d: expected %s, got %s", i, expected, variants[i].Info)
+		}
+	}
+}
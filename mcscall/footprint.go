@@ -0,0 +1,37 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/sam"
+)
+
+// footprint returns the interval of family b that was interrogated: from the first to
+// the last position covered by both the watson and crick piles left after end clipping
+// and RemovePositionalOutliers, or by either in unstranded calling. The piles must be
+// sorted by position. ok is false if no position was interrogated.
+func footprint(watsonPiles, crickPiles []sam.Pile, b bed.Bed, unstranded bool) (fp bed.Bed, ok bool) {
+	var start, end uint32
+	switch {
+	case len(watsonPiles) == 0 && len(crickPiles) == 0:
+		return fp, false
+	case unstranded && len(watsonPiles) == 0:
+		start, end = crickPiles[0].Pos, crickPiles[len(crickPiles)-1].Pos
+	case unstranded && len(crickPiles) == 0:
+		start, end = watsonPiles[0].Pos, watsonPiles[len(watsonPiles)-1].Pos
+	case len(watsonPiles) == 0 || len(crickPiles) == 0:
+		return fp, false
+	default:
+		start, end = watsonPiles[0].Pos, watsonPiles[len(watsonPiles)-1].Pos
+		crickStart, crickEnd := crickPiles[0].Pos, crickPiles[len(crickPiles)-1].Pos
+		if (crickStart > start) != unstranded {
+			start = crickStart
+		}
+		if (crickEnd < end) != unstranded {
+			end = crickEnd
+		}
+	}
+	if end < start {
+		return fp, false
+	}
+	return bed.Bed{Chrom: b.Chrom, ChromStart: int(start) - 1, ChromEnd: int(end), Name: b.Name, FieldsInitialized: 4}, true // bed is 0-base sam is 1-base
+}
@@ -0,0 +1,32 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/sam"
+	"testing"
+)
+
+func TestFootprint(t *testing.T) {
+	piles := func(start, end uint32) []sam.Pile {
+		var ans []sam.Pile
+		for pos := start; pos <= end; pos++ {
+			ans = append(ans, sam.Pile{Pos: pos})
+		}
+		return ans
+	}
+	b := bed.Bed{Chrom: "chr1", ChromStart: 0, ChromEnd: 200, Name: "1"}
+	fp, ok := footprint(piles(11, 150), piles(20, 160), b, false)
+	if !ok || fp.ChromStart != 19 || fp.ChromEnd != 150 || fp.Name != "1" {
+		t.Errorf("expected the duplex footprint chr1:19-150, got %v %v", fp, ok)
+	}
+	fp, ok = footprint(piles(11, 150), piles(20, 160), b, true)
+	if !ok || fp.ChromStart != 10 || fp.ChromEnd != 160 {
+		t.Errorf("expected the unstranded footprint chr1:10-160, got %v %v", fp, ok)
+	}
+	if _, ok = footprint(piles(11, 150), nil, b, false); ok {
+		t.Errorf("expected no duplex footprint without crick piles")
+	}
+	if _, ok = footprint(piles(11, 15), piles(20, 30), b, false); ok {
+		t.Errorf("expected no duplex footprint of strands that do not overlap")
+	}
+}
@@ -0,0 +1,159 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"golang.org/x/exp/slices"
+	"sort"
+	"strings"
+)
+
+// NotCalledFilterName is the FILTER of a record of a ForceCallVcf site that its read
+// family did not call.
+const NotCalledFilterName = "NotCalled"
+
+// ForceCallInfo is the INFO flag of the records of ForceCallVcf sites.
+const ForceCallInfo = "FC"
+
+// forcedSites are the sites of ForceCallVcf on each chromosome, sorted by position,
+// with one alt allele each. Repeated sites are listed once.
+type forcedSites map[string][]vcf.Vcf
+
+func readForcedSites(file string) forcedSites {
+	records, _ := vcf.Read(file)
+	sites := make(forcedSites)
+	for _, v := range records {
+		for _, alt := range v.Alt {
+			if alt == "*" || alt == "." || strings.HasPrefix(alt, "<") || strings.EqualFold(alt, v.Ref) {
+				continue
+			}
+			sites[v.Chr] = append(sites[v.Chr], vcf.Vcf{Chr: v.Chr, Pos: v.Pos, Id: v.Id, Ref: strings.ToUpper(v.Ref), Alt: []string{strings.ToUpper(alt)}})
+		}
+	}
+	for chrom, s := range sites {
+		sort.SliceStable(s, func(i, j int) bool {
+			return s[i].Pos < s[j].Pos
+		})
+		sites[chrom] = slices.CompactFunc(s, func(a, b vcf.Vcf) bool {
+			return a.Pos == b.Pos && a.Ref == b.Ref && a.Alt[0] == b.Alt[0]
+		})
+	}
+	return sites
+}
+
+// in returns the sites in family b.
+func (f forcedSites) in(b bed.Bed) []vcf.Vcf {
+	s := f[b.Chrom]
+	i := sort.Search(len(s), func(i int) bool { return s[i].Pos > b.ChromStart })
+	j := sort.Search(len(s), func(i int) bool { return s[i].Pos > b.ChromEnd })
+	return s[i:j]
+}
+
+// forceCall returns a record of each ForceCallVcf site in family b with the reads of
+// each strand that carry its alt allele as PS and MS, and the reads of each strand
+// covering it in INFO as WDP and CDP, next to the family size of each strand, watsonSize
+// and crickSize, and the alt fractions. GT is 1 if the alt allele is on both strands, or
+// on either in unstranded calling. Records get the NotCalled FILTER until addForced
+// matches them with the calls of the family.
+func (c *Caller) forceCall(watsonReads, crickReads []sam.Sam, watsonSize, crickSize int, b bed.Bed) []vcf.Vcf {
+	sites := c.forced.in(b)
+	if len(sites) == 0 {
+		return nil
+	}
+	if !WatsonIsPlus(watsonReads, crickReads) {
+		watsonReads, crickReads = crickReads, watsonReads
+		watsonSize, crickSize = crickSize, watsonSize
+	}
+	if !c.CountOverlappingPairs {
+		watsonReads, crickReads = mergeOverlappingMates(watsonReads), mergeOverlappingMates(crickReads)
+	}
+	ans := make([]vcf.Vcf, 0, len(sites))
+	for _, s := range sites {
+		watsonAlt, watsonDepth := siteCounts(watsonReads, s)
+		crickAlt, crickDepth := siteCounts(crickReads, s)
+		gt := int16(0)
+		if (watsonAlt > 0 && crickAlt > 0) || (c.MinStrandedDepth == 0 && watsonAlt+crickAlt > 0) {
+			gt = 1
+		}
+		v := s
+		v.Filter = NotCalledFilterName
+		v.Info = fmt.Sprintf("%s;WDP=%d;CDP=%d;%s", ForceCallInfo, watsonDepth, crickDepth, familySizeInfo(watsonSize, crickSize, watsonAlt, watsonDepth, crickAlt, crickDepth))
+		v.Format = []string{"GT", "DP", "PS", "MS", "RF"}
+		v.Samples = []vcf.Sample{{Alleles: []int16{gt}, FormatData: []string{"", fmt.Sprint(watsonDepth + crickDepth), fmt.Sprint(watsonAlt), fmt.Sprint(crickAlt), b.Name}}}
+		if v.Id == "" {
+			v.Id = "."
+		}
+		ans = append(ans, v)
+	}
+	return ans
+}
+
+// siteCounts returns the reads that carry the alt allele of v and the reads with a
+// base, other than N, at its first position.
+func siteCounts(reads []sam.Sam, v vcf.Vcf) (alt, depth int) {
+	for i := range reads {
+		if _, ok := altIndex(reads[i], v); ok {
+			alt++
+		}
+		if base, ok := baseAtPos(reads[i], v.Pos); ok && base <= dna.T {
+			depth++
+		}
+	}
+	return
+}
+
+// addForced adds the records of forceCall to the calls of a family. A call of the same
+// allele gets the FC flag, and its record is dropped, so each site has a single record.
+func addForced(variants, forced []vcf.Vcf) []vcf.Vcf {
+	if len(forced) == 0 {
+		return variants
+	}
+	n := len(variants)
+	for _, f := range forced {
+		called := false
+		for i := 0; i < n; i++ {
+			v := &variants[i]
+			if !IsRefBlock(*v) && v.Chr == f.Chr && v.Pos == f.Pos && v.Ref == f.Ref && v.Alt[0] == f.Alt[0] {
+				v.Info += ";" + ForceCallInfo
+				called = true
+				break
+			}
+		}
+		if !called {
+			variants = append(variants, f)
+		}
+	}
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Pos < variants[j].Pos
+	})
+	return variants
+}
+
+// AddForceCallHeader adds the lines of the records of ForceCallVcf sites to h.
+func AddForceCallHeader(h vcf.Header) vcf.Header {
+	lines := []string{
+		fmt.Sprintf("##FILTER=<ID=%s,Description=\"Site given to -forceCall that the read family did not call\">", NotCalledFilterName),
+		fmt.Sprintf("##INFO=<ID=%s,Number=0,Type=Flag,Description=\"Site given to -forceCall\">", ForceCallInfo),
+	}
+	var hasDepths bool
+	for i := range h.Text {
+		hasDepths = hasDepths || strings.HasPrefix(h.Text[i], "##INFO=<ID=WDP,")
+	}
+	if !hasDepths {
+		lines = append(lines,
+			"##INFO=<ID=WDP,Number=1,Type=Integer,Description=\"Watson read depth of a candidate that failed a filter or a -forceCall site\">",
+			"##INFO=<ID=CDP,Number=1,Type=Integer,Description=\"Crick read depth of a candidate that failed a filter or a -forceCall site\">",
+		)
+	}
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), lines...), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, lines...)
+	return h
+}
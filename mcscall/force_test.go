@@ -0,0 +1,41 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strconv"
+	"testing"
+)
+
+func TestForceCall(t *testing.T) {
+	watson := []sam.Sam{testRead("a", 11, "8M", "AAATAAAA"), testRead("b", 11, "8M", "AAATAAAA"), testRead("c", 11, "3M1D4M", "AAAAAAA")}
+	crick := []sam.Sam{testRead("d", 11, "8M", "AAATAAAA"), testRead("e", 11, "8M", "AAANAAAA")}
+	c := Caller{Options: Options{MinStrandedDepth: 1}, forced: forcedSites{"chr1": {
+		{Chr: "chr1", Pos: 13, Ref: "AA", Alt: []string{"A"}}, // on one strand
+		{Chr: "chr1", Pos: 14, Ref: "A", Alt: []string{"T"}},  // on both strands
+		{Chr: "chr1", Pos: 16, Ref: "A", Alt: []string{"G"}},  // on neither
+		{Chr: "chr1", Pos: 30, Ref: "A", Alt: []string{"G"}},  // outside the family
+	}}}
+	forced := c.forceCall(watson, crick, len(watson), len(crick), bed.Bed{Chrom: "chr1", ChromStart: 10, ChromEnd: 20, Name: "1"})
+	if len(forced) != 3 {
+		t.Fatalf("expected a record of each of the 3 sites in the family, got %d", len(forced))
+	}
+	for i, expected := range []struct {
+		gt      int16
+		dp, alt int
+	}{{0, 5, 1}, {1, 3, 3}, {0, 5, 0}} {
+		s := forced[i].Samples[0]
+		ps, _ := strconv.Atoi(s.FormatData[2])
+		ms, _ := strconv.Atoi(s.FormatData[3])
+		if s.Alleles[0] != expected.gt || s.FormatData[1] != strconv.Itoa(expected.dp) || ps+ms != expected.alt {
+			t.Errorf("%d %s>%s: expected GT %d, DP %d, and %d alt reads, got %d, %s, and %d", forced[i].Pos, forced[i].Ref, forced[i].Alt[0], expected.gt, expected.dp, expected.alt, s.Alleles[0], s.FormatData[1], ps+ms)
+		}
+	}
+
+	calls := []vcf.Vcf{{Chr: "chr1", Pos: 14, Ref: "A", Alt: []string{"T"}, Info: "DS"}}
+	merged := addForced(calls, forced)
+	if len(merged) != 3 || merged[1].Info != "DS;"+ForceCallInfo || merged[0].Filter != NotCalledFilterName {
+		t.Errorf("expected the call to be flagged and the other sites added, got %v", merged)
+	}
+}
@@ -0,0 +1,117 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"sort"
+	"strings"
+)
+
+// GroupInfo is the INFO field with the strand groups of a family that carry a call and
+// those that cover it, with Options.GroupTag.
+const GroupInfo = "GRP"
+
+// tagValue returns the value of tag in r, which must have its tags in Extra, or "" if
+// it has none.
+func tagValue(r *sam.Sam, tag string) string {
+	idx := strings.Index("\t"+r.Extra, "\t"+tag+":")
+	if idx == -1 || idx+len(tag)+3 > len(r.Extra) {
+		return ""
+	}
+	value, _, _ := strings.Cut(r.Extra[idx+len(tag)+3:], "\t")
+	return value
+}
+
+// splitGroups returns the reads of a family split by strand and by the value of tag
+// into strand groups, e.g. the sub-families of each UMI pair of dual-UMI libraries,
+// ordered by strand and tag value. Reads without tag form a group of their strand.
+func splitGroups(watsonReads, crickReads []sam.Sam, tag string) [][]sam.Sam {
+	byKey := make(map[string][]sam.Sam)
+	for _, strand := range [][]sam.Sam{watsonReads, crickReads} {
+		for i := range strand {
+			key := string(barcode.GetRS(&strand[i])) + "\t" + tagValue(&strand[i], tag)
+			byKey[key] = append(byKey[key], strand[i])
+		}
+	}
+	keys := make([]string, 0, len(byKey))
+	for key := range byKey {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	groups := make([][]sam.Sam, len(keys))
+	for i, key := range keys {
+		groups[i] = byKey[key]
+	}
+	return groups
+}
+
+// checkGroups adds the strand groups that carry each call, of those that cover it, to
+// its INFO field as GRP, and fails calls that any covering group does not carry at the
+// MinAf of the call type, as the watson and crick strands must agree in duplex calling.
+// Calls must be at the position of their pile, before normalizeIndels.
+func (c *Caller) checkGroups(variants []vcf.Vcf, groups [][]sam.Sam) []vcf.Vcf {
+	if len(groups) == 0 {
+		return variants
+	}
+	if !c.CountOverlappingPairs {
+		for i := range groups {
+			groups[i] = mergeOverlappingMates(groups[i])
+		}
+	}
+	kept := variants[:0]
+	for _, v := range variants {
+		if IsRefBlock(v) {
+			kept = append(kept, v)
+			continue
+		}
+		var carrying, covering int
+		for _, g := range groups {
+			alt, depth := siteCounts(g, v)
+			if depth == 0 {
+				continue
+			}
+			covering++
+			if alt > 0 && float64(alt)/float64(depth) >= c.minAf(typeOf(v)) {
+				carrying++
+			}
+		}
+		v.Info = popaf.AppendInfo(v.Info, fmt.Sprintf("%s=%d/%d", GroupInfo, carrying, covering))
+		if carrying < covering {
+			failed := v.Filter
+			if !c.fail(GroupMismatchFilter, &failed) {
+				continue
+			}
+			v.Filter = failed
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
+
+// typeOf returns the variant type of v. MNVs are SNVs.
+func typeOf(v vcf.Vcf) variantType {
+	switch {
+	case len(v.Alt[0]) > len(v.Ref):
+		return insertion
+	case len(v.Ref) > len(v.Alt[0]):
+		return deletion
+	default:
+		return snv
+	}
+}
+
+// AddGroupHeader adds the INFO line of the strand groups of each call to h.
+func AddGroupHeader(h vcf.Header) vcf.Header {
+	line := fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=String,Description=\"Strand groups of the read family that carry the call / that cover it\">", GroupInfo)
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), line), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, line)
+	return h
+}
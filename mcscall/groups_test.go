@@ -0,0 +1,38 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestCheckGroups(t *testing.T) {
+	watson := []sam.Sam{
+		testRead("a", 11, "4M", "AATA", "RS:Z:W", "UG:Z:AC"),
+		testRead("b", 11, "4M", "AATA", "RS:Z:W", "UG:Z:AC"),
+		testRead("c", 11, "4M", "AATA", "RS:Z:W", "UG:Z:GT"),
+	}
+	crick := []sam.Sam{
+		testRead("d", 11, "4M", "AATA", "RS:Z:C", "UG:Z:AC"),
+		testRead("e", 11, "4M", "AAAA", "RS:Z:C", "UG:Z:GT"), // the crick GT group, groups[1], lacks the T
+		testRead("f", 11, "4M", "AANA", "RS:Z:C", "UG:Z:GT"),
+	}
+	groups := splitGroups(watson, crick, "UG")
+	if len(groups) != 4 {
+		t.Fatalf("expected 4 groups, got %d", len(groups))
+	}
+	if tag := tagValue(&watson[0], "UG"); tag != "AC" {
+		t.Errorf("expected UG tag AC, got %q", tag)
+	}
+
+	c := Caller{Options: Options{MinAf: 0.9, EmitFiltered: true}}
+	variants := c.checkGroups([]vcf.Vcf{{Pos: 13, Ref: "A", Alt: []string{"T"}, Filter: ".", Info: "DS"}}, groups)
+	if variants[0].Info != "DS;GRP=3/4" || variants[0].Filter != GroupMismatchFilter.String() {
+		t.Errorf("expected the call to fail in one of 4 groups, got %s %s", variants[0].Filter, variants[0].Info)
+	}
+
+	c.EmitFiltered = false
+	if variants = c.checkGroups([]vcf.Vcf{{Pos: 13, Ref: "A", Alt: []string{"T"}, Filter: "."}}, [][]sam.Sam{groups[0], groups[2], groups[3]}); len(variants) != 1 || variants[0].Filter != "." {
+		t.Errorf("expected the groups that agree to pass the call, got %v", variants)
+	}
+}
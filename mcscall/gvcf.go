@@ -0,0 +1,130 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"sort"
+	"strings"
+)
+
+// NonRef is the ALT allele of a reference block.
+const NonRef = "<NON_REF>"
+
+// site is a called site of a family and the depth of each strand there.
+type site struct {
+	pos           uint32
+	watson, crick int
+	variant       bool // a variant was called at the site
+}
+
+// addSite records a site where a call could be made from the watson and crick piles,
+// which are at the same position even if one is empty.
+func (c *Caller) addSite(wPile, cPile sam.Pile, variant bool) {
+	c.calledSites = append(c.calledSites, wPile.Pos)
+	if c.GVCF {
+		c.sites = append(c.sites, site{pos: wPile.Pos, watson: calcDepth(wPile), crick: calcDepth(cPile), variant: variant})
+	}
+}
+
+// addRefBlocks adds a reference block for each run of adjacent called sites of family b
+// without a variant to variants, and sorts them by position. The DP, PS, and MS of a
+// block are the lowest total, watson, and crick depth of its sites.
+func (c *Caller) addRefBlocks(variants []vcf.Vcf, b bed.Bed) []vcf.Vcf {
+	if !c.GVCF || len(c.sites) == 0 {
+		return variants
+	}
+	sort.Slice(c.sites, func(i, j int) bool {
+		return c.sites[i].pos < c.sites[j].pos
+	})
+	var start, end, watson, crick, total int
+	flush := func() {
+		if start == 0 {
+			return
+		}
+		refBase, err := c.ref.SeekByName(b.Chrom, start-1, start)
+		exception.PanicOnErr(err)
+		dna.AllToUpper(refBase)
+		variants = append(variants, refBlock(b, start, end, string(dna.BaseToRune(refBase[0])), total, watson, crick))
+		start = 0
+	}
+	for _, s := range c.sites {
+		if s.variant {
+			flush()
+			continue
+		}
+		if start != 0 && int(s.pos) != end+1 {
+			flush()
+		}
+		if start == 0 {
+			start, watson, crick, total = int(s.pos), s.watson, s.crick, s.watson+s.crick
+		}
+		end = int(s.pos)
+		if s.watson < watson {
+			watson = s.watson
+		}
+		if s.crick < crick {
+			crick = s.crick
+		}
+		if s.watson+s.crick < total {
+			total = s.watson + s.crick
+		}
+	}
+	flush()
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Pos < variants[j].Pos
+	})
+	return variants
+}
+
+// refBlock returns the gVCF record of the reference block from start to end (1-based,
+// inclusive) in family b.
+func refBlock(b bed.Bed, start, end int, ref string, total, watson, crick int) vcf.Vcf {
+	var v vcf.Vcf
+	v.Chr = b.Chrom
+	v.Pos = start
+	v.Id = "."
+	v.Ref = ref
+	v.Alt = []string{NonRef}
+	v.Filter = "."
+	v.Info = fmt.Sprintf("END=%d", end)
+	v.Format = []string{"GT", "DP", "PS", "MS", "RF"}
+	v.Samples = []vcf.Sample{{Alleles: []int16{0}, FormatData: []string{"", fmt.Sprint(total), fmt.Sprint(watson), fmt.Sprint(crick), b.Name}}}
+	return v
+}
+
+// IsRefBlock reports whether v is a reference block rather than a variant.
+func IsRefBlock(v vcf.Vcf) bool {
+	return len(v.Alt) == 1 && v.Alt[0] == NonRef
+}
+
+// countVariants returns the number of records in v that are neither reference blocks
+// nor rejected candidates.
+func countVariants(v []vcf.Vcf) int {
+	var n int
+	for i := range v {
+		if !IsRefBlock(v[i]) && !IsRejected(v[i]) {
+			n++
+		}
+	}
+	return n
+}
+
+// AddGVCFHeader adds the ##ALT and ##INFO lines of reference blocks to h.
+func AddGVCFHeader(h vcf.Header) vcf.Header {
+	var ans vcf.Header
+	for _, line := range h.Text {
+		if line == "##fileformat=VCFv4.2" {
+			ans.Text = append(ans.Text, line, "##ALT=<ID=NON_REF,Description=\"Reference block of sites where a variant could be called in a read family\">")
+			continue
+		}
+		if strings.HasPrefix(line, "##FORMAT") && len(ans.Text) > 0 && !strings.HasPrefix(ans.Text[len(ans.Text)-1], "##FORMAT") {
+			ans.Text = append(ans.Text, "##INFO=<ID=END,Number=1,Type=Integer,Description=\"End position of the reference block. DP, PS, and MS are the lowest depths in the block.\">")
+		}
+		ans.Text = append(ans.Text, line)
+	}
+	return ans
+}
@@ -0,0 +1,46 @@
+package mcscall
+
+import (
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/vcf"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAddRefBlocks(t *testing.T) {
+	ref := filepath.Join(t.TempDir(), "ref.fa")
+	if err := os.WriteFile(ref, []byte(">chr1\nACGTACGTAC\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ref+".fai", []byte("chr1\t10\t6\t10\t11\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c := &Caller{Options: Options{GVCF: true}, ref: fai.NewSeeker(ref)}
+	c.sites = []site{{pos: 6, watson: 4, crick: 5}, {pos: 2, watson: 5, crick: 4}, {pos: 3, watson: 6, crick: 6},
+		{pos: 4, watson: 4, crick: 4, variant: true}, {pos: 5, watson: 7, crick: 7}, {pos: 8, watson: 9, crick: 9}}
+	variant := vcf.Vcf{Chr: "chr1", Pos: 4, Ref: "T", Alt: []string{"A"}}
+	got := c.addRefBlocks([]vcf.Vcf{variant}, bed.Bed{Chrom: "chr1", Name: "7"})
+
+	expected := []string{"2 C END=3 9:5:4", "4 T  ", "5 A END=6 9:4:5", "8 T END=8 18:9:9"}
+	if len(got) != len(expected) || countVariants(got) != 1 {
+		t.Fatalf("expected 3 blocks and a variant, got %v", got)
+	}
+	for i := range got {
+		var depths string
+		if IsRefBlock(got[i]) {
+			depths = strings.Join(got[i].Samples[0].FormatData[1:4], ":")
+		}
+		if s := strings.Join([]string{strconv.Itoa(got[i].Pos), got[i].Ref, got[i].Info, depths}, " "); s != expected[i] {
+			t.Errorf("record %d: expected %q, got %q", i, expected[i], s)
+		}
+	}
+
+	h := AddGVCFHeader(VcfHeader(ref, ref))
+	if !strings.HasPrefix(h.Text[1], "##ALT=<ID=NON_REF") || !strings.Contains(strings.Join(h.Text, "\n"), "##INFO=<ID=END") {
+		t.Errorf("missing gVCF header lines: %v", h.Text)
+	}
+}
@@ -0,0 +1,14 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"strings"
+)
+
+// testRead returns a read named name aligned at pos with cigar cig and bases seq, with
+// tags, if any, as its Extra field. Tests set other fields, such as Qual, as needed.
+func testRead(name string, pos uint32, cig, seq string, tags ...string) sam.Sam {
+	return sam.Sam{QName: name, Pos: pos, Cigar: cigar.FromString(cig), Seq: dna.StringToBases(seq), Extra: strings.Join(tags, "\t")}
+}
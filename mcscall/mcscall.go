@@ -0,0 +1,349 @@
+// Package mcscall calls variants from META-CS read families. It holds the core of
+// mcsCallVariants so the family fetching, clipping, pileup, and pile-pair calling
+// steps can be used directly from other Go programs.
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/pool"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/fasta"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"sort"
+)
+
+// Options set the read filters and calling thresholds used by a Caller.
+type Options struct {
+	MinMapQ                  uint8          // minimum mapping quality of a read
+	MinTotalDepth            int            // minimum combined watson and crick depth
+	MinStrandedDepth         int            // minimum depth of each strand, 0 for unstranded calling
+	AllowSuppAln             bool           // keep reads with supplementary alignments
+	MinAf                    float64        // minimum alt allele fraction within each strand
+	MinAfSnv                 float64        // MinAf of SNVs, 0 to use MinAf
+	MinAfIns                 float64        // MinAf of insertions, 0 to use MinAf
+	MinAfDel                 float64        // MinAf of deletions, 0 to use MinAf
+	MinBaseQuality           int            // bases below this quality are N-masked
+	BaseQualPenalty          float64        // fraction of a read that an N-masked base counts for
+	ConsensusQuality         bool           // weigh SNV bases by quality for AF and QUAL instead of N-masking them below MinBaseQuality
+	MaxSoftClipFraction      float64        // maximum fraction of a read that may be soft clipped
+	EndPad                   int            // bases clipped from either end of each read
+	CountOverlappingPairs    bool           // count both reads where a read pair overlaps
+	UseMdTag                 bool           // take the reference base of SNVs from the MD tags of reads where present
+	CallSingleStrand         bool           // output single-stranded variants
+	MaxVariantsPerReadFamily int            // discard every call in a family with more variants than this
+	StrandErrorRate          float64        // probability of an error carried by every read of a strand, used for QUAL
+	EmitFiltered             bool           // return candidates that fail a calling filter with its name in FILTER
+	FamilyInfo               []FamilyColumn // family bed columns copied to the INFO field of each call
+	GVCF                     bool           // also return reference blocks for the called sites of each family
+	NormalBam                string         // matched normal bam (with .bai) to check each call against, or ""
+	MaxNormalAltReads        int            // drop calls with more alt reads than this in the normal
+	TagNormal                bool           // set FILTER to NormalFilterName instead of dropping calls seen in the normal
+	PopVcf                   string         // tabix indexed population VCF (e.g. gnomAD) to look up each call in, or ""
+	PopAfField               string         // INFO field of PopVcf with the allele frequency
+	MaxPopAf                 float64        // filter calls with a higher population allele frequency
+	RemovePop                bool           // drop calls above MaxPopAf instead of setting FILTER to popaf.Filter
+	OrientationBias          bool           // add the orientation bias p-values of each call to FORMAT as OB
+	MinOrientationP          float64        // fail calls with an orientation bias p-value below this on either strand, 0 for none
+	MaxHomopolymer           int            // drop indels in a reference homopolymer longer than this, 0 for no limit
+	MaxDinucRepeat           int            // drop indels in a reference dinucleotide repeat longer than this many bases, 0 for no limit
+	TagRepeat                bool           // set FILTER to RepeatFilterName instead of dropping indels in long repeats
+	MinOxoGP                 float64        // drop G>T and C>A SNVs with a read orientation bias p-value below this on either strand, 0 for none
+	MinDeaminationEndDist    int            // drop C>T and G>A SNVs whose alt reads are a median of fewer bases than this from the read ends, 0 for none
+	TagDamage                bool           // set FILTER to OxoGFilterName or DeaminationFilterName instead of dropping SNVs with a damage signature
+	MnvMaxDist               int            // merge SNV calls of a family at most this many bases apart into an MNV, 0 to keep them apart
+	SecondaryAf              float64        // list other alleles carried by this fraction of the reads of a strand in INFO, 0 for none
+	GroupTag                 string         // tag whose value splits each strand of a family into groups that must all carry a call, or ""
+	ForceCallVcf             string         // VCF of sites to return a record of in every family that covers them, called or not, or ""
+	MaxFamilyDepth           int            // randomly keep at most this many reads of each strand of a family, 0 for no limit
+	DownsampleSeed           uint64         // seed of the reads kept by MaxFamilyDepth
+	MinBreakendClip          int            // return BND records where the reads of a family are clipped by at least this many bases, 0 for none
+}
+
+// DefaultOptions returns the same defaults as mcsCallVariants.
+func DefaultOptions() Options {
+	return Options{
+		MinMapQ:                  20,
+		MinTotalDepth:            8,
+		MinStrandedDepth:         4,
+		MinAf:                    0.9,
+		MinBaseQuality:           30,
+		BaseQualPenalty:          0.5,
+		MaxSoftClipFraction:      0.2,
+		EndPad:                   3,
+		MaxVariantsPerReadFamily: 3,
+		StrandErrorRate:          0.001,
+		PopAfField:               "AF",
+		MaxPopAf:                 0.001,
+		MnvMaxDist:               1,
+	}
+}
+
+// Caller calls variants one read family at a time from an indexed bam, or a Stream, and
+// a reference. Buffers are reused between families so a Caller is not safe for
+// concurrent use; create one per goroutine.
+type Caller struct {
+	Options
+
+	// CalledSites, if not nil, receives a bed region for each contiguous run
+	// of positions in a family where a call could be made.
+	CalledSites chan<- bed.Bed
+
+	// Evidence, if not nil, receives copies of the end-clipped and N-masked reads that
+	// carry the calls of each family, with the IDs of the calls in an EvidenceTag tag.
+	// The ID column of each call is set.
+	Evidence chan<- []sam.Sam
+
+	// Consensus, if not nil, receives the duplex consensus sequence of each family that
+	// is called, for QC.
+	Consensus chan<- fasta.Fasta
+
+	// Footprints, if not nil, receives the interval of each called family interrogated
+	// after end clipping and the removal of positional outliers, named with the family ID.
+	Footprints chan<- bed.Bed
+
+	// Debug, if not nil, receives a trace of calling decisions.
+	Debug chan<- string
+
+	// Stats, if not nil, counts families, reads, variants, and filter rejections.
+	Stats *Stats
+
+	bam         *sam.BamReader
+	header      sam.Header
+	bai         sam.Bai
+	ref         *fai.Seeker
+	reads       []sam.Sam
+	calledSites []uint32
+	sites       []site // called sites with their depths, for GVCF
+	md          mdRef  // reference bases of the family from MD tags, with UseMdTag
+	breaks      breakends
+	watsonCons  consensus
+	crickCons   consensus
+	stream      *Stream
+	normal      *normal
+	pop         *popaf.DB
+	forced      forcedSites
+}
+
+// NewCaller opens bamFile (with bamFile.bai) and refFile (with refFile.fai) for calling.
+func NewCaller(bamFile, refFile string, opts Options) *Caller {
+	c := &Caller{Options: opts}
+	c.bai = bai.Read(bamFile) // first, so a missing index is reported before the bam is read
+	c.bam, c.header = sam.OpenBam(bamFile)
+	c.ref = fai.NewSeeker(refFile)
+	if opts.NormalBam != "" {
+		c.normal = openNormal(opts.NormalBam)
+	}
+	if opts.PopVcf != "" {
+		c.pop = popaf.Open(opts.PopVcf, opts.PopAfField)
+	}
+	if opts.ForceCallVcf != "" {
+		c.forced = readForcedSites(opts.ForceCallVcf)
+	}
+	return c
+}
+
+// NewStreamCaller returns a Caller that takes the reads of each family from s, which
+// must have read the family before it is called, instead of seeking them in an indexed
+// bam. The reference and Options are opened as by NewCaller.
+func NewStreamCaller(s *Stream, refFile string, opts Options) *Caller {
+	c := &Caller{Options: opts, header: s.Header(), stream: s}
+	c.ref = fai.NewSeeker(refFile)
+	if opts.NormalBam != "" {
+		c.normal = openNormal(opts.NormalBam)
+	}
+	if opts.PopVcf != "" {
+		c.pop = popaf.Open(opts.PopVcf, opts.PopAfField)
+	}
+	if opts.ForceCallVcf != "" {
+		c.forced = readForcedSites(opts.ForceCallVcf)
+	}
+	return c
+}
+
+// Header returns the header of the input bam.
+func (c *Caller) Header() sam.Header {
+	return c.header
+}
+
+// Close closes the bam and reference. The Stream of a Caller made with NewStreamCaller
+// is not closed. A bam that could not be read to the end of a
+// block, as when it is truncated, returns an error instead of panicking.
+func (c *Caller) Close() (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("could not close bam: %v", r)
+		}
+	}()
+	if c.bam != nil {
+		if err = c.bam.Close(); err != nil {
+			return err
+		}
+	}
+	if c.normal != nil {
+		if err = c.normal.bam.Close(); err != nil {
+			return err
+		}
+	}
+	if c.pop != nil {
+		if err = c.pop.Close(); err != nil {
+			return err
+		}
+	}
+	return c.ref.Close()
+}
+
+// CallFamily returns the variants called in the read family b. The bed name must
+// be the family ID set in the RF tag and the coordinates must cover the family. With
+// MaxFamilyDepth, the reads of each strand are downsampled before the pileup, and the
+// family size in the INFO of each call is that before downsampling. With
+// MinBreakendClip, BND records of the breakends in the family are also returned, even
+// if the family has too few reads to call other variants.
+func (c *Caller) CallFamily(b bed.Bed) []vcf.Vcf {
+	watsonReads, crickReads := c.FetchFamily(b)
+	defer pool.Sams.Put(watsonReads)
+	defer pool.Sams.Put(crickReads)
+	if c.Stats != nil {
+		c.Stats.Families.Inc()
+		c.Stats.Reads.Add(len(watsonReads) + len(crickReads))
+	}
+	watsonSize, crickSize := len(watsonReads), len(crickReads)
+	watsonReads = downsample(watsonReads, c.MaxFamilyDepth, b.Name, c.DownsampleSeed)
+	crickReads = downsample(crickReads, c.MaxFamilyDepth, b.Name, c.DownsampleSeed)
+	if (len(watsonReads) == 0 && len(crickReads) == 0) || (len(watsonReads) < c.MinStrandedDepth || len(crickReads) < c.MinStrandedDepth) {
+		c.reject(FamilyDepthFilter)
+		breakends := c.callBreakends(b)
+		if c.Stats != nil {
+			c.Stats.Variants.Add(len(breakends))
+		}
+		forced := addForced(breakends, c.forceCall(watsonReads, crickReads, watsonSize, crickSize, b))
+		addFamilyInfo(forced, b, c.FamilyInfo)
+		return forced
+	}
+
+	sort.Slice(watsonReads, func(i, j int) bool {
+		return watsonReads[i].Pos < watsonReads[j].Pos
+	})
+	sort.Slice(crickReads, func(i, j int) bool {
+		return crickReads[i].Pos < crickReads[j].Pos
+	})
+
+	// IF NECESSARY SWITCH WATSON AND CRICK READS SO WATSON IS ALWAYS PLUS AND CRICK IS ALWAYS MINUS
+	if !WatsonIsPlus(watsonReads, crickReads) {
+		watsonReads, crickReads = crickReads, watsonReads
+		watsonSize, crickSize = crickSize, watsonSize
+	}
+
+	watsonPiles := Pileup(watsonReads, c.header, c.CountOverlappingPairs)
+	crickPiles := Pileup(crickReads, c.header, c.CountOverlappingPairs)
+	forced := c.forceCall(watsonReads, crickReads, watsonSize, crickSize, b)
+	if c.ConsensusQuality {
+		c.watsonCons.build(watsonReads, b, c.CountOverlappingPairs)
+		c.crickCons.build(crickReads, b, c.CountOverlappingPairs)
+	}
+
+	// remove piles that fall outside the consensus start/end of the read families
+	filteredWatsonPiles, filteredCrickPiles := RemovePositionalOutliers(watsonPiles, crickPiles, watsonReads, crickReads)
+	pool.Piles.Put(watsonPiles)
+	pool.Piles.Put(crickPiles)
+	if c.Footprints != nil {
+		if fp, ok := footprint(filteredWatsonPiles, filteredCrickPiles, b, c.MinStrandedDepth == 0); ok {
+			c.Footprints <- fp
+		}
+	}
+	variants := c.CallPiles(filteredWatsonPiles, filteredCrickPiles, b)
+	if c.Consensus != nil {
+		c.Consensus <- duplexConsensus(filteredWatsonPiles, filteredCrickPiles, b)
+	}
+	pool.Piles.Put(filteredWatsonPiles)
+	pool.Piles.Put(filteredCrickPiles)
+	variants = c.mergeMnvs(variants, watsonReads, crickReads)
+	addEndDistance(variants, watsonReads, crickReads)
+	c.addFamilySize(variants, watsonSize, crickSize, watsonReads, crickReads)
+	if c.GroupTag != "" {
+		variants = c.checkGroups(variants, splitGroups(watsonReads, crickReads, c.GroupTag))
+	}
+	variants = c.checkDamage(variants, watsonReads, crickReads)
+	var carriers []carrier
+	if c.Evidence != nil {
+		carriers = findEvidence(variants, b.Name, watsonReads, crickReads)
+	}
+	variants = c.checkPopulation(c.checkRepeats(c.normalizeIndels(c.checkNormal(variants), b)))
+	variants = append(variants, c.callBreakends(b)...)
+	variants = addForced(variants, forced)
+	if c.Stats != nil {
+		c.Stats.Variants.Add(countVariants(variants))
+	}
+	addFamilyInfo(variants, b, c.FamilyInfo)
+	if c.Evidence != nil {
+		c.sendEvidence(variants, carriers)
+	}
+	return variants
+}
+
+// FetchFamily returns the watson and crick reads of family b that pass the read
+// filters in Options. Returned reads are end-clipped and low quality bases are
+// N-masked, below MinBaseQuality or, with ConsensusQuality, only the Q2 ends of Illumina
+// reads. With UseMdTag, the reference bases of the family are first read from the
+// MD tags of the reads. The reads share memory with the Caller and are only valid until the
+// next call to FetchFamily or CallFamily. The slices are taken from pool.Sams and
+// may be returned with pool.Sams.Put once they are no longer needed.
+func (c *Caller) FetchFamily(b bed.Bed) (watsonReads, crickReads []sam.Sam) {
+	var famId string
+	var strand byte
+
+	if c.UseMdTag {
+		c.md.reset()
+	}
+	c.breaks.reset()
+	if c.stream != nil {
+		c.reads = c.stream.take(b)
+	} else {
+		c.reads = sam.SeekBamRegionRecycle(c.bam, c.bai, b.Chrom, uint32(b.ChromStart), uint32(b.ChromEnd), c.reads[:0])
+	}
+	watsonReads = pool.Sams.Get(len(c.reads))
+	crickReads = pool.Sams.Get(len(c.reads))
+
+	for i := range c.reads {
+		if c.reads[i].MapQ < c.MinMapQ {
+			continue
+		}
+		sam.ParseExtra(&c.reads[i])
+		famId = barcode.GetRF(&c.reads[i])
+		if famId != b.Name {
+			continue
+		}
+		if c.MinBreakendClip > 0 { // before the reads with clips or a supplementary alignment are dropped
+			c.breaks.add(&c.reads[i], barcode.GetRS(&c.reads[i]))
+		}
+		if hasSuppAln(c.reads[i]) && !c.AllowSuppAln {
+			c.reject(SuppAlnFilter)
+			continue
+		}
+		if SoftClipFraction(&c.reads[i]) > c.MaxSoftClipFraction {
+			c.reject(SoftClipFilter)
+			continue
+		}
+		if c.UseMdTag {
+			c.md.add(&c.reads[i])
+		}
+		ClipReadEnds(&c.reads[i], c.EndPad)
+		if c.ConsensusQuality {
+			MaskLowQualityBases(&c.reads[i], minConsensusInputQual)
+		} else {
+			MaskLowQualityBases(&c.reads[i], c.MinBaseQuality)
+		}
+
+		strand = barcode.GetRS(&c.reads[i])
+		if strand == 'W' {
+			watsonReads = append(watsonReads, c.reads[i])
+		} else if strand == 'C' {
+			crickReads = append(crickReads, c.reads[i])
+		}
+	}
+	return
+}
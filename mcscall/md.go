@@ -0,0 +1,133 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+)
+
+// refBase returns the reference base at the 1-based position pos of chr, taken from the
+// MD tags of the reads of the family with UseMdTag and otherwise read from the reference.
+func (c *Caller) refBase(chr string, pos int) dna.Base {
+	if c.UseMdTag {
+		if b, found := c.md.base(pos); found {
+			return b
+		}
+	}
+	refBase, err := c.ref.SeekByName(chr, pos-1, pos)
+	exception.PanicOnErr(err)
+	return dna.ToUpper(refBase[0])
+}
+
+// mdRef holds the reference bases of the window of a read family, read from the MD
+// tags of its reads, so SNV calls need not seek the reference.
+type mdRef struct {
+	start int        // 1-based position of bases[0]
+	bases []dna.Base // dna.Nil where no read gave the base
+}
+
+// reset empties m for the next family.
+func (m *mdRef) reset() {
+	m.bases = m.bases[:0]
+}
+
+// base returns the reference base at the 1-based position pos, and false if no read
+// gave it.
+func (m *mdRef) base(pos int) (dna.Base, bool) {
+	i := pos - m.start
+	if i < 0 || i >= len(m.bases) || m.bases[i] == dna.Nil {
+		return dna.Nil, false
+	}
+	return m.bases[i], true
+}
+
+// set records b as the reference base at the 1-based position pos.
+func (m *mdRef) set(pos int, b dna.Base) {
+	if len(m.bases) == 0 {
+		m.start = pos
+	}
+	if pos < m.start {
+		grown := make([]dna.Base, m.start-pos, m.start-pos+len(m.bases))
+		for i := range grown {
+			grown[i] = dna.Nil
+		}
+		m.bases = append(grown, m.bases...)
+		m.start = pos
+	}
+	for len(m.bases) <= pos-m.start {
+		m.bases = append(m.bases, dna.Nil)
+	}
+	m.bases[pos-m.start] = dna.ToUpper(b)
+}
+
+// add records the reference bases of the aligned bases and deletions of r from its MD
+// tag. It must be called before r is clipped. Reads without an MD tag, or with one that
+// does not match the cigar, are skipped from the point they stop matching.
+func (m *mdRef) add(r *sam.Sam) {
+	md := mdTag(r)
+	if md == "" {
+		return
+	}
+
+	var i int // index in md
+	number := func() int {
+		var n int
+		for ; i < len(md) && md[i] >= '0' && md[i] <= '9'; i++ {
+			n = n*10 + int(md[i]-'0')
+		}
+		return n
+	}
+	matches := number()
+	refPos, queryPos := int(r.Pos), 0
+	for _, op := range r.Cigar {
+		switch op.Op {
+		case 'M', '=', 'X':
+			for k := 0; k < op.RunLength; k++ {
+				if matches > 0 {
+					if r.Seq[queryPos] != dna.N {
+						m.set(refPos, r.Seq[queryPos])
+					}
+					matches--
+				} else {
+					if i >= len(md) || md[i] == '^' {
+						return
+					}
+					b, err := dna.RuneToBase(rune(md[i]))
+					if err != nil {
+						return
+					}
+					m.set(refPos, b)
+					i++
+					matches = number()
+				}
+				refPos++
+				queryPos++
+			}
+		case 'D':
+			if matches > 0 || i >= len(md) || md[i] != '^' || i+op.RunLength >= len(md) {
+				return
+			}
+			i++
+			for k := 0; k < op.RunLength; k++ {
+				b, err := dna.RuneToBase(rune(md[i]))
+				if err != nil {
+					return
+				}
+				m.set(refPos, b)
+				i++
+				refPos++
+			}
+			matches = number()
+		case 'N':
+			refPos += op.RunLength
+		case 'I', 'S':
+			queryPos += op.RunLength
+		}
+	}
+}
+
+// mdTag returns the value of the MD tag of r, which must have its tags in Extra, or ""
+// if it has none.
+func mdTag(r *sam.Sam) string {
+	return tagValue(r, "MD")
+}
@@ -0,0 +1,37 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"testing"
+)
+
+func TestMdRef(t *testing.T) {
+	c := &Caller{Options: DefaultOptions()}
+	c.UseMdTag = true
+	//                  1234567890123456789
+	c.ref = testRef(t, "AAAAAAAAAACGTAGGCCAA")
+
+	// a mismatch at 13 and a deletion of 15 and 16
+	r := sam.Sam{Pos: 10, Cigar: cigar.FromString("1S5M2D2M"), Seq: dna.StringToBases("TACGAACC"), Extra: "NM:i:3\tMD:Z:3T1^GG2\tRF:Z:1"}
+	c.md.reset()
+	c.md.add(&r)
+	// a read before the first, with a base masked by the sequencer
+	r = sam.Sam{Pos: 8, Cigar: cigar.FromString("3M"), Seq: dna.StringToBases("ANA"), Extra: "MD:Z:1G1"}
+	c.md.add(&r)
+
+	for pos, expected := range map[int]dna.Base{8: dna.A, 9: dna.G, 10: dna.A, 13: dna.T, 15: dna.G, 16: dna.G, 18: dna.C} {
+		if b, found := c.md.base(pos); !found || b != expected {
+			t.Errorf("position %d: expected %s from MD, got %s %v", pos, dna.BaseToString(expected), dna.BaseToString(b), found)
+		}
+	}
+
+	// the reference is read where no read gave the base
+	if b := c.refBase("chr1", 19); b != dna.A {
+		t.Errorf("expected A from the reference, got %s", dna.BaseToString(b))
+	}
+	if b := c.refBase("chr1", 9); b != dna.G {
+		t.Errorf("expected G from MD, got %s", dna.BaseToString(b))
+	}
+}
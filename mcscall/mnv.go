@@ -0,0 +1,178 @@
+package mcscall
+
+import (
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// mergeMnvs merges runs of passing SNV calls of a family that are at most MnvMaxDist
+// bases apart, have the same INFO apart from the fields of a single site (see
+// sharedInfo), and are carried by the same reads into a single MNV call (e.g. CC>TT).
+// The reads carry the same alleles if at least MinAf of the reads that carry either alt
+// allele of two neighboring SNVs, and cover both, carry both. The QUAL, DP, PS, and MS of
+// an MNV are the lowest of its SNVs. Calls are returned sorted by position.
+func (c *Caller) mergeMnvs(variants []vcf.Vcf, watsonReads, crickReads []sam.Sam) []vcf.Vcf {
+	if c.MnvMaxDist < 1 || len(variants) < 2 {
+		return variants
+	}
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Pos < variants[j].Pos
+	})
+	ans := variants[:0]
+	var run []vcf.Vcf
+	flush := func() {
+		if len(run) == 1 {
+			ans = append(ans, run[0])
+		} else if len(run) > 1 {
+			ans = append(ans, c.mnvToVcf(run))
+		}
+		run = run[:0]
+	}
+	for _, v := range variants {
+		if !isMnvPart(v) {
+			flush()
+			ans = append(ans, v)
+			continue
+		}
+		if len(run) > 0 {
+			last := run[len(run)-1]
+			if v.Pos-last.Pos > c.MnvMaxDist || sharedInfo(v.Info) != sharedInfo(last.Info) || !c.sameReads(last, v, watsonReads, crickReads) {
+				flush()
+			}
+		}
+		run = append(run, v)
+	}
+	flush()
+	return ans
+}
+
+// isMnvPart reports whether v is a passing SNV call that may be merged into an MNV.
+func isMnvPart(v vcf.Vcf) bool {
+	return len(v.Ref) == 1 && len(v.Alt) == 1 && len(v.Alt[0]) == 1 && v.Filter == "." && !IsRefBlock(v)
+}
+
+// sameReads reports whether at least MinAf of the reads that cover SNVs a and b and carry
+// either alt allele carry both. N-masked bases are not counted.
+func (c *Caller) sameReads(a, b vcf.Vcf, reads ...[]sam.Sam) bool {
+	altA, altB := dna.StringToBase(a.Alt[0]), dna.StringToBase(b.Alt[0])
+	var either, both int
+	for _, strand := range reads {
+		for i := range strand {
+			baseA, okA := baseAtPos(strand[i], a.Pos)
+			baseB, okB := baseAtPos(strand[i], b.Pos)
+			if !okA || !okB || baseA == dna.N || baseB == dna.N {
+				continue
+			}
+			if baseA == altA || baseB == altB {
+				either++
+			}
+			if baseA == altA && baseB == altB {
+				both++
+			}
+		}
+	}
+	return either > 0 && float64(both) >= c.MinAf*float64(either)
+}
+
+// baseAtPos returns the base of r aligned to the 1-based reference position pos, and
+// false if r does not cover pos or has a deletion there.
+func baseAtPos(r sam.Sam, pos int) (dna.Base, bool) {
+	i, ok := queryIndex(r, pos)
+	if !ok {
+		return dna.N, false
+	}
+	return dna.ToUpper(r.Seq[i]), true
+}
+
+// sharedInfo returns info without the fields that describe a single site, which the SNV
+// calls of an MNV need not share: the secondary alleles (SecondaryInfo).
+func sharedInfo(info string) string {
+	fields := strings.Split(info, ";")
+	kept := fields[:0]
+	for _, f := range fields {
+		if !strings.HasPrefix(f, SecondaryInfo+"=") {
+			kept = append(kept, f)
+		}
+	}
+	return strings.Join(kept, ";")
+}
+
+// mnvSecondaryAlleles returns the secondary alleles of the SNV calls in run, each
+// preceded by the position of its SNV in the MNV, starting at 1 (e.g. 2G:3:2).
+func mnvSecondaryAlleles(run []vcf.Vcf) []string {
+	var alleles []string
+	for _, snv := range run {
+		for _, f := range strings.Split(snv.Info, ";") {
+			list, found := strings.CutPrefix(f, SecondaryInfo+"=")
+			if !found {
+				continue
+			}
+			for _, allele := range strings.Split(list, ",") {
+				alleles = append(alleles, strconv.Itoa(snv.Pos-run[0].Pos+1)+allele)
+			}
+		}
+	}
+	return alleles
+}
+
+// formatIndex returns the index of the FORMAT field key of v, or -1 if v has none.
+func formatIndex(v vcf.Vcf, key string) int {
+	for i := range v.Format {
+		if v.Format[i] == key && i < len(v.Samples[0].FormatData) {
+			return i
+		}
+	}
+	return -1
+}
+
+// mnvToVcf returns the MNV call of the SNV calls in run, which span the reference from
+// the first to the last. FORMAT fields other than DP, PS, MS, and OB are those of the
+// first SNV.
+func (c *Caller) mnvToVcf(run []vcf.Vcf) vcf.Vcf {
+	first, last := run[0], run[len(run)-1]
+	refSeq, err := c.ref.SeekByName(first.Chr, first.Pos-1, last.Pos)
+	exception.PanicOnErr(err)
+	dna.AllToUpper(refSeq)
+	altSeq := make([]dna.Base, len(refSeq))
+	copy(altSeq, refSeq)
+
+	v := first
+	v.Ref = dna.BasesToString(refSeq)
+	v.Info = sharedInfo(first.Info)
+	if alleles := mnvSecondaryAlleles(run); len(alleles) > 0 {
+		v.Info = popaf.AppendInfo(v.Info, SecondaryInfo+"="+strings.Join(alleles, ","))
+	}
+	v.Samples = []vcf.Sample{{Alleles: []int16{1}, FormatData: append([]string{}, first.Samples[0].FormatData...)}}
+	for _, key := range []string{"DP", "PS", "MS"} {
+		i := formatIndex(first, key)
+		if i < 0 {
+			continue
+		}
+		depth, _ := strconv.Atoi(first.Samples[0].FormatData[i])
+		for _, snv := range run {
+			if j := formatIndex(snv, key); j >= 0 {
+				if d, _ := strconv.Atoi(snv.Samples[0].FormatData[j]); d < depth {
+					depth = d
+				}
+			}
+		}
+		v.Samples[0].FormatData[i] = strconv.Itoa(depth)
+	}
+	if i := formatIndex(first, orientationBiasField); i >= 0 {
+		v.Samples[0].FormatData[i] = minOrientationBias(run)
+	}
+	for _, snv := range run {
+		altSeq[snv.Pos-first.Pos] = dna.StringToBase(snv.Alt[0])
+		if snv.Qual < v.Qual {
+			v.Qual = snv.Qual
+		}
+	}
+	v.Alt = []string{dna.BasesToString(altSeq)}
+	return v
+}
@@ -0,0 +1,66 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strconv"
+	"testing"
+)
+
+func TestMergeMnvs(t *testing.T) {
+	snv := func(pos int, alt string) vcf.Vcf {
+		return vcf.Vcf{Chr: "chr1", Pos: pos, Ref: "A", Alt: []string{alt}, Filter: ".", Info: "DS", Qual: float64(pos), Format: []string{"GT", "DP", "PS", "MS", "RF"},
+			Samples: []vcf.Sample{{Alleles: []int16{1}, FormatData: []string{"", "8", strconv.Itoa(pos), "4", "7"}}}}
+	}
+	c := &Caller{Options: DefaultOptions()}
+
+	// bases at 10, 11, then 13, 14, 15 after the deletion
+	watson := []sam.Sam{testRead("", 10, "2M1D3M", "TTAAA"), testRead("", 10, "2M1D3M", "TTAAA"), testRead("", 10, "2M1D3M", "TTNAA")}
+	crick := []sam.Sam{testRead("", 10, "2M1D3M", "TTAAA")}
+	if !c.sameReads(snv(10, "T"), snv(11, "T"), watson, crick) {
+		t.Error("expected SNVs carried by every read to be on the same reads")
+	}
+	if c.sameReads(snv(10, "T"), snv(12, "T"), watson, crick) {
+		t.Error("expected no reads to cover the deleted base")
+	}
+
+	c.ref = testRef(t, "ACGTACGTACGTACGTACGT")
+	variants := c.mergeMnvs([]vcf.Vcf{snv(11, "T"), snv(10, "T"), snv(14, "C")}, watson, crick)
+	if len(variants) != 2 {
+		t.Fatalf("expected an MNV and an SNV, got %v", variants)
+	}
+	if v := variants[0]; v.Pos != 10 || v.Ref != "CG" || v.Alt[0] != "TT" || v.Qual != 10 || v.Samples[0].FormatData[2] != "10" || v.Samples[0].FormatData[4] != "7" {
+		t.Errorf("unexpected MNV %v", v)
+	}
+
+	// secondary alleles of each site are kept with their position in the MNV
+	a, b := snv(10, "T"), snv(11, "T")
+	a.Info += ";" + SecondaryInfo + "=G:3:2"
+	b.Info += ";" + SecondaryInfo + "=C:1:0,+CA:2:1"
+	variants = c.mergeMnvs([]vcf.Vcf{a, b}, watson, crick)
+	if len(variants) != 1 || variants[0].Info != "DS;SA=1G:3:2,2C:1:0,2+CA:2:1" {
+		t.Errorf("expected SNVs with different secondary alleles to be merged, got %v", variants)
+	}
+
+	// FORMAT fields are found by name
+	a, b = snv(10, "T"), snv(11, "T")
+	for _, v := range []*vcf.Vcf{&a, &b} {
+		v.Format = []string{"GT", "RF", "MS", "PS", "DP"}
+		v.Samples[0].FormatData = []string{"", "7", "4", strconv.Itoa(v.Pos), "8"}
+	}
+	variants = c.mergeMnvs([]vcf.Vcf{a, b}, watson, crick)
+	if len(variants) != 1 || variants[0].Samples[0].FormatData[3] != "10" || variants[0].Samples[0].FormatData[1] != "7" {
+		t.Errorf("unexpected MNV %v", variants)
+	}
+
+	crick = append(crick, testRead("", 10, "2M1D3M", "ATAAA"), testRead("", 10, "2M1D3M", "ATAAA"))
+	if c.sameReads(snv(10, "T"), snv(11, "T"), watson, crick) {
+		t.Error("expected SNVs with 2 of 6 reads carrying one to be on different reads")
+	}
+
+	// calls on different reads, or too far apart, are kept apart
+	variants = c.mergeMnvs([]vcf.Vcf{snv(11, "T"), snv(10, "T"), snv(13, "C")}, watson, crick)
+	if len(variants) != 3 || variants[0].Pos != 10 || variants[2].Pos != 13 {
+		t.Errorf("unexpected calls after merging: %v", variants)
+	}
+}
@@ -0,0 +1,109 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/bai"
+	"github.com/dasnellings/duplexTools/pool"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"sort"
+	"strings"
+)
+
+// NormalFilterName is the FILTER of calls supported in the matched normal when
+// Options.TagNormal is set.
+const NormalFilterName = "Normal"
+
+// normal reads the pile of a matched normal bam at each call.
+type normal struct {
+	bam    *sam.BamReader
+	header sam.Header
+	bai    sam.Bai
+	reads  []sam.Sam
+}
+
+func openNormal(bamFile string) *normal {
+	n := &normal{bai: bai.Read(bamFile)}
+	n.bam, n.header = sam.OpenBam(bamFile)
+	return n
+}
+
+// count returns the number of reads in the normal that support the alt allele of v,
+// and the depth at v. Reads below minMapQ, duplicates, and secondary and supplementary
+// alignments are not counted, and bases below minBaseQuality are N-masked.
+func (n *normal) count(v vcf.Vcf, minMapQ uint8, minBaseQuality int) (alt, depth int) {
+	pos := v.Pos
+	if len(v.Ref) > len(v.Alt[0]) { // deletions start at the base before the pile
+		pos++
+	}
+	n.reads = sam.SeekBamRegionRecycle(n.bam, n.bai, v.Chr, uint32(pos-1), uint32(pos), n.reads[:0])
+	reads := pool.Sams.Get(len(n.reads))
+	defer pool.Sams.Put(reads)
+	for i := range n.reads {
+		if n.reads[i].MapQ < minMapQ || n.reads[i].Flag&0xd00 != 0 { // secondary, duplicate, or supplementary
+			continue
+		}
+		MaskLowQualityBases(&n.reads[i], minBaseQuality)
+		reads = append(reads, n.reads[i])
+	}
+	sort.Slice(reads, func(i, j int) bool {
+		return reads[i].Pos < reads[j].Pos
+	})
+	piles := Pileup(reads, n.header, false)
+	defer pool.Piles.Put(piles)
+	for _, p := range piles {
+		if int(p.Pos) != pos {
+			continue
+		}
+		return altCount(p, v), calcDepth(p)
+	}
+	return 0, 0
+}
+
+// checkNormal adds the alt and total depth in the normal to the INFO field of each
+// call and drops, or with TagNormal filters, calls with more than MaxNormalAltReads
+// alt reads in the normal.
+func (c *Caller) checkNormal(variants []vcf.Vcf) []vcf.Vcf {
+	if c.normal == nil {
+		return variants
+	}
+	kept := variants[:0]
+	for _, v := range variants {
+		if IsRefBlock(v) {
+			kept = append(kept, v)
+			continue
+		}
+		alt, depth := c.normal.count(v, c.MinMapQ, c.MinBaseQuality)
+		v.Info += fmt.Sprintf(";NAD=%d;NDP=%d", alt, depth)
+		if alt > c.MaxNormalAltReads {
+			c.reject(NormalFilter)
+			if !c.TagNormal {
+				continue
+			}
+			v.Filter = popaf.AppendFilter(v.Filter, NormalFilterName)
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
+
+// AddNormalHeader adds the lines for the matched normal counts, and the Normal FILTER
+// if tag is set, to h.
+func AddNormalHeader(h vcf.Header, maxAltReads int, tag bool) vcf.Header {
+	lines := []string{
+		"##INFO=<ID=NAD,Number=1,Type=Integer,Description=\"Reads supporting the alt allele in the matched normal\">",
+		"##INFO=<ID=NDP,Number=1,Type=Integer,Description=\"Read depth in the matched normal\">",
+	}
+	if tag {
+		lines = append([]string{fmt.Sprintf("##FILTER=<ID=%s,Description=\"More than %d reads support the alt allele in the matched normal\">", NormalFilterName, maxAltReads)}, lines...)
+	}
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), lines...), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, lines...)
+	return h
+}
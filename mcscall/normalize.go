@@ -0,0 +1,74 @@
+package mcscall
+
+import (
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/vcf"
+	"sort"
+)
+
+// normalizeIndels left-aligns the insertion and deletion calls in variants, which are
+// made at the pile position of the family, so they match the output of bcftools norm,
+// and sorts the calls by position if any moved. Calls are not moved before the start of
+// family b, so they stay after the calls of families written before it.
+func (c *Caller) normalizeIndels(variants []vcf.Vcf, b bed.Bed) []vcf.Vcf {
+	var moved bool
+	for i := range variants {
+		if IsRefBlock(variants[i]) || len(variants[i].Ref) == len(variants[i].Alt[0]) {
+			continue
+		}
+		pos := variants[i].Pos
+		normalize(&variants[i], c.ref, b.ChromStart+1)
+		moved = moved || variants[i].Pos != pos
+	}
+	if moved {
+		sort.SliceStable(variants, func(i, j int) bool {
+			return variants[i].Pos < variants[j].Pos
+		})
+	}
+	return variants
+}
+
+// normalize left-aligns v and trims bases shared by REF and ALT, keeping one base before
+// an indel, as in Tan et al. 2015. v is not moved before minPos, or before its own
+// position if that is smaller. An indel that cannot move further left, at minPos or at
+// the start of the contig, keeps the base after it instead, as bcftools norm does.
+// Bases next to v are read from ref.
+func normalize(v *vcf.Vcf, ref *fai.Seeker, minPos int) {
+	r, a := []byte(v.Ref), []byte(v.Alt[0])
+	pos := v.Pos
+	if minPos > pos {
+		minPos = pos
+	}
+	for {
+		if len(r) > 0 && len(a) > 0 && r[len(r)-1] == a[len(a)-1] {
+			r, a = r[:len(r)-1], a[:len(a)-1]
+			continue
+		}
+		if (len(r) == 0 || len(a) == 0) && pos > 1 && pos > minPos {
+			base := refBase(ref, v.Chr, pos-1)
+			r, a = append([]byte{base}, r...), append([]byte{base}, a...)
+			pos--
+			continue
+		}
+		if len(r) == 0 || len(a) == 0 {
+			base := refBase(ref, v.Chr, pos+len(r))
+			r, a = append(r, base), append(a, base)
+		}
+		break
+	}
+	for len(r) > 1 && len(a) > 1 && r[0] == a[0] {
+		r, a = r[1:], a[1:]
+		pos++
+	}
+	v.Pos, v.Ref, v.Alt[0] = pos, string(r), string(a)
+}
+
+// refBase returns the upper case reference base at the 1-based position pos of chr.
+func refBase(ref *fai.Seeker, chr string, pos int) byte {
+	seq, err := ref.SeekByName(chr, pos-1, pos)
+	exception.PanicOnErr(err)
+	return byte(dna.BaseToRune(dna.ToUpper(seq[0])))
+}
@@ -0,0 +1,55 @@
+package mcscall
+
+import (
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/vertgenlab/gonomics/vcf"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// testRef returns a seeker of a reference with seq as chr1.
+func testRef(t *testing.T, seq string) *fai.Seeker {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "ref.fa"), []byte(">chr1\n"+seq+"\n"), 0644)
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, "ref.fa.fai"), []byte("chr1\t"+strconv.Itoa(len(seq))+"\t6\t"+strconv.Itoa(len(seq))+"\t"+strconv.Itoa(len(seq)+1)+"\n"), 0644)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := fai.NewSeeker(filepath.Join(dir, "ref.fa"))
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestNormalize(t *testing.T) {
+	//                  1234567890
+	ref := testRef(t, "GCACACAGTT")
+	tests := []struct {
+		pos         int
+		ref, alt    string
+		minPos      int
+		expectedPos int
+		expectedRef string
+		expectedAlt string
+	}{
+		{5, "ACA", "A", 1, 1, "GCA", "G"},   // deletion of the last CA of the repeat
+		{7, "A", "ACA", 1, 1, "G", "GCA"},   // insertion after the repeat
+		{8, "G", "GT", 1, 8, "G", "GT"},     // nothing to shift
+		{3, "ACAC", "AC", 1, 1, "GCA", "G"}, // longer deletion
+		{9, "TT", "T", 1, 8, "GT", "G"},     // homopolymer
+		{4, "CA", "CTTA", 1, 4, "C", "CTT"}, // trailing base trimmed
+		{1, "GCA", "CA", 1, 1, "GC", "C"},   // deletion of the first base keeps the base after it
+		{5, "ACA", "A", 4, 4, "CAC", "C"},   // not moved before the family
+		{5, "ACA", "A", 9, 5, "ACA", "A"},   // nor after its own position
+	}
+	for _, test := range tests {
+		v := vcf.Vcf{Chr: "chr1", Pos: test.pos, Ref: test.ref, Alt: []string{test.alt}}
+		normalize(&v, ref, test.minPos)
+		if v.Pos != test.expectedPos || v.Ref != test.expectedRef || v.Alt[0] != test.expectedAlt {
+			t.Errorf("%d %s>%s: expected %d %s>%s, got %d %s>%s", test.pos, test.ref, test.alt, test.expectedPos, test.expectedRef, test.expectedAlt, v.Pos, v.Ref, v.Alt[0])
+		}
+	}
+}
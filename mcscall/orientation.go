@@ -0,0 +1,149 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// orientationBiasField is the FORMAT field of the orientation bias p-values of a call.
+const orientationBiasField = "OB"
+
+// orientationCounts returns the reads of p that carry the alt allele of v and the other
+// reads, each split into forward and reverse orientation. N-masked bases are not counted.
+func orientationCounts(p sam.Pile, v vcf.Vcf) (altF, altR, otherF, otherR int) {
+	switch {
+	case len(v.Ref) > len(v.Alt[0]):
+		altF, altR = p.DelCountF[len(v.Ref)-len(v.Alt[0])], p.DelCountR[len(v.Ref)-len(v.Alt[0])]
+	case len(v.Alt[0]) > len(v.Ref):
+		altF, altR = p.InsCountF[v.Alt[0][1:]], p.InsCountR[v.Alt[0][1:]]
+	default:
+		b := dna.StringToBase(v.Alt[0])
+		altF, altR = p.CountF[b], p.CountR[b]
+	}
+	for i := range p.CountF {
+		if i == int(dna.N) {
+			continue
+		}
+		otherF += p.CountF[i]
+		otherR += p.CountR[i]
+	}
+	otherF -= altF // the alt reads are also counted in the bases, or gaps, of the pile
+	otherR -= altR
+	if otherF < 0 {
+		otherF = 0
+	}
+	if otherR < 0 {
+		otherR = 0
+	}
+	return
+}
+
+// orientationBias returns the two-sided Fisher's exact test p-value that the alt allele
+// of v is carried by reads of one orientation more often than the other reads of the
+// pile of a strand. Support from a single orientation of an otherwise balanced strand
+// is typical of mapping artifacts.
+func orientationBias(p sam.Pile, v vcf.Vcf) float64 {
+	altF, altR, otherF, otherR := orientationCounts(p, v)
+	return fisherExact(altF, altR, otherF, otherR)
+}
+
+// fisherExact returns the two-sided Fisher's exact test p-value of the 2x2 table
+// [a b; c d], summing the probabilities of the tables with the same margins that are
+// no more likely than the one given.
+func fisherExact(a, b, c, d int) float64 {
+	row1, col1, n := a+b, a+c, a+b+c+d
+	if n == 0 {
+		return 1
+	}
+	logProb := func(x int) float64 {
+		return logChoose(row1, x) + logChoose(n-row1, col1-x) - logChoose(n, col1)
+	}
+	observed := logProb(a)
+	lo, hi := col1-(n-row1), row1
+	if lo < 0 {
+		lo = 0
+	}
+	if col1 < hi {
+		hi = col1
+	}
+	var p float64
+	for x := lo; x <= hi; x++ {
+		if lp := logProb(x); lp <= observed+1e-7 {
+			p += math.Exp(lp)
+		}
+	}
+	if p > 1 {
+		return 1
+	}
+	return p
+}
+
+// logChoose returns the log of n choose k.
+func logChoose(n, k int) float64 {
+	lgN, _ := math.Lgamma(float64(n + 1))
+	lgK, _ := math.Lgamma(float64(k + 1))
+	lgNK, _ := math.Lgamma(float64(n - k + 1))
+	return lgN - lgK - lgNK
+}
+
+// checkOrientation adds the orientation bias p-values of the watson and crick piles of
+// call v to its FORMAT as OB, with OrientationBias or MinOrientationP, and reports
+// whether v is kept. A call with a p-value below MinOrientationP on either strand fails
+// the orientation_bias filter, which is added to failed.
+func (c *Caller) checkOrientation(v *vcf.Vcf, wPile, cPile sam.Pile, failed *string) bool {
+	if !c.OrientationBias && c.MinOrientationP == 0 {
+		return true
+	}
+	watson, crick := orientationBias(wPile, *v), orientationBias(cPile, *v)
+	v.Format = append(v.Format, orientationBiasField)
+	v.Samples[0].FormatData = append(v.Samples[0].FormatData, formatOrientationBias(watson, crick))
+	if watson < c.MinOrientationP || crick < c.MinOrientationP {
+		return c.fail(OrientationBiasFilter, failed)
+	}
+	return true
+}
+
+func formatOrientationBias(watson, crick float64) string {
+	return strconv.FormatFloat(watson, 'g', 3, 64) + "," + strconv.FormatFloat(crick, 'g', 3, 64)
+}
+
+// minOrientationBias returns the OB of the SNV calls of an MNV, with the lowest p-value
+// of each strand, or "" if the calls have no OB.
+func minOrientationBias(run []vcf.Vcf) string {
+	if formatIndex(run[0], orientationBiasField) < 0 {
+		return ""
+	}
+	watson, crick := 1.0, 1.0
+	for _, snv := range run {
+		i := formatIndex(snv, orientationBiasField)
+		if i < 0 {
+			continue
+		}
+		w, cr, _ := strings.Cut(snv.Samples[0].FormatData[i], ",")
+		if p, err := strconv.ParseFloat(w, 64); err == nil && p < watson {
+			watson = p
+		}
+		if p, err := strconv.ParseFloat(cr, 64); err == nil && p < crick {
+			crick = p
+		}
+	}
+	return formatOrientationBias(watson, crick)
+}
+
+// AddOrientationHeader adds the FORMAT line of the orientation bias p-values to h.
+func AddOrientationHeader(h vcf.Header) vcf.Header {
+	line := fmt.Sprintf("##FORMAT=<ID=%s,Number=2,Type=Float,Description=\"Fisher's exact test p-value of orientation bias of the alt reads of the watson and crick strands\">", orientationBiasField)
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), line), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, line)
+	return h
+}
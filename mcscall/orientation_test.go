@@ -0,0 +1,40 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"math"
+	"testing"
+)
+
+func TestFisherExact(t *testing.T) {
+	tests := []struct {
+		a, b, c, d int
+		expected   float64
+	}{
+		{3, 1, 1, 3, 0.4857},
+		{8, 0, 0, 8, 0.0002},
+		{4, 4, 4, 4, 1},
+		{0, 0, 0, 0, 1},
+	}
+	for _, test := range tests {
+		if p := fisherExact(test.a, test.b, test.c, test.d); math.Abs(p-test.expected) > 1e-4 {
+			t.Errorf("[%d %d; %d %d]: expected %v, got %v", test.a, test.b, test.c, test.d, test.expected, p)
+		}
+	}
+}
+
+func TestOrientationCounts(t *testing.T) {
+	var p sam.Pile
+	p.CountF[dna.A], p.CountR[dna.A] = 5, 5
+	p.CountF[dna.T], p.CountR[dna.T] = 8, 0
+	p.CountF[dna.N] = 3
+	altF, altR, otherF, otherR := orientationCounts(p, vcf.Vcf{Ref: "A", Alt: []string{"T"}})
+	if altF != 8 || altR != 0 || otherF != 5 || otherR != 5 {
+		t.Errorf("expected 8 0 5 5, got %d %d %d %d", altF, altR, otherF, otherR)
+	}
+	if orientationBias(p, vcf.Vcf{Ref: "A", Alt: []string{"T"}}) > 0.05 {
+		t.Error("expected alt reads from one orientation to be biased")
+	}
+}
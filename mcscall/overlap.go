@@ -0,0 +1,148 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"sort"
+)
+
+// mergeOverlappingMates returns reads with the overlap of each read pair counted once.
+// Where the mates overlap, the base of the mate that starts first is kept, taking the
+// base of the other mate where it has the higher quality, and the overlap is soft
+// clipped from the other mate, which is dropped if it lies within the first. The
+// cigars of the clipped mates are copied, so reads keep their alignments. As a
+// clipped mate starts later than it did, the reads are sorted by position again for
+// the pileup.
+func mergeOverlappingMates(reads []sam.Sam) []sam.Sam {
+	first := make(map[string]int, len(reads))
+	var clipped map[int]int // read index to query bases clipped
+	for i := range reads {
+		j, found := first[reads[i].QName]
+		if !found {
+			first[reads[i].QName] = i
+			continue
+		}
+		a, b := j, i
+		if reads[b].Pos < reads[a].Pos {
+			a, b = b, a
+		}
+		if reads[a].RName != reads[b].RName || reads[b].GetChromStart() >= reads[a].GetChromEnd() {
+			continue
+		}
+		mergeQuals(&reads[a], reads[b])
+		if clipped == nil {
+			clipped = make(map[int]int)
+		}
+		clipped[b] = overlapQueryLen(reads[b], reads[a].GetChromEnd())
+	}
+	if clipped == nil {
+		return reads
+	}
+
+	ans := make([]sam.Sam, 0, len(reads))
+	for i := range reads {
+		n, found := clipped[i]
+		if !found {
+			ans = append(ans, reads[i])
+			continue
+		}
+		if n < 0 { // within its mate
+			continue
+		}
+		r := reads[i]
+		r.Cigar = append([]cigar.Cigar(nil), r.Cigar...)
+		clipFwd(&r, n)
+		for len(r.Cigar) > 1 && r.Cigar[1].Op == 'D' {
+			r.Pos += uint32(r.Cigar[1].RunLength)
+			r.Cigar = append(r.Cigar[:1], r.Cigar[2:]...)
+		}
+		sclipTerminalIns(&r)
+		ans = append(ans, r)
+	}
+	sort.SliceStable(ans, func(i, j int) bool { return ans[i].Pos < ans[j].Pos })
+	return ans
+}
+
+// mergeQuals replaces the bases of a aligned to the same reference position as a base
+// of its mate b with the base of b where it has the higher quality or a is N-masked.
+func mergeQuals(a *sam.Sam, b sam.Sam) {
+	var qual []byte
+	for pos := int(b.Pos); pos <= a.GetChromEnd(); pos++ {
+		i, okA := queryIndex(*a, pos)
+		j, okB := queryIndex(b, pos)
+		if !okA || !okB || b.Seq[j] == dna.N {
+			continue
+		}
+		hasQual := i < len(a.Qual) && j < len(b.Qual)
+		if a.Seq[i] != dna.N && (!hasQual || b.Qual[j] <= a.Qual[i]) {
+			continue
+		}
+		a.Seq[i] = b.Seq[j]
+		if hasQual {
+			if qual == nil {
+				qual = []byte(a.Qual)
+			}
+			qual[i] = b.Qual[j]
+		}
+	}
+	if qual != nil {
+		a.Qual = string(qual)
+	}
+}
+
+// overlapQueryLen returns the number of aligned query bases of r before the 0-based
+// reference position end, or -1 if r ends before end.
+func overlapQueryLen(r sam.Sam, end int) int {
+	if r.GetChromEnd() <= end {
+		return -1
+	}
+	refPos := r.GetChromStart()
+	var n int
+	for _, op := range r.Cigar {
+		if refPos >= end {
+			break
+		}
+		switch op.Op {
+		case 'M', '=', 'X':
+			if refPos+op.RunLength > end {
+				return n + end - refPos
+			}
+			n += op.RunLength
+			refPos += op.RunLength
+		case 'I':
+			n += op.RunLength
+		case 'D', 'N':
+			refPos += op.RunLength
+		}
+	}
+	return n
+}
+
+// queryIndex returns the index in the query of r of the base aligned to the 1-based
+// reference position pos, and false if r does not cover pos or has a deletion there.
+func queryIndex(r sam.Sam, pos int) (int, bool) {
+	refPos := int(r.Pos)
+	var queryPos int
+	for _, op := range r.Cigar {
+		switch op.Op {
+		case 'M', '=', 'X':
+			if pos < refPos+op.RunLength {
+				if pos < refPos {
+					return 0, false
+				}
+				return queryPos + pos - refPos, true
+			}
+			refPos += op.RunLength
+			queryPos += op.RunLength
+		case 'D', 'N':
+			if pos < refPos+op.RunLength {
+				return 0, false
+			}
+			refPos += op.RunLength
+		case 'I', 'S':
+			queryPos += op.RunLength
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,69 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"strings"
+	"testing"
+)
+
+func TestMergeOverlappingMates(t *testing.T) {
+	reads := []sam.Sam{
+		testRead("a", 10, "6M", "AAAAAA"),
+		testRead("b", 10, "4M", "CCCC"),
+		testRead("a", 14, "2M2D4M", "GTTTTT"),
+		testRead("b", 11, "2M", "CC"),
+	}
+	for i, qual := range []string{"IIIII#", "IIII", "IIIIII", "II"} {
+		reads[i].RName, reads[i].Qual = "chr1", qual
+	}
+	ans := mergeOverlappingMates(reads)
+	if len(ans) != 3 {
+		t.Fatalf("expected the mate within its pair to be dropped, got %d reads", len(ans))
+	}
+
+	// the first mate takes the higher quality base at 15
+	if s := dna.BasesToString(ans[0].Seq); s != "AAAAAT" || ans[0].Qual != "IIIIII" {
+		t.Errorf("unexpected first mate %s %s", s, ans[0].Qual)
+	}
+
+	// the overlap at 14 and 15, and the deletion after it, are clipped from the second
+	if r := ans[2]; r.Pos != 18 || cigar.ToString(r.Cigar) != "2S4M" {
+		t.Errorf("unexpected second mate %d %s", r.Pos, cigar.ToString(r.Cigar))
+	}
+	if r := reads[2]; r.Pos != 14 || cigar.ToString(r.Cigar) != "2M2D4M" {
+		t.Errorf("expected the input read to be unchanged, got %d %s", r.Pos, cigar.ToString(r.Cigar))
+	}
+}
+
+func TestPileupOverlappingMatesOrder(t *testing.T) {
+	// the second mate of a is clipped to start at 20, after b starts at 17
+	reads := []sam.Sam{
+		testRead("a", 10, "10M", strings.Repeat("A", 10)),
+		testRead("a", 15, "10M", strings.Repeat("A", 10)),
+		testRead("b", 17, "6M", strings.Repeat("A", 6)),
+		testRead("b", 19, "6M", strings.Repeat("A", 6)),
+	}
+	for i := range reads {
+		reads[i].RName, reads[i].Qual = "chr1", strings.Repeat("I", len(reads[i].Seq))
+	}
+	merged := mergeOverlappingMates(append([]sam.Sam(nil), reads...))
+	for i := 1; i < len(merged); i++ {
+		if merged[i].Pos < merged[i-1].Pos {
+			t.Fatalf("reads are not sorted after merging mates: %d after %d", merged[i].Pos, merged[i-1].Pos)
+		}
+	}
+
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 100}}, nil, sam.Coordinate, sam.None)
+	depth := make(map[uint32]int)
+	for _, p := range Pileup(reads, header, false) {
+		depth[p.Pos] = calcDepth(p)
+	}
+	for pos, expected := range map[uint32]int{10: 1, 17: 2, 20: 2, 23: 2, 24: 2} {
+		if depth[pos] != expected {
+			t.Errorf("expected depth %d at %d, got %d", expected, pos, depth[pos])
+		}
+	}
+}
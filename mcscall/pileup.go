@@ -0,0 +1,278 @@
+package mcscall
+
+import (
+	"github.com/dasnellings/duplexTools/pool"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"golang.org/x/exp/maps"
+)
+
+// Pileup returns the piles of reads sorted by position. Terminal insertions are
+// converted to soft clips, and unless countOverlappingPairs is set, the overlap of a
+// read pair is only counted once (see mergeOverlappingMates).
+func Pileup(reads []sam.Sam, header sam.Header, countOverlappingPairs bool) []sam.Pile {
+	if len(reads) == 0 {
+		return nil
+	}
+
+	for i := range reads {
+		sclipTerminalIns(&reads[i])
+	}
+	if !countOverlappingPairs {
+		reads = mergeOverlappingMates(reads)
+	}
+	samChan := make(chan sam.Sam, len(reads))
+	for i := range reads {
+		samChan <- reads[i]
+	}
+	close(samChan)
+
+	ans := pool.Piles.Get(100)
+	pileChan := sam.GoPileup(samChan, header, false, nil, nil)
+	for p := range pileChan {
+		ans = append(ans, p)
+	}
+	return ans
+}
+
+// RemovePositionalOutliers removes piles that fall outside the most common start and
+// end positions of the forward and reverse reads of a family.
+func RemovePositionalOutliers(watsonPiles, crickPiles []sam.Pile, watsonReads, crickReads []sam.Sam) (filteredWatsonPiles, filteredCrickPiles []sam.Pile) {
+	filteredWatsonPiles = pool.Piles.Get(len(watsonPiles))
+	filteredCrickPiles = pool.Piles.Get(len(crickPiles))
+
+	fwdStartMap := make(map[int]int)
+	fwdEndMap := make(map[int]int)
+	revStartMap := make(map[int]int)
+	revEndMap := make(map[int]int)
+
+	for i := range watsonReads {
+		if sam.IsPosStrand(watsonReads[i]) {
+			fwdStartMap[watsonReads[i].GetChromStart()]++
+			fwdEndMap[watsonReads[i].GetChromEnd()]++
+		} else {
+			revStartMap[watsonReads[i].GetChromStart()]++
+			revEndMap[watsonReads[i].GetChromEnd()]++
+		}
+	}
+	for i := range crickReads {
+		if sam.IsPosStrand(crickReads[i]) {
+			fwdStartMap[crickReads[i].GetChromStart()]++
+			fwdEndMap[crickReads[i].GetChromEnd()]++
+		} else {
+			revStartMap[crickReads[i].GetChromStart()]++
+			revEndMap[crickReads[i].GetChromEnd()]++
+		}
+	}
+
+	var fwdStart, fwdEnd, revStart, revEnd, maxCount int
+	for key, val := range fwdStartMap {
+		if val > maxCount || (val == maxCount && key < fwdStart) {
+			fwdStart = key
+			maxCount = val
+		}
+	}
+	maxCount = 0
+	for key, val := range fwdEndMap {
+		if val > maxCount || (val == maxCount && key > fwdEnd) {
+			fwdEnd = key
+			maxCount = val
+		}
+	}
+	maxCount = 0
+	for key, val := range revStartMap {
+		if val > maxCount || (val == maxCount && key < revStart) {
+			revStart = key
+			maxCount = val
+		}
+	}
+	maxCount = 0
+	for key, val := range revEndMap {
+		if val > maxCount || (val == maxCount && key > revEnd) {
+			revEnd = key
+			maxCount = val
+		}
+	}
+
+	for i := range watsonPiles {
+		if (int(watsonPiles[i].Pos) > fwdStart && int(watsonPiles[i].Pos) < fwdEnd) ||
+			(int(watsonPiles[i].Pos) > revStart && int(watsonPiles[i].Pos) < revEnd) {
+			filteredWatsonPiles = append(filteredWatsonPiles, watsonPiles[i])
+		}
+	}
+
+	for i := range crickPiles {
+		if (int(crickPiles[i].Pos) > fwdStart && int(crickPiles[i].Pos) < fwdEnd) ||
+			(int(crickPiles[i].Pos) > revStart && int(crickPiles[i].Pos) < revEnd) {
+			filteredCrickPiles = append(filteredCrickPiles, crickPiles[i])
+		}
+	}
+	return
+}
+
+func sumPiles(a, b sam.Pile) sam.Pile {
+	var ans sam.Pile
+	ans.Pos = a.Pos
+	ans.RefIdx = a.RefIdx
+	for i := range ans.CountF {
+		ans.CountF[i] = a.CountF[i] + b.CountF[i]
+		ans.CountR[i] = a.CountR[i] + b.CountR[i]
+	}
+
+	ans.InsCountF = make(map[string]int)
+	ans.InsCountR = make(map[string]int)
+	ans.DelCountF = make(map[int]int)
+	ans.DelCountR = make(map[int]int)
+
+	maps.Copy(ans.InsCountF, a.InsCountF)
+	maps.Copy(ans.InsCountR, a.InsCountR)
+	maps.Copy(ans.DelCountF, a.DelCountF)
+	maps.Copy(ans.DelCountR, a.DelCountR)
+
+	for key, val := range b.InsCountF {
+		ans.InsCountF[key] += val
+	}
+	for key, val := range b.InsCountR {
+		ans.InsCountR[key] += val
+	}
+	for key, val := range b.DelCountF {
+		ans.DelCountF[key] += val
+	}
+	for key, val := range b.DelCountR {
+		ans.DelCountR[key] += val
+	}
+	return ans
+}
+
+type variantType byte
+
+const (
+	snv variantType = iota
+	insertion
+	deletion
+	none
+)
+
+func maxBase(p sam.Pile) (tp variantType, snvAltBase dna.Base, insSeq string, delLen int, altAlleleCount, maxInsCount int) {
+	var maxSnvCount, maxDelCount int
+
+	// check SNV
+	for i := 0; i < len(p.CountF); i++ {
+		if i == int(dna.Gap) || i == int(dna.N) { // deletions handled below, ignore Ns
+			continue
+		}
+		if p.CountF[i]+p.CountR[i] > maxSnvCount {
+			snvAltBase = dna.Base(i)
+			maxSnvCount = p.CountF[i] + p.CountR[i]
+		}
+	}
+
+	// ties are broken by the shorter deletion and the lexically smaller insertion, so
+	// the call does not depend on map iteration order
+
+	// check Del Fwd
+	for key := range p.DelCountF {
+		if p.DelCountF[key]+p.DelCountR[key] > maxDelCount || (p.DelCountF[key]+p.DelCountR[key] == maxDelCount && key < delLen) {
+			delLen = key
+			maxDelCount = p.DelCountF[key] + p.DelCountR[key]
+		}
+	}
+
+	// check Del Rev
+	for key := range p.DelCountR {
+		if p.DelCountF[key]+p.DelCountR[key] > maxDelCount || (p.DelCountF[key]+p.DelCountR[key] == maxDelCount && key < delLen) {
+			delLen = key
+			maxDelCount = p.DelCountF[key] + p.DelCountR[key]
+		}
+	}
+
+	// check Ins Fwd
+	for key := range p.InsCountF {
+		if p.InsCountF[key]+p.InsCountR[key] > maxInsCount || (p.InsCountF[key]+p.InsCountR[key] == maxInsCount && key < insSeq) {
+			insSeq = key
+			maxInsCount = p.InsCountF[key] + p.InsCountR[key]
+		}
+	}
+
+	// check Ins Rev
+	for key := range p.InsCountR {
+		if p.InsCountF[key]+p.InsCountR[key] > maxInsCount || (p.InsCountF[key]+p.InsCountR[key] == maxInsCount && key < insSeq) {
+			insSeq = key
+			maxInsCount = p.InsCountF[key] + p.InsCountR[key]
+		}
+	}
+
+	// score and return winner
+	if maxSnvCount > maxInsCount && maxSnvCount > maxDelCount {
+		tp = snv
+		altAlleleCount = maxSnvCount
+		return
+	}
+
+	if maxInsCount > maxDelCount {
+		tp = insertion
+		altAlleleCount = maxInsCount
+		return
+	}
+
+	if delLen > 0 {
+		tp = deletion
+		altAlleleCount = maxDelCount
+		return
+	}
+
+	tp = none
+	return
+}
+
+// calcDepth returns the number of reads in the input pile
+func calcDepth(s sam.Pile) int {
+	var depth int
+	for i := range s.CountF {
+		if i == int(dna.N) {
+			continue
+		}
+		depth += s.CountF[i] + s.CountR[i]
+	}
+	return depth
+}
+
+func pileDepth(p sam.Pile, baseQualPenalty float64) float64 {
+	var depth float64
+	var maskCount int
+	for i := range p.CountF {
+		if i == int(dna.N) {
+			maskCount += p.CountF[i] + p.CountR[i]
+			continue
+		}
+		depth += float64(p.CountF[i] + p.CountR[i])
+	}
+	depth += float64(maskCount) * baseQualPenalty
+	return depth
+}
+
+// sclipTerminalIns will convert an insertion on the left or right end of the read to a soft clip
+func sclipTerminalIns(s *sam.Sam) {
+	if len(s.Cigar) == 0 || s.Cigar[0].Op == '*' {
+		return
+	}
+	if s.Cigar[0].Op == 'I' {
+		s.Cigar[0].Op = 'S'
+	}
+	if s.Cigar[len(s.Cigar)-1].Op == 'I' {
+		s.Cigar[len(s.Cigar)-1].Op = 'S'
+	}
+
+	// catch case where beginning/end of read is already soft clipped
+	if len(s.Cigar) >= 2 && s.Cigar[0].Op == 'S' && s.Cigar[1].Op == 'I' {
+		s.Cigar[1].Op = 'S'
+		s.Cigar[1].RunLength += s.Cigar[0].RunLength
+		s.Cigar = s.Cigar[1:]
+	}
+
+	if len(s.Cigar) >= 2 && s.Cigar[len(s.Cigar)-1].Op == 'S' && s.Cigar[len(s.Cigar)-2].Op == 'I' {
+		s.Cigar[len(s.Cigar)-2].Op = 'S'
+		s.Cigar[len(s.Cigar)-2].RunLength += s.Cigar[len(s.Cigar)-1].RunLength
+		s.Cigar = s.Cigar[:len(s.Cigar)-1]
+	}
+}
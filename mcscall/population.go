@@ -0,0 +1,30 @@
+package mcscall
+
+import (
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/vcf"
+)
+
+// checkPopulation adds the population allele frequency of each call found in PopVcf to
+// its INFO field and filters, or with RemovePop drops, calls above MaxPopAf.
+func (c *Caller) checkPopulation(variants []vcf.Vcf) []vcf.Vcf {
+	if c.pop == nil {
+		return variants
+	}
+	kept := variants[:0]
+	for _, v := range variants {
+		if IsRefBlock(v) {
+			kept = append(kept, v)
+			continue
+		}
+		if _, above := c.pop.Annotate(&v, c.MaxPopAf); above {
+			c.reject(PopAfFilter)
+			if c.RemovePop {
+				continue
+			}
+			v.Filter = popaf.AppendFilter(v.Filter, popaf.Filter)
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
@@ -0,0 +1,75 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"math"
+)
+
+// maxQual caps QUAL, as probabilities below 1e-100 are not meaningful.
+const maxQual = 999
+
+// altCount returns the number of reads in p that carry the alt allele of v.
+func altCount(p sam.Pile, v vcf.Vcf) int {
+	switch {
+	case len(v.Ref) > len(v.Alt[0]):
+		return p.DelCountF[len(v.Ref)-len(v.Alt[0])] + p.DelCountR[len(v.Ref)-len(v.Alt[0])]
+	case len(v.Alt[0]) > len(v.Ref):
+		return p.InsCountF[v.Alt[0][1:]] + p.InsCountR[v.Alt[0][1:]]
+	default:
+		b := dna.StringToBase(v.Alt[0])
+		return p.CountF[b] + p.CountR[b]
+	}
+}
+
+// strandArtifactProb returns the probability that the alt reads of v in the pile of one
+// strand are an artifact. The strand is wrong either through an error early in its
+// amplification, carried by all of its reads, with probability StrandErrorRate, or
+// through independent base errors in at least as many reads as carry the alt allele.
+// Bases below MinBaseQuality are N-masked and not counted, so the base error rate is
+// at most that of MinBaseQuality. Larger families with more alt reads give smaller
+// probabilities. With ConsensusQuality, the base errors of an SNV are instead those of
+// the consensus of the strand at its position, site, which weighs each base by its
+// quality.
+func (c *Caller) strandArtifactProb(p sam.Pile, site consensusSite, v vcf.Vcf) float64 {
+	if c.ConsensusQuality && len(v.Ref) == 1 && len(v.Alt[0]) == 1 {
+		return c.StrandErrorRate + (1-c.StrandErrorRate)*site.errorProb(dna.StringToBase(v.Alt[0]))
+	}
+	n, k := calcDepth(p), altCount(p, v)
+	if k > n {
+		k = n
+	}
+	if k == 0 {
+		return 1
+	}
+	baseError := math.Pow(10, -float64(c.MinBaseQuality)/10)
+	return c.StrandErrorRate + (1-c.StrandErrorRate)*binomialTail(n, k, baseError)
+}
+
+// binomialTail returns the probability of at least k successes in n trials with probability p.
+func binomialTail(n, k int, p float64) float64 {
+	var ans float64
+	lgN, _ := math.Lgamma(float64(n + 1))
+	for i := k; i <= n; i++ {
+		lgI, _ := math.Lgamma(float64(i + 1))
+		lgNI, _ := math.Lgamma(float64(n - i + 1))
+		ans += math.Exp(lgN - lgI - lgNI + float64(i)*math.Log(p) + float64(n-i)*math.Log1p(-p))
+	}
+	if ans > 1 {
+		return 1
+	}
+	return ans
+}
+
+// phred returns the Phred scaled quality of an error probability, rounded to 2 decimals.
+func phred(prob float64) float64 {
+	if prob <= 1e-100 {
+		return maxQual
+	}
+	q := -10 * math.Log10(prob)
+	if q > maxQual {
+		q = maxQual
+	}
+	return math.Round(q*100) / 100
+}
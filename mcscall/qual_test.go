@@ -0,0 +1,41 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestQual(t *testing.T) {
+	c := &Caller{Options: DefaultOptions()}
+	v := vcf.Vcf{Ref: "A", Alt: []string{"T"}}
+	pile := func(alt, ref int) sam.Pile {
+		var p sam.Pile
+		p.CountF[dna.T] = alt
+		p.CountF[dna.A] = ref
+		p.CountF[dna.N] = 5 // masked bases are not counted
+		return p
+	}
+
+	// a single read on one strand is wrong with the strand or base error rate
+	if q := phred(c.strandArtifactProb(pile(1, 0), consensusSite{}, v)); q != 26.99 {
+		t.Errorf("expected QUAL 26.99 for one read, got %v", q)
+	}
+	if q := phred(c.strandArtifactProb(pile(0, 4), consensusSite{}, v)); q != 0 {
+		t.Errorf("expected QUAL 0 without alt reads, got %v", q)
+	}
+	single := phred(c.strandArtifactProb(pile(4, 0), consensusSite{}, v))
+	if single < 29.9 || single > 30 {
+		t.Errorf("expected QUAL near the strand error rate for a large family, got %v", single)
+	}
+	if q := phred(c.strandArtifactProb(pile(1, 1), consensusSite{}, v)); q >= 26.99 {
+		t.Errorf("expected a ref read to lower QUAL, got %v", q)
+	}
+	if q := phred(c.strandArtifactProb(pile(4, 0), consensusSite{}, v) * c.strandArtifactProb(pile(4, 0), consensusSite{}, v)); q < 2*single-0.1 {
+		t.Errorf("expected duplex QUAL near twice the single strand QUAL, got %v", q)
+	}
+	if q := phred(1e-200); q != maxQual {
+		t.Errorf("expected QUAL capped at %d, got %v", maxQual, q)
+	}
+}
@@ -0,0 +1,99 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"sort"
+)
+
+// EndDistInfo is the INFO field with the median distance of a call from the nearer end
+// of the reads that carry it.
+const EndDistInfo = "ED"
+
+// addEndDistance adds the median distance of each call from the nearer end of the reads
+// that carry it to its INFO field. Distances count every base of the read, including
+// those clipped by EndPad, so artifacts near the ends of reads have a small ED. Calls
+// must be at the position of their pile, before normalizeIndels.
+func addEndDistance(variants []vcf.Vcf, reads ...[]sam.Sam) {
+	var dists []int
+	for i := range variants {
+		if IsRefBlock(variants[i]) {
+			continue
+		}
+		var median int
+		if median, dists = endDistance(variants[i], dists[:0], reads...); len(dists) == 0 {
+			continue
+		}
+		variants[i].Info = popaf.AppendInfo(variants[i].Info, fmt.Sprintf("%s=%d", EndDistInfo, median))
+	}
+}
+
+// endDistance returns the median distance of v from the nearer end of the reads that
+// carry it, with the distances of each read appended to dists, which is empty if no
+// read carries v.
+func endDistance(v vcf.Vcf, dists []int, reads ...[]sam.Sam) (int, []int) {
+	for _, strand := range reads {
+		for j := range strand {
+			if idx, ok := altIndex(strand[j], v); ok {
+				dists = append(dists, min(idx, len(strand[j].Seq)-1-idx))
+			}
+		}
+	}
+	if len(dists) == 0 {
+		return 0, dists
+	}
+	sort.Ints(dists)
+	return (dists[(len(dists)-1)/2] + dists[len(dists)/2]) / 2, dists
+}
+
+// altIndex returns the index in the query of r of the alt allele of v, and false if r
+// does not carry it. The index of an SNV or MNV is that of its first base, and that of
+// an insertion or deletion is that of the base before it.
+func altIndex(r sam.Sam, v vcf.Vcf) (int, bool) {
+	ref, alt := v.Ref, v.Alt[0]
+	if len(ref) == len(alt) {
+		first, ok := queryIndex(r, v.Pos)
+		if !ok {
+			return 0, false
+		}
+		for k := range ref {
+			if ref[k] == alt[k] {
+				continue
+			}
+			base, ok := baseAtPos(r, v.Pos+k)
+			if !ok || base != dna.StringToBase(alt[k:k+1]) {
+				return 0, false
+			}
+		}
+		return first, true
+	}
+
+	refPos, queryPos := int(r.Pos), 0 // refPos is that of the next reference base
+	for _, op := range r.Cigar {
+		switch op.Op {
+		case 'M', '=', 'X':
+			refPos += op.RunLength
+			queryPos += op.RunLength
+		case 'S':
+			queryPos += op.RunLength
+		case 'I':
+			if refPos == v.Pos+1 && len(alt) > len(ref) && op.RunLength == len(alt)-len(ref) &&
+				dna.BasesToString(r.Seq[queryPos:queryPos+op.RunLength]) == alt[1:] {
+				return queryPos - 1, true
+			}
+			queryPos += op.RunLength
+		case 'D', 'N':
+			if refPos == v.Pos+1 && len(ref) > len(alt) && op.RunLength == len(ref)-len(alt) {
+				return queryPos - 1, true
+			}
+			refPos += op.RunLength
+		}
+		if refPos > v.Pos+1 {
+			break
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,32 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestAddEndDistance(t *testing.T) {
+	watson := []sam.Sam{
+		testRead("", 10, "2S8M", "NNAAATAAAA"), // T at 13 is 4 bases from the end
+		testRead("", 12, "8M", "ATAAAAAA"),     // 1 base from the start
+		testRead("", 8, "8M", "AAAAATAA"),      // 2 bases from the end
+		testRead("", 10, "8M", "AAAAAAAA"),     // reference
+	}
+	crick := []sam.Sam{
+		testRead("", 10, "3M2I5M", "AAACCAAAAA"), // insertion after 12
+		testRead("", 10, "3M2D5M", "AAAAAAAA"),   // deletion of 13 and 14
+	}
+	variants := []vcf.Vcf{
+		{Pos: 13, Ref: "A", Alt: []string{"T"}, Info: "DS"},
+		{Pos: 12, Ref: "A", Alt: []string{"ACC"}, Info: "SS"},
+		{Pos: 12, Ref: "AAA", Alt: []string{"A"}, Info: "SS"},
+		{Pos: 12, Ref: "A", Alt: []string{"AC"}, Info: "SS"},
+	}
+	addEndDistance(variants, watson, crick)
+	for i, expected := range []string{"DS;ED=2", "SS;ED=2", "SS;ED=2", "SS"} {
+		if variants[i].Info != expected {
+			t.Errorf("call %d: expected %s, got %s", i, expected, variants[i].Info)
+		}
+	}
+}
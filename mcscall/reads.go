@@ -0,0 +1,210 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"golang.org/x/exp/slices"
+)
+
+// ClipReadEnds soft clips clipLen query bases from each end of s, moving the
+// alignment start past any clipped reference bases.
+func ClipReadEnds(s *sam.Sam, clipLen int) {
+	if s.Cigar == nil || len(s.Cigar) == 0 || s.Cigar[0].Op == '*' {
+		return
+	}
+
+	var anyNonClip bool
+	for i := range s.Cigar {
+		if s.Cigar[i].Op != 'S' {
+			anyNonClip = true
+			break
+		}
+	}
+
+	if !anyNonClip {
+		return
+	}
+
+	clipFwd(s, clipLen)
+	clipRev(s, clipLen)
+
+	// collapse cigar if everything is soft clipped
+	if len(s.Cigar) == 2 && s.Cigar[0].Op == 'S' && s.Cigar[1].Op == 'S' {
+		s.Cigar[0].RunLength += s.Cigar[1].RunLength
+		s.Cigar = s.Cigar[:1]
+	}
+
+	//if cigar.QueryLength(s.Cigar) != len(s.Seq) {
+	//	log.Panic("something went horribly wrong with cigar\n", s)
+	//}
+}
+
+func clipFwd(s *sam.Sam, clipLen int) {
+	if clipLen < 1 {
+		return
+	}
+
+	// check if first index is soft clip, if not make a soft clip with len = 0
+	if s.Cigar[0].Op != 'S' {
+		s.Cigar = slices.Insert(s.Cigar, 0, cigar.Cigar{Op: 'S', RunLength: 0})
+	}
+	var numToClip int = clipLen
+	var currNumToClip int
+	for i := 1; numToClip > 0; i++ {
+		// increment pos as well as cigar
+		switch s.Cigar[i].Op {
+		case 'M':
+			currNumToClip = min(s.Cigar[i].RunLength, numToClip)
+			s.Cigar[i].RunLength -= currNumToClip
+			s.Cigar[0].RunLength += currNumToClip
+			s.Pos += uint32(currNumToClip)
+			numToClip -= currNumToClip
+
+		case 'D':
+			s.Pos += uint32(s.Cigar[i].RunLength)
+			s.Cigar[i].RunLength = 0
+
+		case 'I':
+			currNumToClip = min(s.Cigar[i].RunLength, numToClip)
+			s.Cigar[0].RunLength += currNumToClip
+			s.Cigar[i].RunLength -= currNumToClip
+			numToClip -= currNumToClip
+
+		case 'S':
+			s.Cigar = cleanCigar(s.Cigar)
+			return
+		}
+	}
+	s.Cigar = cleanCigar(s.Cigar)
+}
+
+func clipRev(s *sam.Sam, clipLen int) {
+	if clipLen < 1 {
+		return
+	}
+
+	// check if last index is soft clip, if not make a soft clip with len = 0
+	if s.Cigar[len(s.Cigar)-1].Op != 'S' {
+		s.Cigar = append(s.Cigar, cigar.Cigar{Op: 'S', RunLength: 0})
+	}
+	var numToClip int = clipLen
+	var currNumToClip int
+	lastIdx := len(s.Cigar) - 1
+	for i := lastIdx - 1; numToClip > 0; i-- {
+		// increment pos as well as cigar
+		switch s.Cigar[i].Op {
+		case 'M', 'I':
+			currNumToClip = min(s.Cigar[i].RunLength, numToClip)
+			s.Cigar[i].RunLength -= currNumToClip
+			s.Cigar[lastIdx].RunLength += currNumToClip
+			numToClip -= currNumToClip
+
+		case 'D':
+			s.Cigar[i].RunLength = 0
+
+		case 'S':
+			s.Cigar = cleanCigar(s.Cigar)
+			return
+		}
+	}
+	s.Cigar = cleanCigar(s.Cigar)
+}
+
+// MaskLowQualityBases sets bases with quality below minQual to N.
+func MaskLowQualityBases(s *sam.Sam, minQual int) {
+	var currQual uint8
+	for i := range s.Qual {
+		currQual = s.Qual[i] - 33
+		if currQual < uint8(minQual) {
+			s.Seq[i] = dna.N
+		}
+	}
+}
+
+func cleanCigar(c []cigar.Cigar) []cigar.Cigar {
+	// remove all indexes with RunLength of 0
+	for i := 0; i < len(c); i++ {
+		if c[i].RunLength == 0 {
+			c = slices.Delete(c, i, i+1)
+			i--
+		}
+	}
+	return c
+}
+
+func hasSuppAln(r sam.Sam) bool {
+	_, found, err := sam.QueryTag(r, "SA")
+	if err != nil || !found {
+		return false
+	}
+	return true
+}
+
+// SoftClipFraction returns the fraction of bases in r that are soft clipped.
+func SoftClipFraction(r *sam.Sam) float64 {
+	totalLen := len(r.Seq)
+	var sClipCount int
+	for i := range r.Cigar {
+		if r.Cigar[i].Op == 'S' {
+			sClipCount += r.Cigar[i].RunLength
+		}
+	}
+	return float64(sClipCount) / float64(totalLen)
+}
+
+type orientation bool
+
+const (
+	F1R2 orientation = true
+	F2R1 orientation = false
+)
+
+// WatsonIsPlus reports whether the watson reads of a family came from the reference
+// plus strand.
+func WatsonIsPlus(watsonReads, crickReads []sam.Sam) bool {
+	var watsonF1R2Count, watsonF2R1Count int //, crickF1R2Count, crickF2R1Count int
+	for i := range watsonReads {
+		if getOrientation(&watsonReads[i]) == F1R2 {
+			watsonF1R2Count++
+		} else {
+			watsonF2R1Count++
+		}
+	}
+
+	//for i := range crickReads {
+	//	if getOrientation(&crickReads[i]) == F1R2 {
+	//		crickF1R2Count++
+	//	} else {
+	//		crickF2R1Count++
+	//	}
+	//}
+
+	//log.Println(watsonReads[0].Pos, watsonF1R2Count, watsonF2R1Count, crickF1R2Count, crickF2R1Count)
+
+	// Due to the orientation of the META-CS oligos and SBS the plus strand will be F2R1 and minus strand will be F1R2
+	return watsonF1R2Count < watsonF2R1Count
+}
+
+func getOrientation(r *sam.Sam) orientation {
+	if sam.IsForwardRead(*r) {
+		if sam.IsPosStrand(*r) {
+			return F1R2
+		} else {
+			return F2R1
+		}
+	} else { // is reverse read
+		if sam.IsPosStrand(*r) {
+			return F2R1
+		} else {
+			return F1R2
+		}
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
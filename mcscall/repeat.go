@@ -0,0 +1,128 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strings"
+)
+
+// RepeatFilterName is the FILTER of indels in a long homopolymer or dinucleotide repeat
+// when Options.TagRepeat is set.
+const RepeatFilterName = "Repeat"
+
+// maxRepeatScan is the longest repeat measured in the reference, in bases.
+const maxRepeatScan = 200
+
+// repeatUnit returns the homopolymer or dinucleotide unit that the inserted or deleted
+// bases of indel v repeat, or "" if they repeat neither.
+func repeatUnit(v vcf.Vcf) string {
+	seq := v.Alt[0][1:]
+	if len(v.Ref) > len(v.Alt[0]) {
+		seq = v.Ref[1:]
+	}
+	seq = strings.ToUpper(seq)
+	for _, n := range []int{1, 2} {
+		if len(seq) < n || len(seq)%n != 0 {
+			continue
+		}
+		unit := seq[:n]
+		if n == 2 && unit[0] == unit[1] {
+			continue // a homopolymer of even length
+		}
+		if strings.Repeat(unit, len(seq)/n) == seq {
+			return unit
+		}
+	}
+	return ""
+}
+
+// repeatLength returns the length in bases of the run of unit in the reference at the
+// bases after the anchor of indel v, which is left-aligned so the run starts there.
+func repeatLength(v vcf.Vcf, unit string, ref *fai.Seeker, chromSize int) int {
+	end := v.Pos + maxRepeatScan
+	if end > chromSize {
+		end = chromSize
+	}
+	if v.Pos >= end {
+		return 0
+	}
+	seq, err := ref.SeekByName(v.Chr, v.Pos, end) // v.Pos is the 1-based anchor, so the 0-based base after it
+	exception.PanicOnErr(err)
+	bases := dna.StringToBases(unit)
+	var n int
+	for n < len(seq) && dna.ToUpper(seq[n]) == bases[n%len(bases)] {
+		n++
+	}
+	return n
+}
+
+// checkRepeats adds the repeat unit and reference repeat length of each indel in a
+// homopolymer or dinucleotide repeat to its INFO field as RU and RL, and drops, or with
+// TagRepeat filters, those in a homopolymer longer than MaxHomopolymer or a dinucleotide
+// repeat longer than MaxDinucRepeat. Calls must be normalized.
+func (c *Caller) checkRepeats(variants []vcf.Vcf) []vcf.Vcf {
+	if c.MaxHomopolymer == 0 && c.MaxDinucRepeat == 0 {
+		return variants
+	}
+	kept := variants[:0]
+	for _, v := range variants {
+		if IsRefBlock(v) || len(v.Ref) == len(v.Alt[0]) {
+			kept = append(kept, v)
+			continue
+		}
+		unit := repeatUnit(v)
+		if unit == "" {
+			kept = append(kept, v)
+			continue
+		}
+		length := repeatLength(v, unit, c.ref, c.chromSize(v.Chr))
+		v.Info += fmt.Sprintf(";RU=%s;RL=%d", unit, length)
+		limit := c.MaxHomopolymer
+		if len(unit) == 2 {
+			limit = c.MaxDinucRepeat
+		}
+		if limit > 0 && length > limit {
+			c.reject(RepeatFilter)
+			if !c.TagRepeat {
+				continue
+			}
+			v.Filter = popaf.AppendFilter(v.Filter, RepeatFilterName)
+		}
+		kept = append(kept, v)
+	}
+	return kept
+}
+
+// chromSize returns the length of chrom in the bam header.
+func (c *Caller) chromSize(chrom string) int {
+	for _, ci := range c.header.Chroms {
+		if ci.Name == chrom {
+			return ci.Size
+		}
+	}
+	return 0
+}
+
+// AddRepeatHeader adds the lines for the repeat context of indels, and the Repeat FILTER
+// if tag is set, to h.
+func AddRepeatHeader(h vcf.Header, maxHomopolymer, maxDinucRepeat int, tag bool) vcf.Header {
+	lines := []string{
+		"##INFO=<ID=RU,Number=1,Type=String,Description=\"Homopolymer or dinucleotide unit repeated by the inserted or deleted bases\">",
+		"##INFO=<ID=RL,Number=1,Type=Integer,Description=\"Length in bases of the reference repeat of RU at the indel\">",
+	}
+	if tag {
+		lines = append([]string{fmt.Sprintf("##FILTER=<ID=%s,Description=\"Indel in a homopolymer longer than %d bases or a dinucleotide repeat longer than %d bases (0 for no limit)\">", RepeatFilterName, maxHomopolymer, maxDinucRepeat)}, lines...)
+	}
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), lines...), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, lines...)
+	return h
+}
@@ -0,0 +1,41 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestRepeats(t *testing.T) {
+	//      123456789012345678
+	seq := "GCACACAGTTTTTCATGA"
+	ref := testRef(t, seq)
+	tests := []struct {
+		pos      int
+		ref, alt string
+		unit     string
+		length   int
+	}{
+		{1, "GCA", "G", "CA", 6},    // deletion in the CA repeat
+		{1, "G", "GCACA", "CA", 6},  // insertion in the CA repeat
+		{8, "GT", "G", "T", 5},      // homopolymer deletion
+		{8, "G", "GTTT", "T", 5},    // homopolymer insertion
+		{8, "G", "GA", "A", 0},      // homopolymer insertion outside a repeat
+		{13, "TCA", "T", "CA", 2},   // a single CA
+		{15, "ATGA", "A", "", 0},    // neither a homopolymer nor a dinucleotide
+		{17, "G", "GAAAAA", "A", 1}, // at the end of the contig
+	}
+	for _, test := range tests {
+		v := vcf.Vcf{Chr: "chr1", Pos: test.pos, Ref: test.ref, Alt: []string{test.alt}}
+		unit := repeatUnit(v)
+		if unit != test.unit {
+			t.Errorf("%d %s>%s: expected unit %q, got %q", test.pos, test.ref, test.alt, test.unit, unit)
+			continue
+		}
+		if unit == "" {
+			continue
+		}
+		if length := repeatLength(v, unit, ref, len(seq)); length != test.length {
+			t.Errorf("%d %s>%s: expected a repeat of %d bases, got %d", test.pos, test.ref, test.alt, test.length, length)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"sort"
+	"strings"
+)
+
+// SecondaryInfo is the INFO field listing the secondary alleles of a call.
+const SecondaryInfo = "SA"
+
+// addSecondaryAlleles adds the non-reference alleles of the pile, other than the ALT of v,
+// that are carried by at least SecondaryAf of the reads of either strand to the INFO
+// field of v, with the number of watson and crick reads that carry each. SNVs are given
+// by their base, insertions by + and the inserted sequence, and deletions by - and their
+// length, as in samtools mpileup (e.g. SA=G:3:2,+CA:1:0). mergeMnvs adds the position of
+// each allele in an MNV.
+func (c *Caller) addSecondaryAlleles(v *vcf.Vcf, wPile, cPile sam.Pile) {
+	if c.SecondaryAf <= 0 {
+		return
+	}
+	wDepth, cDepth := float64(calcDepth(wPile)), float64(calcDepth(cPile))
+	var alleles []string
+	add := func(allele string, watson, crick int) {
+		if (watson > 0 && float64(watson) >= c.SecondaryAf*wDepth) || (crick > 0 && float64(crick) >= c.SecondaryAf*cDepth) {
+			alleles = append(alleles, fmt.Sprintf("%s:%d:%d", allele, watson, crick))
+		}
+	}
+
+	refBase, altBase := v.Ref[:1], v.Alt[0]
+	var insSeq string
+	var delLen int
+	switch {
+	case len(v.Ref) > len(v.Alt[0]):
+		refBase, altBase, delLen = v.Ref[1:2], "", len(v.Ref)-len(v.Alt[0]) // deletions start at the base before the pile
+	case len(v.Alt[0]) > len(v.Ref):
+		altBase, insSeq = "", v.Alt[0][1:]
+	}
+	for _, b := range []dna.Base{dna.A, dna.C, dna.G, dna.T} {
+		s := dna.BaseToString(b)
+		if s != refBase && s != altBase {
+			add(s, wPile.CountF[b]+wPile.CountR[b], cPile.CountF[b]+cPile.CountR[b])
+		}
+	}
+	for _, seq := range sortedKeys(wPile.InsCountF, wPile.InsCountR, cPile.InsCountF, cPile.InsCountR) {
+		if seq != insSeq {
+			add("+"+seq, wPile.InsCountF[seq]+wPile.InsCountR[seq], cPile.InsCountF[seq]+cPile.InsCountR[seq])
+		}
+	}
+	for _, n := range sortedKeys(wPile.DelCountF, wPile.DelCountR, cPile.DelCountF, cPile.DelCountR) {
+		if n != delLen {
+			add(fmt.Sprintf("-%d", n), wPile.DelCountF[n]+wPile.DelCountR[n], cPile.DelCountF[n]+cPile.DelCountR[n])
+		}
+	}
+	if len(alleles) > 0 {
+		v.Info = popaf.AppendInfo(v.Info, SecondaryInfo+"="+strings.Join(alleles, ","))
+	}
+}
+
+// sortedKeys returns the keys of every map in sorted order.
+func sortedKeys[K string | int](maps ...map[K]int) []K {
+	var keys []K
+	seen := make(map[K]bool)
+	for _, m := range maps {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// AddSecondaryHeader adds the ##INFO line of the secondary alleles to h.
+func AddSecondaryHeader(h vcf.Header, minAf float64) vcf.Header {
+	line := fmt.Sprintf("##INFO=<ID=%s,Number=.,Type=String,Description=\"Other non-reference alleles carried by at least %g of the reads of a strand, as allele:watson reads:crick reads. Insertions are +sequence and deletions -length. In MNV calls each allele is preceded by the position of its base in the MNV, starting at 1.\">", SecondaryInfo, minAf)
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), line), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, line)
+	return h
+}
@@ -0,0 +1,37 @@
+package mcscall
+
+import (
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"testing"
+)
+
+func TestAddSecondaryAlleles(t *testing.T) {
+	var w, c sam.Pile
+	w.CountF[dna.T], w.CountF[dna.G], w.CountF[dna.C] = 5, 4, 1
+	c.CountR[dna.T], c.CountR[dna.C] = 9, 1
+	w.InsCountF = map[string]int{"CA": 2}
+	c.DelCountR = map[int]int{1: 1}
+	caller := &Caller{Options: DefaultOptions()}
+
+	v := vcf.Vcf{Ref: "C", Alt: []string{"T"}, Info: "DS"}
+	caller.addSecondaryAlleles(&v, w, c)
+	if v.Info != "DS" {
+		t.Errorf("expected no secondary alleles by default, got %s", v.Info)
+	}
+
+	caller.SecondaryAf = 0.2
+	caller.addSecondaryAlleles(&v, w, c)
+	if expected := "DS;SA=G:4:0,+CA:2:0"; v.Info != expected {
+		t.Errorf("expected %s, got %s", expected, v.Info)
+	}
+
+	// the deletion called is not listed, and the reference base is the one after the first
+	v = vcf.Vcf{Ref: "AC", Alt: []string{"A"}, Info: "DS"}
+	caller.SecondaryAf = 0.1
+	caller.addSecondaryAlleles(&v, w, c)
+	if expected := "DS;SA=G:4:0,T:5:9,+CA:2:0"; v.Info != expected {
+		t.Errorf("expected %s, got %s", expected, v.Info)
+	}
+}
@@ -0,0 +1,137 @@
+package mcscall
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/dasnellings/duplexTools/metrics"
+	"github.com/vertgenlab/gonomics/vcf"
+)
+
+// Filter is a reason that a read, site, or read family does not produce a call.
+type Filter int
+
+const (
+	SuppAlnFilter         Filter = iota // read has a supplementary alignment
+	SoftClipFilter                      // read is soft clipped more than MaxSoftClipFraction
+	FamilyDepthFilter                   // family has fewer than MinStrandedDepth reads on a strand
+	StrandMismatchFilter                // watson and crick support different alleles
+	MinAfFilter                         // alt allele fraction is below MinAf
+	MinDepthFilter                      // alt allele depth is below MinStrandedDepth or MinTotalDepth
+	MaxVariantsFilter                   // family has more than MaxVariantsPerReadFamily calls
+	NormalFilter                        // call has more than MaxNormalAltReads alt reads in the matched normal
+	PopAfFilter                         // call has a population allele frequency above MaxPopAf
+	OrientationBiasFilter               // alt reads of a strand are biased to one read orientation below MinOrientationP
+	RepeatFilter                        // indel is in a homopolymer or dinucleotide repeat above MaxHomopolymer or MaxDinucRepeat
+	OxoGFilter                          // G>T SNV has an orientation bias p-value below MinOxoGP
+	DeaminationFilter                   // C>T SNV is below MinDeaminationEndDist from the read ends
+	GroupMismatchFilter                 // a strand group covering the call does not carry it
+	numFilters
+)
+
+var filterNames = [numFilters]string{"supp_aln", "soft_clip", "family_depth", "strand_mismatch", "min_af", "min_depth", "max_variants", "normal", "pop_af", "orientation_bias", "repeat", "oxog", "deamination", "group_mismatch"}
+
+// String returns the name of the filter used in metric labels and, with
+// Options.EmitFiltered, in the FILTER column.
+func (f Filter) String() string {
+	return filterNames[f]
+}
+
+// Stats counts the work done by Callers. A single Stats may be shared by Callers
+// running in different goroutines. Reads below MinMapQ are not counted as they are
+// dropped before their family is known.
+type Stats struct {
+	Families metrics.Counter // read families processed
+	Reads    metrics.Counter // reads belonging to the processed families
+	Variants metrics.Counter // variants returned
+	Rejected [numFilters]metrics.Counter
+}
+
+// Register adds the counters in s to r, along with the rate of reads processed
+// since Register was called.
+func (s *Stats) Register(r *metrics.Registry) {
+	start := time.Now()
+	r.Counter("mcscall_families_processed_total", "Read families processed.", &s.Families)
+	r.Counter("mcscall_reads_processed_total", "Reads belonging to processed read families.", &s.Reads)
+	r.Counter("mcscall_variants_emitted_total", "Variants called.", &s.Variants)
+	for f := Filter(0); f < numFilters; f++ {
+		r.Counter("mcscall_rejections_total", "Reads, sites, or read families removed by each filter.", &s.Rejected[f], "filter", f.String())
+	}
+	r.GaugeFunc("mcscall_reads_per_second", "Mean reads processed per second since the start of the run.", func() float64 {
+		return float64(s.Reads.Value()) / time.Since(start).Seconds()
+	})
+}
+
+// Rejections returns the number of rejections by each filter, keyed by filter name.
+func (s *Stats) Rejections() map[string]int64 {
+	ans := make(map[string]int64, numFilters)
+	for f := Filter(0); f < numFilters; f++ {
+		ans[f.String()] = s.Rejected[f].Value()
+	}
+	return ans
+}
+
+// reject counts a rejection by filter f if the Caller has Stats.
+func (c *Caller) reject(f Filter) {
+	if c.Stats != nil {
+		c.Stats.Rejected[f].Inc()
+	}
+}
+
+// callingFilters are the filters that Options.EmitFiltered names in FILTER.
+var callingFilters = []Filter{StrandMismatchFilter, MinAfFilter, MinDepthFilter, MaxVariantsFilter, OrientationBiasFilter, GroupMismatchFilter}
+
+// IsRejected reports whether v is a candidate kept with Options.EmitFiltered that
+// failed a calling filter. Calls only filtered against the normal or a population
+// database are not rejected.
+func IsRejected(v vcf.Vcf) bool {
+	for _, name := range strings.Split(v.Filter, ";") {
+		for _, f := range callingFilters {
+			if name == f.String() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AddFilterHeader adds the ##FILTER lines of the filters set on calls with
+// Options.EmitFiltered to h, and the ##INFO lines of the strand depths added to them.
+func AddFilterHeader(h vcf.Header, opts Options) vcf.Header {
+	lines := []string{
+		fmt.Sprintf("##FILTER=<ID=%s,Description=\"Watson and crick support different alleles\">", StrandMismatchFilter),
+		fmt.Sprintf("##FILTER=<ID=%s,Description=\"Alt allele fraction of a strand below %s\">", MinAfFilter, minAfDescription(opts)),
+		fmt.Sprintf("##FILTER=<ID=%s,Description=\"Alt allele depth below %d on a strand or %d in total\">", MinDepthFilter, opts.MinStrandedDepth, opts.MinTotalDepth),
+		fmt.Sprintf("##FILTER=<ID=%s,Description=\"Read family has more than %d calls\">", MaxVariantsFilter, opts.MaxVariantsPerReadFamily),
+	}
+	if opts.MinOrientationP > 0 {
+		lines = append(lines, fmt.Sprintf("##FILTER=<ID=%s,Description=\"Orientation bias p-value of the alt reads of a strand below %g\">", OrientationBiasFilter, opts.MinOrientationP))
+	}
+	if opts.GroupTag != "" {
+		lines = append(lines, fmt.Sprintf("##FILTER=<ID=%s,Description=\"A strand group of the read family covering the call does not carry it\">", GroupMismatchFilter))
+	}
+	lines = append(lines,
+		"##INFO=<ID=WDP,Number=1,Type=Integer,Description=\"Watson read depth of a candidate that failed a filter\">",
+		"##INFO=<ID=CDP,Number=1,Type=Integer,Description=\"Crick read depth of a candidate that failed a filter\">",
+	)
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "##INFO") || strings.HasPrefix(h.Text[i], "##FORMAT") || strings.HasPrefix(h.Text[i], "#CHROM") {
+			h.Text = append(append(append([]string{}, h.Text[:i]...), lines...), h.Text[i:]...)
+			return h
+		}
+	}
+	h.Text = append(h.Text, lines...)
+	return h
+}
+
+// minAfDescription returns the minimum alt allele fraction of opts, or that of each
+// variant type if they differ.
+func minAfDescription(opts Options) string {
+	c := Caller{Options: opts}
+	snvAf, insAf, delAf := c.minAf(snv), c.minAf(insertion), c.minAf(deletion)
+	if snvAf == insAf && snvAf == delAf {
+		return fmt.Sprintf("%g", snvAf)
+	}
+	return fmt.Sprintf("%g for SNVs, %g for insertions, and %g for deletions", snvAf, insAf, delAf)
+}
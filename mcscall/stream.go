@@ -0,0 +1,142 @@
+package mcscall
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dasnellings/duplexTools/barcode"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/sam"
+	"sync"
+)
+
+// Stream reads the reads of read families from a coordinate sorted bam in a single pass,
+// so families can be called from a bam without an index, such as one piped from
+// samtools view. The bam is read with package hts, so with htslib it may also be a cram.
+// Families are passed to Read in the order of the bam, by contig in the
+// order of the bam header and then by start, and the reads of each are held until a
+// Caller made with NewStreamCaller calls the family. Only the reads that may belong to a
+// later family are buffered. Read must be called from one goroutine, but the Callers
+// may run in any number of others.
+type Stream struct {
+	reads  <-chan sam.Sam
+	header sam.Header
+	chroms map[string]int
+
+	chrom, start int       // contig index and start of the last family read
+	window       []sam.Sam // reads of chrom that may belong to a later family
+	next         sam.Sam   // first read not yet in the window, if ahead
+	nextChrom    int
+	ahead, done  bool
+	lastPos      int // position of the last read, to check the bam is sorted
+
+	mu       sync.Mutex
+	families map[familyKey][]sam.Sam // reads of the families read but not yet called
+}
+
+// ErrUnsorted is wrapped by the errors of Stream.Read for families or reads out of order.
+var ErrUnsorted = errors.New("out of order")
+
+// familyKey identifies a family given to Stream.Read.
+type familyKey struct {
+	chrom string
+	start int
+	name  string
+}
+
+// OpenStream starts reading bamFile, which may be a pipe, for Read.
+func OpenStream(bamFile string) *Stream {
+	s := &Stream{chroms: make(map[string]int), families: make(map[familyKey][]sam.Sam), chrom: -1, nextChrom: -1}
+	s.reads, s.header = hts.GoReadToChan(bamFile)
+	for i, c := range s.header.Chroms {
+		s.chroms[c.Name] = i
+	}
+	return s
+}
+
+// Header returns the header of the bam.
+func (s *Stream) Header() sam.Header {
+	return s.header
+}
+
+// Read reads the bam up to the end of family b and holds the reads of b until they are
+// called. An error is returned if b is not on a contig of the bam header, if b comes
+// before the last family read, or if the bam is unsorted.
+func (s *Stream) Read(b bed.Bed) error {
+	chrom, found := s.chroms[b.Chrom]
+	if !found {
+		return fmt.Errorf("%s of family %s is not in the bam header", b.Chrom, b.Name)
+	}
+	if chrom < s.chrom || (chrom == s.chrom && b.ChromStart < s.start) {
+		return fmt.Errorf("%w: family %s at %s:%d comes before the family read last at %s:%d. Families must be sorted in the order of the contigs of the bam header, then by start", ErrUnsorted, b.Name, b.Chrom, b.ChromStart+1, s.header.Chroms[s.chrom].Name, s.start+1)
+	}
+	if chrom != s.chrom {
+		s.window = nil
+	}
+	s.chrom, s.start = chrom, b.ChromStart
+
+	for !s.done {
+		if !s.ahead {
+			if err := s.readNext(); err != nil {
+				return err
+			}
+			continue
+		}
+		if s.nextChrom > chrom || (s.nextChrom == chrom && s.next.GetChromStart() >= b.ChromEnd) {
+			break
+		}
+		if s.nextChrom == chrom && s.next.GetChromEnd() > b.ChromStart { // reads ending before b are in no later family
+			s.window = append(s.window, s.next)
+		}
+		s.ahead = false
+	}
+
+	var reads []sam.Sam
+	kept := s.window[:0]
+	for i := range s.window {
+		switch {
+		case s.window[i].GetChromEnd() <= b.ChromStart:
+		case s.window[i].GetChromStart() < b.ChromEnd && barcode.GetRF(&s.window[i]) == b.Name:
+			reads = append(reads, s.window[i])
+		default:
+			kept = append(kept, s.window[i])
+		}
+	}
+	s.window = kept
+
+	s.mu.Lock()
+	s.families[familyKey{b.Chrom, b.ChromStart, b.Name}] = reads
+	s.mu.Unlock()
+	return nil
+}
+
+// readNext takes the next read of the bam as next. Unmapped reads without a position,
+// which are sorted last, end the stream.
+func (s *Stream) readNext() error {
+	r, ok := <-s.reads
+	if !ok {
+		s.done = true
+		return nil
+	}
+	chrom, found := s.chroms[r.RName]
+	if !found {
+		s.done = true
+		return nil
+	}
+	if chrom < s.nextChrom || (chrom == s.nextChrom && int(r.Pos) < s.lastPos) {
+		return fmt.Errorf("%w: bam is not sorted by coordinate, %s at %s:%d comes after %s:%d", ErrUnsorted, r.QName, r.RName, r.Pos, s.header.Chroms[s.nextChrom].Name, s.lastPos)
+	}
+	sam.ParseExtra(&r)
+	s.next, s.nextChrom, s.lastPos, s.ahead = r, chrom, int(r.Pos), true
+	return nil
+}
+
+// take returns the reads of family b and releases them from the Stream.
+func (s *Stream) take(b bed.Bed) []sam.Sam {
+	key := familyKey{b.Chrom, b.ChromStart, b.Name}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	reads := s.families[key]
+	delete(s.families, key)
+	return reads
+}
@@ -0,0 +1,71 @@
+package mcscall
+
+import (
+	"errors"
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/sam"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStream(t *testing.T) {
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr2", Size: 1000}, {Name: "chr1", Size: 1000, Order: 1}}, nil, sam.Coordinate, sam.None)
+	filename := filepath.Join(t.TempDir(), "stream.bam")
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bam.NewWriter(file, header)
+	for _, r := range []sam.Sam{
+		testRead("a", 11, "10M", "ACGTACGTAC", "RF:Z:x"), testRead("b", 15, "10M", "ACGTACGTAC", "RF:Z:y"),
+		testRead("c", 21, "10M", "ACGTACGTAC", "RF:Z:x"), testRead("d", 101, "10M", "ACGTACGTAC", "RF:Z:z"),
+		testRead("e", 1, "10M", "ACGTACGTAC", "RF:Z:v"),
+	} {
+		r.RName, r.MapQ, r.RNext, r.Qual = "chr2", 60, "*", "IIIIIIIIII"
+		if r.QName == "e" {
+			r.RName = "chr1"
+		}
+		w.Write(r)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+
+	s := OpenStream(filename)
+	family := func(chrom string, start, end int, name string) bed.Bed {
+		return bed.Bed{Chrom: chrom, ChromStart: start, ChromEnd: end, Name: name, FieldsInitialized: 4}
+	}
+	names := func(reads []sam.Sam) (ans string) {
+		for _, r := range reads {
+			ans += r.QName
+		}
+		return
+	}
+
+	// families are read in the order of the header, so chr2 comes first
+	x, y := family("chr2", 10, 30, "x"), family("chr2", 14, 24, "y")
+	for _, b := range []bed.Bed{x, y, family("chr1", 0, 10, "v")} {
+		if err = s.Read(b); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := names(s.take(y)); got != "b" {
+		t.Errorf("expected read b in family y, got %s", got)
+	}
+	if got := names(s.take(x)); got != "ac" {
+		t.Errorf("expected reads a and c in family x, got %s", got)
+	}
+	if got := names(s.take(x)); got != "" {
+		t.Errorf("expected the reads of x to be taken once, got %s", got)
+	}
+	if got := names(s.take(family("chr1", 0, 10, "v"))); got != "e" {
+		t.Errorf("expected read e in family v, got %s", got)
+	}
+	if err = s.Read(family("chr2", 100, 110, "z")); !errors.Is(err, ErrUnsorted) {
+		t.Errorf("expected a family of an earlier contig to be out of order, got %v", err)
+	}
+}
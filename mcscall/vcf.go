@@ -0,0 +1,196 @@
+package mcscall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/popaf"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"log"
+	"strings"
+)
+
+type strandType byte
+
+const (
+	doubleStranded strandType = iota
+	singleStranded
+	unStranded
+)
+
+func (s strandType) String() string {
+	switch s {
+	case doubleStranded:
+		return "DS"
+	case singleStranded:
+		return "SS"
+	case unStranded:
+		return "US"
+	default:
+		log.Panicf("Unrecognized strand type: %d", byte(s))
+		return ""
+	}
+}
+
+func snvToVcf(watsonPile, crickPile sam.Pile, chr string, refBase, altBase dna.Base, readFamily string, strandedness strandType, isPlus bool) vcf.Vcf {
+	var v vcf.Vcf
+	v.Chr = chr
+	v.Pos = int(watsonPile.Pos)
+	v.Ref = string(dna.BaseToRune(refBase))
+	v.Alt = []string{string(dna.BaseToRune(altBase))}
+	v.Filter = "."
+	v.Info = strandedness.String()
+	if strandedness == singleStranded {
+		if isPlus {
+			v.Info += ";Strand=+"
+		} else {
+			v.Info += ";Strand=-"
+		}
+	}
+	v.Id = "."
+	v.Format = []string{"GT", "DP", "PS", "MS", "RF"}
+
+	var totalDepth, watsonDepth, crickDepth string
+	totalDepth = fmt.Sprint(calcDepth(watsonPile) + calcDepth(crickPile))
+	watsonDepth = fmt.Sprint(watsonPile.CountF[altBase] + watsonPile.CountR[altBase])
+	crickDepth = fmt.Sprint(crickPile.CountF[altBase] + crickPile.CountR[altBase])
+
+	v.Samples = make([]vcf.Sample, 1)
+	v.Samples[0].Alleles = []int16{1}
+	v.Samples[0].FormatData = []string{"", totalDepth, watsonDepth, crickDepth, readFamily}
+
+	return v
+}
+
+func insToVcf(watsonPile, crickPile sam.Pile, chr string, insSeq string, faSeeker *fai.Seeker, readFamily string, strandedness strandType, isPlus bool) vcf.Vcf {
+	var v vcf.Vcf
+	v.Chr = chr
+	v.Pos = int(watsonPile.Pos)
+
+	refBase, err := faSeeker.SeekByName(chr, int(watsonPile.Pos)-1, int(watsonPile.Pos))
+	dna.AllToUpper(refBase)
+	exception.PanicOnErr(err)
+
+	v.Ref = string(dna.BaseToRune(refBase[0]))
+	v.Alt = []string{string(dna.BaseToRune(refBase[0])) + insSeq}
+	v.Filter = "."
+	v.Info = strandedness.String()
+	if strandedness == singleStranded {
+		if isPlus {
+			v.Info += ";Strand=+"
+		} else {
+			v.Info += ";Strand=-"
+		}
+	}
+	v.Id = "."
+	v.Format = []string{"GT", "DP", "PS", "MS", "RF"}
+
+	var totalDepth, watsonDepth, crickDepth string
+	totalDepth = fmt.Sprint(calcDepth(watsonPile) + calcDepth(crickPile))
+	watsonDepth = fmt.Sprint(watsonPile.InsCountF[insSeq] + watsonPile.InsCountR[insSeq])
+	crickDepth = fmt.Sprint(crickPile.InsCountF[insSeq] + crickPile.InsCountR[insSeq])
+
+	v.Samples = make([]vcf.Sample, 1)
+	v.Samples[0].Alleles = []int16{1}
+	v.Samples[0].FormatData = []string{"", totalDepth, watsonDepth, crickDepth, readFamily}
+	return v
+}
+
+func delToVcf(watsonPile, crickPile sam.Pile, chr string, delLen int, faSeeker *fai.Seeker, readFamily string, strandedness strandType, isPlus bool) vcf.Vcf {
+	var v vcf.Vcf
+	v.Chr = chr
+	v.Pos = int(watsonPile.Pos) - 1
+
+	refBase, err := faSeeker.SeekByName(chr, int(watsonPile.Pos-2), int(watsonPile.Pos-1)+delLen)
+	dna.AllToUpper(refBase)
+	exception.PanicOnErr(err)
+
+	v.Ref = dna.BasesToString(refBase)
+	v.Alt = []string{string(dna.BaseToRune(refBase[0]))}
+	v.Filter = "."
+	v.Info = strandedness.String()
+	if strandedness == singleStranded {
+		if isPlus {
+			v.Info += ";Strand=+"
+		} else {
+			v.Info += ";Strand=-"
+		}
+	}
+	v.Id = "."
+	v.Format = []string{"GT", "DP", "PS", "MS", "RF"}
+
+	var totalDepth, watsonDepth, crickDepth string
+	totalDepth = fmt.Sprint(calcDepth(watsonPile) + calcDepth(crickPile))
+	watsonDepth = fmt.Sprint(watsonPile.DelCountF[delLen] + watsonPile.DelCountR[delLen])
+	crickDepth = fmt.Sprint(crickPile.DelCountF[delLen] + crickPile.DelCountR[delLen])
+
+	v.Samples = make([]vcf.Sample, 1)
+	v.Samples[0].Alleles = []int16{1}
+	v.Samples[0].FormatData = []string{"", totalDepth, watsonDepth, crickDepth, readFamily}
+	return v
+}
+
+// VcfHeader returns the header for calls made from the bam infile against
+// referenceFile. The sample name is infile without the .bam suffix.
+func VcfHeader(infile string, referenceFile string) vcf.Header {
+	var header vcf.Header
+	header.Text = append(header.Text, "##fileformat=VCFv4.2")
+	header.Text = append(header.Text, fmt.Sprintf("##reference=%s", referenceFile))
+	header.Text = append(header.Text, strings.TrimSuffix(fai.VcfContigHeader(referenceFile), "\n"))
+	header.Text = append(header.Text, "##INFO=<ID=DS,Number=0,Type=Flag,Description=\"Variant is double-stranded\">")
+	header.Text = append(header.Text, "##INFO=<ID=SS,Number=0,Type=Flag,Description=\"Variant is single-stranded\">")
+	header.Text = append(header.Text, "##INFO=<ID=US,Number=0,Type=Flag,Description=\"Variant is called with unstranded mode\">")
+	header.Text = append(header.Text, "##INFO=<ID=Strand,Number=1,Type=String,Description=\"Strand the mutation is on (relative to the reference)\">")
+	header.Text = append(header.Text, fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=Integer,Description=\"Median distance of the variant from the nearer end of the reads that carry it\">", EndDistInfo))
+	header.Text = append(header.Text, familySizeHeader()...)
+	header.Text = append(header.Text, "##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Total Read Depth\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=PS,Number=1,Type=Integer,Description=\"Reference Plus Strand Read Depth\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=MS,Number=1,Type=Integer,Description=\"Reference Minus Strand Read Depth\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=RF,Number=1,Type=Integer,Description=\"Read Family Identifier\">")
+	header.Text = append(header.Text, fmt.Sprintf("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t%s", strings.TrimSuffix(infile, ".bam")))
+	return header
+}
+
+// CallHeader returns VcfHeader with the INFO, FILTER, and FORMAT lines of the fields
+// that CallFamily adds to calls made with opts, so the records of a Caller can be
+// written as mcsCallVariants writes them.
+func CallHeader(infile string, referenceFile string, opts Options) vcf.Header {
+	header := AddFamilyInfoHeader(VcfHeader(infile, referenceFile), opts.FamilyInfo)
+	if opts.EmitFiltered {
+		header = AddFilterHeader(header, opts)
+	}
+	if opts.GVCF {
+		header = AddGVCFHeader(header)
+	}
+	if opts.NormalBam != "" {
+		header = AddNormalHeader(header, opts.MaxNormalAltReads, opts.TagNormal)
+	}
+	if opts.SecondaryAf > 0 {
+		header = AddSecondaryHeader(header, opts.SecondaryAf)
+	}
+	if opts.OrientationBias || opts.MinOrientationP > 0 {
+		header = AddOrientationHeader(header)
+	}
+	if opts.MaxHomopolymer > 0 || opts.MaxDinucRepeat > 0 {
+		header = AddRepeatHeader(header, opts.MaxHomopolymer, opts.MaxDinucRepeat, opts.TagRepeat)
+	}
+	if opts.TagDamage && (opts.MinOxoGP > 0 || opts.MinDeaminationEndDist > 0) {
+		header = AddDamageHeader(header, opts)
+	}
+	if opts.GroupTag != "" {
+		header = AddGroupHeader(header)
+	}
+	if opts.ForceCallVcf != "" {
+		header = AddForceCallHeader(header)
+	}
+	if opts.MinBreakendClip > 0 {
+		header = AddBreakendHeader(header)
+	}
+	if opts.PopVcf != "" {
+		header = popaf.AddHeader(header, opts.PopAfField, opts.MaxPopAf, opts.RemovePop)
+	}
+	return header
+}
@@ -0,0 +1,43 @@
+// Package memo parses files once per process and shares the result, so that threads
+// asking for the same file, such as the bam index of every mcsCallVariants worker, do
+// not each parse it. A file is parsed again if its size or modification time changes.
+// The parsed value is shared, so callers must treat it as read-only.
+package memo
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// Files holds the values parsed from files. The zero value is ready to use.
+type Files[T any] struct {
+	mu     sync.Mutex
+	parsed map[string]*parsed[T] // file -> value parsed from it
+}
+
+// parsed is the value of a file as it was when parsed.
+type parsed[T any] struct {
+	size    int64
+	modTime time.Time
+	once    sync.Once
+	value   T
+}
+
+// Get returns the value parse returns for filename, calling it only if filename has not
+// been parsed before or has changed since. info is the os.FileInfo of filename, which
+// callers have to check it exists. Callers asking for a file being parsed wait for it.
+func (f *Files[T]) Get(filename string, info os.FileInfo, parse func(string) T) T {
+	f.mu.Lock()
+	if f.parsed == nil {
+		f.parsed = make(map[string]*parsed[T])
+	}
+	p, found := f.parsed[filename]
+	if !found || p.size != info.Size() || !p.modTime.Equal(info.ModTime()) {
+		p = &parsed[T]{size: info.Size(), modTime: info.ModTime()}
+		f.parsed[filename] = p
+	}
+	f.mu.Unlock()
+	p.once.Do(func() { p.value = parse(filename) })
+	return p.value
+}
@@ -0,0 +1,60 @@
+package memo
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGet(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.txt")
+	if err := os.WriteFile(filename, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var files Files[string]
+	var calls int
+	parse := func(name string) string {
+		calls++
+		b, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	get := func() string {
+		info, err := os.Stat(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files.Get(filename, info, parse)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if actual := get(); actual != "first" {
+				t.Errorf("expected first, got %s", actual)
+			}
+		}()
+	}
+	wg.Wait()
+	if calls != 1 {
+		t.Errorf("expected the file to be parsed once, parsed %d times", calls)
+	}
+
+	// a changed file is parsed again
+	if err := os.WriteFile(filename, []byte("second"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(filename, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if actual := get(); actual != "second" || calls != 2 {
+		t.Errorf("expected the changed file to be parsed again, got %s after %d parses", actual, calls)
+	}
+}
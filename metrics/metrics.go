@@ -0,0 +1,131 @@
+// Package metrics exposes counters over HTTP in the Prometheus text format so that
+// long runs can be monitored while they are in progress.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Counter is a count that only increases. It is safe for concurrent use.
+type Counter struct {
+	v atomic.Int64
+}
+
+// Inc adds one to the counter.
+func (c *Counter) Inc() {
+	c.v.Add(1)
+}
+
+// Add adds n to the counter.
+func (c *Counter) Add(n int) {
+	c.v.Add(int64(n))
+}
+
+// Value returns the current count.
+func (c *Counter) Value() int64 {
+	return c.v.Load()
+}
+
+// metric is a single named series. Exactly one of counter and gauge is set.
+type metric struct {
+	name, help string
+	labels     string // formatted as {key="value",...}, or empty
+	counter    *Counter
+	gauge      func() float64
+}
+
+// Registry holds the metrics served by ServeHTTP.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+}
+
+// Counter adds c to the registry. labels are key, value pairs distinguishing
+// counters that share a name (e.g. "filter", "mapq").
+func (r *Registry) Counter(name, help string, c *Counter, labels ...string) {
+	r.add(metric{name: name, help: help, labels: formatLabels(labels), counter: c})
+}
+
+// GaugeFunc adds a gauge whose value is computed by f each time metrics are served.
+func (r *Registry) GaugeFunc(name, help string, f func() float64) {
+	r.add(metric{name: name, help: help, gauge: f})
+}
+
+func (r *Registry) add(m metric) {
+	r.mu.Lock()
+	r.metrics = append(r.metrics, m)
+	r.mu.Unlock()
+}
+
+// WriteTo writes every metric in the Prometheus text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := make([]metric, len(r.metrics))
+	copy(metrics, r.metrics)
+	r.mu.Unlock()
+	sort.SliceStable(metrics, func(i, j int) bool { return metrics[i].name < metrics[j].name })
+
+	var b strings.Builder
+	for i, m := range metrics {
+		if i == 0 || metrics[i-1].name != m.name {
+			kind := "counter"
+			if m.gauge != nil {
+				kind = "gauge"
+			}
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, kind)
+		}
+		if m.gauge != nil {
+			fmt.Fprintf(&b, "%s%s %g\n", m.name, m.labels, m.gauge())
+		} else {
+			fmt.Fprintf(&b, "%s%s %d\n", m.name, m.labels, m.counter.Value())
+		}
+	}
+	n, err := io.WriteString(w, b.String())
+	return int64(n), err
+}
+
+// ServeHTTP writes the metrics in response to a scrape.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+// Serve listens on addr (e.g. ":9100") and serves the registry at /metrics in the
+// background. It returns once the address is bound so that a busy port is reported
+// before any work starts.
+func Serve(addr string, r *Registry) error {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r)
+	log.Printf("Serving metrics at http://%s/metrics", ln.Addr())
+	go func() {
+		err := http.Serve(ln, mux)
+		log.Printf("WARNING: metrics server stopped: %s", err)
+	}()
+	return nil
+}
+
+func formatLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	if len(labels)%2 != 0 {
+		log.Panicf("metrics labels must be key, value pairs: %v", labels)
+	}
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
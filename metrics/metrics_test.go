@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	var families, mapq, clip Counter
+	r := new(Registry)
+	r.Counter("families_total", "Families processed.", &families)
+	r.Counter("rejections_total", "Rejections by filter.", &mapq, "filter", "mapq")
+	r.Counter("rejections_total", "Rejections by filter.", &clip, "filter", "soft_clip")
+	r.GaugeFunc("reads_per_second", "Reads per second.", func() float64 { return 1.5 })
+	families.Add(3)
+	mapq.Inc()
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	body, _ := io.ReadAll(rec.Body)
+	expected := "# HELP families_total Families processed.\n" +
+		"# TYPE families_total counter\n" +
+		"families_total 3\n" +
+		"# HELP reads_per_second Reads per second.\n" +
+		"# TYPE reads_per_second gauge\n" +
+		"reads_per_second 1.5\n" +
+		"# HELP rejections_total Rejections by filter.\n" +
+		"# TYPE rejections_total counter\n" +
+		"rejections_total{filter=\"mapq\"} 1\n" +
+		"rejections_total{filter=\"soft_clip\"} 0\n"
+	if string(body) != expected {
+		t.Errorf("expected:\n%s\nreceived:\n%s", expected, body)
+	}
+}
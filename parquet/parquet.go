@@ -0,0 +1,295 @@
+// Package parquet writes tables in the Apache Parquet columnar format so that large
+// side outputs (e.g. per-family metrics and per-read repeat lengths) can be loaded by
+// arrow, pandas, duckdb, or spark without parsing text.
+//
+// Files are written with plain encoding and gzip compressed pages. Every column is
+// required (no nulls) and is one of Int64, Double, or String.
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"github.com/dasnellings/duplexTools/provenance"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+	"log"
+	"math"
+	"sort"
+	"strings"
+)
+
+const magic = "PAR1"
+
+// rowGroupSize is the number of rows buffered before they are written as a row group.
+const rowGroupSize = 1 << 20
+
+// Type is the physical type of a column.
+type Type int
+
+const (
+	Int64  Type = iota // parquet INT64
+	Double             // parquet DOUBLE
+	String             // parquet BYTE_ARRAY annotated as UTF8
+)
+
+// parquet enums
+const (
+	typeInt64      = 2
+	typeDouble     = 5
+	typeByteArray  = 6
+	repRequired    = 0
+	convertedUtf8  = 0
+	encodingPlain  = 0
+	encodingRle    = 3
+	codecGzip      = 2
+	pageTypeData   = 0
+	formatVersion  = 1
+	rootSchemaName = "schema"
+)
+
+// Column is the name and type of a column.
+type Column struct {
+	Name string
+	Type Type
+}
+
+func (c Column) physicalType() int32 {
+	switch c.Type {
+	case Int64:
+		return typeInt64
+	case Double:
+		return typeDouble
+	default:
+		return typeByteArray
+	}
+}
+
+// IsParquet reports whether filename should be written as parquet.
+func IsParquet(filename string) bool {
+	return strings.HasSuffix(filename, ".parquet")
+}
+
+// Writer writes rows to a parquet file. Rows are buffered in memory and written
+// one row group at a time, so a Writer holds at most rowGroupSize rows.
+type Writer struct {
+	out      *fileio.EasyWriter
+	offset   int64
+	columns  []Column
+	values   []bytes.Buffer // plain encoded values of each column in the current row group
+	rows     int
+	numRows  int64
+	groups   []rowGroup
+	metadata map[string]string
+	zw       *gzip.Writer
+	page     bytes.Buffer
+}
+
+// rowGroup is the location of a written row group, kept for the file metadata.
+type rowGroup struct {
+	numRows int64
+	chunks  []columnChunk
+}
+
+type columnChunk struct {
+	offset           int64 // of the page header
+	uncompressedSize int64 // including the page header
+	compressedSize   int64
+	numValues        int64
+}
+
+// Create opens filename for writing a table with the given columns. The duplexTools
+// version and command line are stored in the file's key-value metadata.
+func Create(filename string, columns ...Column) *Writer {
+	w := &Writer{
+		out:      fileio.EasyCreate(filename),
+		columns:  columns,
+		values:   make([]bytes.Buffer, len(columns)),
+		metadata: make(map[string]string),
+	}
+	w.zw = gzip.NewWriter(&w.page)
+	w.SetMetadata("duplexToolsVersion", provenance.Version())
+	w.SetMetadata("commandline", provenance.CommandLine())
+	w.write([]byte(magic))
+	return w
+}
+
+// SetMetadata stores a key-value pair in the file metadata written by Close.
+func (w *Writer) SetMetadata(key, value string) {
+	w.metadata[key] = value
+}
+
+// Write adds a row with one value for each column. Int64 columns accept int and int64,
+// Double columns accept float64, and String columns accept string.
+func (w *Writer) Write(row ...any) {
+	if len(row) != len(w.columns) {
+		log.Panicf("parquet: row has %d values but the table has %d columns", len(row), len(w.columns))
+	}
+	var b [8]byte
+	for i, v := range row {
+		buf := &w.values[i]
+		switch w.columns[i].Type {
+		case Int64:
+			var n int64
+			switch x := v.(type) {
+			case int:
+				n = int64(x)
+			case int64:
+				n = x
+			default:
+				log.Panicf("parquet: column %s is Int64 but value is %T", w.columns[i].Name, v)
+			}
+			binary.LittleEndian.PutUint64(b[:], uint64(n))
+			buf.Write(b[:])
+		case Double:
+			x, ok := v.(float64)
+			if !ok {
+				log.Panicf("parquet: column %s is Double but value is %T", w.columns[i].Name, v)
+			}
+			binary.LittleEndian.PutUint64(b[:], math.Float64bits(x))
+			buf.Write(b[:])
+		case String:
+			s, ok := v.(string)
+			if !ok {
+				log.Panicf("parquet: column %s is String but value is %T", w.columns[i].Name, v)
+			}
+			binary.LittleEndian.PutUint32(b[:4], uint32(len(s)))
+			buf.Write(b[:4])
+			buf.WriteString(s)
+		}
+	}
+	w.rows++
+	if w.rows == rowGroupSize {
+		w.flush()
+	}
+}
+
+// Close writes any buffered rows and the file metadata and closes the file.
+func (w *Writer) Close() error {
+	w.flush()
+	meta := w.fileMetadata()
+	w.write(meta)
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], uint32(len(meta)))
+	w.write(b[:])
+	w.write([]byte(magic))
+	return w.out.Close()
+}
+
+func (w *Writer) write(b []byte) {
+	n, err := w.out.Write(b)
+	exception.PanicOnErr(err)
+	w.offset += int64(n)
+}
+
+// flush writes the buffered rows as a row group with one data page per column.
+func (w *Writer) flush() {
+	if w.rows == 0 {
+		return
+	}
+	g := rowGroup{numRows: int64(w.rows), chunks: make([]columnChunk, len(w.columns))}
+	for i := range w.columns {
+		values := w.values[i].Bytes()
+		w.page.Reset()
+		w.zw.Reset(&w.page)
+		_, err := w.zw.Write(values)
+		exception.PanicOnErr(err)
+		err = w.zw.Close()
+		exception.PanicOnErr(err)
+
+		var h compact
+		h.structBody(func() {
+			h.i32(1, pageTypeData)
+			h.i32(2, int32(len(values)))
+			h.i32(3, int32(w.page.Len()))
+			h.structField(5, func() {
+				h.i32(1, int32(w.rows))
+				h.i32(2, encodingPlain)
+				h.i32(3, encodingRle)
+				h.i32(4, encodingRle)
+			})
+		})
+
+		g.chunks[i] = columnChunk{
+			offset:           w.offset,
+			uncompressedSize: int64(len(h.b) + len(values)),
+			compressedSize:   int64(len(h.b) + w.page.Len()),
+			numValues:        int64(w.rows),
+		}
+		w.write(h.b)
+		w.write(w.page.Bytes())
+		w.values[i].Reset()
+	}
+	w.groups = append(w.groups, g)
+	w.numRows += int64(w.rows)
+	w.rows = 0
+}
+
+// fileMetadata encodes the FileMetaData struct of the parquet footer.
+func (w *Writer) fileMetadata() []byte {
+	var c compact
+	c.structBody(func() {
+		c.i32(1, formatVersion)
+		c.list(2, tStruct, len(w.columns)+1)
+		c.structBody(func() {
+			c.binary(4, rootSchemaName)
+			c.i32(5, int32(len(w.columns)))
+		})
+		for _, col := range w.columns {
+			c.structBody(func() {
+				c.i32(1, col.physicalType())
+				c.i32(3, repRequired)
+				c.binary(4, col.Name)
+				if col.Type == String {
+					c.i32(6, convertedUtf8)
+				}
+			})
+		}
+		c.i64(3, w.numRows)
+		c.list(4, tStruct, len(w.groups))
+		for _, g := range w.groups {
+			var totalSize int64
+			for _, chunk := range g.chunks {
+				totalSize += chunk.uncompressedSize
+			}
+			c.structBody(func() {
+				c.list(1, tStruct, len(g.chunks))
+				for i, chunk := range g.chunks {
+					c.structBody(func() {
+						c.i64(2, chunk.offset)
+						c.structField(3, func() {
+							c.i32(1, w.columns[i].physicalType())
+							c.list(2, tI32, 2)
+							c.zigzag(encodingPlain)
+							c.zigzag(encodingRle)
+							c.list(3, tBinary, 1)
+							c.rawBinary(w.columns[i].Name)
+							c.i32(4, codecGzip)
+							c.i64(5, chunk.numValues)
+							c.i64(6, chunk.uncompressedSize)
+							c.i64(7, chunk.compressedSize)
+							c.i64(9, chunk.offset)
+						})
+					})
+				}
+				c.i64(2, totalSize)
+				c.i64(3, g.numRows)
+			})
+		}
+		keys := make([]string, 0, len(w.metadata))
+		for k := range w.metadata {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		c.list(5, tStruct, len(keys))
+		for _, k := range keys {
+			c.structBody(func() {
+				c.binary(1, k)
+				c.binary(2, w.metadata[k])
+			})
+		}
+		c.binary(6, fmt.Sprintf("duplexTools version %s", provenance.Version()))
+	})
+	return c.b
+}
@@ -0,0 +1,60 @@
+package parquet
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.parquet")
+	w := Create(filename, Column{"chrom", String}, Column{"pos", Int64}, Column{"vaf", Double})
+	w.Write("chr1", 100, 0.5)
+	w.Write("chr2", int64(200), 0.25)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b[:4]) != magic || string(b[len(b)-4:]) != magic {
+		t.Fatalf("file does not start and end with %s", magic)
+	}
+	footerLen := int(binary.LittleEndian.Uint32(b[len(b)-8:]))
+	if footerLen != len(w.fileMetadata()) {
+		t.Errorf("expected footer length %d, got %d", len(w.fileMetadata()), footerLen)
+	}
+	if w.numRows != 2 || len(w.groups) != 1 {
+		t.Fatalf("expected 2 rows in 1 row group, got %d rows in %d", w.numRows, len(w.groups))
+	}
+
+	expected := [][]byte{
+		[]byte("\x04\x00\x00\x00chr1\x04\x00\x00\x00chr2"),
+		{100, 0, 0, 0, 0, 0, 0, 0, 200, 0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0xe0, 0x3f, 0, 0, 0, 0, 0, 0, 0xd0, 0x3f},
+	}
+	for i, chunk := range w.groups[0].chunks {
+		page := b[chunk.offset : chunk.offset+chunk.compressedSize]
+		start := bytes.Index(page, []byte{0x1f, 0x8b}) // gzip data follows the page header
+		if start < 0 {
+			t.Fatalf("column %d: no gzip data in page", i)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(page[start:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		values, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(values, expected[i]) {
+			t.Errorf("column %d: expected %v, got %v", i, expected[i], values)
+		}
+	}
+}
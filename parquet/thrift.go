@@ -0,0 +1,85 @@
+package parquet
+
+import "encoding/binary"
+
+// thrift compact protocol field types
+const (
+	tI32    byte = 5
+	tI64    byte = 6
+	tBinary byte = 8
+	tList   byte = 9
+	tStruct byte = 12
+)
+
+// compact encodes the thrift compact protocol used for parquet page headers and
+// file metadata. Only the types parquet metadata needs are supported.
+type compact struct {
+	b    []byte
+	last []int16 // id of the last field written in each open struct
+}
+
+func (c *compact) uvarint(v uint64) {
+	c.b = binary.AppendUvarint(c.b, v)
+}
+
+func (c *compact) zigzag(v int64) {
+	c.uvarint(uint64((v << 1) ^ (v >> 63)))
+}
+
+func (c *compact) fieldHeader(id int16, typ byte) {
+	last := &c.last[len(c.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		c.b = append(c.b, byte(delta)<<4|typ)
+	} else {
+		c.b = append(c.b, typ)
+		c.zigzag(int64(id))
+	}
+	*last = id
+}
+
+func (c *compact) i32(id int16, v int32) {
+	c.fieldHeader(id, tI32)
+	c.zigzag(int64(v))
+}
+
+func (c *compact) i64(id int16, v int64) {
+	c.fieldHeader(id, tI64)
+	c.zigzag(v)
+}
+
+func (c *compact) binary(id int16, s string) {
+	c.fieldHeader(id, tBinary)
+	c.rawBinary(s)
+}
+
+func (c *compact) rawBinary(s string) {
+	c.uvarint(uint64(len(s)))
+	c.b = append(c.b, s...)
+}
+
+// list writes the header of a list field with n elements of type elem. The elements
+// are written after it without field headers.
+func (c *compact) list(id int16, elem byte, n int) {
+	c.fieldHeader(id, tList)
+	if n < 15 {
+		c.b = append(c.b, byte(n)<<4|elem)
+	} else {
+		c.b = append(c.b, 0xf0|elem)
+		c.uvarint(uint64(n))
+	}
+}
+
+// structField writes a struct field whose fields are written by body.
+func (c *compact) structField(id int16, body func()) {
+	c.fieldHeader(id, tStruct)
+	c.structBody(body)
+}
+
+// structBody writes a struct without a field header, as for a list element or the
+// top level message.
+func (c *compact) structBody(body func()) {
+	c.last = append(c.last, 0)
+	body()
+	c.b = append(c.b, 0) // stop
+	c.last = c.last[:len(c.last)-1]
+}
@@ -0,0 +1,9 @@
+//go:build !unix
+
+package pipe
+
+import "errors"
+
+func mkfifo(name string) error {
+	return errors.New("named pipes are not supported on this system")
+}
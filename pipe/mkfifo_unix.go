@@ -0,0 +1,9 @@
+//go:build unix
+
+package pipe
+
+import "syscall"
+
+func mkfifo(name string) error {
+	return syscall.Mkfifo(name, 0600)
+}
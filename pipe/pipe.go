@@ -0,0 +1,190 @@
+// Package pipe lets commands read inputs given as named pipes or bash process
+// substitutions (e.g. -b <(zcat families.bed.gz)). Such inputs can only be read once
+// from start to end, but gonomics seeks in every text file it opens to check for gzip
+// compression, and indexed bams and fastas are read by seeking. Inputs are therefore
+// replaced before a command starts: bam data is relayed through a new pipe so it is
+// still streamed, and anything else (usually a small bed or vcf) is copied to a file.
+package pipe
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var (
+	mu        sync.Mutex
+	originals = make(map[string]string) // local path -> pipe given by the user
+)
+
+// Is reports whether path is a named pipe, socket, or character device such as the
+// /dev/fd/63 of a process substitution or /dev/stdin. os.DevNull is not a pipe.
+func Is(path string) bool {
+	if path == os.DevNull {
+		return false
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&(os.ModeNamedPipe|os.ModeSocket|os.ModeCharDevice) != 0
+}
+
+// Name returns the pipe that path was made from by Localize, or path if it was not.
+func Name(path string) string {
+	mu.Lock()
+	defer mu.Unlock()
+	if original, found := originals[path]; found {
+		return original
+	}
+	return path
+}
+
+// RequireIndexable exits with exit.MissingIndex if path is, or was made from, a pipe,
+// which cannot have an index for random access. what describes the file, e.g. "bam".
+func RequireIndexable(path, what string) {
+	if Name(path) != path || Is(path) {
+		exit.Fatalf(exit.MissingIndex, "%s %s is a pipe or process substitution, which cannot be read by region. Write it to a file and index it first.", what, Name(path))
+	}
+}
+
+// Localize returns a path in dir to read in place of the pipe at path. Bam data is
+// relayed through a new pipe with a .bam suffix, which may be opened once. Anything
+// else is copied to a file, named with a .gz suffix if it is gzip compressed so that
+// it is decompressed when read.
+func Localize(path, dir string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	r := bufio.NewReaderSize(f, 1<<17) // holds a whole bgzf block for isBam
+	name := filepath.Join(dir, filepath.Base(path))
+
+	if isBam(r) {
+		name += ".bam"
+		if err = mkfifo(name); err != nil {
+			f.Close()
+			return "", fmt.Errorf("could not relay %s: %w", path, err)
+		}
+		go relay(f, r, name)
+		log.Printf("Streaming bam from pipe %s. It can only be read once from start to end.", path)
+	} else {
+		if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+			name += ".gz"
+		}
+		err = copyTo(name, r)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("could not read %s: %w", path, err)
+		}
+	}
+
+	mu.Lock()
+	originals[name] = path
+	mu.Unlock()
+	return name, nil
+}
+
+// Stdin returns the path to read the sam or bam data of standard input from in place
+// of "-": a new pipe in dir with a .bam suffix, which may be opened once. Bam data is
+// relayed as by Localize. Sam text, such as the output of samtools view -h, which may be
+// gzip compressed, is converted to bam as it is relayed, as the tags of reads parsed
+// from sam text are lost by sam.ParseExtra. Stdin may only be called once.
+func Stdin(dir string) (string, error) {
+	r := bufio.NewReaderSize(os.Stdin, 1<<17)
+	name := filepath.Join(dir, "stdin.bam")
+	if err := mkfifo(name); err != nil {
+		return "", fmt.Errorf("could not relay stdin: %w", err)
+	}
+	if isBam(r) {
+		go relay(os.Stdin, r, name)
+		log.Print("Streaming bam from stdin. It can only be read once from start to end.")
+	} else {
+		go relaySam(r, name)
+		log.Print("Streaming sam from stdin, converted to bam. It can only be read once from start to end.")
+	}
+
+	mu.Lock()
+	originals[name] = "-"
+	mu.Unlock()
+	return name, nil
+}
+
+// relaySam converts the sam text of r to bam as it is copied to the pipe at name, once
+// the pipe is opened for reading. The pipe is then removed, as by relay.
+func relaySam(r *bufio.Reader, name string) {
+	out, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		log.Printf("WARNING: could not relay stdin: %s", err)
+		return
+	}
+	os.Remove(name)
+	defer out.Close()
+	in := &fileio.EasyReader{BuffReader: r}
+	if magic, _ := r.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			log.Printf("WARNING: could not relay stdin: %s", err)
+			return
+		}
+		in.BuffReader = bufio.NewReader(gz)
+	}
+	w := sam.NewBamWriter(out, sam.ReadHeader(in))
+	for read, done := sam.ReadNext(in); !done; read, done = sam.ReadNext(in) {
+		sam.WriteToBamFileHandle(w, read, 0)
+	}
+	if err = w.Close(); err != nil {
+		log.Printf("WARNING: stopped relaying stdin: %s", err)
+	}
+}
+
+// isBam reports whether r starts with a bgzf block holding the bam magic bytes.
+func isBam(r *bufio.Reader) bool {
+	header, err := r.Peek(18)
+	if err != nil || header[0] != 0x1f || header[1] != 0x8b || header[3]&4 == 0 || header[12] != 'B' || header[13] != 'C' {
+		return false
+	}
+	block, err := r.Peek(int(binary.LittleEndian.Uint16(header[16:])) + 1)
+	if err != nil || len(block) < 26 {
+		return false
+	}
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(flate.NewReader(bytes.NewReader(block[18:len(block)-8])), magic)
+	return err == nil && string(magic) == "BAM\x01"
+}
+
+// relay copies r to the pipe at name once it is opened for reading. The pipe is then
+// removed, so opening it a second time fails instead of reading nothing.
+func relay(f *os.File, r io.Reader, name string) {
+	defer f.Close()
+	out, err := os.OpenFile(name, os.O_WRONLY, 0)
+	if err != nil {
+		log.Printf("WARNING: could not relay %s: %s", f.Name(), err)
+		return
+	}
+	os.Remove(name)
+	defer out.Close()
+	if _, err = io.Copy(out, r); err != nil {
+		log.Printf("WARNING: stopped relaying %s: %s", f.Name(), err)
+	}
+}
+
+func copyTo(name string, r io.Reader) error {
+	out, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
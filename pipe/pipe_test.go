@@ -0,0 +1,93 @@
+//go:build unix
+
+package pipe
+
+import (
+	"bytes"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/sam"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// fifo returns a named pipe that data is written to.
+func fifo(t *testing.T, data []byte) string {
+	name := filepath.Join(t.TempDir(), "fifo")
+	if err := syscall.Mkfifo(name, 0600); err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		f, err := os.OpenFile(name, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		f.Write(data)
+		f.Close()
+	}()
+	return name
+}
+
+func TestLocalize(t *testing.T) {
+	bed := []byte("chr1\t10\t20\nchr1\t30\t40\n")
+	in := fifo(t, bed)
+	if !Is(in) || Is(os.DevNull) {
+		t.Error("Is does not recognize a named pipe")
+	}
+	local, err := Localize(in, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(local); !bytes.Equal(got, bed) || Is(local) || Name(local) != in {
+		t.Errorf("text from a pipe was not copied to a file: %s", local)
+	}
+
+	var b bytes.Buffer
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 100}}, nil, sam.Coordinate, sam.None)
+	w := sam.NewBamWriter(&b, header)
+	sam.WriteToBamFileHandle(w, sam.Sam{QName: "r", RName: "chr1", Pos: 5, RNext: "*", Qual: "*"}, 0)
+	w.Close()
+	in = fifo(t, b.Bytes())
+	local, err = Localize(in, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(local, ".bam") || !Is(local) {
+		t.Fatalf("bam from a pipe is not relayed through a pipe: %s", local)
+	}
+	br, h := sam.OpenBam(local)
+	defer br.Close()
+	if len(h.Chroms) != 1 || h.Chroms[0].Name != "chr1" {
+		t.Errorf("relayed bam has the wrong header: %v", h.Chroms)
+	}
+}
+
+func TestStdin(t *testing.T) {
+	defer func(stdin *os.File) { os.Stdin = stdin }(os.Stdin)
+	text := []byte("@HD\tVN:1.6\tSO:coordinate\n@SQ\tSN:chr1\tLN:100\nr\t0\tchr1\t6\t60\t4M\t*\t0\t0\tACGT\t*\tRF:Z:fam1\n")
+	f, err := os.Open(fifo(t, text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	os.Stdin = f
+	local, err := Stdin(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(local, ".bam") || !Is(local) || Name(local) != "-" {
+		t.Fatalf("sam text from stdin is not relayed through a pipe: %s", local)
+	}
+	br, h := sam.OpenBam(local)
+	defer br.Close()
+	var read sam.Sam
+	if _, err = sam.DecodeBam(br, &read); err != nil {
+		t.Fatal(err)
+	}
+	sam.ParseExtra(&read)
+	if len(h.Chroms) != 1 || read.QName != "r" || read.Pos != 6 || read.Extra != "RF:Z:fam1" {
+		t.Errorf("sam text from stdin was not converted to bam: %v %s", h.Chroms, sam.ToString(read))
+	}
+}
@@ -0,0 +1,43 @@
+// Package pool recycles the slices of reads, piles, and quality scores that are allocated
+// for every read family so that threads spend less time waiting on the garbage collector.
+package pool
+
+import (
+	"sync"
+
+	"github.com/vertgenlab/gonomics/sam"
+)
+
+// Sams holds slices of sam records, e.g. the reads of a single family.
+var Sams Slices[sam.Sam]
+
+// Piles holds slices of piles produced by a pileup of a family.
+var Piles Slices[sam.Pile]
+
+// Bytes holds scratch buffers, e.g. quality scores before conversion to a sam Qual string.
+var Bytes Slices[byte]
+
+// Slices is a pool of slices of T that is safe for concurrent use. The zero value
+// is ready to use.
+type Slices[T any] struct {
+	p sync.Pool
+}
+
+// Get returns a slice with length 0 and capacity of at least n.
+func (s *Slices[T]) Get(n int) []T {
+	if x, ok := s.p.Get().(*[]T); ok && cap(*x) >= n {
+		return (*x)[:0]
+	}
+	return make([]T, 0, n)
+}
+
+// Put returns x to the pool. x must not be used after Put is called. Elements are
+// not cleared, so memory they reference (e.g. the Seq of a sam record) is kept alive
+// until the slice is reused and its elements are overwritten.
+func (s *Slices[T]) Put(x []T) {
+	if cap(x) == 0 {
+		return
+	}
+	x = x[:0]
+	s.p.Put(&x)
+}
@@ -0,0 +1,203 @@
+// Package popaf looks up the population allele frequency of variants in a large bgzip
+// compressed, tabix indexed VCF such as gnomAD. The database is read only around each
+// variant, streaming forward between nearby queries, so it is never loaded into memory.
+// Variants are matched on position, REF, and ALT, and sequence names are matched with
+// or without a 'chr' prefix.
+package popaf
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strconv"
+	"strings"
+)
+
+// Info and Filter are the INFO field and FILTER added by Annotate.
+const (
+	Info   = "POP_AF"
+	Filter = "popAF"
+)
+
+// record is a single variant read from the database.
+type record struct {
+	pos int
+	ref string
+	alt []string
+	af  []string
+}
+
+// DB streams the database forward and seeks only when the next query is far from the
+// current position, so queries should be roughly sorted. A DB must not be used from
+// more than one goroutine.
+type DB struct {
+	idx     tabix.Index
+	r       *tabix.Reader
+	afField string
+	chr     string   // name of the current sequence in the database
+	buf     []record // records at or after the last queried position
+	pos     int      // position of the last record read
+	query   int      // last queried position
+	done    bool     // no more records on the current sequence
+}
+
+// maxStreamDistance is the largest gap in bases that is read through rather than seeking.
+const maxStreamDistance = 100_000
+
+// Open opens the database in filename (with filename.tbi), reading allele frequencies
+// from the afField INFO field.
+func Open(filename, afField string) *DB {
+	return &DB{idx: tabix.ReadIndex(filename + ".tbi"), r: tabix.NewReader(filename), afField: afField}
+}
+
+// Close closes the database.
+func (d *DB) Close() error {
+	return d.r.Close()
+}
+
+// Annotate adds the population allele frequency of v to its INFO field and reports
+// whether v is in the database and whether its frequency is above maxAf.
+func (d *DB) Annotate(v *vcf.Vcf, maxAf float64) (found, above bool) {
+	af := d.Lookup(*v)
+	if af == "" {
+		return false, false
+	}
+	v.Info = AppendInfo(v.Info, Info+"="+af)
+	afVal, err := strconv.ParseFloat(af, 64)
+	return true, err == nil && afVal > maxAf
+}
+
+// Lookup returns the population allele frequency of the variant, or an empty string if it is not in the database.
+func (d *DB) Lookup(v vcf.Vcf) string {
+	chr := d.resolveName(v.Chr)
+	if chr == "" {
+		return ""
+	}
+	if chr != d.chr || v.Pos < d.query || v.Pos-d.pos > maxStreamDistance {
+		d.seek(chr, v.Pos)
+	}
+	d.query = v.Pos
+
+	var keep int
+	for keep < len(d.buf) && d.buf[keep].pos < v.Pos {
+		keep++
+	}
+	d.buf = d.buf[keep:]
+
+	var rec record
+	var ok bool
+	for !d.done && d.pos <= v.Pos {
+		rec, ok = d.next()
+		if ok && rec.pos >= v.Pos {
+			d.buf = append(d.buf, rec)
+		}
+	}
+
+	for _, rec = range d.buf {
+		if rec.pos != v.Pos {
+			break
+		}
+		if !strings.EqualFold(rec.ref, v.Ref) {
+			continue
+		}
+		for i := range rec.alt {
+			if strings.EqualFold(rec.alt[i], v.Alt[0]) && i < len(rec.af) {
+				return rec.af[i]
+			}
+		}
+	}
+	return ""
+}
+
+// resolveName returns the name of the sequence in the database, trying with and without a 'chr' prefix.
+func (d *DB) resolveName(chr string) string {
+	switch {
+	case d.idx.HasSeq(chr):
+		return chr
+	case strings.HasPrefix(chr, "chr") && d.idx.HasSeq(chr[3:]):
+		return chr[3:]
+	case d.idx.HasSeq("chr" + chr):
+		return "chr" + chr
+	}
+	return ""
+}
+
+// seek moves the cursor to the first database record that may overlap pos.
+func (d *DB) seek(chr string, pos int) {
+	d.chr, d.pos, d.buf = chr, 0, d.buf[:0]
+	offset, found := d.idx.Offset(chr, pos-1, pos)
+	d.done = !found
+	if found {
+		d.r.Seek(offset)
+	}
+}
+
+// next reads the next database record on the current sequence. Returns false if the line was not a record.
+func (d *DB) next() (record, bool) {
+	line, ok := d.r.NextLine()
+	if !ok {
+		d.done = true
+		return record{}, false
+	}
+	if len(line) == 0 || line[0] == '#' {
+		return record{}, false
+	}
+	words := strings.SplitN(line, "\t", 9)
+	if len(words) < 8 {
+		exit.Fatalf(exit.MalformedInput, "malformed line in database:\n%s\n", line)
+	}
+	if words[0] != d.chr {
+		d.done = true
+		return record{}, false
+	}
+	pos, err := strconv.Atoi(words[1])
+	exception.PanicOnErr(err)
+	d.pos = pos
+	return record{pos: pos, ref: words[3], alt: strings.Split(words[4], ","), af: infoValues(words[7], d.afField)}, true
+}
+
+// infoValues returns the comma separated values of an INFO field.
+func infoValues(info, field string) []string {
+	for _, kv := range strings.Split(info, ";") {
+		if strings.HasPrefix(kv, field+"=") {
+			return strings.Split(kv[len(field)+1:], ",")
+		}
+	}
+	return nil
+}
+
+// AddHeader inserts the ##INFO line for the allele frequency, and unless remove is set
+// the ##FILTER line for variants above maxAf, before the #CHROM line.
+func AddHeader(header vcf.Header, afField string, maxAf float64, remove bool) vcf.Header {
+	newLines := []string{fmt.Sprintf("##INFO=<ID=%s,Number=1,Type=Float,Description=\"Population allele frequency from the %s field of the database\">", Info, afField)}
+	if !remove {
+		newLines = append(newLines, fmt.Sprintf("##FILTER=<ID=%s,Description=\"Population allele frequency above %g\">", Filter, maxAf))
+	}
+	var ans vcf.Header
+	ans.Text = make([]string, 0, len(header.Text)+len(newLines))
+	for i := range header.Text {
+		if strings.HasPrefix(header.Text[i], "#CHROM") {
+			ans.Text = append(ans.Text, newLines...)
+		}
+		ans.Text = append(ans.Text, header.Text[i])
+	}
+	return ans
+}
+
+// AppendInfo adds field to an INFO column.
+func AppendInfo(info, field string) string {
+	if info == "" || info == "." {
+		return field
+	}
+	return info + ";" + field
+}
+
+// AppendFilter adds name to a FILTER column.
+func AppendFilter(filter, name string) string {
+	if filter == "" || filter == "." || filter == "PASS" {
+		return name
+	}
+	return filter + ";" + name
+}
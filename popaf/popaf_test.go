@@ -0,0 +1,49 @@
+package popaf
+
+import (
+	"github.com/dasnellings/duplexTools/tabix"
+	"github.com/vertgenlab/gonomics/vcf"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+const testDb = `##fileformat=VCFv4.2
+#CHROM	POS	ID	REF	ALT	QUAL	FILTER	INFO
+1	100	.	A	C,G	.	PASS	AC=3;AF=0.01,0.00001
+1	100	.	AT	A	.	PASS	AF=0.2
+1	250000	.	G	T	.	PASS	AF=0.5
+2	5	.	C	T	.	PASS	AN=10
+`
+
+func TestDB(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "db.vcf.gz")
+	out := tabix.Create(filename)
+	io.WriteString(out, testDb)
+	if err := out.Close(); err != nil {
+		t.Fatal(err)
+	}
+	db := Open(filename, "AF")
+	defer db.Close()
+
+	tests := []struct {
+		v            vcf.Vcf
+		found, above bool
+		info         string
+	}{
+		{vcf.Vcf{Chr: "chr1", Pos: 100, Ref: "A", Alt: []string{"C"}, Info: "DS"}, true, true, "DS;POP_AF=0.01"},
+		{vcf.Vcf{Chr: "chr1", Pos: 100, Ref: "A", Alt: []string{"G"}, Info: "."}, true, false, "POP_AF=0.00001"},
+		{vcf.Vcf{Chr: "chr1", Pos: 100, Ref: "AT", Alt: []string{"A"}, Info: "."}, true, true, "POP_AF=0.2"},
+		{vcf.Vcf{Chr: "chr1", Pos: 100, Ref: "A", Alt: []string{"T"}, Info: "."}, false, false, "."},
+		{vcf.Vcf{Chr: "chr1", Pos: 250000, Ref: "G", Alt: []string{"T"}, Info: "."}, true, true, "POP_AF=0.5"},
+		{vcf.Vcf{Chr: "1", Pos: 100, Ref: "A", Alt: []string{"C"}, Info: "."}, true, true, "POP_AF=0.01"}, // seeks back
+		{vcf.Vcf{Chr: "chr2", Pos: 5, Ref: "C", Alt: []string{"T"}, Info: "."}, false, false, "."},
+		{vcf.Vcf{Chr: "chrX", Pos: 5, Ref: "C", Alt: []string{"T"}, Info: "."}, false, false, "."},
+	}
+	for i, test := range tests {
+		found, above := db.Annotate(&test.v, 0.001)
+		if found != test.found || above != test.above || test.v.Info != test.info {
+			t.Errorf("query %d: expected %v %v %s, got %v %v %s", i, test.found, test.above, test.info, found, above, test.v.Info)
+		}
+	}
+}
@@ -0,0 +1,266 @@
+// Package preflight checks an input bam before a command starts processing it, so a
+// missing index, unsorted reads, missing tags, or unusable base qualities are reported
+// in seconds with a fix instead of after hours of work or as a confusing panic. The
+// checks read the header and a sample of reads from the start of the file.
+package preflight
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dasnellings/duplexTools/bgzf"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/pipe"
+	"github.com/dasnellings/duplexTools/salvage"
+	"github.com/vertgenlab/gonomics/fileio"
+	"github.com/vertgenlab/gonomics/sam"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// SampleSize is the number of reads from the start of a bam checked by Bam.Check.
+const SampleSize = 10000
+
+// tagFixes suggests how to add tags required by a command.
+var tagFixes = map[string]string{
+	"RF": "Input must be annotated with annotateReadFamilies.",
+	"RS": "Input must be annotated with annotateReadFamilies.",
+	"BF": "Barcodes must be moved to BF and BR tags before alignment (see mcsFqToBam or extractIdtDuplex).",
+	"BR": "Barcodes must be moved to BF and BR tags before alignment (see mcsFqToBam or extractIdtDuplex).",
+}
+
+// Bam lists what a command requires of an input bam (or sam).
+type Bam struct {
+	Sorted    bool     // header declares coordinate sort order and reads are sorted
+	Indexed   bool     // a .bai index exists
+	Tags      []string // tags that mapped primary reads must carry, e.g. RF
+	Qualities bool     // reads have base qualities
+}
+
+// Check exits with a classified error if the bam at path does not meet the requirements
+// in b, and logs a warning for an index older than the bam or base qualities that look
+// Phred+64 encoded. A bam that is truncated or cannot be decoded is reported with
+// salvage.Warn. Pipes are not checked, as reading them would consume the data, and
+// neither is cram, which is only read when built with htslib. A cram given to a command
+// that requires an index exits with exit.MalformedInput, as reads are only fetched by
+// region from an indexed bam.
+func (b Bam) Check(path string) {
+	if b.Indexed && hts.IsCram(path) {
+		exit.Fatalf(exit.MalformedInput, "%s is a cram file, but this command reads it by region through a .bai index, which needs a bam even when built with htslib. Convert it with samtools view -b -T ref.fa -o out.bam %s and index the bam.", path, path)
+	}
+	if pipe.Is(path) || pipe.Name(path) != path || hts.IsCram(path) { // htslib checks cram as it reads
+		return
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		log.Fatalf("ERROR: could not find input %s.", path)
+	}
+	if !isSam(path) {
+		if complete, offset, problem, err := bgzf.Check(path); err == nil && !complete {
+			salvage.Warn("%s is truncated or corrupt at byte %d of %d: %s.", path, offset, info.Size(), problem)
+		}
+	}
+	if b.Indexed {
+		index, stale, found := FindIndex(path)
+		switch {
+		case !found:
+			exit.Fatalf(exit.MissingIndex, "could not find %s.bai. Index the bam with samtools index.", path)
+		case stale:
+			log.Printf("WARNING: index %s is older than %s. If the bam was changed after indexing, index it again with samtools index.", index, path)
+		}
+	}
+
+	header, err := ReadHeader(path)
+	if err != nil {
+		exit.Fatalf(exit.MalformedInput, "%s. Confirm it is a complete bam and was not truncated during transfer.", err)
+	}
+	b.CheckHeader(path, header)
+
+	s := Read(path, header, SampleSize, b.Tags...)
+	if s.Err != nil {
+		salvage.Warn("could not read %s after %d reads: %s. The file is truncated or corrupt.", path, s.Reads, s.Err)
+	}
+	if b.Sorted && s.Unsorted != "" {
+		exit.Fatalf(exit.Unsorted, "reads in %s are not coordinate sorted: %s. Sort with samtools sort and index the bam again.", path, s.Unsorted)
+	}
+	if s.Mapped > 0 {
+		for i, tag := range b.Tags {
+			if s.Tagged[i] == 0 {
+				exit.Fatalf(exit.MissingTag, "none of the first %d mapped reads in %s have an %s tag. %s", s.Mapped, path, tag, tagFixes[tag])
+			}
+		}
+	}
+	if b.Qualities {
+		switch {
+		case s.Reads > 0 && s.NoQual == s.Reads:
+			exit.Fatalf(exit.MalformedInput, "none of the first %d reads in %s have base qualities, which are required. Generate the bam again keeping the fastq qualities.", s.Reads, path)
+		case s.Phred64():
+			log.Printf("WARNING: base qualities in %s range from %d to %d, so they may be Phred+64 encoded. Convert the fastq to Phred+33 (e.g. seqtk seq -Q64 -V) and align again.", path, s.MinQual, s.MaxQual)
+		}
+	}
+}
+
+// CheckHeader exits with exit.Unsorted if b requires sorted reads and header does not
+// declare coordinate sort order. Commands streaming a bam from a pipe, which Check
+// skips, call it with the header they read.
+func (b Bam) CheckHeader(path string, header sam.Header) {
+	if b.Sorted && (len(header.Metadata.SortOrder) == 0 || header.Metadata.SortOrder[0] != sam.Coordinate) {
+		exit.Fatalf(exit.Unsorted, "%s must be coordinate sorted, but its header does not declare SO:coordinate. Sort with samtools sort.", pipe.Name(path))
+	}
+}
+
+// FindIndex returns the .bai of the bam at path, either path.bai or the .bai with the
+// .bam suffix replaced, and whether it is older than the bam.
+func FindIndex(path string) (index string, stale, found bool) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", false, false
+	}
+	for _, idx := range []string{path + ".bai", strings.TrimSuffix(path, ".bam") + ".bai"} {
+		if idxInfo, err := os.Stat(idx); err == nil {
+			return idx, idxInfo.ModTime().Before(info.ModTime()), true
+		}
+	}
+	return "", false, false
+}
+
+// ReadHeader reads the header of a bam or sam, converting panics from malformed files
+// to an error.
+func ReadHeader(path string) (header sam.Header, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("could not read header of %s: %v", path, r)
+		}
+	}()
+	if isSam(path) {
+		f := fileio.EasyOpen(path)
+		header = sam.ReadHeader(f)
+		return header, f.Close()
+	}
+	var br *sam.BamReader
+	br, header = sam.OpenBam(path)
+	return header, br.Close()
+}
+
+func isSam(path string) bool {
+	return strings.HasSuffix(strings.TrimSuffix(path, ".gz"), ".sam")
+}
+
+// Sample summarizes the reads at the start of a bam.
+type Sample struct {
+	Reads            int    // reads decoded
+	Mapped           int    // mapped primary reads
+	Tagged           []int  // mapped primary reads carrying each requested tag
+	AllTagged        int    // mapped primary reads carrying every requested tag
+	Unsorted         string // the first read out of coordinate order and the read before it, or ""
+	NoQual           int    // reads without base qualities
+	MinQual, MaxQual byte   // range of Phred scores of reads with qualities
+	Err              error  // error decoding a read, nil at the end of the file
+}
+
+// Phred64 reports whether the quality range looks Phred+64 rather than Phred+33 encoded.
+func (s Sample) Phred64() bool {
+	return s.MinQual >= 31 && s.MaxQual > 45
+}
+
+// Read decodes up to n reads (every read if n < 0) from the start of the bam or sam at
+// path and counts the mapped primary reads carrying each of tags.
+func Read(path string, header sam.Header, n int, tags ...string) Sample {
+	s := Sample{Tagged: make([]int, len(tags)), MinQual: 255}
+	next, done := open(path)
+	defer done()
+
+	chromOrder := make(map[string]int)
+	for i, c := range header.Chroms {
+		chromOrder[c.Name] = i
+	}
+	var r sam.Sam
+	var err error
+	prevChrom, prevPos := -1, 0
+	for n < 0 || s.Reads < n {
+		if err = next(&r); err != nil {
+			break
+		}
+		s.Reads++
+		if r.RName != "*" && s.Unsorted == "" {
+			chrom := chromOrder[r.RName]
+			if chrom < prevChrom || (chrom == prevChrom && int(r.Pos) < prevPos) {
+				s.Unsorted = fmt.Sprintf("%s:%d follows %s:%d", r.RName, r.Pos, header.Chroms[prevChrom].Name, prevPos)
+			}
+			prevChrom, prevPos = chrom, int(r.Pos)
+		}
+		if r.Qual == "*" || r.Qual == "" {
+			s.NoQual++
+		} else {
+			for i := 0; i < len(r.Qual); i++ {
+				q := r.Qual[i] - 33
+				if q < s.MinQual {
+					s.MinQual = q
+				}
+				if q > s.MaxQual {
+					s.MaxQual = q
+				}
+			}
+		}
+		if sam.IsUnmapped(r) || sam.IsNotPrimaryAlign(r) || sam.IsSupplementaryAlign(r) {
+			continue
+		}
+		s.Mapped++
+		all := true
+		for i, tag := range tags {
+			if _, found, _ := sam.QueryTag(r, tag); found {
+				s.Tagged[i]++
+			} else {
+				all = false
+			}
+		}
+		if all {
+			s.AllTagged++
+		}
+	}
+	if s.NoQual == s.Reads {
+		s.MinQual = 0
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		s.Err = err
+	}
+	return s
+}
+
+// open returns a function decoding the next read of path, which converts panics from
+// corrupt records to an error, and a function closing the file.
+func open(path string) (next func(*sam.Sam) error, done func()) {
+	if isSam(path) {
+		f := fileio.EasyOpen(path)
+		next = func(r *sam.Sam) (err error) {
+			defer recoverTo(&err)
+			var done bool
+			*r, done = sam.ReadNext(f) // skips the header
+			if done {
+				return io.EOF
+			}
+			return nil
+		}
+		return next, func() { f.Close() }
+	}
+	br, _ := sam.OpenBam(path)
+	next = func(r *sam.Sam) (err error) {
+		defer recoverTo(&err)
+		_, err = sam.DecodeBam(br, r)
+		return err
+	}
+	return next, func() {
+		defer func() {
+			recover() // truncated files are reported by Sample.Err
+		}()
+		br.Close()
+	}
+}
+
+func recoverTo(err *error) {
+	if r := recover(); r != nil {
+		*err = fmt.Errorf("%v", r)
+	}
+}
@@ -0,0 +1,89 @@
+package preflight
+
+import (
+	"github.com/dasnellings/duplexTools/bam"
+	"github.com/vertgenlab/gonomics/chromInfo"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/sam"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeBam(t *testing.T, filename string, reads []sam.Sam) sam.Header {
+	header := sam.GenerateHeader([]chromInfo.ChromInfo{{Name: "chr1", Size: 1000}, {Name: "chr2", Size: 1000, Order: 1}}, nil, sam.Coordinate, sam.None)
+	file, err := os.Create(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := bam.NewWriter(file, header)
+	for _, r := range reads {
+		w.Write(r)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err = file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+func read(chrom string, pos uint32, qual, extra string) sam.Sam {
+	return sam.Sam{QName: "r", MapQ: 60, RName: chrom, Pos: pos, Cigar: []cigar.Cigar{{RunLength: 4, Op: 'M'}},
+		RNext: "*", Seq: dna.StringToBases("ACGT"), Qual: qual, Extra: extra}
+}
+
+func TestRead(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.bam")
+	header := writeBam(t, filename, []sam.Sam{
+		read("chr1", 10, "IIII", "RF:Z:chr1:10_x\tRS:A:W"),
+		read("chr1", 20, "#I5I", "RF:Z:chr1:20_x"),
+		read("chr2", 5, "IIII", ""),
+		read("chr1", 30, "*", "RF:Z:chr1:30_x"),
+	})
+	s := Read(filename, header, -1, "RF", "RS")
+	if s.Err != nil {
+		t.Fatal(s.Err)
+	}
+	if s.Reads != 4 || s.Mapped != 4 || s.NoQual != 1 {
+		t.Errorf("counted %d reads, %d mapped, %d without qualities; expected 4, 4, 1", s.Reads, s.Mapped, s.NoQual)
+	}
+	if s.Tagged[0] != 3 || s.Tagged[1] != 1 || s.AllTagged != 1 {
+		t.Errorf("tagged counts %v (all %d), expected [3 1] (all 1)", s.Tagged, s.AllTagged)
+	}
+	if s.Unsorted != "chr1:30 follows chr2:5" {
+		t.Errorf("unsorted is %q", s.Unsorted)
+	}
+	if s.MinQual != 2 || s.MaxQual != 40 || s.Phred64() {
+		t.Errorf("quality range %d-%d", s.MinQual, s.MaxQual)
+	}
+
+	if s = Read(filename, header, 2, "RF"); s.Reads != 2 || s.Unsorted != "" {
+		t.Errorf("sample of 2 read %d reads, unsorted %q", s.Reads, s.Unsorted)
+	}
+}
+
+func TestFindIndex(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "test.bam")
+	writeBam(t, filename, nil)
+	if _, _, found := FindIndex(filename); found {
+		t.Error("found an index that does not exist")
+	}
+
+	idx := filepath.Join(dir, "test.bai")
+	if err := os.WriteFile(idx, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(idx, old, old); err != nil {
+		t.Fatal(err)
+	}
+	index, stale, found := FindIndex(filename)
+	if !found || index != idx || !stale {
+		t.Errorf("FindIndex returned %s, stale %t, found %t", index, stale, found)
+	}
+}
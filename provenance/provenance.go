@@ -0,0 +1,109 @@
+// Package provenance stamps outputs with the duplexTools version and the command line
+// that made them, as VCF meta lines and BAM @PG records.
+package provenance
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/deterministic"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// commandLine is captured before remote.Run replaces remote paths in os.Args with
+// local copies, so it records the paths the user gave.
+var commandLine = Quote(os.Args)
+
+// Version returns the module version duplexTools was built from.
+func Version() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" {
+		return "(devel)"
+	}
+	return info.Main.Version
+}
+
+// Command returns the name of the running command, e.g. mcsCallVariants.
+func Command() string {
+	return filepath.Base(os.Args[0])
+}
+
+// CommandLine returns the full command line the program was started with.
+func CommandLine() string {
+	return commandLine
+}
+
+// Vcf returns a copy of h with ##source, ##duplexToolsVersion, ##commandline, and,
+// unless -deterministic is given, ##fileDate lines added before the #CHROM line. Lines
+// from earlier tools are kept, except their ##fileDate.
+func Vcf(h vcf.Header) vcf.Header {
+	lines := []string{
+		"##source=duplexTools " + Command(),
+		"##duplexToolsVersion=" + Version(),
+		fmt.Sprintf("##commandline=%q", CommandLine()),
+	}
+	if !deterministic.Enabled() {
+		lines = append(lines, "##fileDate="+time.Now().Format("20060102"))
+	}
+	text := make([]string, 0, len(h.Text)+len(lines))
+	for i := range h.Text {
+		if strings.HasPrefix(h.Text[i], "#CHROM") {
+			text = append(text, lines...)
+			lines = nil
+		}
+		if !strings.HasPrefix(h.Text[i], "##fileDate=") {
+			text = append(text, h.Text[i])
+		}
+	}
+	h.Text = append(text, lines...)
+	return h
+}
+
+// Sam returns a copy of h with a @PG record for this command appended. The record
+// is chained to the last @PG already in h with PP and its ID is made unique.
+func Sam(h sam.Header) sam.Header {
+	var prev string
+	ids := make(map[string]bool)
+	for _, line := range h.Text {
+		if !strings.HasPrefix(line, "@PG\t") {
+			continue
+		}
+		for _, field := range strings.Split(line, "\t") {
+			if id, found := strings.CutPrefix(field, "ID:"); found {
+				ids[id] = true
+				prev = id
+			}
+		}
+	}
+
+	id := Command()
+	for n := 1; ids[id]; n++ {
+		id = fmt.Sprintf("%s.%d", Command(), n)
+	}
+	pg := fmt.Sprintf("@PG\tID:%s\tPN:duplexTools\tVN:%s", id, Version())
+	if prev != "" {
+		pg += "\tPP:" + prev
+	}
+	pg += "\tCL:" + strings.ReplaceAll(CommandLine(), "\t", " ")
+
+	text := make([]string, len(h.Text), len(h.Text)+1)
+	copy(text, h.Text)
+	h.Text = append(text, pg)
+	return h
+}
+
+// Quote joins args with spaces, quoting any that the shell would split or expand.
+func Quote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n'\"\\$`*?[]{}()<>|&;#~") {
+			arg = "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
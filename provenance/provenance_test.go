@@ -0,0 +1,44 @@
+package provenance
+
+import (
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"strings"
+	"testing"
+)
+
+func TestVcf(t *testing.T) {
+	in := vcf.Header{Text: []string{"##fileformat=VCFv4.2", "##fileDate=20200101", "#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO"}}
+	out := Vcf(in)
+	if len(in.Text) != 3 {
+		t.Errorf("input header was modified: %v", in.Text)
+	}
+	if len(out.Text) != 6 || !strings.HasPrefix(out.Text[1], "##source=duplexTools ") ||
+		!strings.HasPrefix(out.Text[3], "##commandline=") || !strings.HasPrefix(out.Text[4], "##fileDate=") || !strings.HasPrefix(out.Text[5], "#CHROM") {
+		t.Errorf("wrong header:\n%s", strings.Join(out.Text, "\n"))
+	}
+}
+
+func TestSam(t *testing.T) {
+	in := sam.Header{Text: []string{"@HD\tVN:1.6", "@PG\tID:bwa\tPN:bwa"}}
+	out := Sam(Sam(in))
+	if len(in.Text) != 2 || len(out.Text) != 4 {
+		t.Fatalf("wrong number of header lines: %v %v", in.Text, out.Text)
+	}
+	first := strings.Split(out.Text[2], "\t")
+	second := strings.Split(out.Text[3], "\t")
+	if first[1] != "ID:"+Command() || first[4] != "PP:bwa" {
+		t.Errorf("wrong @PG record: %s", out.Text[2])
+	}
+	if second[1] != "ID:"+Command()+".1" || second[4] != "PP:"+Command() {
+		t.Errorf("wrong chained @PG record: %s", out.Text[3])
+	}
+}
+
+func TestQuote(t *testing.T) {
+	got := Quote([]string{"mcsCallVariants", "-i", "a b.bam", "-o", "it's.vcf", ""})
+	expected := `mcsCallVariants -i 'a b.bam' -o 'it'\''s.vcf' ''`
+	if got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
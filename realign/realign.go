@@ -1,10 +1,11 @@
 package realign
 
 import (
+	"context"
+	"github.com/dasnellings/duplexTools/fai"
 	"github.com/vertgenlab/gonomics/align"
 	"github.com/vertgenlab/gonomics/cigar"
 	"github.com/vertgenlab/gonomics/dna"
-	"github.com/vertgenlab/gonomics/fasta"
 	"github.com/vertgenlab/gonomics/sam"
 	"log"
 	"sync"
@@ -13,11 +14,14 @@ import (
 var gapOpen int64 = -600
 var gapExtend int64 = -20
 
-func GoRealignIndels(reads <-chan sam.Sam, ref *fasta.Seeker) <-chan sam.Sam {
+// GoRealignIndels locally realigns each read from reads to the reference surrounding it and
+// returns a channel of the realigned reads. The channel is closed when reads is closed or
+// ctx is cancelled.
+func GoRealignIndels(ctx context.Context, reads <-chan sam.Sam, ref *fai.Seeker) <-chan sam.Sam {
 	wg := new(sync.WaitGroup)
 	output := make(chan sam.Sam, 1000)
 	wg.Add(1)
-	go realignIndelsEngine(reads, output, ref, wg)
+	go realignIndelsEngine(ctx, reads, output, ref, wg)
 	go func(wg *sync.WaitGroup) {
 		wg.Wait()
 		close(output)
@@ -25,13 +29,15 @@ func GoRealignIndels(reads <-chan sam.Sam, ref *fasta.Seeker) <-chan sam.Sam {
 	return output
 }
 
-func RealignIndels(reads <-chan sam.Sam, output chan<- sam.Sam, ref *fasta.Seeker) {
+// RealignIndels realigns each read from reads and sends it to output. It returns when
+// reads is closed or ctx is cancelled, without closing output.
+func RealignIndels(ctx context.Context, reads <-chan sam.Sam, output chan<- sam.Sam, ref *fai.Seeker) {
 	wg := new(sync.WaitGroup)
 	wg.Add(1)
-	realignIndelsEngine(reads, output, ref, wg)
+	realignIndelsEngine(ctx, reads, output, ref, wg)
 }
 
-func realignIndels(in <-chan sam.Sam, out chan<- sam.Sam, ref *fasta.Seeker) {
+func realignIndels(in <-chan sam.Sam, out chan<- sam.Sam, ref *fai.Seeker) {
 	var currStart, currEnd int
 	var currRegion []dna.Base
 	var score int64
@@ -49,13 +55,25 @@ func realignIndels(in <-chan sam.Sam, out chan<- sam.Sam, ref *fasta.Seeker) {
 	close(out)
 }
 
-func realignIndelsEngine(in <-chan sam.Sam, out chan<- sam.Sam, ref *fasta.Seeker, wg *sync.WaitGroup) {
+func realignIndelsEngine(ctx context.Context, in <-chan sam.Sam, out chan<- sam.Sam, ref *fai.Seeker, wg *sync.WaitGroup) {
+	defer wg.Done()
 	var currStart, currEnd int
 	var currRegion []dna.Base
 	var packet align.TargetQueryPair
+	var r sam.Sam
+	var ok bool
 	inputs, outputs := align.GoAffineGapLocalEngine(align.HumanChimpTwoScoreMatrix, gapOpen, gapExtend)
 
-	for r := range in {
+	for {
+		select {
+		case r, ok = <-in:
+			if !ok {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+
 		if !(r.GetChromStart() >= currStart+200 && r.GetChromEnd() <= currEnd-200) {
 			currStart, currEnd, currRegion = getRegion(r, ref)
 			dna.AllToUpper(currRegion)
@@ -66,12 +84,16 @@ func realignIndelsEngine(in <-chan sam.Sam, out chan<- sam.Sam, ref *fasta.Seeke
 		inputs <- packet
 		packet = <-outputs
 		updateRead(&r, packet.Cigar, currStart, currEnd, packet.Score)
-		out <- r
+
+		select {
+		case out <- r:
+		case <-ctx.Done():
+			return
+		}
 	}
-	wg.Done()
 }
 
-func getRegion(read sam.Sam, ref *fasta.Seeker) (start, end int, region []dna.Base) {
+func getRegion(read sam.Sam, ref *fai.Seeker) (start, end int, region []dna.Base) {
 	var err error
 	var pad int = 1000
 	start = read.GetChromStart() - pad
@@ -79,7 +101,7 @@ func getRegion(read sam.Sam, ref *fasta.Seeker) (start, end int, region []dna.Ba
 	if start < 0 {
 		start = 0
 	}
-	region, err = fasta.SeekByName(ref, read.RName, start, end)
+	region, err = ref.SeekByName(read.RName, start, end)
 	if err != nil {
 		log.Printf("ERROR: problem fetching reference %s:%d-%d for read %s\n", read.RName, start, end, read.QName)
 		log.Fatalln(err)
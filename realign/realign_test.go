@@ -1,7 +1,8 @@
 package realign
 
 import (
-	"github.com/vertgenlab/gonomics/fasta"
+	"context"
+	"github.com/dasnellings/duplexTools/fai"
 	"github.com/vertgenlab/gonomics/fileio"
 	"github.com/vertgenlab/gonomics/sam"
 	"os/exec"
@@ -11,12 +12,12 @@ import (
 func TestRealignIndels(t *testing.T) {
 	in := "testdata/bwa_input.bam"
 	ref := "/Users/danielsnellings/resources/hg38.fa"
-	seeker := fasta.NewSeeker(ref, "")
+	seeker := fai.NewSeeker(ref)
 	reads, header := sam.GoReadToChan(in)
 	out := fileio.EasyCreate("testdata/out.bam")
 	bw := sam.NewBamWriter(out, header)
 
-	output := GoRealignIndels(reads, seeker)
+	output := GoRealignIndels(context.Background(), reads, seeker)
 
 	for r := range output {
 		sam.WriteToBamFileHandle(bw, r, 0)
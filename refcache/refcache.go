@@ -0,0 +1,235 @@
+// Package refcache keeps the reference sequences needed to decode CRAM in a directory
+// where each sequence is stored under its MD5, as samtools does with REF_CACHE. htslib
+// finds the sequence of each @SQ line of a cram by its M5 tag in REF_PATH, downloading
+// sequences it does not find and saving them to REF_CACHE, so with Setenv a cram
+// decodes without a matching local fasta. The sequences of a reference are added with
+// Populate, so runs with the reference given need no network access.
+package refcache
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Flag is the name of the option that sets the cache directory.
+const Flag = "refCache"
+
+// URL is where htslib downloads sequences that are not in the cache, given their MD5.
+// It is the default REF_PATH of htslib.
+const URL = "https://www.ebi.ac.uk/ena/cram/md5/%s"
+
+// template is the layout of the cache in REF_PATH and REF_CACHE: the first two pairs
+// of characters of the MD5 are directories.
+const template = "%2s/%2s/%s"
+
+var dir string // set by -refCache
+
+// AddFlag adds the -refCache option to fs.
+func AddFlag(fs *flag.FlagSet) {
+	fs.StringVar(&dir, Flag, dir, "Directory of reference sequences for decoding cram, stored by MD5 as in the samtools REF_CACHE. Sequences of the reference are added and missing sequences are downloaded. Defaults to ref in $DUPLEXTOOLS_CACHE or the user cache directory.")
+}
+
+// FromArgs sets the cache directory from a -refCache option in args, for use before
+// flags are parsed.
+func FromArgs(args []string) {
+	for i, a := range args {
+		if a == "--" {
+			return
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != Flag {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+		}
+		dir = value
+	}
+}
+
+// Dir returns the cache directory set with -refCache, or defaultDir if none was set.
+func Dir(defaultDir string) string {
+	if dir != "" {
+		return dir
+	}
+	return defaultDir
+}
+
+// Path returns the path in the cache directory d of the sequence with the hex MD5 sum.
+func Path(d, sum string) string {
+	return filepath.Join(d, sum[:2], sum[2:4], sum[4:])
+}
+
+// Setenv points htslib at the cache directory d. REF_CACHE is set to d, so downloaded
+// sequences are saved there, and d is searched first in REF_PATH, followed by the
+// REF_PATH already set or, if none, the download URL.
+func Setenv(d string) error {
+	cache := filepath.Join(d, template)
+	path := os.Getenv("REF_PATH")
+	if path == "" {
+		path = "URL=" + URL
+	}
+	if err := os.Setenv("REF_PATH", cache+":"+path); err != nil {
+		return err
+	}
+	return os.Setenv("REF_CACHE", cache)
+}
+
+// Populate adds each sequence of the fasta file, which may be gzip or bgzip compressed,
+// to the cache directory d under the MD5 of the sequence normalized as samtools does:
+// upper case, with every character that is not printable removed. Sequences already in the cache are not written again, and
+// a fasta that has not changed since it was last added is not read again. The temporary
+// file of a sequence being written is removed if Populate fails.
+func Populate(d, fasta string) (err error) {
+	info, err := os.Stat(fasta)
+	if err != nil {
+		return err
+	}
+	abs, err := filepath.Abs(fasta)
+	if err != nil {
+		return err
+	}
+	stamp := sha256.Sum256([]byte(fmt.Sprintf("%s\t%d\t%d", abs, info.Size(), info.ModTime().UnixNano())))
+	done := filepath.Join(d, "populated", hex.EncodeToString(stamp[:]))
+	if _, err = os.Stat(done); err == nil {
+		return nil
+	}
+
+	f, err := os.Open(fasta)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(fasta, ".gz") {
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	if err = os.MkdirAll(d, 0755); err != nil {
+		return err
+	}
+	s := bufio.NewScanner(r)
+	s.Buffer(make([]byte, 1<<20), 1<<30)
+	var seq *sequence
+	defer func() {
+		if err != nil {
+			seq.abort()
+		}
+	}()
+	for s.Scan() {
+		line := s.Bytes()
+		if len(line) > 0 && line[0] == '>' {
+			if err = seq.close(); err != nil {
+				return err
+			}
+			if seq, err = newSequence(d); err != nil {
+				return err
+			}
+			continue
+		}
+		if seq == nil {
+			return fmt.Errorf("%s is not a fasta file", fasta)
+		}
+		if err = seq.write(line); err != nil {
+			return err
+		}
+	}
+	if err = s.Err(); err != nil {
+		return err
+	}
+	if err = seq.close(); err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(done), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(done, []byte(abs+"\n"), 0644)
+}
+
+// sequence is a normalized sequence being written to a temporary file in the cache,
+// which is moved to its Path once its MD5 is known.
+type sequence struct {
+	dir string
+	tmp *os.File
+	w   *bufio.Writer
+	sum hash.Hash
+	buf []byte
+}
+
+func newSequence(d string) (*sequence, error) {
+	tmp, err := os.CreateTemp(d, ".seq-")
+	if err != nil {
+		return nil, err
+	}
+	return &sequence{dir: d, tmp: tmp, w: bufio.NewWriter(tmp), sum: md5.New()}, nil
+}
+
+// write adds the bases of a fasta line.
+func (s *sequence) write(line []byte) error {
+	s.buf = s.buf[:0]
+	for _, c := range line {
+		if c < '!' || c > '~' {
+			continue
+		}
+		if c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		s.buf = append(s.buf, c)
+	}
+	s.sum.Write(s.buf)
+	_, err := s.w.Write(s.buf)
+	return err
+}
+
+// abort removes the temporary file of a sequence that will not be closed. A nil or
+// closed sequence is ignored.
+func (s *sequence) abort() {
+	if s == nil || s.tmp == nil {
+		return
+	}
+	s.tmp.Close()
+	os.Remove(s.tmp.Name())
+	s.tmp = nil
+}
+
+// close moves the sequence to its Path, or removes it if the cache already has it. A
+// nil sequence is ignored.
+func (s *sequence) close() error {
+	if s == nil {
+		return nil
+	}
+	tmp := s.tmp.Name()
+	err := s.w.Flush()
+	if closeErr := s.tmp.Close(); err == nil {
+		err = closeErr
+	}
+	s.tmp = nil
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	path := Path(s.dir, hex.EncodeToString(s.sum.Sum(nil)))
+	if _, err = os.Stat(path); err == nil {
+		return os.Remove(tmp)
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path) // atomic, so concurrent runs never read a partial sequence
+}
@@ -0,0 +1,87 @@
+package refcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPopulate(t *testing.T) {
+	d := filepath.Join(t.TempDir(), "cache")
+	fasta := filepath.Join(t.TempDir(), "ref.fa")
+	err := os.WriteFile(fasta, []byte(">chr1 first\nacgtn\nACGTA\n>chr2\nGGC\nCTT\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Populate(d, fasta); err != nil {
+		t.Fatal(err)
+	}
+	for sum, expected := range map[string]string{
+		"6dd2ea8ce477d9c1471cfb2304cefda3": "ACGTNACGTA",
+		"5d7fff6e87a1a58b80295bc50da15344": "GGCCTT",
+	} {
+		seq, err := os.ReadFile(Path(d, sum))
+		if err != nil {
+			t.Fatalf("expected %s in the cache: %s", expected, err)
+		}
+		if string(seq) != expected {
+			t.Errorf("expected the normalized sequence %s, got %s", expected, seq)
+		}
+	}
+
+	// an unchanged fasta is not read again
+	if err = os.Remove(Path(d, "5d7fff6e87a1a58b80295bc50da15344")); err != nil {
+		t.Fatal(err)
+	}
+	if err = Populate(d, fasta); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(Path(d, "5d7fff6e87a1a58b80295bc50da15344")); err == nil {
+		t.Error("expected a populated fasta to be skipped")
+	}
+}
+
+func TestPopulateTruncated(t *testing.T) {
+	d := t.TempDir()
+	fasta := filepath.Join(t.TempDir(), "ref.fa.gz")
+	var b bytes.Buffer
+	gz := gzip.NewWriter(&b)
+	gz.Write([]byte(">chr1\n" + strings.Repeat("ACGT\n", 1000)))
+	gz.Close()
+	err := os.WriteFile(fasta, b.Bytes()[:b.Len()-10], 0644) // cut into the gzip trailer
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = Populate(d, fasta); err == nil {
+		t.Fatal("expected an error for a truncated fasta")
+	}
+	left, _ := filepath.Glob(filepath.Join(d, ".seq-*"))
+	if len(left) > 0 {
+		t.Errorf("expected temporary files to be removed, found %v", left)
+	}
+}
+
+func TestSetenv(t *testing.T) {
+	t.Setenv("REF_PATH", "")
+	t.Setenv("REF_CACHE", "")
+	if err := Setenv("/cache"); err != nil {
+		t.Fatal(err)
+	}
+	if actual := os.Getenv("REF_PATH"); actual != "/cache/%2s/%2s/%s:URL="+URL {
+		t.Errorf("unexpected REF_PATH %s", actual)
+	}
+	if actual := os.Getenv("REF_CACHE"); actual != "/cache/%2s/%2s/%s" {
+		t.Errorf("unexpected REF_CACHE %s", actual)
+	}
+
+	t.Setenv("REF_PATH", "/refs/%s")
+	if err := Setenv("/cache"); err != nil {
+		t.Fatal(err)
+	}
+	if actual := os.Getenv("REF_PATH"); actual != "/cache/%2s/%2s/%s:/refs/%s" {
+		t.Errorf("expected the REF_PATH already set to be kept, got %s", actual)
+	}
+}
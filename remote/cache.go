@@ -0,0 +1,129 @@
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// companions lists the index suffixes fetched alongside a remote data file so that
+// code opening path+".bai" etc. finds them next to the localized copy.
+var companions = []struct {
+	dataSuffixes []string
+	indexes      []string
+}{
+	{[]string{".bam"}, []string{".bai", ".csi"}},
+	{[]string{".cram"}, []string{".crai"}},
+	{[]string{".fa", ".fasta", ".fna", ".fa.gz", ".fasta.gz", ".fna.gz"}, []string{".fai", ".gzi"}},
+	{[]string{".vcf.gz", ".bed.gz", ".bcf"}, []string{".tbi", ".csi"}},
+}
+
+var indexSuffixes = []string{".bai", ".csi", ".crai", ".fai", ".gzi", ".tbi"}
+
+// CacheDir returns the directory where remote inputs and their indexes are cached
+// between runs. It is $DUPLEXTOOLS_CACHE if set, otherwise a duplexTools directory in
+// the user cache. Files in it may be removed at any time between runs.
+func CacheDir() string {
+	if dir := os.Getenv("DUPLEXTOOLS_CACHE"); dir != "" {
+		return dir
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return filepath.Join(os.TempDir(), "duplexTools")
+	}
+	return filepath.Join(dir, "duplexTools")
+}
+
+// Localize makes the remote file at path available in dir and returns the local path.
+// Index files for the data file (e.g. the .bai of a bam) are placed next to it when
+// they exist. Files are kept in CacheDir by their ETag and only downloaded again if they
+// change remotely, so the local path is a link to the cached copy. The returned error
+// wraps fs.ErrNotExist if path does not exist.
+func Localize(path, dir string) (string, error) {
+	if isIndex(path) {
+		return cached(path)
+	}
+
+	local := filepath.Join(dir, baseName(path))
+	data, err := cached(path)
+	if err != nil {
+		return "", err
+	}
+	err = os.Symlink(data, local)
+	if err != nil {
+		return "", err
+	}
+
+	for _, c := range companions {
+		suffix, ok := hasAnySuffix(path, c.dataSuffixes)
+		if !ok {
+			continue
+		}
+		for _, idx := range c.indexes {
+			// check both file.bam.bai and file.bai
+			for _, candidate := range []string{path + idx, strings.TrimSuffix(path, suffix) + idx} {
+				exists, _, err := Stat(candidate)
+				if err != nil {
+					return "", err
+				}
+				if !exists {
+					continue
+				}
+				index, err := cached(candidate)
+				if err != nil {
+					return "", err
+				}
+				err = os.Symlink(index, local+idx)
+				if err != nil {
+					return "", err
+				}
+				break
+			}
+		}
+	}
+	return local, nil
+}
+
+// cached returns the path of a cached copy of the remote file at path, downloading it
+// if it is missing or has changed. Objects sent without an ETag, size, or modification
+// time cannot be checked for changes and are downloaded again every time.
+func cached(path string) (string, error) {
+	exists, tag, err := Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", fmt.Errorf("remote: %s: %w", path, fs.ErrNotExist)
+	}
+	sum := sha256.Sum256([]byte(path + "\x00" + tag))
+	dir := CacheDir()
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		return "", err
+	}
+	local := filepath.Join(dir, hex.EncodeToString(sum[:8])+"_"+baseName(path))
+	if _, err = os.Stat(local); err == nil && tag != "" {
+		return local, nil
+	}
+	log.Printf("Downloading %s to the cache %s", path, dir)
+	return local, Download(path, local)
+}
+
+func isIndex(path string) bool {
+	_, ok := hasAnySuffix(baseName(path), indexSuffixes)
+	return ok
+}
+
+func hasAnySuffix(s string, suffixes []string) (string, bool) {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return suffix, true
+		}
+	}
+	return "", false
+}
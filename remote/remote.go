@@ -0,0 +1,197 @@
+// Package remote reads and writes files stored at s3://, gs://, http://, and https:// URLs.
+//
+// S3 requests are signed with AWS signature version 4 when AWS_ACCESS_KEY_ID and
+// AWS_SECRET_ACCESS_KEY are set (AWS_SESSION_TOKEN is sent if present). The region is
+// taken from AWS_REGION or AWS_DEFAULT_REGION and AWS_ENDPOINT_URL may point to an
+// S3 compatible server. GCS requests use the bearer token in GOOGLE_OAUTH_ACCESS_TOKEN
+// (e.g. from `gcloud auth print-access-token`). Requests without credentials are sent
+// unsigned, which works for public objects and presigned URLs.
+package remote
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var client = &http.Client{Timeout: 0}
+
+// IsRemote reports whether path is a URL handled by this package.
+func IsRemote(path string) bool {
+	for _, prefix := range []string{"s3://", "gs://", "http://", "https://"} {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Open returns a stream of the object at path.
+func Open(path string) (io.ReadCloser, error) {
+	resp, err := do(http.MethodGet, path, nil, 0)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("remote: GET %s: %s", path, resp.Status)
+	}
+	return resp.Body, nil
+}
+
+// Stat returns whether the object at path exists along with a tag that changes when the
+// object changes (the ETag, or the size and modification time if no ETag is sent).
+func Stat(path string) (exists bool, tag string, err error) {
+	resp, err := do(http.MethodHead, path, nil, 0)
+	if err != nil {
+		return false, "", err
+	}
+	resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound, http.StatusForbidden: // S3 returns 403 for missing objects without list permission
+		return false, "", nil
+	default:
+		return false, "", fmt.Errorf("remote: HEAD %s: %s", path, resp.Status)
+	}
+	tag = resp.Header.Get("ETag")
+	if tag == "" {
+		tag = resp.Header.Get("Content-Length") + resp.Header.Get("Last-Modified")
+	}
+	return true, tag, nil
+}
+
+// Download copies the object at path to the local file dst. The object is written to a
+// temporary file next to dst that is renamed when complete, so concurrent runs filling
+// the same cache never see a partial file.
+func Download(path, dst string) error {
+	r, err := Open(path)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".*.part")
+	if err != nil {
+		return err
+	}
+	tmp := out.Name()
+	_, err = io.Copy(out, r)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("remote: downloading %s: %w", path, err)
+	}
+	return os.Rename(tmp, dst)
+}
+
+// Upload copies the local file src to the object at path. Files larger than
+// multipartThreshold are sent to S3 in parts (see uploadParts).
+func Upload(src, path string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if strings.HasPrefix(path, "s3://") && info.Size() > multipartThreshold {
+		return uploadParts(f, info.Size(), path)
+	}
+	resp, err := do(http.MethodPut, path, f, info.Size())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote: PUT %s: %s %s", path, resp.Status, msg)
+	}
+	return nil
+}
+
+// do sends a request for path, translating s3:// and gs:// to their https endpoints
+// and adding credentials from the environment.
+func do(method, path string, body io.Reader, size int64) (*http.Response, error) {
+	var req *http.Request
+	var err error
+	switch {
+	case strings.HasPrefix(path, "s3://"):
+		req, err = s3Request(method, path, nil, body, size, time.Now())
+	case strings.HasPrefix(path, "gs://"):
+		req, err = gsRequest(method, path, body, size)
+	default:
+		req, err = http.NewRequest(method, path, body)
+		if req != nil && body != nil {
+			req.ContentLength = size
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// splitBucket splits a URL of the form scheme://bucket/key.
+func splitBucket(path string) (bucket, key string, err error) {
+	_, rest, _ := strings.Cut(path, "://")
+	bucket, key, _ = strings.Cut(rest, "/")
+	if bucket == "" || key == "" {
+		return "", "", fmt.Errorf("remote: %s must be of the form scheme://bucket/key", path)
+	}
+	return bucket, key, nil
+}
+
+func gsRequest(method, path string, body io.Reader, size int64) (*http.Request, error) {
+	bucket, key, err := splitBucket(path)
+	if err != nil {
+		return nil, err
+	}
+	endpoint := "https://storage.googleapis.com"
+	if e := os.Getenv("GCS_ENDPOINT_URL"); e != "" {
+		endpoint = strings.TrimSuffix(e, "/")
+	}
+	req, err := http.NewRequest(method, endpoint+"/"+bucket+"/"+escapePath(key), body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return req, nil
+}
+
+// escapePath percent-encodes every byte of an object key except unreserved
+// characters and '/', as required for S3 canonical requests.
+func escapePath(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || c == '-' || c == '_' || c == '.' || c == '~' ||
+			(c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
+
+// baseName returns the last path element of a URL, ignoring any query string.
+func baseName(path string) string {
+	if u, err := url.Parse(path); err == nil && u.Path != "" {
+		path = u.Path
+	}
+	return path[strings.LastIndex(path, "/")+1:]
+}
@@ -0,0 +1,280 @@
+package remote
+
+import (
+	"errors"
+	"flag"
+	"github.com/dasnellings/duplexTools/config"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/hts"
+	"github.com/dasnellings/duplexTools/manifest"
+	"github.com/dasnellings/duplexTools/pipe"
+	"github.com/dasnellings/duplexTools/refcache"
+	"github.com/dasnellings/duplexTools/tmp"
+	"io"
+	iofs "io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// openConfig opens a -config file, which may be remote.
+func openConfig(path string) (io.ReadCloser, error) {
+	if IsRemote(path) {
+		return Open(path)
+	}
+	return os.Open(path)
+}
+
+// output is a remote output path and the local file written in its place.
+type output struct {
+	remote, local string
+}
+
+// outputs are the remote outputs replaced by Prepare, uploaded by Run when main returns.
+var outputs []output
+
+// Run calls main and then uploads the local files that Prepare wrote in place of remote
+// outputs. The options of a -config file are added to os.Args first, so its paths are
+// handled too. Cram inputs are rejected with exit.MalformedInput before anything is
+// read unless built with htslib (see rejectCram). The intermediate files of main,
+// including downloads and pipe copies, are kept in tmp.Dir, which is removed when main
+// returns. The -manifest of a run is written once main returns. Nothing is uploaded for
+// a dry run.
+func Run(main func()) {
+	args, err := config.Expand(os.Args, openConfig)
+	if err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+	os.Args = args
+	rejectCram(os.Args)
+	tmp.FromArgs(os.Args[1:])
+	refcache.FromArgs(os.Args[1:])
+	defer tmp.Cleanup()
+	main()
+	if isDryRun(os.Args[1:]) {
+		return
+	}
+	manifest.Write() // before uploading, so a remote manifest is uploaded with the outputs
+
+	for _, o := range outputs {
+		if _, err = os.Stat(o.local); err != nil {
+			log.Printf("WARNING: %s was not written, skipping upload.", o.remote)
+			continue
+		}
+		log.Printf("Uploading %s", o.remote)
+		err = Upload(o.local, o.remote)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+		for _, idx := range []string{".tbi", ".csi"} { // written by tabix.Create for .vcf.gz and .bed.gz outputs
+			if _, err = os.Stat(o.local + idx); err != nil {
+				continue
+			}
+			err = Upload(o.local+idx, o.remote+idx)
+			if err != nil {
+				log.Fatalf("ERROR: %s", err)
+			}
+		}
+	}
+}
+
+// Prepare replaces every remote path in os.Args with a local path. It must be called
+// once the flags of fs are defined and before they are parsed, as dryrun.Parse does. A
+// remote path is an output if it is the value of a flag of fs that manifest.IsOutput
+// reports as an output, or a positional argument whose position (counting from 0) is
+// in positionalOutputs, and is uploaded by Run when main returns. Every other remote
+// path is an input and is made local with Localize; an input that does not exist exits
+// with an error. Inputs that are pipes, such as process substitutions, are replaced
+// with pipe.Localize, and an input of "-" with pipe.Stdin, which may only be given once.
+// Nothing is downloaded for a dry run, which checks that remote inputs exist instead.
+func Prepare(fs *flag.FlagSet, positionalOutputs ...int) {
+	if isDryRun(os.Args[1:]) {
+		return
+	}
+	var dir string
+	var fromStdin, positional bool
+	var position int
+	for i := 1; i < len(os.Args); i++ {
+		var name, value string
+		var isOutput, inline bool
+		switch arg := os.Args[i]; {
+		case !positional && arg == "--":
+			positional = true
+			continue
+		case !positional && strings.HasPrefix(arg, "-") && arg != "-": // a lone - is stdin
+			name, value, inline = strings.Cut(strings.TrimLeft(arg, "-"), "=")
+			f := fs.Lookup(name)
+			if !inline {
+				if isBoolFlag(f) || i+1 == len(os.Args) {
+					continue
+				}
+				i++
+				value = os.Args[i]
+			}
+			if name == tmp.Flag || name == refcache.Flag { // local directories
+				continue
+			}
+			isOutput = manifest.IsOutput(f)
+		default: // flag.Parse stops at the first positional argument
+			positional = true
+			value = arg
+			for _, o := range positionalOutputs {
+				isOutput = isOutput || o == position
+			}
+			position++
+		}
+
+		stdin := value == "-" && !isOutput
+		if stdin && fromStdin {
+			log.Fatal("ERROR: stdin (-) can only be read by one input.")
+		}
+		fromStdin = fromStdin || stdin
+		fromPipe := !IsRemote(value) && !isOutput && (stdin || pipe.Is(value))
+		if !IsRemote(value) && !fromPipe {
+			continue
+		}
+		if dir == "" {
+			dir = tmp.Path("remote")
+			err := os.Mkdir(dir, 0755)
+			if err != nil {
+				log.Fatalf("ERROR: could not create directory for remote and piped inputs: %s", err)
+			}
+		}
+		sub := filepath.Join(dir, strconv.Itoa(i)) // keeps files with the same name apart
+		err := os.Mkdir(sub, 0755)
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+
+		var local string
+		switch {
+		case stdin:
+			local, err = pipe.Stdin(sub)
+		case fromPipe:
+			local, err = pipe.Localize(value, sub)
+		case isOutput:
+			local = filepath.Join(sub, baseName(value))
+			outputs = append(outputs, output{remote: value, local: local})
+		default:
+			local, err = Localize(value, sub)
+			if errors.Is(err, iofs.ErrNotExist) {
+				log.Fatalf("ERROR: the input %s does not exist. Remote outputs must be given to an output option such as -o.", value)
+			}
+		}
+		if err != nil {
+			log.Fatalf("ERROR: %s", err)
+		}
+
+		if !fromPipe {
+			manifest.Alias(local, value)
+		}
+		if inline {
+			os.Args[i] = "-" + name + "=" + local
+		} else {
+			os.Args[i] = local
+		}
+	}
+	useRefCache(os.Args)
+}
+
+// isBoolFlag reports whether f is a boolean flag, which takes no separate value.
+func isBoolFlag(f *flag.Flag) bool {
+	if f == nil {
+		return false
+	}
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// rejectCram exits with exit.MalformedInput if an input in args is a cram file and
+// duplexTools was built without htslib. Reads are then decoded by gonomics, which only
+// reads sam and bam, so a cram would otherwise fail part way through with a gzip error.
+// Cram support is partial even with htslib: commands that read by region through an
+// index, such as mcsCallVariants without -stream, reject cram in preflight.Bam.Check.
+func rejectCram(args []string) {
+	if hts.Cram {
+		return
+	}
+	if value, found := cramInput(args); found {
+		exit.Fatalf(exit.MalformedInput, "%s is a cram file, which duplexTools cannot read unless built with -tags htslib, and then only in commands that stream the whole file; commands that read by region, such as mcsCallVariants without -stream, need a bam in every build. Convert it to bam with samtools view -b -T ref.fa -o out.bam %s and index the bam.", value, value)
+	}
+}
+
+// useRefCache points htslib at the reference cache (see refcache) if an input in args
+// is a cram file, after adding the sequences of the -r reference, or of the global -r
+// of duplexTools, to the cache. Sequences of a cram that are in neither are downloaded
+// by htslib. args must have their remote paths localized.
+func useRefCache(args []string) {
+	if !hts.Cram {
+		return
+	}
+	if _, found := cramInput(args); !found {
+		return
+	}
+	d := refcache.Dir(filepath.Join(CacheDir(), "ref"))
+	ref := hts.Reference
+	if IsRemote(ref) {
+		ref = ""
+	}
+	for i := 1; i < len(args); i++ {
+		if name, value, hasValue := flagValue(args, i); hasValue && name == "r" {
+			ref = value
+		}
+	}
+	if ref != "" && !pipe.Is(ref) {
+		log.Printf("Adding the sequences of %s to the reference cache %s", ref, d)
+		if err := refcache.Populate(d, ref); err != nil {
+			log.Fatalf("ERROR: could not add %s to the reference cache %s: %s. Set -%s to a writable directory.", ref, d, err, refcache.Flag)
+		}
+	}
+	if err := refcache.Setenv(d); err != nil {
+		log.Fatalf("ERROR: %s", err)
+	}
+}
+
+// cramInput returns the first input in args that is a cram file.
+func cramInput(args []string) (string, bool) {
+	for i := 1; i < len(args); i++ {
+		name, value, hasValue := flagValue(args, i)
+		if hasValue && !isOutputName(name) && hts.IsCram(value) {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// isDryRun reports whether args contain the -dry-run option added by package dryrun,
+// which cannot be imported here because it checks remote paths with Stat.
+func isDryRun(args []string) bool {
+	for _, a := range args {
+		switch a {
+		case "--":
+			return false
+		case "-dry-run", "--dry-run", "-dry-run=true", "--dry-run=true":
+			return true
+		}
+	}
+	return false
+}
+
+// flagValue returns the flag name and value of args[i]. Values given as -flag=value
+// and positional arguments (with an empty name) are handled as well as -flag value.
+func flagValue(args []string, i int) (name, value string, ok bool) {
+	arg := args[i]
+	if strings.HasPrefix(arg, "-") && arg != "-" { // a lone - is stdin
+		name, value, ok = strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		return name, value, ok
+	}
+	if i > 1 && strings.HasPrefix(args[i-1], "-") && !strings.Contains(args[i-1], "=") {
+		return strings.TrimLeft(args[i-1], "-"), arg, true
+	}
+	return "", arg, true
+}
+
+// isOutputName reports whether a flag named name sets an output file.
+func isOutputName(name string) bool {
+	lower := strings.ToLower(name)
+	return lower == "o" || strings.HasSuffix(lower, "out") || strings.HasSuffix(lower, "output")
+}
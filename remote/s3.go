@@ -0,0 +1,235 @@
+package remote
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// unsignedPayload skips hashing the request body, which S3 allows over https.
+const unsignedPayload = "UNSIGNED-PAYLOAD"
+
+// multipart uploads are used above multipartThreshold bytes, as a single PUT to S3 is
+// limited to 5 GB. S3 allows at most 10000 parts, so files up to 2.5 TB can be uploaded.
+var (
+	multipartThreshold int64 = 1 << 30
+	partSize           int64 = 256 << 20
+)
+
+// s3Request returns a request for the object at path with the parameters in query,
+// which may be nil.
+func s3Request(method, path string, query url.Values, body io.Reader, size int64, t time.Time) (*http.Request, error) {
+	bucket, key, err := splitBucket(path)
+	if err != nil {
+		return nil, err
+	}
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	var u string
+	if endpoint := os.Getenv("AWS_ENDPOINT_URL"); endpoint != "" {
+		u = strings.TrimSuffix(endpoint, "/") + "/" + bucket + "/" + escapePath(key) // path style for S3 compatible servers
+	} else {
+		u = fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", bucket, region, escapePath(key))
+	}
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query) // sent as signed
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.ContentLength = size
+	}
+
+	accessKey := os.Getenv("AWS_ACCESS_KEY_ID")
+	secretKey := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	if accessKey == "" || secretKey == "" {
+		return req, nil
+	}
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signV4(req, unsignedPayload, accessKey, secretKey, region, t)
+	return req, nil
+}
+
+// signV4 adds an AWS signature version 4 Authorization header to req covering the host
+// and every header already set on req.
+func signV4(req *http.Request, payloadHash, accessKey, secretKey, region string, t time.Time) {
+	amzDate := t.UTC().Format("20060102T150405Z")
+	day := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, vals := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(vals, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := day + "/" + region + "/s3/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hashHex([]byte(canonicalRequest))
+
+	signingKey := hmacSha256([]byte("AWS4"+secretKey), day)
+	signingKey = hmacSha256(signingKey, region)
+	signingKey = hmacSha256(signingKey, "s3")
+	signingKey = hmacSha256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSha256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s", accessKey, scope, signedHeaders, signature))
+}
+
+// canonicalQuery returns query sorted by name and value with every byte except
+// unreserved characters percent-encoded, as required for S3 canonical requests.
+func canonicalQuery(query url.Values) string {
+	var params []string
+	for name, vals := range query {
+		for _, v := range vals {
+			params = append(params, escapeQuery(name)+"="+escapeQuery(v))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+func escapeQuery(s string) string {
+	return strings.ReplaceAll(escapePath(s), "/", "%2F")
+}
+
+// part is an uploaded part listed when completing a multipart upload.
+type part struct {
+	PartNumber int
+	ETag       string
+}
+
+// uploadParts copies the size bytes of f to the S3 object at path with a multipart
+// upload. The upload is aborted if a part fails, so S3 does not keep the parts.
+func uploadParts(f *os.File, size int64, path string) error {
+	var started struct {
+		UploadId string
+	}
+	err := s3Do(http.MethodPost, path, url.Values{"uploads": {""}}, nil, 0, &started, nil)
+	if err != nil {
+		return fmt.Errorf("remote: starting multipart upload of %s: %w", path, err)
+	}
+
+	var completed struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}
+	for n, offset := 1, int64(0); offset < size; n, offset = n+1, offset+partSize {
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		var header http.Header
+		query := url.Values{"partNumber": {strconv.Itoa(n)}, "uploadId": {started.UploadId}}
+		err = s3Do(http.MethodPut, path, query, io.NewSectionReader(f, offset, length), length, nil, &header)
+		if err != nil {
+			abortUpload(path, started.UploadId)
+			return fmt.Errorf("remote: uploading part %d of %s: %w", n, path, err)
+		}
+		completed.Parts = append(completed.Parts, part{PartNumber: n, ETag: header.Get("ETag")})
+	}
+
+	body, err := xml.Marshal(completed)
+	if err != nil {
+		return err
+	}
+	var result struct { // S3 may report an error after sending 200
+		XMLName xml.Name
+		Code    string
+		Message string
+	}
+	err = s3Do(http.MethodPost, path, url.Values{"uploadId": {started.UploadId}}, bytes.NewReader(body), int64(len(body)), &result, nil)
+	if err == nil && result.XMLName.Local == "Error" {
+		err = fmt.Errorf("%s %s", result.Code, result.Message)
+	}
+	if err != nil {
+		abortUpload(path, started.UploadId)
+		return fmt.Errorf("remote: completing multipart upload of %s: %w", path, err)
+	}
+	return nil
+}
+
+// abortUpload discards the parts of a failed multipart upload.
+func abortUpload(path, id string) {
+	err := s3Do(http.MethodDelete, path, url.Values{"uploadId": {id}}, nil, 0, nil, nil)
+	if err != nil {
+		log.Printf("WARNING: could not abort the multipart upload of %s, its parts are kept by S3 until removed: %s", path, err)
+	}
+}
+
+// s3Do sends an S3 request and decodes the XML response into result and its header into
+// header when they are not nil.
+func s3Do(method, path string, query url.Values, body io.Reader, size int64, result any, header *http.Header) error {
+	req, err := s3Request(method, path, query, body, size, time.Now())
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s %s", resp.Status, msg)
+	}
+	if header != nil {
+		*header = resp.Header
+	}
+	if result != nil {
+		return xml.NewDecoder(resp.Body).Decode(result)
+	}
+	return nil
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hashHex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,484 @@
+package repeatcall
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/dasnellings/duplexTools/gmm"
+	"github.com/guptarohit/asciigraph"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"github.com/vertgenlab/gonomics/vcf"
+	"golang.org/x/exp/slices"
+	"gonum.org/v1/gonum/stat"
+	"log"
+	"math"
+	"path"
+	"strings"
+)
+
+// Genotyper holds buffers reused between calls to CallGenotypes. It is not safe for concurrent use.
+type Genotyper struct {
+	buf     [2][11]float64
+	readBuf []float64
+}
+
+// CallGenotypes returns a vcf record for region with one sample per entry in mm, the
+// mixture models fit to the observedLengths of each sample with FitMixtureModel.
+func (g *Genotyper) CallGenotypes(ref *fai.Seeker, region bed.Bed, minReads int, enclosingReads [][]*sam.Sam, observedLengths [][]int, mm []*gmm.MixtureModel) (vcf.Vcf, bool) {
+	var ans vcf.Vcf
+	repeatUnitLen, refNumRepeats := ParseRepeatSeq(region.Name)
+	refRepeatLen := refNumRepeats * len(repeatUnitLen)
+	ans.Chr = region.Chrom
+	ans.Pos = region.ChromStart
+	refSeq, err := ref.SeekByName(region.Chrom, region.ChromStart, region.ChromEnd)
+	exception.PanicOnErr(err)
+	dna.AllToUpper(refSeq)
+	ans.Ref = dna.BasesToString(refSeq)
+	ans.Ref = "*" // TODO Remove
+	//if len(ans.Ref) != refRepeatLen {
+	//	log.Panicf("ERROR: %s ref seq is \n%s\n the length of %d does not match expected %d from bed file.", region, ans.Ref[1:], len(ans.Ref), refRepeatLen)
+	//}
+
+	ans.Id = region.Name
+
+	/*
+		altLens := make([]int, 2)
+		var refLenDiff int
+		for i, l := range mm[0].Means {
+			altLens[i] = int(math.Round(l))
+			refLenDiff = refRepeatLen - altLens[i]
+			for _, alts := range ans.Alt {
+				if len(alts) == altLens[i] {
+					refLenDiff = 0 // to engage break below
+				}
+			}
+			if refLenDiff == 0 {
+				continue
+			}
+			ans.Alt = append(ans.Alt, ans.Ref[0:len(ans.Ref)-refLenDiff-1])
+		}
+	*/
+	ans.Alt = append(ans.Alt, "*")
+	ans.Filter = "."
+	ans.Id = region.Name
+	ans.Format = []string{"GT", "DP", "MU", "SD", "WT", "LL", "AD", "KS", "CG", "HS", "HG", "RL"}
+	ans.Samples = make([]vcf.Sample, len(mm))
+	var goodnessOfFit0, goodnessOfFit1, pulseHeuristic0, pulseHeuristic1 float64
+	var allele0Reads, allele1Reads, minKsLen0, minKsLen1, optimalHeuristicLen0, optimalHeuristicLen1 int
+	var readLenString0, readLenString1 string
+
+	//for j := range mm[0].Data {
+	//	fmt.Printf("%0.0f, %0.1f, %0.1f\t", mm[0].Data[j], mm[0].Posteriors[0][j], mm[0].Posteriors[1][j])
+	//}
+
+	for i := range ans.Samples {
+		ans.Samples[i].FormatData = make([]string, 12)
+		ans.Samples[i].FormatData[1] = fmt.Sprintf("%d", len(observedLengths[i]))
+
+		if mm[i].LogLikelihood == math.MaxFloat64 {
+			ans.Samples[i].FormatData[2] = "."
+			ans.Samples[i].FormatData[3] = "."
+			ans.Samples[i].FormatData[4] = "."
+			ans.Samples[i].FormatData[5] = "."
+			ans.Samples[i].FormatData[6] = "."
+			ans.Samples[i].FormatData[7] = "."
+			ans.Samples[i].FormatData[8] = "."
+			ans.Samples[i].FormatData[9] = "."
+			ans.Samples[i].FormatData[10] = "."
+			ans.Samples[i].FormatData[11] = "."
+			continue
+		}
+		ans.Samples[i].FormatData[5] = fmt.Sprintf("%.1g", mm[i].LogLikelihood)
+
+		goodnessOfFit0, allele0Reads, minKsLen0 = g.PulseFitKS(mm[i], 0, len(repeatUnitLen), false)
+		goodnessOfFit1, allele1Reads, minKsLen1 = g.PulseFitKS(mm[i], 1, len(repeatUnitLen), false)
+		pulseHeuristic0, _, optimalHeuristicLen0 = PulseFitHeuristic(mm[i], 0, len(repeatUnitLen), false)
+		pulseHeuristic1, _, optimalHeuristicLen1 = PulseFitHeuristic(mm[i], 1, len(repeatUnitLen), false)
+		readLenString0 = getRunLengthEncoding(getReadsForK(mm[i], 0, &g.readBuf))
+		readLenString1 = getRunLengthEncoding(getReadsForK(mm[i], 1, &g.readBuf))
+
+		if mm[i].Means[0] < mm[i].Means[1] {
+			ans.Samples[i].FormatData[2] = fmt.Sprintf("%.1f,%.1f", mm[i].Means[0], mm[i].Means[1])
+			ans.Samples[i].FormatData[3] = fmt.Sprintf("%.1f,%.1f", mm[i].Stdev[0], mm[i].Stdev[1])
+			ans.Samples[i].FormatData[4] = fmt.Sprintf("%.1f,%.1f", mm[i].Weights[0], mm[i].Weights[1])
+			ans.Samples[i].FormatData[6] = fmt.Sprintf("%d,%d", allele0Reads, allele1Reads)
+			ans.Samples[i].FormatData[7] = fmt.Sprintf("%.3f,%.3f", goodnessOfFit0, goodnessOfFit1)
+			ans.Samples[i].FormatData[8] = fmt.Sprintf("%d,%d", minKsLen0, minKsLen1)
+			ans.Samples[i].FormatData[9] = fmt.Sprintf("%.3f,%.3f", pulseHeuristic0, pulseHeuristic1)
+			ans.Samples[i].FormatData[10] = fmt.Sprintf("%d,%d", optimalHeuristicLen0, optimalHeuristicLen1)
+			ans.Samples[i].FormatData[11] = fmt.Sprintf("%s;%s", readLenString0, readLenString1)
+		} else {
+			ans.Samples[i].FormatData[2] = fmt.Sprintf("%.1f,%.1f", mm[i].Means[1], mm[i].Means[0])
+			ans.Samples[i].FormatData[3] = fmt.Sprintf("%.1f,%.1f", mm[i].Stdev[1], mm[i].Stdev[0])
+			ans.Samples[i].FormatData[4] = fmt.Sprintf("%.1f,%.1f", mm[i].Weights[1], mm[i].Weights[0])
+			ans.Samples[i].FormatData[6] = fmt.Sprintf("%d,%d", allele1Reads, allele0Reads)
+			ans.Samples[i].FormatData[7] = fmt.Sprintf("%.3f,%.3f", goodnessOfFit1, goodnessOfFit0)
+			ans.Samples[i].FormatData[8] = fmt.Sprintf("%d,%d", minKsLen1, minKsLen0)
+			ans.Samples[i].FormatData[9] = fmt.Sprintf("%.3f,%.3f", pulseHeuristic1, pulseHeuristic0)
+			ans.Samples[i].FormatData[10] = fmt.Sprintf("%d,%d", optimalHeuristicLen1, optimalHeuristicLen0)
+			ans.Samples[i].FormatData[11] = fmt.Sprintf("%s;%s", readLenString1, readLenString0)
+		}
+	}
+
+	ans.Info = fmt.Sprintf("RefLength=%d", refRepeatLen)
+	return ans, true
+}
+
+// FitMixtureModel fits a two component gaussian mixture model to data, keeping the
+// best of 10 restarts. mm and bestMm are swapped as scratch space so the returned
+// models must be passed back in on the next call. f is reused to hold data as floats.
+func FitMixtureModel(data []int, mm, bestMm *gmm.MixtureModel, f *[]float64) (converged bool, newMm, newBestMm *gmm.MixtureModel) {
+	if cap(*f) >= len(data) {
+		*f = (*f)[0:len(data)]
+	} else {
+		*f = make([]float64, len(data))
+	}
+
+	for i := range data {
+		(*f)[i] = float64(data[i])
+	}
+
+	for i := 0; i < 10; i++ {
+		converged, _ = gmm.RunMixtureModel(*f, 2, 50, 50, mm)
+		if i == 0 {
+			mm, bestMm = bestMm, mm
+			continue
+		}
+		if mm.LogLikelihood < bestMm.LogLikelihood {
+			mm, bestMm = bestMm, mm
+		}
+	}
+	return converged, mm, bestMm
+}
+
+// PulseFitHeuristic scores how well the reads assigned to component k of mm fit
+// length changes in steps of period and returns the score, the number of reads, and
+// the best fitting peak length.
+func PulseFitHeuristic(mm *gmm.MixtureModel, k, period int, print bool) (float64, int, int) {
+	var readsIncluded, readLen int
+	var maxFitSum, startFitSum, lessFitSum, moreFitSum, less2FitSum, more2FitSum, startVal, lessVal, moreVal, less2Val, more2Val float64
+
+	// test original peak as well as +/- 1 to account for slip-up/down bias
+	var startPeak, lessPeak, morePeak, bestPeak, less2Peak, more2Peak int
+	startPeak = int(math.Round(mm.Means[k]))
+	lessPeak = startPeak - 1
+	less2Peak = startPeak - 2
+	morePeak = startPeak + 1
+	more2Peak = startPeak + 2
+
+	for i := range mm.Data {
+		readLen = int(mm.Data[i])
+		if getMaxK(mm.Posteriors, i) != k {
+			continue
+		}
+		readsIncluded++
+		startVal = gaussianY(mm.Data[i], 1, float64(startPeak), mm.Stdev[k]) // TODO values could be cached
+		lessVal = gaussianY(mm.Data[i], 1, float64(lessPeak), mm.Stdev[k])
+		moreVal = gaussianY(mm.Data[i], 1, float64(morePeak), mm.Stdev[k])
+		less2Val = gaussianY(mm.Data[i], 1, float64(less2Peak), mm.Stdev[k])
+		more2Val = gaussianY(mm.Data[i], 1, float64(more2Peak), mm.Stdev[k])
+
+		if (startPeak-readLen)%period == 0 {
+			startFitSum += startVal
+		} else {
+			startFitSum -= 1
+		}
+
+		if (lessPeak-readLen)%period == 0 {
+			lessFitSum += lessVal
+		} else {
+			lessFitSum -= 1
+		}
+
+		if (morePeak-readLen)%period == 0 {
+			moreFitSum += moreVal
+		} else {
+			moreFitSum -= 1
+		}
+
+		if (less2Peak-readLen)%period == 0 {
+			less2FitSum += less2Val
+		} else {
+			less2FitSum -= 1
+		}
+
+		if (more2Peak-readLen)%period == 0 {
+			more2FitSum += more2Val
+		} else {
+			more2FitSum -= 1
+		}
+	}
+
+	maxFitSum = math.Max(startFitSum, math.Max(lessFitSum, moreFitSum))
+	switch maxFitSum {
+	case startFitSum:
+		bestPeak = startPeak
+	case lessFitSum:
+		bestPeak = lessPeak
+	case moreFitSum:
+		bestPeak = morePeak
+	}
+
+	if print {
+		log.Printf("Pulse fit heuristic:\tlen=%d\tvalue=%0.2f\treads=%d\n", lessPeak, ((lessFitSum/float64(readsIncluded))+1)/2, readsIncluded)
+		log.Printf("Pulse fit heuristic:\tlen=%d\tvalue=%0.2f\treads=%d\n", startPeak, ((startFitSum/float64(readsIncluded))+1)/2, readsIncluded)
+		log.Printf("Pulse fit heuristic:\tlen=%d\tvalue=%0.2f\treads=%d\n", morePeak, ((moreFitSum/float64(readsIncluded))+1)/2, readsIncluded)
+	}
+	return ((maxFitSum / float64(readsIncluded)) + 1) / 2, readsIncluded, bestPeak
+}
+
+// PulseFitKS returns the minimum Kolmogorov-Smirnov statistic for the fit of the reads
+// assigned to component k of mm to a slippage model with steps of period, along with
+// the number of reads and the best fitting peak length.
+func (g *Genotyper) PulseFitKS(mm *gmm.MixtureModel, k, period int, print bool) (float64, int, int) {
+	// test original peak as well as +/- 1 to account for slip-up/down bias
+	var startPeak, lessPeak, morePeak, less2Peak, more2Peak int
+	startPeak = int(math.Round(mm.Means[k]))
+	lessPeak = startPeak - 1
+	less2Peak = startPeak - 2
+	morePeak = startPeak + 1
+	more2Peak = startPeak + 2
+
+	reads := getReadsForK(mm, k, &g.readBuf)
+	slices.Sort(reads)
+
+	expectedK0less2Val, expectedK0less2Weight := getExpectedValuesForK(mm, k, &g.buf, less2Peak, period, true)
+	ansLess2 := stat.KolmogorovSmirnov(reads, nil, expectedK0less2Val, expectedK0less2Weight)
+	if print {
+		//fmt.Println(reads)
+		//fmt.Println(expectedK0lessVal)
+		//fmt.Println(expectedK0lessWeight)
+		fmt.Printf("k=%d reads=%d peak=%d, ks=%0.4f\n", k, len(reads), less2Peak, ansLess2)
+	}
+
+	expectedK0lessVal, expectedK0lessWeight := getExpectedValuesForK(mm, k, &g.buf, lessPeak, period, true)
+	ansLess := stat.KolmogorovSmirnov(reads, nil, expectedK0lessVal, expectedK0lessWeight)
+	if print {
+		//fmt.Println(reads)
+		//fmt.Println(expectedK0lessVal)
+		//fmt.Println(expectedK0lessWeight)
+		fmt.Printf("k=%d reads=%d peak=%d, ks=%0.4f\n", k, len(reads), lessPeak, ansLess)
+	}
+
+	expectedK0startVal, expectedK0startWeight := getExpectedValuesForK(mm, k, &g.buf, startPeak, period, false)
+	ansStart := stat.KolmogorovSmirnov(reads, nil, expectedK0startVal, expectedK0startWeight)
+	if print {
+		//fmt.Println(expectedK0startVal)
+		//fmt.Println(expectedK0startWeight)
+		fmt.Printf("k=%d reads=%d peak=%d, ks=%0.4f\n", k, len(reads), startPeak, ansStart)
+	}
+
+	expectedK0moreVal, expectedK0moreWeight := getExpectedValuesForK(mm, k, &g.buf, morePeak, period, false)
+	ansMore := stat.KolmogorovSmirnov(reads, nil, expectedK0moreVal, expectedK0moreWeight)
+	if print {
+		//fmt.Println(expectedK0moreVal)
+		//fmt.Println(expectedK0moreWeight)
+		fmt.Printf("k=%d reads=%d peak=%d, ks=%0.4f\n", k, len(reads), morePeak, ansMore)
+	}
+
+	expectedK0more2Val, expectedK0more2Weight := getExpectedValuesForK(mm, k, &g.buf, more2Peak, period, false)
+	ansMore2 := stat.KolmogorovSmirnov(reads, nil, expectedK0more2Val, expectedK0more2Weight)
+	if print {
+		//fmt.Println(expectedK0moreVal)
+		//fmt.Println(expectedK0moreWeight)
+		fmt.Printf("k=%d reads=%d peak=%d, ks=%0.4f\n", k, len(reads), more2Peak, ansMore2)
+	}
+
+	minScore := minslice(ansStart, ansLess, ansMore, ansMore2, ansLess2)
+	switch minScore {
+	case ansStart:
+		return ansStart, len(reads), startPeak
+	case ansLess:
+		return ansLess, len(reads), lessPeak
+	case ansLess2:
+		return ansLess2, len(reads), less2Peak
+	case ansMore:
+		return ansMore, len(reads), morePeak
+	case ansMore2:
+		return ansMore2, len(reads), more2Peak
+	default:
+		panic("unreachable")
+	}
+}
+
+func getReadsForK(mm *gmm.MixtureModel, k int, readBuf *[]float64) []float64 {
+	*readBuf = (*readBuf)[:0]
+	if cap(*readBuf) < len(mm.Data) {
+		*readBuf = make([]float64, 0, len(mm.Data))
+	}
+
+	for i := range mm.Data {
+		if getMaxK(mm.Posteriors, i) != k {
+			continue
+		}
+		*readBuf = append(*readBuf, mm.Data[i])
+	}
+	return *readBuf
+}
+
+func getExpectedValuesForK(mm *gmm.MixtureModel, k int, buf *[2][11]float64, peak, period int, updateWeights bool) ([]float64, []float64) {
+	var currLen int = peak - (5 * period)
+	for i := 0; i < 11; i++ {
+		(*buf)[0][i] = float64(currLen)
+		if updateWeights {
+			(*buf)[1][i] = gaussianY(float64(currLen), 1, float64(peak), mm.Stdev[k])
+		}
+		currLen += period
+	}
+	return (*buf)[0][:], (*buf)[1][:]
+}
+
+func getMaxK(posteriors [][]float64, i int) int {
+	var maxK int
+	var maxVal float64
+	for k := range posteriors {
+		if posteriors[k][i] > maxVal {
+			maxK = k
+			maxVal = posteriors[k][i]
+		}
+	}
+	return maxK
+}
+
+func minslice(vals ...float64) float64 {
+	minval := vals[0]
+	for i := 1; i < len(vals); i++ {
+		if vals[i] < minval {
+			minval = vals[i]
+		}
+	}
+	return minval
+}
+
+func getRunLengthEncoding(s []float64) string {
+	ans := new(strings.Builder)
+	var currVal float64
+	var currCount int
+	for i := range s {
+		if s[i] != currVal {
+			if ans.Len() == 0 && currVal != 0 {
+				ans.WriteString(fmt.Sprintf("%d=%d", int(currVal), currCount))
+			} else if currVal != 0 {
+				ans.WriteString(fmt.Sprintf(",%d=%d", int(currVal), currCount))
+			}
+			currVal = s[i]
+			currCount = 1
+		} else {
+			currCount++
+		}
+	}
+
+	if ans.Len() == 0 && currVal != 0 {
+		ans.WriteString(fmt.Sprintf("%d=%d", int(currVal), currCount))
+	} else if currVal != 0 {
+		ans.WriteString(fmt.Sprintf(",%d=%d", int(currVal), currCount))
+	}
+	return ans.String()
+}
+
+// PrintFits prints the fit of each mixture model component and ascii plots of the
+// observed length distributions for debugging.
+func (g *Genotyper) PrintFits(observedLengths [][]int, minReads int, mm []*gmm.MixtureModel, period int) {
+	for i := range mm {
+		for k := range mm[i].Means {
+			fmt.Printf("k=%d mu=%0.2f stdev=%0.2f\tloglikelihood=%0.4g\n", k, mm[i].Means[k], mm[i].Stdev[k], mm[i].LogLikelihood)
+			g.PulseFitKS(mm[i], k, period, true)
+			PulseFitHeuristic(mm[i], k, period, true)
+		}
+	}
+	plot(observedLengths, minReads, mm, make([][]float64, 2))
+}
+
+func plot(observedLengths [][]int, minReads int, mm []*gmm.MixtureModel, gaussians [][]float64) {
+	readsPerSample := make([]int, len(observedLengths))
+	p := make([][]float64, len(observedLengths))
+	for i := range observedLengths {
+		p[i] = make([]float64, 100)
+		for j := range observedLengths[i] {
+			p[i][observedLengths[i][j]]++
+			readsPerSample[i]++
+		}
+	}
+	if len(observedLengths) == 1 && readsPerSample[0] < minReads {
+		return
+	}
+
+	for i := range p {
+		if readsPerSample[i] < minReads {
+			continue
+		}
+		//if i != 0 {
+		//	continue
+		//}
+		fmt.Println(asciigraph.Plot(p[i], asciigraph.Height(5), asciigraph.Precision(0), asciigraph.SeriesColors(asciigraph.AnsiColor(i))))
+
+		gaussians[0] = gaussianHist(mm[i].Weights[0], mm[i].Means[0], mm[i].Stdev[0])
+		gaussians[1] = gaussianHist(mm[i].Weights[1], mm[i].Means[1], mm[i].Stdev[1])
+
+		fmt.Println(asciigraph.PlotMany(gaussians, asciigraph.Precision(0), asciigraph.SeriesColors(
+			asciigraph.Red,
+			asciigraph.Yellow,
+			asciigraph.Green,
+			asciigraph.Blue,
+			asciigraph.Cyan,
+			asciigraph.BlueViolet,
+			asciigraph.Brown,
+			asciigraph.Gray,
+			asciigraph.Orange,
+			asciigraph.Olive,
+		), asciigraph.Height(10)))
+	}
+
+	//fmt.Println(asciigraph.PlotMany(p, asciigraph.Precision(0), asciigraph.SeriesColors(
+	//	asciigraph.Red,
+	//	asciigraph.Yellow,
+	//	asciigraph.Green,
+	//	asciigraph.Blue,
+	//	asciigraph.Cyan,
+	//	asciigraph.BlueViolet,
+	//	asciigraph.Brown,
+	//	asciigraph.Gray,
+	//	asciigraph.Orange,
+	//	asciigraph.Olive,
+	//), asciigraph.Height(10)))
+}
+
+func gaussianHist(weight, mean, stdev float64) []float64 {
+	y := make([]float64, 100)
+	for x := range y {
+		y[x] = gaussianY(float64(x), weight, mean, stdev)
+	}
+	return y
+}
+
+func gaussianY(x, weight, mean, stdev float64) float64 {
+	top := math.Pow(x-mean, 2)
+	bot := 2 * stdev * stdev
+	return weight * math.Exp(-top/bot)
+}
+
+// VcfHeader returns the header for genotypes of the tab separated bam files in
+// samples against referenceFile.
+func VcfHeader(samples string, referenceFile string) vcf.Header {
+	var header vcf.Header
+	header.Text = append(header.Text, "##fileformat=VCFv4.2")
+	header.Text = append(header.Text, fmt.Sprintf("##reference=%s", path.Clean(referenceFile)))
+	header.Text = append(header.Text, strings.TrimSuffix(fai.VcfContigHeader(referenceFile), "\n"))
+	header.Text = append(header.Text, "##FORMAT=<ID=GT,Number=1,Type=String,Description=\"Genotype\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=DP,Number=1,Type=Integer,Description=\"Total Read Depth\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=MU,Number=2,Type=Float,Description=\"Mean repeat length of each allele determined by gaussian mixture modelling.\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=SD,Number=2,Type=Float,Description=\"Standard deviation of the repeat length of each allele determined by gaussian mixture modelling.\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=WT,Number=2,Type=Float,Description=\"Weight assigned to each allele (rough estimate of allele frequency) determined by gaussian mixture modelling.\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=LL,Number=1,Type=Float,Description=\"Negative log likelihood of gaussian mixture model.\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=AD,Number=2,Type=Integer,Description=\"Number of reads assigned to each allele based on posteriors from gaussian modelling.\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=KS,Number=2,Type=Float,Description=\"Kolmogorov-Smirnov (KS) statistic for fit of data to oscillating slippage model dependent on repeat unit length.\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=CG,Number=2,Type=Integer,Description=\"Optimal repeat length fit as determined by minimum KS statistic.\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=HS,Number=2,Type=Float,Description=\"Heuristic score for fit of data to oscillating slippage model dependent on repeat unit length. Higher values indicate better fit to slippage model\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=HG,Number=2,Type=Integer,Description=\"Optimal repeat length fit as determined by maximum heuristic score.\">")
+	header.Text = append(header.Text, "##FORMAT=<ID=RL,Number=2,Type=String,Description=\"Run length encoding of read lengths for each allele separated by semicolons.\">")
+	header.Text = append(header.Text, "##INFO=<ID=RefLength,Number=1,Type=Integer,Description=\"Length in bp of the repeat in the reference genome.\">")
+	header.Text = append(header.Text, fmt.Sprintf("#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\tFORMAT\t%s", strings.Replace(samples, ".bam", "", -1)))
+	return header
+}
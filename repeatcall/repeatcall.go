@@ -0,0 +1,271 @@
+// Package repeatcall genotypes targeted short simple repeats. It holds the core of
+// genotypeTargetRepeats: collecting and realigning reads that enclose a repeat,
+// measuring the repeat length in each read, and fitting a two allele mixture
+// model to the observed lengths.
+package repeatcall
+
+import (
+	"context"
+	"fmt"
+	"github.com/vertgenlab/gonomics/bed"
+	"github.com/vertgenlab/gonomics/cigar"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/sam"
+	"golang.org/x/exp/slices"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Options control which reads are used to measure a repeat.
+type Options struct {
+	TargetPadding   int  // bases added to either side of a target when selecting reads for realignment
+	MinFlankOverlap int  // bases that must align on either side of the repeat for a read to enclose it
+	MinMapQ         int  // minimum mapping quality before realignment, -1 for no filter
+	RemoveDups      bool // remove reads with the same start and end as the previous read
+	Debug           int  // print the length measured in each read when > 2
+}
+
+// EnclosingReads realigns the reads near region with the aligners reading from
+// alignerInput and returns the reads that enclose the repeat along with the repeat
+// length measured in each. The region name must be formatted as described for
+// ParseRepeatSeq. enclosingReads is reused as the backing slice of the answer. No reads
+// are returned if ctx is cancelled before realignment finishes.
+func EnclosingReads(ctx context.Context, enclosingReads []*sam.Sam, opts Options, bamIdx sam.Bai, region bed.Bed, br *sam.BamReader, alignerInput chan<- sam.Sam, alignerOutput <-chan sam.Sam) ([]*sam.Sam, []int) {
+	var start, end int
+	var reads []sam.Sam
+	enclosingReads = resetEnclosingReads(enclosingReads, len(reads)) // starts at len == 0, cap >= len(reads)
+
+	// STEP 1: Find reads with initial alignment close to target as candidates for local realignment
+	start = region.ChromStart - opts.TargetPadding
+	end = region.ChromEnd + opts.TargetPadding
+	if start < 0 {
+		start = 0
+	}
+	reads = sam.SeekBamRegion(br, bamIdx, region.Chrom, uint32(start), uint32(end))
+	if len(reads) == 0 {
+		return enclosingReads, nil
+	}
+
+	// STEP 2: Realign reads to target region
+	realignReads(ctx, reads, opts.MinMapQ, alignerInput, alignerOutput) // read order in slice may change
+	if ctx.Err() != nil {
+		return enclosingReads, nil
+	}
+
+	// STEP 3: Determine which realigned reads overlap targets with the minimum flanking overlap
+	for i := range reads {
+		if opts.MinMapQ != -1 && reads[i].MapQ < uint8(opts.MinMapQ) {
+			continue
+		}
+		if sam.IsUnmapped(reads[i]) {
+			continue
+		}
+		if reads[i].GetChromStart() <= region.ChromStart-opts.MinFlankOverlap && reads[i].GetChromEnd() >= region.ChromEnd+opts.MinFlankOverlap {
+			enclosingReads = append(enclosingReads, &reads[i])
+		}
+	}
+
+	// STEP 4: Sort enclosing reads by position
+	sort.Slice(enclosingReads, func(i, j int) bool {
+		if enclosingReads[i].GetChromStart() < enclosingReads[j].GetChromStart() {
+			return true
+		}
+		if enclosingReads[i].GetChromEnd() < enclosingReads[j].GetChromEnd() {
+			return true
+		}
+		return true
+	})
+
+	// STEP 5: Remove duplicates
+	if opts.RemoveDups {
+		enclosingReads = dedup(enclosingReads)
+	}
+
+	// STEP 6: Genotype repeats
+	observedLengths := make([]int, len(enclosingReads))
+	repeatSeq, _ := ParseRepeatSeq(region.Name)
+	for i := range enclosingReads {
+		observedLengths[i] = RepeatLength(enclosingReads[i], region.ChromStart, region.ChromEnd, repeatSeq)
+		if opts.Debug > 2 {
+			fmt.Fprintln(os.Stderr, enclosingReads[i].QName, observedLengths[i], "start:", enclosingReads[i].Pos)
+		}
+	}
+	return enclosingReads, observedLengths
+}
+
+// RepeatLength returns the length in bp of the repeat of repeatSeq found in read
+// between the reference coordinates regionStart and regionEnd.
+func RepeatLength(read *sam.Sam, regionStart, regionEnd int, repeatSeq []dna.Base) int {
+	var readIdx, refIdx, i int
+	refIdx = int(read.Pos)
+
+	// get to start of region
+	for i = range read.Cigar {
+		if cigar.ConsumesReference(read.Cigar[i].Op) {
+			refIdx += read.Cigar[i].RunLength
+		}
+		if cigar.ConsumesQuery(read.Cigar[i].Op) {
+			readIdx += read.Cigar[i].RunLength
+		}
+		if refIdx >= regionStart {
+			break
+		}
+	}
+	if refIdx > regionStart {
+		if cigar.ConsumesQuery(read.Cigar[i].Op) {
+			readIdx -= refIdx - regionStart
+		}
+		refIdx -= refIdx - regionStart
+	}
+	readIdx++
+
+	var repeatIdx int
+	for repeatIdx = range repeatSeq {
+		if read.Seq[readIdx] == repeatSeq[repeatIdx] {
+			break
+		}
+	}
+
+	// move backwards to look for misaligned repeat sequence
+	for read.Seq[readIdx] == repeatSeq[repeatIdx] {
+		repeatIdx--
+		readIdx--
+		refIdx--
+		if repeatIdx == -1 {
+			repeatIdx = len(repeatSeq) - 1
+		}
+		if readIdx == -1 {
+			break
+		}
+	}
+	repeatIdx++
+	if repeatIdx == len(repeatSeq) {
+		repeatIdx = 0
+	}
+	readIdx++
+	refIdx++
+	return RepeatRun(read.Seq, readIdx, readIdx+regionEnd-refIdx, repeatSeq, repeatIdx) // TODO divide by repeat unit length???
+}
+
+// RepeatRun walks seq forward from seqIdx, where repeatSeq[repeatIdx] is expected, and
+// returns the length in bp of the longest repeat of repeatSeq found, as RepeatLength
+// does for the read bases in a region. A walk starting before end may extend past it.
+// After a mismatch the walk resumes at the next base found in repeatSeq, and a run
+// shorter than the longest so far is added to the next run.
+func RepeatRun(seq []dna.Base, seqIdx, end int, repeatSeq []dna.Base, repeatIdx int) int {
+	// move forwards to calc repeat length
+	var observedLength, maxLength int
+	for seqIdx < end && seqIdx < len(seq) {
+		// move through repeat until mismatch
+		for seq[seqIdx] == repeatSeq[repeatIdx] {
+			observedLength++
+			repeatIdx++
+			seqIdx++
+			if repeatIdx == len(repeatSeq) {
+				repeatIdx = 0
+			}
+			if seqIdx == len(seq) {
+				break
+			}
+		}
+		if observedLength > maxLength {
+			maxLength = observedLength
+			observedLength = 0
+		}
+		// move forward until you get a base matching the repeat
+		for seqIdx < len(seq) && seq[seqIdx] != repeatSeq[repeatIdx] {
+			for repeatIdx = 0; repeatIdx < len(repeatSeq); repeatIdx++ {
+				if seq[seqIdx] == repeatSeq[repeatIdx] {
+					break
+				}
+			}
+			if repeatIdx == len(repeatSeq) { // current base does not match any base in repeat sequence
+				repeatIdx = 0
+				seqIdx++
+			}
+		}
+	}
+	return maxLength
+}
+
+// ParseRepeatSeq parses a target name formatted as NxUNIT (e.g. 10xCA) and returns
+// the repeat unit and the number of units in the reference.
+func ParseRepeatSeq(s string) ([]dna.Base, int) {
+	var words []string
+	if strings.Contains(s, "x") {
+		words = strings.Split(s, "x")
+	}
+	num, err := strconv.Atoi(words[0])
+	exception.PanicOnErr(err)
+	return dna.StringToBases(strings.Split(words[1], "_")[0]), num
+}
+
+func dedup(reads []*sam.Sam) []*sam.Sam {
+	for i := 1; i < len(reads); i++ {
+		if reads[i].GetChromStart() == reads[i-1].GetChromStart() && reads[i].GetChromEnd() == reads[i-1].GetChromEnd() {
+			slices.Delete(reads, i, i+1)
+		}
+	}
+	return reads
+}
+
+// read order may change
+func realignReads(ctx context.Context, reads []sam.Sam, minMapQ int, alignerInput chan<- sam.Sam, alignerOutput <-chan sam.Sam) {
+	var readsSkipped, readsReceived int
+
+	// count how many reads will be skipped over for realignment
+	for i := range reads {
+		if minMapQ != -1 && reads[i].MapQ < uint8(minMapQ) {
+			readsSkipped++
+		}
+	}
+
+	// start streaming reads to aligner
+	go sendReads(ctx, reads, minMapQ, alignerInput)
+
+	// start receiving aligned reads
+	var read sam.Sam
+	for {
+		select {
+		case read = <-alignerOutput:
+		case <-ctx.Done():
+			return
+		}
+		reads[readsReceived] = read
+		readsReceived++
+
+		// break when all reads sent for alignment have been received
+		if readsReceived+readsSkipped == len(reads) {
+			reads = reads[0:readsReceived]
+			break
+		}
+	}
+}
+
+func sendReads(ctx context.Context, reads []sam.Sam, minMapQ int, alignerInput chan<- sam.Sam) {
+	for i := range reads {
+		if minMapQ != -1 && reads[i].MapQ < uint8(minMapQ) {
+			continue
+		}
+		select {
+		case alignerInput <- reads[i]:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func resetEnclosingReads(s []*sam.Sam, len int) []*sam.Sam {
+	if cap(s) >= len {
+		for i := range s {
+			s[i] = nil
+		}
+		s = s[:0]
+	} else {
+		s = make([]*sam.Sam, 0, len)
+	}
+	return s
+}
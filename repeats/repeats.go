@@ -2,14 +2,14 @@ package repeats
 
 import (
 	"fmt"
+	"github.com/dasnellings/duplexTools/fai"
 	"github.com/vertgenlab/gonomics/bed"
 	"github.com/vertgenlab/gonomics/dna"
 	"github.com/vertgenlab/gonomics/exception"
-	"github.com/vertgenlab/gonomics/fasta"
 )
 
-func FindPerfectRepeat(reference *fasta.Seeker, r *Record) (bed.Bed, int, []dna.Base) {
-	refseq, err := fasta.SeekByName(reference, r.Chr, r.Start, r.End)
+func FindPerfectRepeat(reference *fai.Seeker, r *Record) (bed.Bed, int, []dna.Base) {
+	refseq, err := reference.SeekByName(r.Chr, r.Start, r.End)
 	exception.PanicOnErr(err)
 	dna.AllToUpper(refseq)
 
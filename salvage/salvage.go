@@ -0,0 +1,84 @@
+// Package salvage handles inputs that turn out to be truncated or corrupt part way
+// through. By default the command exits with exit.MalformedInput, naming the file and
+// the byte offset or record of the problem. Commands that add the -salvage option can
+// instead process everything before the problem and end their outputs with a
+// #TRUNCATED line, which mcsMerge rejects unless -allowTruncated is given.
+package salvage
+
+import (
+	"flag"
+	"fmt"
+	"github.com/dasnellings/duplexTools/exit"
+	"log"
+	"sync"
+)
+
+// Flag is the name of the option.
+const Flag = "salvage"
+
+var (
+	enabled    bool
+	registered bool // the command accepts -salvage
+	mu         sync.Mutex
+	reason     string // the first problem found, or ""
+	stopped    bool   // Stop was called
+)
+
+// AddFlag adds the -salvage option to fs.
+func AddFlag(fs *flag.FlagSet) {
+	registered = true
+	fs.BoolVar(&enabled, Flag, enabled, "If an input is truncated or corrupt, process the data before the problem and end the outputs with a #TRUNCATED line instead of exiting.")
+}
+
+// Enabled reports whether -salvage was given.
+func Enabled() bool {
+	return enabled
+}
+
+// Stop reports a truncated or corrupt input found while reading it, described by the
+// arguments in the manner of fmt.Printf. Without -salvage it exits with
+// exit.MalformedInput. With -salvage it logs a warning and returns, and the caller must
+// stop reading the input.
+func Stop(format string, args ...any) {
+	report(true, format, args...)
+}
+
+// Warn is Stop for a problem found ahead of reading, e.g. a bam without an end of file
+// block. With -salvage, reading continues until the problem is reached.
+func Warn(format string, args ...any) {
+	report(false, format, args...)
+}
+
+func report(stop bool, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if !enabled {
+		if registered {
+			msg += " Rerun with -salvage to process the data before this point."
+		}
+		exit.Fatalf(exit.MalformedInput, "%s", msg)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	stopped = stopped || stop
+	if reason == "" {
+		reason = msg
+		log.Printf("WARNING: %s Processing the data before this point. Outputs will end with a #TRUNCATED line.", msg)
+	}
+}
+
+// Stopped reports whether Stop was called, i.e. whether reading should stop.
+func Stopped() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return stopped
+}
+
+// Marker returns the line that ends partial outputs, or "" if no problem was reported.
+func Marker() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if reason == "" {
+		return ""
+	}
+	return "#TRUNCATED: an input is truncated or corrupt, so only the data before the problem was processed: " + reason
+}
@@ -0,0 +1,76 @@
+// Package sbs classifies single base substitutions into the 96 pyrimidine-centered
+// trinucleotide classes (SBS96) used for mutational spectra and signatures.
+package sbs
+
+import (
+	"fmt"
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/vertgenlab/gonomics/dna"
+	"github.com/vertgenlab/gonomics/vcf"
+	"log"
+)
+
+// Types are the SBS96 classes in the conventional order (A[C>A]A, A[C>A]C, ...).
+var Types = makeTypes()
+
+var typeIdx = makeTypeIdx()
+
+func makeTypes() []string {
+	var ans []string
+	bases := []string{"A", "C", "G", "T"}
+	for _, sub := range []string{"C>A", "C>G", "C>T", "T>A", "T>C", "T>G"} {
+		for _, five := range bases {
+			for _, three := range bases {
+				ans = append(ans, fmt.Sprintf("%s[%s]%s", five, sub, three))
+			}
+		}
+	}
+	return ans
+}
+
+func makeTypeIdx() map[string]int {
+	m := make(map[string]int, len(Types))
+	for i := range Types {
+		m[Types[i]] = i
+	}
+	return m
+}
+
+// Index returns the position of class in Types.
+func Index(class string) (int, bool) {
+	i, found := typeIdx[class]
+	return i, found
+}
+
+// Class returns the SBS96 class of ALT allele a of v (e.g. A[C>T]G), or an empty string
+// if the allele is not an SNV or its trinucleotide context in ref is undefined.
+func Class(v vcf.Vcf, a int, ref *fai.Seeker) string {
+	if len(v.Ref) != 1 || len(v.Alt[a]) != 1 || v.Pos < 2 {
+		return ""
+	}
+	refBase := dna.ToUpper(dna.StringToBase(v.Ref))
+	altBase := dna.ToUpper(dna.StringToBase(v.Alt[a]))
+	if !dna.DefineBase(refBase) || !dna.DefineBase(altBase) || refBase == altBase {
+		return ""
+	}
+	seq, err := ref.SeekByName(v.Chr, v.Pos-2, v.Pos+1)
+	if err != nil || len(seq) != 3 {
+		return ""
+	}
+	dna.AllToUpper(seq)
+	for i := range seq {
+		if !dna.DefineBase(seq[i]) {
+			return ""
+		}
+	}
+	if refBase == dna.A || refBase == dna.G {
+		refBase = dna.ComplementSingleBase(refBase)
+		altBase = dna.ComplementSingleBase(altBase)
+		dna.ReverseComplement(seq)
+	}
+	if seq[1] != refBase {
+		log.Printf("WARNING: reference base does not match vcf at %s:%d\n", v.Chr, v.Pos)
+		return ""
+	}
+	return fmt.Sprintf("%s[%s>%s]%s", dna.BaseToString(seq[0]), dna.BaseToString(refBase), dna.BaseToString(altBase), dna.BaseToString(seq[2]))
+}
@@ -0,0 +1,47 @@
+package sbs
+
+import (
+	"github.com/dasnellings/duplexTools/fai"
+	"github.com/vertgenlab/gonomics/vcf"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClass(t *testing.T) {
+	ref := filepath.Join(t.TempDir(), "ref.fa")
+	if err := os.WriteFile(ref, []byte(">chr1\nACGTNAGCt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(ref+".fai", []byte("chr1\t9\t6\t9\t10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	seeker := fai.NewSeeker(ref)
+	defer seeker.Close()
+
+	tests := []struct {
+		pos      int
+		ref, alt string
+		expected string
+	}{
+		{2, "C", "T", "A[C>T]G"},
+		{3, "G", "A", "A[C>T]G"}, // purines are reported on the other strand
+		{8, "C", "A", "G[C>A]T"}, // soft-masked context
+		{1, "A", "G", ""},        // no 5' base
+		{4, "T", "C", ""},        // N in context
+		{2, "C", "CT", ""},       // not an SNV
+	}
+	for _, test := range tests {
+		v := vcf.Vcf{Chr: "chr1", Pos: test.pos, Ref: test.ref, Alt: []string{test.alt}}
+		if got := Class(v, 0, seeker); got != test.expected {
+			t.Errorf("%d %s>%s: expected %q, got %q", test.pos, test.ref, test.alt, test.expected, got)
+		}
+	}
+
+	if len(Types) != 96 || Types[0] != "A[C>A]A" || Types[95] != "T[T>G]T" {
+		t.Errorf("unexpected classes %v", Types)
+	}
+	if i, found := Index("A[C>T]G"); !found || Types[i] != "A[C>T]G" {
+		t.Errorf("Index returned %d %v", i, found)
+	}
+}
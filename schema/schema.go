@@ -0,0 +1,131 @@
+// Package schema versions the JSON documents written by duplexTools (e.g. mcsQc -o
+// qc.json) so that systems such as a LIMS can ingest outputs from a mix of releases.
+//
+// Every document has a "schema" field naming its type and a "schemaVersion" field of
+// the form MAJOR.MINOR. Versions change by these rules:
+//
+//   - Adding a field increments MINOR. Readers must ignore fields they do not know.
+//   - Removing or renaming a field, or changing its type, units, or meaning, increments
+//     MAJOR. A field name is never reused for something else.
+//   - Fields that may be omitted stay optional in later minor versions.
+//
+// A reader for a MAJOR version can read every MINOR version of it. Documents written
+// before versioning have no schemaVersion and are read as 1.0. The JSON Schema of the
+// current version of each document is embedded and printed by `duplexTools schema`.
+package schema
+
+import (
+	"embed"
+	"encoding/json"
+	"fmt"
+	"github.com/dasnellings/duplexTools/provenance"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Version is the version of a document schema.
+type Version struct {
+	Major, Minor int
+}
+
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d", v.Major, v.Minor)
+}
+
+// current versions of each document
+var (
+	McsQc           = Version{1, 0} // mcsQc JSON output
+	Error           = Version{1, 0} // errors logged with DUPLEXTOOLS_ERROR_FORMAT=json
+	Manifest        = Version{1, 0} // run manifest written with -manifest
+	McsCallVariants = Version{1, 3} // run summary written by mcsCallVariants -summaryOut
+)
+
+// versions maps each document name to its current version and must list every
+// document with an embedded JSON Schema.
+var versions = map[string]Version{
+	"mcsQc":           McsQc,
+	"error":           Error,
+	"manifest":        Manifest,
+	"mcsCallVariants": McsCallVariants,
+}
+
+//go:embed *.schema.json
+var files embed.FS
+
+// Header identifies a document. Embed it in the struct that is marshalled so the
+// fields are written first.
+type Header struct {
+	Schema             string `json:"schema"`
+	SchemaVersion      string `json:"schemaVersion"`
+	DuplexToolsVersion string `json:"duplexToolsVersion,omitempty"`
+}
+
+// Fields are the names of the Header fields, for readers that treat the other fields
+// of a document as data.
+var Fields = []string{"schema", "schemaVersion", "duplexToolsVersion"}
+
+// NewHeader returns the Header of the current version of document name.
+func NewHeader(name string) Header {
+	v, found := versions[name]
+	if !found {
+		panic(fmt.Sprintf("schema: unknown document %s", name))
+	}
+	return Header{Schema: name, SchemaVersion: v.String(), DuplexToolsVersion: provenance.Version()}
+}
+
+// Parse parses a MAJOR.MINOR version.
+func Parse(s string) (Version, error) {
+	major, minor, found := strings.Cut(s, ".")
+	var v Version
+	var err error
+	if v.Major, err = strconv.Atoi(major); err != nil || !found {
+		return v, fmt.Errorf("schema version '%s' is not MAJOR.MINOR", s)
+	}
+	if v.Minor, err = strconv.Atoi(minor); err != nil {
+		return v, fmt.Errorf("schema version '%s' is not MAJOR.MINOR", s)
+	}
+	return v, nil
+}
+
+// Check returns an error if the JSON document data is not a document of the named
+// schema that can be read by this build, i.e. if it names another schema or has a
+// different major version. Documents without a schema field are accepted as 1.0.
+func Check(data []byte, name string) error {
+	var h Header
+	if err := json.Unmarshal(data, &h); err != nil {
+		return err
+	}
+	if h.Schema == "" && h.SchemaVersion == "" {
+		h.Schema, h.SchemaVersion = name, "1.0"
+	}
+	if h.Schema != name {
+		return fmt.Errorf("document is a %s, not a %s", h.Schema, name)
+	}
+	v, err := Parse(h.SchemaVersion)
+	if err != nil {
+		return err
+	}
+	if current := versions[name]; v.Major != current.Major {
+		return fmt.Errorf("%s schema version %s is not compatible with version %s read by duplexTools %s", name, v, current, provenance.Version())
+	}
+	return nil
+}
+
+// Names returns the names of the documents with a schema.
+func Names() []string {
+	ans := make([]string, 0, len(versions))
+	for name := range versions {
+		ans = append(ans, name)
+	}
+	sort.Strings(ans)
+	return ans
+}
+
+// JsonSchema returns the JSON Schema of the current version of document name.
+func JsonSchema(name string) ([]byte, error) {
+	if _, found := versions[name]; !found {
+		return nil, fmt.Errorf("no schema named %s. Schemas: %s", name, strings.Join(Names(), ", "))
+	}
+	return files.ReadFile(name + ".schema.json")
+}
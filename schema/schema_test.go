@@ -0,0 +1,48 @@
+package schema
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		data string
+		ok   bool
+	}{
+		{`{"sample": "a"}`, true}, // written before versioning
+		{`{"schema": "mcsQc", "schemaVersion": "1.0"}`, true},
+		{`{"schema": "mcsQc", "schemaVersion": "1.7", "newField": 3}`, true},
+		{`{"schema": "mcsQc", "schemaVersion": "2.0"}`, false},
+		{`{"schema": "error", "schemaVersion": "1.0"}`, false},
+		{`{"schema": "mcsQc", "schemaVersion": "one"}`, false},
+		{`[1, 2]`, false},
+	}
+	for _, test := range tests {
+		if err := Check([]byte(test.data), "mcsQc"); (err == nil) != test.ok {
+			t.Errorf("%s: expected ok=%v, got error %v", test.data, test.ok, err)
+		}
+	}
+}
+
+func TestJsonSchema(t *testing.T) {
+	for _, name := range Names() {
+		b, err := JsonSchema(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var s struct {
+			Title      string
+			Properties map[string]json.RawMessage
+		}
+		if err = json.Unmarshal(b, &s); err != nil {
+			t.Errorf("%s: schema is not valid json: %s", name, err)
+		}
+		if s.Title != name || s.Properties["schemaVersion"] == nil {
+			t.Errorf("%s: schema has title %s and no schemaVersion property", name, s.Title)
+		}
+	}
+	if _, err := JsonSchema("missing"); err == nil {
+		t.Error("expected an error for an unknown schema")
+	}
+}
@@ -0,0 +1,66 @@
+// Package shard splits the read families or targets of a run across cluster jobs.
+// Items are dealt to shards in turn, so shard i of n processes items i-1, i-1+n,
+// i-1+2n, ... and every shard gets the same share of the work regardless of how the
+// items are distributed across the genome.
+package shard
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Shard is the 1-based Index of a job out of Count jobs. The zero value processes
+// every item and satisfies flag.Value for use with flag.Var.
+type Shard struct {
+	Index, Count int
+}
+
+// Parse parses a shard given as "i/n", e.g. "3/100".
+func Parse(s string) (Shard, error) {
+	i, n, found := strings.Cut(s, "/")
+	index, err1 := strconv.Atoi(i)
+	count, err2 := strconv.Atoi(n)
+	if !found || err1 != nil || err2 != nil || count < 1 || index < 1 || index > count {
+		return Shard{}, fmt.Errorf("shard must be given as i/n with 1 <= i <= n, found %q", s)
+	}
+	return Shard{Index: index, Count: count}, nil
+}
+
+// Set parses s into the Shard to satisfy the flag.Value interface.
+func (s *Shard) Set(value string) error {
+	var err error
+	*s, err = Parse(value)
+	return err
+}
+
+// String returns the shard as "i/n", or an empty string if unset.
+func (s *Shard) String() string {
+	if !s.IsSet() {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.Index, s.Count)
+}
+
+// IsSet returns true if the work is split across more than one shard.
+func (s Shard) IsSet() bool {
+	return s.Count > 1
+}
+
+// Keep returns true if the item at 0-based position k of the input is processed by s.
+func (s Shard) Keep(k int) bool {
+	if !s.IsSet() {
+		return true
+	}
+	return k%s.Count == s.Index-1
+}
+
+// Suffix returns a string to add to the names of intermediate files so that shards
+// running in the same directory do not overwrite each other (e.g. ".shard3of100").
+// It is empty if s is not set.
+func (s Shard) Suffix() string {
+	if !s.IsSet() {
+		return ""
+	}
+	return fmt.Sprintf(".shard%dof%d", s.Index, s.Count)
+}
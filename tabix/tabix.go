@@ -0,0 +1,257 @@
+// Package tabix provides random access to bgzip compressed, tabix indexed text files such as vcf.gz.
+package tabix
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/dasnellings/duplexTools/exit"
+	"github.com/dasnellings/duplexTools/pipe"
+	"github.com/vertgenlab/gonomics/exception"
+	"io"
+	"log"
+	"os"
+	"strings"
+)
+
+// metaBin is the pseudo-bin used by samtools/htslib to store per-reference metadata.
+const metaBin = 37450
+
+// linearShift is the log2 size of the windows in the linear index.
+const linearShift = 14
+
+// Index stores the virtual file offsets for each reference in a tabix index.
+type Index struct {
+	Format  int32 // 0 generic, 1 SAM, 2 VCF
+	ColSeq  int32 // 1-based column of the sequence name
+	ColBeg  int32 // 1-based column of the start position
+	ColEnd  int32 // 1-based column of the end position, 0 if not present
+	Meta    byte  // lines starting with this character are skipped
+	Skip    int32 // number of header lines to skip
+	names   []string
+	nameMap map[string]int
+	refs    []refIndex
+}
+
+// refIndex is the binning and linear index for a single reference sequence.
+type refIndex struct {
+	bins   map[uint32][]chunk
+	linear []uint64
+}
+
+// chunk is a range of virtual file offsets.
+type chunk struct {
+	beg uint64
+	end uint64
+}
+
+// ReadIndex reads a tabix index (.tbi) file. It exits with exit.MissingIndex if
+// filename does not exist.
+func ReadIndex(filename string) Index {
+	pipe.RequireIndexable(strings.TrimSuffix(strings.TrimSuffix(filename, ".tbi"), ".csi"), "file")
+	file, err := os.Open(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		exit.Fatalf(exit.MissingIndex, "could not find tabix index %s. Compress the file with bgzip and index it with tabix.", filename)
+	}
+	exception.PanicOnErr(err)
+	zr, err := gzip.NewReader(bufio.NewReader(file))
+	exception.PanicOnErr(err)
+	r := bufio.NewReader(zr)
+
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(r, magic)
+	exception.PanicOnErr(err)
+	if string(magic) != "TBI\x01" {
+		exit.Fatalf(exit.MalformedInput, "%s is not a tabix index.", filename)
+	}
+
+	var ans Index
+	var nRef, meta, nameLen int32
+	for _, v := range []*int32{&nRef, &ans.Format, &ans.ColSeq, &ans.ColBeg, &ans.ColEnd, &meta, &ans.Skip, &nameLen} {
+		readLe(r, v)
+	}
+	ans.Format &= 0xFFFF // upper bits are flags (e.g. 0x10000 for UCSC style zero-based coordinates)
+	ans.Meta = byte(meta)
+
+	names := make([]byte, nameLen)
+	_, err = io.ReadFull(r, names)
+	exception.PanicOnErr(err)
+	ans.names = strings.Split(strings.TrimRight(string(names), "\x00"), "\x00")
+	if len(ans.names) != int(nRef) {
+		exit.Fatalf(exit.MalformedInput, "malformed tabix index %s. Expected %d sequence names, found %d.", filename, nRef, len(ans.names))
+	}
+	ans.nameMap = make(map[string]int, nRef)
+	for i := range ans.names {
+		ans.nameMap[ans.names[i]] = i
+	}
+
+	ans.refs = make([]refIndex, nRef)
+	var nBin, nChunk, nIntv int32
+	var bin uint32
+	for i := range ans.refs {
+		readLe(r, &nBin)
+		ans.refs[i].bins = make(map[uint32][]chunk, nBin)
+		for j := int32(0); j < nBin; j++ {
+			readLe(r, &bin)
+			readLe(r, &nChunk)
+			chunks := make([]chunk, nChunk)
+			for k := range chunks {
+				readLe(r, &chunks[k].beg)
+				readLe(r, &chunks[k].end)
+			}
+			if bin != metaBin {
+				ans.refs[i].bins[bin] = chunks
+			}
+		}
+		readLe(r, &nIntv)
+		ans.refs[i].linear = make([]uint64, nIntv)
+		for j := range ans.refs[i].linear {
+			readLe(r, &ans.refs[i].linear[j])
+		}
+	}
+
+	err = zr.Close()
+	exception.PanicOnErr(err)
+	err = file.Close()
+	exception.PanicOnErr(err)
+	return ans
+}
+
+func readLe(r io.Reader, data any) {
+	err := binary.Read(r, binary.LittleEndian, data)
+	exception.PanicOnErr(err)
+}
+
+// Names returns the name of each sequence in the order they appear in the index.
+func (idx Index) Names() []string {
+	return idx.names
+}
+
+// HasSeq returns true if the index contains the sequence name.
+func (idx Index) HasSeq(name string) bool {
+	_, found := idx.nameMap[name]
+	return found
+}
+
+// Offset returns the virtual file offset to start reading from to find all records overlapping [start, end) on
+// a sequence (0-based, half-open). Returns false if no records may overlap the region.
+func (idx Index) Offset(name string, start, end int) (uint64, bool) {
+	i, found := idx.nameMap[name]
+	if !found {
+		return 0, false
+	}
+	ref := idx.refs[i]
+
+	var minLinear uint64
+	if len(ref.linear) > 0 {
+		w := start >> linearShift
+		if w >= len(ref.linear) {
+			w = len(ref.linear) - 1
+		}
+		minLinear = ref.linear[w]
+	}
+
+	var ans uint64
+	found = false
+	for _, bin := range regionToBins(start, end) {
+		for _, c := range ref.bins[bin] {
+			if c.end <= minLinear {
+				continue
+			}
+			if !found || c.beg < ans {
+				ans = c.beg
+				found = true
+			}
+		}
+	}
+	if found && ans < minLinear {
+		ans = minLinear
+	}
+	return ans, found
+}
+
+// regionToBins returns all bins that may contain records overlapping [start, end).
+func regionToBins(start, end int) []uint32 {
+	if end <= start {
+		end = start + 1
+	}
+	end--
+	ans := []uint32{0}
+	for _, level := range []struct {
+		offset int
+		shift  uint
+	}{{1, 26}, {9, 23}, {73, 20}, {585, 17}, {4681, 14}} {
+		for k := level.offset + (start >> level.shift); k <= level.offset+(end>>level.shift); k++ {
+			ans = append(ans, uint32(k))
+		}
+	}
+	return ans
+}
+
+// Reader reads lines from a bgzip compressed file starting from a virtual offset.
+type Reader struct {
+	file *os.File
+	zr   *gzip.Reader
+	r    *bufio.Reader
+}
+
+// NewReader opens a bgzip compressed file for random access.
+func NewReader(filename string) *Reader {
+	file, err := os.Open(filename)
+	exception.PanicOnErr(err)
+	return &Reader{file: file}
+}
+
+// Seek moves the reader to a virtual file offset from the index.
+func (r *Reader) Seek(virtualOffset uint64) {
+	_, err := r.file.Seek(int64(virtualOffset>>16), io.SeekStart)
+	exception.PanicOnErr(err)
+	br := bufio.NewReader(r.file)
+	if r.zr == nil {
+		r.zr, err = gzip.NewReader(br)
+	} else {
+		err = r.zr.Reset(br)
+	}
+	exception.PanicOnErr(err)
+	r.r = bufio.NewReaderSize(r.zr, 1<<16)
+	_, err = r.r.Discard(int(virtualOffset & 0xFFFF))
+	exception.PanicOnErr(err)
+}
+
+// NextLine returns the next line without the trailing newline. Returns false at the end of the file.
+func (r *Reader) NextLine() (string, bool) {
+	if r.r == nil {
+		log.Panic("ERROR: tabix Reader must Seek before reading.")
+	}
+	line, err := r.r.ReadString('\n')
+	if err == io.EOF {
+		if line == "" {
+			return "", false
+		}
+		return strings.TrimRight(line, "\r"), true
+	}
+	exception.PanicOnErr(err)
+	return strings.TrimRight(line, "\r\n"), true
+}
+
+// Close closes the underlying file.
+func (r *Reader) Close() error {
+	if r.zr != nil {
+		err := r.zr.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return r.file.Close()
+}
+
+// String method for Index enables easy printing with the fmt package.
+func (idx Index) String() string {
+	ans := new(strings.Builder)
+	for i := range idx.names {
+		ans.WriteString(fmt.Sprintf("%s\t%d bins\t%d windows\n", idx.names[i], len(idx.refs[i].bins), len(idx.refs[i].linear)))
+	}
+	return ans.String()
+}
@@ -0,0 +1,354 @@
+package tabix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dasnellings/duplexTools/bgzf"
+	"github.com/vertgenlab/gonomics/exception"
+	"github.com/vertgenlab/gonomics/fileio"
+)
+
+// csiDepth is the number of binning levels used while building an index. With
+// linearShift it covers positions up to 2^32. Indexes of files with no record past
+// tbiMaxPos are converted to the 5 level tabix scheme when written.
+const csiDepth = 6
+
+// tbiMaxPos is the largest position that can be stored in a .tbi index.
+const tbiMaxPos = 1 << 29
+
+// noOffset marks windows of the linear index that no record overlaps yet.
+const noOffset = ^uint64(0)
+
+// indexedSuffixes are the file extensions that Create writes as bgzip compressed, indexed files.
+var indexedSuffixes = []string{".vcf.gz", ".vcf.bgz", ".bed.gz", ".bed.bgz", ".bedgraph.gz", ".bedgraph.bgz"}
+
+// Create opens filename for writing. Files ending in .vcf.gz, .bed.gz, or .bedGraph.gz
+// (or .bgz) are written with a Writer so that a tabix index is created next to them on
+// Close. Any other filename is opened with fileio.EasyCreate.
+func Create(filename string) io.WriteCloser {
+	if Indexed(filename) {
+		return NewWriter(filename)
+	}
+	return fileio.EasyCreate(filename)
+}
+
+// Indexed reports whether Create writes filename as a bgzip compressed, indexed file.
+func Indexed(filename string) bool {
+	lower := strings.ToLower(filename)
+	for _, suffix := range indexedSuffixes {
+		if strings.HasSuffix(lower, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Writer bgzip compresses VCF or BED lines and indexes the records as they are written.
+// The index is written to filename.tbi on Close, or to filename.csi if a record ends
+// past the 2^29 limit of the tabix format. Records must be sorted by position with each
+// sequence in one contiguous run; if they are not the index is skipped with a warning.
+type Writer struct {
+	filename  string
+	file      *os.File
+	bgzf      *bgzf.Writer
+	line      []byte
+	lineStart uint64 // position of the start of line from bgzf.Writer.Offset
+	idx       Index
+	lastBeg   int
+	maxEnd    int
+	ok        bool // false once a record cannot be indexed
+}
+
+// NewWriter creates filename and returns a Writer that indexes its records. The
+// format is VCF if filename contains ".vcf", otherwise BED.
+func NewWriter(filename string) *Writer {
+	file, err := os.Create(filename)
+	exception.PanicOnErr(err)
+	w := &Writer{filename: filename, file: file, bgzf: bgzf.NewWriter(file), ok: true}
+	w.idx = Index{Format: 0, ColSeq: 1, ColBeg: 2, ColEnd: 3, Meta: '#', nameMap: make(map[string]int)}
+	if strings.Contains(strings.ToLower(filename), ".vcf") {
+		w.idx.Format, w.idx.ColEnd = 2, 0
+	}
+	return w
+}
+
+// Write compresses p and indexes every line completed by it.
+func (w *Writer) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if len(w.line) == 0 {
+			w.lineStart = w.bgzf.Offset()
+		}
+		end := bytes.IndexByte(p, '\n') + 1
+		if end == 0 {
+			end = len(p)
+		}
+		w.line = append(w.line, p[:end]...)
+		_, err := w.bgzf.Write(p[:end])
+		if err != nil {
+			return n - len(p), err
+		}
+		if p[end-1] == '\n' {
+			w.addLine(w.line[:len(w.line)-1], w.lineStart, w.bgzf.Offset())
+			w.line = w.line[:0]
+		}
+		p = p[end:]
+	}
+	return n, nil
+}
+
+// addLine adds a record spanning [vBeg, vEnd) to the index. Offsets are positions from
+// bgzf.Writer.Offset until they are converted to virtual offsets by Close.
+func (w *Writer) addLine(line []byte, vBeg, vEnd uint64) {
+	if !w.ok || len(line) == 0 || line[0] == w.idx.Meta || bytes.HasPrefix(line, []byte("track")) || bytes.HasPrefix(line, []byte("browser")) {
+		return
+	}
+	seq, beg, end, err := w.parse(string(line))
+	if err != nil {
+		w.skipIndex("could not parse %q", line)
+		return
+	}
+	if end > 1<<32 {
+		w.skipIndex("%s:%d is past the largest position that can be indexed", seq, end)
+		return
+	}
+
+	i, found := w.idx.nameMap[seq]
+	switch {
+	case !found:
+		i = len(w.idx.names)
+		w.idx.nameMap[seq] = i
+		w.idx.names = append(w.idx.names, seq)
+		w.idx.refs = append(w.idx.refs, refIndex{bins: make(map[uint32][]chunk)})
+	case i != len(w.idx.names)-1 || beg < w.lastBeg:
+		w.skipIndex("records are not sorted at %s:%d", seq, beg+1)
+		return
+	}
+	w.lastBeg = beg
+	if end > w.maxEnd {
+		w.maxEnd = end
+	}
+
+	ref := &w.idx.refs[i]
+	bin := regionToBin(beg, end, csiDepth)
+	chunks := ref.bins[bin]
+	if len(chunks) > 0 && chunks[len(chunks)-1].end == vBeg {
+		chunks[len(chunks)-1].end = vEnd
+	} else {
+		ref.bins[bin] = append(chunks, chunk{beg: vBeg, end: vEnd})
+	}
+	for win := beg >> linearShift; win <= (end-1)>>linearShift; win++ {
+		for len(ref.linear) <= win {
+			ref.linear = append(ref.linear, noOffset)
+		}
+		if ref.linear[win] == noOffset {
+			ref.linear[win] = vBeg
+		}
+	}
+}
+
+// parse returns the sequence and 0-based, half-open interval of a record.
+func (w *Writer) parse(line string) (seq string, beg, end int, err error) {
+	fields := strings.SplitN(line, "\t", 9)
+	if len(fields) < 3 || (w.idx.Format == 2 && len(fields) < 4) {
+		return "", 0, 0, strconv.ErrSyntax
+	}
+	seq = fields[0]
+	beg, err = strconv.Atoi(fields[1])
+	if err != nil {
+		return
+	}
+	if w.idx.Format != 2 {
+		end, err = strconv.Atoi(fields[2])
+		if end <= beg {
+			end = beg + 1
+		}
+		return
+	}
+	beg-- // vcf is 1-based
+	end = beg + len(fields[3])
+	if len(fields) >= 8 { // symbolic alleles give their length with END in INFO
+		for _, info := range strings.Split(fields[7], ";") {
+			if v, found := strings.CutPrefix(info, "END="); found {
+				if e, convErr := strconv.Atoi(v); convErr == nil && e > end {
+					end = e
+				}
+			}
+		}
+	}
+	return
+}
+
+func (w *Writer) skipIndex(format string, args ...any) {
+	log.Printf("WARNING: not indexing %s: "+format, append([]any{w.filename}, args...)...)
+	w.ok = false
+}
+
+// Close writes any buffered data and the bgzf end of file marker, then writes the index.
+func (w *Writer) Close() error {
+	if len(w.line) > 0 { // last line without a newline
+		w.addLine(w.line, w.lineStart, w.bgzf.Offset())
+	}
+	err := w.bgzf.Close()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || !w.ok {
+		return err
+	}
+	w.idx.convertOffsets(w.bgzf.VirtualOffset)
+	if w.maxEnd > tbiMaxPos {
+		os.Remove(w.filename + ".tbi") // do not leave a stale index to be picked up instead
+		return w.idx.write(w.filename+".csi", true)
+	}
+	os.Remove(w.filename + ".csi")
+	return w.idx.write(w.filename+".tbi", false)
+}
+
+// convertOffsets applies virtualOffset to every offset in the index.
+func (idx Index) convertOffsets(virtualOffset func(uint64) uint64) {
+	for _, ref := range idx.refs {
+		for _, chunks := range ref.bins {
+			for i := range chunks {
+				chunks[i].beg, chunks[i].end = virtualOffset(chunks[i].beg), virtualOffset(chunks[i].end)
+			}
+		}
+		for i, v := range ref.linear {
+			if v != noOffset {
+				ref.linear[i] = virtualOffset(v)
+			}
+		}
+	}
+}
+
+// write writes the index in the tabix (.tbi) or csi format, bgzip compressed. The
+// bins in idx must use the csiDepth binning scheme.
+func (idx Index) write(filename string, csi bool) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	out := bgzf.NewWriter(file)
+
+	format := idx.Format
+	if format == 0 {
+		format |= 0x10000 // bed coordinates are zero-based
+	}
+	var names []byte
+	for _, name := range idx.names {
+		names = append(append(names, name...), 0)
+	}
+	header := []any{format, idx.ColSeq, idx.ColBeg, idx.ColEnd, int32(idx.Meta), idx.Skip, int32(len(names)), names}
+
+	if csi {
+		var aux bytes.Buffer
+		for _, v := range header {
+			writeLe(&aux, v)
+		}
+		writeLe(out, []byte("CSI\x01"))
+		writeLe(out, []any{int32(linearShift), int32(csiDepth), int32(aux.Len()), aux.Bytes(), int32(len(idx.refs))})
+	} else {
+		writeLe(out, []byte("TBI\x01"))
+		writeLe(out, int32(len(idx.refs)))
+		writeLe(out, header)
+	}
+
+	for _, ref := range idx.refs {
+		for i := range ref.linear { // empty windows start at the previous record
+			if ref.linear[i] != noOffset {
+				continue
+			}
+			ref.linear[i] = 0
+			if i > 0 {
+				ref.linear[i] = ref.linear[i-1]
+			}
+		}
+		bins := make([]uint32, 0, len(ref.bins))
+		for bin := range ref.bins {
+			bins = append(bins, bin)
+		}
+		sort.Slice(bins, func(i, j int) bool { return bins[i] < bins[j] })
+		writeLe(out, int32(len(bins)))
+		for _, bin := range bins {
+			chunks := ref.bins[bin]
+			if csi {
+				writeLe(out, []any{bin, binOffset(ref.linear, bin), int32(len(chunks))})
+			} else {
+				writeLe(out, []any{tbiBin(bin), int32(len(chunks))})
+			}
+			for _, c := range chunks {
+				writeLe(out, []uint64{c.beg, c.end})
+			}
+		}
+		if !csi {
+			writeLe(out, int32(len(ref.linear)))
+			writeLe(out, ref.linear)
+		}
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+func writeLe(w io.Writer, data any) {
+	if values, ok := data.([]any); ok {
+		for _, v := range values {
+			writeLe(w, v)
+		}
+		return
+	}
+	err := binary.Write(w, binary.LittleEndian, data)
+	exception.PanicOnErr(err)
+}
+
+// regionToBin returns the smallest bin containing [beg, end) in a binning scheme with depth levels.
+func regionToBin(beg, end, depth int) uint32 {
+	end--
+	shift := linearShift
+	offset := ((1 << (3 * depth)) - 1) / 7
+	for level := depth; level > 0; level-- {
+		if beg>>shift == end>>shift {
+			return uint32(offset + beg>>shift)
+		}
+		shift += 3
+		offset -= 1 << (3 * (level - 1))
+	}
+	return 0
+}
+
+// binLevel returns the level of bin and the offset of the first bin on that level.
+func binLevel(bin uint32) (level, offset int) {
+	for level, offset = 0, 0; offset+(1<<(3*level)) <= int(bin); level++ {
+		offset += 1 << (3 * level)
+	}
+	return level, offset
+}
+
+// tbiBin converts a bin from the csiDepth scheme to the 5 level tabix scheme, which has
+// the same bins without the top level.
+func tbiBin(bin uint32) uint32 {
+	level, offset := binLevel(bin)
+	if level == 0 {
+		return 0
+	}
+	return uint32(((1<<(3*(level-1)))-1)/7 + int(bin) - offset)
+}
+
+// binOffset returns the virtual offset of the first record overlapping the start of a csiDepth bin.
+func binOffset(linear []uint64, bin uint32) uint64 {
+	level, offset := binLevel(bin)
+	win := (int(bin) - offset) << (3 * (csiDepth - level))
+	if win >= len(linear) {
+		return 0
+	}
+	return linear[win]
+}
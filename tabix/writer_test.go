@@ -0,0 +1,100 @@
+package tabix
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.vcf.gz")
+	out := Create(filename)
+	var expected strings.Builder
+	expected.WriteString("##fileformat=VCFv4.2\n#CHROM\tPOS\tID\tREF\tALT\tQUAL\tFILTER\tINFO\n")
+	for _, chrom := range []string{"chr1", "chr2"} {
+		for pos := 1; pos < 2000000; pos += 97 {
+			fmt.Fprintf(&expected, "%s\t%d\t.\tACGT\tA\t.\tPASS\t.\n", chrom, pos)
+		}
+	}
+	// write in pieces that do not line up with lines
+	for s := expected.String(); len(s) > 0; {
+		n := 1000
+		if n > len(s) {
+			n = len(s)
+		}
+		io.WriteString(out, s[:n])
+		s = s[n:]
+	}
+	err := out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file, _ := os.Open(filename)
+	zr, err := gzip.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil || string(data) != expected.String() {
+		t.Errorf("decompressed file does not match input: %v", err)
+	}
+	file.Close()
+
+	idx := ReadIndex(filename + ".tbi")
+	if strings.Join(idx.Names(), ",") != "chr1,chr2" {
+		t.Errorf("wrong sequence names: %v", idx.Names())
+	}
+	r := NewReader(filename)
+	defer r.Close()
+	for _, region := range []struct {
+		chrom      string
+		start, end int
+	}{{"chr1", 0, 10}, {"chr1", 500000, 500100}, {"chr2", 1999000, 2000000}, {"chr2", 1234567, 1234570}} {
+		var found []int
+		offset, ok := idx.Offset(region.chrom, region.start, region.end)
+		if ok {
+			r.Seek(offset)
+			for line, more := r.NextLine(); more; line, more = r.NextLine() {
+				fields := strings.Split(line, "\t")
+				pos, _ := strconv.Atoi(fields[1])
+				if fields[0] != region.chrom || pos > region.end {
+					break
+				}
+				if pos+3 > region.start {
+					found = append(found, pos)
+				}
+			}
+		}
+		var want []int
+		for pos := 1; pos < 2000000; pos += 97 {
+			if pos+3 > region.start && pos <= region.end {
+				want = append(want, pos)
+			}
+		}
+		if fmt.Sprint(found) != fmt.Sprint(want) {
+			t.Errorf("%v: expected %v, found %v", region, want, found)
+		}
+	}
+}
+
+func TestWriterCsi(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.bed.gz")
+	out := Create(filename)
+	fmt.Fprintf(out, "chr1\t%d\t%d\n", 1<<30, 1<<30+10)
+	err := out.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(filename + ".csi"); err != nil {
+		t.Error("csi index was not written")
+	}
+	if _, err = os.Stat(filename + ".tbi"); err == nil {
+		t.Error("tbi index should not be written for positions past 2^29")
+	}
+}
@@ -0,0 +1,80 @@
+// Package tmp places the intermediate files of a command, such as the filtered family
+// bed of mcsCallVariants and downloaded remote inputs, in a directory unique to the run
+// that is removed when the command finishes. Intermediates are then never written
+// next to the inputs, which may be in a read-only directory.
+package tmp
+
+import (
+	"flag"
+	"github.com/dasnellings/duplexTools/provenance"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Flag is the name of the option that sets the parent directory of the run directory.
+const Flag = "tmpdir"
+
+var (
+	base string // parent of dir; os.TempDir if empty
+	mu   sync.Mutex
+	dir  string // created on first use
+)
+
+// AddFlag adds the -tmpdir option to fs.
+func AddFlag(fs *flag.FlagSet) {
+	fs.StringVar(&base, Flag, base, "Directory for intermediate files, which are removed when the command finishes. Defaults to $TMPDIR or /tmp.")
+}
+
+// FromArgs sets the parent directory from a -tmpdir option in args, for use before
+// flags are parsed.
+func FromArgs(args []string) {
+	for i, a := range args {
+		if a == "--" {
+			return
+		}
+		name, value, hasValue := strings.Cut(strings.TrimLeft(a, "-"), "=")
+		if !strings.HasPrefix(a, "-") || name != Flag {
+			continue
+		}
+		if !hasValue && i+1 < len(args) {
+			value = args[i+1]
+		}
+		base = value
+	}
+}
+
+// Dir returns the directory for intermediate files of this run, creating it on the
+// first call. Its name is unique, so concurrent runs never share intermediates.
+func Dir() string {
+	mu.Lock()
+	defer mu.Unlock()
+	if dir == "" {
+		d, err := os.MkdirTemp(base, "duplexTools-"+provenance.Command()+"-")
+		if err != nil {
+			log.Fatalf("ERROR: could not create a directory for intermediate files: %s. Set -%s to a writable directory.", err, Flag)
+		}
+		dir = d
+	}
+	return dir
+}
+
+// Path returns the path of an intermediate file named name in Dir.
+func Path(name string) string {
+	return filepath.Join(Dir(), filepath.Base(name))
+}
+
+// Cleanup removes Dir and everything in it.
+func Cleanup() {
+	mu.Lock()
+	defer mu.Unlock()
+	if dir == "" {
+		return
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		log.Printf("WARNING: could not remove intermediate files in %s: %s", dir, err)
+	}
+	dir = ""
+}
@@ -0,0 +1,33 @@
+package tmp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDir(t *testing.T) {
+	parent := t.TempDir()
+	FromArgs([]string{"-i", "in.bam", "-tmpdir", parent, "-o", "out.vcf"})
+	defer func() { base = "" }()
+
+	path := Path("/inputs/families.analysis.bed")
+	if filepath.Dir(filepath.Dir(path)) != parent || filepath.Base(path) != "families.analysis.bed" {
+		t.Errorf("expected families.analysis.bed in a new directory under %s, got %s", parent, path)
+	}
+	if err := os.WriteFile(path, []byte("chr1\t0\t10\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if Dir() != filepath.Dir(path) {
+		t.Error("Dir changed between calls")
+	}
+
+	Cleanup()
+	if _, err := os.Stat(filepath.Dir(path)); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", filepath.Dir(path))
+	}
+	if Dir() == filepath.Dir(path) {
+		t.Error("a new directory should be created after Cleanup")
+	}
+	Cleanup()
+}